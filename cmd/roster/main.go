@@ -1,9 +1,14 @@
 package main
 
 import (
+	_ "expvar"
+	"flag"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/meszmate/roster/internal/app"
@@ -12,33 +17,113 @@ import (
 )
 
 func main() {
+	demo := flag.Bool("demo", false, "run with a generated account, contacts and conversations (no network, nothing saved)")
+	deliver := flag.Bool("deliver", false, "only pass the xmpp: URI to the running roster, failing if none is running")
+	debugAddr := flag.String("debug-addr", "", "serve memory and chat history counters at http://<addr>/debug/vars (e.g. localhost:6060)")
+	profileCPU := flag.Bool("profile-cpu", false, "record a CPU profile to the profiles folder of the data directory")
+	profileMem := flag.Bool("profile-mem", false, "write a heap profile to the profiles folder of the data directory on exit")
+	traceRun := flag.Bool("trace", false, "record an execution trace to the profiles folder of the data directory")
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: %s [flags] [xmpp:uri]\n", os.Args[0])
+		fmt.Fprintf(out, "       %s register-uri-handler\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	uri := flag.Arg(0)
+	if uri == "register-uri-handler" {
+		note, err := app.RegisterURIHandler()
+		if err != nil {
+			log.Fatalf("Failed to register URI handler: %v", err)
+		}
+		fmt.Println(note)
+		return
+	}
+	if uri != "" {
+		// Opened as the handler of an xmpp: link
+		if !strings.HasPrefix(uri, "xmpp:") {
+			log.Fatalf("Unsupported argument %q: expected an xmpp: URI", uri)
+		}
+		// A running roster opens the link itself
+		if !*demo {
+			err := app.DeliverURI(uri)
+			if err == nil {
+				return
+			}
+			if *deliver {
+				log.Fatalf("Failed to deliver %s: %v", uri, err)
+			}
+		}
+	}
+
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	// Initialize application
-	application, err := app.New(cfg)
+	// Profiling covers startup too, so it begins before the app does
+	prof, err := startProfiling(cfg, *profileCPU, *profileMem, *traceRun)
 	if err != nil {
-		log.Fatalf("Failed to initialize app: %v", err)
+		log.Fatalf("Failed to start profiling: %v", err)
+	}
+	stopProfiling := func() {
+		for _, path := range prof.stop() {
+			fmt.Fprintf(os.Stderr, "Wrote %s\n", path)
+		}
+	}
+
+	// Initialize application
+	var application *app.App
+	if *demo {
+		application = app.NewDemo(cfg)
+	} else {
+		// An encrypted accounts file is unlocked on the terminal; once the
+		// UI runs, an expired passphrase is asked for in a dialog instead
+		config.SetPassphrasePrompt(promptPassphrase)
+		application, err = app.New(cfg)
+		config.SetPassphrasePrompt(nil)
+		if err != nil {
+			stopProfiling()
+			log.Fatalf("Failed to initialize app: %v", err)
+		}
 	}
 	defer application.Close()
 
+	// Expose expvar counters; bound before the UI takes over the terminal
+	if *debugAddr != "" {
+		ln, err := net.Listen("tcp", *debugAddr)
+		if err != nil {
+			stopProfiling()
+			log.Fatalf("Failed to listen on %s: %v", *debugAddr, err)
+		}
+		go func() { _ = http.Serve(ln, nil) }()
+	}
+
 	// Create root UI model
 	model := ui.NewModel(application)
+	if uri != "" {
+		model = model.WithStartupURI(uri)
+	}
 
 	// Create and run Bubble Tea program
-	p := tea.NewProgram(
-		model,
+	opts := []tea.ProgramOption{
 		tea.WithAltScreen(),
 		tea.WithMouseCellMotion(),
-	)
+		tea.WithReportFocus(),
+	}
+	if application.Config().UI.LowBandwidth {
+		opts = append(opts, tea.WithFPS(ui.LowBandwidthFPS))
+	}
+	p := tea.NewProgram(model, opts...)
 
 	// Store program reference for sending messages from other goroutines
 	application.SetProgram(p)
 
-	if _, err := p.Run(); err != nil {
+	_, err = p.Run()
+	stopProfiling()
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error running program: %v\n", err)
 		os.Exit(1)
 	}
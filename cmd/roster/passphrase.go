@@ -0,0 +1,24 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/charmbracelet/x/term"
+)
+
+// promptPassphrase asks for the master passphrase of an encrypted accounts
+// file on the terminal, before the UI takes it over
+func promptPassphrase() (string, error) {
+	if !term.IsTerminal(os.Stdin.Fd()) {
+		return "", errors.New("the accounts file is encrypted and stdin is not a terminal to ask for its passphrase")
+	}
+	fmt.Fprint(os.Stderr, "Passphrase for accounts.toml: ")
+	pass, err := term.ReadPassword(os.Stdin.Fd())
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return string(pass), nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"time"
+
+	"github.com/meszmate/roster/internal/config"
+)
+
+// profiler records the CPU profile and execution trace while roster runs
+// and writes a heap profile when it exits. The files go to the profiles
+// folder of the data directory, named by start time, for attaching to
+// issues.
+type profiler struct {
+	dir   string
+	stamp string
+	cpu   *os.File
+	trace *os.File
+	mem   bool
+	files []string
+}
+
+// startProfiling starts the requested outputs. The returned profiler is nil
+// when none were requested.
+func startProfiling(cfg *config.Config, cpu, mem, tracing bool) (*profiler, error) {
+	if !cpu && !mem && !tracing {
+		return nil, nil
+	}
+
+	dataDir := cfg.General.DataDir
+	if dataDir == "" {
+		paths, err := config.GetPaths()
+		if err != nil {
+			return nil, err
+		}
+		dataDir = paths.DataDir
+	}
+	p := &profiler{
+		dir:   filepath.Join(dataDir, "profiles"),
+		stamp: time.Now().Format("20060102-150405"),
+		mem:   mem,
+	}
+	if err := os.MkdirAll(p.dir, 0700); err != nil {
+		return nil, err
+	}
+
+	if cpu {
+		f, err := p.create("cpu", "pprof")
+		if err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to start CPU profile: %w", err)
+		}
+		p.cpu = f
+	}
+	if tracing {
+		f, err := p.create("trace", "out")
+		if err != nil {
+			p.stop()
+			return nil, err
+		}
+		if err := trace.Start(f); err != nil {
+			f.Close()
+			p.stop()
+			return nil, fmt.Errorf("failed to start trace: %w", err)
+		}
+		p.trace = f
+	}
+	return p, nil
+}
+
+func (p *profiler) create(kind, ext string) (*os.File, error) {
+	path := filepath.Join(p.dir, fmt.Sprintf("%s-%s.%s", kind, p.stamp, ext))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	p.files = append(p.files, path)
+	return f, nil
+}
+
+// stop flushes every output and returns the files written
+func (p *profiler) stop() []string {
+	if p == nil {
+		return nil
+	}
+	if p.cpu != nil {
+		pprof.StopCPUProfile()
+		p.cpu.Close()
+		p.cpu = nil
+	}
+	if p.trace != nil {
+		trace.Stop()
+		p.trace.Close()
+		p.trace = nil
+	}
+	if p.mem {
+		p.mem = false
+		if f, err := p.create("mem", "pprof"); err == nil {
+			// Up-to-date statistics of what is still live
+			runtime.GC()
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to write heap profile: %v\n", err)
+			}
+			f.Close()
+		} else {
+			fmt.Fprintf(os.Stderr, "Failed to write heap profile: %v\n", err)
+		}
+	}
+	return p.files
+}
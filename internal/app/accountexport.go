@@ -0,0 +1,41 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/meszmate/roster/internal/config"
+)
+
+const (
+	accountsExportFormat  = "roster-accounts"
+	accountsExportVersion = 1
+)
+
+// IsEncryptedAccountsExport reports whether data is an encrypted accounts
+// export, written by ExportAccountsEncrypted, rather than a plain one
+func IsEncryptedAccountsExport(data []byte) bool {
+	return config.IsSealed(data, accountsExportFormat)
+}
+
+// ExportAccountsEncrypted exports the accounts including their passwords,
+// sealed with the passphrase like an encrypted accounts file (scrypt and
+// AES-256-GCM). Importing it needs the same passphrase.
+func (a *App) ExportAccountsEncrypted(passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("a passphrase is required")
+	}
+	plain, err := json.Marshal(a.exportedAccounts(true))
+	if err != nil {
+		return nil, err
+	}
+	return config.SealWithPassphrase(accountsExportFormat, accountsExportVersion, passphrase, plain)
+}
+
+// decryptAccountsExport reverses ExportAccountsEncrypted
+func decryptAccountsExport(data []byte, passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("the export is encrypted, enter its passphrase")
+	}
+	return config.OpenWithPassphrase(data, accountsExportFormat, accountsExportVersion, passphrase)
+}
@@ -0,0 +1,100 @@
+package app
+
+import (
+	"time"
+
+	"github.com/meszmate/roster/internal/client"
+)
+
+// Each account has traffic statistics for diagnosing chatty servers or
+// plugins: the counts its clients keep in a shared client.TrafficStats,
+// how often it reconnected after losing the connection and how long the
+// current connection is up. They live for the session and are reset with
+// :stats reset.
+
+// AccountStats is a snapshot of an account's statistics
+type AccountStats struct {
+	client.Traffic
+	Reconnects  int
+	ConnectedAt time.Time // zero while not connected
+	Since       time.Time // when counting started or was last reset
+}
+
+// accountStats is what is kept per account
+type accountStats struct {
+	traffic     *client.TrafficStats
+	reconnects  int
+	lost        bool // the last connection dropped, so the next connect is a reconnect
+	connectedAt time.Time
+	since       time.Time
+}
+
+// accountStatsLocked returns the statistics of an account, creating them.
+// The caller holds a.mu for writing.
+func (a *App) accountStatsLocked(accountJID string) *accountStats {
+	if a.accountStats == nil {
+		a.accountStats = make(map[string]*accountStats)
+	}
+	s := a.accountStats[accountJID]
+	if s == nil {
+		s = &accountStats{traffic: &client.TrafficStats{}, since: time.Now()}
+		a.accountStats[accountJID] = s
+	}
+	return s
+}
+
+// trafficStats returns the counters a new client of the account shares
+func (a *App) trafficStats(accountJID string) *client.TrafficStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.accountStatsLocked(accountJID).traffic
+}
+
+// noteConnected records that an account connected
+func (a *App) noteConnected(accountJID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s := a.accountStatsLocked(accountJID)
+	if s.lost {
+		s.reconnects++
+	}
+	s.lost = false
+	s.connectedAt = time.Now()
+}
+
+// noteDisconnected records that an account's connection closed; lost is
+// set when it dropped rather than being closed by the user
+func (a *App) noteDisconnected(accountJID string, lost bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s := a.accountStatsLocked(accountJID)
+	s.connectedAt = time.Time{}
+	s.lost = lost
+}
+
+// GetAccountStats returns the statistics of an account
+func (a *App) GetAccountStats(accountJID string) AccountStats {
+	a.mu.Lock()
+	s := a.accountStatsLocked(accountJID)
+	stats := AccountStats{
+		Reconnects:  s.reconnects,
+		ConnectedAt: s.connectedAt,
+		Since:       s.since,
+	}
+	traffic := s.traffic
+	a.mu.Unlock()
+	stats.Traffic = traffic.Snapshot()
+	return stats
+}
+
+// ResetAccountStats starts counting an account's traffic and reconnects
+// from zero. The uptime of the current connection is not a count and stays.
+func (a *App) ResetAccountStats(accountJID string) {
+	a.mu.Lock()
+	s := a.accountStatsLocked(accountJID)
+	s.reconnects = 0
+	s.since = time.Now()
+	traffic := s.traffic
+	a.mu.Unlock()
+	traffic.Reset()
+}
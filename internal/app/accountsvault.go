@@ -0,0 +1,61 @@
+package app
+
+import (
+	"errors"
+
+	"github.com/meszmate/roster/internal/config"
+)
+
+// The accounts file may be kept encrypted with a master passphrase, see
+// config/vault.go. Once the passphrase cache expires, changes to accounts
+// stay in memory until the file is unlocked again.
+
+// saveAccounts writes the accounts file. When it is locked, the UI is asked
+// for the passphrase and the write is retried by UnlockAccounts. Callers run
+// inside the UI's Update and may hold a.mu, so the event is sent from a
+// goroutine rather than waiting for the UI to take it.
+func (a *App) saveAccounts() error {
+	err := config.SaveAccounts(a.accounts)
+	if errors.Is(err, config.ErrAccountsLocked) {
+		a.accountsUnsaved.Store(true)
+		go a.sendEvent(EventMsg{Type: EventAccountsLocked})
+	}
+	return err
+}
+
+// AccountsEncrypted reports whether the accounts file is encrypted
+func (a *App) AccountsEncrypted() bool {
+	return config.AccountsEncrypted()
+}
+
+// UnlockAccounts checks the master passphrase and writes the account
+// changes that were held back while the file was locked
+func (a *App) UnlockAccounts(passphrase string) error {
+	if err := config.UnlockAccounts(passphrase); err != nil {
+		return err
+	}
+	if !a.accountsUnsaved.Swap(false) {
+		return nil
+	}
+	return a.saveAccounts()
+}
+
+// EncryptAccounts encrypts the accounts file with a master passphrase, which
+// is then asked for at every start
+func (a *App) EncryptAccounts(passphrase string) error {
+	if err := config.EncryptAccounts(a.accounts, passphrase); err != nil {
+		return err
+	}
+	a.accountsUnsaved.Store(false)
+	return nil
+}
+
+// DecryptAccounts stores the accounts file in plain text again. It fails
+// with config.ErrAccountsLocked until the file is unlocked.
+func (a *App) DecryptAccounts() error {
+	if err := config.DecryptAccounts(a.accounts); err != nil {
+		return err
+	}
+	a.accountsUnsaved.Store(false)
+	return nil
+}
@@ -0,0 +1,82 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxAliasDepth limits how deeply aliases may refer to other aliases
+const maxAliasDepth = 10
+
+// aliasCommand is one command produced by expanding an alias
+type aliasCommand struct {
+	Name string
+	Args []string
+}
+
+// expandAlias resolves a command alias from the config into the commands it
+// runs. Commands in an alias are separated by &&, and arguments typed after
+// the alias are appended to its last command. Aliases may use other aliases,
+// but not themselves.
+func (a *App) expandAlias(name string, args []string) ([]aliasCommand, error) {
+	return a.expandAliasChain(name, args, []string{name})
+}
+
+func (a *App) expandAliasChain(name string, args []string, chain []string) ([]aliasCommand, error) {
+	if len(chain) > maxAliasDepth {
+		return nil, fmt.Errorf("alias %s nests too deeply", chain[0])
+	}
+
+	var parts [][]string
+	for _, part := range strings.Split(a.cfg.Aliases[name], "&&") {
+		if fields := strings.Fields(part); len(fields) > 0 {
+			parts = append(parts, fields)
+		}
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("alias %s is empty", name)
+	}
+	last := parts[len(parts)-1]
+	parts[len(parts)-1] = append(last[:len(last):len(last)], args...)
+
+	var commands []aliasCommand
+	for _, fields := range parts {
+		cmd, cmdArgs := fields[0], fields[1:]
+		if _, ok := a.cfg.Aliases[cmd]; !ok {
+			commands = append(commands, aliasCommand{Name: cmd, Args: cmdArgs})
+			continue
+		}
+		for _, seen := range chain {
+			if seen == cmd {
+				return nil, fmt.Errorf("alias loop: %s -> %s", strings.Join(chain, " -> "), cmd)
+			}
+		}
+		nested, err := a.expandAliasChain(cmd, cmdArgs, append(chain[:len(chain):len(chain)], cmd))
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, nested...)
+	}
+	return commands, nil
+}
+
+// executeAlias runs the commands an alias expands to, one after another
+func (a *App) executeAlias(name string, args []string) tea.Cmd {
+	commands, err := a.expandAlias(name, args)
+	if err != nil {
+		return func() tea.Msg {
+			return CommandActionMsg{
+				Action: ActionCommandError,
+				Data:   map[string]interface{}{"error": err.Error()},
+			}
+		}
+	}
+
+	cmds := make([]tea.Cmd, len(commands))
+	for i, c := range commands {
+		cmds[i] = a.ExecuteCommand(c.Name, c.Args)
+	}
+	return tea.Sequence(cmds...)
+}
@@ -3,13 +3,17 @@ package app
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -19,6 +23,10 @@ import (
 	"github.com/meszmate/roster/internal/ui/components/chat"
 	"github.com/meszmate/roster/internal/ui/components/dialogs"
 	"github.com/meszmate/roster/internal/ui/components/roster"
+	"github.com/meszmate/roster/internal/ui/theme"
+	"github.com/meszmate/roster/internal/ui/timefmt"
+	"github.com/meszmate/roster/pkg/plugin"
+	"github.com/meszmate/roster/pkg/plugin/api"
 	"github.com/meszmate/xmpp-go/jid"
 	"github.com/meszmate/xmpp-go/plugins/register"
 )
@@ -40,6 +48,21 @@ const (
 	EventTyping
 	EventMAMSyncing
 	EventReceipt
+	EventNewDevice
+	EventMessagesExpired
+	EventReadOnOtherDevice
+	EventOpenURI
+	EventConnectProgress
+	EventAccountsLocked
+	EventRosterConflict
+	EventStatusItemsChanged
+	EventCommandsChanged
+	EventStatusHook
+	EventVoiceRequest
+	EventRoomMessageDropped
+	EventMUCPresence
+	EventArchivePrefs
+	EventPluginNotice
 )
 
 // EventMsg represents an event from the app layer
@@ -74,17 +97,22 @@ type ChatMessage struct {
 	Status      MessageStatus
 	CorrectedID string
 	Reactions   map[string]string
+	JSON        string // XEP-0335 JSON container payload
+	FileURL     string // XEP-0066 out-of-band URL of a shared file
+	Warning     bool   // a system message shown as a security warning
 }
 
 // MessageStatusUpdateMsg is sent when a message status changes
 type MessageStatusUpdateMsg struct {
-	MessageID string
-	Status    MessageStatus
+	AccountJID string
+	MessageID  string
+	Status     MessageStatus
 }
 
 // SendMessageResultMsg is sent after attempting to send a message
 type SendMessageResultMsg struct {
 	Success   bool
+	Queued    bool // Kept in the outgoing queue to be sent later
 	MessageID string
 	To        string
 	Error     string
@@ -92,9 +120,18 @@ type SendMessageResultMsg struct {
 
 // PresenceUpdate represents a presence update
 type PresenceUpdate struct {
-	JID       string
-	Status    string
-	StatusMsg string
+	AccountJID string
+	JID        string
+	Status     string
+	StatusMsg  string
+}
+
+// TypingUpdate represents a chat state change (XEP-0085) from a contact
+type TypingUpdate struct {
+	AccountJID string
+	JID        string
+	State      string // active, composing, paused, inactive, gone
+	Typing     bool
 }
 
 // RosterLoadingUpdate represents roster loading state for an account.
@@ -118,7 +155,48 @@ const (
 	ActionWindowPrev
 	ActionSaveWindows
 	ActionLoadWindows
+	ActionRenameWindow
 	ActionShowRegister
+	ActionShowOMEMOExport
+	ActionShowOMEMOImport
+	ActionShowSecurityEvents
+	ActionShowSnippets
+	ActionShowQueue
+	ActionShowParticipants
+	ActionSetSound
+	ActionDeclineCall
+	ActionLinkPreview
+	ActionSettingChanged
+	ActionCommandError
+	ActionSetEncryption
+	ActionSetSaving
+	ActionSetExpiry
+	ActionRunDiagnostics
+	ActionCycleChatLayout
+	ActionBrowseRooms
+	ActionOpenURI
+	ActionShareLink
+	ActionSetRoomNotify
+	ActionSetRoomHistory
+	ActionExportChat
+	ActionBulk
+	ActionSoftwareVersion
+	ActionPing
+	ActionDatabaseInfo
+	ActionEncryptAccounts
+	ActionDecryptAccounts
+	ActionUnlockAccounts
+	ActionRosterConflicts
+	ActionPluginPermissions
+	ActionStatusItems
+	ActionContactRequests
+	ActionAnswerRequest
+	ActionRequestVoice
+	ActionAccountStats
+	ActionSearchHistory
+	ActionShowStarred
+	ActionArchivePrefs
+	ActionPrivacy
 )
 
 // CommandActionMsg is sent when a command needs UI interaction
@@ -129,9 +207,12 @@ type CommandActionMsg struct {
 
 // ConnectResultMsg is sent when a connection attempt completes
 type ConnectResultMsg struct {
-	Success bool
-	JID     string
-	Error   string
+	Success    bool
+	Cancelled  bool // the user aborted the attempt
+	Background bool // started by autoconnect or reconnect, not by the user
+	JID        string
+	Error      string
+	Err        error // the failure, for DescribeError
 }
 
 // ConnectingMsg is sent when connection is starting
@@ -146,6 +227,7 @@ type AddContactResultMsg struct {
 	JID        string
 	Name       string
 	Error      string
+	Queued     bool // the account is offline, the contact is added when it connects
 }
 
 // DisconnectResultMsg is sent when a disconnect operation completes
@@ -220,9 +302,14 @@ type App struct {
 	statusMsg      string
 	rosters        []roster.Roster
 	chatHistory    map[string][]chat.Message
+	historyUsed    map[string]uint64 // historyKey -> historyClock at last use
+	historyClock   uint64
+	historyLimits  map[string]historyLimit // historyKey -> saving and size, see capHistory
+	historyAdded   bool                    // a conversation was added since the last capHistory
 
 	// Multi-account state
 	accountStatuses map[string]string // JID -> status (online, connecting, failed, offline)
+	connectFailures map[string]string // JID -> why the last connection attempt failed
 	accountUnreads  map[string]int    // JID -> unread count
 
 	// Per-account contact unread tracking: accountJID -> contactJID -> unread count
@@ -235,23 +322,128 @@ type App struct {
 	// Status sharing state: contactJID -> enabled (true = sharing status with contact)
 	statusSharing map[string]bool
 
+	// Automatic reconnect after the connection is lost: accountJID -> pending timer / attempt count
+	reconnectTimers   map[string]*time.Timer
+	reconnectAttempts map[string]int
+
+	// Outgoing messages not sent yet: queue ID -> message / pending send timer
+	outbox       map[string]*QueuedMessage
+	outboxTimers map[string]*time.Timer
+	outboxSeq    int
+
+	// Ignored MUC occupants: historyKey(account, room) -> nick -> ignored
+	mucIgnored map[string]map[string]bool
+
+	// Own nick per joined room: historyKey(account, room) -> nick
+	roomNicks map[string]string
+
+	// Own role per joined room, and visitors' voice requests waiting for
+	// our answer as a moderator
+	roomRoles     map[string]string
+	voiceRequests []VoiceRequest
+
+	// Who is in each joined room and the room's subject, see muc.go
+	roomOccupants map[string]map[string]RoomOccupant
+	roomJoined    map[string]bool
+	roomSubjects  map[string]string
+
+	// The server's archiving of each account, see archive.go
+	archivePrefs map[string]*archiveEntry
+
+	// Privacy dashboard switches, see privacy.go, and what they govern: the
+	// open conversation, the ones told composing, the last markable message
+	// of each (chatstates.go) and the last key press (lastactivity.go)
+	privacyPrefs map[string]bool
+	chatFocus    chatFocus
+	typing       map[string]*typingEntry
+	markable     map[string]string
+	lastInput    time.Time
+
+	// Messages sent from the composer, by conversation, see inputhistory.go
+	sentInput map[string][]string
+
+	// Our room messages waiting to be reflected: message ID -> message,
+	// see reflection.go
+	pendingReflections map[string]*pendingReflection
+
+	// Whether the terminal has focus, see focus.go
+	focus focusState
+
+	// Traffic statistics per account JID, see accountstats.go
+	accountStats map[string]*accountStats
+
+	// Pending contact requests: historyKey -> kind, when each stranger
+	// wrote in the last hour, and the blocked senders
+	contactRequests map[string]string
+	requestTimes    map[string][]time.Time
+	blockedContacts map[string]bool
+
+	// Incoming calls still ringing: call ID -> call
+	calls map[string]*pendingCall
+
+	// Link previews being fetched: URL -> in flight
+	previewFetching map[string]bool
+
+	// Software versions asked this session: historyKey -> answer
+	softwareVersions map[string]*softwareVersionEntry
+
+	// Pings of contacts' clients: historyKey(account, full JID) -> outcome
+	pings map[string]*pingEntry
+
+	// Conversations locked to one client: historyKey -> full JID
+	resourceLocks map[string]string
+
+	// The rebuilt database banner was acknowledged with :db
+	storageBannerSeen bool
+
+	// Account changes wait for the encrypted accounts file to be unlocked
+	accountsUnsaved atomic.Bool
+
+	// Plain text conversation logs, nil when chat_logs.enabled is off
+	chatLog *chatLogger
+
+	// Roster changes not confirmed by the server, and queued ones another
+	// client changed meanwhile: historyKey -> edit
+	rosterEdits     map[string]*RosterEdit
+	rosterConflicts map[string]*RosterConflict
+
+	// Status bar items of plugins: id -> item
+	statusItems map[string]*statusItem
+
+	// : commands added by plugins, and the built-in names they cannot take
+	pluginCommands   map[string]*PluginCommand
+	reservedCommands map[string]bool
+
+	// The plugins in plugins.enabled and the API they are served, see
+	// pluginhost.go; pluginHost is nil in the demo
+	pluginAPI  *api.PluginAPI
+	pluginHost *plugin.Host
+
+	// Connections being established: account JID -> attempt
+	connecting map[string]*connectAttempt
+
+	// OMEMO devices not yet acknowledged by the user: historyKey -> device IDs
+	deviceAlerts map[string]map[uint32]bool
+
 	// Operation tracking for cancellation
 	pendingOps   map[dialogs.OperationType]context.CancelFunc
 	pendingOpsMu sync.Mutex
 
 	// SQLite storage for roster persistence
 	storage *sqlite.DB
+
+	// demo is set by NewDemo: generated data, no network
+	demo bool
 }
 
 // New creates a new App instance
 func New(cfg *config.Config) (*App, error) {
+	config.SetPassphraseCacheTimeout(time.Duration(cfg.General.PassphraseCacheMinutes) * time.Minute)
 	accounts, err := config.LoadAccounts()
 	if err != nil {
 		return nil, err
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-
 	// Get data directory from config or use default
 	dataDir := cfg.General.DataDir
 	if dataDir == "" {
@@ -270,12 +462,38 @@ func New(cfg *config.Config) (*App, error) {
 			fmt.Fprintf(os.Stderr, "Warning: failed to initialize storage: %v\n", err)
 		} else {
 			fmt.Fprintf(os.Stderr, "[DEBUG] SQLite storage initialized at %s\n", dataDir)
+			if rec := storage.Recovered(); rec != nil {
+				fmt.Fprintf(os.Stderr, "Warning: database was damaged (%s) and rebuilt, the damaged file is at %s\n", rec.Problem, rec.BackupPath)
+			}
+			if err := storage.DeletePresenceHistory(time.Now().Add(-presenceHistoryRetention)); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to prune presence history: %v\n", err)
+			}
 		}
 	} else {
 		fmt.Fprintf(os.Stderr, "[WARN] dataDir is empty, storage not initialized\n")
 	}
 
-	app := &App{
+	app := newApp(cfg, accounts, storage)
+	historyApp.Store(app)
+	app.pluginHost = plugin.NewHost(app.pluginDir(), app.pluginAPI)
+	app.pluginHost.SetConsent(app.PluginConsent)
+
+	// Restore cached roster and unread state so UI has immediate data before
+	// a live roster sync completes.
+	app.restorePersistedState()
+	go app.runMessageExpiry()
+	app.startIPC()
+	app.ApplyChatLogs()
+	go app.runStatusHook()
+
+	return app, nil
+}
+
+// newApp creates an App with empty state around the given accounts and
+// storage, which may be nil
+func newApp(cfg *config.Config, accounts *config.AccountsConfig, storage *sqlite.DB) *App {
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &App{
 		cfg:                    cfg,
 		accounts:               accounts,
 		events:                 make(chan EventMsg, 100),
@@ -283,6 +501,8 @@ func New(cfg *config.Config) (*App, error) {
 		cancel:                 cancel,
 		status:                 "offline",
 		chatHistory:            make(map[string][]chat.Message),
+		historyUsed:            make(map[string]uint64),
+		historyLimits:          make(map[string]historyLimit),
 		accountStatuses:        make(map[string]string),
 		accountUnreads:         make(map[string]int),
 		clients:                make(map[string]*client.Client),
@@ -290,15 +510,18 @@ func New(cfg *config.Config) (*App, error) {
 		contactFavorites:       map[string]map[string]bool{},
 		contactLastInteraction: map[string]map[string]int64{},
 		statusSharing:          make(map[string]bool),
+		reconnectTimers:        make(map[string]*time.Timer),
+		reconnectAttempts:      make(map[string]int),
+		outbox:                 make(map[string]*QueuedMessage),
+		outboxTimers:           make(map[string]*time.Timer),
+		mucIgnored:             make(map[string]map[string]bool),
+		roomNicks:              make(map[string]string),
+		deviceAlerts:           make(map[string]map[uint32]bool),
 		pendingOps:             make(map[dialogs.OperationType]context.CancelFunc),
 		storage:                storage,
 	}
-
-	// Restore cached roster and unread state so UI has immediate data before
-	// a live roster sync completes.
-	app.restorePersistedState()
-
-	return app, nil
+	a.pluginAPI = a.newPluginAPI()
+	return a
 }
 
 // Config returns the configuration
@@ -306,6 +529,11 @@ func (a *App) Config() *config.Config {
 	return a.cfg
 }
 
+// TimeFormat returns the formatter for the configured time and date formats
+func (a *App) TimeFormat() timefmt.Formatter {
+	return timefmt.New(a.cfg.UI.TimeFormat, a.cfg.UI.DateFormat)
+}
+
 // SetProgram sets the Bubble Tea program reference
 func (a *App) SetProgram(p *tea.Program) {
 	a.program = p
@@ -316,6 +544,7 @@ func (a *App) Init() tea.Cmd {
 	return tea.Batch(
 		a.listenForEvents(),
 		a.autoConnect(),
+		a.loadPlugins(),
 	)
 }
 
@@ -331,42 +560,50 @@ func (a *App) listenForEvents() tea.Cmd {
 	}
 }
 
-// autoConnect auto-connects to accounts if configured
+// autoConnect auto-connects to accounts if configured. The accounts connect
+// concurrently once the UI handles the AutoConnectMsg, in their connect
+// order and each after its connect delay.
 func (a *App) autoConnect() tea.Cmd {
-	// Collect all accounts that need auto-connect (per-account setting)
-	var cmds []tea.Cmd
-	var firstAccount string
+	if a.demo {
+		return nil
+	}
 
+	// Collect all accounts that need auto-connect (per-account setting)
+	var accounts []config.Account
 	for _, account := range a.accounts.Accounts {
 		if account.AutoConnect && account.Password != "" {
-			jid := account.JID
-			if firstAccount == "" {
-				firstAccount = jid
-			}
-
-			// Create a command for each account
-			cmds = append(cmds, func() tea.Msg {
-				a.mu.Lock()
-				a.accountStatuses[jid] = "connecting"
-				a.mu.Unlock()
-				return ConnectingMsg{JID: jid}
-			})
+			accounts = append(accounts, account)
+		}
+	}
+	if len(accounts) == 0 {
+		return nil
+	}
+	sort.SliceStable(accounts, func(i, j int) bool {
+		return accounts[i].ConnectOrder < accounts[j].ConnectOrder
+	})
+	jids := make([]string, len(accounts))
+	delays := make([]time.Duration, len(accounts))
+	var after time.Duration
+	for i, account := range accounts {
+		if i > 0 && account.ConnectDelay > 0 {
+			after += time.Duration(account.ConnectDelay) * time.Second
 		}
+		jids[i] = account.JID
+		delays[i] = after
 	}
 
 	// Set the first auto-connect account as current
-	if firstAccount != "" {
-		a.currentAccount = firstAccount
-		a.mu.Lock()
-		a.status = "connecting"
-		a.mu.Unlock()
+	a.mu.Lock()
+	a.currentAccount = jids[0]
+	a.status = "connecting"
+	for _, jid := range jids {
+		a.accountStatuses[jid] = "connecting"
 	}
+	a.mu.Unlock()
 
-	if len(cmds) == 0 {
-		return nil
+	return func() tea.Msg {
+		return AutoConnectMsg{JIDs: jids, Delays: delays}
 	}
-
-	return tea.Batch(cmds...)
 }
 
 // sendEvent sends an event to the UI
@@ -385,8 +622,18 @@ func (a *App) sendEvent(event EventMsg) {
 
 // Close closes the app
 func (a *App) Close() {
+	if a.pluginHost != nil {
+		a.pluginHost.UnloadAll()
+	}
 	a.cancel()
 	close(a.events)
+	a.mu.Lock()
+	chatLog := a.chatLog
+	a.chatLog = nil
+	a.mu.Unlock()
+	if chatLog != nil {
+		chatLog.close()
+	}
 	if a.storage != nil {
 		a.storage.Close()
 	}
@@ -431,6 +678,8 @@ func mapStatusToShow(status string) string {
 	switch status {
 	case "online":
 		return "" // Empty show means online/available
+	case "chat":
+		return "chat"
 	case "away":
 		return "away"
 	case "dnd":
@@ -450,6 +699,7 @@ func (a *App) SetStatusAndSend(status, statusMsg string) error {
 
 	a.mu.RLock()
 	client := a.xmppClient
+	accountJID := a.currentAccount
 	a.mu.RUnlock()
 
 	if client == nil || !client.IsConnected() {
@@ -457,7 +707,7 @@ func (a *App) SetStatusAndSend(status, statusMsg string) error {
 	}
 
 	show := mapStatusToShow(status)
-	return client.SendPresence(show, statusMsg)
+	return a.sendPresence(accountJID, client, show, statusMsg)
 }
 
 // GetContacts returns the roster entries (alias for GetRosters for compatibility)
@@ -486,7 +736,7 @@ func (a *App) GetContactsWithStatusInfo() []roster.Roster {
 
 	// Enrich each roster entry with status sharing info
 	for i := range rosters {
-		rosters[i].StatusHidden = !a.IsStatusSharingEnabled(rosters[i].JID)
+		rosters[i].StatusHidden = !a.IsStatusSharingEnabledForAccount(rosters[i].AccountJID, rosters[i].JID)
 	}
 
 	return rosters
@@ -517,7 +767,9 @@ func (a *App) restorePersistedState() {
 		a.loadRosterCacheForAccount(acc.JID)
 		a.loadUnreadStateForAccount(acc.JID)
 		a.loadContactMetadataForAccount(acc.JID)
+		a.loadStatusSharingForAccount(acc.JID)
 	}
+	a.loadContactRequests()
 }
 
 func (a *App) ensureAccountStateLoaded(accountJID string) {
@@ -548,74 +800,30 @@ func (a *App) ensureAccountStateLoaded(accountJID string) {
 	}
 }
 
-func contactFavoritesStateKey(accountJID string) string {
-	return "contacts:favorites:" + accountJID
-}
-
-func contactInteractionStateKey(accountJID string) string {
-	return "contacts:last_interaction:" + accountJID
-}
-
 func (a *App) loadContactMetadataForAccount(accountJID string) {
 	if a.storage == nil || accountJID == "" {
 		return
 	}
 
 	favorites := make(map[string]bool)
-	if raw, err := a.storage.GetAppState(contactFavoritesStateKey(accountJID)); err == nil && raw != "" {
-		var list []string
-		if err := json.Unmarshal([]byte(raw), &list); err == nil {
-			for _, jid := range list {
-				if jid == "" {
-					continue
-				}
-				favorites[jid] = true
+	lastInteraction := make(map[string]int64)
+	if entries, err := a.storage.GetContactMetadata(accountJID); err == nil {
+		for _, entry := range entries {
+			if entry.Favorite {
+				favorites[entry.JID] = true
+			}
+			if !entry.LastInteraction.IsZero() {
+				lastInteraction[entry.JID] = entry.LastInteraction.Unix()
 			}
 		}
 	}
 
-	lastInteraction := make(map[string]int64)
-	if raw, err := a.storage.GetAppState(contactInteractionStateKey(accountJID)); err == nil && raw != "" {
-		_ = json.Unmarshal([]byte(raw), &lastInteraction)
-	}
-
 	a.mu.Lock()
 	a.contactFavorites[accountJID] = favorites
 	a.contactLastInteraction[accountJID] = lastInteraction
 	a.mu.Unlock()
 }
 
-func (a *App) saveContactMetadataForAccount(accountJID string) {
-	if a.storage == nil || accountJID == "" {
-		return
-	}
-
-	a.mu.RLock()
-	favSet := a.contactFavorites[accountJID]
-	interactionMap := a.contactLastInteraction[accountJID]
-	favorites := make([]string, 0, len(favSet))
-	for jid, on := range favSet {
-		if on {
-			favorites = append(favorites, jid)
-		}
-	}
-	sort.Strings(favorites)
-	interactionCopy := make(map[string]int64, len(interactionMap))
-	for jid, ts := range interactionMap {
-		interactionCopy[jid] = ts
-	}
-	a.mu.RUnlock()
-
-	favoritesJSON, err := json.Marshal(favorites)
-	if err == nil {
-		_ = a.storage.SetAppState(contactFavoritesStateKey(accountJID), string(favoritesJSON))
-	}
-	interactionsJSON, err := json.Marshal(interactionCopy)
-	if err == nil {
-		_ = a.storage.SetAppState(contactInteractionStateKey(accountJID), string(interactionsJSON))
-	}
-}
-
 func (a *App) IsContactFavoriteForAccount(accountJID, contactJID string) bool {
 	if accountJID == "" || contactJID == "" {
 		return false
@@ -650,12 +858,165 @@ func (a *App) ToggleContactFavoriteForAccount(accountJID, contactJID string) (bo
 	a.contactFavorites[accountJID][contactJID] = newState
 	a.mu.Unlock()
 
-	a.saveContactMetadataForAccount(accountJID)
+	if a.storage != nil {
+		_ = a.storage.SetContactFavorite(accountJID, contactJID, newState)
+	}
 	a.sendEvent(EventMsg{Type: EventRosterUpdate})
 
 	return newState, nil
 }
 
+func mucIgnoredStateKey(accountJID, roomJID string) string {
+	return "muc:ignored:" + accountJID + ":" + roomJID
+}
+
+// loadIgnoredOccupantsLocked returns the ignore set for a room, loading it
+// from storage on first use. Caller must hold a.mu for writing.
+func (a *App) loadIgnoredOccupantsLocked(accountJID, roomJID string) map[string]bool {
+	key := historyKey(accountJID, roomJID)
+	if set, ok := a.mucIgnored[key]; ok {
+		return set
+	}
+	set := make(map[string]bool)
+	if a.storage != nil {
+		if raw, err := a.storage.GetAppState(mucIgnoredStateKey(accountJID, roomJID)); err == nil && raw != "" {
+			var nicks []string
+			if err := json.Unmarshal([]byte(raw), &nicks); err == nil {
+				for _, nick := range nicks {
+					if nick != "" {
+						set[nick] = true
+					}
+				}
+			}
+		}
+	}
+	a.mucIgnored[key] = set
+	return set
+}
+
+// GetIgnoredOccupants returns the sorted nicks ignored in a room
+func (a *App) GetIgnoredOccupants(accountJID, roomJID string) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	set := a.loadIgnoredOccupantsLocked(accountJID, roomJID)
+	nicks := make([]string, 0, len(set))
+	for nick := range set {
+		nicks = append(nicks, nick)
+	}
+	sort.Strings(nicks)
+	return nicks
+}
+
+// SetOccupantIgnored hides or shows the messages of a room occupant and
+// persists the room's ignore list
+func (a *App) SetOccupantIgnored(accountJID, roomJID, nick string, ignored bool) error {
+	nick = strings.TrimSpace(nick)
+	if roomJID == "" {
+		return fmt.Errorf("no room selected")
+	}
+	if nick == "" {
+		return fmt.Errorf("no occupant selected")
+	}
+
+	a.mu.Lock()
+	set := a.loadIgnoredOccupantsLocked(accountJID, roomJID)
+	if ignored {
+		set[nick] = true
+	} else {
+		delete(set, nick)
+	}
+	nicks := make([]string, 0, len(set))
+	for n := range set {
+		nicks = append(nicks, n)
+	}
+	a.mu.Unlock()
+
+	if a.storage == nil {
+		return nil
+	}
+	if len(nicks) == 0 {
+		return a.storage.DeleteAppState(mucIgnoredStateKey(accountJID, roomJID))
+	}
+	sort.Strings(nicks)
+	data, err := json.Marshal(nicks)
+	if err != nil {
+		return err
+	}
+	return a.storage.SetAppState(mucIgnoredStateKey(accountJID, roomJID), string(data))
+}
+
+// GetRoomOccupants returns the nicks in a room now or seen in its history
+// together with the ones on its ignore list, sorted
+func (a *App) GetRoomOccupants(accountJID, roomJID string) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	seen := make(map[string]bool)
+	for nick := range a.loadIgnoredOccupantsLocked(accountJID, roomJID) {
+		seen[nick] = true
+	}
+	for nick := range a.roomOccupants[historyKey(accountJID, roomJID)] {
+		seen[nick] = true
+	}
+	for _, msg := range a.chatHistory[historyKey(accountJID, roomJID)] {
+		if msg.Outgoing {
+			continue
+		}
+		if idx := strings.Index(msg.From, "/"); idx >= 0 && idx+1 < len(msg.From) {
+			seen[msg.From[idx+1:]] = true
+		}
+	}
+
+	nicks := make([]string, 0, len(seen))
+	for nick := range seen {
+		nicks = append(nicks, nick)
+	}
+	sort.Strings(nicks)
+	return nicks
+}
+
+// RoomActivity summarises what happened in a room since it was last viewed.
+// Indices refer to the room's chat history.
+type RoomActivity struct {
+	Since    time.Time
+	Messages int
+	FirstNew int
+	Mentions []int
+}
+
+// MarkRoomSeen records that the room's history has been viewed up to now
+func (a *App) MarkRoomSeen(accountJID, roomJID string) {
+	if a.storage == nil || roomJID == "" {
+		return
+	}
+	_ = a.storage.SetRoomLastRead(accountJID, roomJID, time.Now())
+}
+
+// GetRoomActivity counts the messages and mentions of the own nick that
+// arrived in a room since it was last viewed, including backfilled history
+func (a *App) GetRoomActivity(accountJID, roomJID string, history []chat.Message) (RoomActivity, bool) {
+	lastRead := a.GetRoomSettings(accountJID, roomJID).LastRead
+	if lastRead.IsZero() {
+		return RoomActivity{}, false
+	}
+	nick := a.RoomNick(accountJID, roomJID)
+
+	activity := RoomActivity{Since: lastRead, FirstNew: -1}
+	for i, msg := range history {
+		if msg.Outgoing || msg.Type == "system" || !msg.Timestamp.After(activity.Since) {
+			continue
+		}
+		if activity.FirstNew < 0 {
+			activity.FirstNew = i
+		}
+		activity.Messages++
+		if mentionsNick(msg.Body, nick) {
+			activity.Mentions = append(activity.Mentions, i)
+		}
+	}
+	return activity, activity.Messages > 0
+}
+
 func (a *App) TouchContactInteractionForAccount(accountJID, contactJID string, at time.Time) {
 	accountJID = strings.TrimSpace(accountJID)
 	contactJID = strings.TrimSpace(contactJID)
@@ -679,7 +1040,9 @@ func (a *App) TouchContactInteractionForAccount(accountJID, contactJID string, a
 	}
 	a.mu.Unlock()
 
-	a.saveContactMetadataForAccount(accountJID)
+	if a.storage != nil {
+		_ = a.storage.TouchContactInteraction(accountJID, contactJID, at)
+	}
 }
 
 func (a *App) loadRosterCacheForAccount(accountJID string) {
@@ -813,17 +1176,23 @@ func historyContactFromKey(key string) string {
 	return key
 }
 
-// GetChatHistory returns chat history for a JID
+// GetChatHistory returns chat history for a JID on the current account
 func (a *App) GetChatHistory(jid string) []chat.Message {
 	a.mu.RLock()
 	currentAccount := a.currentAccount
-	key := historyKey(currentAccount, jid)
+	a.mu.RUnlock()
+	return a.GetChatHistoryForAccount(currentAccount, jid)
+}
+
+// GetChatHistoryForAccount returns the chat history between an account and a JID
+func (a *App) GetChatHistoryForAccount(accountJID, jid string) []chat.Message {
+	key := historyKey(accountJID, jid)
+	a.mu.Lock()
 	history := a.chatHistory[key]
-	if len(history) == 0 {
-		// Backward compatibility for older in-memory keys.
-		history = a.chatHistory[jid]
+	if len(history) > 0 {
+		a.touchHistoryLocked(key)
 	}
-	a.mu.RUnlock()
+	a.mu.Unlock()
 
 	// If we have messages in memory, return them
 	if len(history) > 0 {
@@ -831,8 +1200,8 @@ func (a *App) GetChatHistory(jid string) []chat.Message {
 	}
 
 	// Try to load from database
-	if a.storage != nil && currentAccount != "" {
-		dbMessages, err := a.storage.GetMessages(currentAccount, jid, 100, 0)
+	if a.storage != nil && accountJID != "" {
+		dbMessages, err := a.storage.GetMessages(accountJID, jid, a.historyLoadLimit(accountJID, jid), 0)
 		if err == nil && len(dbMessages) > 0 {
 			// Convert storage messages to chat messages
 			messages := make([]chat.Message, len(dbMessages))
@@ -848,7 +1217,7 @@ func (a *App) GetChatHistory(jid string) []chat.Message {
 
 				messages[i] = chat.Message{
 					ID:        dbMsg.ID,
-					From:      currentAccount,
+					From:      accountJID,
 					To:        jid,
 					Body:      dbMsg.Body,
 					Timestamp: dbMsg.Timestamp,
@@ -858,15 +1227,24 @@ func (a *App) GetChatHistory(jid string) []chat.Message {
 					Status:    status,
 				}
 				if !dbMsg.Outgoing {
+					// The full JID it came from, which in rooms names the
+					// nick; messages stored before senders were kept only
+					// have the conversation
 					messages[i].From = jid
-					messages[i].To = currentAccount
+					if dbMsg.Sender != "" {
+						messages[i].From = dbMsg.Sender
+					}
+					messages[i].To = accountJID
 				}
 			}
 
 			// Cache in memory
 			a.mu.Lock()
 			a.chatHistory[key] = messages
+			a.historyAdded = true
+			a.touchHistoryLocked(key)
 			a.mu.Unlock()
+			a.capHistory()
 
 			return messages
 		}
@@ -914,6 +1292,9 @@ func (a *App) AddChatMessageForAccount(accountJID, jid string, msg chat.Message)
 			if msg.Encrypted {
 				existing.Encrypted = true
 			}
+			if existing.FileURL == "" && msg.FileURL != "" {
+				existing.FileURL = msg.FileURL
+			}
 			if msg.CorrectedID != "" {
 				existing.CorrectedID = msg.CorrectedID
 			}
@@ -935,11 +1316,33 @@ func (a *App) AddChatMessageForAccount(accountJID, jid string, msg chat.Message)
 			return
 		}
 	}
-	a.chatHistory[key] = append(a.chatHistory[key], msg)
+	var warning *chat.Message
+	if a.plaintextDowngradeLocked(key, msg) {
+		w := a.addDowngradeWarningLocked(key, msg)
+		warning = &w
+	}
+	a.appendHistoryLocked(key, msg)
 	a.mu.Unlock()
+	a.capHistory()
+	a.logChatMessage(accountJID, jid, msg)
+	if msg.Outgoing && a.contactRequestKind(accountJID, jid) == RequestMessage {
+		// Writing back accepts a message request
+		a.clearContactRequest(accountJID, jid)
+	}
+	if warning != nil {
+		a.sendEvent(EventMsg{Type: EventMessage, Data: ChatMessage{
+			AccountJID: accountJID,
+			ID:         warning.ID,
+			From:       warning.From,
+			Body:       warning.Body,
+			Timestamp:  warning.Timestamp,
+			Type:       warning.Type,
+			Warning:    true,
+		}})
+	}
 
 	// Persist to database if enabled
-	if a.storage != nil && accountJID != "" && a.cfg.Storage.SaveMessages {
+	if accountJID != "" && a.SavingEnabled(accountJID, jid) {
 		msgType := msg.Type
 		if msgType == "" {
 			msgType = "chat"
@@ -947,6 +1350,7 @@ func (a *App) AddChatMessageForAccount(accountJID, jid string, msg chat.Message)
 		_ = a.storage.SaveMessage(
 			accountJID,
 			jid,
+			msg.From,
 			msg.ID,
 			msg.Body,
 			msgType,
@@ -958,7 +1362,7 @@ func (a *App) AddChatMessageForAccount(accountJID, jid string, msg chat.Message)
 
 	a.TouchContactInteractionForAccount(accountJID, jid, msg.Timestamp)
 
-	a.sendEvent(EventMsg{Type: EventMessage, Data: ChatMessage{
+	a.sendEvent(a.messageEvent(accountJID, jid, ChatMessage{
 		AccountJID:  accountJID,
 		ID:          msg.ID,
 		From:        msg.From,
@@ -971,18 +1375,25 @@ func (a *App) AddChatMessageForAccount(accountJID, jid string, msg chat.Message)
 		Status:      MessageStatus(msg.Status),
 		CorrectedID: msg.CorrectedID,
 		Reactions:   msg.Reactions,
-	}})
+		JSON:        msg.JSON,
+		FileURL:     msg.FileURL,
+	}))
 }
 
-// SendChatMessage sends a message and returns a command to handle the result
+// SendChatMessage sends a message and returns a command to handle the result.
+// Messages that cannot be sent right away are kept in the outgoing queue.
 func (a *App) SendChatMessage(to, body string) tea.Cmd {
+	return a.sendMessageCmd(to, body, "")
+}
+
+// sendMessageCmd sends a message, or shares the uploaded file at fileURL
+func (a *App) sendMessageCmd(to, body, fileURL string) tea.Cmd {
 	return func() tea.Msg {
 		a.mu.RLock()
-		client := a.clients[a.currentAccount]
 		currentAccount := a.currentAccount
 		a.mu.RUnlock()
 
-		if client == nil || !client.IsConnected() {
+		if currentAccount == "" {
 			return SendMessageResultMsg{
 				Success: false,
 				To:      to,
@@ -990,57 +1401,48 @@ func (a *App) SendChatMessage(to, body string) tea.Cmd {
 			}
 		}
 
-		// Send the message
-		msgID, err := client.SendMessage(to, body)
-		if err != nil {
+		if a.demo {
+			return a.sendDemoMessage(currentAccount, to, body)
+		}
+
+		if a.getConnectedClient(currentAccount) == nil {
+			qm := a.enqueueMessage(currentAccount, to, body, QueueQueued, time.Time{}, "")
 			return SendMessageResultMsg{
 				Success:   false,
-				MessageID: msgID,
+				Queued:    true,
+				MessageID: qm.ID,
 				To:        to,
-				Error:     err.Error(),
+				Error:     "not connected",
 			}
 		}
 
-		// Create local echo message with Sending status
-		timestamp := time.Now()
-		localMsg := chat.Message{
-			ID:        msgID,
-			From:      currentAccount,
-			To:        to,
-			Body:      body,
-			Timestamp: timestamp,
-			Outgoing:  true,
-			Status:    chat.MessageStatus(StatusSending),
+		msgID, err := a.deliverChatMessage(currentAccount, to, body, fileURL)
+		if errors.Is(err, client.ErrEncryptionUnavailable) {
+			// Retrying will not help until the contact publishes OMEMO devices
+			return SendMessageResultMsg{
+				Success: false,
+				To:      to,
+				Error:   err.Error() + " (:omemo disable to send unencrypted)",
+			}
 		}
-
-		// Add to chat history and notify UI
-		a.mu.Lock()
-		key := historyKey(currentAccount, to)
-		a.chatHistory[key] = append(a.chatHistory[key], localMsg)
-		a.mu.Unlock()
-
-		// Send event to update UI immediately with the local echo
-		a.sendEvent(EventMsg{Type: EventMessage, Data: ChatMessage{
-			AccountJID: currentAccount,
-			ID:         localMsg.ID,
-			From:       localMsg.From,
-			To:         localMsg.To,
-			Body:       localMsg.Body,
-			Timestamp:  localMsg.Timestamp,
-			Outgoing:   localMsg.Outgoing,
-			Status:     MessageStatus(localMsg.Status),
-		}})
-
-		a.TouchContactInteractionForAccount(currentAccount, to, timestamp)
-
-		// Persist to database if enabled
-		if a.storage != nil && a.cfg.Storage.SaveMessages {
-			_ = a.storage.SaveMessage(currentAccount, to, msgID, body, "chat", timestamp, true, false)
+		if errors.Is(err, ErrSendingPaused) {
+			// Waits for the user, not for the connection
+			return SendMessageResultMsg{
+				Success: false,
+				To:      to,
+				Error:   err.Error(),
+			}
+		}
+		if err != nil {
+			qm := a.enqueueMessage(currentAccount, to, body, QueueRetrying, time.Now().Add(reconnectDelay(1)), err.Error())
+			return SendMessageResultMsg{
+				Success:   false,
+				Queued:    true,
+				MessageID: qm.ID,
+				To:        to,
+				Error:     err.Error(),
+			}
 		}
-
-		// After successful send, update status to Sent
-		// The message was accepted by the XMPP library
-		a.UpdateMessageStatusForAccount(currentAccount, to, msgID, StatusSent)
 
 		return SendMessageResultMsg{
 			Success:   true,
@@ -1050,34 +1452,127 @@ func (a *App) SendChatMessage(to, body string) tea.Cmd {
 	}
 }
 
-// UpdateMessageStatus updates the status of a message by ID
-func (a *App) UpdateMessageStatus(contactJID, msgID string, status MessageStatus) {
+// deliverChatMessage sends a message from a specific account, echoes it into
+// the chat history and persists it. A fileURL shares an uploaded file, with
+// the URL as XEP-0066 out-of-band data unless the message is encrypted.
+func (a *App) deliverChatMessage(accountJID, to, body, fileURL string) (string, error) {
+	client := a.getConnectedClient(accountJID)
+	if client == nil {
+		return "", fmt.Errorf("account %s is not connected", accountJID)
+	}
+
+	// Send the message, with composer markdown as XEP-0393 styling. With
+	// require_encryption, :omemo enable or a policy denying
+	// plaintext_fallback, conversations that should be encrypted never fall
+	// back to plaintext.
+	var msgID string
+	var encrypted bool
+	var err error
+	if a.cfg.UI.MessageStyling && fileURL == "" {
+		body = toMessageStyling(body)
+	}
+	dest := a.messageTarget(accountJID, to)
 	a.mu.RLock()
-	accountJID := a.currentAccount
+	room := a.roomNicks[historyKey(accountJID, to)] != ""
 	a.mu.RUnlock()
-	a.UpdateMessageStatusForAccount(accountJID, contactJID, msgID, status)
-}
-
-// UpdateMessageStatusForAccount updates the status of a message by ID for a specific account.
-func (a *App) UpdateMessageStatusForAccount(accountJID, contactJID, msgID string, status MessageStatus) {
-	key := historyKey(accountJID, contactJID)
-
-	a.mu.Lock()
-	if messages, ok := a.chatHistory[key]; ok {
-		for i, msg := range messages {
-			if msg.ID == msgID {
-				// Convert to chat.MessageStatus
-				a.chatHistory[key][i].Status = chat.MessageStatus(status)
-				break
-			}
+	switch {
+	case room:
+		msgID, err = a.sendRoomMessage(client, accountJID, to, dest, body)
+	case a.EncryptionEnabled(accountJID, to):
+		if a.EncryptedSendingPaused(accountJID, to) {
+			return "", ErrSendingPaused
 		}
-	} else if messages, ok := a.chatHistory[contactJID]; ok {
-		// Backward compatibility for older in-memory key format.
-		for i, msg := range messages {
-			if msg.ID == msgID {
-				a.chatHistory[contactJID][i].Status = chat.MessageStatus(status)
-				break
-			}
+		// Never fall back to plaintext silently in a conversation that was
+		// encrypted so far, or with a contact set to always encrypt
+		fallback := !a.cfg.Encryption.RequireEncryption && !a.ConversationEncrypted(accountJID, to) &&
+			a.ContactEncryption(accountJID, to) != EncryptionOMEMO &&
+			!a.PolicyDenies(accountJID, PolicyPlaintextFallback)
+		msgID, encrypted, err = client.SendEncryptedMessage(dest, body, fallback)
+	case fileURL != "":
+		msgID, err = client.SendFileMessage(dest, fileURL)
+	case a.cfg.UI.MessageStyling:
+		msgID, err = client.SendMessage(dest, body)
+	default:
+		msgID, err = client.SendUnstyledMessage(dest, body)
+	}
+	if err != nil {
+		return msgID, err
+	}
+	// The message ends the composing sent while writing it
+	a.stopTyping(accountJID, to)
+
+	// Create local echo message with Sending status
+	timestamp := time.Now()
+	localMsg := chat.Message{
+		ID:        msgID,
+		From:      accountJID,
+		To:        to,
+		Body:      body,
+		Timestamp: timestamp,
+		Encrypted: encrypted,
+		Outgoing:  true,
+		Status:    chat.MessageStatus(StatusSending),
+		FileURL:   fileURL,
+	}
+
+	// Add to chat history and notify UI
+	a.mu.Lock()
+	key := historyKey(accountJID, to)
+	a.appendHistoryLocked(key, localMsg)
+	a.mu.Unlock()
+	a.capHistory()
+
+	// Send event to update UI immediately with the local echo
+	a.sendEvent(a.messageEvent(accountJID, to, ChatMessage{
+		AccountJID: accountJID,
+		ID:         localMsg.ID,
+		From:       localMsg.From,
+		To:         localMsg.To,
+		Body:       localMsg.Body,
+		Timestamp:  localMsg.Timestamp,
+		Encrypted:  encrypted,
+		Outgoing:   localMsg.Outgoing,
+		Status:     MessageStatus(localMsg.Status),
+		FileURL:    fileURL,
+	}))
+
+	a.TouchContactInteractionForAccount(accountJID, to, timestamp)
+	a.logChatMessage(accountJID, to, localMsg)
+
+	// Persist to database if enabled
+	if a.SavingEnabled(accountJID, to) {
+		_ = a.storage.SaveMessage(accountJID, to, accountJID, msgID, body, "chat", timestamp, true, encrypted)
+	}
+
+	// After successful send, update status to Sent
+	// The message was accepted by the XMPP library
+	if room {
+		a.roomMessageSent(accountJID, to, msgID)
+	} else {
+		a.UpdateMessageStatusForAccount(accountJID, to, msgID, StatusSent)
+	}
+
+	return msgID, nil
+}
+
+// UpdateMessageStatus updates the status of a message by ID
+func (a *App) UpdateMessageStatus(contactJID, msgID string, status MessageStatus) {
+	a.mu.RLock()
+	accountJID := a.currentAccount
+	a.mu.RUnlock()
+	a.UpdateMessageStatusForAccount(accountJID, contactJID, msgID, status)
+}
+
+// UpdateMessageStatusForAccount updates the status of a message by ID for a specific account.
+func (a *App) UpdateMessageStatusForAccount(accountJID, contactJID, msgID string, status MessageStatus) {
+	key := historyKey(accountJID, contactJID)
+
+	a.mu.Lock()
+	for i, msg := range a.chatHistory[key] {
+		if msg.ID == msgID {
+			// Convert to chat.MessageStatus
+			a.chatHistory[key][i].Status = chat.MessageStatus(status)
+			break
 		}
 	}
 	a.mu.Unlock()
@@ -1086,9 +1581,9 @@ func (a *App) UpdateMessageStatusForAccount(accountJID, contactJID, msgID string
 	if a.storage != nil {
 		switch status {
 		case StatusDelivered:
-			_ = a.storage.MarkMessageReceived(msgID)
+			_ = a.storage.MarkMessageReceived(accountJID, msgID)
 		case StatusRead:
-			_ = a.storage.MarkMessageDisplayed(msgID)
+			_ = a.storage.MarkMessageDisplayed(accountJID, msgID)
 		}
 	}
 
@@ -1096,17 +1591,25 @@ func (a *App) UpdateMessageStatusForAccount(accountJID, contactJID, msgID string
 	a.sendEvent(EventMsg{
 		Type: EventReceipt,
 		Data: MessageStatusUpdateMsg{
-			MessageID: msgID,
-			Status:    status,
+			AccountJID: accountJID,
+			MessageID:  msgID,
+			Status:     status,
 		},
 	})
 }
 
 func (a *App) CorrectMessage(to, originalID, newBody string) tea.Cmd {
+	a.mu.RLock()
+	currentAccount := a.currentAccount
+	a.mu.RUnlock()
+	return a.CorrectMessageForAccount(currentAccount, to, originalID, newBody)
+}
+
+// CorrectMessageForAccount sends a correction of a message sent from an account
+func (a *App) CorrectMessageForAccount(accountJID, to, originalID, newBody string) tea.Cmd {
 	return func() tea.Msg {
 		a.mu.RLock()
-		client := a.clients[a.currentAccount]
-		currentAccount := a.currentAccount
+		client := a.clients[accountJID]
 		a.mu.RUnlock()
 
 		if client == nil || !client.IsConnected() {
@@ -1127,24 +1630,14 @@ func (a *App) CorrectMessage(to, originalID, newBody string) tea.Cmd {
 			}
 		}
 
-		key := historyKey(currentAccount, to)
+		key := historyKey(accountJID, to)
 
 		a.mu.Lock()
-		if messages, ok := a.chatHistory[key]; ok {
-			for i, msg := range messages {
-				if msg.ID == originalID {
-					a.chatHistory[key][i].Body = newBody
-					a.chatHistory[key][i].CorrectedID = originalID
-					break
-				}
-			}
-		} else if messages, ok := a.chatHistory[to]; ok {
-			for i, msg := range messages {
-				if msg.ID == originalID {
-					a.chatHistory[to][i].Body = newBody
-					a.chatHistory[to][i].CorrectedID = originalID
-					break
-				}
+		for i, msg := range a.chatHistory[key] {
+			if msg.ID == originalID {
+				a.chatHistory[key][i].Body = newBody
+				a.chatHistory[key][i].CorrectedID = originalID
+				break
 			}
 		}
 		a.mu.Unlock()
@@ -1152,7 +1645,7 @@ func (a *App) CorrectMessage(to, originalID, newBody string) tea.Cmd {
 		a.sendEvent(EventMsg{
 			Type: EventMessage,
 			Data: ChatMessage{
-				AccountJID:  currentAccount,
+				AccountJID:  accountJID,
 				ID:          newID,
 				To:          to,
 				Body:        newBody,
@@ -1181,21 +1674,11 @@ func (a *App) CorrectMessageInHistoryForAccount(accountJID, to, originalID, newB
 	key := historyKey(accountJID, to)
 
 	a.mu.Lock()
-	if messages, ok := a.chatHistory[key]; ok {
-		for i, msg := range messages {
-			if msg.ID == originalID {
-				a.chatHistory[key][i].Body = newBody
-				a.chatHistory[key][i].CorrectedID = originalID
-				break
-			}
-		}
-	} else if messages, ok := a.chatHistory[to]; ok {
-		for i, msg := range messages {
-			if msg.ID == originalID {
-				a.chatHistory[to][i].Body = newBody
-				a.chatHistory[to][i].CorrectedID = originalID
-				break
-			}
+	for i, msg := range a.chatHistory[key] {
+		if msg.ID == originalID {
+			a.chatHistory[key][i].Body = newBody
+			a.chatHistory[key][i].CorrectedID = originalID
+			break
 		}
 	}
 	a.mu.Unlock()
@@ -1214,7 +1697,15 @@ func (a *App) CorrectMessageInHistoryForAccount(accountJID, to, originalID, newB
 
 func (a *App) SendReaction(to, messageID, reaction string) error {
 	a.mu.RLock()
-	client := a.clients[a.currentAccount]
+	currentAccount := a.currentAccount
+	a.mu.RUnlock()
+	return a.SendReactionForAccount(currentAccount, to, messageID, reaction)
+}
+
+// SendReactionForAccount reacts to a message in a chat of an account
+func (a *App) SendReactionForAccount(accountJID, to, messageID, reaction string) error {
+	a.mu.RLock()
+	client := a.clients[accountJID]
 	a.mu.RUnlock()
 
 	if client == nil || !client.IsConnected() {
@@ -1235,25 +1726,13 @@ func (a *App) AddReactionToHistoryForAccount(accountJID, contactJID, msgID, from
 	key := historyKey(accountJID, contactJID)
 
 	a.mu.Lock()
-	if messages, ok := a.chatHistory[key]; ok {
-		for i, msg := range messages {
-			if msg.ID == msgID {
-				if a.chatHistory[key][i].Reactions == nil {
-					a.chatHistory[key][i].Reactions = make(map[string]string)
-				}
-				a.chatHistory[key][i].Reactions[from] = reaction
-				break
-			}
-		}
-	} else if messages, ok := a.chatHistory[contactJID]; ok {
-		for i, msg := range messages {
-			if msg.ID == msgID {
-				if a.chatHistory[contactJID][i].Reactions == nil {
-					a.chatHistory[contactJID][i].Reactions = make(map[string]string)
-				}
-				a.chatHistory[contactJID][i].Reactions[from] = reaction
-				break
+	for i, msg := range a.chatHistory[key] {
+		if msg.ID == msgID {
+			if a.chatHistory[key][i].Reactions == nil {
+				a.chatHistory[key][i].Reactions = make(map[string]string)
 			}
+			a.chatHistory[key][i].Reactions[from] = reaction
+			break
 		}
 	}
 	a.mu.Unlock()
@@ -1278,36 +1757,136 @@ func (a *App) RequestUploadSlot(serviceJID, filename string, size int64, content
 	if c == nil || !c.IsConnected() {
 		return nil, fmt.Errorf("not connected")
 	}
+	if a.PolicyDenies(a.CurrentAccount(), PolicyFileUpload) {
+		return nil, PolicyError(PolicyFileUpload)
+	}
 
 	return c.RequestUploadSlot(serviceJID, filename, size, contentType)
 }
 
+// SendFileMessage shares a file uploaded with HTTP File Upload by its URL
 func (a *App) SendFileMessage(to, getURL string) tea.Cmd {
-	return a.SendChatMessage(to, getURL)
+	return a.sendMessageCmd(to, getURL, getURL)
 }
 
+// DiscoverUploadService returns the HTTP upload service of the current
+// account, or an empty string when the server has none
+func (a *App) DiscoverUploadService() (string, error) {
+	a.mu.RLock()
+	c := a.clients[a.currentAccount]
+	a.mu.RUnlock()
+
+	if c == nil || !c.IsConnected() {
+		return "", fmt.Errorf("not connected")
+	}
+	if a.PolicyDenies(a.CurrentAccount(), PolicyFileUpload) {
+		return "", PolicyError(PolicyFileUpload)
+	}
+
+	service, err := c.DiscoverUploadService()
+	if errors.Is(err, client.ErrNoUploadService) {
+		return "", nil
+	}
+	return service, err
+}
+
+// SendFileDirect sends a file straight to the contact with Jingle over
+// In-Band Bytestreams and records the transfer in the conversation
+func (a *App) SendFileDirect(to, filename string, size int64, r io.Reader) tea.Cmd {
+	return func() tea.Msg {
+		a.mu.RLock()
+		accountJID := a.currentAccount
+		c := a.clients[accountJID]
+		a.mu.RUnlock()
+
+		if c == nil || !c.IsConnected() {
+			return SendMessageResultMsg{To: to, Error: "not connected"}
+		}
+		if a.PolicyDenies(accountJID, PolicyFileUpload) {
+			return SendMessageResultMsg{To: to, Error: PolicyError(PolicyFileUpload).Error()}
+		}
+
+		if err := c.SendFileInBand(to, filename, size, r); err != nil {
+			return SendMessageResultMsg{To: to, Error: "direct transfer failed: " + err.Error()}
+		}
+
+		body := fmt.Sprintf("[file] %s (%d bytes, sent directly)", filename, size)
+		timestamp := time.Now()
+		msgID := fmt.Sprintf("ibb-%d", timestamp.UnixNano())
+		localMsg := chat.Message{
+			ID:        msgID,
+			From:      accountJID,
+			To:        to,
+			Body:      body,
+			Timestamp: timestamp,
+			Outgoing:  true,
+			Status:    chat.MessageStatus(StatusSent),
+		}
+
+		a.mu.Lock()
+		key := historyKey(accountJID, to)
+		a.appendHistoryLocked(key, localMsg)
+		a.mu.Unlock()
+		a.capHistory()
+
+		a.sendEvent(EventMsg{Type: EventMessage, Data: ChatMessage{
+			AccountJID: accountJID,
+			ID:         msgID,
+			From:       accountJID,
+			To:         to,
+			Body:       body,
+			Timestamp:  timestamp,
+			Outgoing:   true,
+			Status:     StatusSent,
+		}})
+		a.TouchContactInteractionForAccount(accountJID, to, timestamp)
+		a.logChatMessage(accountJID, to, localMsg)
+
+		return SendMessageResultMsg{Success: true, MessageID: msgID, To: to}
+	}
+}
+
+// ExportAccounts exports the accounts as plain JSON, without passwords.
+// ExportAccountsEncrypted is the default and also carries the passwords.
 func (a *App) ExportAccounts() ([]byte, error) {
+	return json.MarshalIndent(a.exportedAccounts(false), "", "  ")
+}
+
+func (a *App) exportedAccounts(withPasswords bool) []map[string]interface{} {
 	a.mu.RLock()
 	defer a.mu.RUnlock()
 
 	export := make([]map[string]interface{}, 0)
 	for _, acc := range a.accounts.Accounts {
 		if !acc.Session {
-			export = append(export, map[string]interface{}{
+			entry := map[string]interface{}{
 				"jid":          acc.JID,
 				"server":       acc.Server,
 				"port":         acc.Port,
 				"resource":     acc.Resource,
 				"auto_connect": acc.AutoConnect,
 				"omemo":        acc.OMEMO,
-			})
+			}
+			if withPasswords && acc.Password != "" {
+				entry["password"] = acc.Password
+			}
+			export = append(export, entry)
 		}
 	}
-
-	return json.MarshalIndent(export, "", "  ")
+	return export
 }
 
-func (a *App) ImportAccounts(data []byte) error {
+// ImportAccounts adds the accounts from an export. The passphrase is only
+// needed for encrypted exports.
+func (a *App) ImportAccounts(data []byte, passphrase string) error {
+	if IsEncryptedAccountsExport(data) {
+		plain, err := decryptAccountsExport(data, passphrase)
+		if err != nil {
+			return err
+		}
+		data = plain
+	}
+
 	var accounts []map[string]interface{}
 	if err := json.Unmarshal(data, &accounts); err != nil {
 		return fmt.Errorf("invalid import format: %w", err)
@@ -1350,6 +1929,9 @@ func (a *App) ImportAccounts(data []byte) error {
 
 // ExecuteCommand executes a command
 func (a *App) ExecuteCommand(cmd string, args []string) tea.Cmd {
+	if _, ok := a.cfg.Aliases[cmd]; ok {
+		return a.executeAlias(cmd, args)
+	}
 	return func() tea.Msg {
 		switch cmd {
 		// General commands
@@ -1357,6 +1939,12 @@ func (a *App) ExecuteCommand(cmd string, args []string) tea.Cmd {
 			return tea.Quit()
 
 		case "help", "h":
+			if len(args) > 0 {
+				return CommandActionMsg{
+					Action: ActionShowHelp,
+					Data:   map[string]interface{}{"command": strings.TrimPrefix(args[0], ":")},
+				}
+			}
 			return CommandActionMsg{Action: ActionShowHelp}
 
 		// Account management
@@ -1393,6 +1981,12 @@ func (a *App) ExecuteCommand(cmd string, args []string) tea.Cmd {
 					a.SetAccountResource(args[1], args[2])
 				}
 				return nil
+			case "encrypt":
+				return CommandActionMsg{Action: ActionEncryptAccounts}
+			case "decrypt":
+				return CommandActionMsg{Action: ActionDecryptAccounts}
+			case "unlock":
+				return CommandActionMsg{Action: ActionUnlockAccounts}
 			}
 			return nil
 
@@ -1478,12 +2072,43 @@ func (a *App) ExecuteCommand(cmd string, args []string) tea.Cmd {
 		case "settings":
 			return CommandActionMsg{Action: ActionShowSettings}
 
+		case "layout":
+			if len(args) == 0 {
+				return CommandActionMsg{Action: ActionCycleChatLayout}
+			}
+			a.SetSetting("chat_layout", args[0])
+			return CommandActionMsg{
+				Action: ActionSettingChanged,
+				Data:   map[string]interface{}{"key": "chat_layout"},
+			}
+
+		case "group":
+			mode := roster.NextGrouping(a.cfg.UI.RosterGroup)
+			if len(args) > 0 {
+				mode = strings.ToLower(args[0])
+				if !roster.ValidGrouping(mode) {
+					return CommandActionMsg{
+						Action: ActionCommandError,
+						Data:   map[string]interface{}{"error": "Usage: :group [none|groups|domain]"},
+					}
+				}
+			}
+			a.SetSetting("roster_group", mode)
+			return CommandActionMsg{
+				Action: ActionSettingChanged,
+				Data:   map[string]interface{}{"key": "roster_group"},
+			}
+
 		case "set":
 			if len(args) == 0 {
 				return CommandActionMsg{Action: ActionShowSettings}
 			}
 			if len(args) >= 2 {
 				a.SetSetting(args[0], args[1])
+				return CommandActionMsg{
+					Action: ActionSettingChanged,
+					Data:   map[string]interface{}{"key": args[0]},
+				}
 			}
 			return nil
 
@@ -1521,6 +2146,20 @@ func (a *App) ExecuteCommand(cmd string, args []string) tea.Cmd {
 			}
 			return nil
 
+		case "schedule", "later":
+			if len(args) >= 3 {
+				at, err := parseSendTime(args[1], time.Now())
+				if err != nil {
+					a.sendEvent(EventMsg{Type: EventError, Data: err.Error()})
+					return nil
+				}
+				a.mu.RLock()
+				accountJID := a.currentAccount
+				a.mu.RUnlock()
+				a.ScheduleChatMessage(accountJID, args[0], strings.Join(args[2:], " "), at)
+			}
+			return nil
+
 		// Window switching (like TTY: :1, :2, etc.)
 		case "1", "2", "3", "4", "5", "6", "7", "8", "9", "10",
 			"11", "12", "13", "14", "15", "16", "17", "18", "19", "20":
@@ -1549,6 +2188,123 @@ func (a *App) ExecuteCommand(cmd string, args []string) tea.Cmd {
 			// Toggle handled by UI
 			return nil
 
+		case "conflicts":
+			return CommandActionMsg{Action: ActionRosterConflicts}
+
+		case "requests":
+			return CommandActionMsg{Action: ActionContactRequests}
+
+		case "accept", "reject", "block", "unblock":
+			target := ""
+			if len(args) > 0 {
+				target = args[0]
+			}
+			return CommandActionMsg{
+				Action: ActionAnswerRequest,
+				Data:   map[string]interface{}{"jid": target, "answer": cmd},
+			}
+
+		case "voice":
+			return CommandActionMsg{Action: ActionRequestVoice}
+
+		case "stats":
+			reset := len(args) > 0 && args[0] == "reset"
+			return CommandActionMsg{Action: ActionAccountStats, Data: map[string]interface{}{"reset": reset}}
+
+		case "archive":
+			mode := ""
+			if len(args) > 0 {
+				mode = strings.ToLower(args[0])
+				if !client.ValidArchiveDefault(mode) {
+					return CommandActionMsg{
+						Action: ActionCommandError,
+						Data:   map[string]interface{}{"error": "Usage: :archive [always|roster|never]"},
+					}
+				}
+			}
+			return CommandActionMsg{Action: ActionArchivePrefs, Data: map[string]interface{}{"mode": mode}}
+
+		case "privacy":
+			return CommandActionMsg{Action: ActionPrivacy}
+
+		case "search":
+			if len(args) == 0 {
+				return CommandActionMsg{
+					Action: ActionCommandError,
+					Data:   map[string]interface{}{"error": "Usage: :search <words> [from:nick] [in:room] [after:date] [before:date]"},
+				}
+			}
+			return CommandActionMsg{
+				Action: ActionSearchHistory,
+				Data:   map[string]interface{}{"query": strings.Join(args, " ")},
+			}
+
+		case "starred":
+			return CommandActionMsg{Action: ActionShowStarred}
+
+		case "statusbar":
+			if len(args) == 0 {
+				return CommandActionMsg{Action: ActionStatusItems}
+			}
+			id := args[0]
+			items := a.StatusItems()
+			if n, err := strconv.Atoi(id); err == nil && n >= 1 && n <= len(items) {
+				id = items[n-1].ID
+			}
+			if !a.ActivateStatusItem(id) {
+				return CommandActionMsg{
+					Action: ActionCommandError,
+					Data:   map[string]interface{}{"error": "No status bar item " + args[0] + " to activate"},
+				}
+			}
+			return nil
+
+		case "plugin", "plugins":
+			usage := CommandActionMsg{
+				Action: ActionCommandError,
+				Data:   map[string]interface{}{"error": "Usage: :plugin list|perms|install|remove|enable|disable <name>"},
+			}
+			if len(args) == 0 || args[0] == "list" {
+				return a.ListPlugins()()
+			}
+			if args[0] == "perms" || args[0] == "permissions" {
+				name := ""
+				if len(args) > 1 {
+					name = args[1]
+				}
+				return CommandActionMsg{
+					Action: ActionPluginPermissions,
+					Data:   map[string]interface{}{"name": name},
+				}
+			}
+			if len(args) < 2 {
+				return usage
+			}
+			switch args[0] {
+			case "install":
+				return a.InstallPlugin(args[1])()
+			case "remove", "uninstall":
+				return a.RemovePlugin(args[1])()
+			case "enable", "disable":
+				msg := PluginActionMsg{Action: args[0], Name: args[1]}
+				if args[0] == "enable" {
+					consent, err := a.PluginConsentNeeded(args[1])
+					if err != nil {
+						msg.Error = err.Error()
+						return msg
+					}
+					if len(consent) > 0 {
+						msg.Consent = consent
+						return msg
+					}
+				}
+				if err := a.SetPluginEnabled(args[1], args[0] == "enable"); err != nil {
+					msg.Error = err.Error()
+				}
+				return msg
+			}
+			return usage
+
 		case "add":
 			if len(args) >= 1 {
 				// TODO: Add contact via XMPP
@@ -1570,6 +2326,175 @@ func (a *App) ExecuteCommand(cmd string, args []string) tea.Cmd {
 		case "loadw", "loadwindows":
 			return CommandActionMsg{Action: ActionLoadWindows}
 
+		case "title":
+			return CommandActionMsg{
+				Action: ActionRenameWindow,
+				Data:   map[string]interface{}{"name": strings.Join(args, " ")},
+			}
+
+		case "omemo":
+			if len(args) == 0 {
+				return nil
+			}
+			switch args[0] {
+			case "export":
+				return CommandActionMsg{Action: ActionShowOMEMOExport}
+			case "import":
+				return CommandActionMsg{Action: ActionShowOMEMOImport}
+			case "log":
+				return CommandActionMsg{Action: ActionShowSecurityEvents}
+			case "enable", "disable", "default":
+				return CommandActionMsg{
+					Action: ActionSetEncryption,
+					Data:   map[string]interface{}{"mode": args[0]},
+				}
+			}
+			return nil
+
+		case "logging":
+			mode := ""
+			if len(args) > 0 {
+				mode = args[0]
+			}
+			return CommandActionMsg{
+				Action: ActionSetSaving,
+				Data:   map[string]interface{}{"mode": mode},
+			}
+
+		case "expire", "disappear":
+			duration := ""
+			if len(args) > 0 {
+				duration = args[0]
+			}
+			return CommandActionMsg{
+				Action: ActionSetExpiry,
+				Data:   map[string]interface{}{"duration": duration},
+			}
+
+		case "security":
+			return CommandActionMsg{Action: ActionShowSecurityEvents}
+
+		case "doctor":
+			target := ""
+			if len(args) > 0 {
+				target = args[0]
+			}
+			return CommandActionMsg{
+				Action: ActionRunDiagnostics,
+				Data:   map[string]interface{}{"jid": target},
+			}
+
+		case "open":
+			if len(args) == 0 {
+				return CommandActionMsg{
+					Action: ActionCommandError,
+					Data:   map[string]interface{}{"error": "Usage: :open <xmpp:uri>"},
+				}
+			}
+			return CommandActionMsg{
+				Action: ActionOpenURI,
+				Data:   map[string]interface{}{"uri": args[0]},
+			}
+
+		case "link":
+			target := ""
+			if len(args) > 0 {
+				target = args[0]
+			}
+			return CommandActionMsg{
+				Action: ActionShareLink,
+				Data:   map[string]interface{}{"target": target},
+			}
+
+		case "rooms":
+			service := ""
+			if len(args) > 0 {
+				service = args[0]
+			}
+			return CommandActionMsg{
+				Action: ActionBrowseRooms,
+				Data:   map[string]interface{}{"service": service},
+			}
+
+		case "snippets", "snippet":
+			return CommandActionMsg{Action: ActionShowSnippets}
+
+		case "queue", "outbox":
+			return CommandActionMsg{Action: ActionShowQueue}
+
+		case "notify":
+			mode := ""
+			if len(args) > 0 {
+				mode = args[0]
+			}
+			return CommandActionMsg{
+				Action: ActionSetRoomNotify,
+				Data:   map[string]interface{}{"mode": mode},
+			}
+
+		case "history":
+			size := ""
+			if len(args) > 0 {
+				size = args[0]
+			}
+			return CommandActionMsg{
+				Action: ActionSetRoomHistory,
+				Data:   map[string]interface{}{"size": size},
+			}
+
+		case "export":
+			return CommandActionMsg{
+				Action: ActionExportChat,
+				Data:   map[string]interface{}{"args": args},
+			}
+
+		case "version":
+			target := ""
+			if len(args) > 0 {
+				target = args[0]
+			}
+			return CommandActionMsg{
+				Action: ActionSoftwareVersion,
+				Data:   map[string]interface{}{"jid": target},
+			}
+
+		case "db":
+			return CommandActionMsg{Action: ActionDatabaseInfo}
+
+		case "ping":
+			target := ""
+			if len(args) > 0 {
+				target = args[0]
+			}
+			return CommandActionMsg{
+				Action: ActionPing,
+				Data:   map[string]interface{}{"jid": target},
+			}
+
+		case "bulk":
+			return CommandActionMsg{
+				Action: ActionBulk,
+				Data:   map[string]interface{}{"args": args},
+			}
+
+		case "participants", "occupants":
+			return CommandActionMsg{Action: ActionShowParticipants}
+
+		case "sound":
+			return CommandActionMsg{
+				Action: ActionSetSound,
+				Data:   map[string]interface{}{"sound": strings.Join(args, " ")},
+			}
+
+		case "decline":
+			return CommandActionMsg{Action: ActionDeclineCall}
+
+		case "preview":
+			return CommandActionMsg{
+				Action: ActionLinkPreview,
+				Data:   map[string]interface{}{"args": args},
+			}
+
 		case "register":
 			if len(args) >= 1 {
 				server := args[0]
@@ -1584,21 +2509,22 @@ func (a *App) ExecuteCommand(cmd string, args []string) tea.Cmd {
 			return CommandActionMsg{Action: ActionShowRegister}
 
 		default:
-			// Unknown command
-			return nil
+			// Plugin command, or unknown
+			return a.runPluginCommand(cmd, args)
 		}
 	}
 }
 
 // RemoveAccount removes an account from the configuration
 func (a *App) RemoveAccount(jid string) {
+	a.cancelReconnect(jid)
 	for i, acc := range a.accounts.Accounts {
 		if acc.JID == jid {
 			isSession := acc.Session
 			a.accounts.Accounts = append(a.accounts.Accounts[:i], a.accounts.Accounts[i+1:]...)
 			// Only save if it wasn't a session account
 			if !isSession {
-				_ = config.SaveAccounts(a.accounts)
+				_ = a.saveAccounts()
 			}
 			break
 		}
@@ -1630,8 +2556,8 @@ func (a *App) RemoveAccount(jid string) {
 	a.sendEvent(EventMsg{Type: EventRosterUpdate})
 	a.sendEvent(EventMsg{Type: EventPresence})
 	if a.storage != nil {
-		_ = a.storage.DeleteAppState(contactFavoritesStateKey(jid))
-		_ = a.storage.DeleteAppState(contactInteractionStateKey(jid))
+		_ = a.storage.DeleteContactMetadata(jid)
+		_ = a.storage.DeleteRoomSettings(jid)
 	}
 }
 
@@ -1642,7 +2568,7 @@ func (a *App) SetDefaultAccount(jid string) {
 			a.accounts.Accounts[i].AutoConnect = (a.accounts.Accounts[i].JID == jid)
 		}
 	}
-	_ = config.SaveAccounts(a.accounts)
+	_ = a.saveAccounts()
 }
 
 // SetAccountResource sets the resource (client identifier) for an account
@@ -1652,7 +2578,7 @@ func (a *App) SetAccountResource(jid, resource string) {
 			a.accounts.Accounts[i].Resource = resource
 			// Only save if it's not a session account
 			if !a.accounts.Accounts[i].Session {
-				_ = config.SaveAccounts(a.accounts)
+				_ = a.saveAccounts()
 			}
 			return
 		}
@@ -1666,51 +2592,313 @@ func (a *App) AddAccount(acc config.Account) {
 	for i, existing := range a.accounts.Accounts {
 		if existing.JID == acc.JID {
 			a.accounts.Accounts[i] = acc
-			_ = config.SaveAccounts(a.accounts)
+			_ = a.saveAccounts()
 			return
 		}
 	}
-	a.accounts.Accounts = append(a.accounts.Accounts, acc)
-	_ = config.SaveAccounts(a.accounts)
-}
+	a.accounts.Accounts = append(a.accounts.Accounts, acc)
+	_ = a.saveAccounts()
+}
+
+// AddSessionAccount adds a session-only account (not saved to disk)
+func (a *App) AddSessionAccount(acc config.Account) {
+	acc.Session = true
+	// Check if account already exists
+	for i, existing := range a.accounts.Accounts {
+		if existing.JID == acc.JID {
+			a.accounts.Accounts[i] = acc
+			return // Don't save session accounts
+		}
+	}
+	a.accounts.Accounts = append(a.accounts.Accounts, acc)
+	// Don't save to disk
+}
+
+// SetSetting sets a configuration setting
+func (a *App) SetSetting(key, value string) {
+	switch key {
+	case "theme":
+		a.cfg.UI.Theme = value
+	case "roster_width":
+		if w, err := strconv.Atoi(value); err == nil {
+			a.cfg.UI.RosterWidth = w
+		}
+	case "roster_position":
+		a.cfg.UI.RosterPosition = value
+	case "show_timestamps":
+		a.cfg.UI.ShowTimestamps = (value == "true" || value == "on" || value == "1")
+	case "time_format":
+		a.cfg.UI.TimeFormat = value
+	case "date_format":
+		a.cfg.UI.DateFormat = value
+	case "notifications":
+		a.cfg.UI.Notifications = (value == "true" || value == "on" || value == "1")
+	case "message_styling":
+		a.cfg.UI.MessageStyling = (value == "true" || value == "on" || value == "1")
+	case "window_list":
+		a.cfg.UI.WindowList = value
+	case "window_order":
+		a.cfg.UI.WindowOrder = value
+	case "roster_sort":
+		a.cfg.UI.RosterSort = value
+	case "roster_group":
+		a.cfg.UI.RosterGroup = value
+	case "which_key_delay":
+		if d, err := strconv.Atoi(value); err == nil {
+			a.cfg.UI.WhichKeyDelay = d
+		}
+	case "chat_wrap":
+		a.cfg.UI.ChatWrap = value
+	case "chat_layout":
+		if value == "default" || value == "theme" {
+			value = ""
+		}
+		a.cfg.UI.ChatLayout = value
+	case "chat_max_width":
+		if w, err := strconv.Atoi(value); err == nil && w >= 0 {
+			a.cfg.UI.ChatMaxWidth = w
+		}
+	case "nick_column":
+		if w, err := strconv.Atoi(value); err == nil && w >= 0 {
+			a.cfg.UI.NickColumn = w
+		}
+	case "low_bandwidth":
+		a.cfg.UI.LowBandwidth = (value == "true" || value == "on" || value == "1")
+	case "clipboard":
+		a.cfg.UI.Clipboard = value
+	case "terminal_title":
+		a.cfg.UI.TerminalTitle = (value == "true" || value == "on" || value == "1")
+	case "multiplexer_bell":
+		a.cfg.UI.MultiplexerBell = (value == "true" || value == "on" || value == "1")
+	case "away_on_blur":
+		if m, err := strconv.Atoi(value); err == nil && m >= 0 {
+			a.cfg.UI.AwayOnBlur = m
+		}
+	case "roster_columns":
+		a.cfg.UI.RosterColumns = nil
+		if value != "default" {
+			a.cfg.UI.RosterColumns = roster.ParseColumns(strings.Split(value, ","))
+		}
+	case "encryption", "default_encryption":
+		a.cfg.Encryption.Default = value
+	case "require_encryption":
+		a.cfg.Encryption.RequireEncryption = (value == "true" || value == "on" || value == "1")
+	case "track_presence_history":
+		a.cfg.Storage.TrackPresenceHistory = (value == "true" || value == "on" || value == "1")
+		if !a.cfg.Storage.TrackPresenceHistory {
+			_ = a.ClearPresenceHistory()
+		}
+	case "chat_logs":
+		a.cfg.ChatLogs.Enabled = (value == "true" || value == "on" || value == "1")
+		a.ApplyChatLogs()
+	case "version_reply":
+		a.cfg.SoftwareVersion.Reply = (value == "true" || value == "on" || value == "1")
+	case "version_show_os":
+		a.cfg.SoftwareVersion.ShowOS = (value == "true" || value == "on" || value == "1")
+	}
+	_ = config.Save(a.cfg)
+}
+
+// GetSnippets returns the snippets available to an account: global ones
+// overridden by the account's own set
+func (a *App) GetSnippets(accountJID string) map[string]string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	snippets := make(map[string]string, len(a.cfg.Snippets))
+	for name, text := range a.cfg.Snippets {
+		snippets[name] = text
+	}
+	if acc := a.GetAccount(accountJID); acc != nil {
+		for name, text := range acc.Snippets {
+			snippets[name] = text
+		}
+	}
+	return snippets
+}
+
+// IsAccountSnippet reports whether a snippet comes from the account's own set
+func (a *App) IsAccountSnippet(accountJID, name string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if acc := a.GetAccount(accountJID); acc != nil {
+		_, ok := acc.Snippets[name]
+		return ok
+	}
+	return false
+}
+
+// SetSnippet adds or replaces a snippet. With an account JID it is stored in
+// that account's set, otherwise in the global set.
+func (a *App) SetSnippet(accountJID, name, text string) error {
+	name = strings.TrimPrefix(strings.TrimSpace(name), ";")
+	if name == "" || strings.ContainsAny(name, " \t\n") {
+		return fmt.Errorf("snippet names must be a single word")
+	}
+	if text == "" {
+		return fmt.Errorf("snippet text is empty")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if accountJID != "" {
+		acc := a.GetAccount(accountJID)
+		if acc == nil {
+			return fmt.Errorf("unknown account %s", accountJID)
+		}
+		if acc.Snippets == nil {
+			acc.Snippets = make(map[string]string)
+		}
+		acc.Snippets[name] = text
+		if acc.Session {
+			return nil
+		}
+		return a.saveAccounts()
+	}
+
+	if a.cfg.Snippets == nil {
+		a.cfg.Snippets = make(map[string]string)
+	}
+	a.cfg.Snippets[name] = text
+	return config.Save(a.cfg)
+}
+
+// DeleteSnippet removes a snippet, preferring the account's own set
+func (a *App) DeleteSnippet(accountJID, name string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if acc := a.GetAccount(accountJID); acc != nil {
+		if _, ok := acc.Snippets[name]; ok {
+			delete(acc.Snippets, name)
+			if acc.Session {
+				return nil
+			}
+			return a.saveAccounts()
+		}
+	}
+	if _, ok := a.cfg.Snippets[name]; ok {
+		delete(a.cfg.Snippets, name)
+		return config.Save(a.cfg)
+	}
+	return fmt.Errorf("no snippet named %s", name)
+}
+
+// parseSound splits a notification sound into the number of terminal bells
+// and the command to run
+func parseSound(sound string) (bells int, command string, err error) {
+	switch {
+	case sound == "" || sound == "bell":
+		return 1, "", nil
+	case sound == "none":
+		return 0, "", nil
+	case strings.HasPrefix(sound, "bell:"):
+		n, err := strconv.Atoi(strings.TrimPrefix(sound, "bell:"))
+		if err != nil || n < 1 || n > 9 {
+			return 0, "", fmt.Errorf("bell count must be 1-9")
+		}
+		return n, "", nil
+	case strings.HasPrefix(sound, "exec:"):
+		command = strings.TrimSpace(strings.TrimPrefix(sound, "exec:"))
+		if command == "" {
+			return 0, "", fmt.Errorf("exec sound needs a command")
+		}
+		return 0, command, nil
+	}
+	return 0, "", fmt.Errorf("unknown sound %q (use bell, bell:N, none or exec:<command>)", sound)
+}
+
+// GetConversationSound returns the notification sound for a contact or room
+// and whether it was set for that conversation
+func (a *App) GetConversationSound(jid string) (string, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if sound, ok := a.cfg.Sounds.Conversations[jid]; ok {
+		return sound, true
+	}
+	if a.cfg.Sounds.Default == "" {
+		return "bell", false
+	}
+	return a.cfg.Sounds.Default, false
+}
+
+// SetConversationSound assigns a notification sound to a contact or room.
+// "default" or an empty sound removes the assignment.
+func (a *App) SetConversationSound(jid, sound string) error {
+	if jid == "" {
+		return fmt.Errorf("no conversation selected")
+	}
+	sound = strings.TrimSpace(sound)
+	if sound != "" && sound != "default" {
+		if _, _, err := parseSound(sound); err != nil {
+			return err
+		}
+	}
 
-// AddSessionAccount adds a session-only account (not saved to disk)
-func (a *App) AddSessionAccount(acc config.Account) {
-	acc.Session = true
-	// Check if account already exists
-	for i, existing := range a.accounts.Accounts {
-		if existing.JID == acc.JID {
-			a.accounts.Accounts[i] = acc
-			return // Don't save session accounts
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if sound == "" || sound == "default" {
+		delete(a.cfg.Sounds.Conversations, jid)
+	} else {
+		if a.cfg.Sounds.Conversations == nil {
+			a.cfg.Sounds.Conversations = make(map[string]string)
 		}
+		a.cfg.Sounds.Conversations[jid] = sound
 	}
-	a.accounts.Accounts = append(a.accounts.Accounts, acc)
-	// Don't save to disk
+	return config.Save(a.cfg)
 }
 
-// SetSetting sets a configuration setting
-func (a *App) SetSetting(key, value string) {
-	switch key {
-	case "theme":
-		a.cfg.UI.Theme = value
-	case "roster_width":
-		if w, err := strconv.Atoi(value); err == nil {
-			a.cfg.UI.RosterWidth = w
+// InMultiplexer reports whether roster runs inside tmux or screen
+func InMultiplexer() bool {
+	return os.Getenv("TMUX") != "" || os.Getenv("STY") != ""
+}
+
+// PlayNotificationSound announces an incoming message from a contact or room
+// with its assigned sound, when notifications are enabled. Without terminal
+// focus only the first message is announced.
+func (a *App) PlayNotificationSound(jid string) {
+	if a.holdNotification(jid) {
+		return
+	}
+
+	a.mu.RLock()
+	enabled := a.cfg.UI.Notifications
+	// Inside tmux or screen a bell flags roster's window in the status bar,
+	// so with multiplexer_bell there is one even when the sound is silent
+	flag := a.cfg.UI.MultiplexerBell && InMultiplexer()
+	a.mu.RUnlock()
+
+	bells := 0
+	if enabled {
+		sound, _ := a.GetConversationSound(jid)
+		n, command, err := parseSound(sound)
+		if err != nil {
+			return
 		}
-	case "roster_position":
-		a.cfg.UI.RosterPosition = value
-	case "show_timestamps":
-		a.cfg.UI.ShowTimestamps = (value == "true" || value == "on" || value == "1")
-	case "time_format":
-		a.cfg.UI.TimeFormat = value
-	case "notifications":
-		a.cfg.UI.Notifications = (value == "true" || value == "on" || value == "1")
-	case "encryption", "default_encryption":
-		a.cfg.Encryption.Default = value
-	case "require_encryption":
-		a.cfg.Encryption.RequireEncryption = (value == "true" || value == "on" || value == "1")
+		if command != "" {
+			cmd := exec.Command("sh", "-c", command)
+			if err := cmd.Start(); err == nil {
+				go func() { _ = cmd.Wait() }()
+			}
+		}
+		bells = n
 	}
-	_ = config.Save(a.cfg)
+	if bells == 0 && flag {
+		bells = 1
+	}
+	if bells == 0 {
+		return
+	}
+	go func() {
+		// Space the bells out so terminals don't merge them into one
+		for i := 0; i < bells; i++ {
+			if i > 0 {
+				time.Sleep(200 * time.Millisecond)
+			}
+			fmt.Fprint(os.Stdout, "\a")
+		}
+	}()
 }
 
 // GetSettings returns current settings as a map
@@ -1721,7 +2909,24 @@ func (a *App) GetSettings() map[string]string {
 		"roster_position":    a.cfg.UI.RosterPosition,
 		"show_timestamps":    strconv.FormatBool(a.cfg.UI.ShowTimestamps),
 		"time_format":        a.cfg.UI.TimeFormat,
+		"date_format":        a.cfg.UI.DateFormat,
 		"notifications":      strconv.FormatBool(a.cfg.UI.Notifications),
+		"message_styling":    strconv.FormatBool(a.cfg.UI.MessageStyling),
+		"window_list":        a.cfg.UI.WindowList,
+		"window_order":       a.cfg.UI.WindowOrder,
+		"roster_sort":        a.cfg.UI.RosterSort,
+		"roster_group":       a.cfg.UI.RosterGroup,
+		"which_key_delay":    strconv.Itoa(a.cfg.UI.WhichKeyDelay),
+		"chat_wrap":          a.cfg.UI.ChatWrap,
+		"chat_layout":        a.cfg.UI.ChatLayout,
+		"chat_max_width":     strconv.Itoa(a.cfg.UI.ChatMaxWidth),
+		"nick_column":        strconv.Itoa(a.cfg.UI.NickColumn),
+		"low_bandwidth":      strconv.FormatBool(a.cfg.UI.LowBandwidth),
+		"clipboard":          a.cfg.UI.Clipboard,
+		"terminal_title":     strconv.FormatBool(a.cfg.UI.TerminalTitle),
+		"multiplexer_bell":   strconv.FormatBool(a.cfg.UI.MultiplexerBell),
+		"away_on_blur":       strconv.Itoa(a.cfg.UI.AwayOnBlur),
+		"roster_columns":     strings.Join(roster.ParseColumns(a.cfg.UI.RosterColumns), ","),
 		"encryption":         a.cfg.Encryption.Default,
 		"require_encryption": strconv.FormatBool(a.cfg.Encryption.RequireEncryption),
 	}
@@ -1813,6 +3018,8 @@ type AccountDisplayInfo struct {
 	OMEMO       bool
 	Session     bool
 	AutoConnect bool
+	Step        string // while connecting: dialing, TLS, authentication...
+	Failure     string // after a failed attempt: why, in a few words
 }
 
 // GetAllAccountsDisplay returns ALL accounts with full display info
@@ -1840,6 +3047,16 @@ func (a *App) GetAllAccountsDisplay() []AccountDisplayInfo {
 			status = "offline"
 		}
 
+		var step, failure string
+		switch status {
+		case "connecting":
+			if attempt, ok := a.connecting[acc.JID]; ok {
+				step = attempt.step
+			}
+		case "failed":
+			failure = a.connectFailures[acc.JID]
+		}
+
 		// Calculate unread messages and chats per account
 		unreadMsgs := a.accountUnreads[acc.JID]
 		unreadChats := 0
@@ -1864,6 +3081,8 @@ func (a *App) GetAllAccountsDisplay() []AccountDisplayInfo {
 			OMEMO:       acc.OMEMO,
 			Session:     acc.Session,
 			AutoConnect: acc.AutoConnect,
+			Step:        step,
+			Failure:     failure,
 		})
 	}
 	return result
@@ -1960,6 +3179,23 @@ func (a *App) ClearContactUnread(accountJID, contactJID string) {
 	if a.storage != nil {
 		_ = a.storage.MarkRead(accountJID, contactJID)
 	}
+	a.sendReadMarker(accountJID, contactJID)
+}
+
+// ReadOnOtherDevice is sent when a conversation was read on another of the
+// account's devices
+type ReadOnOtherDevice struct {
+	AccountJID string
+	JID        string
+}
+
+// handleReadOnOtherDevice clears the unread count of a conversation that
+// was read on another device, so it does not stay bold here
+func (a *App) handleReadOnOtherDevice(accountJID, contactJID string) {
+	// The other device told the contact already
+	a.takeMarkable(accountJID, contactJID)
+	a.ClearContactUnread(accountJID, contactJID)
+	a.sendEvent(EventMsg{Type: EventReadOnOtherDevice, Data: ReadOnOtherDevice{AccountJID: accountJID, JID: contactJID}})
 }
 
 // EnsureContactInRosterForAccount makes sure a sender exists in the in-memory roster for an account.
@@ -1995,6 +3231,222 @@ func (a *App) EnsureContactInRosterForAccount(accountJID, contactJID string) {
 	}
 }
 
+// mapShowToStatus converts an incoming XMPP presence to a roster status string
+func mapShowToStatus(presenceType, show string) string {
+	if presenceType == "unavailable" {
+		return "offline"
+	}
+	switch show {
+	case "chat", "away", "dnd", "xa":
+		return show
+	default:
+		return "online"
+	}
+}
+
+// contactStatus returns the status of a contact after one of its clients
+// sent presence: that of the highest priority client still online, and
+// offline only once none is left
+func contactStatus(p client.Presence, resources []client.Resource) (string, string) {
+	if len(resources) > 0 {
+		return mapShowToStatus("", resources[0].Show), resources[0].Status
+	}
+	if p.From.Resource() == "" {
+		// Presence from the bare JID is not tracked per resource
+		return mapShowToStatus(p.Type, p.Show), p.Status
+	}
+	return "offline", p.Status
+}
+
+// UpdateContactPresenceForAccount applies a contact's presence to the account's
+// roster and records when they were last seen going offline.
+func (a *App) UpdateContactPresenceForAccount(accountJID, contactJID, status, statusMsg string) {
+	if accountJID == "" || contactJID == "" {
+		return
+	}
+
+	a.mu.Lock()
+	wasOnline := false
+	for i := range a.rosters {
+		if a.rosters[i].AccountJID != accountJID || a.rosters[i].JID != contactJID {
+			continue
+		}
+		wasOnline = a.rosters[i].Status != "" && a.rosters[i].Status != "offline"
+		a.rosters[i].Status = status
+		a.rosters[i].StatusMsg = statusMsg
+	}
+	a.mu.Unlock()
+
+	if isOnline := status != "offline"; isOnline != wasOnline {
+		a.recordPresenceTransition(accountJID, contactJID, status)
+	}
+
+	if status == "offline" && wasOnline {
+		if err := a.SaveContactLastPresenceForAccount(accountJID, contactJID, status, statusMsg); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to save last presence for %s: %v\n", contactJID, err)
+		}
+	}
+
+	a.sendEvent(EventMsg{Type: EventPresence, Data: PresenceUpdate{
+		AccountJID: accountJID,
+		JID:        contactJID,
+		Status:     status,
+		StatusMsg:  statusMsg,
+	}})
+	a.pluginAPI.EmitPresence(contactJID, status)
+}
+
+// presenceHistoryRetention is how long presence transitions are kept
+const presenceHistoryRetention = 30 * 24 * time.Hour
+
+// recordPresenceTransition stores a contact going online or offline, unless
+// presence tracking is disabled
+func (a *App) recordPresenceTransition(accountJID, contactJID, status string) {
+	if a.storage == nil || !a.cfg.Storage.TrackPresenceHistory {
+		return
+	}
+	if err := a.storage.AddPresenceTransition(accountJID, contactJID, status, time.Now()); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record presence history for %s: %v\n", contactJID, err)
+	}
+}
+
+// closePresenceHistory marks contacts that were online as offline once we
+// lose the connection, since we can no longer observe them
+func (a *App) closePresenceHistory(accountJID string) {
+	a.mu.RLock()
+	var online []string
+	for _, r := range a.rosters {
+		if r.AccountJID == accountJID && r.Status != "" && r.Status != "offline" {
+			online = append(online, r.JID)
+		}
+	}
+	a.mu.RUnlock()
+
+	for _, contactJID := range online {
+		a.recordPresenceTransition(accountJID, contactJID, "offline")
+	}
+}
+
+// ClearPresenceHistory deletes all recorded presence transitions
+func (a *App) ClearPresenceHistory() error {
+	if a.storage == nil {
+		return nil
+	}
+	return a.storage.DeletePresenceHistory(time.Time{})
+}
+
+// PresenceActivity summarizes when a contact has been available
+type PresenceActivity struct {
+	Last24h    []float64 // Fraction online per hour, oldest first
+	Last7d     []float64 // Fraction online per day, oldest first
+	UsualHours string    // e.g. "9–17", empty when there is no clear pattern
+}
+
+// GetPresenceActivity returns a contact's availability over the last day and
+// week, or nil if tracking is disabled or nothing was recorded
+func (a *App) GetPresenceActivity(accountJID, contactJID string) *PresenceActivity {
+	if a.storage == nil || !a.cfg.Storage.TrackPresenceHistory || accountJID == "" {
+		return nil
+	}
+
+	now := time.Now()
+	weekStart := now.Add(-7 * 24 * time.Hour)
+	transitions, err := a.storage.GetPresenceTransitions(accountJID, contactJID, weekStart)
+	if err != nil || len(transitions) == 0 {
+		return nil
+	}
+
+	spans := onlineSpans(transitions, now)
+	activity := &PresenceActivity{
+		Last24h: make([]float64, 24),
+		Last7d:  make([]float64, 7),
+	}
+	dayStart := now.Add(-24 * time.Hour)
+	for i := range activity.Last24h {
+		start := dayStart.Add(time.Duration(i) * time.Hour)
+		activity.Last24h[i] = onlineFraction(spans, start, start.Add(time.Hour))
+	}
+	for i := range activity.Last7d {
+		start := weekStart.Add(time.Duration(i) * 24 * time.Hour)
+		activity.Last7d[i] = onlineFraction(spans, start, start.Add(24*time.Hour))
+	}
+	activity.UsualHours = usualHours(spans, weekStart, now)
+	return activity
+}
+
+type presenceSpan struct {
+	start, end time.Time
+}
+
+// onlineSpans turns ordered transitions into the periods the contact was online
+func onlineSpans(transitions []sqlite.PresenceTransition, now time.Time) []presenceSpan {
+	var spans []presenceSpan
+	var onlineSince time.Time
+	for _, t := range transitions {
+		online := t.Status != "offline"
+		if online && onlineSince.IsZero() {
+			onlineSince = t.Timestamp
+		} else if !online && !onlineSince.IsZero() {
+			spans = append(spans, presenceSpan{onlineSince, t.Timestamp})
+			onlineSince = time.Time{}
+		}
+	}
+	if !onlineSince.IsZero() {
+		spans = append(spans, presenceSpan{onlineSince, now})
+	}
+	return spans
+}
+
+// onlineFraction returns how much of [start, end) is covered by spans
+func onlineFraction(spans []presenceSpan, start, end time.Time) float64 {
+	var online time.Duration
+	for _, sp := range spans {
+		s, e := sp.start, sp.end
+		if s.Before(start) {
+			s = start
+		}
+		if e.After(end) {
+			e = end
+		}
+		if e.After(s) {
+			online += e.Sub(s)
+		}
+	}
+	return float64(online) / float64(end.Sub(start))
+}
+
+// usualHours finds the longest run of local hours in which the contact was
+// online at least half of the time over the given range
+func usualHours(spans []presenceSpan, from, to time.Time) string {
+	var hours [24]float64
+	var samples [24]int
+	for t := from.Truncate(time.Hour); t.Before(to); t = t.Add(time.Hour) {
+		h := t.Local().Hour()
+		hours[h] += onlineFraction(spans, t, t.Add(time.Hour))
+		samples[h]++
+	}
+
+	bestStart, bestLen := -1, 0
+	runStart, runLen := -1, 0
+	for h := 0; h < 24; h++ {
+		if samples[h] > 0 && hours[h]/float64(samples[h]) >= 0.5 {
+			if runLen == 0 {
+				runStart = h
+			}
+			runLen++
+			if runLen > bestLen {
+				bestStart, bestLen = runStart, runLen
+			}
+		} else {
+			runLen = 0
+		}
+	}
+	if bestLen == 0 || bestLen == 24 {
+		return ""
+	}
+	return fmt.Sprintf("%d–%d", bestStart, bestStart+bestLen)
+}
+
 // SwitchActiveAccount switches to a different account
 func (a *App) SwitchActiveAccount(jid string) {
 	if jid != "" {
@@ -2031,10 +3483,11 @@ func (a *App) SwitchActiveAccount(jid string) {
 
 // WindowState represents a saved window
 type WindowState struct {
-	Type   string `json:"type"`   // "console", "chat", "muc"
-	JID    string `json:"jid"`    // JID for chat/muc windows
-	Title  string `json:"title"`  // Window title
-	Active bool   `json:"active"` // Whether this was the active window
+	Type   string `json:"type"`           // "console", "chat", "muc"
+	JID    string `json:"jid"`            // JID for chat/muc windows
+	Title  string `json:"title"`          // Window title
+	Name   string `json:"name,omitempty"` // Custom name set with :title
+	Active bool   `json:"active"`         // Whether this was the active window
 }
 
 // SaveWindowState saves the current window state to a file
@@ -2077,9 +3530,13 @@ func (a *App) LoadWindowState() ([]WindowState, error) {
 	return windows, nil
 }
 
-// doConnect performs the actual XMPP connection
-func (a *App) doConnect(jidStr, password, server string, port int, isSession bool) tea.Cmd {
+// doConnect performs the actual XMPP connection. A background connection
+// only becomes the current account when there is none.
+func (a *App) doConnect(jidStr, password, server string, port int, isSession, background bool) tea.Cmd {
+	ctx, attempt := a.startConnect(jidStr)
 	return func() tea.Msg {
+		defer a.finishConnect(jidStr, attempt)
+
 		// Check if already connected
 		a.mu.RLock()
 		if client, exists := a.clients[jidStr]; exists && client.IsConnected() {
@@ -2101,8 +3558,11 @@ func (a *App) doConnect(jidStr, password, server string, port int, isSession boo
 
 		// Set connecting status
 		a.mu.Lock()
-		a.status = "connecting"
-		a.currentAccount = jidStr
+		current := !background || a.currentAccount == "" || a.currentAccount == jidStr
+		if current {
+			a.status = "connecting"
+			a.currentAccount = jidStr
+		}
 		a.accountStatuses[jidStr] = "connecting" // Update account-specific status
 		// NOTE: We no longer disconnect existing clients - true multi-account support
 		// Each account maintains its own connection in the clients map
@@ -2117,19 +3577,32 @@ func (a *App) doConnect(jidStr, password, server string, port int, isSession boo
 		a.sendEvent(EventMsg{Type: EventPresence})
 
 		// Create new client
-		newClient, err := client.NewClient(client.ClientConfig{
+		clientCfg := client.ClientConfig{
 			JID:      jidStr,
 			Password: password,
 			Server:   server,
 			Port:     port,
 			Resource: "roster",
-		})
+		}
+		if acc := a.GetAccount(jidStr); acc != nil {
+			clientCfg.KeepAliveInterval = time.Duration(acc.KeepAliveInterval) * time.Second
+			clientCfg.PingInterval = time.Duration(acc.PingInterval) * time.Second
+			clientCfg.PingTimeout = time.Duration(acc.PingTimeout) * time.Second
+		}
+		clientCfg.Traffic = a.trafficStats(jidStr)
+		if a.storage != nil {
+			clientCfg.OMEMODB = a.storage.SQL()
+		}
+		newClient, err := client.NewClient(clientCfg)
 		if err != nil {
 			a.mu.Lock()
-			a.connected = false
-			a.status = "failed"
+			if current {
+				a.connected = false
+				a.status = "failed"
+			}
 			a.accountStatuses[jidStr] = "failed"
 			a.mu.Unlock()
+			a.setConnectFailure(jidStr, err)
 			a.sendEvent(EventMsg{Type: EventRosterLoading, Data: RosterLoadingUpdate{AccountJID: jidStr, Loading: false}})
 			return ConnectResultMsg{
 				Success: false,
@@ -2139,20 +3612,54 @@ func (a *App) doConnect(jidStr, password, server string, port int, isSession boo
 		}
 
 		// Set up handlers
+		newClient.SetConnectStepHandler(func(step string) {
+			a.setConnectStep(jidStr, attempt, step)
+		})
+
+		newClient.SetVersionHandler(a.softwareVersionReply)
+		newClient.SetLastActivityHandler(func(from jid.JID) *time.Duration {
+			return a.lastActivityReply(jidStr, from)
+		})
+
 		newClient.SetConnectHandler(func() {
 			a.sendEvent(EventMsg{Type: EventConnected})
+			a.pluginAPI.EmitConnect()
 			go a.syncMAMForChats(jidStr, newClient)
+			go a.refreshArchivePrefs(jidStr)
 		})
 
 		newClient.SetDisconnectHandler(func(err error) {
+			a.noteDisconnected(jidStr, err != nil)
+			// Without a reconnect coming the account stays failed with
+			// the reason in the account list
+			status := "offline"
+			if err != nil && reconnectUseless(err) {
+				status = "failed"
+			}
 			a.mu.Lock()
 			a.connected = false
 			a.status = "offline"
-			a.accountStatuses[jidStr] = "offline"
+			a.accountStatuses[jidStr] = status
 			delete(a.clients, jidStr)
 			a.mu.Unlock()
+			if status == "failed" {
+				a.setConnectFailure(jidStr, err)
+			}
+			a.closePresenceHistory(jidStr)
+			a.clearRoomPresence(jidStr)
+			a.forgetArchivePrefs(jidStr)
 			a.sendEvent(EventMsg{Type: EventRosterLoading, Data: RosterLoadingUpdate{AccountJID: jidStr, Loading: false}})
 			a.sendEvent(EventMsg{Type: EventDisconnected, Data: err})
+			a.pluginAPI.EmitDisconnect()
+			var streamErr *client.StreamError
+			if errors.As(err, &streamErr) {
+				// The server said why it closed the stream
+				a.sendEvent(EventMsg{Type: EventError, Data: DescribeError(err)})
+			}
+			if err != nil && !reconnectUseless(err) {
+				// Connection was lost rather than closed by the user.
+				a.scheduleReconnect(jidStr, err)
+			}
 		})
 
 		newClient.SetErrorHandler(func(err error) {
@@ -2213,6 +3720,22 @@ func (a *App) doConnect(jidStr, password, server string, port int, isSession boo
 				Encrypted:   msg.Encrypted,
 				Outgoing:    outgoing,
 				CorrectedID: msg.CorrectedID,
+				JSON:        msg.JSON,
+				FileURL:     msg.FileURL,
+			}
+			if strings.TrimSpace(chatMsg.Body) == "" && msg.JSON != "" {
+				// Keep JSON-only payloads in history as their body
+				chatMsg.Body = msg.JSON
+			}
+			if a.matchReflection(jidStr, contactJID, msg) {
+				return
+			}
+			if msg.Subject != "" && strings.TrimSpace(msg.Body) == "" {
+				a.setRoomSubject(jidStr, contactJID, msg.From.Resource(), msg.Subject)
+				return
+			}
+			if !outgoing && !a.screenIncomingMessage(jidStr, contactJID) {
+				return
 			}
 			a.EnsureContactInRosterForAccount(jidStr, contactJID)
 			if chatMsg.CorrectedID != "" {
@@ -2232,9 +3755,13 @@ func (a *App) doConnect(jidStr, password, server string, port int, isSession boo
 					a.IncrementContactUnread(jidStr, contactJID)
 				}
 				a.AddChatMessageForAccount(jidStr, contactJID, chatMsg)
+				a.emitPluginMessage(jidStr, contactJID, chatMsg)
 			}
 
-			if msg.ID != "" && !outgoing && chatMsg.Body != "" && msg.ReceiptRequested {
+			if msg.ID != "" && !outgoing && chatMsg.Body != "" && msg.Markable && msg.Type != "groupchat" {
+				a.noteMarkable(jidStr, contactJID, msg.ID)
+			}
+			if msg.ID != "" && !outgoing && chatMsg.Body != "" && msg.ReceiptRequested && a.PrivacyEnabled(jidStr, PrivacyReceipts) {
 				receiptTo := contactJID
 				go func(to, messageID string) {
 					_ = newClient.SendReceipt(to, messageID)
@@ -2242,6 +3769,57 @@ func (a *App) doConnect(jidStr, password, server string, port int, isSession boo
 			}
 		})
 
+		newClient.SetPresenceHandler(func(p client.Presence) {
+			if p.From.IsZero() {
+				return
+			}
+			if p.Type == "subscribe" {
+				a.handleSubscriptionRequest(jidStr, p.From.Bare().String())
+				return
+			}
+			a.handleRoomPresence(jidStr, p)
+			a.handleMUCPresence(jidStr, p)
+			// Other subscription stanzas and errors are not availability updates.
+			if p.Type != "" && p.Type != "unavailable" {
+				return
+			}
+			contactJID := p.From.Bare().String()
+			if parsed, err := jid.Parse(jidStr); err == nil && parsed.Bare().String() == contactJID {
+				return
+			}
+			status, statusMsg := contactStatus(p, newClient.Resources(contactJID))
+			a.UpdateContactPresenceForAccount(jidStr, contactJID, status, statusMsg)
+		})
+
+		newClient.SetChatStateHandler(func(from jid.JID, state string) {
+			contactJID := from.Bare().String()
+			if parsed, err := jid.Parse(jidStr); err == nil && parsed.Bare().String() == contactJID {
+				return
+			}
+			a.sendEvent(EventMsg{Type: EventTyping, Data: TypingUpdate{
+				AccountJID: jidStr,
+				JID:        contactJID,
+				State:      state,
+				Typing:     state == "composing",
+			}})
+		})
+
+		newClient.SetCallHandler(func(call client.CallEvent) {
+			a.handleCall(jidStr, call)
+		})
+
+		newClient.SetVoiceRequestHandler(func(req client.VoiceRequest) {
+			a.handleVoiceRequest(jidStr, req)
+		})
+
+		newClient.SetOMEMODeviceHandler(func(contactJID string, deviceID uint32, identityKey []byte, changed bool) {
+			a.handleOMEMODevice(jidStr, contactJID, deviceID, formatFingerprint(identityKey))
+		})
+
+		newClient.SetReadOnOtherDeviceHandler(func(peer jid.JID, messageID string) {
+			a.handleReadOnOtherDevice(jidStr, peer.String())
+		})
+
 		newClient.SetReceiptHandler(func(messageID string, status string) {
 			accountPrefix := jidStr + "|"
 			a.mu.RLock()
@@ -2260,20 +3838,6 @@ func (a *App) doConnect(jidStr, password, server string, port int, isSession boo
 					break
 				}
 			}
-			if contactJID == "" {
-				// Backward compatibility for older in-memory key format.
-				for key, messages := range a.chatHistory {
-					for _, msg := range messages {
-						if msg.ID == messageID {
-							contactJID = historyContactFromKey(key)
-							break
-						}
-					}
-					if contactJID != "" {
-						break
-					}
-				}
-			}
 			a.mu.RUnlock()
 
 			if contactJID != "" {
@@ -2337,41 +3901,69 @@ func (a *App) doConnect(jidStr, password, server string, port int, isSession boo
 					existingByJID[itemJID] = len(a.rosters) - 1
 				}
 			}
+			replay, conflicts := a.syncRosterEditsLocked(accountJID, items)
+			a.overlayRosterEditsLocked(accountJID)
 
 			a.mu.Unlock()
 
+			for _, conflict := range conflicts {
+				a.sendEvent(EventMsg{Type: EventRosterConflict, Data: conflict})
+			}
+			if len(replay) > 0 {
+				go a.replayRosterEdits(newClient, replay)
+			}
+
 			a.saveRosterCacheForAccount(accountJID)
 			a.sendEvent(EventMsg{Type: EventRosterUpdate})
 			a.sendEvent(EventMsg{Type: EventRosterLoading, Data: RosterLoadingUpdate{AccountJID: accountJID, Loading: false}})
+			go a.addImportedContacts(accountJID)
 		})
 
-		if err := newClient.Connect(); err != nil {
+		if err := newClient.ConnectContext(ctx); err != nil {
+			cancelled := errors.Is(err, client.ErrConnectCancelled)
+			status := "failed"
+			if cancelled {
+				status = "offline"
+			}
 			a.mu.Lock()
-			a.connected = false
-			a.status = "failed"
-			a.accountStatuses[jidStr] = "failed"
+			if current {
+				a.connected = false
+				a.status = status
+			}
+			a.accountStatuses[jidStr] = status
 			a.mu.Unlock()
+			if !cancelled {
+				a.setConnectFailure(jidStr, err)
+			}
 			a.sendEvent(EventMsg{Type: EventRosterLoading, Data: RosterLoadingUpdate{AccountJID: jidStr, Loading: false}})
 			return ConnectResultMsg{
-				Success: false,
-				JID:     jidStr,
-				Error:   err.Error(),
+				Success:   false,
+				Cancelled: cancelled,
+				JID:       jidStr,
+				Error:     err.Error(),
+				Err:       err,
 			}
 		}
 
+		a.noteConnected(jidStr)
 		a.mu.Lock()
-		a.xmppClient = newClient
+		delete(a.reconnectAttempts, jidStr)
 		a.clients[jidStr] = newClient
-		a.connected = true
-		a.currentAccount = jidStr
-		a.status = "online"
+		// Another account may have become current meanwhile
+		if !background || a.currentAccount == "" || a.currentAccount == jidStr {
+			a.xmppClient = newClient
+			a.connected = true
+			a.currentAccount = jidStr
+			a.status = "online"
+		}
 		a.accountStatuses[jidStr] = "online"
 		statusMsg := a.statusMsg
 		a.mu.Unlock()
 
 		// Announce initial presence after bind so the server can route
 		// realtime messages to this resource.
-		if err := newClient.SendPresence("", statusMsg); err != nil {
+		a.loadStatusSharingForAccount(jidStr)
+		if err := a.sendPresence(jidStr, newClient, "", statusMsg); err != nil {
 			a.sendEvent(EventMsg{
 				Type: EventError,
 				Data: "Failed to send initial presence: " + err.Error(),
@@ -2398,6 +3990,8 @@ func (a *App) doConnect(jidStr, password, server string, port int, isSession boo
 			}
 		}()
 
+		go a.flushOutgoingQueue(jidStr)
+
 		return ConnectResultMsg{
 			Success: true,
 			JID:     jidStr,
@@ -2422,9 +4016,86 @@ func (a *App) DoConnect(jidStr string) tea.Cmd {
 				Error:   "Account not found",
 			}
 		}
-	}
+	}
+
+	return a.doConnect(acc.JID, acc.Password, acc.Server, acc.Port, acc.Session, false)
+}
+
+const (
+	reconnectBaseDelay = 5 * time.Second
+	reconnectMaxDelay  = 5 * time.Minute
+)
+
+// reconnectDelay returns the exponential backoff delay for a reconnect attempt
+func reconnectDelay(attempt int) time.Duration {
+	delay := reconnectBaseDelay
+	for i := 0; i < attempt && delay < reconnectMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > reconnectMaxDelay {
+		delay = reconnectMaxDelay
+	}
+	return delay
+}
+
+// scheduleReconnect retries the connection for an account after it was lost
+// with err, backing off exponentially between failed attempts. When err asks
+// for a longer wait the backoff starts there.
+func (a *App) scheduleReconnect(jidStr string, err error) {
+	acc := a.GetAccount(jidStr)
+	if acc == nil || acc.Password == "" {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, pending := a.reconnectTimers[jidStr]; pending {
+		return
+	}
+	attempt := a.reconnectAttempts[jidStr]
+	for wait := reconnectWait(err); reconnectDelay(attempt) < wait; {
+		attempt++
+	}
+	a.reconnectAttempts[jidStr] = attempt + 1
+	a.reconnectTimers[jidStr] = time.AfterFunc(reconnectDelay(attempt), func() {
+		a.mu.Lock()
+		if _, pending := a.reconnectTimers[jidStr]; !pending {
+			a.mu.Unlock()
+			return
+		}
+		delete(a.reconnectTimers, jidStr)
+		a.mu.Unlock()
+
+		msg := a.ConnectInBackground(jidStr)()
+		if result, ok := msg.(ConnectResultMsg); ok && !result.Success {
+			if reconnectUseless(result.Err) {
+				// Retrying cannot help; tell the user instead
+				a.cancelReconnect(jidStr)
+				if a.program != nil {
+					a.program.Send(msg)
+				}
+				return
+			}
+			if !result.Cancelled {
+				a.scheduleReconnect(jidStr, result.Err)
+			}
+			return
+		}
+		if a.program != nil {
+			a.program.Send(msg)
+		}
+	})
+}
 
-	return a.doConnect(acc.JID, acc.Password, acc.Server, acc.Port, acc.Session)
+// cancelReconnect stops any pending automatic reconnect for an account
+func (a *App) cancelReconnect(jidStr string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if t, ok := a.reconnectTimers[jidStr]; ok {
+		t.Stop()
+		delete(a.reconnectTimers, jidStr)
+	}
+	delete(a.reconnectAttempts, jidStr)
 }
 
 // Disconnect disconnects from the XMPP server (legacy - disconnects current account)
@@ -2446,6 +4117,8 @@ func (a *App) DoDisconnect(jidStr string) tea.Cmd {
 			}
 		}
 
+		a.cancelReconnect(jidStr)
+
 		// Get client reference while holding lock
 		a.mu.Lock()
 		client, exists := a.clients[jidStr]
@@ -2467,7 +4140,10 @@ func (a *App) DoDisconnect(jidStr string) tea.Cmd {
 			_ = client.Disconnect()
 		}
 
+		a.clearRoomPresence(jidStr)
+		a.forgetArchivePrefs(jidStr)
 		a.sendEvent(EventMsg{Type: EventDisconnected})
+		a.pluginAPI.EmitDisconnect()
 		a.sendEvent(EventMsg{Type: EventRosterLoading, Data: RosterLoadingUpdate{AccountJID: jidStr, Loading: false}})
 		return DisconnectResultMsg{
 			Success: true,
@@ -2488,14 +4164,6 @@ func (a *App) AddContactForAccount(accountJID, contactJID, name, group string) e
 		return fmt.Errorf("no account selected")
 	}
 
-	a.mu.RLock()
-	client := a.clients[accountJID]
-	a.mu.RUnlock()
-
-	if client == nil || !client.IsConnected() {
-		return fmt.Errorf("account not connected: %s", accountJID)
-	}
-
 	contactJID = strings.TrimSpace(contactJID)
 	name = strings.TrimSpace(name)
 	group = strings.TrimSpace(group)
@@ -2513,20 +4181,29 @@ func (a *App) AddContactForAccount(accountJID, contactJID, name, group string) e
 	if contactJID == "" {
 		return fmt.Errorf("invalid contact JID")
 	}
-	if client.JID().Bare().String() == contactJID {
+	if bareJIDString(accountJID) == contactJID {
 		return fmt.Errorf("cannot add your own JID to roster")
 	}
 
+	// Adding a contact that only wrote to us keeps what is known of it
 	groups := parseGroupInput(group)
-
-	// Add to roster
-	if err := client.AddContact(contactJID, name, groups); err != nil {
-		return fmt.Errorf("failed to add contact: %w", err)
+	knownName, knownGroups := a.rosterItem(accountJID, contactJID)
+	if name == "" {
+		name = knownName
+	}
+	if len(groups) == 0 {
+		groups = knownGroups
 	}
 
-	// Send subscription request
-	if err := client.Subscribe(contactJID); err != nil {
-		return fmt.Errorf("failed to subscribe: %w", err)
+	// Shown at once; sent now, or when the account connects
+	if _, err := a.editRoster(RosterEdit{
+		AccountJID: accountJID,
+		JID:        contactJID,
+		Name:       name,
+		Groups:     groups,
+		Subscribe:  true,
+	}); err != nil {
+		return fmt.Errorf("failed to add contact: %w", err)
 	}
 
 	return nil
@@ -2569,82 +4246,16 @@ func (a *App) DoAddContactForAccount(accountJID, contactJID, name, group string)
 			}
 		}
 
-		// Optimistically update local roster so the new contact appears
-		// immediately, even before server roster push arrives.
-		a.addContactToLocalRoster(accountJID, contactJID, name, group)
-
 		return AddContactResultMsg{
 			Success:    true,
 			AccountJID: accountJID,
 			JID:        contactJID,
 			Name:       name,
+			Queued:     a.rosterEditQueued(accountJID, bareJIDString(strings.TrimSpace(contactJID))),
 		}
 	}
 }
 
-// addContactToLocalRoster adds a contact to local roster immediately (optimistic update)
-func (a *App) addContactToLocalRoster(accountJID, contactJID, name, group string) {
-	contactJID = strings.TrimSpace(contactJID)
-	if parsed, err := jid.Parse(contactJID); err == nil {
-		contactJID = parsed.Bare().String()
-	}
-	name = strings.TrimSpace(name)
-	accountJID = strings.TrimSpace(accountJID)
-	if accountJID == "" {
-		a.mu.RLock()
-		accountJID = a.currentAccount
-		a.mu.RUnlock()
-	}
-
-	a.mu.Lock()
-	groups := parseGroupInput(group)
-
-	// If an incoming-only contact already exists, promote it to a real roster
-	// entry and merge optional metadata.
-	for i := range a.rosters {
-		if a.rosters[i].AccountJID != accountJID || a.rosters[i].JID != contactJID {
-			continue
-		}
-		if name != "" {
-			a.rosters[i].Name = name
-		}
-		if len(groups) > 0 {
-			a.rosters[i].Groups = groups
-		}
-		a.rosters[i].AddedToRoster = true
-		if a.rosters[i].Subscription == "" || a.rosters[i].Subscription == "none" {
-			a.rosters[i].Subscription = "none"
-		}
-		a.mu.Unlock()
-		a.saveRosterCacheForAccount(accountJID)
-		a.sendEvent(EventMsg{Type: EventRosterUpdate})
-		return
-	}
-
-	if a.statusSharing == nil {
-		a.statusSharing = make(map[string]bool)
-	}
-
-	newContact := roster.Roster{
-		JID:           contactJID,
-		Name:          name,
-		Groups:        groups,
-		Status:        "offline",
-		AccountJID:    accountJID,
-		AddedToRoster: true,
-		Subscription:  "none",
-	}
-
-	a.rosters = append(a.rosters, newContact)
-
-	a.statusSharing[contactJID] = true
-
-	a.mu.Unlock()
-
-	a.saveRosterCacheForAccount(accountJID)
-	a.sendEvent(EventMsg{Type: EventRosterUpdate})
-}
-
 func parseGroupInput(group string) []string {
 	if group == "" {
 		return nil
@@ -2749,6 +4360,10 @@ func (a *App) syncMAMForChats(accountJID string, client *client.Client) {
 	a.sendEvent(EventMsg{Type: EventMAMSyncing, Data: true})
 
 	for jid := range uniqueJIDs {
+		// The archive is only fetched to fill in local history
+		if !a.SavingEnabled(accountJID, jid) {
+			continue
+		}
 		sync, err := a.storage.GetMAMSync(accountJID, jid)
 		if err != nil {
 			continue
@@ -2867,18 +4482,32 @@ func (a *App) GetPresenceForContact(contactJID string) (show, statusMsg string)
 
 // SaveContactLastPresence saves the contact's last known presence when they go offline
 func (a *App) SaveContactLastPresence(contactJID, show, statusMsg string) error {
-	// TODO: Save to storage when storage is integrated
-	_ = contactJID
-	_ = show
-	_ = statusMsg
-	return nil
+	return a.SaveContactLastPresenceForAccount(a.CurrentAccount(), contactJID, show, statusMsg)
+}
+
+// SaveContactLastPresenceForAccount saves the contact's last known presence for a specific account
+func (a *App) SaveContactLastPresenceForAccount(accountJID, contactJID, show, statusMsg string) error {
+	if a.storage == nil || accountJID == "" {
+		return nil
+	}
+	return a.storage.SaveContactLastPresence(accountJID, contactJID, show, statusMsg)
 }
 
 // GetContactLastPresence gets the contact's last known presence
 func (a *App) GetContactLastPresence(contactJID string) (show, statusMsg string, lastSeen time.Time) {
-	// TODO: Load from storage when storage is integrated
-	_ = contactJID
-	return "", "", time.Time{}
+	return a.GetContactLastPresenceForAccount(a.CurrentAccount(), contactJID)
+}
+
+// GetContactLastPresenceForAccount gets the contact's last known presence for a specific account
+func (a *App) GetContactLastPresenceForAccount(accountJID, contactJID string) (show, statusMsg string, lastSeen time.Time) {
+	if a.storage == nil || accountJID == "" {
+		return "", "", time.Time{}
+	}
+	show, statusMsg, lastSeen, err := a.storage.GetContactLastPresence(accountJID, contactJID)
+	if err != nil {
+		return "", "", time.Time{}
+	}
+	return show, statusMsg, lastSeen
 }
 
 // ToggleAccountAutoConnect toggles the auto-connect setting for an account
@@ -2886,7 +4515,7 @@ func (a *App) ToggleAccountAutoConnect(jid string) bool {
 	for i := range a.accounts.Accounts {
 		if a.accounts.Accounts[i].JID == jid && !a.accounts.Accounts[i].Session {
 			a.accounts.Accounts[i].AutoConnect = !a.accounts.Accounts[i].AutoConnect
-			_ = config.SaveAccounts(a.accounts)
+			_ = a.saveAccounts()
 			return a.accounts.Accounts[i].AutoConnect
 		}
 	}
@@ -2910,6 +4539,16 @@ func (a *App) getConnectedClient(accountJID string) *client.Client {
 	return nil
 }
 
+// GetTLSInfo returns how the connection of an account is encrypted. It
+// reports false when the account is offline.
+func (a *App) GetTLSInfo(accountJID string) (client.TLSInfo, bool) {
+	c := a.getConnectedClient(accountJID)
+	if c == nil {
+		return client.TLSInfo{}, false
+	}
+	return c.TLSInfo()
+}
+
 // IsAccountConnected checks if a specific account is connected
 func (a *App) IsAccountConnected(accountJID string) bool {
 	a.mu.RLock()
@@ -2935,7 +4574,11 @@ func (a *App) GetContactsForAccount(accountJID string) []roster.Roster {
 			if favs, ok := a.contactFavorites[out[i].AccountJID]; ok {
 				out[i].Favorite = favs[out[i].JID]
 			}
-			out[i].StatusHidden = !a.IsStatusSharingEnabled(out[i].JID)
+			out[i].StatusHidden = !a.statusSharingEnabledLocked(out[i].AccountJID, out[i].JID)
+			out[i].Request = a.contactRequests[historyKey(out[i].AccountJID, out[i].JID)] != ""
+			if unix := a.contactLastInteraction[out[i].AccountJID][out[i].JID]; unix > 0 {
+				out[i].LastActivity = time.Unix(unix, 0)
+			}
 		}
 		return out // Return all if no account specified
 	}
@@ -2950,17 +4593,29 @@ func (a *App) GetContactsForAccount(accountJID string) []roster.Roster {
 			if favs, ok := a.contactFavorites[accountJID]; ok {
 				entry.Favorite = favs[r.JID]
 			}
-			entry.StatusHidden = !a.IsStatusSharingEnabled(r.JID)
+			entry.StatusHidden = !a.statusSharingEnabledLocked(accountJID, r.JID)
+			entry.Request = a.contactRequests[historyKey(accountJID, r.JID)] != ""
+			if unix := a.contactLastInteraction[accountJID][r.JID]; unix > 0 {
+				entry.LastActivity = time.Unix(unix, 0)
+			}
 			filtered = append(filtered, entry)
 		}
 	}
 
 	lastInteraction := a.contactLastInteraction[accountJID]
+	byPresence := a.cfg.UI.RosterSort == "presence"
 	sort.SliceStable(filtered, func(i, j int) bool {
 		if filtered[i].Favorite != filtered[j].Favorite {
 			return filtered[i].Favorite
 		}
 
+		if byPresence {
+			ri, rj := theme.PresenceRank(filtered[i].Status), theme.PresenceRank(filtered[j].Status)
+			if ri != rj {
+				return ri < rj
+			}
+		}
+
 		var ti, tj int64
 		if lastInteraction != nil {
 			ti = lastInteraction[filtered[i].JID]
@@ -2997,7 +4652,7 @@ func (a *App) SendPresenceForAccount(accountJID, show, status string) error {
 	if client == nil {
 		return fmt.Errorf("account not connected")
 	}
-	return client.SendPresence(show, status)
+	return a.sendPresence(accountJID, client, show, status)
 }
 
 // JoinRoom joins an existing MUC room
@@ -3010,9 +4665,28 @@ func (a *App) JoinRoom(roomJID, nick, password string) error {
 		return fmt.Errorf("not connected")
 	}
 
+	if nick == "" {
+		nick = a.RoomNick(a.CurrentAccount(), roomJID)
+	}
+	a.rememberRoomNick(roomJID, nick)
 	return client.JoinRoom(roomJID, nick, password)
 }
 
+// rememberRoomNick stores the nick used in a room for mention detection and
+// as the nick to join it with next time
+func (a *App) rememberRoomNick(roomJID, nick string) {
+	if nick == "" {
+		return
+	}
+	a.mu.Lock()
+	accountJID := a.currentAccount
+	a.roomNicks[historyKey(accountJID, roomJID)] = nick
+	a.mu.Unlock()
+	if a.storage != nil && accountJID != "" {
+		_ = a.storage.SetRoomNick(accountJID, roomJID, nick)
+	}
+}
+
 // CreateRoom creates a new MUC room
 func (a *App) CreateRoom(roomJID, nick, password string, useDefaults, membersOnly, persistent bool) error {
 	a.mu.RLock()
@@ -3028,6 +4702,7 @@ func (a *App) CreateRoom(roomJID, nick, password string, useDefaults, membersOnl
 	_ = persistent
 	_ = password
 
+	a.rememberRoomNick(roomJID, nick)
 	return c.JoinRoom(roomJID, nick, "")
 }
 
@@ -3112,22 +4787,24 @@ func (a *App) DeleteBookmark(roomJID string) error {
 // ToggleStatusSharing toggles status sharing for a contact
 // Returns the new state (true = sharing enabled)
 func (a *App) ToggleStatusSharing(contactJID string) (bool, error) {
-	a.mu.Lock()
+	a.mu.RLock()
 	currentAccount := a.currentAccount
-	client := a.xmppClient
+	a.mu.RUnlock()
+	return a.ToggleStatusSharingForAccount(currentAccount, contactJID)
+}
 
-	if currentAccount == "" {
-		a.mu.Unlock()
+// ToggleStatusSharingForAccount toggles status sharing for a contact of an account
+func (a *App) ToggleStatusSharingForAccount(accountJID, contactJID string) (bool, error) {
+	if accountJID == "" {
 		return false, fmt.Errorf("no account selected")
 	}
+	key := historyKey(accountJID, contactJID)
 
-	// Missing map key means sharing is enabled by default.
-	currentState, exists := a.statusSharing[contactJID]
-	if !exists {
-		currentState = true
-	}
+	a.mu.Lock()
+	client := a.clients[accountJID]
+	currentState := a.statusSharingEnabledLocked(accountJID, contactJID)
 	newState := !currentState
-	a.statusSharing[contactJID] = newState
+	a.statusSharing[key] = newState
 	a.mu.Unlock()
 
 	// If connected, send directed presence or unavailable based on new state
@@ -3139,7 +4816,7 @@ func (a *App) ToggleStatusSharing(contactJID string) (bool, error) {
 			if err := client.SendDirectedPresence(contactJID, show, a.statusMsg); err != nil {
 				// Revert state on error
 				a.mu.Lock()
-				a.statusSharing[contactJID] = currentState
+				a.statusSharing[key] = currentState
 				a.mu.Unlock()
 				return currentState, err
 			}
@@ -3148,13 +4825,16 @@ func (a *App) ToggleStatusSharing(contactJID string) (bool, error) {
 			if err := client.HideStatusFrom(contactJID); err != nil {
 				// Revert state on error
 				a.mu.Lock()
-				a.statusSharing[contactJID] = currentState
+				a.statusSharing[key] = currentState
 				a.mu.Unlock()
 				return currentState, err
 			}
 		}
 	}
 
+	if a.storage != nil {
+		_ = a.storage.SetStatusSharing(accountJID, contactJID, newState)
+	}
 	return newState, nil
 }
 
@@ -3282,6 +4962,42 @@ func (a *App) GetContactFingerprintDetails(contactJID string) []OMEMODeviceInfo
 	return devices
 }
 
+// OMEMOShortCode is the short authentication string of a contact's device,
+// compared out loud instead of the full fingerprint
+type OMEMOShortCode struct {
+	Emoji   []string
+	Numbers string
+}
+
+// GetOMEMOShortCode derives the short authentication string shared with a
+// device of a contact. The contact sees the same code only if both sides
+// have each other's real identity keys.
+func (a *App) GetOMEMOShortCode(contactJID string, deviceID uint32) (OMEMOShortCode, error) {
+	a.mu.RLock()
+	currentAccount := a.currentAccount
+	a.mu.RUnlock()
+
+	if currentAccount == "" {
+		return OMEMOShortCode{}, fmt.Errorf("no account selected")
+	}
+
+	c := a.getConnectedClient(currentAccount)
+	if c == nil {
+		return OMEMOShortCode{}, fmt.Errorf("not connected")
+	}
+
+	store := c.OMEMOStore()
+	if store == nil {
+		return OMEMOShortCode{}, fmt.Errorf("OMEMO not available")
+	}
+
+	sas, err := store.ShortAuthString(contactJID, deviceID)
+	if err != nil {
+		return OMEMOShortCode{}, err
+	}
+	return OMEMOShortCode{Emoji: sas.Emoji, Numbers: sas.String()}, nil
+}
+
 // OMEMODeviceInfo represents info about an OMEMO device
 type OMEMODeviceInfo struct {
 	DeviceID    uint32
@@ -3290,6 +5006,68 @@ type OMEMODeviceInfo struct {
 	TrustString string
 }
 
+// OMEMO backups are sealed like the accounts file, see
+// config.SealWithPassphrase
+const (
+	omemoBackupFormat  = "roster-omemo-backup"
+	omemoBackupVersion = 1
+)
+
+// ExportOMEMO writes the account's OMEMO identity, sessions and trust
+// decisions to an encrypted backup file
+func (a *App) ExportOMEMO(accountJID, path, passphrase string) error {
+	c := a.getConnectedClient(accountJID)
+	if c == nil {
+		return fmt.Errorf("account %s is not connected", accountJID)
+	}
+	if passphrase == "" {
+		return fmt.Errorf("a passphrase is required")
+	}
+
+	backup, err := c.ExportOMEMOBackup()
+	if err != nil {
+		return err
+	}
+	plain, err := json.Marshal(backup)
+	if err != nil {
+		return err
+	}
+	data, err := config.SealWithPassphrase(omemoBackupFormat, omemoBackupVersion, passphrase, plain)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// ImportOMEMO restores an encrypted OMEMO backup into the account's stored
+// OMEMO state. The account has to be offline; it connects as the restored
+// device next time.
+func (a *App) ImportOMEMO(accountJID, path, passphrase string) error {
+	if a.storage == nil {
+		return fmt.Errorf("storage is not available")
+	}
+	a.mu.RLock()
+	_, active := a.clients[accountJID]
+	a.mu.RUnlock()
+	if active {
+		return fmt.Errorf("disconnect %s before importing its OMEMO keys", accountJID)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	plain, err := config.OpenWithPassphrase(data, omemoBackupFormat, omemoBackupVersion, passphrase)
+	if err != nil {
+		return err
+	}
+	var backup client.OMEMOBackup
+	if err := json.Unmarshal(plain, &backup); err != nil {
+		return fmt.Errorf("corrupted backup: %w", err)
+	}
+	return client.ImportOMEMOBackup(a.storage.SQL(), accountJID, &backup)
+}
+
 // SetOMEMOTrust sets the trust level for a contact's device
 func (a *App) SetOMEMOTrust(contactJID string, deviceID uint32, trustLevel int) error {
 	a.mu.RLock()
@@ -3310,7 +5088,23 @@ func (a *App) SetOMEMOTrust(contactJID string, deviceID uint32, trustLevel int)
 		return fmt.Errorf("OMEMO not available")
 	}
 
-	return store.SetTrustLevel(contactJID, deviceID, trustLevel)
+	oldLevel := store.GetTrustLevel(contactJID, deviceID)
+	if err := store.SetTrustLevel(contactJID, deviceID, trustLevel); err != nil {
+		return err
+	}
+	a.clearDeviceAlert(currentAccount, contactJID, deviceID)
+	if oldLevel != trustLevel {
+		a.recordSecurityEvent(sqlite.SecurityEvent{
+			Account:     currentAccount,
+			ContactJID:  contactJID,
+			DeviceID:    deviceID,
+			Event:       sqlite.SecurityEventTrustChanged,
+			OldTrust:    oldLevel,
+			NewTrust:    trustLevel,
+			Fingerprint: deviceFingerprint(store, contactJID, deviceID),
+		})
+	}
+	return nil
 }
 
 // DeleteOMEMODevice removes a device from the OMEMO store
@@ -3333,14 +5127,208 @@ func (a *App) DeleteOMEMODevice(contactJID string, deviceID uint32) error {
 		return fmt.Errorf("OMEMO not available")
 	}
 
-	return store.DeleteDevice(contactJID, deviceID)
+	fingerprint := deviceFingerprint(store, contactJID, deviceID)
+	oldLevel := store.GetTrustLevel(contactJID, deviceID)
+	if err := store.DeleteDevice(contactJID, deviceID); err != nil {
+		return err
+	}
+	a.clearDeviceAlert(currentAccount, contactJID, deviceID)
+	a.recordSecurityEvent(sqlite.SecurityEvent{
+		Account:     currentAccount,
+		ContactJID:  contactJID,
+		DeviceID:    deviceID,
+		Event:       sqlite.SecurityEventDeviceRemoved,
+		OldTrust:    oldLevel,
+		Fingerprint: fingerprint,
+	})
+	return nil
+}
+
+// NewDeviceAlert is sent when a contact presents an OMEMO device that the
+// user has not acknowledged yet
+type NewDeviceAlert struct {
+	AccountJID string
+	JID        string
+	DeviceID   uint32
+	Changed    bool // the device was known but its identity key changed
+}
+
+// handleOMEMODevice logs a device the OMEMO store has just learned about and
+// raises a new-device alert unless the same identity was seen before.
+// The store keeps identities in the database across runs, so this runs for
+// devices it has not seen or whose key changed; the audit log is checked too
+// so a device logged before the store kept its identities is not announced
+// again.
+func (a *App) handleOMEMODevice(accountJID, contactJID string, deviceID uint32, fingerprint string) {
+	if parsed, err := jid.Parse(accountJID); err == nil && parsed.Bare().String() == contactJID {
+		return
+	}
+
+	previous := ""
+	if a.storage != nil {
+		previous, _ = a.storage.LastDeviceFingerprint(accountJID, contactJID, deviceID)
+	}
+	if previous == fingerprint {
+		return
+	}
+
+	event := sqlite.SecurityEventDeviceAdded
+	if previous != "" {
+		event = sqlite.SecurityEventIdentityChanged
+	}
+	a.recordSecurityEvent(sqlite.SecurityEvent{
+		Account:     accountJID,
+		ContactJID:  contactJID,
+		DeviceID:    deviceID,
+		Event:       event,
+		Fingerprint: fingerprint,
+	})
+
+	key := historyKey(accountJID, contactJID)
+	a.mu.Lock()
+	if a.deviceAlerts[key] == nil {
+		a.deviceAlerts[key] = make(map[uint32]bool)
+	}
+	a.deviceAlerts[key][deviceID] = true
+	a.mu.Unlock()
+
+	a.sendEvent(EventMsg{Type: EventNewDevice, Data: NewDeviceAlert{
+		AccountJID: accountJID,
+		JID:        contactJID,
+		DeviceID:   deviceID,
+		Changed:    previous != "",
+	}})
+}
+
+// PendingNewDevices returns the unacknowledged OMEMO devices of a contact
+func (a *App) PendingNewDevices(accountJID, contactJID string) []uint32 {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var devices []uint32
+	for id := range a.deviceAlerts[historyKey(accountJID, contactJID)] {
+		devices = append(devices, id)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i] < devices[j] })
+	return devices
+}
+
+// AcknowledgeNewDevices dismisses all new-device alerts for a contact
+func (a *App) AcknowledgeNewDevices(accountJID, contactJID string) {
+	a.mu.Lock()
+	delete(a.deviceAlerts, historyKey(accountJID, contactJID))
+	a.mu.Unlock()
+}
+
+func (a *App) clearDeviceAlert(accountJID, contactJID string, deviceID uint32) {
+	key := historyKey(accountJID, contactJID)
+	a.mu.Lock()
+	delete(a.deviceAlerts[key], deviceID)
+	if len(a.deviceAlerts[key]) == 0 {
+		delete(a.deviceAlerts, key)
+	}
+	a.mu.Unlock()
+}
+
+// ErrSendingPaused is returned for encrypted messages to a contact with
+// unacknowledged new devices
+var ErrSendingPaused = errors.New("encrypted sending paused until the new device is verified or dismissed")
+
+// EncryptedSendingPaused reports whether encrypted messages to a contact must
+// wait until new devices are acknowledged. With trust-on-first-use enabled
+// new devices are accepted and sending is never paused.
+func (a *App) EncryptedSendingPaused(accountJID, contactJID string) bool {
+	if a.cfg.Encryption.OMEMOTOFU {
+		return false
+	}
+	return len(a.PendingNewDevices(accountJID, contactJID)) > 0
+}
+
+// deviceFingerprint returns the formatted fingerprint of a contact device, or
+// an empty string if the device is unknown
+func deviceFingerprint(store *client.OMEMOStore, contactJID string, deviceID uint32) string {
+	for _, id := range store.GetRemoteIdentitiesForJID(contactJID) {
+		if id.DeviceID == deviceID {
+			return formatFingerprint(id.IdentityKey)
+		}
+	}
+	return ""
+}
+
+// recordSecurityEvent appends an entry to the OMEMO audit log
+func (a *App) recordSecurityEvent(ev sqlite.SecurityEvent) {
+	if a.storage == nil || ev.Account == "" {
+		return
+	}
+	if err := a.storage.SaveSecurityEvent(ev); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record security event: %v\n", err)
+	}
+}
+
+// SecurityEventInfo is a display-ready OMEMO audit log entry
+type SecurityEventInfo struct {
+	Timestamp   time.Time
+	ContactJID  string
+	DeviceID    uint32
+	Description string
+	Fingerprint string
+}
+
+// GetSecurityEvents returns the most recent OMEMO security events for an
+// account, newest first
+func (a *App) GetSecurityEvents(accountJID string, limit int) []SecurityEventInfo {
+	if a.storage == nil || accountJID == "" {
+		return nil
+	}
+	events, err := a.storage.GetSecurityEvents(accountJID, limit)
+	if err != nil {
+		return nil
+	}
+
+	infos := make([]SecurityEventInfo, 0, len(events))
+	for _, ev := range events {
+		var desc string
+		switch ev.Event {
+		case sqlite.SecurityEventDeviceAdded:
+			desc = "new device"
+		case sqlite.SecurityEventIdentityChanged:
+			desc = "identity key changed"
+		case sqlite.SecurityEventTrustChanged:
+			desc = trustLevelString(ev.OldTrust) + " -> " + trustLevelString(ev.NewTrust)
+		case sqlite.SecurityEventDeviceRemoved:
+			desc = "device removed"
+		default:
+			desc = ev.Event
+		}
+		infos = append(infos, SecurityEventInfo{
+			Timestamp:   ev.Timestamp,
+			ContactJID:  ev.ContactJID,
+			DeviceID:    ev.DeviceID,
+			Description: desc,
+			Fingerprint: ev.Fingerprint,
+		})
+	}
+	return infos
 }
 
 // IsStatusSharingEnabled checks if status sharing is enabled for a contact
 func (a *App) IsStatusSharingEnabled(contactJID string) bool {
 	a.mu.RLock()
 	defer a.mu.RUnlock()
-	enabled, exists := a.statusSharing[contactJID]
+	return a.statusSharingEnabledLocked(a.currentAccount, contactJID)
+}
+
+// IsStatusSharingEnabledForAccount checks if an account shares its status with a contact
+func (a *App) IsStatusSharingEnabledForAccount(accountJID, contactJID string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.statusSharingEnabledLocked(accountJID, contactJID)
+}
+
+// statusSharingEnabledLocked must be called with a.mu held. A missing entry
+// means sharing is enabled by default.
+func (a *App) statusSharingEnabledLocked(accountJID, contactJID string) bool {
+	enabled, exists := a.statusSharing[historyKey(accountJID, contactJID)]
 	if !exists {
 		return true
 	}
@@ -0,0 +1,203 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/meszmate/roster/internal/client"
+)
+
+// Besides the local database the server may keep an archive of the
+// account's messages (XEP-0313), which other clients sync from. Its default
+// of always, roster or never (XEP-0441) is fetched after connecting, shown
+// in the account details and changed there or with :archive. A server that
+// archives while message saving is turned off keeps what the user chose not
+// to keep, so that is warned about once per connection.
+
+// ArchivePrefsMsg is sent when an account's archiving preference was
+// fetched or changed, or failed to be
+type ArchivePrefsMsg struct {
+	AccountJID string
+	Default    string // always, roster or never
+	Changed    bool   // the result of a change rather than a fetch
+	Conflict   bool   // the server archives while message saving is off
+	Error      string
+}
+
+// archiveEntry is what the server said about an account's archiving
+type archiveEntry struct {
+	Prefs   client.ArchivePrefs
+	Err     string
+	Pending bool
+	Warned  bool // the conflict with local saving was reported
+}
+
+// NextArchiveDefault returns the archiving default after current, in the
+// order always, roster, never
+func NextArchiveDefault(current string) string {
+	switch current {
+	case client.ArchiveAlways:
+		return client.ArchiveRoster
+	case client.ArchiveRoster:
+		return client.ArchiveNever
+	}
+	return client.ArchiveAlways
+}
+
+// ArchivePreference describes the server archiving of an account: the
+// default, "asking..." while waiting, why it is not known, or "" before it
+// was asked
+func (a *App) ArchivePreference(accountJID string) string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	entry := a.archivePrefs[accountJID]
+	switch {
+	case entry == nil:
+		return ""
+	case entry.Pending:
+		return "asking..."
+	case entry.Err != "":
+		return "not available (" + entry.Err + ")"
+	}
+	return entry.Prefs.Default
+}
+
+// ArchiveConflict reports whether the server archives an account's
+// messages although message saving is turned off
+func (a *App) ArchiveConflict(accountJID string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.archiveConflictLocked(accountJID)
+}
+
+func (a *App) archiveConflictLocked(accountJID string) bool {
+	entry := a.archivePrefs[accountJID]
+	if entry == nil || entry.Pending || entry.Err != "" {
+		return false
+	}
+	return entry.Prefs.Default != client.ArchiveNever && !a.cfg.Storage.SaveMessages
+}
+
+// FetchArchivePrefs asks the server how it archives an account's messages
+func (a *App) FetchArchivePrefs(accountJID string) tea.Cmd {
+	c, ok := a.startArchiveRequest(accountJID)
+	if !ok {
+		return nil
+	}
+	return func() tea.Msg {
+		var prefs client.ArchivePrefs
+		var err error
+		if c == nil || !c.IsConnected() {
+			err = fmt.Errorf("account not connected")
+		} else {
+			prefs, err = c.GetArchivePrefs()
+		}
+		return a.finishArchiveRequest(accountJID, prefs, err, false)
+	}
+}
+
+// SetArchiveDefault changes the server's archiving default for an account,
+// keeping the JIDs it always or never archives
+func (a *App) SetArchiveDefault(accountJID, mode string) tea.Cmd {
+	if !client.ValidArchiveDefault(mode) {
+		return func() tea.Msg {
+			return ArchivePrefsMsg{AccountJID: accountJID, Changed: true, Error: fmt.Sprintf("unknown archiving default %q", mode)}
+		}
+	}
+	a.mu.RLock()
+	var prefs client.ArchivePrefs
+	if entry := a.archivePrefs[accountJID]; entry != nil {
+		prefs = entry.Prefs
+	}
+	a.mu.RUnlock()
+	prefs.Default = mode
+
+	c, ok := a.startArchiveRequest(accountJID)
+	if !ok {
+		return nil
+	}
+	return func() tea.Msg {
+		var applied client.ArchivePrefs
+		var err error
+		if c == nil || !c.IsConnected() {
+			err = fmt.Errorf("account not connected")
+		} else {
+			applied, err = c.SetArchivePrefs(prefs)
+		}
+		return a.finishArchiveRequest(accountJID, applied, err, true)
+	}
+}
+
+// refreshArchivePrefs fetches the archiving preference after connecting
+func (a *App) refreshArchivePrefs(accountJID string) {
+	if cmd := a.FetchArchivePrefs(accountJID); cmd != nil {
+		if msg, ok := cmd().(ArchivePrefsMsg); ok {
+			a.sendEvent(EventMsg{Type: EventArchivePrefs, Data: msg})
+		}
+	}
+}
+
+// startArchiveRequest marks an account's archiving preference as being
+// asked for, false when it already is
+func (a *App) startArchiveRequest(accountJID string) (*client.Client, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.archivePrefs == nil {
+		a.archivePrefs = make(map[string]*archiveEntry)
+	}
+	entry := a.archivePrefs[accountJID]
+	if entry == nil {
+		entry = &archiveEntry{}
+		a.archivePrefs[accountJID] = entry
+	}
+	if entry.Pending {
+		return nil, false
+	}
+	entry.Pending = true
+	return a.clients[accountJID], true
+}
+
+// finishArchiveRequest records the answer to a preference request
+func (a *App) finishArchiveRequest(accountJID string, prefs client.ArchivePrefs, err error, changed bool) ArchivePrefsMsg {
+	msg := ArchivePrefsMsg{AccountJID: accountJID, Changed: changed}
+	a.mu.Lock()
+	entry := a.archivePrefs[accountJID]
+	if entry == nil {
+		// The account disconnected meanwhile
+		entry = &archiveEntry{}
+		a.archivePrefs[accountJID] = entry
+	}
+	entry.Pending = false
+	if err != nil {
+		msg.Error = err.Error()
+		if info := DescribeError(err); info.Title != "Error" {
+			msg.Error = strings.ToLower(info.Title)
+		}
+		// A failed change leaves the known preference as it was
+		if !changed {
+			entry.Err = msg.Error
+		}
+	} else {
+		entry.Prefs = prefs
+		entry.Err = ""
+	}
+	msg.Default = entry.Prefs.Default
+	// Warn about a conflict once, and again after a change brings it back
+	if a.archiveConflictLocked(accountJID) {
+		msg.Conflict = !entry.Warned || (changed && err == nil)
+		entry.Warned = true
+	} else {
+		entry.Warned = false
+	}
+	a.mu.Unlock()
+	return msg
+}
+
+// forgetArchivePrefs drops what is known about an account's archiving when
+// it disconnects, so the next connection asks and warns again
+func (a *App) forgetArchivePrefs(accountJID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.archivePrefs, accountJID)
+}
@@ -0,0 +1,162 @@
+package app
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/meszmate/roster/internal/config"
+)
+
+// BulkOp is an action applied to every marked contact at once
+type BulkOp string
+
+// Bulk roster operations
+const (
+	BulkGroup  BulkOp = "group"  // move into the given groups, out of all others
+	BulkTag    BulkOp = "tag"    // add the given groups, keeping the current ones
+	BulkRemove BulkOp = "remove" // remove from the roster
+	BulkMute   BulkOp = "mute"   // silence notification sounds
+	BulkUnmute BulkOp = "unmute" // undo mute
+	BulkExport BulkOp = "export" // export each chat as Markdown
+)
+
+// ParseBulkOp returns the operation named by s
+func ParseBulkOp(s string) (BulkOp, bool) {
+	switch op := BulkOp(strings.ToLower(s)); op {
+	case BulkGroup, BulkTag, BulkRemove, BulkMute, BulkUnmute, BulkExport:
+		return op, true
+	}
+	return "", false
+}
+
+// Describe summarizes what the operation does to n contacts, for the
+// confirmation dialog
+func (op BulkOp) Describe(n int, arg string) string {
+	contacts := fmt.Sprintf("%d contacts", n)
+	if n == 1 {
+		contacts = "1 contact"
+	}
+	switch op {
+	case BulkGroup:
+		if arg == "" {
+			return "Remove " + contacts + " from all groups"
+		}
+		return fmt.Sprintf("Move %s to %q", contacts, arg)
+	case BulkTag:
+		return fmt.Sprintf("Add %s to %q", contacts, arg)
+	case BulkRemove:
+		return "Remove " + contacts + " from the roster"
+	case BulkMute:
+		return "Mute " + contacts
+	case BulkUnmute:
+		return "Unmute " + contacts
+	case BulkExport:
+		return "Export the chats with " + contacts + " as Markdown"
+	}
+	return string(op) + " " + contacts
+}
+
+// BulkResultMsg is sent when a bulk operation has gone through every contact
+type BulkResultMsg struct {
+	Op     BulkOp
+	Done   int
+	Queued int      // roster changes waiting for the account to connect
+	Failed []string // "jid: reason" for each contact that failed
+	Dir    string   // where exports were written
+}
+
+// DoBulk applies op to the contacts of an account. Roster changes are sent
+// to the server one contact at a time, or queued while the account is
+// offline; a failure skips that contact and carries on with the rest.
+func (a *App) DoBulk(accountJID string, jids []string, op BulkOp, arg string) tea.Cmd {
+	return func() tea.Msg {
+		result := BulkResultMsg{Op: op}
+		fail := func(jid string, err error) {
+			result.Failed = append(result.Failed, jid+": "+err.Error())
+		}
+
+		switch op {
+		case BulkMute, BulkUnmute:
+			if err := a.setSounds(jids, op == BulkMute); err != nil {
+				for _, jid := range jids {
+					fail(jid, err)
+				}
+				return result
+			}
+			result.Done = len(jids)
+			return result
+
+		case BulkExport:
+			for _, jid := range jids {
+				exported, err := a.ExportChat(accountJID, jid, ExportMarkdown, "")
+				if err != nil {
+					fail(jid, err)
+					continue
+				}
+				result.Done++
+				result.Dir = filepath.Dir(exported.Dir)
+			}
+			return result
+		}
+
+		groups := parseGroupInput(arg)
+		for _, jid := range jids {
+			edit := RosterEdit{AccountJID: accountJID, JID: jid}
+			switch op {
+			case BulkRemove:
+				edit.Remove = true
+			case BulkGroup, BulkTag:
+				name, current := a.rosterItem(accountJID, jid)
+				edit.Name = name
+				edit.Groups = groups
+				if op == BulkTag {
+					edit.Groups = parseGroupInput(strings.Join(append(current, groups...), ","))
+				}
+			}
+			queued, err := a.editRoster(edit)
+			if err != nil {
+				fail(jid, err)
+				continue
+			}
+			result.Done++
+			if queued {
+				result.Queued++
+			}
+		}
+		a.sendEvent(EventMsg{Type: EventRosterUpdate})
+		return result
+	}
+}
+
+// rosterItem returns the name and groups a contact has in the roster of an
+// account, which a roster set must repeat to keep them
+func (a *App) rosterItem(accountJID, contactJID string) (string, []string) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, r := range a.rosters {
+		if r.AccountJID == accountJID && r.JID == contactJID {
+			return r.Name, append([]string(nil), r.Groups...)
+		}
+	}
+	return "", nil
+}
+
+// setSounds mutes or unmutes conversations and saves the config once
+func (a *App) setSounds(jids []string, mute bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cfg.Sounds.Conversations == nil {
+		a.cfg.Sounds.Conversations = make(map[string]string)
+	}
+	for _, jid := range jids {
+		if mute {
+			a.cfg.Sounds.Conversations[jid] = "none"
+		} else if a.cfg.Sounds.Conversations[jid] == "none" {
+			// A sound picked with :sound is not a mute, leave it
+			delete(a.cfg.Sounds.Conversations, jid)
+		}
+	}
+	return config.Save(a.cfg)
+}
@@ -0,0 +1,153 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/meszmate/roster/internal/client"
+	"github.com/meszmate/roster/internal/ui/components/chat"
+	"github.com/meszmate/xmpp-go/jid"
+)
+
+// pendingCall is an incoming call that is still ringing
+type pendingCall struct {
+	AccountJID string
+	Contact    string
+	Peer       string // full JID of the calling client
+	Media      []string
+	Since      time.Time
+}
+
+// callKind describes the call media for chat notices
+func callKind(media []string) string {
+	for _, m := range media {
+		if m == "video" {
+			return "video call"
+		}
+	}
+	if len(media) > 0 {
+		return "voice call"
+	}
+	return "call"
+}
+
+// handleCall turns Jingle call signalling into chat notices. Calls cannot
+// be answered here, so the user is pointed at their other devices.
+func (a *App) handleCall(accountJID string, call client.CallEvent) {
+	from := call.From.Bare().String()
+	own := accountJID
+	if parsed, err := jid.Parse(accountJID); err == nil {
+		own = parsed.Bare().String()
+	}
+
+	a.mu.Lock()
+	if a.calls == nil {
+		a.calls = make(map[string]*pendingCall)
+	}
+	pending := a.calls[call.ID]
+
+	var contact, notice string
+	switch call.State {
+	case client.CallProposed:
+		if from == own || pending != nil {
+			// Our own outgoing call, or a repeated proposal
+			a.mu.Unlock()
+			return
+		}
+		pending = &pendingCall{
+			AccountJID: accountJID,
+			Contact:    from,
+			Peer:       call.From.String(),
+			Media:      call.Media,
+			Since:      time.Now(),
+		}
+		a.calls[call.ID] = pending
+		contact = from
+		notice = fmt.Sprintf("Incoming %s — answer on another device, or :decline to reject", callKind(call.Media))
+	case client.CallRetracted:
+		if pending == nil {
+			a.mu.Unlock()
+			return
+		}
+		delete(a.calls, call.ID)
+		contact = pending.Contact
+		notice = fmt.Sprintf("Missed %s at %s", callKind(pending.Media), a.TimeFormat().Clock(pending.Since))
+	case client.CallAccepted, client.CallProceed, client.CallRejected:
+		if pending == nil || from != own {
+			a.mu.Unlock()
+			return
+		}
+		delete(a.calls, call.ID)
+		contact = pending.Contact
+		notice = "Call answered on another device"
+		if call.State == client.CallRejected {
+			notice = "Call declined on another device"
+		}
+	default:
+		a.mu.Unlock()
+		return
+	}
+	a.mu.Unlock()
+
+	a.addCallNotice(accountJID, contact, notice)
+}
+
+// addCallNotice appends a system line about a call to the conversation
+func (a *App) addCallNotice(accountJID, contact, body string) {
+	notice := chat.Message{
+		ID:        fmt.Sprintf("call-%d", time.Now().UnixNano()),
+		From:      contact,
+		Body:      body,
+		Timestamp: time.Now(),
+		Type:      "system",
+	}
+
+	a.mu.Lock()
+	key := historyKey(accountJID, contact)
+	a.appendHistoryLocked(key, notice)
+	a.mu.Unlock()
+	a.capHistory()
+
+	a.sendEvent(EventMsg{Type: EventMessage, Data: ChatMessage{
+		AccountJID: accountJID,
+		ID:         notice.ID,
+		From:       notice.From,
+		Body:       notice.Body,
+		Timestamp:  notice.Timestamp,
+		Type:       notice.Type,
+	}})
+}
+
+// DeclineCall rejects the most recent call still ringing from a contact
+func (a *App) DeclineCall(accountJID, contact string) error {
+	a.mu.Lock()
+	var id string
+	var call *pendingCall
+	for callID, pending := range a.calls {
+		if pending.AccountJID != accountJID || pending.Contact != contact {
+			continue
+		}
+		if call == nil || pending.Since.After(call.Since) {
+			id, call = callID, pending
+		}
+	}
+	if call != nil {
+		delete(a.calls, id)
+	}
+	a.mu.Unlock()
+
+	if call == nil {
+		return fmt.Errorf("no incoming call from %s", contact)
+	}
+
+	c := a.getConnectedClient(accountJID)
+	if c == nil {
+		return fmt.Errorf("account %s is not connected", accountJID)
+	}
+	if err := c.DeclineCall(call.Peer, id); err != nil {
+		return err
+	}
+
+	a.addCallNotice(accountJID, contact, "Declined "+callKind(call.Media))
+	return nil
+}
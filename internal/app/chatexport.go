@@ -0,0 +1,269 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/meszmate/roster/internal/config"
+	"github.com/meszmate/roster/internal/ui/components/chat"
+	"github.com/meszmate/xmpp-go/jid"
+)
+
+// ExportProfile selects the format of a chat export
+type ExportProfile string
+
+// Chat export profiles
+const (
+	// ExportMarkdown writes one Markdown file per month, with reactions
+	// inlined, corrections marked and shared files linked
+	ExportMarkdown ExportProfile = "markdown"
+)
+
+// ChatExportResult describes a finished chat export
+type ChatExportResult struct {
+	Dir      string
+	Files    []string // one per month, oldest first
+	Messages int
+}
+
+// ExportChat writes the history of a conversation under dir, in a folder
+// named after the contact or room. An empty dir exports to the data
+// directory. Stored messages are combined with those in memory, which also
+// carry reactions and file links.
+func (a *App) ExportChat(accountJID, contactJID string, profile ExportProfile, dir string) (ChatExportResult, error) {
+	if profile != ExportMarkdown {
+		return ChatExportResult{}, fmt.Errorf("unknown export format %q (use markdown)", profile)
+	}
+	if dir == "" {
+		paths, err := config.GetPaths()
+		if err != nil {
+			return ChatExportResult{}, err
+		}
+		dir = filepath.Join(paths.DataDir, "exports", accountJID)
+	}
+	if strings.HasPrefix(dir, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(home, dir[2:])
+		}
+	}
+	dir = filepath.Join(dir, exportFileName(contactJID))
+
+	messages := a.exportMessages(accountJID, contactJID)
+	if len(messages) == 0 {
+		return ChatExportResult{}, fmt.Errorf("no messages with %s to export", contactJID)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return ChatExportResult{}, err
+	}
+
+	result := ChatExportResult{Dir: dir, Messages: len(messages)}
+	for _, month := range splitByMonth(messages) {
+		name := month[0].Timestamp.Format("2006-01") + ".md"
+		doc := a.markdownMonth(accountJID, contactJID, month)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(doc), 0600); err != nil {
+			return result, err
+		}
+		result.Files = append(result.Files, name)
+	}
+
+	var index strings.Builder
+	fmt.Fprintf(&index, "# %s\n\n", contactJID)
+	for _, name := range result.Files {
+		month := strings.TrimSuffix(name, ".md")
+		fmt.Fprintf(&index, "- [%s](%s)\n", month, name)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "index.md"), []byte(index.String()), 0600); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// exportMessages returns every stored message of a conversation, merged with
+// the ones in memory, oldest first
+func (a *App) exportMessages(accountJID, contactJID string) []chat.Message {
+	var messages []chat.Message
+	byID := make(map[string]int)
+	if a.storage != nil {
+		stored, err := a.storage.GetMessages(accountJID, contactJID, -1, 0)
+		if err == nil {
+			for _, m := range stored {
+				msg := chat.Message{
+					ID:        m.ID,
+					From:      accountJID,
+					Body:      m.Body,
+					Timestamp: m.Timestamp,
+					Outgoing:  m.Outgoing,
+					Type:      m.Type,
+				}
+				if !m.Outgoing {
+					msg.From = contactJID
+				}
+				if m.Corrected {
+					msg.CorrectedID = m.CorrectedID
+				}
+				byID[m.ID] = len(messages)
+				messages = append(messages, msg)
+			}
+		}
+	}
+
+	a.mu.RLock()
+	for _, msg := range a.chatHistory[historyKey(accountJID, contactJID)] {
+		if i, ok := byID[msg.ID]; ok && msg.ID != "" {
+			messages[i] = msg
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	a.mu.RUnlock()
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Timestamp.Before(messages[j].Timestamp)
+	})
+	return messages
+}
+
+// splitByMonth groups time-ordered messages by calendar month
+func splitByMonth(messages []chat.Message) [][]chat.Message {
+	var months [][]chat.Message
+	for i, msg := range messages {
+		if i == 0 || msg.Timestamp.Format("2006-01") != messages[i-1].Timestamp.Format("2006-01") {
+			months = append(months, nil)
+		}
+		months[len(months)-1] = append(months[len(months)-1], msg)
+	}
+	return months
+}
+
+// markdownMonth renders one month of a conversation, a heading per day
+func (a *App) markdownMonth(accountJID, contactJID string, messages []chat.Message) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s — %s\n", contactJID, messages[0].Timestamp.Format("January 2006"))
+
+	var day string
+	for _, msg := range messages {
+		if d := msg.Timestamp.Format("2006-01-02"); d != day {
+			day = d
+			fmt.Fprintf(&b, "\n## %s\n\n", day)
+		}
+
+		fmt.Fprintf(&b, "- **%s** %s: %s", msg.Timestamp.Format("15:04"),
+			markdownEscape(a.exportSender(accountJID, contactJID, msg)), markdownBody(msg))
+		if msg.CorrectedID != "" {
+			b.WriteString(" _(edited)_")
+		}
+		b.WriteString("\n")
+		if reactions := markdownReactions(msg.Reactions, msg.Type == "groupchat"); reactions != "" {
+			b.WriteString("  - " + reactions + "\n")
+		}
+	}
+	return b.String()
+}
+
+// exportSender names who sent a message: the nick in rooms, the bare JID
+// in chats
+func (a *App) exportSender(accountJID, contactJID string, msg chat.Message) string {
+	if msg.Type == "groupchat" {
+		if msg.Outgoing {
+			return a.RoomNick(accountJID, contactJID)
+		}
+		if _, nick, ok := strings.Cut(msg.From, "/"); ok && nick != "" {
+			return nick
+		}
+	}
+	if msg.Outgoing {
+		return bareJIDString(accountJID)
+	}
+	return bareJIDString(msg.From)
+}
+
+// markdownBody renders a message body, with a shared file as a link and
+// continuation lines indented to stay inside the list item
+func markdownBody(msg chat.Message) string {
+	body := msg.Body
+	if msg.FileURL != "" {
+		name := msg.FileName
+		if name == "" {
+			name = msg.FileURL
+		}
+		link := fmt.Sprintf("[%s](<%s>)", markdownEscape(name), msg.FileURL)
+		if msg.FileSize > 0 {
+			link += " (" + exportSize(msg.FileSize) + ")"
+		}
+		// The body of a file share is usually just its URL
+		if strings.TrimSpace(body) == msg.FileURL || body == "" {
+			body = link
+		} else {
+			body += " " + link
+		}
+	}
+	return strings.ReplaceAll(body, "\n", "  \n  ")
+}
+
+// markdownReactions lists reactions by emoji with who sent each: their
+// nick in rooms, their bare JID elsewhere
+func markdownReactions(reactions map[string]string, groupchat bool) string {
+	if len(reactions) == 0 {
+		return ""
+	}
+	byEmoji := make(map[string][]string)
+	var emojis []string
+	for from, emoji := range reactions {
+		if _, ok := byEmoji[emoji]; !ok {
+			emojis = append(emojis, emoji)
+		}
+		name := bareJIDString(from)
+		if _, nick, ok := strings.Cut(from, "/"); ok && groupchat && nick != "" {
+			name = nick
+		}
+		byEmoji[emoji] = append(byEmoji[emoji], name)
+	}
+	sort.Strings(emojis)
+	parts := make([]string, len(emojis))
+	for i, emoji := range emojis {
+		from := byEmoji[emoji]
+		sort.Strings(from)
+		parts[i] = emoji + " " + markdownEscape(strings.Join(from, ", "))
+	}
+	return "Reactions: " + strings.Join(parts, " · ")
+}
+
+// markdownEscape keeps names from being read as Markdown markup
+func markdownEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `_`, `\_`, "`", "\\`", `[`, `\[`, `]`, `\]`).Replace(s)
+}
+
+// exportFileName makes a JID safe to use as a folder name
+func exportFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// exportSize formats a file size for display
+func exportSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// bareJIDString returns the bare form of a JID, or s when it does not parse
+func bareJIDString(s string) string {
+	if parsed, err := jid.Parse(s); err == nil {
+		return parsed.Bare().String()
+	}
+	return s
+}
@@ -0,0 +1,267 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/meszmate/roster/internal/ui/components/chat"
+)
+
+// Plain text logs, irssi style, are written next to the database when
+// chat_logs.enabled is set: one file per conversation and day, appended to
+// and never rewritten, so grep and tail work on them. They are independent
+// of the database, except that conversations set to :logging never are not
+// logged either.
+
+const (
+	// DefaultChatLogTemplate names log files below the log folder
+	DefaultChatLogTemplate = "{account}/{jid}/{date}.log"
+
+	// chatLogQueue is how many lines may wait for the disk before new ones
+	// are dropped
+	chatLogQueue = 1024
+
+	// chatLogIdle is how long a log file stays open without writes
+	chatLogIdle = 5 * time.Minute
+
+	// chatLogMaxOpen caps the log files kept open at once
+	chatLogMaxOpen = 32
+
+	chatLogStamp = "Mon Jan 02 15:04:05 2006"
+)
+
+// chatLogLine is one message on its way to a log file
+type chatLogLine struct {
+	path string
+	text string
+}
+
+// chatLogFile is an open log file
+type chatLogFile struct {
+	f        *os.File
+	lastUsed time.Time
+}
+
+// chatLogger appends lines to log files from its own goroutine, so a slow
+// disk never holds up the UI
+type chatLogger struct {
+	dir      string
+	template string
+	lines    chan chatLogLine
+	done     chan struct{}
+	files    map[string]*chatLogFile // only touched by run
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newChatLogger(dir, template string) *chatLogger {
+	l := &chatLogger{
+		dir:      dir,
+		template: template,
+		lines:    make(chan chatLogLine, chatLogQueue),
+		done:     make(chan struct{}),
+		files:    make(map[string]*chatLogFile),
+	}
+	go l.run()
+	return l
+}
+
+// run writes queued lines until the logger is closed
+func (l *chatLogger) run() {
+	defer close(l.done)
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case line, ok := <-l.lines:
+			if !ok {
+				for path := range l.files {
+					l.closeFile(path)
+				}
+				return
+			}
+			l.write(line)
+		case <-ticker.C:
+			for path, file := range l.files {
+				if time.Since(file.lastUsed) > chatLogIdle {
+					l.closeFile(path)
+				}
+			}
+		}
+	}
+}
+
+// write appends a line, opening its file first when needed
+func (l *chatLogger) write(line chatLogLine) {
+	file := l.files[line.path]
+	if file == nil {
+		if len(l.files) >= chatLogMaxOpen {
+			l.closeOldest()
+		}
+		if err := os.MkdirAll(filepath.Dir(line.path), 0700); err != nil {
+			return
+		}
+		f, err := os.OpenFile(line.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(f, "--- Log opened %s\n", time.Now().Format(chatLogStamp))
+		file = &chatLogFile{f: f}
+		l.files[line.path] = file
+	}
+	file.lastUsed = time.Now()
+	_, _ = file.f.WriteString(line.text)
+}
+
+func (l *chatLogger) closeFile(path string) {
+	file := l.files[path]
+	fmt.Fprintf(file.f, "--- Log closed %s\n", time.Now().Format(chatLogStamp))
+	file.f.Close()
+	delete(l.files, path)
+}
+
+func (l *chatLogger) closeOldest() {
+	oldest := ""
+	for path, file := range l.files {
+		if oldest == "" || file.lastUsed.Before(l.files[oldest].lastUsed) {
+			oldest = path
+		}
+	}
+	if oldest != "" {
+		l.closeFile(oldest)
+	}
+}
+
+// enqueue hands a line to the writer. A full queue drops it rather than
+// wait.
+func (l *chatLogger) enqueue(line chatLogLine) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return
+	}
+	select {
+	case l.lines <- line:
+	default:
+	}
+}
+
+// close writes what is queued and closes the files
+func (l *chatLogger) close() {
+	l.mu.Lock()
+	l.closed = true
+	close(l.lines)
+	l.mu.Unlock()
+	<-l.done
+}
+
+// path expands the file name template for a conversation and day. It
+// returns "" when the template would leave the log folder.
+func (l *chatLogger) path(accountJID, contactJID string, day time.Time) string {
+	day = day.Local()
+	name := strings.NewReplacer(
+		"{account}", exportFileName(bareJIDString(accountJID)),
+		"{jid}", exportFileName(contactJID),
+		"{date}", day.Format("2006-01-02"),
+		"{year}", day.Format("2006"),
+		"{month}", day.Format("01"),
+		"{day}", day.Format("02"),
+	).Replace(l.template)
+	path := filepath.Join(l.dir, filepath.FromSlash(name))
+	if rel, err := filepath.Rel(l.dir, path); err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	return path
+}
+
+// chatLogDir is where plain text logs go unless chat_logs.dir says
+// otherwise
+func (a *App) chatLogDir() string {
+	if a.cfg.ChatLogs.Dir != "" {
+		return a.cfg.ChatLogs.Dir
+	}
+	return filepath.Join(a.cfg.General.DataDir, "logs", "chats")
+}
+
+// ApplyChatLogs starts or stops the plain text logs to match the config
+func (a *App) ApplyChatLogs() {
+	enabled := a.cfg.ChatLogs.Enabled && !a.demo
+	dir, template := a.chatLogDir(), a.cfg.ChatLogs.Template
+	if template == "" {
+		template = DefaultChatLogTemplate
+	}
+
+	a.mu.Lock()
+	old := a.chatLog
+	if enabled && old != nil && old.dir == dir && old.template == template {
+		a.mu.Unlock()
+		return
+	}
+	a.chatLog = nil
+	if enabled {
+		a.chatLog = newChatLogger(dir, template)
+	}
+	a.mu.Unlock()
+	if old != nil {
+		old.close()
+	}
+}
+
+// ChatLogPath returns today's log file of a conversation, or "" when plain
+// text logs are off
+func (a *App) ChatLogPath(accountJID, contactJID string) string {
+	a.mu.RLock()
+	l := a.chatLog
+	a.mu.RUnlock()
+	if l == nil {
+		return ""
+	}
+	return l.path(accountJID, contactJID, time.Now())
+}
+
+// logChatMessage queues a message for the plain text log of its
+// conversation
+func (a *App) logChatMessage(accountJID, contactJID string, msg chat.Message) {
+	a.mu.RLock()
+	l := a.chatLog
+	a.mu.RUnlock()
+	if l == nil || accountJID == "" || strings.TrimSpace(msg.Body) == "" {
+		return
+	}
+	if a.ConversationSaving(accountJID, contactJID) == SavingNever {
+		return
+	}
+
+	at := msg.Timestamp
+	if at.IsZero() {
+		at = time.Now()
+	}
+	path := l.path(accountJID, contactJID, at)
+	if path == "" {
+		return
+	}
+	l.enqueue(chatLogLine{
+		path: path,
+		text: formatChatLogLine(at, a.exportSender(accountJID, contactJID, msg), msg.Body),
+	})
+}
+
+// formatChatLogLine renders a message the way irssi logs it, one line per
+// line of the body: "15:04 <nick> text", or "15:04  * nick text" for /me
+func formatChatLogLine(at time.Time, nick, body string) string {
+	stamp := at.Local().Format("15:04")
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimRight(body, "\n"), "\n") {
+		if rest, ok := strings.CutPrefix(line, "/me "); ok {
+			fmt.Fprintf(&b, "%s  * %s %s\n", stamp, nick, rest)
+			continue
+		}
+		fmt.Fprintf(&b, "%s <%s> %s\n", stamp, nick, line)
+	}
+	return b.String()
+}
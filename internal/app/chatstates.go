@@ -0,0 +1,212 @@
+package app
+
+import (
+	"time"
+)
+
+// What roster tells a contact about a one-to-one conversation besides its
+// messages, each only while turned on in the privacy dashboard:
+//
+//   - chat states (XEP-0085): active when the conversation is looked at,
+//     inactive when it is left or the terminal loses focus, gone when its
+//     window is closed
+//   - typing (XEP-0085): composing while writing, paused after a few idle
+//     seconds or when the composer is cleared
+//   - read markers (XEP-0333): displayed for the last markable message once
+//     the conversation is read here
+//
+// Rooms get none of them.
+
+// typingPause is how long after the last keystroke composing turns paused
+const typingPause = 5 * time.Second
+
+// typingEntry is a conversation told composing, paused when timer fires
+type typingEntry struct {
+	accountJID string
+	jid        string
+	timer      *time.Timer
+}
+
+// chatFocus is the conversation being looked at
+type chatFocus struct {
+	accountJID string
+	jid        string
+}
+
+// ConversationFocused records which conversation is open, telling the one
+// left it is inactive and the new one it is active. An empty contactJID
+// means none is.
+func (a *App) ConversationFocused(accountJID, contactJID string) {
+	next := chatFocus{accountJID: accountJID, jid: contactJID}
+	if contactJID == "" {
+		next = chatFocus{}
+	}
+	a.mu.Lock()
+	prev := a.chatFocus
+	a.chatFocus = next
+	blurred := a.focus.blurred
+	a.mu.Unlock()
+	if prev == next {
+		return
+	}
+	if prev.jid != "" {
+		a.pauseTyping(prev.accountJID, prev.jid)
+		if !blurred {
+			a.sendChatState(prev.accountJID, prev.jid, "inactive")
+		}
+	}
+	if next.jid != "" && !blurred {
+		a.sendChatState(next.accountJID, next.jid, "active")
+	}
+}
+
+// ConversationClosed tells a contact its conversation window was closed
+func (a *App) ConversationClosed(accountJID, contactJID string) {
+	a.mu.Lock()
+	if a.chatFocus == (chatFocus{accountJID: accountJID, jid: contactJID}) {
+		a.chatFocus = chatFocus{}
+	}
+	a.mu.Unlock()
+	a.stopTyping(accountJID, contactJID)
+	a.sendChatState(accountJID, contactJID, "gone")
+}
+
+// UserTyping is told the composer of a conversation changed: composing is
+// sent when writing starts and paused once it stops or the text is cleared
+func (a *App) UserTyping(accountJID, contactJID string, empty bool) {
+	if contactJID == "" || !a.PrivacyEnabled(accountJID, PrivacyTyping) {
+		return
+	}
+	if empty {
+		a.pauseTyping(accountJID, contactJID)
+		return
+	}
+	key := historyKey(accountJID, contactJID)
+	a.mu.Lock()
+	if t := a.typing[key]; t != nil {
+		t.timer.Reset(typingPause)
+		a.mu.Unlock()
+		return
+	}
+	if a.typing == nil {
+		a.typing = make(map[string]*typingEntry)
+	}
+	a.typing[key] = &typingEntry{
+		accountJID: accountJID,
+		jid:        contactJID,
+		timer: time.AfterFunc(typingPause, func() {
+			a.pauseTyping(accountJID, contactJID)
+		}),
+	}
+	a.mu.Unlock()
+	a.sendChatState(accountJID, contactJID, "composing")
+}
+
+// pauseTyping sends paused to a conversation composing was sent to
+func (a *App) pauseTyping(accountJID, contactJID string) {
+	if a.stopTyping(accountJID, contactJID) {
+		a.sendChatState(accountJID, contactJID, "paused")
+	}
+}
+
+// stopTyping forgets that composing was sent to a conversation, as a sent
+// message ends it, and reports whether it was
+func (a *App) stopTyping(accountJID, contactJID string) bool {
+	key := historyKey(accountJID, contactJID)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	t := a.typing[key]
+	if t == nil {
+		return false
+	}
+	t.timer.Stop()
+	delete(a.typing, key)
+	return true
+}
+
+// stopAllTyping sends paused to every conversation of an account that was
+// told composing
+func (a *App) stopAllTyping(accountJID string) {
+	a.mu.RLock()
+	var jids []string
+	for _, t := range a.typing {
+		if t.accountJID == accountJID {
+			jids = append(jids, t.jid)
+		}
+	}
+	a.mu.RUnlock()
+	for _, contactJID := range jids {
+		a.pauseTyping(accountJID, contactJID)
+	}
+}
+
+// focusedChatState tells the open conversation it is active or inactive as
+// the terminal gains or loses focus
+func (a *App) focusedChatState(state string) {
+	a.mu.RLock()
+	focus := a.chatFocus
+	a.mu.RUnlock()
+	if focus.jid == "" {
+		return
+	}
+	if state == "inactive" {
+		a.pauseTyping(focus.accountJID, focus.jid)
+	}
+	a.sendChatState(focus.accountJID, focus.jid, state)
+}
+
+// sendChatState sends a chat state to a contact when the account shares
+// it: composing and paused are typing, the others chat states
+func (a *App) sendChatState(accountJID, contactJID, state string) {
+	item := PrivacyChatStates
+	if state == "composing" || state == "paused" {
+		item = PrivacyTyping
+	}
+	if accountJID == "" || contactJID == "" || !a.PrivacyEnabled(accountJID, item) || a.knownRoom(accountJID, contactJID) {
+		return
+	}
+	c := a.getConnectedClient(accountJID)
+	if c == nil {
+		return
+	}
+	go func() {
+		_ = c.SendChatState(contactJID, state)
+	}()
+}
+
+// noteMarkable remembers the last message of a conversation the sender
+// wants to know was read
+func (a *App) noteMarkable(accountJID, contactJID, messageID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.markable == nil {
+		a.markable = make(map[string]string)
+	}
+	a.markable[historyKey(accountJID, contactJID)] = messageID
+}
+
+// takeMarkable returns and forgets the message a read marker is owed for
+func (a *App) takeMarkable(accountJID, contactJID string) string {
+	key := historyKey(accountJID, contactJID)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	id := a.markable[key]
+	delete(a.markable, key)
+	return id
+}
+
+// sendReadMarker tells a contact its conversation was read up to the last
+// markable message, when the account shares read markers
+func (a *App) sendReadMarker(accountJID, contactJID string) {
+	id := a.takeMarkable(accountJID, contactJID)
+	if id == "" || !a.PrivacyEnabled(accountJID, PrivacyReadMarkers) {
+		return
+	}
+	c := a.getConnectedClient(accountJID)
+	if c == nil {
+		return
+	}
+	go func() {
+		_ = c.SendDisplayedMarker(contactJID, id)
+	}()
+}
@@ -0,0 +1,363 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/meszmate/roster/internal/config"
+	"github.com/meszmate/xmpp-go/jid"
+)
+
+// ImportedContact is a contact found in another client's data
+type ImportedContact struct {
+	JID   string `json:"jid"`
+	Name  string `json:"name,omitempty"`
+	Group string `json:"group,omitempty"`
+}
+
+// ImportedAccount is an account found in another client's data
+type ImportedAccount struct {
+	Account       config.Account
+	Contacts      []ImportedContact
+	NeedsPassword bool // the password was not stored, or kept in a keyring
+	Exists        bool // an account with this JID is already configured
+}
+
+// ClientImport holds the accounts read from another client before they
+// are added
+type ClientImport struct {
+	Source   string // Pidgin, Gajim or Dino
+	Path     string
+	Accounts []ImportedAccount
+}
+
+// ReadClientImport reads the accounts and contacts of another XMPP client.
+// It understands Pidgin's accounts.xml (with blist.xml next to it for the
+// contacts), Gajim's config file and Dino's dino.db.
+func (a *App) ReadClientImport(path string) (*ClientImport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var imp *ClientImport
+	switch {
+	case bytes.HasPrefix(data, []byte("SQLite format 3\x00")):
+		imp, err = readDinoImport(path)
+	case bytes.Contains(data, []byte("<protocol>")):
+		imp, err = readPidginImport(path, data)
+	case bytes.Contains(data, []byte("accounts.")):
+		imp, err = readGajimImport(data)
+	default:
+		return nil, fmt.Errorf("not a Pidgin, Gajim or Dino file")
+	}
+	if err != nil {
+		return nil, err
+	}
+	imp.Path = path
+	if len(imp.Accounts) == 0 {
+		return nil, fmt.Errorf("no XMPP accounts found in %s data", imp.Source)
+	}
+
+	a.mu.RLock()
+	for i := range imp.Accounts {
+		for _, existing := range a.accounts.Accounts {
+			if existing.JID == imp.Accounts[i].Account.JID {
+				imp.Accounts[i].Exists = true
+				break
+			}
+		}
+	}
+	a.mu.RUnlock()
+	return imp, nil
+}
+
+// newImportedAccount fills in roster defaults for an account from another client
+func newImportedAccount(bareJID, resource, password string) ImportedAccount {
+	// Resources with placeholders like Gajim's "gajim.$rand" are not kept
+	if strings.ContainsAny(resource, "$%") {
+		resource = ""
+	}
+	return ImportedAccount{
+		Account: config.Account{
+			JID:      bareJID,
+			Password: password,
+			Port:     5222,
+			Resource: resource,
+			OMEMO:    true,
+		},
+		NeedsPassword: password == "",
+	}
+}
+
+type pidginAccounts struct {
+	Accounts []struct {
+		Protocol string `xml:"protocol"`
+		Name     string `xml:"name"`
+		Password string `xml:"password"`
+		Settings []struct {
+			UI       string `xml:"ui,attr"`
+			Settings []struct {
+				Name  string `xml:"name,attr"`
+				Value string `xml:",chardata"`
+			} `xml:"setting"`
+		} `xml:"settings"`
+	} `xml:"account"`
+}
+
+type pidginBuddyList struct {
+	Groups []struct {
+		Name     string `xml:"name,attr"`
+		Contacts []struct {
+			Buddies []struct {
+				Account string `xml:"account,attr"`
+				Proto   string `xml:"proto,attr"`
+				Name    string `xml:"name"`
+				Alias   string `xml:"alias"`
+			} `xml:"buddy"`
+		} `xml:"contact"`
+	} `xml:"blist>group"`
+}
+
+// readPidginImport maps Pidgin's XMPP accounts and, when blist.xml is in the
+// same directory, their buddies
+func readPidginImport(path string, data []byte) (*ClientImport, error) {
+	var parsed pidginAccounts
+	if err := xml.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("invalid Pidgin accounts.xml: %w", err)
+	}
+
+	imp := &ClientImport{Source: "Pidgin"}
+	byName := make(map[string]int)
+	for _, acc := range parsed.Accounts {
+		if acc.Protocol != "prpl-jabber" {
+			continue
+		}
+		full, err := jid.Parse(strings.TrimSpace(acc.Name))
+		if err != nil {
+			continue
+		}
+		imported := newImportedAccount(full.Bare().String(), full.Resource(), acc.Password)
+		for _, settings := range acc.Settings {
+			for _, s := range settings.Settings {
+				value := strings.TrimSpace(s.Value)
+				switch {
+				case s.Name == "connect_server" && settings.UI == "":
+					imported.Account.Server = value
+				case s.Name == "port" && settings.UI == "":
+					if port, err := strconv.Atoi(value); err == nil && port > 0 {
+						imported.Account.Port = port
+					}
+				case s.Name == "auto-login" && settings.UI != "":
+					imported.Account.AutoConnect = value == "1"
+				}
+			}
+		}
+		byName[strings.TrimSpace(acc.Name)] = len(imp.Accounts)
+		imp.Accounts = append(imp.Accounts, imported)
+	}
+
+	blist, err := os.ReadFile(filepath.Join(filepath.Dir(path), "blist.xml"))
+	if err != nil {
+		return imp, nil
+	}
+	var buddies pidginBuddyList
+	if err := xml.Unmarshal(blist, &buddies); err != nil {
+		return imp, nil
+	}
+	for _, group := range buddies.Groups {
+		for _, contact := range group.Contacts {
+			for _, buddy := range contact.Buddies {
+				idx, ok := byName[buddy.Account]
+				if !ok || buddy.Proto != "prpl-jabber" || buddy.Name == "" {
+					continue
+				}
+				imp.Accounts[idx].Contacts = append(imp.Accounts[idx].Contacts, ImportedContact{
+					JID:   buddy.Name,
+					Name:  buddy.Alias,
+					Group: group.Name,
+				})
+			}
+		}
+	}
+	return imp, nil
+}
+
+// readGajimImport maps the accounts in Gajim's config file. Gajim keeps
+// passwords in the system keyring and contacts on the server, so neither
+// can be read from it.
+func readGajimImport(data []byte) (*ClientImport, error) {
+	settings := make(map[string]map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		parts := strings.Split(strings.TrimSpace(key), ".")
+		if len(parts) != 3 || parts[0] != "accounts" {
+			continue
+		}
+		if settings[parts[1]] == nil {
+			settings[parts[1]] = make(map[string]string)
+		}
+		settings[parts[1]][parts[2]] = strings.TrimSpace(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	imp := &ClientImport{Source: "Gajim"}
+	for _, name := range names {
+		s := settings[name]
+		if s["name"] == "" || s["hostname"] == "" || s["anonymous_auth"] == "True" {
+			continue
+		}
+		password := s["password"]
+		if strings.HasPrefix(password, "keyring:") || strings.HasPrefix(password, "libsecret:") || strings.HasPrefix(password, "winvault:") {
+			password = ""
+		}
+		imported := newImportedAccount(s["name"]+"@"+s["hostname"], s["resource"], password)
+		imported.Account.AutoConnect = s["autoconnect"] == "True"
+		if s["use_custom_host"] == "True" {
+			imported.Account.Server = s["custom_host"]
+			if port, err := strconv.Atoi(s["custom_port"]); err == nil && port > 0 {
+				imported.Account.Port = port
+			}
+		}
+		imp.Accounts = append(imp.Accounts, imported)
+	}
+	return imp, nil
+}
+
+// readDinoImport maps the accounts and rosters in Dino's database
+func readDinoImport(path string) (*ClientImport, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT id, bare_jid, resourcepart, password, enabled FROM account`)
+	if err != nil {
+		return nil, fmt.Errorf("not a Dino database: %w", err)
+	}
+	imp := &ClientImport{Source: "Dino"}
+	byID := make(map[int64]int)
+	for rows.Next() {
+		var id int64
+		var bareJID string
+		var resource, password sql.NullString
+		var enabled sql.NullBool
+		if err := rows.Scan(&id, &bareJID, &resource, &password, &enabled); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		imported := newImportedAccount(bareJID, resource.String, password.String)
+		imported.Account.AutoConnect = enabled.Bool
+		byID[id] = len(imp.Accounts)
+		imp.Accounts = append(imp.Accounts, imported)
+	}
+	rows.Close()
+
+	contacts, err := db.Query(`SELECT account_id, jid, handle FROM roster`)
+	if err != nil {
+		return imp, nil
+	}
+	defer contacts.Close()
+	for contacts.Next() {
+		var accountID int64
+		var contactJID string
+		var handle sql.NullString
+		if err := contacts.Scan(&accountID, &contactJID, &handle); err != nil {
+			continue
+		}
+		if idx, ok := byID[accountID]; ok {
+			imp.Accounts[idx].Contacts = append(imp.Accounts[idx].Contacts, ImportedContact{
+				JID:  contactJID,
+				Name: handle.String,
+			})
+		}
+	}
+	return imp, nil
+}
+
+func importedContactsKey(accountJID string) string {
+	return "import:contacts:" + accountJID
+}
+
+// ApplyClientImport adds the imported accounts that are not configured yet.
+// passwords holds what the user typed for accounts without a stored
+// password; those left empty are asked for when connecting. Contacts are
+// added to the server roster the next time each account connects.
+func (a *App) ApplyClientImport(imp *ClientImport, passwords map[string]string) int {
+	added := 0
+	for _, imported := range imp.Accounts {
+		if imported.Exists {
+			continue
+		}
+		acc := imported.Account
+		if acc.Password == "" {
+			acc.Password = passwords[acc.JID]
+		}
+		a.AddAccount(acc)
+		added++
+
+		if len(imported.Contacts) > 0 && a.storage != nil {
+			if raw, err := json.Marshal(imported.Contacts); err == nil {
+				_ = a.storage.SetAppState(importedContactsKey(acc.JID), string(raw))
+			}
+		}
+	}
+	return added
+}
+
+// addImportedContacts adds contacts from a client import that are missing
+// from the account's roster, once the roster has been fetched
+func (a *App) addImportedContacts(accountJID string) {
+	if a.storage == nil {
+		return
+	}
+	raw, err := a.storage.GetAppState(importedContactsKey(accountJID))
+	if err != nil || raw == "" {
+		return
+	}
+	var contacts []ImportedContact
+	if err := json.Unmarshal([]byte(raw), &contacts); err != nil {
+		_ = a.storage.DeleteAppState(importedContactsKey(accountJID))
+		return
+	}
+
+	a.mu.RLock()
+	known := make(map[string]bool)
+	for _, r := range a.rosters {
+		if r.AccountJID == accountJID {
+			known[r.JID] = true
+		}
+	}
+	a.mu.RUnlock()
+
+	// Only tried once, so contacts the user removed are not added back
+	_ = a.storage.DeleteAppState(importedContactsKey(accountJID))
+	for _, contact := range contacts {
+		if !known[contact.JID] {
+			_ = a.AddContactForAccount(accountJID, contact.JID, contact.Name, contact.Group)
+		}
+	}
+}
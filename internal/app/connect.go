@@ -0,0 +1,137 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// connectAttempt is a connection that is still being established
+type connectAttempt struct {
+	cancel context.CancelFunc
+	step   string // reported by the client: dialing, TLS, authentication...
+}
+
+// AutoConnectMsg starts the accounts set to connect on startup. They all
+// connect at once, each reporting its own progress and failure, unless a
+// connect delay holds them back.
+type AutoConnectMsg struct {
+	JIDs   []string
+	Delays []time.Duration // how long after the message each account starts
+}
+
+// ConnectProgress is sent as a connection attempt moves to its next step
+type ConnectProgress struct {
+	AccountJID string
+	Step       string
+}
+
+// startConnect registers a cancellable connection attempt for an account
+func (a *App) startConnect(jidStr string) (context.Context, *connectAttempt) {
+	ctx, cancel := context.WithCancel(context.Background())
+	attempt := &connectAttempt{cancel: cancel}
+
+	a.mu.Lock()
+	if a.connecting == nil {
+		a.connecting = make(map[string]*connectAttempt)
+	}
+	a.connecting[jidStr] = attempt
+	delete(a.connectFailures, jidStr)
+	a.mu.Unlock()
+	return ctx, attempt
+}
+
+// finishConnect forgets an attempt once it has succeeded or failed. A newer
+// attempt for the same account is left registered.
+func (a *App) finishConnect(jidStr string, attempt *connectAttempt) {
+	a.mu.Lock()
+	if a.connecting[jidStr] == attempt {
+		delete(a.connecting, jidStr)
+	}
+	a.mu.Unlock()
+	attempt.cancel()
+}
+
+// setConnectStep records the step an attempt has reached and tells the UI
+func (a *App) setConnectStep(jidStr string, attempt *connectAttempt, step string) {
+	a.mu.Lock()
+	attempt.step = step
+	a.mu.Unlock()
+	a.sendEvent(EventMsg{Type: EventConnectProgress, Data: ConnectProgress{AccountJID: jidStr, Step: step}})
+}
+
+// setConnectFailure remembers why the last attempt of an account failed,
+// for the account list
+func (a *App) setConnectFailure(jidStr string, err error) {
+	a.mu.Lock()
+	if a.connectFailures == nil {
+		a.connectFailures = make(map[string]string)
+	}
+	a.connectFailures[jidStr] = DescribeError(err).Title
+	a.mu.Unlock()
+}
+
+// ConnectAfter connects an account in the background once delay has passed.
+// An account the user connected in the meantime is left alone, one they
+// disconnected is reported as cancelled.
+func (a *App) ConnectAfter(jidStr string, delay time.Duration) tea.Cmd {
+	if delay <= 0 {
+		return a.ConnectInBackground(jidStr)
+	}
+	return func() tea.Msg {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-a.ctx.Done():
+			return nil
+		}
+
+		a.mu.RLock()
+		_, started := a.connecting[jidStr]
+		status := a.accountStatuses[jidStr]
+		a.mu.RUnlock()
+		switch {
+		case started || status == "online":
+			// Its own result reaches the UI
+			return nil
+		case status != "connecting":
+			return ConnectResultMsg{Cancelled: true, Background: true, JID: jidStr}
+		}
+		return a.ConnectInBackground(jidStr)()
+	}
+}
+
+// ConnectInBackground connects an account without making it the current
+// one, unless there is none yet. Its result is marked Background so a
+// failure is reported in the account list rather than a dialog.
+func (a *App) ConnectInBackground(jidStr string) tea.Cmd {
+	acc := a.GetAccount(jidStr)
+	if acc == nil {
+		return nil
+	}
+	cmd := a.doConnect(acc.JID, acc.Password, acc.Server, acc.Port, acc.Session, true)
+	return func() tea.Msg {
+		msg := cmd()
+		if result, ok := msg.(ConnectResultMsg); ok {
+			result.Background = true
+			return result
+		}
+		return msg
+	}
+}
+
+// CancelConnect aborts the connection being established for an account. It
+// reports whether there was one.
+func (a *App) CancelConnect(jidStr string) bool {
+	a.mu.Lock()
+	attempt, ok := a.connecting[jidStr]
+	delete(a.connecting, jidStr)
+	a.mu.Unlock()
+
+	if ok {
+		attempt.cancel()
+	}
+	return ok
+}
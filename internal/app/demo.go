@@ -0,0 +1,257 @@
+package app
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/meszmate/roster/internal/config"
+	"github.com/meszmate/roster/internal/ui/components/chat"
+	"github.com/meszmate/roster/internal/ui/components/roster"
+)
+
+// demoAccountJID is the account shown by --demo
+const demoAccountJID = "you@demo.roster.im"
+
+// demoChurnInterval is how often a demo contact changes presence
+const demoChurnInterval = 4 * time.Second
+
+var demoStatuses = []string{"online", "online", "away", "dnd", "xa", "offline"}
+
+var demoContacts = []struct {
+	name, jid, group, status, statusMsg string
+}{
+	{"Alice Lindqvist", "alice@demo.roster.im", "Friends", "online", "Back from the mountains"},
+	{"Bruno Costa", "bruno@demo.roster.im", "Friends", "away", "Lunch"},
+	{"Chen Wei", "chen@demo.roster.im", "Work", "online", ""},
+	{"Dana Okafor", "dana@demo.roster.im", "Work", "dnd", "Deep work until 4pm"},
+	{"Emil Novak", "emil@demo.roster.im", "Work", "xa", "On vacation"},
+	{"Farah Haddad", "farah@demo.roster.im", "Family", "online", ""},
+	{"Gustav Berg", "gustav@demo.roster.im", "Family", "offline", ""},
+	{"Hana Sato", "hana@demo.roster.im", "Open Source", "online", "Reviewing PRs"},
+	{"Ivan Petrov", "ivan@demo.roster.im", "Open Source", "away", ""},
+	{"Julia Romano", "julia@demo.roster.im", "Friends", "offline", "Ciao!"},
+	{"Kofi Mensah", "kofi@demo.roster.im", "Open Source", "online", ""},
+	{"Lena Fischer", "lena@demo.roster.im", "Work", "online", "In a meeting"},
+}
+
+// demoLine is one message of a scripted conversation. ago is how long
+// before startup it was sent.
+type demoLine struct {
+	outgoing bool
+	ago      time.Duration
+	body     string
+}
+
+var demoConversations = map[string][]demoLine{
+	"alice@demo.roster.im": {
+		{false, 26 * time.Hour, "Are we still on for the hike on Saturday?"},
+		{true, 25 * time.Hour, "Yes! Trailhead at 8?"},
+		{false, 25 * time.Hour, "Perfect, I'll bring coffee ☕"},
+		{false, 40 * time.Minute, "Photos are up: https://example.com/album/mountains"},
+		{false, 39 * time.Minute, "The *view* from the ridge was unreal"},
+	},
+	"chen@demo.roster.im": {
+		{true, 3 * time.Hour, "Did the release build go through?"},
+		{false, 3 * time.Hour, "Green on all platforms. Tagging `v1.4.0` now"},
+		{true, 2 * time.Hour, "🎉"},
+		{false, 12 * time.Minute, "Changelog draft is in the shared folder, can you take a look?"},
+	},
+	"dana@demo.roster.im": {
+		{false, 50 * time.Hour, "Quarterly review moved to Thursday"},
+		{true, 49 * time.Hour, "Thanks for the heads up"},
+	},
+	"hana@demo.roster.im": {
+		{false, 5 * time.Hour, "I left a few comments on your patch"},
+		{false, 5 * time.Hour, "Mostly nits, but the _error handling_ in the parser needs another look"},
+		{true, 4 * time.Hour, "Good catch, pushing a fix tonight"},
+		{false, 8 * time.Minute, "LGTM now, merging"},
+	},
+	"farah@demo.roster.im": {
+		{false, 7 * 24 * time.Hour, "Happy birthday!! 🎂"},
+		{true, 7 * 24 * time.Hour, "Thank you ❤️"},
+	},
+}
+
+var demoReplies = []string{
+	"Sounds good!",
+	"Haha, fair enough",
+	"Let me check and get back to you",
+	"Sure, send it over",
+	"👍",
+	"Can we talk about this tomorrow?",
+	"Oh nice, I didn't know that",
+	"On it",
+}
+
+var demoIncoming = []string{
+	"Hey, got a minute?",
+	"Did you see the news today?",
+	"Meeting notes are ready",
+	"Lunch later?",
+	"Pushed the fix, CI is running",
+	"Thanks again for yesterday!",
+}
+
+// NewDemo creates an App filled with a generated account, contacts and
+// conversations for developing themes and layouts. Nothing is read from or
+// written to disk, nothing connects, and presence changes and replies are
+// simulated.
+func NewDemo(cfg *config.Config) *App {
+	accounts := &config.AccountsConfig{Accounts: []config.Account{{
+		JID:      demoAccountJID,
+		Resource: "roster",
+		OMEMO:    true,
+		Session:  true,
+	}}}
+	app := newApp(cfg, accounts, nil)
+	historyApp.Store(app)
+	app.demo = true
+	app.seedDemo()
+	go app.runDemo()
+	return app
+}
+
+// seedDemo fills the roster and chat histories of the demo account
+func (a *App) seedDemo() {
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.currentAccount = demoAccountJID
+	a.status = "online"
+	a.accountStatuses[demoAccountJID] = "online"
+	a.contactUnreads[demoAccountJID] = make(map[string]int)
+
+	for _, c := range demoContacts {
+		a.rosters = append(a.rosters, roster.Roster{
+			JID:           c.jid,
+			Name:          c.name,
+			Groups:        []string{c.group},
+			Status:        c.status,
+			StatusMsg:     c.statusMsg,
+			AccountJID:    demoAccountJID,
+			AddedToRoster: true,
+			Subscription:  "both",
+		})
+	}
+
+	for contactJID, lines := range demoConversations {
+		key := historyKey(demoAccountJID, contactJID)
+		unread := 0
+		for i, line := range lines {
+			msg := chat.Message{
+				ID:        fmt.Sprintf("demo-%s-%d", contactJID, i),
+				From:      contactJID,
+				To:        demoAccountJID,
+				Body:      line.body,
+				Timestamp: now.Add(-line.ago),
+				Outgoing:  line.outgoing,
+				Encrypted: true,
+			}
+			if line.outgoing {
+				msg.From, msg.To = demoAccountJID, contactJID
+				msg.Status = chat.MessageStatus(StatusRead)
+				unread = 0
+			} else {
+				unread++
+			}
+			a.chatHistory[key] = append(a.chatHistory[key], msg)
+		}
+		// Conversations ending with fresh incoming messages start unread
+		if last := lines[len(lines)-1]; !last.outgoing && last.ago < time.Hour {
+			a.contactUnreads[demoAccountJID][contactJID] = unread
+			a.accountUnreads[demoAccountJID] += unread
+		}
+	}
+}
+
+// runDemo changes presence of random contacts and now and then sends a
+// message from one of them, until the app shuts down
+func (a *App) runDemo() {
+	t := time.NewTicker(demoChurnInterval)
+	defer t.Stop()
+	for tick := 1; ; tick++ {
+		select {
+		case <-t.C:
+		case <-a.ctx.Done():
+			return
+		}
+
+		c := demoContacts[rand.Intn(len(demoContacts))]
+		status := demoStatuses[rand.Intn(len(demoStatuses))]
+		a.setDemoPresence(c.jid, status)
+
+		if tick%5 == 0 && status != "offline" {
+			a.receiveDemoMessage(c.jid, demoIncoming[rand.Intn(len(demoIncoming))])
+		}
+	}
+}
+
+func (a *App) setDemoPresence(contactJID, status string) {
+	a.mu.Lock()
+	statusMsg := ""
+	for i := range a.rosters {
+		if a.rosters[i].AccountJID == demoAccountJID && a.rosters[i].JID == contactJID {
+			a.rosters[i].Status = status
+			statusMsg = a.rosters[i].StatusMsg
+		}
+	}
+	a.mu.Unlock()
+
+	a.sendEvent(EventMsg{Type: EventPresence, Data: PresenceUpdate{
+		AccountJID: demoAccountJID,
+		JID:        contactJID,
+		Status:     status,
+		StatusMsg:  statusMsg,
+	}})
+}
+
+func (a *App) receiveDemoMessage(contactJID, body string) {
+	a.sendEvent(EventMsg{Type: EventTyping, Data: TypingUpdate{
+		AccountJID: demoAccountJID,
+		JID:        contactJID,
+		State:      "active",
+	}})
+	a.IncrementContactUnread(demoAccountJID, contactJID)
+	a.AddChatMessageForAccount(demoAccountJID, contactJID, chat.Message{
+		ID:        fmt.Sprintf("demo-%d", time.Now().UnixNano()),
+		From:      contactJID,
+		To:        demoAccountJID,
+		Body:      body,
+		Timestamp: time.Now(),
+		Encrypted: true,
+	})
+}
+
+// sendDemoMessage echoes a message sent in demo mode and has the contact
+// type a reply a moment later
+func (a *App) sendDemoMessage(accountJID, to, body string) SendMessageResultMsg {
+	msgID := fmt.Sprintf("demo-%d", time.Now().UnixNano())
+	a.AddChatMessageForAccount(accountJID, to, chat.Message{
+		ID:        msgID,
+		From:      accountJID,
+		To:        to,
+		Body:      body,
+		Timestamp: time.Now(),
+		Encrypted: true,
+		Outgoing:  true,
+		Status:    chat.MessageStatus(StatusDelivered),
+	})
+
+	time.AfterFunc(time.Second, func() {
+		a.sendEvent(EventMsg{Type: EventTyping, Data: TypingUpdate{
+			AccountJID: accountJID,
+			JID:        to,
+			State:      "composing",
+			Typing:     true,
+		}})
+	})
+	time.AfterFunc(3*time.Second, func() {
+		a.UpdateMessageStatusForAccount(accountJID, to, msgID, StatusRead)
+		a.receiveDemoMessage(to, demoReplies[rand.Intn(len(demoReplies))])
+	})
+
+	return SendMessageResultMsg{Success: true, MessageID: msgID, To: to}
+}
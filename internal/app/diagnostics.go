@@ -0,0 +1,90 @@
+package app
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/meszmate/roster/internal/client"
+	"github.com/meszmate/roster/internal/ui/components/dialogs"
+)
+
+// diagnosticsTimeout bounds a whole :doctor run
+const diagnosticsTimeout = 45 * time.Second
+
+// DiagnosticCheck is one line of an account health report
+type DiagnosticCheck struct {
+	Name   string
+	Status string // PASS, WARN, FAIL or SKIP
+	Detail string
+}
+
+// DiagnosticsMsg carries the report of a :doctor run
+type DiagnosticsMsg struct {
+	JID    string
+	Checks []DiagnosticCheck
+}
+
+// RunDiagnostics checks why an account might not work: DNS, reachability,
+// TLS and SASL against its server without logging in, then, if the account
+// is connected, the server features roster relies on and the clock skew.
+// The local database gets the full integrity check startup skips.
+// The JID does not need to be a configured account.
+func (a *App) RunDiagnostics(accountJID string) tea.Cmd {
+	ctx, cancel := context.WithTimeout(a.ctx, diagnosticsTimeout)
+	a.RegisterOperation(dialogs.OpDiagnostics, cancel)
+
+	return func() tea.Msg {
+		defer cancel()
+
+		bare, _, _ := strings.Cut(accountJID, "/")
+		domain := bare
+		if _, d, ok := strings.Cut(bare, "@"); ok {
+			domain = d
+		}
+
+		server, port := "", 5222
+		if acc := a.GetAccount(bare); acc != nil {
+			server, port = acc.Server, acc.Port
+		}
+
+		checks := client.Probe(ctx, domain, server, port)
+		if c := a.getConnectedClient(bare); c != nil {
+			checks = append(checks, c.Diagnose()...)
+		} else {
+			checks = append(checks, client.DiagnosticCheck{
+				Name:   "Session",
+				Status: client.CheckSkip,
+				Detail: "account not connected; connect it to check server features",
+			})
+		}
+
+		if a.storage != nil {
+			checks = append(checks, a.databaseCheck())
+		}
+
+		report := make([]DiagnosticCheck, 0, len(checks))
+		for _, check := range checks {
+			report = append(report, DiagnosticCheck{
+				Name:   check.Name,
+				Status: check.Status.String(),
+				Detail: check.Detail,
+			})
+		}
+		return DiagnosticsMsg{JID: bare, Checks: report}
+	}
+}
+
+// databaseCheck runs the full integrity check of the local database
+func (a *App) databaseCheck() client.DiagnosticCheck {
+	check := client.DiagnosticCheck{Name: "Database", Status: client.CheckPass, Detail: "integrity check passed"}
+	problem, err := a.storage.IntegrityCheck()
+	switch {
+	case err != nil:
+		check.Status, check.Detail = client.CheckWarn, "integrity check could not run: "+err.Error()
+	case problem != "":
+		check.Status, check.Detail = client.CheckFail, problem+"; it is rebuilt at the next start if quick_check finds it too"
+	}
+	return check
+}
@@ -0,0 +1,166 @@
+package app
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/meszmate/roster/internal/ui/components/chat"
+)
+
+// expirySweepInterval is how often expired local messages are deleted
+const expirySweepInterval = time.Minute
+
+// minExpiry keeps a typo like :expire 1s from wiping a conversation
+const minExpiry = time.Minute
+
+const expiryPrefPrefix = "expiry:"
+
+func expiryPrefKey(accountJID, contactJID string) string {
+	return expiryPrefPrefix + historyKey(accountJID, contactJID)
+}
+
+// MessagesExpired is sent when local copies of messages of a conversation
+// were deleted by its timer
+type MessagesExpired struct {
+	AccountJID string
+	JID        string
+}
+
+// ParseExpiry parses an auto-delete duration given in days ("7d") or as a
+// Go duration ("12h", "90m")
+func ParseExpiry(s string) (time.Duration, error) {
+	var d time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q (use 12h or 7d)", s)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		parsed, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q (use 12h or 7d)", s)
+		}
+		d = parsed
+	}
+	if d < minExpiry {
+		return 0, fmt.Errorf("duration must be at least %s", minExpiry)
+	}
+	return d, nil
+}
+
+// FormatExpiry formats an auto-delete duration the way ParseExpiry reads it
+func FormatExpiry(d time.Duration) string {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	}
+	return fmt.Sprintf("%dm", d/time.Minute)
+}
+
+// ConversationExpiry returns how long local copies of a conversation's
+// messages are kept, or 0 when they are not auto-deleted
+func (a *App) ConversationExpiry(accountJID, contactJID string) time.Duration {
+	if a.storage == nil {
+		return 0
+	}
+	raw, err := a.storage.GetAppState(expiryPrefKey(accountJID, contactJID))
+	if err != nil || raw == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// SetConversationExpiry sets the auto-delete timer of a conversation and
+// applies it right away. Zero turns it off. Only local copies are deleted;
+// the contact and the server archive keep theirs.
+func (a *App) SetConversationExpiry(accountJID, contactJID string, d time.Duration) error {
+	if a.storage == nil {
+		return fmt.Errorf("storage is not available")
+	}
+	if d <= 0 {
+		return a.storage.DeleteAppState(expiryPrefKey(accountJID, contactJID))
+	}
+	if err := a.storage.SetAppState(expiryPrefKey(accountJID, contactJID), d.String()); err != nil {
+		return err
+	}
+	a.expireConversation(accountJID, contactJID, d)
+	return nil
+}
+
+// expireConversation deletes the messages of a conversation that are older
+// than d, in memory and on disk
+func (a *App) expireConversation(accountJID, contactJID string, d time.Duration) {
+	cutoff := time.Now().Add(-d)
+	key := historyKey(accountJID, contactJID)
+
+	removed := false
+	a.mu.Lock()
+	if history, ok := a.chatHistory[key]; ok {
+		kept := make([]chat.Message, 0, len(history))
+		for _, msg := range history {
+			if !msg.Timestamp.IsZero() && msg.Timestamp.Before(cutoff) {
+				removed = true
+				continue
+			}
+			kept = append(kept, msg)
+		}
+		a.chatHistory[key] = kept
+	}
+	a.mu.Unlock()
+
+	if a.storage != nil {
+		if n, err := a.storage.DeleteMessagesBefore(accountJID, contactJID, cutoff); err == nil && n > 0 {
+			removed = true
+		}
+	}
+	if removed {
+		a.sendEvent(EventMsg{Type: EventMessagesExpired, Data: MessagesExpired{AccountJID: accountJID, JID: contactJID}})
+	}
+}
+
+// expireMessages applies every conversation's auto-delete timer
+func (a *App) expireMessages() {
+	if a.storage == nil {
+		return
+	}
+	prefs, err := a.storage.GetAppStateWithPrefix(expiryPrefPrefix)
+	if err != nil {
+		return
+	}
+	for key, raw := range prefs {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			continue
+		}
+		accountJID, contactJID, ok := strings.Cut(key, "|")
+		if !ok {
+			continue
+		}
+		a.expireConversation(accountJID, contactJID, d)
+	}
+}
+
+// runMessageExpiry deletes expired local messages until the app shuts down
+func (a *App) runMessageExpiry() {
+	a.expireMessages()
+
+	t := time.NewTicker(expirySweepInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			a.expireMessages()
+		case <-a.ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/meszmate/roster/internal/ui/components/chat"
+)
+
+// A conversation whose latest message was OMEMO encrypted is treated as
+// encrypted. A plaintext message after that is a downgrade, which may be
+// an attacker stripping encryption or a contact's client losing its keys:
+// an incoming one gets a warning line in the chat, and sending one needs
+// confirmation in the UI. Once a plaintext message is in the conversation
+// it counts as unencrypted again, so a downgrade is reported only once.
+
+// plaintextWarning is shown above an unencrypted incoming message in an
+// encrypted conversation
+const plaintextWarning = "UNENCRYPTED message received in an OMEMO encrypted conversation. It may have been read or altered in transit."
+
+// lastEncryptedLocked returns when the latest message of a conversation was
+// sent or received, if it was encrypted. The caller holds a.mu.
+func (a *App) lastEncryptedLocked(key string) (time.Time, bool) {
+	history := a.chatHistory[key]
+	for i := len(history) - 1; i >= 0; i-- {
+		msg := history[i]
+		if msg.Type == "system" || msg.Body == "" {
+			continue
+		}
+		return msg.Timestamp, msg.Encrypted
+	}
+	return time.Time{}, false
+}
+
+// ConversationEncrypted reports whether the latest message of a
+// conversation was encrypted
+func (a *App) ConversationEncrypted(accountJID, contactJID string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	_, encrypted := a.lastEncryptedLocked(historyKey(accountJID, contactJID))
+	return encrypted
+}
+
+// PlaintextDowngrade reports whether sending to a contact now would go out
+// unencrypted in a conversation that so far was encrypted
+func (a *App) PlaintextDowngrade(accountJID, contactJID string) bool {
+	return !a.EncryptionEnabled(accountJID, contactJID) && a.ConversationEncrypted(accountJID, contactJID)
+}
+
+// plaintextDowngradeLocked reports whether an incoming message is plaintext
+// arriving after encrypted ones. Older messages, from the archive for
+// instance, do not count. The caller holds a.mu.
+func (a *App) plaintextDowngradeLocked(key string, msg chat.Message) bool {
+	if msg.Outgoing || msg.Encrypted || msg.Body == "" || (msg.Type != "" && msg.Type != "chat") {
+		return false
+	}
+	at, encrypted := a.lastEncryptedLocked(key)
+	return encrypted && !msg.Timestamp.Before(at)
+}
+
+// addDowngradeWarningLocked puts a warning line into a conversation and
+// returns it for the UI. The caller holds a.mu.
+func (a *App) addDowngradeWarningLocked(key string, msg chat.Message) chat.Message {
+	warning := chat.Message{
+		ID:        fmt.Sprintf("downgrade-%d", time.Now().UnixNano()),
+		From:      msg.From,
+		Body:      plaintextWarning,
+		Timestamp: msg.Timestamp,
+		Type:      "system",
+		Warning:   true,
+	}
+	a.appendHistoryLocked(key, warning)
+	return warning
+}
@@ -0,0 +1,60 @@
+package app
+
+import (
+	"fmt"
+)
+
+// Per-contact encryption preferences set with :omemo enable|disable
+const (
+	EncryptionDefault = ""      // follow the account's OMEMO flag
+	EncryptionOMEMO   = "omemo" // always encrypt
+	EncryptionNone    = "none"  // always send plaintext
+)
+
+func encryptionPrefKey(accountJID, contactJID string) string {
+	return "encryption:" + historyKey(accountJID, contactJID)
+}
+
+// ContactEncryption returns the encryption preference set for a contact
+func (a *App) ContactEncryption(accountJID, contactJID string) string {
+	if a.storage == nil {
+		return EncryptionDefault
+	}
+	pref, err := a.storage.GetAppState(encryptionPrefKey(accountJID, contactJID))
+	if err != nil {
+		return EncryptionDefault
+	}
+	return pref
+}
+
+// SetContactEncryption sets the encryption preference for a contact.
+// EncryptionDefault removes it.
+func (a *App) SetContactEncryption(accountJID, contactJID, pref string) error {
+	if a.storage == nil {
+		return fmt.Errorf("storage is not available")
+	}
+	switch pref {
+	case EncryptionDefault:
+		return a.storage.DeleteAppState(encryptionPrefKey(accountJID, contactJID))
+	case EncryptionOMEMO, EncryptionNone:
+		return a.storage.SetAppState(encryptionPrefKey(accountJID, contactJID), pref)
+	}
+	return fmt.Errorf("unknown encryption %q", pref)
+}
+
+// EncryptionEnabled reports whether messages to a contact are sent with
+// OMEMO: the contact preference if set, otherwise the account's OMEMO flag
+// when OMEMO is the default encryption
+func (a *App) EncryptionEnabled(accountJID, contactJID string) bool {
+	switch a.ContactEncryption(accountJID, contactJID) {
+	case EncryptionOMEMO:
+		return true
+	case EncryptionNone:
+		return false
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	acc := a.GetAccount(accountJID)
+	return acc != nil && acc.OMEMO && a.cfg.Encryption.Default == "omemo"
+}
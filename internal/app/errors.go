@@ -0,0 +1,235 @@
+package app
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/meszmate/roster/internal/client"
+)
+
+// ErrorInfo explains an error to the user: what went wrong and what they
+// can do about it
+type ErrorInfo struct {
+	Title   string
+	Message string
+	Hint    string // suggested action, empty when there is none
+	Details string // the error chain for debugging, see ErrorChain
+}
+
+// String joins the message and the hint for display in a dialog
+func (e ErrorInfo) String() string {
+	if e.Hint == "" {
+		return e.Message
+	}
+	return e.Message + "\n\n" + e.Hint
+}
+
+// DescribeError maps an error from the client to a message the user can act
+// on. Errors it does not know are shown as they are.
+func DescribeError(err error) ErrorInfo {
+	if err == nil {
+		return ErrorInfo{Title: "Error", Message: "Unknown error"}
+	}
+	info := describeError(err)
+	info.Details = ErrorChain(err)
+	return info
+}
+
+// ErrorChain lists an error and the errors it wraps, one per line with its
+// type and indented by depth
+func ErrorChain(err error) string {
+	var b strings.Builder
+	var walk func(err error, depth int)
+	walk = func(err error, depth int) {
+		fmt.Fprintf(&b, "%s%T: %v\n", strings.Repeat("  ", depth), err, err)
+		switch wrapped := err.(type) {
+		case interface{ Unwrap() error }:
+			if inner := wrapped.Unwrap(); inner != nil {
+				walk(inner, depth+1)
+			}
+		case interface{ Unwrap() []error }:
+			for _, inner := range wrapped.Unwrap() {
+				walk(inner, depth+1)
+			}
+		}
+	}
+	if err != nil {
+		walk(err, 0)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// describeError is DescribeError without the details
+func describeError(err error) ErrorInfo {
+
+	var authErr *client.AuthError
+	if errors.As(err, &authErr) {
+		info := ErrorInfo{Title: "Login failed"}
+		switch authErr.Condition {
+		case client.ConditionAccountDisabled:
+			info.Message = "The server has disabled this account."
+			info.Hint = "Contact the server administrator."
+		case client.ConditionCredentialsExpired:
+			info.Message = "The password of this account has expired."
+			info.Hint = "Change it with the server's web interface or another client, then update it with :account edit."
+		case client.ConditionTemporaryAuthFailure:
+			info.Message = "The server could not check the password right now."
+			info.Hint = "Try again in a few minutes."
+		default:
+			info.Message = "The server rejected the username or password."
+			info.Hint = "Check the password with :account edit, or :connect to enter it again."
+		}
+		if authErr.Text != "" {
+			info.Message += "\nServer said: " + authErr.Text
+		}
+		return info
+	}
+
+	var tlsErr *client.TLSError
+	if errors.As(err, &tlsErr) {
+		info := ErrorInfo{Title: "Secure connection failed"}
+		var unknownAuthority x509.UnknownAuthorityError
+		var hostname x509.HostnameError
+		var invalid x509.CertificateInvalidError
+		switch {
+		case tlsErr.Rejected:
+			info.Message = "The server refused to encrypt the connection."
+		case errors.As(err, &unknownAuthority):
+			info.Message = "The server's certificate is not signed by a trusted authority."
+		case errors.As(err, &hostname):
+			info.Message = "The server's certificate is for a different domain: " + hostname.Error()
+		case errors.As(err, &invalid):
+			info.Message = "The server's certificate is invalid: " + invalid.Error()
+		default:
+			info.Message = "The TLS handshake failed: " + tlsErr.Err.Error()
+		}
+		info.Hint = "Check the server and port with :account edit, and run :doctor for details."
+		return info
+	}
+
+	var streamErr *client.StreamError
+	if errors.As(err, &streamErr) {
+		info := describeCondition(streamErr.Condition)
+		if info.Title == "" {
+			info = ErrorInfo{Title: "Disconnected by server", Message: "The server closed the connection: " + string(streamErr.Condition) + "."}
+		}
+		if streamErr.Text != "" {
+			info.Message += "\nServer said: " + streamErr.Text
+		}
+		return info
+	}
+
+	if info := describeCondition(client.ErrorCondition(err)); info.Title != "" {
+		return info
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return ErrorInfo{
+			Title:   "Server not found",
+			Message: "Could not look up " + dnsErr.Name + ".",
+			Hint:    "Check the server name with :account edit and your network, or run :doctor.",
+		}
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return ErrorInfo{
+			Title:   "Server unreachable",
+			Message: "Could not connect to the server: " + opErr.Err.Error(),
+			Hint:    "Check the server and port with :account edit and your network, or run :doctor.",
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) || strings.Contains(err.Error(), "timed out") {
+		return ErrorInfo{
+			Title:   "Timed out",
+			Message: "The server did not answer in time: " + err.Error(),
+			Hint:    "The network or server may be slow; try again or run :doctor.",
+		}
+	}
+
+	return ErrorInfo{Title: "Error", Message: err.Error()}
+}
+
+// describeCondition explains a defined error condition, or returns an empty
+// ErrorInfo for one it does not know
+func describeCondition(c client.Condition) ErrorInfo {
+	switch c {
+	case client.ConditionConflict:
+		return ErrorInfo{
+			Title:   "Session replaced",
+			Message: "Another client logged in with the same resource and took over this session.",
+			Hint:    "Give each client its own resource with :account edit, then :connect again.",
+		}
+	case client.ConditionPolicyViolation:
+		return ErrorInfo{
+			Title:   "Blocked by server policy",
+			Message: "The server refused this as against its rules, often for sending too much too fast.",
+			Hint:    "Wait a moment before trying again.",
+		}
+	case client.ConditionResourceConstraint:
+		return ErrorInfo{
+			Title:   "Server busy",
+			Message: "The server lacks the resources to handle this right now.",
+			Hint:    "Try again later.",
+		}
+	case client.ConditionSystemShutdown:
+		return ErrorInfo{
+			Title:   "Server shutting down",
+			Message: "The server is shutting down.",
+			Hint:    "The account tries to reconnect in a few minutes, once the server is likely back.",
+		}
+	case client.ConditionConnectionTimeout:
+		return ErrorInfo{
+			Title:   "Connection timed out",
+			Message: "The server closed the connection after hearing nothing from this client for too long.",
+			Hint:    "The account reconnects on its own.",
+		}
+	case client.ConditionHostUnknown:
+		return ErrorInfo{
+			Title:   "Unknown domain",
+			Message: "The server does not host the domain of this account.",
+			Hint:    "Check the account JID and server with :account edit.",
+		}
+	case client.ConditionNotAuthorized, client.ConditionForbidden, client.ConditionNotAllowed:
+		return ErrorInfo{Title: "Not allowed", Message: "The server did not allow this."}
+	case client.ConditionServiceUnavailable, client.ConditionFeatureNotImplemented:
+		return ErrorInfo{Title: "Not supported", Message: "The server or contact does not support this."}
+	case client.ConditionRemoteServerNotFound:
+		return ErrorInfo{
+			Title:   "Server not found",
+			Message: "The other server could not be reached.",
+			Hint:    "Check the address, or try again later.",
+		}
+	}
+	return ErrorInfo{}
+}
+
+// reconnectUseless reports whether connecting again would fail the same way
+// until the user changes something
+func reconnectUseless(err error) bool {
+	var authErr *client.AuthError
+	if errors.As(err, &authErr) {
+		return authErr.Condition != client.ConditionTemporaryAuthFailure
+	}
+	// Reconnecting after being replaced would in turn replace the other
+	// client, and the two would take turns forever
+	return errors.Is(err, client.ConditionConflict) || errors.Is(err, client.ConditionHostUnknown)
+}
+
+// reconnectWait returns how long to wait at least before reconnecting after
+// err. A server that is shutting down or told us to slow down is not asked
+// again right away.
+func reconnectWait(err error) time.Duration {
+	switch client.ErrorCondition(err) {
+	case client.ConditionSystemShutdown:
+		return 2 * time.Minute
+	case client.ConditionPolicyViolation, client.ConditionResourceConstraint:
+		return time.Minute
+	}
+	return 0
+}
@@ -0,0 +1,123 @@
+package app
+
+import (
+	"sort"
+	"time"
+)
+
+// Terminals that report focus (most do; tmux needs focus-events on) tell
+// roster whether its window is looked at. While it is not, like a GUI
+// client in the background:
+//
+//   - messages in the open conversation count as unread and it is not
+//     marked read until the window has focus again
+//   - only the first message rings; the rest are held and summed up on
+//     return
+//   - with ui.away_on_blur set, the presence goes away after that many
+//     minutes and back on return, unless the status was changed meanwhile
+//   - with chat states shared, the open conversation is told it is
+//     inactive, and active again on return
+//
+// Terminals that never report focus are always taken to be focused.
+
+// FocusReturn sums up what happened while the terminal had no focus
+type FocusReturn struct {
+	Messages int      // notifications held back
+	From     []string // conversations they came from
+	WasAway  bool     // the presence went away and came back
+}
+
+// focusState is what is kept while the terminal has no focus
+type focusState struct {
+	blurred   bool
+	awayTimer *time.Timer
+	saved     *[2]string     // status and message from before going away
+	notified  bool           // the first message since losing focus rang
+	held      map[string]int // conversation -> notifications held back
+}
+
+// Focused reports whether the terminal has focus
+func (a *App) Focused() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return !a.focus.blurred
+}
+
+// TerminalBlurred records that the terminal lost focus
+func (a *App) TerminalBlurred() {
+	a.mu.Lock()
+	if a.focus.blurred {
+		a.mu.Unlock()
+		return
+	}
+	a.focus = focusState{blurred: true}
+	if minutes := a.cfg.UI.AwayOnBlur; minutes > 0 {
+		a.focus.awayTimer = time.AfterFunc(time.Duration(minutes)*time.Minute, a.awayOnBlur)
+	}
+	a.mu.Unlock()
+	a.focusedChatState("inactive")
+}
+
+// TerminalFocused records that the terminal got focus back and returns what
+// happened in the meantime
+func (a *App) TerminalFocused() FocusReturn {
+	a.mu.Lock()
+	state := a.focus
+	a.focus = focusState{}
+	if state.awayTimer != nil {
+		state.awayTimer.Stop()
+	}
+	// A status set by hand while away stays
+	restore := state.saved != nil && a.status == "away"
+	a.mu.Unlock()
+	if state.blurred {
+		a.focusedChatState("active")
+	}
+
+	ret := FocusReturn{WasAway: restore}
+	for jid, n := range state.held {
+		ret.Messages += n
+		ret.From = append(ret.From, jid)
+	}
+	sort.Strings(ret.From)
+	if restore {
+		_ = a.SetStatusAndSend(state.saved[0], state.saved[1])
+	}
+	return ret
+}
+
+// awayOnBlur sets the presence to away once the terminal was unfocused for
+// ui.away_on_blur minutes. Only online and chat go away; dnd and xa say
+// more already.
+func (a *App) awayOnBlur() {
+	a.mu.Lock()
+	if !a.focus.blurred || (a.status != "online" && a.status != "chat") {
+		a.mu.Unlock()
+		return
+	}
+	saved := [2]string{a.status, a.statusMsg}
+	a.focus.saved = &saved
+	message := a.statusMsg
+	a.mu.Unlock()
+
+	_ = a.SetStatusAndSend("away", message)
+}
+
+// holdNotification reports whether a notification should stay silent
+// because the terminal has no focus and one rang already, counting it
+func (a *App) holdNotification(jid string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.focus.blurred {
+		return false
+	}
+	if !a.focus.notified {
+		a.focus.notified = true
+		return false
+	}
+	if a.focus.held == nil {
+		a.focus.held = make(map[string]int)
+	}
+	a.focus.held[jid]++
+	return true
+}
@@ -0,0 +1,196 @@
+package app
+
+import (
+	"expvar"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/meszmate/roster/internal/ui/components/chat"
+)
+
+// Limits of the in-memory chat history. Conversations whose messages are
+// saved are trimmed and evicted past them and reloaded from the database
+// when opened again. What the database cannot give back is never dropped:
+// conversations that are not saved are kept whole, and in saved ones so are
+// the lines that exist only in memory (see reloadable).
+const (
+	maxCachedMessages      = 500 // newest messages kept per conversation, or its :history size
+	maxCachedConversations = 50  // conversations kept, least recently used evicted first
+)
+
+// Chat history counters, served at /debug/vars with --debug-addr. The sizes
+// are counted when read rather than on every message.
+var (
+	historyEvictions = expvar.NewInt("roster.history.evictions")
+	historyTrimmed   = expvar.NewInt("roster.history.trimmed_messages")
+
+	// historyApp is the App whose history the size counters describe
+	historyApp atomic.Pointer[App]
+)
+
+func init() {
+	expvar.Publish("roster.history.conversations", expvar.Func(func() any {
+		conversations, _, _ := historyApp.Load().historySize()
+		return conversations
+	}))
+	expvar.Publish("roster.history.messages", expvar.Func(func() any {
+		_, messages, _ := historyApp.Load().historySize()
+		return messages
+	}))
+	expvar.Publish("roster.history.body_bytes", expvar.Func(func() any {
+		_, _, bytes := historyApp.Load().historySize()
+		return bytes
+	}))
+}
+
+// historySize counts the conversations and messages in memory and the bytes
+// of their bodies
+func (a *App) historySize() (conversations, messages, bytes int) {
+	if a == nil {
+		return 0, 0, 0
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, history := range a.chatHistory {
+		messages += len(history)
+		for _, msg := range history {
+			bytes += len(msg.Body)
+		}
+	}
+	return len(a.chatHistory), messages, bytes
+}
+
+// reloadable reports whether a message would come back as it is from the
+// database. System lines, security warnings and sends that failed are never
+// saved, and corrections, reactions, JSON payloads and files are not read
+// back with the message.
+func reloadable(msg chat.Message) bool {
+	return msg.Type != "system" && !msg.Warning && msg.Status != chat.MessageStatus(StatusFailed) &&
+		msg.CorrectedID == "" && len(msg.Reactions) == 0 && msg.JSON == "" &&
+		msg.FileURL == "" && msg.FileName == ""
+}
+
+// appendHistoryLocked adds a message to a conversation's history and marks
+// the conversation used. Callers hold a.mu and call capHistory after
+// releasing it.
+func (a *App) appendHistoryLocked(key string, msg chat.Message) {
+	if _, ok := a.chatHistory[key]; !ok {
+		a.historyAdded = true
+	}
+	a.chatHistory[key] = append(a.chatHistory[key], msg)
+	a.touchHistoryLocked(key)
+}
+
+// touchHistoryLocked marks a conversation as the most recently used
+func (a *App) touchHistoryLocked(key string) {
+	a.historyClock++
+	a.historyUsed[key] = a.historyClock
+}
+
+// historyLimit is what capHistory needs of a conversation's settings, read
+// from the database once rather than on every message
+type historyLimit struct {
+	saving string // ConversationSaving
+	keep   int    // messages kept when trimming
+}
+
+// historyLimit returns the cached settings of a conversation, looking them up
+// the first time. Callers do not hold a.mu.
+func (a *App) historyLimit(key string) historyLimit {
+	a.mu.RLock()
+	limit, ok := a.historyLimits[key]
+	a.mu.RUnlock()
+	if ok {
+		return limit
+	}
+
+	// Conversations without an account are never saved
+	limit = historyLimit{saving: SavingNever, keep: maxCachedMessages}
+	if account, contact, ok := strings.Cut(key, "|"); ok {
+		limit.saving = a.ConversationSaving(account, contact)
+		// A room set to load more with :history keeps that many
+		limit.keep = max(maxCachedMessages, a.historyLoadLimit(account, contact))
+	}
+	a.mu.Lock()
+	a.historyLimits[key] = limit
+	a.mu.Unlock()
+	return limit
+}
+
+// forgetHistoryLimit drops the cached settings of a conversation after they
+// changed
+func (a *App) forgetHistoryLimit(accountJID, contactJID string) {
+	a.mu.Lock()
+	delete(a.historyLimits, historyKey(accountJID, contactJID))
+	a.mu.Unlock()
+}
+
+// capHistory trims conversations to maxCachedMessages and, when one was
+// added, evicts the least recently used ones past maxCachedConversations.
+// Only conversations whose messages are saved are touched; trimming keeps
+// their messages that are not reloadable, and a conversation holding any is
+// not evicted.
+func (a *App) capHistory() {
+	a.mu.Lock()
+	var candidates []string
+	for key, messages := range a.chatHistory {
+		if len(messages) > maxCachedMessages {
+			candidates = append(candidates, key)
+		}
+	}
+	if a.historyAdded && len(a.chatHistory) > maxCachedConversations {
+		candidates = candidates[:0]
+		for key := range a.chatHistory {
+			candidates = append(candidates, key)
+		}
+		// Oldest use first, so eviction takes from the front
+		sort.Slice(candidates, func(i, j int) bool {
+			return a.historyUsed[candidates[i]] < a.historyUsed[candidates[j]]
+		})
+	}
+	a.historyAdded = false
+	a.mu.Unlock()
+	if len(candidates) == 0 {
+		return
+	}
+
+	// Looked up before locking, as the first lookup reads the database
+	limits := make(map[string]historyLimit, len(candidates))
+	for _, key := range candidates {
+		limits[key] = a.historyLimit(key)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, key := range candidates {
+		messages, ok := a.chatHistory[key]
+		limit := limits[key]
+		if a.storage == nil || !a.savingWith(limit.saving) || !ok {
+			continue
+		}
+		if len(a.chatHistory) > maxCachedConversations && !slices.ContainsFunc(messages, func(msg chat.Message) bool {
+			return !reloadable(msg)
+		}) {
+			delete(a.chatHistory, key)
+			delete(a.historyUsed, key)
+			delete(a.historyLimits, key)
+			historyEvictions.Add(1)
+			continue
+		}
+		if len(messages) > limit.keep {
+			// Copied so the dropped messages can be freed
+			cut := len(messages) - limit.keep
+			kept := make([]chat.Message, 0, limit.keep)
+			for _, msg := range messages[:cut] {
+				if !reloadable(msg) {
+					kept = append(kept, msg)
+				}
+			}
+			kept = append(kept, messages[cut:]...)
+			a.chatHistory[key] = kept
+			historyTrimmed.Add(int64(len(messages) - len(kept)))
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package app
+
+// What is sent from the composer is remembered per conversation for the
+// session, so Up and Down in the composer bring it back like shell
+// history. It is kept in memory only.
+
+// inputHistoryLimit is how many sent messages a conversation remembers
+const inputHistoryLimit = 100
+
+// RememberSentInput adds a message sent to a conversation to its composer
+// history, unless it repeats the last one
+func (a *App) RememberSentInput(accountJID, contactJID, body string) {
+	if body == "" || contactJID == "" {
+		return
+	}
+	key := historyKey(accountJID, contactJID)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	sent := a.sentInput[key]
+	if len(sent) > 0 && sent[len(sent)-1] == body {
+		return
+	}
+	sent = append(sent, body)
+	if len(sent) > inputHistoryLimit {
+		sent = sent[len(sent)-inputHistoryLimit:]
+	}
+	if a.sentInput == nil {
+		a.sentInput = make(map[string][]string)
+	}
+	a.sentInput[key] = sent
+}
+
+// SentInput returns the composer history of a conversation, oldest first
+func (a *App) SentInput(accountJID, contactJID string) []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return append([]string(nil), a.sentInput[historyKey(accountJID, contactJID)]...)
+}
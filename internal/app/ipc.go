@@ -0,0 +1,120 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/meszmate/roster/internal/config"
+)
+
+// The first running roster listens on a unix socket, so a roster started
+// as the xmpp: URI handler can pass the link on to it and exit instead of
+// opening a second session.
+
+// ipcTimeout bounds connecting to and talking with the running instance
+const ipcTimeout = 2 * time.Second
+
+// IPCSocketPath returns the socket the running instance listens on
+func IPCSocketPath() (string, error) {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "roster.sock"), nil
+	}
+	paths, err := config.GetPaths()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(paths.DataDir, "roster.sock"), nil
+}
+
+// DeliverURI hands an xmpp: URI to the running instance, which opens it as
+// if it was clicked there. It fails when no instance is running.
+func DeliverURI(uri string) error {
+	path, err := IPCSocketPath()
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("unix", path, ipcTimeout)
+	if err != nil {
+		return fmt.Errorf("roster is not running: %w", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(ipcTimeout))
+
+	if _, err := fmt.Fprintf(conn, "open %s\n", uri); err != nil {
+		return err
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("no reply from the running instance: %w", err)
+	}
+	if reply = strings.TrimSpace(reply); reply != "ok" {
+		return fmt.Errorf("running instance refused the link: %s", reply)
+	}
+	return nil
+}
+
+// startIPC listens for links from DeliverURI until the app closes. Only
+// the first instance listens; later ones leave the socket alone.
+func (a *App) startIPC() {
+	path, err := IPCSocketPath()
+	if err != nil {
+		return
+	}
+	if conn, err := net.DialTimeout("unix", path, ipcTimeout); err == nil {
+		conn.Close()
+		return
+	}
+	// Nobody answers, so the socket was left behind by a crashed instance
+	_ = os.Remove(path)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to listen for xmpp: links: %v\n", err)
+		return
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to listen for xmpp: links: %v\n", err)
+		return
+	}
+	_ = os.Chmod(path, 0600)
+
+	go func() {
+		<-a.ctx.Done()
+		ln.Close() // also removes the socket file
+	}()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go a.serveIPC(conn)
+		}
+	}()
+}
+
+// serveIPC handles one "open <uri>" request
+func (a *App) serveIPC(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(ipcTimeout))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+	cmd, uri, _ := strings.Cut(strings.TrimSpace(line), " ")
+	if cmd != "open" || !strings.HasPrefix(uri, "xmpp:") {
+		fmt.Fprintln(conn, "error: expected open <xmpp:uri>")
+		return
+	}
+	if a.ctx.Err() != nil {
+		fmt.Fprintln(conn, "error: shutting down")
+		return
+	}
+	a.sendEvent(EventMsg{Type: EventOpenURI, Data: uri})
+	fmt.Fprintln(conn, "ok")
+}
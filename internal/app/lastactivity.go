@@ -0,0 +1,46 @@
+package app
+
+import (
+	"time"
+
+	"github.com/meszmate/xmpp-go/jid"
+)
+
+// Contacts may ask how long the user has been idle (XEP-0012). When an
+// account shares it, contacts it shares presence with are told the time
+// since the last key was pressed; everyone else is refused the same way as
+// when it is off.
+
+// NoteUserInput records that the user did something, for last activity
+func (a *App) NoteUserInput() {
+	a.mu.Lock()
+	a.lastInput = time.Now()
+	a.mu.Unlock()
+}
+
+// lastActivityReply is how long the user has been idle, for a last
+// activity query from someone the account shares it with, or nil
+func (a *App) lastActivityReply(accountJID string, from jid.JID) *time.Duration {
+	if from.IsZero() || !a.PrivacyEnabled(accountJID, PrivacyLastActivity) {
+		return nil
+	}
+	contactJID := from.Bare().String()
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	shared := false
+	for _, r := range a.rosters {
+		if r.AccountJID == accountJID && r.JID == contactJID {
+			shared = r.Subscription == "from" || r.Subscription == "both"
+			break
+		}
+	}
+	if !shared {
+		return nil
+	}
+	idle := time.Duration(0)
+	if !a.lastInput.IsZero() {
+		idle = time.Since(a.lastInput)
+	}
+	return &idle
+}
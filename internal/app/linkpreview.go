@@ -0,0 +1,240 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// LinkPreview is the title and description of a linked page
+type LinkPreview struct {
+	URL         string    `json:"url"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	FetchedAt   time.Time `json:"fetched_at"`
+}
+
+// LinkPreviewMsg is sent when a preview has been loaded from cache or fetched
+type LinkPreviewMsg struct {
+	Preview LinkPreview
+	Error   string
+}
+
+// maxPreviewBody limits how much of a page is read to find its metadata
+const maxPreviewBody = 100 * 1024
+
+var (
+	metaTitleRe = regexp.MustCompile(`(?is)<title[^>]*>([^<]+)</title>`)
+	metaTagRes  = map[string][]*regexp.Regexp{
+		"og:title":       metaTagPatterns("og:title"),
+		"og:description": metaTagPatterns("og:description"),
+		"description":    metaTagPatterns("description"),
+	}
+)
+
+// metaTagPatterns matches <meta property|name="..." content="..."> in
+// either attribute order
+func metaTagPatterns(name string) []*regexp.Regexp {
+	quoted := regexp.QuoteMeta(name)
+	return []*regexp.Regexp{
+		regexp.MustCompile(`(?i)<meta[^>]+(?:property|name)=["']` + quoted + `["'][^>]+content=["']([^"']+)["']`),
+		regexp.MustCompile(`(?i)<meta[^>]+content=["']([^"']+)["'][^>]+(?:property|name)=["']` + quoted + `["']`),
+	}
+}
+
+func linkPreviewCacheKey(link string) string {
+	return "linkpreview:cache:" + link
+}
+
+// linkPreviewOptInKey is account wide when contactJID is empty
+func linkPreviewOptInKey(accountJID, contactJID string) string {
+	if contactJID == "" {
+		return "linkpreview:optin:" + accountJID
+	}
+	return "linkpreview:optin:" + historyKey(accountJID, contactJID)
+}
+
+// LinkPreviewOptIn reports whether previews are enabled for the contact and
+// for the whole account
+func (a *App) LinkPreviewOptIn(accountJID, contactJID string) (contact, account bool) {
+	if a.storage == nil {
+		return false, false
+	}
+	if raw, err := a.storage.GetAppState(linkPreviewOptInKey(accountJID, contactJID)); err == nil {
+		contact = raw == "1"
+	}
+	if raw, err := a.storage.GetAppState(linkPreviewOptInKey(accountJID, "")); err == nil {
+		account = raw == "1"
+	}
+	return contact, account
+}
+
+// SetLinkPreviewOptIn enables or disables previews for a contact, or for
+// the whole account when contactJID is empty
+func (a *App) SetLinkPreviewOptIn(accountJID, contactJID string, enabled bool) error {
+	if a.storage == nil {
+		return fmt.Errorf("storage is not available")
+	}
+	key := linkPreviewOptInKey(accountJID, contactJID)
+	if !enabled {
+		return a.storage.DeleteAppState(key)
+	}
+	return a.storage.SetAppState(key, "1")
+}
+
+// LinkPreviewsAllowed reports whether links in a conversation may be fetched
+func (a *App) LinkPreviewsAllowed(accountJID, contactJID string) bool {
+	if !a.cfg.LinkPreviews.Enabled {
+		return false
+	}
+	contact, account := a.LinkPreviewOptIn(accountJID, contactJID)
+	return contact || account
+}
+
+// linkPreviewable reports whether a link may be fetched under the config
+func (a *App) linkPreviewable(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		return a.cfg.LinkPreviews.AllowHTTP
+	}
+	return false
+}
+
+// cachedLinkPreview returns a preview that is still within the cache TTL
+func (a *App) cachedLinkPreview(link string) (LinkPreview, bool) {
+	if a.storage == nil {
+		return LinkPreview{}, false
+	}
+	raw, err := a.storage.GetAppState(linkPreviewCacheKey(link))
+	if err != nil || raw == "" {
+		return LinkPreview{}, false
+	}
+	var preview LinkPreview
+	if err := json.Unmarshal([]byte(raw), &preview); err != nil {
+		return LinkPreview{}, false
+	}
+	ttl := time.Duration(a.cfg.LinkPreviews.CacheHours) * time.Hour
+	if time.Since(preview.FetchedAt) > ttl {
+		return LinkPreview{}, false
+	}
+	return preview, true
+}
+
+// FetchLinkPreview loads the preview of a link from the cache, fetching the
+// page when there is no fresh entry
+func (a *App) FetchLinkPreview(link string) tea.Cmd {
+	return func() tea.Msg {
+		if !a.linkPreviewable(link) {
+			return LinkPreviewMsg{Preview: LinkPreview{URL: link}, Error: "link not previewable"}
+		}
+		if preview, ok := a.cachedLinkPreview(link); ok {
+			return LinkPreviewMsg{Preview: preview}
+		}
+
+		a.mu.Lock()
+		if a.previewFetching == nil {
+			a.previewFetching = make(map[string]bool)
+		}
+		if a.previewFetching[link] {
+			a.mu.Unlock()
+			return nil
+		}
+		a.previewFetching[link] = true
+		a.mu.Unlock()
+		defer func() {
+			a.mu.Lock()
+			delete(a.previewFetching, link)
+			a.mu.Unlock()
+		}()
+
+		preview, err := fetchLinkPreview(link, a.cfg.LinkPreviews.AllowHTTP)
+		if err != nil {
+			return LinkPreviewMsg{Preview: LinkPreview{URL: link}, Error: err.Error()}
+		}
+
+		// Cache misses too, so pages without metadata are not refetched
+		if a.storage != nil {
+			if raw, err := json.Marshal(preview); err == nil {
+				_ = a.storage.SetAppState(linkPreviewCacheKey(link), string(raw))
+			}
+		}
+		return LinkPreviewMsg{Preview: preview}
+	}
+}
+
+// fetchLinkPreview reads the title and description of a page
+func fetchLinkPreview(link string, allowHTTP bool) (LinkPreview, error) {
+	preview := LinkPreview{URL: link, FetchedAt: time.Now()}
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return fmt.Errorf("too many redirects")
+			}
+			if req.URL.Scheme != "https" && !allowHTTP {
+				return fmt.Errorf("redirected to insecure URL")
+			}
+			return nil
+		},
+	}
+	resp, err := client.Get(link)
+	if err != nil {
+		return preview, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return preview, nil
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" && !strings.Contains(ct, "html") {
+		return preview, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPreviewBody))
+	if err != nil {
+		return preview, err
+	}
+	page := string(body)
+
+	preview.Title = extractMetaTag(page, "og:title")
+	if preview.Title == "" {
+		if match := metaTitleRe.FindStringSubmatch(page); len(match) > 1 {
+			preview.Title = cleanPreviewText(match[1])
+		}
+	}
+	preview.Description = extractMetaTag(page, "og:description")
+	if preview.Description == "" {
+		preview.Description = extractMetaTag(page, "description")
+	}
+	return preview, nil
+}
+
+// extractMetaTag returns the content of a <meta property|name="..."> tag
+func extractMetaTag(page, name string) string {
+	for _, re := range metaTagRes[name] {
+		if match := re.FindStringSubmatch(page); len(match) > 1 {
+			return cleanPreviewText(match[1])
+		}
+	}
+	return ""
+}
+
+// cleanPreviewText unescapes HTML entities and collapses whitespace
+func cleanPreviewText(s string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+}
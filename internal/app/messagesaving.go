@@ -0,0 +1,67 @@
+package app
+
+import (
+	"fmt"
+)
+
+// Per-conversation message saving set with :logging always|never|default
+const (
+	SavingDefault = ""       // follow storage.save_messages
+	SavingAlways  = "always" // save even when saving is off globally
+	SavingNever   = "never"  // never write the conversation to disk
+)
+
+func savingPrefKey(accountJID, contactJID string) string {
+	return "saving:" + historyKey(accountJID, contactJID)
+}
+
+// ConversationSaving returns the message saving preference set for a
+// contact or room
+func (a *App) ConversationSaving(accountJID, contactJID string) string {
+	if a.storage == nil {
+		return SavingDefault
+	}
+	pref, err := a.storage.GetAppState(savingPrefKey(accountJID, contactJID))
+	if err != nil {
+		return SavingDefault
+	}
+	return pref
+}
+
+// SetConversationSaving sets the message saving preference for a contact or
+// room. SavingDefault removes it.
+func (a *App) SetConversationSaving(accountJID, contactJID, pref string) error {
+	if a.storage == nil {
+		return fmt.Errorf("storage is not available")
+	}
+	defer a.forgetHistoryLimit(accountJID, contactJID)
+	switch pref {
+	case SavingDefault:
+		return a.storage.DeleteAppState(savingPrefKey(accountJID, contactJID))
+	case SavingAlways, SavingNever:
+		return a.storage.SetAppState(savingPrefKey(accountJID, contactJID), pref)
+	}
+	return fmt.Errorf("unknown saving mode %q", pref)
+}
+
+// SavingEnabled reports whether messages of a conversation are written to
+// the database: the conversation preference if set, otherwise
+// storage.save_messages
+func (a *App) SavingEnabled(accountJID, contactJID string) bool {
+	if a.storage == nil {
+		return false
+	}
+	return a.savingWith(a.ConversationSaving(accountJID, contactJID))
+}
+
+// savingWith applies a conversation's saving preference to
+// storage.save_messages
+func (a *App) savingWith(pref string) bool {
+	switch pref {
+	case SavingAlways:
+		return true
+	case SavingNever:
+		return false
+	}
+	return a.cfg.Storage.SaveMessages
+}
@@ -0,0 +1,237 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/meszmate/roster/internal/client"
+	"github.com/meszmate/roster/internal/ui/components/chat"
+)
+
+// Rooms have their own events next to the chat ones. The room's presence
+// about each occupant keeps a list of who is in it with their role and
+// affiliation (EventMUCPresence); our own presence says the join is done
+// (EventMUCJoined) or that we are out (EventMUCLeft). Messages of rooms we
+// are in go out as EventMUCMessage, as do subject changes and the notices
+// of occupants joining and leaving. A room first lists everyone present
+// and then us, so notices only start after our own presence. Notices are
+// kept in memory, not in the database or the chat logs.
+
+// RoomOccupant is someone in a room as the room's presence describes them
+type RoomOccupant struct {
+	Nick        string
+	JID         string // the real JID, when the room reveals it
+	Role        string // moderator, participant or visitor
+	Affiliation string // owner, admin, member or none
+	Status      string // online, away, dnd, xa or chat
+	StatusMsg   string
+}
+
+// MUCPresence reports an occupant joining, changing or leaving a room
+type MUCPresence struct {
+	AccountJID string
+	Room       string
+	Occupant   RoomOccupant
+	Left       bool
+	Self       bool // the occupant is us
+}
+
+// MUCRoom names a room we joined or left
+type MUCRoom struct {
+	AccountJID string
+	Room       string
+	Nick       string
+}
+
+// MUCMessage is a message in a room, or a notice about it. Subject is set
+// when the message changed the room's subject.
+type MUCMessage struct {
+	ChatMessage
+	Room    string
+	Nick    string
+	Subject string
+}
+
+// inRoom reports whether we joined or are joining a room
+func (a *App) inRoom(accountJID, jidStr string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.roomNicks[historyKey(accountJID, jidStr)] != ""
+}
+
+// messageEvent is the event announcing a message of a conversation, a MUC
+// one for rooms we are in
+func (a *App) messageEvent(accountJID, conversation string, cm ChatMessage) EventMsg {
+	if !a.inRoom(accountJID, conversation) {
+		return EventMsg{Type: EventMessage, Data: cm}
+	}
+	_, nick, _ := strings.Cut(cm.From, "/")
+	if cm.Outgoing {
+		nick = a.RoomNick(accountJID, conversation)
+	}
+	return EventMsg{Type: EventMUCMessage, Data: MUCMessage{ChatMessage: cm, Room: conversation, Nick: nick}}
+}
+
+// handleMUCPresence keeps the occupant list of a room and tells who came
+// and went
+func (a *App) handleMUCPresence(accountJID string, p client.Presence) {
+	if p.Room == nil || p.From.Resource() == "" {
+		return
+	}
+	room := p.From.Bare().String()
+	key := historyKey(accountJID, room)
+	occupant := RoomOccupant{
+		Nick:        p.From.Resource(),
+		JID:         p.Room.JID,
+		Role:        p.Room.Role,
+		Affiliation: p.Room.Affiliation,
+		Status:      mapShowToStatus(p.Type, p.Show),
+		StatusMsg:   p.Status,
+	}
+	left := p.Type == "unavailable"
+
+	a.mu.Lock()
+	if a.roomOccupants == nil {
+		a.roomOccupants = make(map[string]map[string]RoomOccupant)
+		a.roomJoined = make(map[string]bool)
+	}
+	occupants := a.roomOccupants[key]
+	if occupants == nil {
+		occupants = make(map[string]RoomOccupant)
+		a.roomOccupants[key] = occupants
+	}
+	_, known := occupants[occupant.Nick]
+	joined := a.roomJoined[key]
+	switch {
+	case p.Room.Self && left:
+		delete(a.roomOccupants, key)
+		delete(a.roomJoined, key)
+		delete(a.roomSubjects, key)
+	case p.Room.Self:
+		a.roomJoined[key] = true
+		occupants[occupant.Nick] = occupant
+	case left:
+		delete(occupants, occupant.Nick)
+	default:
+		occupants[occupant.Nick] = occupant
+	}
+	a.mu.Unlock()
+
+	a.sendEvent(EventMsg{Type: EventMUCPresence, Data: MUCPresence{
+		AccountJID: accountJID,
+		Room:       room,
+		Occupant:   occupant,
+		Left:       left,
+		Self:       p.Room.Self,
+	}})
+
+	switch {
+	case p.Room.Self && left:
+		a.sendEvent(EventMsg{Type: EventMUCLeft, Data: MUCRoom{AccountJID: accountJID, Room: room, Nick: occupant.Nick}})
+	case p.Room.Self && !joined:
+		a.sendEvent(EventMsg{Type: EventMUCJoined, Data: MUCRoom{AccountJID: accountJID, Room: room, Nick: occupant.Nick}})
+	case joined && left:
+		notice := occupant.Nick + " left"
+		if p.Status != "" {
+			notice += " (" + p.Status + ")"
+		}
+		a.addRoomNotice(accountJID, room, notice, "")
+	case joined && !known:
+		a.addRoomNotice(accountJID, room, occupant.Nick+" joined", "")
+	}
+}
+
+// clearRoomPresence forgets who is in the rooms of an account that lost
+// its connection, which takes us out of them
+func (a *App) clearRoomPresence(accountJID string) {
+	prefix := historyKey(accountJID, "")
+	var left []string
+	a.mu.Lock()
+	for key := range a.roomOccupants {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if a.roomJoined[key] {
+			left = append(left, strings.TrimPrefix(key, prefix))
+		}
+		delete(a.roomOccupants, key)
+		delete(a.roomJoined, key)
+		delete(a.roomSubjects, key)
+	}
+	a.mu.Unlock()
+
+	for _, room := range left {
+		a.sendEvent(EventMsg{Type: EventMUCLeft, Data: MUCRoom{AccountJID: accountJID, Room: room}})
+	}
+}
+
+// setRoomSubject records a room's subject. The first one after joining is
+// the current subject, later ones are changes by an occupant.
+func (a *App) setRoomSubject(accountJID, room, nick, subject string) {
+	key := historyKey(accountJID, room)
+	a.mu.Lock()
+	if a.roomSubjects == nil {
+		a.roomSubjects = make(map[string]string)
+	}
+	old, known := a.roomSubjects[key]
+	a.roomSubjects[key] = subject
+	a.mu.Unlock()
+	if known && old == subject {
+		return
+	}
+
+	notice := "Subject: " + subject
+	if known && nick != "" {
+		notice = nick + " changed the subject to: " + subject
+	}
+	a.addRoomNotice(accountJID, room, notice, subject)
+}
+
+// RoomSubject returns the subject of a room we are in
+func (a *App) RoomSubject(accountJID, room string) string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.roomSubjects[historyKey(accountJID, room)]
+}
+
+// GetRoomParticipants returns who is in a room now
+func (a *App) GetRoomParticipants(accountJID, room string) []RoomOccupant {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	occupants := a.roomOccupants[historyKey(accountJID, room)]
+	out := make([]RoomOccupant, 0, len(occupants))
+	for _, o := range occupants {
+		out = append(out, o)
+	}
+	return out
+}
+
+// addRoomNotice puts a system line into a room's conversation for this
+// session only
+func (a *App) addRoomNotice(accountJID, room, body, subject string) {
+	msg := chat.Message{
+		ID:        fmt.Sprintf("muc-%d", time.Now().UnixNano()),
+		From:      room,
+		Body:      body,
+		Timestamp: time.Now(),
+		Type:      "system",
+	}
+	a.mu.Lock()
+	a.appendHistoryLocked(historyKey(accountJID, room), msg)
+	a.mu.Unlock()
+	a.capHistory()
+
+	a.sendEvent(EventMsg{Type: EventMUCMessage, Data: MUCMessage{
+		ChatMessage: ChatMessage{
+			AccountJID: accountJID,
+			ID:         msg.ID,
+			From:       msg.From,
+			Body:       msg.Body,
+			Timestamp:  msg.Timestamp,
+			Type:       msg.Type,
+		},
+		Room:    room,
+		Subject: subject,
+	}})
+}
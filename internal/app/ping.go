@@ -0,0 +1,140 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/meszmate/roster/internal/client"
+)
+
+// pingEntry is the last ping of a full JID, or that one is under way
+type pingEntry struct {
+	RTT        time.Duration
+	Err        string
+	NoResponse bool
+	Pending    bool
+}
+
+// PingResultMsg is sent when a ping to a contact's client came back or
+// timed out
+type PingResultMsg struct {
+	AccountJID string
+	JID        string // full JID that was pinged
+	RTT        time.Duration
+	Error      string // the error the client answered with, or why it failed
+	NoResponse bool
+}
+
+// Describe formats the outcome, e.g. "42 ms" or "no response"
+func (r PingResultMsg) Describe() string {
+	return pingEntry{RTT: r.RTT, Err: r.Error, NoResponse: r.NoResponse}.describe()
+}
+
+func (e pingEntry) describe() string {
+	switch {
+	case e.Pending:
+		return "pinging..."
+	case e.NoResponse:
+		return "no response"
+	case e.Err != "":
+		if e.RTT > 0 {
+			return fmt.Sprintf("answered with %s in %s", e.Err, formatRTT(e.RTT))
+		}
+		return e.Err
+	}
+	return formatRTT(e.RTT)
+}
+
+// formatRTT rounds a round trip time for display
+func formatRTT(d time.Duration) string {
+	if d < time.Second {
+		return fmt.Sprintf("%d ms", d.Milliseconds())
+	}
+	return fmt.Sprintf("%.1f s", d.Seconds())
+}
+
+// ContactResources returns the online clients of a contact, highest
+// priority first
+func (a *App) ContactResources(accountJID, contactJID string) []client.Resource {
+	a.mu.RLock()
+	c := a.clients[accountJID]
+	a.mu.RUnlock()
+	if c == nil {
+		return nil
+	}
+	return c.Resources(contactJID)
+}
+
+// PingStatus describes the last ping of a full JID, or "" when it was not
+// pinged yet
+func (a *App) PingStatus(accountJID, fullJID string) string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	entry := a.pings[historyKey(accountJID, fullJID)]
+	if entry == nil {
+		return ""
+	}
+	return entry.describe()
+}
+
+// PingContact pings a full JID, or every online client of a bare JID, with
+// XEP-0199. Each answer arrives as its own PingResultMsg.
+func (a *App) PingContact(accountJID, target string) tea.Cmd {
+	targets := []string{target}
+	if !strings.Contains(target, "/") && strings.Contains(target, "@") {
+		targets = targets[:0]
+		for _, r := range a.ContactResources(accountJID, target) {
+			targets = append(targets, r.JID)
+		}
+		if len(targets) == 0 {
+			return func() tea.Msg {
+				return PingResultMsg{AccountJID: accountJID, JID: target, Error: "no online client to ping"}
+			}
+		}
+	}
+
+	a.mu.Lock()
+	if a.pings == nil {
+		a.pings = make(map[string]*pingEntry)
+	}
+	for _, jid := range targets {
+		a.pings[historyKey(accountJID, jid)] = &pingEntry{Pending: true}
+	}
+	c := a.clients[accountJID]
+	a.mu.Unlock()
+
+	cmds := make([]tea.Cmd, 0, len(targets))
+	for _, jid := range targets {
+		cmds = append(cmds, a.pingOne(c, accountJID, jid))
+	}
+	return tea.Batch(cmds...)
+}
+
+// pingOne pings a single JID and records the outcome
+func (a *App) pingOne(c *client.Client, accountJID, jid string) tea.Cmd {
+	return func() tea.Msg {
+		msg := PingResultMsg{AccountJID: accountJID, JID: jid}
+		var err error
+		if c == nil || !c.IsConnected() {
+			err = fmt.Errorf("account not connected")
+		} else {
+			msg.RTT, err = c.Ping(jid)
+		}
+		switch {
+		case errors.Is(err, client.ErrIQTimeout):
+			msg.NoResponse = true
+		case err != nil && client.ErrorCondition(err) != "":
+			msg.Error = string(client.ErrorCondition(err))
+		case err != nil:
+			msg.Error = err.Error()
+		}
+
+		a.mu.Lock()
+		a.pings[historyKey(accountJID, jid)] = &pingEntry{RTT: msg.RTT, Err: msg.Error, NoResponse: msg.NoResponse}
+		a.mu.Unlock()
+		return msg
+	}
+}
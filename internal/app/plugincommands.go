@@ -0,0 +1,120 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Plugins add : commands of their own through the plugin API. Built-in
+// commands and aliases always win: a plugin cannot take their names, and
+// ExecuteCommand only looks at plugin commands for names it does not know.
+
+// PluginCommand is a : command added by a plugin
+type PluginCommand struct {
+	Name        string
+	Description string
+	Args        []string
+	Help        string
+	Plugin      string // the plugin that added it
+
+	Complete func(args []string) []string // candidates for the last argument
+	Run      func(args []string) error
+}
+
+// ReserveCommands marks the names of the built-in commands, which plugins
+// cannot register
+func (a *App) ReserveCommands(names []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.reservedCommands == nil {
+		a.reservedCommands = make(map[string]bool)
+	}
+	for _, name := range names {
+		a.reservedCommands[name] = true
+	}
+}
+
+// RegisterPluginCommand adds a plugin command. A plugin may replace its own
+// commands but not those of the core or of other plugins.
+func (a *App) RegisterPluginCommand(cmd PluginCommand) error {
+	if !validCommandName(cmd.Name) {
+		return fmt.Errorf("invalid command name %q", cmd.Name)
+	}
+	if cmd.Run == nil {
+		return fmt.Errorf("command :%s has no handler", cmd.Name)
+	}
+	if a.PolicyDenies("", PolicyPlugins) {
+		return PolicyError(PolicyPlugins)
+	}
+
+	a.mu.Lock()
+	if a.reservedCommands[cmd.Name] || a.cfg.Aliases[cmd.Name] != "" {
+		a.mu.Unlock()
+		return fmt.Errorf(":%s is a built-in command or an alias", cmd.Name)
+	}
+	if old := a.pluginCommands[cmd.Name]; old != nil && old.Plugin != cmd.Plugin {
+		a.mu.Unlock()
+		return fmt.Errorf(":%s is already added by plugin %s", cmd.Name, old.Plugin)
+	}
+	if a.pluginCommands == nil {
+		a.pluginCommands = make(map[string]*PluginCommand)
+	}
+	a.pluginCommands[cmd.Name] = &cmd
+	a.mu.Unlock()
+
+	a.sendEvent(EventMsg{Type: EventCommandsChanged})
+	return nil
+}
+
+// UnregisterPluginCommand removes a plugin command
+func (a *App) UnregisterPluginCommand(name string) {
+	a.mu.Lock()
+	_, ok := a.pluginCommands[name]
+	delete(a.pluginCommands, name)
+	a.mu.Unlock()
+	if ok {
+		a.sendEvent(EventMsg{Type: EventCommandsChanged})
+	}
+}
+
+// PluginCommands returns the plugin commands sorted by name
+func (a *App) PluginCommands() []PluginCommand {
+	a.mu.RLock()
+	cmds := make([]PluginCommand, 0, len(a.pluginCommands))
+	for _, cmd := range a.pluginCommands {
+		cmds = append(cmds, *cmd)
+	}
+	a.mu.RUnlock()
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].Name < cmds[j].Name })
+	return cmds
+}
+
+// runPluginCommand runs a plugin command. It returns an error for the
+// status line when the command fails, nil otherwise, also when no plugin
+// has a command of that name.
+func (a *App) runPluginCommand(name string, args []string) tea.Msg {
+	a.mu.RLock()
+	cmd := a.pluginCommands[name]
+	a.mu.RUnlock()
+	if cmd == nil {
+		return nil
+	}
+	if err := cmd.Run(args); err != nil {
+		return CommandActionMsg{
+			Action: ActionCommandError,
+			Data:   map[string]interface{}{"error": fmt.Sprintf(":%s (%s): %v", name, cmd.Plugin, err)},
+		}
+	}
+	return nil
+}
+
+// validCommandName allows lower case letters, digits, - and _
+func validCommandName(name string) bool {
+	if name == "" || strings.Trim(name, "abcdefghijklmnopqrstuvwxyz0123456789-_") != "" {
+		return false
+	}
+	return name[0] >= 'a' && name[0] <= 'z'
+}
@@ -0,0 +1,177 @@
+package app
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/meszmate/roster/internal/ui/components/chat"
+	"github.com/meszmate/roster/internal/ui/components/roster"
+	"github.com/meszmate/roster/pkg/plugin"
+	"github.com/meszmate/roster/pkg/plugin/api"
+)
+
+// The plugins in plugins.enabled are started with the app and stopped when
+// it closes. They act on the current account through pluginAPI, which the
+// host narrows for each plugin to what its manifest declares and the user
+// granted (see pluginperms.go); a plugin whose manifest asks for more is
+// not loaded.
+
+// PluginNotice is a notification shown for a plugin, or a plugin that
+// failed to start
+type PluginNotice struct {
+	Title string
+	Body  string
+}
+
+// String formats the notice for the status line
+func (n PluginNotice) String() string {
+	return n.Title + ": " + n.Body
+}
+
+// newPluginAPI connects the plugin API to the app
+func (a *App) newPluginAPI() *api.PluginAPI {
+	p := api.NewPluginAPI()
+	p.SetSendMessage(func(to, body string) error {
+		_, err := a.deliverChatMessage(a.CurrentAccount(), to, body, "")
+		return err
+	})
+	p.SetGetContacts(func() []plugin.Contact {
+		rosters := a.GetContacts()
+		contacts := make([]plugin.Contact, len(rosters))
+		for i, r := range rosters {
+			contacts[i] = pluginContact(r)
+		}
+		return contacts
+	})
+	p.SetGetContact(func(jid string) *plugin.Contact {
+		for _, r := range a.GetContacts() {
+			if r.JID == jid {
+				contact := pluginContact(r)
+				return &contact
+			}
+		}
+		return nil
+	})
+	p.SetGetPresence(func(jid string) string {
+		for _, r := range a.GetContacts() {
+			if r.JID == jid {
+				return r.Status
+			}
+		}
+		return ""
+	})
+	p.SetAddContact(func(jid, name string, groups []string) error {
+		return a.AddContact(jid, name, strings.Join(groups, ","))
+	})
+	p.SetRemoveContact(func(jid string) error {
+		accountJID := a.CurrentAccount()
+		if accountJID == "" {
+			return fmt.Errorf("no account selected")
+		}
+		if _, err := a.editRoster(RosterEdit{AccountJID: accountJID, JID: jid, Remove: true}); err != nil {
+			return err
+		}
+		a.sendEvent(EventMsg{Type: EventRosterUpdate})
+		return nil
+	})
+	p.SetGetHistory(func(jid string, limit int) []plugin.Message {
+		history := a.GetChatHistory(jid)
+		if limit > 0 && len(history) > limit {
+			history = history[len(history)-limit:]
+		}
+		messages := make([]plugin.Message, len(history))
+		for i, m := range history {
+			messages[i] = api.CreateMessage(m.ID, m.From, m.To, m.Body, m.Timestamp, m.Encrypted, m.Outgoing)
+		}
+		return messages
+	})
+	p.SetGetUnreadCount(func(jid string) int {
+		accountJID := a.CurrentAccount()
+		a.mu.RLock()
+		defer a.mu.RUnlock()
+		return a.contactUnreads[accountJID][jid]
+	})
+	// The terminal has no desktop notifications; they go to the status line
+	p.SetShowNotification(func(title, body string) error {
+		a.sendEvent(EventMsg{Type: EventPluginNotice, Data: PluginNotice{Title: title, Body: body}})
+		return nil
+	})
+	p.SetShowDialog(func(title, message string, buttons []string) (int, error) {
+		return -1, fmt.Errorf("plugin dialogs are not supported")
+	})
+	p.SetStatusBarItem(func(item plugin.StatusBarItem) error {
+		a.SetStatusItem(StatusItem{
+			ID:         item.ID,
+			Text:       item.Text,
+			Priority:   item.Priority,
+			MaxWidth:   item.MaxWidth,
+			Refresh:    item.Refresh,
+			OnRefresh:  item.OnRefresh,
+			OnActivate: item.OnActivate,
+		})
+		return nil
+	})
+	p.SetRemoveStatusBarItem(func(id string) error {
+		a.RemoveStatusItem(id)
+		return nil
+	})
+	p.SetRegisterCommand(func(spec plugin.CommandSpec) error {
+		return a.RegisterPluginCommand(PluginCommand{
+			Name:        spec.Name,
+			Description: spec.Description,
+			Args:        spec.Args,
+			Help:        spec.Help,
+			Plugin:      spec.Plugin,
+			Complete:    spec.Complete,
+			Run:         spec.Handler,
+		})
+	})
+	p.SetUnregisterCommand(a.UnregisterPluginCommand)
+	return p
+}
+
+func pluginContact(r roster.Roster) plugin.Contact {
+	return plugin.Contact{JID: r.JID, Name: r.Name, Groups: r.Groups, Status: r.Status, StatusMsg: r.StatusMsg}
+}
+
+// loadPlugins starts the enabled plugins. Those that fail are reported in
+// the status line, one at a time.
+func (a *App) loadPlugins() tea.Cmd {
+	if a.pluginHost == nil || a.PolicyDenies("", PolicyPlugins) {
+		return nil
+	}
+	return func() tea.Msg {
+		var names []string
+		for name := range a.enabledPlugins() {
+			if validPluginName(name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := a.pluginHost.Load(filepath.Join(a.pluginDir(), name)); err != nil {
+				a.sendEvent(EventMsg{Type: EventPluginNotice, Data: PluginNotice{Title: "Plugin " + name + " not loaded", Body: err.Error()}})
+			}
+		}
+		for _, lp := range a.pluginHost.List() {
+			if err := a.pluginHost.Start(lp.Name); err != nil {
+				a.sendEvent(EventMsg{Type: EventPluginNotice, Data: PluginNotice{Title: "Plugin " + lp.Name + " not started", Body: err.Error()}})
+			}
+		}
+		return nil
+	}
+}
+
+// emitPluginMessage hands a received message to the plugins, and its links
+// to those that asked for them
+func (a *App) emitPluginMessage(accountJID, contactJID string, msg chat.Message) {
+	a.mu.RLock()
+	room := a.roomNicks[historyKey(accountJID, contactJID)] != ""
+	a.mu.RUnlock()
+	m := api.CreateMessage(msg.ID, msg.From, msg.To, msg.Body, msg.Timestamp, msg.Encrypted, msg.Outgoing)
+	a.pluginAPI.EmitMessage(m)
+	a.pluginAPI.EmitURLs(m, contactJID, room)
+}
@@ -0,0 +1,537 @@
+package app
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/meszmate/roster/internal/config"
+	"github.com/meszmate/roster/pkg/plugin/manifest"
+)
+
+// Community plugins are listed in a curated index, a JSON file served over
+// HTTPS. Every download it points to carries a SHA-256 checksum that must
+// match before anything lands in the plugin folder. Plugins come either as
+// a binary per platform or as Go sources, which are built with the local
+// toolchain, and the permissions the index lists for them become their
+// manifest. What was installed is recorded in installed.json next to the
+// plugins; which of them run is plugins.enabled in config.toml.
+
+const (
+	// maxPluginIndex and maxPluginDownload limit what is read from the index
+	// and from a download
+	maxPluginIndex    = 1 << 20
+	maxPluginDownload = 200 << 20
+
+	pluginBuildTimeout = 5 * time.Minute
+
+	installedPluginsFile = "installed.json"
+)
+
+// PluginIndex is the curated list of community plugins
+type PluginIndex struct {
+	Version int             `json:"version"`
+	Plugins []PluginListing `json:"plugins"`
+}
+
+// PluginListing is one plugin in the index
+type PluginListing struct {
+	Name        string                    `json:"name"`
+	Description string                    `json:"description"`
+	Version     string                    `json:"version"`
+	Author      string                    `json:"author,omitempty"`
+	Homepage    string                    `json:"homepage,omitempty"`
+	Binaries    map[string]PluginDownload `json:"binaries,omitempty"` // "GOOS/GOARCH" -> build
+	Source      *PluginDownload           `json:"source,omitempty"`   // .tar.gz of the Go sources
+	Permissions []manifest.Permission     `json:"permissions,omitempty"`
+}
+
+// PluginDownload is a file the index points to
+type PluginDownload struct {
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+}
+
+// InstalledPlugin records a plugin installed from the index
+type InstalledPlugin struct {
+	Name        string    `json:"name"`
+	Version     string    `json:"version"`
+	SHA256      string    `json:"sha256"`
+	FromSource  bool      `json:"from_source"`
+	InstalledAt time.Time `json:"installed_at"`
+}
+
+// PluginStatus is a plugin as :plugin list shows it
+type PluginStatus struct {
+	Name        string
+	Description string
+	Version     string // version in the index
+	Installed   string // installed version, "" when not installed
+	Enabled     bool
+	Available   bool // can be installed on this platform
+}
+
+// PluginListMsg is sent when the plugin index was fetched
+type PluginListMsg struct {
+	Plugins []PluginStatus
+	Error   string
+}
+
+// PluginActionMsg is sent when a plugin was installed, removed, enabled or
+// disabled
+type PluginActionMsg struct {
+	Action  string // install, remove, enable or disable
+	Name    string
+	Version string
+	Error   string
+
+	// Consent lists the permissions to ask for before the plugin is
+	// enabled
+	Consent []manifest.Permission
+}
+
+// pluginIndexURL is where the index is read from
+func (a *App) pluginIndexURL() (string, error) {
+	if a.cfg.Plugins.IndexURL == "" {
+		return "", fmt.Errorf("no plugin index configured, set plugins.index_url in config.toml")
+	}
+	return a.cfg.Plugins.IndexURL, nil
+}
+
+// pluginDir is where plugins are installed
+func (a *App) pluginDir() string {
+	if a.cfg.Plugins.PluginDir != "" {
+		return a.cfg.Plugins.PluginDir
+	}
+	return filepath.Join(a.cfg.General.DataDir, "plugins")
+}
+
+// ListPlugins fetches the index and merges it with what is installed
+func (a *App) ListPlugins() tea.Cmd {
+	return func() tea.Msg {
+		index, err := a.fetchPluginIndex()
+		if err != nil {
+			return PluginListMsg{Error: err.Error()}
+		}
+		installed := loadInstalledPlugins(a.pluginDir())
+		enabled := a.enabledPlugins()
+
+		var list []PluginStatus
+		listed := make(map[string]bool)
+		for _, p := range index.Plugins {
+			listed[p.Name] = true
+			_, hasBinary := p.Binaries[runtime.GOOS+"/"+runtime.GOARCH]
+			list = append(list, PluginStatus{
+				Name:        p.Name,
+				Description: p.Description,
+				Version:     p.Version,
+				Installed:   installed[p.Name].Version,
+				Enabled:     enabled[p.Name],
+				Available:   hasBinary || p.Source != nil,
+			})
+		}
+		// Installed plugins the index no longer lists
+		for name, p := range installed {
+			if !listed[name] {
+				list = append(list, PluginStatus{Name: name, Installed: p.Version, Enabled: enabled[name]})
+			}
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+		return PluginListMsg{Plugins: list}
+	}
+}
+
+// InstallPlugin downloads a plugin from the index, checks it and enables it
+func (a *App) InstallPlugin(name string) tea.Cmd {
+	return func() tea.Msg {
+		msg := PluginActionMsg{Action: "install", Name: name}
+		if a.PolicyDenies("", PolicyPlugins) {
+			msg.Error = PolicyError(PolicyPlugins).Error()
+			return msg
+		}
+		index, err := a.fetchPluginIndex()
+		if err != nil {
+			msg.Error = err.Error()
+			return msg
+		}
+		var listing *PluginListing
+		for i := range index.Plugins {
+			if index.Plugins[i].Name == name {
+				listing = &index.Plugins[i]
+				break
+			}
+		}
+		if listing == nil {
+			msg.Error = "no plugin named " + name + " in the index"
+			return msg
+		}
+		if !validPluginName(name) {
+			msg.Error = "the index lists an invalid plugin name"
+			return msg
+		}
+
+		record, err := installPlugin(a.pluginDir(), *listing)
+		if err != nil {
+			msg.Error = err.Error()
+			return msg
+		}
+		msg.Version = record.Version
+		// A new version may ask for more than was granted before
+		msg.Consent, err = a.PluginConsentNeeded(name)
+		if err != nil {
+			msg.Error = err.Error()
+			return msg
+		}
+		if len(msg.Consent) > 0 {
+			_ = a.setPluginEnabled(name, false)
+			return msg
+		}
+		if err := a.setPluginEnabled(name, true); err != nil {
+			msg.Error = "installed, but enabling failed: " + err.Error()
+		}
+		return msg
+	}
+}
+
+// RemovePlugin deletes an installed plugin and disables it
+func (a *App) RemovePlugin(name string) tea.Cmd {
+	return func() tea.Msg {
+		msg := PluginActionMsg{Action: "remove", Name: name}
+		if !validPluginName(name) {
+			msg.Error = "invalid plugin name"
+			return msg
+		}
+		dir := a.pluginDir()
+		installed := loadInstalledPlugins(dir)
+		if _, ok := installed[name]; !ok {
+			msg.Error = name + " was not installed with :plugin install"
+			return msg
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			msg.Error = err.Error()
+			return msg
+		}
+		_ = os.Remove(manifest.Path(filepath.Join(dir, name)))
+		_ = os.RemoveAll(filepath.Join(dir, "src", name))
+		delete(installed, name)
+		if err := saveInstalledPlugins(dir, installed); err != nil {
+			msg.Error = err.Error()
+			return msg
+		}
+		if err := a.setPluginEnabled(name, false); err != nil {
+			msg.Error = err.Error()
+			return msg
+		}
+		if err := a.forgetPluginPermissions(name); err != nil {
+			msg.Error = err.Error()
+		}
+		return msg
+	}
+}
+
+// SetPluginEnabled adds a plugin to plugins.enabled or takes it out
+func (a *App) SetPluginEnabled(name string, enabled bool) error {
+	if !validPluginName(name) {
+		return fmt.Errorf("invalid plugin name %q", name)
+	}
+	if enabled && a.PolicyDenies("", PolicyPlugins) {
+		return PolicyError(PolicyPlugins)
+	}
+	if enabled {
+		if _, err := os.Stat(filepath.Join(a.pluginDir(), name)); err != nil {
+			return fmt.Errorf("%s is not installed", name)
+		}
+	}
+	return a.setPluginEnabled(name, enabled)
+}
+
+func (a *App) enabledPlugins() map[string]bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	enabled := make(map[string]bool, len(a.cfg.Plugins.Enabled))
+	for _, name := range a.cfg.Plugins.Enabled {
+		enabled[name] = true
+	}
+	return enabled
+}
+
+func (a *App) setPluginEnabled(name string, enabled bool) error {
+	a.mu.Lock()
+	var list []string
+	for _, n := range a.cfg.Plugins.Enabled {
+		if n != name {
+			list = append(list, n)
+		}
+	}
+	if enabled {
+		list = append(list, name)
+	}
+	if list == nil {
+		list = []string{}
+	}
+	a.cfg.Plugins.Enabled = list
+	a.mu.Unlock()
+	return config.Save(a.cfg)
+}
+
+// validPluginName keeps names from the index and the command line from
+// reaching outside the plugin folder
+func validPluginName(name string) bool {
+	if name == "" || name == "src" || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".json") {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// installPlugin puts the binary of a plugin into dir, downloading it or
+// building it from source, and records it
+func installPlugin(dir string, p PluginListing) (InstalledPlugin, error) {
+	record := InstalledPlugin{Name: p.Name, Version: p.Version, InstalledAt: time.Now()}
+	for _, perm := range p.Permissions {
+		if !perm.Valid() {
+			return record, fmt.Errorf("%s asks for an unknown permission %q", p.Name, perm)
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return record, err
+	}
+
+	target := filepath.Join(dir, p.Name)
+	tmp := target + ".download"
+	defer os.Remove(tmp)
+
+	if bin, ok := p.Binaries[runtime.GOOS+"/"+runtime.GOARCH]; ok {
+		if err := download(bin, tmp); err != nil {
+			return record, err
+		}
+		if err := os.Chmod(tmp, 0755); err != nil {
+			return record, err
+		}
+		record.SHA256 = strings.ToLower(bin.SHA256)
+	} else if p.Source != nil {
+		src := filepath.Join(dir, "src", p.Name)
+		if err := installPluginSource(*p.Source, src, tmp); err != nil {
+			return record, err
+		}
+		record.SHA256 = strings.ToLower(p.Source.SHA256)
+		record.FromSource = true
+	} else {
+		return record, fmt.Errorf("%s has no build for %s/%s and no sources", p.Name, runtime.GOOS, runtime.GOARCH)
+	}
+
+	if err := manifest.Write(target, manifest.Manifest{Name: p.Name, Version: p.Version, Permissions: p.Permissions}); err != nil {
+		return record, err
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		return record, err
+	}
+	installed := loadInstalledPlugins(dir)
+	installed[p.Name] = record
+	return record, saveInstalledPlugins(dir, installed)
+}
+
+// installPluginSource downloads and unpacks the sources of a plugin into
+// src and builds them into out
+func installPluginSource(d PluginDownload, src, out string) error {
+	if _, err := exec.LookPath("go"); err != nil {
+		return fmt.Errorf("this plugin is only available as source, and building it needs the Go toolchain")
+	}
+
+	archive := src + ".tar.gz"
+	defer os.Remove(archive)
+	if err := os.MkdirAll(filepath.Dir(src), 0755); err != nil {
+		return err
+	}
+	if err := download(d, archive); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(src); err != nil {
+		return err
+	}
+	if err := untarGz(archive, src); err != nil {
+		return fmt.Errorf("failed to unpack sources: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pluginBuildTimeout)
+	defer cancel()
+	abs, err := filepath.Abs(out)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "go", "build", "-o", abs, ".")
+	cmd.Dir = src
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("build failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// fetchPluginIndex reads and parses the plugin index
+func (a *App) fetchPluginIndex() (PluginIndex, error) {
+	var index PluginIndex
+	indexURL, err := a.pluginIndexURL()
+	if err != nil {
+		return index, err
+	}
+	body, err := httpsGet(indexURL, maxPluginIndex)
+	if err != nil {
+		return index, fmt.Errorf("failed to fetch plugin index: %w", err)
+	}
+	defer body.Close()
+	if err := json.NewDecoder(body).Decode(&index); err != nil {
+		return index, fmt.Errorf("failed to parse plugin index: %w", err)
+	}
+	return index, nil
+}
+
+// download writes a file from the index to path once its checksum matched
+func download(d PluginDownload, path string) error {
+	want := strings.ToLower(strings.TrimSpace(d.SHA256))
+	if len(want) != sha256.Size*2 {
+		return fmt.Errorf("the index gives no valid SHA-256 for %s", d.URL)
+	}
+	body, err := httpsGet(d.URL, maxPluginDownload)
+	if err != nil {
+		return fmt.Errorf("download failed: %w", err)
+	}
+	defer body.Close()
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("download failed: %w", err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", d.URL, want, got)
+	}
+	return nil
+}
+
+// httpsGet fetches a URL over HTTPS only, redirects included, and limits
+// how much of the body is read
+func httpsGet(raw string, limit int64) (io.ReadCloser, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" {
+		return nil, fmt.Errorf("%s is not an https:// URL", raw)
+	}
+	client := &http.Client{
+		Timeout: 2 * time.Minute,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return fmt.Errorf("too many redirects")
+			}
+			if req.URL.Scheme != "https" {
+				return fmt.Errorf("redirected to insecure URL")
+			}
+			return nil
+		},
+	}
+	resp, err := client.Get(raw)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", raw, resp.Status)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(resp.Body, limit), resp.Body}, nil
+}
+
+// untarGz unpacks regular files and folders of a .tar.gz into dir, refusing
+// entries that would land outside it
+func untarGz(archive, dir string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if rel, err := filepath.Rel(dir, path); err != nil || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("archive entry %s leaves the source folder", hdr.Name)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, tr)
+			if cerr := out.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+		}
+		// Links and devices are skipped
+	}
+}
+
+func loadInstalledPlugins(dir string) map[string]InstalledPlugin {
+	installed := make(map[string]InstalledPlugin)
+	data, err := os.ReadFile(filepath.Join(dir, installedPluginsFile))
+	if err == nil {
+		_ = json.Unmarshal(data, &installed)
+	}
+	return installed
+}
+
+func saveInstalledPlugins(dir string, installed map[string]InstalledPlugin) error {
+	data, err := json.MarshalIndent(installed, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, installedPluginsFile), data, 0644)
+}
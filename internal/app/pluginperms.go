@@ -0,0 +1,166 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/meszmate/roster/internal/config"
+	"github.com/meszmate/roster/pkg/plugin"
+	"github.com/meszmate/roster/pkg/plugin/manifest"
+)
+
+// Plugins declare what they need in a manifest next to their binary (see
+// pkg/plugin/manifest). The user consents to it when a plugin is
+// enabled; the grant is kept in plugins.permissions and the host refuses to
+// load a plugin whose manifest asks for more than was granted, so a
+// URL-preview plugin that starts wanting to send messages is stopped until
+// the user agrees again.
+
+// PluginConsentNeeded returns the permissions a plugin declares but was
+// not granted
+func (a *App) PluginConsentNeeded(name string) ([]manifest.Permission, error) {
+	if !validPluginName(name) {
+		return nil, fmt.Errorf("invalid plugin name %q", name)
+	}
+	m, err := manifest.Read(filepath.Join(a.pluginDir(), name))
+	if err != nil {
+		return nil, err
+	}
+	return manifest.Missing(m.Permissions, a.grantedPluginPermissions(name)), nil
+}
+
+// PluginConsent tells the plugin host whether a plugin may load with the
+// permissions of its manifest. It never asks; consent is given when the
+// plugin is enabled.
+func (a *App) PluginConsent(m manifest.Manifest) bool {
+	return len(manifest.Missing(m.Permissions, a.grantedPluginPermissions(m.Name))) == 0
+}
+
+// AllowPlugin grants a plugin the permissions the user was shown and
+// enables it. The manifest is read again only to check it asks for nothing
+// more, as it may have changed while the user was deciding.
+func (a *App) AllowPlugin(name string, shown []manifest.Permission) error {
+	if !validPluginName(name) {
+		return fmt.Errorf("invalid plugin name %q", name)
+	}
+	if a.PolicyDenies("", PolicyPlugins) {
+		return PolicyError(PolicyPlugins)
+	}
+	m, err := manifest.Read(filepath.Join(a.pluginDir(), name))
+	if err != nil {
+		return err
+	}
+	granted := a.grantedPluginPermissions(name)
+	for _, p := range shown {
+		if !slices.Contains(granted, p) {
+			granted = append(granted, p)
+		}
+	}
+	perms := make([]string, len(granted))
+	for i, p := range granted {
+		perms[i] = string(p)
+	}
+	a.mu.Lock()
+	if a.cfg.Plugins.Permissions == nil {
+		a.cfg.Plugins.Permissions = make(map[string][]string)
+	}
+	a.cfg.Plugins.Permissions[name] = perms
+	a.mu.Unlock()
+	if missing := manifest.Missing(m.Permissions, granted); len(missing) > 0 {
+		if err := config.Save(a.cfg); err != nil {
+			return err
+		}
+		return fmt.Errorf("%s now also asks for %s, review it with :plugin enable %s", name, manifest.Format(missing), name)
+	}
+	return a.SetPluginEnabled(name, true)
+}
+
+// forgetPluginPermissions drops the grant of a removed plugin
+func (a *App) forgetPluginPermissions(name string) error {
+	a.mu.Lock()
+	_, ok := a.cfg.Plugins.Permissions[name]
+	delete(a.cfg.Plugins.Permissions, name)
+	a.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return config.Save(a.cfg)
+}
+
+func (a *App) grantedPluginPermissions(name string) []manifest.Permission {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	var granted []manifest.Permission
+	for _, p := range a.cfg.Plugins.Permissions[name] {
+		granted = append(granted, manifest.Permission(p))
+	}
+	return granted
+}
+
+// PluginPermissionReport describes what installed plugins declare and were
+// granted, for :plugin perms
+func (a *App) PluginPermissionReport(name string) string {
+	dir := a.pluginDir()
+	var names []string
+	if name != "" {
+		if !validPluginName(name) {
+			return "Invalid plugin name " + name
+		}
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return name + " is not installed"
+		}
+		names = []string{name}
+	} else {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if !entry.IsDir() && !strings.HasSuffix(entry.Name(), ".json") && !strings.HasSuffix(entry.Name(), ".download") {
+				names = append(names, entry.Name())
+			}
+		}
+		sort.Strings(names)
+	}
+	if len(names) == 0 {
+		return "No plugins are installed in " + dir
+	}
+
+	enabled := a.enabledPlugins()
+	sandboxErr := plugin.SandboxUnavailable()
+	var b strings.Builder
+	for i, n := range names {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		state := "disabled"
+		if enabled[n] {
+			state = "enabled"
+		}
+		m, err := manifest.Read(filepath.Join(dir, n))
+		if err != nil {
+			fmt.Fprintf(&b, "%s (%s): %v, it will not load\n", n, state, err)
+			continue
+		}
+		fmt.Fprintf(&b, "%s (%s)\n", n, state)
+		granted := a.grantedPluginPermissions(n)
+		if len(m.Permissions) == 0 {
+			b.WriteString("  Declares no permissions\n")
+		}
+		for _, p := range m.Permissions {
+			mark := "granted"
+			if len(manifest.Missing([]manifest.Permission{p}, granted)) > 0 {
+				mark = "not granted"
+			}
+			fmt.Fprintf(&b, "  %-32s %s\n", p.Description(), mark)
+		}
+		if sandboxErr != nil && !slices.Contains(granted, manifest.PermNetwork) {
+			fmt.Fprintf(&b, "  Can reach the network anyway, the sandbox is unavailable: %v\n", sandboxErr)
+		}
+		if len(m.Permissions) > 0 && !a.PluginConsent(m) {
+			fmt.Fprintf(&b, "  Will not load until allowed with :plugin enable %s\n", n)
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -0,0 +1,54 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// A deployment can force features off in the [policy] section of
+// config.toml: deny for every account, policy.accounts for single ones.
+// What a policy denies is refused here whatever asks for it, and the UI
+// greys out the keys that lead to it.
+
+// Features a policy can deny, see config.PolicyConfig
+const (
+	PolicyFileUpload        = "file_upload"        // HTTP upload and direct transfers
+	PolicyLinkOpen          = "link_open"          // opening links in the browser
+	PolicyPlaintextFallback = "plaintext_fallback" // sending plaintext when encryption fails
+	PolicyPlugins           = "plugins"            // installing, enabling and running plugins
+)
+
+// ErrPolicyDenied is returned for what the policy turned off
+var ErrPolicyDenied = errors.New("disabled by policy")
+
+var policyLabels = map[string]string{
+	PolicyFileUpload:        "File upload",
+	PolicyLinkOpen:          "Opening links",
+	PolicyPlaintextFallback: "Plaintext fallback",
+	PolicyPlugins:           "Plugins",
+}
+
+// PolicyError is the error for a denied feature
+func PolicyError(feature string) error {
+	return fmt.Errorf("%s is %w", policyLabels[feature], ErrPolicyDenied)
+}
+
+// PolicyDenies reports whether the policy turned a feature off for an
+// account. An empty accountJID asks for features that are not tied to one
+// account, which are off when any account denies them.
+func (a *App) PolicyDenies(accountJID, feature string) bool {
+	policy := a.cfg.Policy
+	if slices.Contains(policy.Deny, feature) {
+		return true
+	}
+	if accountJID != "" {
+		return slices.Contains(policy.Accounts[accountJID], feature)
+	}
+	for _, denied := range policy.Accounts {
+		if slices.Contains(denied, feature) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+package app
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/meszmate/roster/internal/client"
+	"github.com/meszmate/roster/internal/config"
+)
+
+// The privacy dashboard (:privacy, or P in the account details) lists what
+// an account tells others besides its messages, each item with a switch
+// that turns the part of roster sharing it on or off. Receipts stay on as
+// they always were; what roster never sent before is off until turned on.
+// The switches of the archive, link previews and the software version are
+// the settings those already had.
+
+// Privacy dashboard items
+const (
+	PrivacyReceipts     = "receipts"      // delivery receipts (XEP-0184)
+	PrivacyReadMarkers  = "read_markers"  // read markers (XEP-0333)
+	PrivacyChatStates   = "chat_states"   // active, inactive and gone (XEP-0085)
+	PrivacyTyping       = "typing"        // composing and paused (XEP-0085)
+	PrivacyLastActivity = "last_activity" // idle time (XEP-0012)
+	PrivacyAvatar       = "avatar"        // published avatar (XEP-0084)
+	PrivacyArchive      = "archive"       // server archive, see archive.go
+	PrivacyLinkPreviews = "link_previews" // fetching linked pages, see linkpreview.go
+	PrivacyVersion      = "version"       // software version, see softwareversion.go
+)
+
+// privacyDefaults are the items shared until changed
+var privacyDefaults = map[string]bool{
+	PrivacyReceipts: true,
+}
+
+// PrivacyItem is one line of the privacy dashboard
+type PrivacyItem struct {
+	ID     string
+	Label  string
+	On     bool
+	State  string // what is shared, when more than on or off
+	Detail string // what the item tells and to whom
+	Fixed  bool   // cannot be switched here
+}
+
+func privacyPrefKey(accountJID, item string) string {
+	return "privacy:" + item + ":" + accountJID
+}
+
+// PrivacyEnabled reports whether an account shares receipts, read markers,
+// chat states, typing or last activity
+func (a *App) PrivacyEnabled(accountJID, item string) bool {
+	key := privacyPrefKey(accountJID, item)
+	a.mu.RLock()
+	on, cached := a.privacyPrefs[key]
+	a.mu.RUnlock()
+	if cached {
+		return on
+	}
+
+	on = privacyDefaults[item]
+	if a.storage != nil {
+		if raw, err := a.storage.GetAppState(key); err == nil && raw != "" {
+			on = raw == "1"
+		}
+	}
+	a.mu.Lock()
+	if a.privacyPrefs == nil {
+		a.privacyPrefs = make(map[string]bool)
+	}
+	a.privacyPrefs[key] = on
+	a.mu.Unlock()
+	return on
+}
+
+// SetPrivacy turns an item of the privacy dashboard on or off for an
+// account. The archive is changed with SetArchiveDefault instead, and the
+// avatar cannot be.
+func (a *App) SetPrivacy(accountJID, item string, on bool) error {
+	switch item {
+	case PrivacyReceipts, PrivacyReadMarkers, PrivacyChatStates, PrivacyTyping, PrivacyLastActivity:
+		if a.storage == nil {
+			return fmt.Errorf("storage is not available")
+		}
+		if !on && item == PrivacyTyping {
+			a.stopAllTyping(accountJID)
+		}
+		raw := "0"
+		if on {
+			raw = "1"
+		}
+		if err := a.storage.SetAppState(privacyPrefKey(accountJID, item), raw); err != nil {
+			return err
+		}
+		a.mu.Lock()
+		if a.privacyPrefs == nil {
+			a.privacyPrefs = make(map[string]bool)
+		}
+		a.privacyPrefs[privacyPrefKey(accountJID, item)] = on
+		a.mu.Unlock()
+		return nil
+	case PrivacyLinkPreviews:
+		if err := a.SetLinkPreviewOptIn(accountJID, "", on); err != nil {
+			return err
+		}
+		if on && !a.cfg.LinkPreviews.Enabled {
+			// Previews were off everywhere, only this account asked for them
+			a.cfg.LinkPreviews.Enabled = true
+			_ = config.Save(a.cfg)
+		}
+		return nil
+	case PrivacyVersion:
+		a.SetSetting("version_reply", strconv.FormatBool(on))
+		return nil
+	case PrivacyArchive:
+		return fmt.Errorf("the archive is changed with :archive")
+	case PrivacyAvatar:
+		return fmt.Errorf("roster does not publish avatars")
+	}
+	return fmt.Errorf("unknown privacy item %q", item)
+}
+
+// PrivacyItems lists what an account shares, in the dashboard's order
+func (a *App) PrivacyItems(accountJID string) []PrivacyItem {
+	items := []PrivacyItem{
+		{ID: PrivacyReceipts, Label: "Delivery receipts", Detail: "that a message arrived, to its sender"},
+		{ID: PrivacyReadMarkers, Label: "Read markers", Detail: "that a conversation was read, to the contact"},
+		{ID: PrivacyChatStates, Label: "Chat states", Detail: "whether a conversation is open, to the contact"},
+		{ID: PrivacyTyping, Label: "Typing indicators", Detail: "that a message is being written, to the contact"},
+		{ID: PrivacyLastActivity, Label: "Last activity", Detail: "idle time, to contacts that see the presence"},
+	}
+	for i := range items {
+		items[i].On = a.PrivacyEnabled(accountJID, items[i].ID)
+	}
+
+	items = append(items, PrivacyItem{
+		ID: PrivacyAvatar, Label: "Avatar", State: "none published",
+		Detail: "roster does not publish avatars, contacts see initials", Fixed: true,
+	})
+
+	archive := PrivacyItem{ID: PrivacyArchive, Label: "Server archive", Detail: "messages kept by the server, for other clients"}
+	switch mode := a.ArchivePreference(accountJID); mode {
+	case "":
+		archive.State = "not known yet"
+		if !a.IsAccountConnected(accountJID) {
+			archive.State = "unknown until connected"
+			archive.Fixed = true
+		}
+	case client.ArchiveAlways, client.ArchiveRoster:
+		archive.On = true
+		archive.State = mode
+	case client.ArchiveNever:
+		archive.State = mode
+	default:
+		archive.State = mode
+		archive.Fixed = true
+	}
+	items = append(items, archive)
+
+	_, account := a.LinkPreviewOptIn(accountJID, "")
+	previews := PrivacyItem{
+		ID: PrivacyLinkPreviews, Label: "Link previews",
+		On:     account && a.cfg.LinkPreviews.Enabled,
+		Detail: "that a link was read, to the site it points to",
+	}
+	if account && !a.cfg.LinkPreviews.Enabled {
+		previews.State = "off (link_previews.enabled is off)"
+	}
+	items = append(items, previews)
+
+	a.mu.RLock()
+	version := a.cfg.SoftwareVersion
+	a.mu.RUnlock()
+	reply := PrivacyItem{
+		ID: PrivacyVersion, Label: "Software version", On: version.Reply,
+		Detail: "the client and its version to anyone who asks, for every account",
+	}
+	if version.Reply && version.ShowOS {
+		reply.State = "on, with the OS"
+	}
+	return append(items, reply)
+}
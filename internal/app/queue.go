@@ -0,0 +1,269 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// QueueState describes why an outgoing message has not been sent yet
+type QueueState string
+
+const (
+	QueueQueued    QueueState = "queued"    // waiting for the account to connect
+	QueueScheduled QueueState = "scheduled" // waiting for its send time
+	QueueRetrying  QueueState = "retrying"  // the last send attempt failed
+	QueueSending   QueueState = "sending"   // being sent right now
+)
+
+// maxSendAttempts is how often a failing message is retried before it stays
+// in the queue for manual action
+const maxSendAttempts = 5
+
+// QueuedMessage is an outgoing chat message waiting to be sent
+type QueuedMessage struct {
+	ID         string
+	AccountJID string
+	To         string
+	Body       string
+	State      QueueState
+	CreatedAt  time.Time
+	SendAt     time.Time // Scheduled time or next retry; zero when waiting for a connection
+	Attempts   int
+	LastError  string
+}
+
+// enqueueMessage adds a message to the outgoing queue and arms its timer
+func (a *App) enqueueMessage(accountJID, to, body string, state QueueState, sendAt time.Time, lastErr string) QueuedMessage {
+	a.mu.Lock()
+	a.outboxSeq++
+	qm := &QueuedMessage{
+		ID:         fmt.Sprintf("q%d", a.outboxSeq),
+		AccountJID: accountJID,
+		To:         to,
+		Body:       body,
+		State:      state,
+		CreatedAt:  time.Now(),
+		SendAt:     sendAt,
+		LastError:  lastErr,
+	}
+	if state == QueueRetrying {
+		qm.Attempts = 1
+	}
+	a.outbox[qm.ID] = qm
+	a.armQueueTimerLocked(qm)
+	snapshot := *qm
+	a.mu.Unlock()
+	return snapshot
+}
+
+// armQueueTimerLocked (re)starts the timer that sends a scheduled or
+// retrying message. Caller must hold a.mu.
+func (a *App) armQueueTimerLocked(qm *QueuedMessage) {
+	if t, ok := a.outboxTimers[qm.ID]; ok {
+		t.Stop()
+		delete(a.outboxTimers, qm.ID)
+	}
+	if qm.SendAt.IsZero() {
+		return
+	}
+	id := qm.ID
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Until(qm.SendAt), func() {
+		a.mu.Lock()
+		// A timer stopped too late to keep it from firing was replaced
+		// or dropped, and must not send
+		current := a.outboxTimers[id] == timer
+		if current {
+			delete(a.outboxTimers, id)
+		}
+		a.mu.Unlock()
+		if current {
+			_ = a.SendQueuedNow(id)
+		}
+	})
+	a.outboxTimers[id] = timer
+}
+
+// ScheduleChatMessage queues a message to be sent at the given time
+func (a *App) ScheduleChatMessage(accountJID, to, body string, at time.Time) QueuedMessage {
+	return a.enqueueMessage(accountJID, to, body, QueueScheduled, at, "")
+}
+
+// parseSendTime parses a send time given either as a delay ("+30m", "2h") or
+// a clock time ("18:30"). Clock times already past today mean tomorrow.
+func parseSendTime(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(strings.TrimPrefix(s, "+")); err == nil {
+		if d <= 0 {
+			return time.Time{}, fmt.Errorf("send time must be in the future")
+		}
+		return now.Add(d), nil
+	}
+	t, err := time.ParseInLocation("15:04", s, now.Location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid send time %q (use +30m or 18:30)", s)
+	}
+	at := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+	if !at.After(now) {
+		at = at.AddDate(0, 0, 1)
+	}
+	return at, nil
+}
+
+// GetOutgoingQueue returns all pending outgoing messages grouped by account,
+// oldest first
+func (a *App) GetOutgoingQueue() []QueuedMessage {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	queue := make([]QueuedMessage, 0, len(a.outbox))
+	for _, qm := range a.outbox {
+		queue = append(queue, *qm)
+	}
+	sort.Slice(queue, func(i, j int) bool {
+		if queue[i].AccountJID != queue[j].AccountJID {
+			return queue[i].AccountJID < queue[j].AccountJID
+		}
+		return queue[i].CreatedAt.Before(queue[j].CreatedAt)
+	})
+	return queue
+}
+
+// UpdateQueuedMessage replaces the body of a pending message
+func (a *App) UpdateQueuedMessage(id, body string) error {
+	if body == "" {
+		return fmt.Errorf("message is empty")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	qm, ok := a.outbox[id]
+	if !ok {
+		return fmt.Errorf("message is no longer queued")
+	}
+	if qm.State == QueueSending {
+		return fmt.Errorf("message is being sent")
+	}
+	qm.Body = body
+	return nil
+}
+
+// DeleteQueuedMessage drops a pending message without sending it
+func (a *App) DeleteQueuedMessage(id string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	qm, ok := a.outbox[id]
+	if !ok {
+		return fmt.Errorf("message is no longer queued")
+	}
+	if qm.State == QueueSending {
+		return fmt.Errorf("message is being sent")
+	}
+	a.removeQueuedLocked(id)
+	return nil
+}
+
+func (a *App) removeQueuedLocked(id string) {
+	if t, ok := a.outboxTimers[id]; ok {
+		t.Stop()
+		delete(a.outboxTimers, id)
+	}
+	delete(a.outbox, id)
+}
+
+// SendQueuedNow attempts to send a pending message immediately. On failure
+// the message stays queued and, while attempts remain, a retry is scheduled.
+// The message is marked as sending before it goes out, so the timer, the
+// flush on connect and a forced send never deliver it twice.
+func (a *App) SendQueuedNow(id string) error {
+	a.mu.Lock()
+	qm, ok := a.outbox[id]
+	if !ok {
+		a.mu.Unlock()
+		return fmt.Errorf("message is no longer queued")
+	}
+	if qm.State == QueueSending {
+		a.mu.Unlock()
+		return fmt.Errorf("message is already being sent")
+	}
+	accountJID, to, body := qm.AccountJID, qm.To, qm.Body
+	if c, ok := a.clients[accountJID]; !ok || !c.IsConnected() {
+		qm.State = QueueQueued
+		qm.SendAt = time.Time{}
+		a.armQueueTimerLocked(qm)
+		a.mu.Unlock()
+		return fmt.Errorf("account %s is not connected", accountJID)
+	}
+	qm.State = QueueSending
+	qm.SendAt = time.Time{}
+	a.armQueueTimerLocked(qm)
+	a.mu.Unlock()
+
+	_, err := a.deliverChatMessage(accountJID, to, body, "")
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	qm, ok = a.outbox[id]
+	if !ok {
+		return err
+	}
+	if err == nil {
+		a.removeQueuedLocked(id)
+		return nil
+	}
+
+	// Back in the queue for the timer or the next connect to retry
+	qm.State = QueueRetrying
+	qm.Attempts++
+	qm.LastError = err.Error()
+	if qm.Attempts < maxSendAttempts {
+		qm.SendAt = time.Now().Add(reconnectDelay(qm.Attempts))
+	}
+	a.armQueueTimerLocked(qm)
+	return err
+}
+
+// QueueSendResultMsg is sent after a queued message was force-sent
+type QueueSendResultMsg struct {
+	ID    string
+	Error string
+}
+
+// SendQueuedCmd force-sends a queued message and returns a command to handle
+// the result
+func (a *App) SendQueuedCmd(id string) tea.Cmd {
+	return func() tea.Msg {
+		result := QueueSendResultMsg{ID: id}
+		if err := a.SendQueuedNow(id); err != nil {
+			result.Error = err.Error()
+		}
+		return result
+	}
+}
+
+// flushOutgoingQueue sends messages that were waiting for the account to
+// come online. Scheduled messages keep waiting for their time.
+func (a *App) flushOutgoingQueue(accountJID string) {
+	a.mu.RLock()
+	var pending []*QueuedMessage
+	for _, qm := range a.outbox {
+		if qm.AccountJID == accountJID && qm.State != QueueScheduled && qm.State != QueueSending && qm.SendAt.IsZero() {
+			pending = append(pending, qm)
+		}
+	}
+	// In the order they were written, as the IDs do not sort by number
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].CreatedAt.Before(pending[j].CreatedAt)
+	})
+	ids := make([]string, len(pending))
+	for i, qm := range pending {
+		ids[i] = qm.ID
+	}
+	a.mu.RUnlock()
+
+	for _, id := range ids {
+		_ = a.SendQueuedNow(id)
+	}
+}
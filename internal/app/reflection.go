@@ -0,0 +1,141 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/meszmate/roster/internal/client"
+)
+
+// A room sends every message back to all occupants, the sender included,
+// and only a message the room reflected reached the others. Our own room
+// messages wait for their reflection, matched by the XEP-0359 origin-id
+// they carry or by their ID, which most rooms keep: ✓ means the server took
+// the message and ✓✓ that the room reflected it. A message not reflected
+// within reflectionTimeout is marked failed and offered for resending.
+
+// reflectionTimeout is how long a room message may wait for its reflection
+const reflectionTimeout = 30 * time.Second
+
+// DroppedRoomMessage is a message a room did not reflect in time
+type DroppedRoomMessage struct {
+	AccountJID string
+	Room       string
+	ID         string
+	Body       string
+}
+
+// pendingReflection is a room message waiting for its reflection. It stays
+// after timing out, so a late reflection still clears the failed mark.
+type pendingReflection struct {
+	DroppedRoomMessage
+	timer   *time.Timer
+	dropped bool
+}
+
+// newRoomMessageID returns the ID of a message sent to a room
+func newRoomMessageID() string {
+	return fmt.Sprintf("room-%d", time.Now().UnixNano())
+}
+
+// sendRoomMessage sends a message to a room and waits for its reflection.
+// Rooms are not encrypted, so with require_encryption, or a policy denying
+// plaintext_fallback, a room that should be is not written to.
+func (a *App) sendRoomMessage(c *client.Client, accountJID, room, dest, body string) (string, error) {
+	strict := a.cfg.Encryption.RequireEncryption || a.PolicyDenies(accountJID, PolicyPlaintextFallback)
+	if strict && a.EncryptionEnabled(accountJID, room) {
+		return "", client.ErrEncryptionUnavailable
+	}
+	id := newRoomMessageID()
+	a.awaitReflection(accountJID, room, id, body)
+	if err := c.SendRoomMessage(dest, id, body, !a.cfg.UI.MessageStyling); err != nil {
+		a.cancelReflection(id)
+		return "", err
+	}
+	return id, nil
+}
+
+// awaitReflection starts waiting for a room message to come back
+func (a *App) awaitReflection(accountJID, room, id, body string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.pendingReflections == nil {
+		a.pendingReflections = make(map[string]*pendingReflection)
+	}
+	p := &pendingReflection{DroppedRoomMessage: DroppedRoomMessage{
+		AccountJID: accountJID,
+		Room:       room,
+		ID:         id,
+		Body:       body,
+	}}
+	p.timer = time.AfterFunc(reflectionTimeout, func() { a.reflectionTimedOut(id) })
+	a.pendingReflections[id] = p
+}
+
+// roomMessageSent marks a room message sent once its local echo is in the
+// history, or reflected if the reflection was quicker
+func (a *App) roomMessageSent(accountJID, room, id string) {
+	a.mu.RLock()
+	_, waiting := a.pendingReflections[id]
+	a.mu.RUnlock()
+	status := StatusDelivered
+	if waiting {
+		status = StatusSent
+	}
+	a.UpdateMessageStatusForAccount(accountJID, room, id, status)
+}
+
+// cancelReflection stops waiting for a room message that was not sent
+func (a *App) cancelReflection(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if p := a.pendingReflections[id]; p != nil {
+		p.timer.Stop()
+		delete(a.pendingReflections, id)
+	}
+}
+
+// reflectionTimedOut marks a room message that did not come back as failed
+func (a *App) reflectionTimedOut(id string) {
+	a.mu.Lock()
+	p := a.pendingReflections[id]
+	if p == nil || p.dropped {
+		a.mu.Unlock()
+		return
+	}
+	p.dropped = true
+	dropped := p.DroppedRoomMessage
+	a.mu.Unlock()
+
+	a.UpdateMessageStatusForAccount(dropped.AccountJID, dropped.Room, dropped.ID, StatusFailed)
+	a.sendEvent(EventMsg{Type: EventRoomMessageDropped, Data: dropped})
+}
+
+// matchReflection reports whether a room message is the reflection of one
+// we sent, marking ours as reflected
+func (a *App) matchReflection(accountJID, room string, msg client.Message) bool {
+	if msg.Type != "groupchat" {
+		return false
+	}
+	a.mu.Lock()
+	var p *pendingReflection
+	for _, id := range []string{msg.OriginID, msg.ID} {
+		if id == "" {
+			continue
+		}
+		if candidate := a.pendingReflections[id]; candidate != nil && candidate.AccountJID == accountJID && candidate.Room == room {
+			p = candidate
+			break
+		}
+	}
+	if p == nil {
+		a.mu.Unlock()
+		return false
+	}
+	p.timer.Stop()
+	delete(a.pendingReflections, p.ID)
+	a.mu.Unlock()
+
+	a.UpdateMessageStatusForAccount(accountJID, room, p.ID, StatusDelivered)
+	return true
+}
@@ -0,0 +1,453 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/meszmate/roster/internal/ui/components/chat"
+)
+
+// Contact requests are subscription requests and messages from senders who
+// are not in the roster. They are listed in the Requests section of the
+// roster instead of opening windows and ringing, until they are accepted,
+// which adds the sender to the roster, rejected or blocked; each sender
+// gets requests.max_per_hour messages an hour before the rest is dropped,
+// and senders from requests.blocked_domains are declined outright. Blocked
+// JIDs are also blocked on the server where it supports XEP-0191, and
+// whatever still arrives from them is dropped.
+
+// Kinds of contact request
+const (
+	RequestSubscription = "subscription" // asked to see our presence
+	RequestMessage      = "message"      // wrote without being in the roster
+)
+
+const (
+	requestPrefKeyPrefix = "request:"
+	blockedPrefKeyPrefix = "blocked:"
+)
+
+func requestPrefKey(accountJID, contactJID string) string {
+	return requestPrefKeyPrefix + historyKey(accountJID, contactJID)
+}
+
+func blockedPrefKey(accountJID, contactJID string) string {
+	return blockedPrefKeyPrefix + historyKey(accountJID, contactJID)
+}
+
+// ContactRequest is a pending request from someone not in the roster
+type ContactRequest struct {
+	AccountJID string
+	JID        string
+	Kind       string
+}
+
+// loadContactRequests restores the pending requests and the blocked JIDs
+func (a *App) loadContactRequests() {
+	if a.storage == nil {
+		return
+	}
+	saved, err := a.storage.GetAppStateWithPrefix(requestPrefKeyPrefix)
+	if err != nil {
+		return
+	}
+	blocked, err := a.storage.GetAppStateWithPrefix(blockedPrefKeyPrefix)
+	if err != nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.contactRequests == nil {
+		a.contactRequests = make(map[string]string)
+	}
+	for key, kind := range saved {
+		a.contactRequests[key] = kind
+	}
+	if a.blockedContacts == nil {
+		a.blockedContacts = make(map[string]bool)
+	}
+	for key := range blocked {
+		a.blockedContacts[key] = true
+	}
+}
+
+// IsContactRequest reports whether a contact is a pending request
+func (a *App) IsContactRequest(accountJID, contactJID string) bool {
+	return a.contactRequestKind(accountJID, contactJID) != ""
+}
+
+// contactRequestKind returns the kind of a pending request, "" for none
+func (a *App) contactRequestKind(accountJID, contactJID string) string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.contactRequests[historyKey(accountJID, contactJID)]
+}
+
+// HeldAsRequest reports whether messages from a contact are held in the
+// Requests section instead of opening a window: it is a stranger with a
+// pending request
+func (a *App) HeldAsRequest(accountJID, contactJID string) bool {
+	return a.IsContactRequest(accountJID, contactJID) && a.isStranger(accountJID, contactJID)
+}
+
+// ContactRequests returns the pending requests of an account, or of every
+// account when accountJID is empty, sorted by JID
+func (a *App) ContactRequests(accountJID string) []ContactRequest {
+	a.mu.RLock()
+	var requests []ContactRequest
+	for key, kind := range a.contactRequests {
+		acc, jid, _ := strings.Cut(key, "|")
+		if accountJID == "" || acc == accountJID {
+			requests = append(requests, ContactRequest{AccountJID: acc, JID: jid, Kind: kind})
+		}
+	}
+	a.mu.RUnlock()
+	sort.Slice(requests, func(i, j int) bool {
+		if requests[i].AccountJID != requests[j].AccountJID {
+			return requests[i].AccountJID < requests[j].AccountJID
+		}
+		return requests[i].JID < requests[j].JID
+	})
+	return requests
+}
+
+// setContactRequest records a pending request. A subscription request is
+// not downgraded to a message one.
+func (a *App) setContactRequest(accountJID, contactJID, kind string) {
+	key := historyKey(accountJID, contactJID)
+	a.mu.Lock()
+	if a.contactRequests == nil {
+		a.contactRequests = make(map[string]string)
+	}
+	old := a.contactRequests[key]
+	if old == RequestSubscription {
+		kind = old
+	}
+	a.contactRequests[key] = kind
+	a.mu.Unlock()
+	if old == kind {
+		return
+	}
+	if a.storage != nil {
+		_ = a.storage.SetAppState(requestPrefKey(accountJID, contactJID), kind)
+	}
+	a.sendEvent(EventMsg{Type: EventRosterUpdate})
+}
+
+// clearContactRequest forgets a request and reports what kind it was
+func (a *App) clearContactRequest(accountJID, contactJID string) string {
+	key := historyKey(accountJID, contactJID)
+	a.mu.Lock()
+	kind := a.contactRequests[key]
+	delete(a.contactRequests, key)
+	delete(a.requestTimes, key)
+	a.mu.Unlock()
+	if kind == "" {
+		return ""
+	}
+	if a.storage != nil {
+		_ = a.storage.DeleteAppState(requestPrefKey(accountJID, contactJID))
+	}
+	a.sendEvent(EventMsg{Type: EventRosterUpdate})
+	return kind
+}
+
+// AcceptContactRequest moves a request to the regular contacts: a sender
+// not in the roster is added to it and asked for their presence, keeping
+// the conversation so far. A subscription request is approved.
+func (a *App) AcceptContactRequest(accountJID, contactJID string) error {
+	kind := a.clearContactRequest(accountJID, contactJID)
+	if kind == "" {
+		return fmt.Errorf("%s has no pending request", contactJID)
+	}
+	added := false
+	if !a.inRoster(accountJID, contactJID) {
+		if err := a.AddContactForAccount(accountJID, contactJID, "", ""); err != nil {
+			return err
+		}
+		added = true
+	}
+	if kind != RequestSubscription {
+		return nil
+	}
+	c := a.getConnectedClient(accountJID)
+	if c == nil {
+		return fmt.Errorf("not connected")
+	}
+	if err := c.ApproveSubscription(contactJID); err != nil {
+		return err
+	}
+	if added {
+		// Adding asked for their presence already
+		return nil
+	}
+	return c.Subscribe(contactJID)
+}
+
+// RejectContactRequest drops a request and the sender's roster entry. A
+// subscription request is declined.
+func (a *App) RejectContactRequest(accountJID, contactJID string) error {
+	kind := a.clearContactRequest(accountJID, contactJID)
+	if kind == "" {
+		return fmt.Errorf("%s has no pending request", contactJID)
+	}
+	a.removeStrangerFromRoster(accountJID, contactJID)
+	if kind != RequestSubscription {
+		return nil
+	}
+	c := a.getConnectedClient(accountJID)
+	if c == nil {
+		return fmt.Errorf("not connected")
+	}
+	return c.DenySubscription(contactJID)
+}
+
+// BlockContact blocks a JID: its request is dropped along with the roster
+// entry made for it, a subscription request is declined, and from now on
+// its messages and subscription requests are thrown away. The server is
+// asked to block it too when connected.
+func (a *App) BlockContact(accountJID, contactJID string) error {
+	contactJID = strings.TrimSpace(contactJID)
+	if accountJID == "" || contactJID == "" {
+		return fmt.Errorf("no contact to block")
+	}
+	kind := a.clearContactRequest(accountJID, contactJID)
+	a.removeStrangerFromRoster(accountJID, contactJID)
+
+	a.mu.Lock()
+	if a.blockedContacts == nil {
+		a.blockedContacts = make(map[string]bool)
+	}
+	a.blockedContacts[historyKey(accountJID, contactJID)] = true
+	a.mu.Unlock()
+	if a.storage != nil {
+		_ = a.storage.SetAppState(blockedPrefKey(accountJID, contactJID), "1")
+	}
+	a.sendEvent(EventMsg{Type: EventRosterUpdate})
+
+	c := a.getConnectedClient(accountJID)
+	if c == nil {
+		return nil
+	}
+	if kind == RequestSubscription {
+		_ = c.DenySubscription(contactJID)
+	}
+	return c.Block(contactJID)
+}
+
+// UnblockContact lifts a block set with BlockContact
+func (a *App) UnblockContact(accountJID, contactJID string) error {
+	contactJID = strings.TrimSpace(contactJID)
+	key := historyKey(accountJID, contactJID)
+	a.mu.Lock()
+	blocked := a.blockedContacts[key]
+	delete(a.blockedContacts, key)
+	a.mu.Unlock()
+	if !blocked {
+		return fmt.Errorf("%s is not blocked", contactJID)
+	}
+	if a.storage != nil {
+		_ = a.storage.DeleteAppState(blockedPrefKey(accountJID, contactJID))
+	}
+	if c := a.getConnectedClient(accountJID); c != nil {
+		return c.Unblock(contactJID)
+	}
+	return nil
+}
+
+// IsBlocked reports whether a JID was blocked on an account
+func (a *App) IsBlocked(accountJID, contactJID string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.blockedContacts[historyKey(accountJID, contactJID)]
+}
+
+// BlockedContacts returns the JIDs blocked on an account, sorted
+func (a *App) BlockedContacts(accountJID string) []string {
+	a.mu.RLock()
+	var jids []string
+	for key := range a.blockedContacts {
+		if acc, jid, _ := strings.Cut(key, "|"); acc == accountJID {
+			jids = append(jids, jid)
+		}
+	}
+	a.mu.RUnlock()
+	sort.Strings(jids)
+	return jids
+}
+
+// handleSubscriptionRequest files a subscription request. It is declined
+// when the sender or its domain is blocked, and approved when we already
+// see the contact's presence.
+func (a *App) handleSubscriptionRequest(accountJID, contactJID string) {
+	c := a.getConnectedClient(accountJID)
+	if a.IsBlocked(accountJID, contactJID) || a.requestDomainBlocked(contactJID) {
+		if c != nil {
+			_ = c.DenySubscription(contactJID)
+		}
+		return
+	}
+	if c != nil && a.subscribedTo(accountJID, contactJID) {
+		_ = c.ApproveSubscription(contactJID)
+		return
+	}
+	a.EnsureContactInRosterForAccount(accountJID, contactJID)
+	a.setContactRequest(accountJID, contactJID, RequestSubscription)
+	a.AddChatMessageForAccount(accountJID, contactJID, chat.Message{
+		ID:        fmt.Sprintf("request-%d", time.Now().UnixNano()),
+		From:      contactJID,
+		Body:      contactJID + " asks to see your presence. :accept, :reject or :block",
+		Timestamp: time.Now(),
+		Type:      "system",
+	})
+}
+
+// screenIncomingMessage decides what happens to a message from contactJID.
+// It reports false when the message is to be dropped; messages from
+// strangers that are kept are filed as requests.
+func (a *App) screenIncomingMessage(accountJID, contactJID string) bool {
+	if a.IsBlocked(accountJID, contactJID) {
+		return false
+	}
+	if !a.isStranger(accountJID, contactJID) {
+		return true
+	}
+	if a.requestDomainBlocked(contactJID) {
+		return false
+	}
+	a.mu.RLock()
+	quarantine := a.cfg.Requests.Quarantine
+	limit := a.cfg.Requests.MaxPerHour
+	a.mu.RUnlock()
+	if !quarantine {
+		return true
+	}
+	if !a.allowRequestMessage(accountJID, contactJID, limit, time.Now()) {
+		return false
+	}
+	a.setContactRequest(accountJID, contactJID, RequestMessage)
+	return true
+}
+
+// allowRequestMessage counts a message from a stranger and reports whether
+// it is within the hourly limit
+func (a *App) allowRequestMessage(accountJID, contactJID string, limit int, now time.Time) bool {
+	if limit <= 0 {
+		return true
+	}
+	key := historyKey(accountJID, contactJID)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.requestTimes == nil {
+		a.requestTimes = make(map[string][]time.Time)
+	}
+	recent := a.requestTimes[key][:0]
+	for _, t := range a.requestTimes[key] {
+		if now.Sub(t) < time.Hour {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= limit {
+		a.requestTimes[key] = recent
+		return false
+	}
+	a.requestTimes[key] = append(recent, now)
+	return true
+}
+
+// isStranger reports whether a sender is neither in the roster nor a room
+// we know, and has not been accepted. Services without a local part, like
+// the server itself, are not strangers.
+func (a *App) isStranger(accountJID, contactJID string) bool {
+	if !strings.Contains(contactJID, "@") {
+		return false
+	}
+	key := historyKey(accountJID, contactJID)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if _, ok := a.roomNicks[key]; ok {
+		return false
+	}
+	for _, r := range a.rosters {
+		if r.AccountJID == accountJID && r.JID == contactJID {
+			return !r.AddedToRoster && r.Subscription == "none" && !a.hasOutgoingLocked(key)
+		}
+	}
+	return true
+}
+
+// inRoster reports whether a contact was added to the roster of an account,
+// as opposed to an entry kept for someone who only wrote to us
+func (a *App) inRoster(accountJID, contactJID string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, r := range a.rosters {
+		if r.AccountJID == accountJID && r.JID == contactJID {
+			return r.AddedToRoster
+		}
+	}
+	return false
+}
+
+// subscribedTo reports whether we see a roster contact's presence
+func (a *App) subscribedTo(accountJID, contactJID string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, r := range a.rosters {
+		if r.AccountJID == accountJID && r.JID == contactJID {
+			return r.AddedToRoster && (r.Subscription == "to" || r.Subscription == "both")
+		}
+	}
+	return false
+}
+
+// hasOutgoingLocked reports whether we wrote in a conversation, which
+// makes its contact someone we talk to. The caller holds a.mu.
+func (a *App) hasOutgoingLocked(key string) bool {
+	for _, msg := range a.chatHistory[key] {
+		if msg.Outgoing {
+			return true
+		}
+	}
+	return false
+}
+
+// requestDomainBlocked reports whether a JID is on a blocked domain or one
+// of its subdomains
+func (a *App) requestDomainBlocked(contactJID string) bool {
+	domain := contactJID
+	if i := strings.LastIndex(domain, "@"); i >= 0 {
+		domain = domain[i+1:]
+	}
+	domain = strings.ToLower(domain)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, blocked := range a.cfg.Requests.BlockedDomains {
+		blocked = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(blocked), "."))
+		if blocked != "" && (domain == blocked || strings.HasSuffix(domain, "."+blocked)) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeStrangerFromRoster drops the roster entry made for someone who
+// wrote without being in the roster
+func (a *App) removeStrangerFromRoster(accountJID, contactJID string) {
+	a.mu.Lock()
+	removed := false
+	for i, r := range a.rosters {
+		if r.AccountJID == accountJID && r.JID == contactJID && !r.AddedToRoster {
+			a.rosters = append(a.rosters[:i], a.rosters[i+1:]...)
+			removed = true
+			break
+		}
+	}
+	a.mu.Unlock()
+	if removed {
+		a.saveRosterCacheForAccount(accountJID)
+		a.sendEvent(EventMsg{Type: EventRosterUpdate})
+	}
+}
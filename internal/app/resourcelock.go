@@ -0,0 +1,71 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A conversation can be locked to one client of a contact: messages then go
+// to that full JID instead of the bare JID, which the server routes to the
+// contact's preferred client. Locks last for the session.
+
+// LockedResource returns the full JID a conversation is locked to, or ""
+// when messages go to the bare JID
+func (a *App) LockedResource(accountJID, contactJID string) string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.resourceLocks[historyKey(accountJID, contactJID)]
+}
+
+// LockResource sends the messages of a conversation to one full JID, or back
+// to the bare JID when fullJID is ""
+func (a *App) LockResource(accountJID, contactJID, fullJID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := historyKey(accountJID, contactJID)
+	if fullJID == "" {
+		delete(a.resourceLocks, key)
+		return
+	}
+	if a.resourceLocks == nil {
+		a.resourceLocks = make(map[string]string)
+	}
+	a.resourceLocks[key] = fullJID
+}
+
+// CycleResourceLock locks a conversation to the next online client of the
+// contact, in priority order, and after the last one goes back to the bare
+// JID. It returns the full JID now locked to, or "" for the bare JID.
+func (a *App) CycleResourceLock(accountJID, contactJID string) (string, error) {
+	current := a.LockedResource(accountJID, contactJID)
+	resources := a.ContactResources(accountJID, contactJID)
+	if len(resources) == 0 && current == "" {
+		return "", fmt.Errorf("%s has no online client to lock to", contactJID)
+	}
+
+	next := ""
+	if current == "" {
+		next = resources[0].JID
+	} else {
+		for i, r := range resources {
+			if r.JID == current && i+1 < len(resources) {
+				next = resources[i+1].JID
+				break
+			}
+		}
+	}
+	a.LockResource(accountJID, contactJID, next)
+	return next, nil
+}
+
+// messageTarget is where a chat message to contact goes: the locked full
+// JID, or the JID as given
+func (a *App) messageTarget(accountJID, to string) string {
+	if strings.Contains(to, "/") {
+		return to
+	}
+	if locked := a.LockedResource(accountJID, to); locked != "" {
+		return locked
+	}
+	return to
+}
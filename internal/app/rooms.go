@@ -0,0 +1,74 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/meszmate/roster/internal/ui/components/dialogs"
+)
+
+// roomListTimeout bounds listing the rooms of a MUC service, including the
+// disco#info request sent to every room
+const roomListTimeout = 60 * time.Second
+
+// PublicRoom is a room listed by a MUC service
+type PublicRoom struct {
+	JID         string
+	Name        string
+	Description string
+	Occupants   int // -1 when unknown
+	Password    bool
+	MembersOnly bool
+}
+
+// RoomListMsg carries the rooms found by :rooms
+type RoomListMsg struct {
+	Service string
+	Rooms   []PublicRoom
+	Error   string
+}
+
+// BrowseRooms lists the public rooms of a MUC service with their occupant
+// counts. An empty service means the group chat service of the current
+// account's server.
+func (a *App) BrowseRooms(service string) tea.Cmd {
+	ctx, cancel := context.WithTimeout(a.ctx, roomListTimeout)
+	a.RegisterOperation(dialogs.OpBrowseRooms, cancel)
+
+	a.mu.RLock()
+	c := a.xmppClient
+	a.mu.RUnlock()
+
+	return func() tea.Msg {
+		defer cancel()
+
+		if c == nil || !c.IsConnected() {
+			return RoomListMsg{Service: service, Error: "not connected"}
+		}
+		if service == "" {
+			found, err := c.DiscoverMUCService(ctx)
+			if err != nil {
+				return RoomListMsg{Error: err.Error()}
+			}
+			service = found
+		}
+
+		rooms, err := c.ListRooms(ctx, service)
+		if err != nil {
+			return RoomListMsg{Service: service, Error: err.Error()}
+		}
+		list := make([]PublicRoom, 0, len(rooms))
+		for _, r := range rooms {
+			list = append(list, PublicRoom{
+				JID:         r.JID,
+				Name:        r.Name,
+				Description: r.Description,
+				Occupants:   r.Occupants,
+				Password:    r.Password,
+				MembersOnly: r.MembersOnly,
+			})
+		}
+		return RoomListMsg{Service: service, Rooms: list}
+	}
+}
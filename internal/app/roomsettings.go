@@ -0,0 +1,121 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/meszmate/xmpp-go/jid"
+)
+
+// Notification modes of a room
+const (
+	RoomNotifyAll      = "all"
+	RoomNotifyMentions = "mentions"
+	RoomNotifyNone     = "none"
+)
+
+const (
+	// defaultHistoryLoad is how many stored messages are loaded when a
+	// conversation opens
+	defaultHistoryLoad = 100
+	maxHistoryLoad     = 10000
+)
+
+// RoomSettings is what is remembered about a room apart from its bookmark.
+// They are stored per account and applied whenever the room is joined.
+type RoomSettings struct {
+	Nick         string
+	LastRead     time.Time
+	Notify       string // one of the RoomNotify modes
+	HistoryLimit int    // stored messages loaded when the room opens
+}
+
+// GetRoomSettings returns the settings of a room, with defaults filled in
+func (a *App) GetRoomSettings(accountJID, roomJID string) RoomSettings {
+	settings := RoomSettings{Notify: RoomNotifyAll, HistoryLimit: defaultHistoryLoad}
+	if a.storage == nil || roomJID == "" {
+		return settings
+	}
+	stored, err := a.storage.GetRoomSettings(accountJID, roomJID)
+	if err != nil {
+		return settings
+	}
+	settings.Nick = stored.Nick
+	settings.LastRead = stored.LastRead
+	if stored.Notify != "" {
+		settings.Notify = stored.Notify
+	}
+	if stored.HistoryLimit > 0 {
+		settings.HistoryLimit = stored.HistoryLimit
+	}
+	return settings
+}
+
+// RoomNick returns the nick to join a room with: the one last used there,
+// or else the local part of the account
+func (a *App) RoomNick(accountJID, roomJID string) string {
+	a.mu.RLock()
+	nick := a.roomNicks[historyKey(accountJID, roomJID)]
+	a.mu.RUnlock()
+	if nick != "" {
+		return nick
+	}
+	if nick = a.GetRoomSettings(accountJID, roomJID).Nick; nick != "" {
+		return nick
+	}
+	if parsed, err := jid.Parse(accountJID); err == nil {
+		return parsed.Local()
+	}
+	return ""
+}
+
+// SetRoomNotify sets when messages in a room are announced: all of them,
+// only those mentioning the own nick, or none
+func (a *App) SetRoomNotify(accountJID, roomJID, mode string) error {
+	if a.storage == nil {
+		return fmt.Errorf("storage is not available")
+	}
+	switch mode {
+	case RoomNotifyAll, RoomNotifyMentions, RoomNotifyNone:
+	default:
+		return fmt.Errorf("unknown mode %q (use all, mentions or none)", mode)
+	}
+	return a.storage.SetRoomNotify(accountJID, roomJID, mode)
+}
+
+// SetRoomHistoryLimit sets how many stored messages are loaded when a room
+// opens. Zero restores the default.
+func (a *App) SetRoomHistoryLimit(accountJID, roomJID string, limit int) error {
+	if a.storage == nil {
+		return fmt.Errorf("storage is not available")
+	}
+	if limit < 0 || limit > maxHistoryLoad {
+		return fmt.Errorf("history size must be between 1 and %d", maxHistoryLoad)
+	}
+	defer a.forgetHistoryLimit(accountJID, roomJID)
+	return a.storage.SetRoomHistoryLimit(accountJID, roomJID, limit)
+}
+
+// RoomWantsNotification reports whether a message in a room is announced
+// under the room's notification mode. Other conversations always are.
+func (a *App) RoomWantsNotification(accountJID, roomJID, body string) bool {
+	switch a.GetRoomSettings(accountJID, roomJID).Notify {
+	case RoomNotifyNone:
+		return false
+	case RoomNotifyMentions:
+		return mentionsNick(body, a.RoomNick(accountJID, roomJID))
+	}
+	return true
+}
+
+// historyLoadLimit returns how many stored messages of a conversation are
+// loaded when it opens
+func (a *App) historyLoadLimit(accountJID, contactJID string) int {
+	return a.GetRoomSettings(accountJID, contactJID).HistoryLimit
+}
+
+// mentionsNick reports whether a message body mentions a nick
+func mentionsNick(body, nick string) bool {
+	return nick != "" && strings.Contains(strings.ToLower(body), strings.ToLower(nick))
+}
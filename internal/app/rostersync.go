@@ -0,0 +1,407 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/meszmate/roster/internal/client"
+	"github.com/meszmate/roster/internal/ui/components/roster"
+)
+
+// Roster changes made here are shown at once and kept as pending edits
+// until the server confirms them. Edits made while the account is offline
+// are queued and replayed when it connects. Before a queued edit is
+// replayed, the server's version of the item is compared with the one the
+// edit was made against: when another client changed it meanwhile, the
+// edit is held back as a conflict for the user to resolve instead of one
+// side silently winning.
+
+// RosterEdit is a roster change the server has not confirmed yet
+type RosterEdit struct {
+	AccountJID string
+	JID        string
+	Name       string
+	Groups     []string
+	Remove     bool
+	Subscribe  bool // ask for the contact's presence once the item is set
+	Queued     bool // made while offline, waiting for the account to connect
+	CreatedAt  time.Time
+
+	// base is the entry as it was when the edit was made, nil when there
+	// was none
+	base *roster.Roster
+}
+
+// RosterConflict is a queued edit whose item was changed by another client
+// while the account was offline
+type RosterConflict struct {
+	Edit         RosterEdit
+	ServerExists bool
+	ServerName   string
+	ServerGroups []string
+}
+
+// Mine describes the local version of the item
+func (c RosterConflict) Mine() string {
+	if c.Edit.Remove {
+		return "removed"
+	}
+	return describeRosterItem(c.Edit.Name, c.Edit.Groups)
+}
+
+// Server describes the server's version of the item
+func (c RosterConflict) Server() string {
+	if !c.ServerExists {
+		return "removed"
+	}
+	return describeRosterItem(c.ServerName, c.ServerGroups)
+}
+
+func describeRosterItem(name string, groups []string) string {
+	if name == "" {
+		name = "(no name)"
+	}
+	if len(groups) == 0 {
+		return name + ", no groups"
+	}
+	return name + ", groups: " + strings.Join(groups, ", ")
+}
+
+// RosterConflictResolvedMsg is sent when a roster conflict was resolved
+type RosterConflictResolvedMsg struct {
+	AccountJID string
+	JID        string
+	KeptMine   bool
+	Queued     bool // the kept edit waits for the account to connect
+	Error      string
+}
+
+// RosterConflicts returns the unresolved roster conflicts, oldest first
+func (a *App) RosterConflicts() []RosterConflict {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	out := make([]RosterConflict, 0, len(a.rosterConflicts))
+	for _, c := range a.rosterConflicts {
+		out = append(out, *c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Edit.CreatedAt.Before(out[j].Edit.CreatedAt)
+	})
+	return out
+}
+
+// rosterEditQueued reports whether a change to a contact waits for the
+// account to connect
+func (a *App) rosterEditQueued(accountJID, contactJID string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	e := a.rosterEdits[historyKey(accountJID, contactJID)]
+	return e != nil && e.Queued
+}
+
+// editRoster applies a roster change locally and sends it, or queues it
+// when the account is offline. It reports whether the edit was queued.
+func (a *App) editRoster(edit RosterEdit) (bool, error) {
+	key := historyKey(edit.AccountJID, edit.JID)
+
+	a.mu.Lock()
+	if prev := a.rosterEdits[key]; prev != nil {
+		// The server has not seen the earlier edit, so both are compared
+		// against what it had
+		edit.base = prev.base
+		edit.Subscribe = edit.Subscribe || (prev.Subscribe && !edit.Remove)
+	} else {
+		edit.base = a.rosterEntryLocked(edit.AccountJID, edit.JID)
+	}
+	delete(a.rosterConflicts, key)
+	c := a.clients[edit.AccountJID]
+	edit.Queued = c == nil || !c.IsConnected()
+	edit.CreatedAt = time.Now()
+	if a.rosterEdits == nil {
+		a.rosterEdits = make(map[string]*RosterEdit)
+	}
+	e := &edit
+	a.rosterEdits[key] = e
+	a.applyRosterEditLocked(edit)
+	a.mu.Unlock()
+
+	a.saveRosterCacheForAccount(edit.AccountJID)
+	a.sendEvent(EventMsg{Type: EventRosterUpdate})
+	if edit.Queued {
+		return true, nil
+	}
+	return false, a.sendRosterEdit(c, e)
+}
+
+// sendRosterEdit sends a roster change to the server. When the server
+// refuses it the local roster goes back to how it was.
+func (a *App) sendRosterEdit(c *client.Client, e *RosterEdit) error {
+	var err error
+	if e.Remove {
+		err = c.RemoveContact(e.JID)
+	} else {
+		err = c.AddContact(e.JID, e.Name, e.Groups)
+	}
+
+	key := historyKey(e.AccountJID, e.JID)
+	a.mu.Lock()
+	// A newer edit of the same contact takes over
+	current := a.rosterEdits[key] == e
+	if current {
+		delete(a.rosterEdits, key)
+		if err != nil {
+			a.revertRosterEditLocked(*e)
+		}
+	}
+	a.mu.Unlock()
+
+	if err != nil {
+		if current {
+			a.saveRosterCacheForAccount(e.AccountJID)
+			a.sendEvent(EventMsg{Type: EventRosterUpdate})
+		}
+		return err
+	}
+	if e.Subscribe {
+		if err := c.Subscribe(e.JID); err != nil {
+			return fmt.Errorf("failed to subscribe: %w", err)
+		}
+	}
+	return nil
+}
+
+// syncRosterEditsLocked compares the pending edits of an account with the
+// roster the server sent. Edits the server already has are dropped, queued
+// ones it can take are returned for replay, and the rest become conflicts.
+// The caller holds a.mu and applies overlayRosterEditsLocked afterwards.
+func (a *App) syncRosterEditsLocked(accountJID string, items []client.RosterItem) (replay []*RosterEdit, conflicts []RosterConflict) {
+	server := make(map[string]client.RosterItem, len(items))
+	for _, item := range items {
+		if item.Subscription != "remove" {
+			server[item.JID.Bare().String()] = item
+		}
+	}
+
+	for key, e := range a.rosterEdits {
+		if e.AccountJID != accountJID || a.rosterConflicts[key] != nil {
+			continue
+		}
+		item, exists := server[e.JID]
+		switch {
+		case e.matches(item, exists):
+			if e.Queued && !e.Subscribe {
+				delete(a.rosterEdits, key)
+			} else if e.Queued {
+				replay = append(replay, a.sendingLocked(key, e))
+			}
+		case !e.Queued:
+			// Under way; the server's answer decides
+		case e.baseMatches(item, exists):
+			replay = append(replay, a.sendingLocked(key, e))
+		default:
+			conflict := &RosterConflict{
+				Edit:         *e,
+				ServerExists: exists,
+				ServerName:   item.Name,
+				ServerGroups: item.Groups,
+			}
+			if a.rosterConflicts == nil {
+				a.rosterConflicts = make(map[string]*RosterConflict)
+			}
+			a.rosterConflicts[key] = conflict
+			conflicts = append(conflicts, *conflict)
+		}
+	}
+	return replay, conflicts
+}
+
+// sendingLocked replaces a queued edit with one that is under way. The
+// caller holds a.mu.
+func (a *App) sendingLocked(key string, e *RosterEdit) *RosterEdit {
+	sending := *e
+	sending.Queued = false
+	a.rosterEdits[key] = &sending
+	return &sending
+}
+
+// overlayRosterEditsLocked keeps the pending edits of an account visible
+// over the roster the server sent. Conflicts show the server's version
+// until they are resolved. The caller holds a.mu.
+func (a *App) overlayRosterEditsLocked(accountJID string) {
+	for key, e := range a.rosterEdits {
+		if e.AccountJID == accountJID && a.rosterConflicts[key] == nil {
+			a.applyRosterEditLocked(*e)
+		}
+	}
+}
+
+// replayRosterEdits sends the edits queued while the account was offline
+func (a *App) replayRosterEdits(c *client.Client, edits []*RosterEdit) {
+	for _, e := range edits {
+		if err := a.sendRosterEdit(c, e); err != nil {
+			a.sendEvent(EventMsg{
+				Type: EventError,
+				Data: fmt.Sprintf("Failed to send queued roster change for %s: %v", e.JID, err),
+			})
+		}
+	}
+}
+
+// ResolveRosterConflict settles a conflict: keepMine sends the local edit
+// over the server's version, otherwise the edit is dropped and the
+// server's version stays.
+func (a *App) ResolveRosterConflict(accountJID, contactJID string, keepMine bool) tea.Cmd {
+	return func() tea.Msg {
+		msg := RosterConflictResolvedMsg{AccountJID: accountJID, JID: contactJID, KeptMine: keepMine}
+		key := historyKey(accountJID, contactJID)
+
+		a.mu.Lock()
+		e := a.rosterEdits[key]
+		delete(a.rosterConflicts, key)
+		if e == nil {
+			a.mu.Unlock()
+			msg.Error = "the conflict was already resolved"
+			return msg
+		}
+		if !keepMine {
+			delete(a.rosterEdits, key)
+			a.mu.Unlock()
+			a.sendEvent(EventMsg{Type: EventRosterUpdate})
+			return msg
+		}
+
+		// The server's version is what the edit now replaces
+		kept := *e
+		kept.base = a.rosterEntryLocked(accountJID, contactJID)
+		c := a.clients[accountJID]
+		kept.Queued = c == nil || !c.IsConnected()
+		a.rosterEdits[key] = &kept
+		a.applyRosterEditLocked(kept)
+		a.mu.Unlock()
+
+		a.saveRosterCacheForAccount(accountJID)
+		a.sendEvent(EventMsg{Type: EventRosterUpdate})
+		if kept.Queued {
+			msg.Queued = true
+			return msg
+		}
+		if err := a.sendRosterEdit(c, &kept); err != nil {
+			msg.Error = err.Error()
+		}
+		return msg
+	}
+}
+
+// rosterEntryLocked returns a copy of a contact's roster entry, or nil. The
+// caller holds a.mu.
+func (a *App) rosterEntryLocked(accountJID, contactJID string) *roster.Roster {
+	for _, r := range a.rosters {
+		if r.AccountJID == accountJID && r.JID == contactJID {
+			entry := r
+			entry.Groups = append([]string(nil), r.Groups...)
+			return &entry
+		}
+	}
+	return nil
+}
+
+// applyRosterEditLocked shows an edit in the local roster. The caller holds
+// a.mu.
+func (a *App) applyRosterEditLocked(e RosterEdit) {
+	for i := range a.rosters {
+		if a.rosters[i].AccountJID != e.AccountJID || a.rosters[i].JID != e.JID {
+			continue
+		}
+		if e.Remove {
+			a.rosters = append(a.rosters[:i], a.rosters[i+1:]...)
+			return
+		}
+		a.rosters[i].Name = e.Name
+		a.rosters[i].Groups = e.Groups
+		a.rosters[i].AddedToRoster = true
+		if a.rosters[i].Subscription == "" {
+			a.rosters[i].Subscription = "none"
+		}
+		return
+	}
+	if e.Remove {
+		return
+	}
+
+	a.rosters = append(a.rosters, roster.Roster{
+		JID:           e.JID,
+		Name:          e.Name,
+		Groups:        e.Groups,
+		Status:        "offline",
+		AccountJID:    e.AccountJID,
+		AddedToRoster: true,
+		Subscription:  "none",
+	})
+	if a.statusSharing == nil {
+		a.statusSharing = make(map[string]bool)
+	}
+	a.statusSharing[historyKey(e.AccountJID, e.JID)] = true
+}
+
+// revertRosterEditLocked puts back the item an edit replaced. The caller
+// holds a.mu.
+func (a *App) revertRosterEditLocked(e RosterEdit) {
+	for i := range a.rosters {
+		if a.rosters[i].AccountJID != e.AccountJID || a.rosters[i].JID != e.JID {
+			continue
+		}
+		if e.base == nil {
+			a.rosters = append(a.rosters[:i], a.rosters[i+1:]...)
+			return
+		}
+		base := *e.base
+		base.Status = a.rosters[i].Status
+		base.StatusMsg = a.rosters[i].StatusMsg
+		base.Unread = a.rosters[i].Unread
+		a.rosters[i] = base
+		return
+	}
+	if e.base != nil {
+		a.rosters = append(a.rosters, *e.base)
+	}
+}
+
+// matches reports whether the server's item already is what the edit makes
+// it
+func (e *RosterEdit) matches(item client.RosterItem, exists bool) bool {
+	if e.Remove {
+		return !exists
+	}
+	return exists && item.Name == e.Name && sameGroups(item.Groups, e.Groups)
+}
+
+// baseMatches reports whether the server's item is still the one the edit
+// was made against
+func (e *RosterEdit) baseMatches(item client.RosterItem, exists bool) bool {
+	if e.base == nil || !e.base.AddedToRoster {
+		return !exists
+	}
+	return exists && item.Name == e.base.Name && sameGroups(item.Groups, e.base.Groups)
+}
+
+// sameGroups compares group lists regardless of order and case
+func sameGroups(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[string]int, len(a))
+	for _, g := range a {
+		seen[strings.ToLower(g)]++
+	}
+	for _, g := range b {
+		key := strings.ToLower(g)
+		if seen[key] == 0 {
+			return false
+		}
+		seen[key]--
+	}
+	return true
+}
@@ -0,0 +1,150 @@
+package app
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/meszmate/roster/internal/storage/sqlite"
+)
+
+// History search covers the messages stored in the database, of chats and
+// rooms alike, so conversations whose saving is off are not found. Besides
+// words a query takes filters:
+//
+//	from:bob          a nick in a room, a JID or its local part; from:me for our own
+//	in:room           a conversation JID or its local part
+//	after:2024-05-01  on or after a day, or after:30d for the last 30 days
+//	before:2024-06-01 before a day, or before:2w for older than two weeks
+
+// searchLimit is how many messages a search returns at most
+const searchLimit = 200
+
+// SearchHit is a stored message found by SearchHistory
+type SearchHit struct {
+	JID       string // the conversation
+	Room      bool
+	Who       string // the nick in a room, the bare JID otherwise, "me" for ours
+	Body      string
+	Timestamp time.Time
+}
+
+// ParseSearchQuery splits a query into its filters and words
+func ParseSearchQuery(query string, now time.Time) (sqlite.MessageSearch, error) {
+	var search sqlite.MessageSearch
+	var words []string
+	for _, field := range strings.Fields(query) {
+		key, value, ok := strings.Cut(field, ":")
+		if !ok || value == "" {
+			words = append(words, field)
+			continue
+		}
+		switch strings.ToLower(key) {
+		case "from":
+			if strings.EqualFold(value, "me") {
+				search.FromMe = true
+			} else {
+				search.From = value
+			}
+		case "in":
+			search.In = value
+		case "after", "before":
+			t, err := parseSearchTime(value, now)
+			if err != nil {
+				return search, fmt.Errorf("%s: %w", key, err)
+			}
+			if key == "after" {
+				search.After = t
+			} else {
+				search.Before = t
+			}
+		default:
+			// A link or a time of day, not a filter
+			words = append(words, field)
+		}
+	}
+	search.Text = strings.Join(words, " ")
+	if search.Text == "" && search.From == "" && !search.FromMe && search.In == "" {
+		return search, fmt.Errorf("nothing to search for")
+	}
+	return search, nil
+}
+
+// parseSearchTime reads a day (2006-01-02) as its start in local time, or
+// a number of days (30d) or weeks (2w) back from now
+func parseSearchTime(value string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, now.Location()); err == nil {
+		return t, nil
+	}
+	unit := value[len(value)-1]
+	n, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || n < 0 || (unit != 'd' && unit != 'w') {
+		return time.Time{}, fmt.Errorf("use a day like 2024-05-01 or a span like 30d or 2w, not %q", value)
+	}
+	if unit == 'w' {
+		n *= 7
+	}
+	return now.AddDate(0, 0, -n), nil
+}
+
+// SearchHistory searches an account's stored history, newest first
+func (a *App) SearchHistory(accountJID, query string) ([]SearchHit, error) {
+	if a.storage == nil {
+		return nil, fmt.Errorf("history is not stored")
+	}
+	search, err := ParseSearchQuery(query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	search.Limit = searchLimit
+	results, err := a.storage.SearchMessages(accountJID, search)
+	if err != nil {
+		return nil, err
+	}
+
+	// A conversation is a room when we know our nick in it, from now or
+	// from having joined it before
+	rooms := make(map[string]bool)
+	for _, r := range results {
+		if _, seen := rooms[r.JID]; !seen {
+			rooms[r.JID] = a.knownRoom(accountJID, r.JID)
+		}
+	}
+
+	hits := make([]SearchHit, 0, len(results))
+	for _, r := range results {
+		hits = append(hits, SearchHit{
+			JID:       r.JID,
+			Room:      rooms[r.JID],
+			Who:       senderName(r.JID, r.Sender, r.Outgoing, rooms[r.JID]),
+			Body:      r.Body,
+			Timestamp: r.Timestamp,
+		})
+	}
+	return hits, nil
+}
+
+// knownRoom reports whether a JID is a room the account has joined
+func (a *App) knownRoom(accountJID, jidStr string) bool {
+	a.mu.RLock()
+	nick := a.roomNicks[historyKey(accountJID, jidStr)]
+	a.mu.RUnlock()
+	return nick != "" || a.GetRoomSettings(accountJID, jidStr).Nick != ""
+}
+
+// senderName names the sender of a stored message of a conversation: "me"
+// for ours, the nick in a room and the bare JID otherwise
+func senderName(conversation, sender string, outgoing, room bool) string {
+	if outgoing {
+		return "me"
+	}
+	bare, resource, _ := strings.Cut(sender, "/")
+	switch {
+	case room && resource != "":
+		return resource
+	case bare != "":
+		return bare
+	}
+	return conversation
+}
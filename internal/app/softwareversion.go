@@ -0,0 +1,122 @@
+package app
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/meszmate/roster/internal/client"
+)
+
+// softwareVersionTTL is how long an answer is reused before asking again;
+// people rarely update their client within a session
+const softwareVersionTTL = 6 * time.Hour
+
+// softwareVersionEntry is what a contact answered, or that it is being asked
+type softwareVersionEntry struct {
+	Version client.SoftwareVersion
+	Err     string
+	Asked   time.Time
+	Pending bool
+}
+
+// SoftwareVersionMsg is sent when a contact answered a software version
+// query, or failed to
+type SoftwareVersionMsg struct {
+	AccountJID string
+	JID        string
+	Version    client.SoftwareVersion
+	Error      string
+}
+
+// SoftwareVersion describes the client a contact runs, as last asked with
+// :version: the software, "asking..." while waiting, why it failed, or ""
+// when it was not asked yet
+func (a *App) SoftwareVersion(accountJID, contactJID string) string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	entry := a.softwareVersions[historyKey(accountJID, contactJID)]
+	switch {
+	case entry == nil:
+		return ""
+	case entry.Pending:
+		return "asking..."
+	case entry.Err != "":
+		return "not available (" + entry.Err + ")"
+	}
+	return entry.Version.String()
+}
+
+// QuerySoftwareVersion asks a contact which client it runs. A fresh answer
+// from earlier in the session is reused unless force is set.
+func (a *App) QuerySoftwareVersion(accountJID, contactJID string, force bool) tea.Cmd {
+	key := historyKey(accountJID, contactJID)
+
+	a.mu.Lock()
+	if a.softwareVersions == nil {
+		a.softwareVersions = make(map[string]*softwareVersionEntry)
+	}
+	entry := a.softwareVersions[key]
+	if entry != nil && (entry.Pending || (!force && entry.Err == "" && time.Since(entry.Asked) < softwareVersionTTL)) {
+		a.mu.Unlock()
+		return nil
+	}
+	a.softwareVersions[key] = &softwareVersionEntry{Asked: time.Now(), Pending: true}
+	c := a.clients[accountJID]
+	a.mu.Unlock()
+
+	return func() tea.Msg {
+		msg := SoftwareVersionMsg{AccountJID: accountJID, JID: contactJID}
+		var err error
+		if c == nil || !c.IsConnected() {
+			err = fmt.Errorf("account not connected")
+		} else {
+			msg.Version, err = c.QueryVersion(contactJID)
+		}
+		if err != nil {
+			msg.Error = err.Error()
+			if info := DescribeError(err); info.Title != "Error" {
+				msg.Error = strings.ToLower(info.Title)
+			}
+		}
+
+		a.mu.Lock()
+		a.softwareVersions[key] = &softwareVersionEntry{Version: msg.Version, Err: msg.Error, Asked: time.Now()}
+		a.mu.Unlock()
+		return msg
+	}
+}
+
+// softwareVersionReply is what this client tells contacts that ask, or nil
+// to refuse
+func (a *App) softwareVersionReply() *client.SoftwareVersion {
+	a.mu.RLock()
+	cfg := a.cfg.SoftwareVersion
+	a.mu.RUnlock()
+	if !cfg.Reply {
+		return nil
+	}
+	v := &client.SoftwareVersion{Name: cfg.Name, Version: cfg.Version}
+	if v.Name == "" {
+		v.Name = "roster"
+	}
+	if v.Version == "" {
+		v.Version = buildVersion()
+	}
+	if cfg.ShowOS {
+		v.OS = runtime.GOOS
+	}
+	return v
+}
+
+// buildVersion is the module version roster was built from, or "dev" for
+// a local build
+func buildVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/meszmate/roster/internal/storage/sqlite"
+	"github.com/meszmate/roster/internal/ui/components/chat"
+)
+
+// Starred messages are a personal list of messages worth keeping, across
+// all conversations of an account. A copy of each is stored, so they stay
+// listed when the conversation's history is not saved or was cleared.
+
+// StarredMessage is a starred message as listed by :starred
+type StarredMessage struct {
+	JID       string // the conversation
+	Room      bool
+	ID        string
+	Who       string // the nick in a room, the bare JID otherwise, "me" for ours
+	Body      string
+	Timestamp time.Time
+	StarredAt time.Time
+}
+
+// ToggleStar stars a message of a conversation, or removes its star. It
+// reports whether the message is starred now.
+func (a *App) ToggleStar(accountJID, jid string, msg chat.Message) (bool, error) {
+	if a.storage == nil {
+		return false, fmt.Errorf("starred messages are not stored")
+	}
+	if msg.ID == "" || msg.Type == "system" {
+		return false, fmt.Errorf("this message cannot be starred")
+	}
+	for _, id := range a.StarredIDs(accountJID, jid) {
+		if id == msg.ID {
+			return false, a.storage.UnstarMessage(accountJID, jid, msg.ID)
+		}
+	}
+	err := a.storage.StarMessage(accountJID, sqlite.StarredMessage{
+		JID:       jid,
+		ID:        msg.ID,
+		Sender:    msg.From,
+		Body:      msg.Body,
+		Timestamp: msg.Timestamp,
+		Outgoing:  msg.Outgoing,
+		StarredAt: time.Now(),
+	})
+	return err == nil, err
+}
+
+// UnstarMessage removes the star of a message
+func (a *App) UnstarMessage(accountJID, jid, id string) error {
+	if a.storage == nil {
+		return fmt.Errorf("starred messages are not stored")
+	}
+	return a.storage.UnstarMessage(accountJID, jid, id)
+}
+
+// StarredIDs returns the IDs of the starred messages of a conversation
+func (a *App) StarredIDs(accountJID, jid string) []string {
+	if a.storage == nil || accountJID == "" {
+		return nil
+	}
+	starred, err := a.storage.GetStarredMessages(accountJID)
+	if err != nil {
+		return nil
+	}
+	var ids []string
+	for _, msg := range starred {
+		if msg.JID == jid {
+			ids = append(ids, msg.ID)
+		}
+	}
+	return ids
+}
+
+// StarredMessages returns the starred messages of an account, most
+// recently starred first
+func (a *App) StarredMessages(accountJID string) ([]StarredMessage, error) {
+	if a.storage == nil {
+		return nil, fmt.Errorf("starred messages are not stored")
+	}
+	stored, err := a.storage.GetStarredMessages(accountJID)
+	if err != nil {
+		return nil, err
+	}
+
+	rooms := make(map[string]bool)
+	starred := make([]StarredMessage, 0, len(stored))
+	for _, msg := range stored {
+		room, seen := rooms[msg.JID]
+		if !seen {
+			room = a.knownRoom(accountJID, msg.JID)
+			rooms[msg.JID] = room
+		}
+		starred = append(starred, StarredMessage{
+			JID:       msg.JID,
+			Room:      room,
+			ID:        msg.ID,
+			Who:       senderName(msg.JID, msg.Sender, msg.Outgoing, room),
+			Body:      msg.Body,
+			Timestamp: msg.Timestamp,
+			StarredAt: msg.StarredAt,
+		})
+	}
+	return starred, nil
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// The status hook is a user command run on a timer whose output sets the
+// presence, for calendar based DND and the like without an integration for
+// each calendar. It prints one line, "show|message" such as
+// "dnd|In a meeting until 15:00", or just "show". An empty line hands the
+// status back: whatever was set before the hook first changed it is
+// restored. The status is only sent while connected, when the output
+// changes and again after connecting, which resets it to online; a status
+// set by hand stays until the hook has something new to say.
+
+const (
+	// DefaultStatusHookInterval is how often the hook runs when
+	// status_hook.interval is not set
+	DefaultStatusHookInterval = time.Minute
+
+	// statusHookTimeout stops a hook that hangs
+	statusHookTimeout = 30 * time.Second
+)
+
+// StatusHookEvent reports a status change made by the hook, or a hook that
+// stopped working
+type StatusHookEvent struct {
+	Status  string
+	Message string
+	Error   string
+}
+
+// statusHookState is what the hook last did
+type statusHookState struct {
+	output    string // last line printed, "" when the hook has not set anything
+	err       string
+	saved     *[2]string // status and message from before the hook took over
+	connected bool       // whether the app was connected at the last run
+}
+
+// runStatusHook runs the status hook on its timer until the app shuts down
+func (a *App) runStatusHook() {
+	a.mu.RLock()
+	command := strings.TrimSpace(a.cfg.StatusHook.Command)
+	interval := time.Duration(a.cfg.StatusHook.Interval) * time.Second
+	a.mu.RUnlock()
+	if command == "" || a.demo {
+		return
+	}
+	if interval <= 0 {
+		interval = DefaultStatusHookInterval
+	}
+
+	var state statusHookState
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		a.checkStatusHook(command, &state)
+		select {
+		case <-t.C:
+		case <-a.ctx.Done():
+			return
+		}
+	}
+}
+
+// checkStatusHook runs the hook once and applies its output
+func (a *App) checkStatusHook(command string, state *statusHookState) {
+	ctx, cancel := context.WithTimeout(a.ctx, statusHookTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "sh", "-c", command).Output()
+	if err != nil {
+		if msg := err.Error(); msg != state.err {
+			state.err = msg
+			a.sendEvent(EventMsg{Type: EventStatusHook, Data: StatusHookEvent{Error: "status hook failed: " + msg}})
+		}
+		return
+	}
+	state.err = ""
+
+	line, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	line = strings.TrimSpace(line)
+	connected := a.Connected()
+	reconnected := connected && !state.connected
+	state.connected = connected
+	if !connected || (line == state.output && (line == "" || !reconnected)) {
+		return
+	}
+
+	if line == "" {
+		state.output = ""
+		if state.saved != nil {
+			status, message := state.saved[0], state.saved[1]
+			state.saved = nil
+			a.applyHookStatus(status, message)
+		}
+		return
+	}
+
+	status, message, err := parseStatusHookLine(line)
+	if err != nil {
+		if msg := err.Error(); msg != state.err {
+			state.err = msg
+			a.sendEvent(EventMsg{Type: EventStatusHook, Data: StatusHookEvent{Error: "status hook: " + msg}})
+		}
+		return
+	}
+	if state.saved == nil {
+		a.mu.RLock()
+		saved := [2]string{a.status, a.statusMsg}
+		a.mu.RUnlock()
+		if _, _, err := parseStatusHookLine(saved[0]); err != nil {
+			saved[0] = "online" // e.g. still connecting
+		}
+		state.saved = &saved
+	}
+	state.output = line
+	a.applyHookStatus(status, message)
+}
+
+// applyHookStatus sets and sends the status chosen by the hook
+func (a *App) applyHookStatus(status, message string) {
+	err := a.SetStatusAndSend(status, message)
+	event := StatusHookEvent{Status: status, Message: message}
+	if err != nil {
+		event.Error = fmt.Sprintf("status hook: sending %s failed: %v", status, err)
+	}
+	a.sendEvent(EventMsg{Type: EventStatusHook, Data: event})
+}
+
+// parseStatusHookLine splits "show|message" and checks the show
+func parseStatusHookLine(line string) (status, message string, err error) {
+	status, message, _ = strings.Cut(line, "|")
+	status = strings.ToLower(strings.TrimSpace(status))
+	message = strings.TrimSpace(message)
+	switch status {
+	case "online", "chat", "away", "dnd", "xa":
+		return status, message, nil
+	}
+	return "", "", fmt.Errorf("unknown status %q, expected online, chat, away, dnd or xa", status)
+}
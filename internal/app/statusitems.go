@@ -0,0 +1,140 @@
+package app
+
+import (
+	"sort"
+	"time"
+)
+
+// Plugins and other extensions can put items in the status bar. Each item
+// has an id, a priority that orders it among the others, a width limit,
+// and optionally a refresh callback run on a timer for things like a
+// weather or CI widget, and an activation callback run when the item is
+// clicked or chosen with :statusbar.
+
+const (
+	// DefaultStatusItemWidth limits item text when the item sets no limit
+	DefaultStatusItemWidth = 24
+
+	// minStatusItemRefresh keeps a widget from refreshing in a busy loop
+	minStatusItemRefresh = time.Second
+)
+
+// StatusItem is an item in the status bar
+type StatusItem struct {
+	ID         string
+	Text       string
+	Priority   int           // higher comes first
+	MaxWidth   int           // in cells, 0 for DefaultStatusItemWidth
+	Refresh    time.Duration // how often OnRefresh runs, 0 for never
+	OnRefresh  func() string // returns the new text
+	OnActivate func()        // run when the item is clicked or chosen
+}
+
+// statusItem is a registered item and the refresh loop it runs
+type statusItem struct {
+	StatusItem
+	stop chan struct{}
+}
+
+// SetStatusItem adds a status bar item, or replaces the one with its id
+func (a *App) SetStatusItem(item StatusItem) {
+	if item.MaxWidth <= 0 {
+		item.MaxWidth = DefaultStatusItemWidth
+	}
+	entry := &statusItem{StatusItem: item}
+	if item.Refresh > 0 && item.OnRefresh != nil {
+		if entry.Refresh < minStatusItemRefresh {
+			entry.Refresh = minStatusItemRefresh
+		}
+		entry.stop = make(chan struct{})
+	}
+
+	a.mu.Lock()
+	if a.statusItems == nil {
+		a.statusItems = make(map[string]*statusItem)
+	}
+	old := a.statusItems[item.ID]
+	a.statusItems[item.ID] = entry
+	a.mu.Unlock()
+
+	if old != nil && old.stop != nil {
+		close(old.stop)
+	}
+	if entry.stop != nil {
+		go a.refreshStatusItem(entry)
+	}
+	a.sendEvent(EventMsg{Type: EventStatusItemsChanged})
+}
+
+// RemoveStatusItem takes an item out of the status bar
+func (a *App) RemoveStatusItem(id string) {
+	a.mu.Lock()
+	old := a.statusItems[id]
+	delete(a.statusItems, id)
+	a.mu.Unlock()
+	if old == nil {
+		return
+	}
+	if old.stop != nil {
+		close(old.stop)
+	}
+	a.sendEvent(EventMsg{Type: EventStatusItemsChanged})
+}
+
+// StatusItems returns the status bar items in display order: by priority,
+// then by id
+func (a *App) StatusItems() []StatusItem {
+	a.mu.RLock()
+	items := make([]StatusItem, 0, len(a.statusItems))
+	for _, item := range a.statusItems {
+		items = append(items, item.StatusItem)
+	}
+	a.mu.RUnlock()
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Priority != items[j].Priority {
+			return items[i].Priority > items[j].Priority
+		}
+		return items[i].ID < items[j].ID
+	})
+	return items
+}
+
+// ActivateStatusItem runs the activation callback of an item. It reports
+// false when there is no such item or it has no callback.
+func (a *App) ActivateStatusItem(id string) bool {
+	a.mu.RLock()
+	item := a.statusItems[id]
+	a.mu.RUnlock()
+	if item == nil || item.OnActivate == nil {
+		return false
+	}
+	go item.OnActivate()
+	return true
+}
+
+// refreshStatusItem updates the text of an item on its timer until the
+// item is replaced or removed, or the app shuts down
+func (a *App) refreshStatusItem(item *statusItem) {
+	t := time.NewTicker(item.Refresh)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			text := item.OnRefresh()
+			a.mu.Lock()
+			current := a.statusItems[item.ID] == item
+			if current {
+				item.Text = text
+			}
+			a.mu.Unlock()
+			if !current {
+				return
+			}
+			a.sendEvent(EventMsg{Type: EventStatusItemsChanged})
+		case <-item.stop:
+			return
+		case <-a.ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package app
+
+import (
+	"strings"
+
+	"github.com/meszmate/roster/internal/client"
+)
+
+// loadStatusSharingForAccount restores the contacts an account hides its
+// status from
+func (a *App) loadStatusSharingForAccount(accountJID string) {
+	if a.storage == nil || accountJID == "" {
+		return
+	}
+	settings, err := a.storage.GetStatusSharingSettings(accountJID)
+	if err != nil {
+		return
+	}
+
+	a.mu.Lock()
+	for contactJID, enabled := range settings {
+		a.statusSharing[historyKey(accountJID, contactJID)] = enabled
+	}
+	a.mu.Unlock()
+}
+
+// hiddenStatusContacts returns the contacts an account does not share its
+// status with
+func (a *App) hiddenStatusContacts(accountJID string) []string {
+	prefix := accountJID + "|"
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	var hidden []string
+	for key, enabled := range a.statusSharing {
+		if !enabled && strings.HasPrefix(key, prefix) {
+			hidden = append(hidden, historyContactFromKey(key))
+		}
+	}
+	return hidden
+}
+
+// sendPresence broadcasts presence for an account, then sends unavailable
+// to the contacts it hides its status from, since the broadcast reaches
+// every subscriber
+func (a *App) sendPresence(accountJID string, c *client.Client, show, status string) error {
+	if err := c.SendPresence(show, status); err != nil {
+		return err
+	}
+	for _, contactJID := range a.hiddenStatusContacts(accountJID) {
+		_ = c.HideStatusFrom(contactJID)
+	}
+	return nil
+}
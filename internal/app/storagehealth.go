@@ -0,0 +1,73 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/meszmate/roster/internal/storage/sqlite"
+)
+
+// StorageBanner returns the warning shown above every chat after the
+// database was found damaged at startup and rebuilt, until it is looked at
+// with :db
+func (a *App) StorageBanner() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.storage == nil || a.storageBannerSeen {
+		return ""
+	}
+	rec := a.storage.Recovered()
+	if rec == nil {
+		return ""
+	}
+	banner := "Message database was damaged and has been rebuilt"
+	if len(rec.Partial) > 0 || len(rec.Lost) > 0 {
+		banner += ", some history may be missing"
+	}
+	return banner + " (:db for details)"
+}
+
+// DatabaseReport describes the database for :db: where it is, its schema
+// version and what happened if it was rebuilt. It also acknowledges the
+// rebuilt database banner.
+func (a *App) DatabaseReport() string {
+	a.mu.Lock()
+	a.storageBannerSeen = true
+	storage := a.storage
+	a.mu.Unlock()
+
+	if storage == nil {
+		return "No database is open; history and the roster cache are not saved."
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "File: %s\n", storage.Path())
+	if version, err := storage.Version(); err != nil {
+		fmt.Fprintf(&b, "Schema: unreadable (%v)\n", err)
+	} else {
+		fmt.Fprintf(&b, "Schema: version %d (this build writes %d)\n", version, sqlite.SchemaVersion())
+	}
+
+	rec := storage.Recovered()
+	if rec == nil {
+		b.WriteString("Integrity: passed at startup")
+		return b.String()
+	}
+	fmt.Fprintf(&b, "Integrity: failed at startup, %s\n", rec.At.Format("2006-01-02 15:04"))
+	fmt.Fprintf(&b, "Problem: %s\n", rec.Problem)
+	fmt.Fprintf(&b, "Damaged file kept at: %s\n", rec.BackupPath)
+	if len(rec.Salvaged) > 0 {
+		fmt.Fprintf(&b, "Recovered: %s\n", strings.Join(rec.Salvaged, ", "))
+	}
+	if len(rec.Partial) > 0 {
+		fmt.Fprintf(&b, "Partly recovered: %s\n", strings.Join(rec.Partial, ", "))
+	}
+	if len(rec.Lost) > 0 {
+		fmt.Fprintf(&b, "Lost: %s\n", strings.Join(rec.Lost, ", "))
+	}
+	if len(rec.Salvaged)+len(rec.Partial)+len(rec.Lost) == 0 {
+		b.WriteString("Nothing could be read from the damaged file.\n")
+	}
+	b.WriteString("Delete the damaged file once you no longer need it.")
+	return b.String()
+}
@@ -0,0 +1,70 @@
+package app
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Composer markdown that has a XEP-0393 message styling equivalent. Spans
+// must not start or end with whitespace, as in XEP-0393 itself.
+var (
+	mdStrongRe = regexp.MustCompile(`(\*\*|__)([^\s*_](?:[^*_]*[^\s*_])?)(\*\*|__)`)
+	mdEmRe     = regexp.MustCompile(`(^|[^\w*])\*([^\s*](?:[^*]*[^\s*])?)\*([^\w*]|$)`)
+	mdStrikeRe = regexp.MustCompile(`~~([^\s~](?:[^~]*[^\s~])?)~~`)
+)
+
+// strongMarker stands in for a bold span while single-star italics are
+// rewritten, since both end up using '*'
+const strongMarker = "\x00"
+
+// toMessageStyling rewrites composer markdown into XEP-0393 message styling:
+// **bold** and __bold__ become *bold*, *italic* becomes _italic_ and
+// ~~strike~~ becomes ~strike~. _italic_ is the same in both. Code spans and
+// ``` blocks are left as typed.
+func toMessageStyling(body string) string {
+	if !strings.ContainsAny(body, "*_~") {
+		return body
+	}
+
+	lines := strings.Split(body, "\n")
+	inPre := false
+	for i, line := range lines {
+		if strings.HasPrefix(line, "```") {
+			inPre = !inPre
+			continue
+		}
+		if inPre {
+			continue
+		}
+
+		// Odd segments are inside `code` spans
+		segments := strings.Split(line, "`")
+		if len(segments)%2 == 0 {
+			// Unbalanced backtick, style only the text before it
+			segments[len(segments)-2] += "`" + segments[len(segments)-1]
+			segments = segments[:len(segments)-1]
+		}
+		for j := 0; j < len(segments); j += 2 {
+			segments[j] = styleSpan(segments[j])
+		}
+		lines[i] = strings.Join(segments, "`")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// styleSpan converts the markdown in text outside of code spans
+func styleSpan(text string) string {
+	text = mdStrongRe.ReplaceAllStringFunc(text, func(m string) string {
+		sub := mdStrongRe.FindStringSubmatch(m)
+		if sub[1] != sub[3] {
+			return m
+		}
+		return strongMarker + sub[2] + strongMarker
+	})
+	// Run twice so adjacent spans that share a boundary character both match
+	for i := 0; i < 2; i++ {
+		text = mdEmRe.ReplaceAllString(text, "${1}_${2}_${3}")
+	}
+	text = mdStrikeRe.ReplaceAllString(text, "~${1}~")
+	return strings.ReplaceAll(text, strongMarker, "*")
+}
@@ -0,0 +1,46 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/meszmate/roster/internal/client"
+)
+
+// XMPPURI is an xmpp: URI from the command line or a message, such as
+// xmpp:room@conference.example.com?join
+type XMPPURI struct {
+	JID    string
+	Action string // message, join, roster, subscribe or empty
+	Params map[string]string
+}
+
+// ParseXMPPURI parses an xmpp: URI
+func ParseXMPPURI(raw string) (XMPPURI, error) {
+	u, err := client.ParseURI(raw)
+	if err != nil {
+		return XMPPURI{}, err
+	}
+	return XMPPURI{JID: u.JID, Action: u.Action, Params: u.Params}, nil
+}
+
+// FindXMPPURI returns the first xmpp: URI in a message body, or ""
+func FindXMPPURI(body string) string {
+	return client.FindURI(body)
+}
+
+// RoomInviteURI returns a shareable link that joins a room
+func RoomInviteURI(roomJID string) string {
+	return client.JoinURI(roomJID).String()
+}
+
+// OwnInviteURI returns a shareable link that adds the current account as a
+// contact
+func (a *App) OwnInviteURI() (string, error) {
+	own := a.CurrentAccount()
+	if own == "" {
+		return "", fmt.Errorf("no account selected")
+	}
+	bare, _, _ := strings.Cut(own, "/")
+	return client.RosterURI(bare, "").String(), nil
+}
@@ -0,0 +1,154 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	uriHandlerDesktopFile = "roster-uri-handler.desktop"
+	uriHandlerBundleName  = "Roster URI Handler.app"
+	uriHandlerBundleID    = "im.roster.urihandler"
+)
+
+// RegisterURIHandler makes roster the system handler for xmpp: links. Links
+// opened while roster runs are delivered to it over the IPC socket; otherwise
+// roster starts in a terminal with the link. It returns a note describing
+// what was registered.
+func RegisterURIHandler() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate roster: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd", "netbsd":
+		return registerDesktopHandler(exe)
+	case "darwin":
+		return registerMacHandler(exe)
+	default:
+		return "", fmt.Errorf("registering a URI handler is not supported on %s", runtime.GOOS)
+	}
+}
+
+// registerDesktopHandler installs a .desktop entry for x-scheme-handler/xmpp
+// and makes it the default with xdg-mime
+func registerDesktopHandler(exe string) (string, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	dir := filepath.Join(dataHome, "applications")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	entry := strings.Join([]string{
+		"[Desktop Entry]",
+		"Type=Application",
+		"Name=roster",
+		"Comment=Open xmpp: links in roster",
+		"Exec=" + desktopExecArg(exe) + " %u",
+		"Terminal=true",
+		"NoDisplay=true",
+		"MimeType=x-scheme-handler/xmpp;",
+		"Categories=Network;InstantMessaging;",
+		"",
+	}, "\n")
+	path := filepath.Join(dir, uriHandlerDesktopFile)
+	if err := os.WriteFile(path, []byte(entry), 0644); err != nil {
+		return "", err
+	}
+
+	if out, err := exec.Command("xdg-mime", "default", uriHandlerDesktopFile, "x-scheme-handler/xmpp").CombinedOutput(); err != nil {
+		return "", fmt.Errorf("wrote %s but xdg-mime failed: %v %s", path, err, strings.TrimSpace(string(out)))
+	}
+	// Refreshes the MIME cache; not every desktop needs or ships it
+	_ = exec.Command("update-desktop-database", dir).Run()
+
+	return fmt.Sprintf("Registered %s as the xmpp: handler", path), nil
+}
+
+// desktopExecArg quotes an argument for the Exec key of a .desktop entry
+func desktopExecArg(s string) string {
+	if !strings.ContainsAny(s, " \t\n\"'\\><~|&;$*?#()`=%") {
+		return s
+	}
+	r := strings.NewReplacer(`\`, `\\\\`, `"`, `\\"`, "`", "\\\\`", `$`, `\\$`, `%`, `%%`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// registerMacHandler builds a small AppleScript application that claims the
+// xmpp scheme. It hands links to the running roster and otherwise opens
+// roster in Terminal.
+func registerMacHandler(exe string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(home, "Applications")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	bundle := filepath.Join(dir, uriHandlerBundleName)
+
+	script := strings.Join([]string{
+		"on open location theURL",
+		"\tset roster to " + appleScriptString(exe),
+		"\ttry",
+		"\t\tdo shell script quoted form of roster & \" --deliver \" & quoted form of theURL",
+		"\ton error",
+		"\t\ttell application \"Terminal\"",
+		"\t\t\tactivate",
+		"\t\t\tdo script quoted form of roster & \" \" & quoted form of theURL",
+		"\t\tend tell",
+		"\tend try",
+		"end open location",
+		"",
+	}, "\n")
+	src, err := os.CreateTemp("", "roster-uri-*.applescript")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(src.Name())
+	if _, err := src.WriteString(script); err != nil {
+		src.Close()
+		return "", err
+	}
+	src.Close()
+
+	_ = os.RemoveAll(bundle)
+	plist := filepath.Join(bundle, "Contents", "Info.plist")
+	urlTypes := `[{"CFBundleURLName":"XMPP link","CFBundleURLSchemes":["xmpp"]}]`
+	steps := [][]string{
+		{"osacompile", "-o", bundle, src.Name()},
+		{"plutil", "-replace", "CFBundleIdentifier", "-string", uriHandlerBundleID, plist},
+		{"plutil", "-replace", "CFBundleURLTypes", "-json", urlTypes, plist},
+		// Editing Info.plist breaks the ad-hoc signature osacompile made
+		{"codesign", "--force", "--sign", "-", bundle},
+		{"/System/Library/Frameworks/CoreServices.framework/Frameworks/LaunchServices.framework/Support/lsregister", "-f", bundle},
+	}
+	for _, step := range steps {
+		if out, err := exec.Command(step[0], step[1:]...).CombinedOutput(); err != nil {
+			return "", fmt.Errorf("%s failed: %v %s", filepath.Base(step[0]), err, strings.TrimSpace(string(out)))
+		}
+	}
+
+	return fmt.Sprintf("Registered %s as the xmpp: handler", bundle), nil
+}
+
+// appleScriptString quotes s as an AppleScript string literal
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,186 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/meszmate/roster/internal/client"
+	"github.com/meszmate/roster/internal/ui/components/chat"
+	"github.com/meszmate/xmpp-go/jid"
+)
+
+// In moderated rooms visitors cannot speak. Our own role comes with the
+// room's presence about us; while it is visitor, sending to the room is
+// stopped here and shown as failed, with :voice to ask the moderators.
+// When we are a moderator the room forwards visitors' requests, which are
+// queued for the UI to grant or deny.
+
+// RoleVisitor is the room role without voice
+const RoleVisitor = "visitor"
+
+// VoiceRequest is a visitor's request for voice waiting for our answer as
+// a moderator
+type VoiceRequest struct {
+	AccountJID string
+	Room       string
+	JID        string // the visitor's real JID, if the room shows it
+	Nick       string
+}
+
+// Who is the visitor as shown to the user
+func (r VoiceRequest) Who() string {
+	if r.JID != "" {
+		return r.Nick + " (" + r.JID + ")"
+	}
+	return r.Nick
+}
+
+// handleRoomPresence records our role in a room from its presence about us
+// and tells when voice was granted or taken away
+func (a *App) handleRoomPresence(accountJID string, p client.Presence) {
+	if p.Room == nil {
+		return
+	}
+	room := p.From.Bare().String()
+	key := historyKey(accountJID, room)
+	a.mu.Lock()
+	if !p.Room.Self && (a.roomNicks[key] == "" || p.From.Resource() != a.roomNicks[key]) {
+		a.mu.Unlock()
+		return
+	}
+	role := p.Room.Role
+	if p.Type == "unavailable" {
+		role = ""
+	}
+	if a.roomRoles == nil {
+		a.roomRoles = make(map[string]string)
+	}
+	old := a.roomRoles[key]
+	if role == "" {
+		delete(a.roomRoles, key)
+	} else {
+		a.roomRoles[key] = role
+	}
+	a.mu.Unlock()
+
+	var note string
+	switch {
+	case role == RoleVisitor && old != RoleVisitor:
+		note = "You are a visitor in this moderated room and cannot send messages. :voice asks the moderators for voice."
+	case old == RoleVisitor && role != RoleVisitor && role != "":
+		note = "You were given voice in this room."
+	}
+	if note != "" {
+		a.addRoomNote(accountJID, room, note)
+	}
+}
+
+// VoiceBlocked reports whether we are a visitor in a room, who cannot send
+func (a *App) VoiceBlocked(accountJID, roomJID string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.roomRoles[historyKey(accountJID, roomJID)] == RoleVisitor
+}
+
+// BlockSendWithoutVoice shows a message we could not send to a room for
+// lack of voice as failed. It is not sent, stored or queued.
+func (a *App) BlockSendWithoutVoice(accountJID, roomJID, body string) {
+	now := time.Now()
+	msg := chat.Message{
+		ID:        fmt.Sprintf("novoice-%d", now.UnixNano()),
+		From:      accountJID,
+		To:        roomJID,
+		Body:      body,
+		Timestamp: now,
+		Outgoing:  true,
+		Status:    chat.MessageStatus(StatusFailed),
+	}
+	a.mu.Lock()
+	a.appendHistoryLocked(historyKey(accountJID, roomJID), msg)
+	a.mu.Unlock()
+	a.sendEvent(EventMsg{Type: EventMessage, Data: ChatMessage{
+		AccountJID: accountJID,
+		ID:         msg.ID,
+		From:       msg.From,
+		To:         msg.To,
+		Body:       msg.Body,
+		Timestamp:  msg.Timestamp,
+		Outgoing:   true,
+		Status:     StatusFailed,
+	}})
+}
+
+// RequestVoice asks the moderators of a room for voice
+func (a *App) RequestVoice(accountJID, roomJID string) error {
+	if !a.VoiceBlocked(accountJID, roomJID) {
+		return fmt.Errorf("you can already speak in %s", roomJID)
+	}
+	c := a.getConnectedClient(accountJID)
+	if c == nil {
+		return fmt.Errorf("not connected")
+	}
+	if err := c.RequestVoice(roomJID); err != nil {
+		return err
+	}
+	a.addRoomNote(accountJID, roomJID, "Voice requested, waiting for a moderator.")
+	return nil
+}
+
+// handleVoiceRequest queues a visitor's request for voice for the UI
+func (a *App) handleVoiceRequest(accountJID string, req client.VoiceRequest) {
+	r := VoiceRequest{AccountJID: accountJID, Room: req.Room.String(), JID: req.JID, Nick: req.Nick}
+	a.mu.Lock()
+	for _, pending := range a.voiceRequests {
+		if pending == r {
+			a.mu.Unlock()
+			return
+		}
+	}
+	a.voiceRequests = append(a.voiceRequests, r)
+	a.mu.Unlock()
+	a.sendEvent(EventMsg{Type: EventVoiceRequest, Data: r})
+}
+
+// PendingVoiceRequests returns the voice requests not answered yet, oldest
+// first
+func (a *App) PendingVoiceRequests() []VoiceRequest {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return append([]VoiceRequest(nil), a.voiceRequests...)
+}
+
+// AnswerVoiceRequest grants or denies a pending voice request
+func (a *App) AnswerVoiceRequest(req VoiceRequest, allow bool) error {
+	c := a.getConnectedClient(req.AccountJID)
+	if c == nil {
+		return fmt.Errorf("not connected")
+	}
+	room, err := jid.Parse(req.Room)
+	if err != nil {
+		return err
+	}
+	if err := c.AnswerVoiceRequest(client.VoiceRequest{Room: room, JID: req.JID, Nick: req.Nick}, allow); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i, pending := range a.voiceRequests {
+		if pending == req {
+			a.voiceRequests = append(a.voiceRequests[:i], a.voiceRequests[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// addRoomNote puts a system line about voice into a room's conversation
+func (a *App) addRoomNote(accountJID, roomJID, body string) {
+	a.AddChatMessageForAccount(accountJID, roomJID, chat.Message{
+		ID:        fmt.Sprintf("voice-%d", time.Now().UnixNano()),
+		From:      roomJID,
+		Body:      body,
+		Timestamp: time.Now(),
+		Type:      "system",
+	})
+}
@@ -0,0 +1,107 @@
+package client
+
+import (
+	"encoding/xml"
+	"fmt"
+	"time"
+
+	mamplugin "github.com/meszmate/xmpp-go/plugins/mam"
+	"github.com/meszmate/xmpp-go/stanza"
+)
+
+// Server archiving defaults (XEP-0441): which messages the server keeps in
+// the account's archive
+const (
+	ArchiveAlways = "always" // every message
+	ArchiveRoster = "roster" // messages with contacts in the roster
+	ArchiveNever  = "never"  // none
+)
+
+// archivePrefsTimeout bounds how long the archive preferences are waited for
+const archivePrefsTimeout = 15 * time.Second
+
+// ArchivePrefs are the archiving preferences of an account: the default
+// and the JIDs always or never archived regardless of it
+type ArchivePrefs struct {
+	Default string
+	Always  []string
+	Never   []string
+}
+
+// ValidArchiveDefault reports whether mode is a known archiving default
+func ValidArchiveDefault(mode string) bool {
+	return mode == ArchiveAlways || mode == ArchiveRoster || mode == ArchiveNever
+}
+
+// GetArchivePrefs asks the server how it archives the account's messages
+func (c *Client) GetArchivePrefs() (ArchivePrefs, error) {
+	// An empty prefs element, the struct would send an empty default
+	reply, err := c.archivePrefsRequest(stanza.IQGet, []byte(`<prefs xmlns="urn:xmpp:mam:2"/>`))
+	if err != nil {
+		return ArchivePrefs{}, err
+	}
+	return parseArchivePrefs(reply.Query)
+}
+
+// SetArchivePrefs changes how the server archives the account's messages
+// and returns the preferences it applied, which may differ
+func (c *Client) SetArchivePrefs(prefs ArchivePrefs) (ArchivePrefs, error) {
+	if !ValidArchiveDefault(prefs.Default) {
+		return ArchivePrefs{}, fmt.Errorf("unknown archiving default %q", prefs.Default)
+	}
+	query, _ := xml.Marshal(archivePrefsPayload(prefs))
+	reply, err := c.archivePrefsRequest(stanza.IQSet, query)
+	if err != nil {
+		return ArchivePrefs{}, err
+	}
+	if len(reply.Query) == 0 {
+		// Some servers confirm without repeating the preferences
+		return prefs, nil
+	}
+	return parseArchivePrefs(reply.Query)
+}
+
+// archivePrefsRequest sends a prefs get or set to the account's own archive
+func (c *Client) archivePrefsRequest(typ string, query []byte) (*stanza.IQ, error) {
+	c.mu.RLock()
+	if !c.connected {
+		c.mu.RUnlock()
+		return nil, fmt.Errorf("not connected")
+	}
+	session := c.session
+	c.mu.RUnlock()
+
+	iq := stanza.NewIQ(typ)
+	iq.To = c.jid.Bare()
+	iq.Query = query
+	return c.doIQ(c.ctx, session, iq, iqOptions{Timeout: archivePrefsTimeout})
+}
+
+// archivePrefsPayload is the prefs element setting prefs. Both lists are
+// sent, empty or not, as the server replaces its lists with them.
+func archivePrefsPayload(prefs ArchivePrefs) mamplugin.Prefs {
+	return mamplugin.Prefs{
+		Default: prefs.Default,
+		Always:  &mamplugin.JIDList{JIDs: prefs.Always},
+		Never:   &mamplugin.JIDList{JIDs: prefs.Never},
+	}
+}
+
+// parseArchivePrefs reads the prefs element of a reply
+func parseArchivePrefs(payload []byte) (ArchivePrefs, error) {
+	var reply mamplugin.Prefs
+	if err := xml.Unmarshal(payload, &reply); err != nil {
+		return ArchivePrefs{}, fmt.Errorf("unreadable archive preferences: %w", err)
+	}
+	if !ValidArchiveDefault(reply.Default) {
+		return ArchivePrefs{}, fmt.Errorf("unknown archiving default %q", reply.Default)
+	}
+	prefs := ArchivePrefs{Default: reply.Default}
+	if reply.Always != nil {
+		prefs.Always = reply.Always.JIDs
+	}
+	if reply.Never != nil {
+		prefs.Never = reply.Never.JIDs
+	}
+	return prefs, nil
+}
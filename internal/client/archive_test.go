@@ -0,0 +1,44 @@
+package client
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestParseArchivePrefs(t *testing.T) {
+	payload := []byte(`<prefs xmlns="urn:xmpp:mam:2" default="roster">
+		<always><jid>romeo@montague.lit</jid></always>
+		<never><jid>montague@montague.lit</jid></never>
+	</prefs>`)
+	prefs, err := parseArchivePrefs(payload)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if prefs.Default != ArchiveRoster || len(prefs.Always) != 1 || prefs.Always[0] != "romeo@montague.lit" ||
+		len(prefs.Never) != 1 || prefs.Never[0] != "montague@montague.lit" {
+		t.Fatalf("unexpected prefs %+v", prefs)
+	}
+
+	if _, err := parseArchivePrefs([]byte(`<prefs xmlns="urn:xmpp:mam:2" default="sometimes"/>`)); err == nil {
+		t.Fatal("expected an unknown default to be refused")
+	}
+}
+
+func TestArchivePrefsPayload(t *testing.T) {
+	out, err := xml.Marshal(archivePrefsPayload(ArchivePrefs{Default: ArchiveNever, Always: []string{"juliet@capulet.lit"}}))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	got := string(out)
+	for _, want := range []string{
+		`xmlns="urn:xmpp:mam:2"`,
+		`default="never"`,
+		`<always><jid>juliet@capulet.lit</jid></always>`,
+		`<never></never>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %s in %s", want, got)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package client
+
+import (
+	"encoding/xml"
+	"fmt"
+
+	"github.com/meszmate/xmpp-go/jid"
+	"github.com/meszmate/xmpp-go/plugins/jingle"
+	"github.com/meszmate/xmpp-go/stanza"
+)
+
+// nsJingleMessage is the XEP-0353 Jingle Message Initiation namespace
+const nsJingleMessage = "urn:xmpp:jingle-message:0"
+
+// Call signalling states reported to the call handler
+const (
+	CallProposed  = "propose" // the contact is ringing us
+	CallRetracted = "retract" // the contact hung up before anyone answered
+	CallAccepted  = "accept"  // one of our other devices picked up
+	CallRejected  = "reject"  // the call was declined, possibly on another device
+	CallProceed   = "proceed" // one of our other devices is setting up the session
+)
+
+// CallEvent describes a voice/video call signal. Calls cannot be answered
+// here; the event lets the UI tell the user and offer a decline.
+type CallEvent struct {
+	From  jid.JID
+	ID    string
+	Media []string
+	State string
+}
+
+type jingleMessage struct {
+	ID           string `xml:"id,attr"`
+	Descriptions []struct {
+		Media string `xml:"media,attr"`
+	} `xml:"description"`
+}
+
+type rtpContents struct {
+	Contents []struct {
+		Description struct {
+			XMLName xml.Name
+			Media   string `xml:"media,attr"`
+		} `xml:"description"`
+	} `xml:"content"`
+}
+
+// callFromMessage extracts a XEP-0353 call signal from a message
+func callFromMessage(msg *stanza.Message) (CallEvent, bool) {
+	for _, ext := range msg.Extensions {
+		if ext.XMLName.Space != nsJingleMessage {
+			continue
+		}
+		switch ext.XMLName.Local {
+		case CallProposed, CallRetracted, CallAccepted, CallRejected, CallProceed:
+		default:
+			continue
+		}
+
+		extXML, err := extensionOuterXML(ext)
+		if err != nil {
+			continue
+		}
+		var jm jingleMessage
+		if err := xml.Unmarshal(extXML, &jm); err != nil || jm.ID == "" {
+			continue
+		}
+
+		call := CallEvent{From: msg.From, ID: jm.ID, State: ext.XMLName.Local}
+		for _, d := range jm.Descriptions {
+			if d.Media != "" {
+				call.Media = append(call.Media, d.Media)
+			}
+		}
+		return call, true
+	}
+	return CallEvent{}, false
+}
+
+// rtpMedia returns the RTP media of a Jingle session-initiate, if it is a call
+func rtpMedia(raw []byte) []string {
+	var parsed rtpContents
+	if err := xml.Unmarshal(raw, &parsed); err != nil {
+		return nil
+	}
+	var media []string
+	for _, content := range parsed.Contents {
+		if content.Description.XMLName.Space == "urn:xmpp:jingle:apps:rtp:1" && content.Description.Media != "" {
+			media = append(media, content.Description.Media)
+		}
+	}
+	return media
+}
+
+// handleIncomingCall acknowledges Jingle call sessions started by a contact
+// and reports them. It reports whether the IQ was consumed.
+func (c *Client) handleIncomingCall(iq *stanza.IQ, j *jingle.Jingle) bool {
+	c.mu.Lock()
+	_, known := c.incomingCalls[j.SID]
+	switch {
+	case j.Action == jingle.ActionSessionInitiate && !known:
+		media := rtpMedia(iq.Query)
+		if len(media) == 0 {
+			c.mu.Unlock()
+			return false
+		}
+		if c.incomingCalls == nil {
+			c.incomingCalls = make(map[string]jid.JID)
+		}
+		c.incomingCalls[j.SID] = iq.From
+		c.mu.Unlock()
+		c.ackIQ(iq)
+		c.emitCall(CallEvent{From: iq.From, ID: j.SID, Media: media, State: CallProposed})
+		return true
+	case j.Action == jingle.ActionSessionTerminate && known:
+		delete(c.incomingCalls, j.SID)
+		c.mu.Unlock()
+		c.ackIQ(iq)
+		c.emitCall(CallEvent{From: iq.From, ID: j.SID, State: CallRetracted})
+		return true
+	case known:
+		c.mu.Unlock()
+		c.ackIQ(iq)
+		return true
+	}
+	c.mu.Unlock()
+	return false
+}
+
+func (c *Client) ackIQ(iq *stanza.IQ) {
+	c.mu.RLock()
+	session := c.session
+	c.mu.RUnlock()
+	if session != nil {
+		_ = c.sendAsync(session, iq.ResultIQ(), sendOptions{Priority: priorityIQ})
+	}
+}
+
+func (c *Client) emitCall(call CallEvent) {
+	if c.onCall != nil {
+		c.onCall(call)
+	}
+}
+
+// DeclineCall rejects an incoming call, either a Jingle session or a
+// XEP-0353 proposal sent to the given full JID
+func (c *Client) DeclineCall(to, id string) error {
+	c.mu.Lock()
+	if !c.connected {
+		c.mu.Unlock()
+		return fmt.Errorf("not connected")
+	}
+	session := c.session
+	peer, isSession := c.incomingCalls[id]
+	delete(c.incomingCalls, id)
+	c.mu.Unlock()
+
+	if isSession {
+		c.terminateJingle(peer, id, "<decline/>")
+		return nil
+	}
+
+	toJID, err := jid.Parse(to)
+	if err != nil {
+		return fmt.Errorf("invalid JID: %w", err)
+	}
+
+	msg := stanza.NewMessage(stanza.MessageChat)
+	msg.To = toJID
+	msg.Extensions = append(msg.Extensions,
+		stanza.Extension{
+			XMLName: xml.Name{Space: nsJingleMessage, Local: CallRejected},
+			Attrs:   []xml.Attr{{Name: xml.Name{Local: "id"}, Value: id}},
+		},
+		stanza.Extension{XMLName: xml.Name{Space: "urn:xmpp:hints", Local: "store"}},
+	)
+
+	return c.sendQueued(c.ctx, session, msg, sendOptions{Priority: priorityMessage})
+}
@@ -3,11 +3,14 @@ package client
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/tls"
-	"encoding/base64"
+	"database/sql"
 	"encoding/hex"
+	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"net"
 	"strconv"
@@ -19,6 +22,7 @@ import (
 	"github.com/meszmate/xmpp-go/dial"
 	"github.com/meszmate/xmpp-go/jid"
 	"github.com/meszmate/xmpp-go/plugin"
+	"github.com/meszmate/xmpp-go/plugins/blocking"
 	"github.com/meszmate/xmpp-go/plugins/bookmarks"
 	"github.com/meszmate/xmpp-go/plugins/caps"
 	"github.com/meszmate/xmpp-go/plugins/carbons"
@@ -28,14 +32,17 @@ import (
 	"github.com/meszmate/xmpp-go/plugins/disco"
 	"github.com/meszmate/xmpp-go/plugins/form"
 	forwardplugin "github.com/meszmate/xmpp-go/plugins/forward"
+	"github.com/meszmate/xmpp-go/plugins/jingle"
 	mamplugin "github.com/meszmate/xmpp-go/plugins/mam"
 	"github.com/meszmate/xmpp-go/plugins/muc"
 	omemoplugin "github.com/meszmate/xmpp-go/plugins/omemo"
+	"github.com/meszmate/xmpp-go/plugins/oob"
 	"github.com/meszmate/xmpp-go/plugins/ping"
 	"github.com/meszmate/xmpp-go/plugins/presence"
 	"github.com/meszmate/xmpp-go/plugins/reactions"
 	"github.com/meszmate/xmpp-go/plugins/receipts"
 	"github.com/meszmate/xmpp-go/plugins/roster"
+	"github.com/meszmate/xmpp-go/plugins/stanzaid"
 	"github.com/meszmate/xmpp-go/plugins/upload"
 	"github.com/meszmate/xmpp-go/stanza"
 	"github.com/meszmate/xmpp-go/storage"
@@ -60,18 +67,50 @@ type Client struct {
 	plugins      *plugin.Manager
 	omemoManager *cryptoomemo.Manager
 	omemoStore   *OMEMOStore
+	omemoDB      *sql.DB
 	deviceID     uint32
 
-	onMessage    func(msg Message)
-	onPresence   func(p Presence)
-	onRoster     func(items []RosterItem)
-	onConnect    func()
-	onDisconnect func(err error)
-	onError      func(err error)
-	onReceipt    func(messageID string, status string)
+	onMessage     func(msg Message)
+	onPresence    func(p Presence)
+	onRoster      func(items []RosterItem)
+	onConnect     func()
+	onConnectStep func(step string)
+	onDisconnect  func(err error)
+	onError       func(err error)
+	onReceipt     func(messageID string, status string)
+	onReadOnOther func(peer jid.JID, messageID string)
+	onChatState   func(from jid.JID, state string)
+	onOMEMODevice func(contactJID string, deviceID uint32, identityKey []byte, changed bool)
+	onCall        func(call CallEvent)
+
+	onVersionQuery func() *SoftwareVersion      // see version.go
+	onLastActivity func(jid.JID) *time.Duration // see lastactivity.go
+	onVoiceRequest func(req VoiceRequest)       // see voice.go
+
+	traffic *TrafficStats // see stats.go
+
+	keepAliveInterval time.Duration
+	pingInterval      time.Duration
+	pingTimeout       time.Duration
 
 	pendingIQs map[string]chan *stanza.IQ
 
+	tlsState *tls.ConnectionState // of the current connection, see tlsinfo.go
+
+	// Outgoing stanzas of the current session, see sendqueue.go
+	sendMu      sync.Mutex
+	sendQueue   *sendQueue
+	sendSession *xmp.Session
+
+	// Direct file transfer state
+	uploadService  string
+	uploadChecked  bool
+	jingleSessions map[string]chan *jingle.Jingle
+	incomingCalls  map[string]jid.JID
+
+	// Every online resource of each contact, see resources.go
+	onlineResources map[string]map[string]Resource
+
 	ctx    context.Context
 	cancel context.CancelFunc
 }
@@ -87,6 +126,11 @@ type streamFeatures struct {
 	StartTLS   *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-tls starttls"`
 	Mechanisms []string  `xml:"urn:ietf:params:xml:ns:xmpp-sasl mechanisms>mechanism"`
 	Bind       *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-bind bind"`
+
+	// ChannelBindings are the types the server can bind SASL to (XEP-0440)
+	ChannelBindings []struct {
+		Type string `xml:"type,attr"`
+	} `xml:"urn:xmpp:sasl-cb:0 sasl-channel-binding>channel-binding"`
 }
 
 type startTLSRequest struct {
@@ -99,11 +143,6 @@ type saslAuth struct {
 	Value     string   `xml:",chardata"`
 }
 
-type saslFailure struct {
-	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:xmpp-sasl failure"`
-	Text    string   `xml:"text"`
-}
-
 func sanitizeEmptyJIDAttrs(start *xml.StartElement) {
 	if start == nil || len(start.Attr) == 0 {
 		return
@@ -128,23 +167,8 @@ func parseIQErrorDetails(resp *stanza.IQ) string {
 	if resp.Error != nil && resp.Error.Type != "" {
 		parts = append(parts, "type="+resp.Error.Type)
 	}
-
-	raw := strings.ToLower(string(resp.Query))
-	conditions := []string{
-		"not-authorized",
-		"service-unavailable",
-		"feature-not-implemented",
-		"resource-constraint",
-		"conflict",
-		"bad-request",
-		"not-acceptable",
-		"jid-malformed",
-	}
-	for _, cond := range conditions {
-		if strings.Contains(raw, cond) {
-			parts = append(parts, "condition="+cond)
-			break
-		}
+	if cond := iqErrorCondition(resp); cond != "" {
+		parts = append(parts, "condition="+string(cond))
 	}
 
 	if len(parts) == 0 {
@@ -153,6 +177,40 @@ func parseIQErrorDetails(resp *stanza.IQ) string {
 	return strings.Join(parts, ", ")
 }
 
+// iqErrorCondition returns the condition of an error reply. The stanza
+// package does not decode it, so it is read from the raw payload.
+func iqErrorCondition(resp *stanza.IQ) Condition {
+	if resp == nil {
+		return ""
+	}
+	if resp.Error != nil && resp.Error.Condition != "" {
+		return Condition(resp.Error.Condition)
+	}
+	dec := xml.NewDecoder(bytes.NewReader(resp.Query))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return ""
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Local != "error" {
+			// Only the error element among the payloads counts
+			if err := dec.Skip(); err != nil {
+				return ""
+			}
+			continue
+		}
+		var body errorElement
+		if err := dec.DecodeElement(&body, &start); err != nil {
+			return ""
+		}
+		return body.condition()
+	}
+}
+
 func (c *Client) getRosterPlugin() (*roster.Plugin, error) {
 	if c.plugins == nil {
 		return nil, fmt.Errorf("plugin manager not initialized")
@@ -253,8 +311,27 @@ type Message struct {
 	Thread           string
 	Encrypted        bool
 	ReceiptRequested bool
+	Markable         bool // the sender wants to know when it is read (XEP-0333)
 	CorrectedID      string
 	Reactions        map[string][]string
+	JSON             string // XEP-0335 JSON container payload
+	FileURL          string // XEP-0066 out-of-band URL of a shared file
+	OriginID         string // XEP-0359 origin-id the sender gave the message
+	Subject          string // a room's subject, set by a groupchat message
+}
+
+// sidNS is the XEP-0359 unique and stable stanza IDs namespace
+const sidNS = "urn:xmpp:sid:0"
+
+// oobNS is the XEP-0066 out-of-band data namespace for messages
+const oobNS = "jabber:x:oob"
+
+// jsonContainerNS is the XEP-0335 JSON container namespace
+const jsonContainerNS = "urn:xmpp:json:0"
+
+type jsonContainer struct {
+	XMLName xml.Name `xml:"urn:xmpp:json:0 json"`
+	Data    string   `xml:",chardata"`
 }
 
 type Presence struct {
@@ -264,6 +341,7 @@ type Presence struct {
 	Show     string
 	Status   string
 	Priority int
+	Room     *RoomPresence // set for presence from a room occupant
 }
 
 type RosterItem struct {
@@ -282,6 +360,20 @@ type ClientConfig struct {
 	Priority int
 	DeviceID uint32
 	DataDir  string
+
+	// OMEMODB keeps the OMEMO keys, sessions and trust across runs, so the
+	// device stays the same; its tables come with the app's database. Nil
+	// keeps them in memory for the connection.
+	OMEMODB *sql.DB
+
+	// Keep-alive settings: zero selects the default, negative disables.
+	KeepAliveInterval time.Duration // whitespace keepalive
+	PingInterval      time.Duration // XEP-0199 server ping
+	PingTimeout       time.Duration
+
+	// Traffic collects the connection's counts, see stats.go. Nil gives the
+	// client counters of its own.
+	Traffic *TrafficStats
 }
 
 func NewClient(cfg ClientConfig) (*Client, error) {
@@ -305,12 +397,20 @@ func NewClient(cfg ClientConfig) (*Client, error) {
 	}
 
 	deviceID := cfg.DeviceID
+	if deviceID == 0 && cfg.OMEMODB != nil {
+		deviceID = StoredDeviceID(cfg.OMEMODB, parsedJID.Bare().String())
+	}
 	if deviceID == 0 {
 		b := make([]byte, 4)
 		_, _ = rand.Read(b)
 		deviceID = uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
 	}
 
+	traffic := cfg.Traffic
+	if traffic == nil {
+		traffic = &TrafficStats{}
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	return &Client{
@@ -320,13 +420,27 @@ func NewClient(cfg ClientConfig) (*Client, error) {
 		port:       cfg.Port,
 		resource:   resource,
 		deviceID:   deviceID,
+		omemoDB:    cfg.OMEMODB,
 		pendingIQs: make(map[string]chan *stanza.IQ),
 		ctx:        ctx,
 		cancel:     cancel,
+
+		keepAliveInterval: keepAliveDuration(cfg.KeepAliveInterval, defaultKeepAliveInterval),
+		pingInterval:      keepAliveDuration(cfg.PingInterval, defaultPingInterval),
+		pingTimeout:       keepAliveDuration(cfg.PingTimeout, defaultPingTimeout),
+		traffic:           traffic,
 	}, nil
 }
 
+// Connect connects without a caller deadline; see ConnectContext
 func (c *Client) Connect() error {
+	return c.ConnectContext(context.Background())
+}
+
+// ConnectContext dials the server and negotiates the session. Dialing, TLS,
+// authentication and resource binding each have their own timeout, and
+// cancelling ctx aborts whichever of them is in progress.
+func (c *Client) ConnectContext(ctx context.Context) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -334,6 +448,19 @@ func (c *Client) Connect() error {
 		return nil
 	}
 
+	ctx, cancel := c.clientContext(ctx)
+	defer cancel()
+
+	err := c.connect(ctx)
+	if err != nil && ctx.Err() == context.Canceled {
+		return fmt.Errorf("%w: %w", ErrConnectCancelled, err)
+	}
+	return err
+}
+
+func (c *Client) connect(ctx context.Context) error {
+	c.connectStep("dialing")
+
 	dialer := dial.NewDialer()
 	dialer.TLSConfig = &tls.Config{
 		ServerName: c.jid.Domain(),
@@ -353,25 +480,41 @@ func (c *Client) Connect() error {
 			port = 5222
 		}
 		addr := net.JoinHostPort(host, strconv.Itoa(port))
-		conn, err := (&net.Dialer{Timeout: 30 * time.Second}).DialContext(c.ctx, "tcp", addr)
+		dialCtx, cancelDial := context.WithTimeout(ctx, dialTimeout)
+		conn, err := (&net.Dialer{}).DialContext(dialCtx, "tcp", addr)
+		cancelDial()
 		if err != nil {
 			return fmt.Errorf("failed to dial server %s: %w", addr, err)
 		}
 		trans = transport.NewTCP(conn)
 	} else {
 		var err error
-		trans, err = dialer.Dial(c.ctx, c.jid.Domain())
+		dialCtx, cancelDial := context.WithTimeout(ctx, dialTimeout)
+		trans, err = dialer.Dial(dialCtx, c.jid.Domain())
+		cancelDial()
 		if err != nil {
 			return fmt.Errorf("failed to dial server: %w", err)
 		}
 	}
 
-	c.omemoStore = NewOMEMOStore(c.jid.String(), c.deviceID)
+	if c.omemoDB != nil {
+		c.omemoStore = NewOMEMOStoreWithDB(c.jid.Bare().String(), c.deviceID, c.omemoDB)
+	} else {
+		c.omemoStore = NewOMEMOStore(c.jid.String(), c.deviceID)
+	}
+	c.omemoStore.SetIdentityHandler(func(addr cryptoomemo.Address, key ed25519.PublicKey, changed bool) {
+		if c.onOMEMODevice != nil {
+			c.onOMEMODevice(addr.JID, addr.DeviceID, []byte(key), changed)
+		}
+	})
 	c.omemoManager = cryptoomemo.NewManager(c.omemoStore)
 
-	if _, err := c.omemoManager.GenerateBundle(100); err != nil {
-		trans.Close()
-		return fmt.Errorf("failed to generate OMEMO bundle: %w", err)
+	// Stored keys are kept: new ones would make contacts see a new device
+	if !c.omemoStore.hasKeys() {
+		if _, err := c.omemoManager.GenerateBundle(100); err != nil {
+			trans.Close()
+			return fmt.Errorf("failed to generate OMEMO bundle: %w", err)
+		}
 	}
 
 	c.plugins = plugin.NewManager()
@@ -405,7 +548,7 @@ func (c *Client) Connect() error {
 		xmp.WithLocalAddr(c.jid),
 	}
 
-	session, err := xmp.NewSession(c.ctx, trans, sessionOpts...)
+	session, err := xmp.NewSession(c.ctx, newLimitedTransport(newCountingTransport(trans, c.traffic), maxStanzaSize), sessionOpts...)
 	if err != nil {
 		trans.Close()
 		return fmt.Errorf("failed to create session: %w", err)
@@ -413,10 +556,14 @@ func (c *Client) Connect() error {
 
 	c.session = session
 
-	if err := c.negotiateClientSession(trans); err != nil {
+	if err := c.negotiateClientSession(ctx, trans); err != nil {
 		session.Close()
 		return fmt.Errorf("xmpp negotiation failed: %w", err)
 	}
+	c.tlsState = nil
+	if state, ok := trans.ConnectionState(); ok {
+		c.tlsState = &state
+	}
 
 	params := plugin.InitParams{
 		SendRaw: func(ctx context.Context, data []byte) error {
@@ -437,8 +584,11 @@ func (c *Client) Connect() error {
 
 	c.client = nil
 	c.connected = true
+	// Contacts announce their resources again once we are online
+	c.onlineResources = nil
 
 	go c.serve()
+	go c.keepAlive(c.session)
 	go func() {
 		_ = c.EnableCarbons()
 	}()
@@ -452,97 +602,69 @@ func (c *Client) Connect() error {
 
 func (c *Client) serve() {
 	for {
-		tok, err := c.session.Reader().Token()
+		c.resetStanzaLimit()
+		st, err := nextStanza(c.session.Reader())
+		if errors.Is(err, errTooManyExtensions) {
+			c.emitError(fmt.Errorf("dropped stanza: %w", err))
+			continue
+		}
 		if err != nil {
 			c.handleDisconnect(err)
 			return
 		}
-
-		start, ok := tok.(xml.StartElement)
-		if !ok {
-			continue
+		if st != nil {
+			c.traffic.stanzaRead()
 		}
 
-		switch start.Name.Local {
-		case "message":
-			sanitizeEmptyJIDAttrs(&start)
-			var msg stanza.Message
-			if err := c.session.Reader().DecodeElement(&msg, &start); err != nil {
-				c.handleDisconnect(err)
-				return
-			}
-			c.handleMessage(&msg)
-
-		case "presence":
-			sanitizeEmptyJIDAttrs(&start)
-			var p stanza.Presence
-			if err := c.session.Reader().DecodeElement(&p, &start); err != nil {
-				c.handleDisconnect(err)
-				return
-			}
-			c.handlePresence(&p)
-
-		case "iq":
-			sanitizeEmptyJIDAttrs(&start)
-			var iq stanza.IQ
-			if err := c.session.Reader().DecodeElement(&iq, &start); err != nil {
-				c.handleDisconnect(err)
-				return
-			}
-			c.handleIQ(&iq)
-
-		default:
-			// Ignore stream-level elements (stream root/features/proceed/success/etc).
-			if start.Name.Space == nsStream {
-				if start.Name.Local == "stream" {
-					continue
-				}
-				if err := c.session.Reader().Skip(); err != nil {
-					c.handleDisconnect(err)
-					return
-				}
-				continue
-			}
-			if err := c.session.Reader().Skip(); err != nil {
-				c.handleDisconnect(err)
-				return
-			}
+		switch st := st.(type) {
+		case *stanza.Message:
+			c.handleMessage(st)
+		case *stanza.Presence:
+			c.handlePresence(st)
+		case *stanza.IQ:
+			c.handleIQ(st)
 		}
 	}
 }
 
-func (c *Client) negotiateClientSession(trans transport.Transport) error {
-	if err := c.openStream(); err != nil {
-		return err
-	}
+func (c *Client) negotiateClientSession(ctx context.Context, trans transport.Transport) error {
+	conn := func() net.Conn { return transportConn(trans) }
 
-	features, err := c.readStreamFeatures()
+	var features *streamFeatures
+	c.connectStep("stream setup")
+	err := runPhase(ctx, conn, "stream setup", streamTimeout, func(context.Context) error {
+		var err error
+		features, err = c.restartStream()
+		return err
+	})
 	if err != nil {
 		return err
 	}
 
 	if features.StartTLS != nil {
-		if err := c.startTLS(trans); err != nil {
-			return err
-		}
-
-		if err := c.openStream(); err != nil {
+		c.connectStep("TLS")
+		err := runPhase(ctx, conn, "TLS", tlsTimeout, func(context.Context) error {
+			if err := c.startTLS(trans); err != nil {
+				return err
+			}
+			var err error
+			features, err = c.restartStream()
 			return err
-		}
-		features, err = c.readStreamFeatures()
+		})
 		if err != nil {
 			return err
 		}
 	}
 
-	if err := c.authenticatePlain(features); err != nil {
-		return err
-	}
-
-	if err := c.openStream(); err != nil {
+	c.connectStep("authentication")
+	err = runPhase(ctx, conn, "authentication", authTimeout, func(context.Context) error {
+		if err := c.authenticate(features, trans); err != nil {
+			return err
+		}
+		var err error
+		features, err = c.restartStream()
 		return err
-	}
-	features, err = c.readStreamFeatures()
+	})
 	if err != nil {
 		return err
 	}
@@ -551,7 +673,16 @@ func (c *Client) negotiateClientSession(trans transport.Transport) error {
 		return fmt.Errorf("server did not offer resource binding")
 	}
 
-	return c.bindResource()
+	c.connectStep("resource binding")
+	return runPhase(ctx, conn, "resource binding", bindTimeout, c.bindResource)
+}
+
+// restartStream opens a new stream and reads the features the server offers on it
+func (c *Client) restartStream() (*streamFeatures, error) {
+	if err := c.openStream(); err != nil {
+		return nil, err
+	}
+	return c.readStreamFeatures()
 }
 
 func (c *Client) openStream() error {
@@ -573,6 +704,7 @@ func (c *Client) openStream() error {
 
 func (c *Client) readStreamFeatures() (*streamFeatures, error) {
 	for {
+		c.resetStanzaLimit()
 		tok, err := c.session.Reader().Token()
 		if err != nil {
 			return nil, err
@@ -587,6 +719,10 @@ func (c *Client) readStreamFeatures() (*streamFeatures, error) {
 			continue
 		}
 
+		if start.Name.Space == nsStream && start.Name.Local == "error" {
+			return nil, decodeStreamError(c.session.Reader(), &start)
+		}
+
 		if start.Name.Space == nsStream && start.Name.Local == "features" {
 			var features streamFeatures
 			if err := c.session.Reader().DecodeElement(&features, &start); err != nil {
@@ -634,12 +770,12 @@ func (c *Client) startTLS(trans transport.Transport) error {
 				MinVersion: tls.VersionTLS12,
 			}
 			if err := trans.StartTLS(tlsConfig); err != nil {
-				return fmt.Errorf("starttls handshake failed: %w", err)
+				return &TLSError{Err: err}
 			}
 			return nil
 		case "failure":
 			_ = c.session.Reader().Skip()
-			return fmt.Errorf("server rejected STARTTLS")
+			return &TLSError{Rejected: true}
 		default:
 			if err := c.session.Reader().Skip(); err != nil {
 				return err
@@ -648,71 +784,7 @@ func (c *Client) startTLS(trans transport.Transport) error {
 	}
 }
 
-func (c *Client) authenticatePlain(features *streamFeatures) error {
-	hasPlain := false
-	for _, mech := range features.Mechanisms {
-		if mech == "PLAIN" {
-			hasPlain = true
-			break
-		}
-	}
-	if !hasPlain {
-		return fmt.Errorf("server does not offer SASL PLAIN")
-	}
-
-	authcid := c.jid.Local()
-	payload := "\x00" + authcid + "\x00" + c.password
-	value := base64.StdEncoding.EncodeToString([]byte(payload))
-
-	if err := c.session.SendElement(c.ctx, saslAuth{
-		Mechanism: "PLAIN",
-		Value:     value,
-	}); err != nil {
-		return fmt.Errorf("failed to send SASL auth: %w", err)
-	}
-
-	for {
-		tok, err := c.session.Reader().Token()
-		if err != nil {
-			return err
-		}
-
-		start, ok := tok.(xml.StartElement)
-		if !ok {
-			continue
-		}
-
-		if start.Name.Space != nsSASL {
-			if err := c.session.Reader().Skip(); err != nil {
-				return err
-			}
-			continue
-		}
-
-		switch start.Name.Local {
-		case "success":
-			if err := c.session.Reader().Skip(); err != nil {
-				return err
-			}
-			return nil
-		case "failure":
-			var fail saslFailure
-			if err := c.session.Reader().DecodeElement(&fail, &start); err != nil {
-				return err
-			}
-			if fail.Text != "" {
-				return fmt.Errorf("sasl authentication failed: %s", fail.Text)
-			}
-			return fmt.Errorf("sasl authentication failed")
-		default:
-			if err := c.session.Reader().Skip(); err != nil {
-				return err
-			}
-		}
-	}
-}
-
-func (c *Client) bindResource() error {
+func (c *Client) bindResource(ctx context.Context) error {
 	tryBind := func(resource string) error {
 		iq := stanza.NewIQ(stanza.IQSet)
 		queryXML, err := xml.Marshal(xmp.BindRequest{Resource: resource})
@@ -721,21 +793,15 @@ func (c *Client) bindResource() error {
 		}
 		iq.Query = queryXML
 
-		resp, err := c.sendIQAndWaitDirect(iq, 12*time.Second)
+		resp, err := c.doIQDirect(ctx, iq, iqOptions{Timeout: bindTimeout})
 		if err != nil {
 			return err
 		}
-		if resp.Type != stanza.IQResult {
-			return fmt.Errorf("bind iq failed: %s", parseIQErrorDetails(resp))
-		}
 
-		if len(resp.Query) > 0 {
-			var bindRes xmp.BindResult
-			if err := xml.Unmarshal(resp.Query, &bindRes); err == nil && bindRes.JID != "" {
-				if parsed, err := jid.Parse(bindRes.JID); err == nil {
-					c.jid = parsed
-					c.session.SetLocalAddr(parsed)
-				}
+		if bindRes, err := decodeIQ[xmp.BindResult](resp); err == nil && bindRes.JID != "" {
+			if parsed, err := jid.Parse(bindRes.JID); err == nil {
+				c.jid = parsed
+				c.session.SetLocalAddr(parsed)
 			}
 		}
 
@@ -773,22 +839,44 @@ func (c *Client) bindResource() error {
 	return fmt.Errorf("bind failed (%s)", strings.Join(errs, "; "))
 }
 
-func (c *Client) sendIQAndWaitDirect(iq *stanza.IQ, timeout time.Duration) (*stanza.IQ, error) {
-	if err := c.session.Send(c.ctx, iq); err != nil {
+// doIQDirect sends an IQ and reads the stream itself until the reply
+// arrives. It is used before the serve loop runs, when handleIQ cannot route
+// replies yet. Retries are not supported here.
+func (c *Client) doIQDirect(ctx context.Context, iq *stanza.IQ, opts iqOptions) (*stanza.IQ, error) {
+	if iq.ID == "" {
+		iq.ID = stanza.GenerateID()
+	}
+	ctx, cancel := c.clientContext(ctx)
+	defer cancel()
+
+	if err := c.session.Send(ctx, iq); err != nil {
 		return nil, err
 	}
 
-	if connGetter, ok := c.session.Transport().(interface{ Conn() net.Conn }); ok {
+	if connGetter, ok := c.session.Transport().(interface{ Conn() net.Conn }); ok && connGetter.Conn() != nil {
 		conn := connGetter.Conn()
-		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+		_ = conn.SetReadDeadline(time.Now().Add(opts.timeout()))
+		// Cancelling unblocks the pending read
+		stop := context.AfterFunc(ctx, func() {
+			_ = conn.SetReadDeadline(time.Now())
+		})
 		defer func() {
+			stop()
 			_ = conn.SetReadDeadline(time.Time{})
 		}()
 	}
 
 	for {
+		c.resetStanzaLimit()
 		tok, err := c.session.Reader().Token()
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return nil, ErrIQTimeout
+			}
 			return nil, err
 		}
 
@@ -805,7 +893,7 @@ func (c *Client) sendIQAndWaitDirect(iq *stanza.IQ, timeout time.Duration) (*sta
 				return nil, err
 			}
 			if resp.ID == iq.ID {
-				return &resp, nil
+				return checkIQResponse(&resp)
 			}
 		case "message", "presence":
 			if err := c.session.Reader().Skip(); err != nil {
@@ -882,6 +970,21 @@ func extensionHasNamespace(extXML []byte, ns string) bool {
 	return bytes.Contains(extXML, []byte(ns))
 }
 
+// chatStateFromExtensions returns the XEP-0085 chat state carried by a message, if any.
+func chatStateFromExtensions(exts []stanza.Extension) string {
+	for _, ext := range exts {
+		if ext.XMLName.Space != "http://jabber.org/protocol/chatstates" {
+			continue
+		}
+		switch ext.XMLName.Local {
+		case chatstates.StateActive, chatstates.StateComposing, chatstates.StatePaused,
+			chatstates.StateInactive, chatstates.StateGone:
+			return ext.XMLName.Local
+		}
+	}
+	return ""
+}
+
 func (c *Client) handleMessage(msg *stanza.Message) {
 	for _, ext := range msg.Extensions {
 		if ext.XMLName.Space == "urn:xmpp:mam:2" && ext.XMLName.Local == "result" {
@@ -907,6 +1010,10 @@ func (c *Client) handleMessage(msg *stanza.Message) {
 		return
 	}
 
+	// Markers we sent from another device arrive as sent carbons. They refer
+	// to the peer's messages, not ours.
+	fromSelf := !msg.From.IsZero() && msg.From.Bare().String() == c.jid.Bare().String()
+
 	handledReceipt := false
 	for _, ext := range msg.Extensions {
 		extXML, err := extensionOuterXML(ext)
@@ -920,7 +1027,7 @@ func (c *Client) handleMessage(msg *stanza.Message) {
 		case isReceiptsNS && ext.XMLName.Local == "received":
 			var received receipts.Received
 			if err := xml.Unmarshal(extXML, &received); err == nil && received.ID != "" {
-				if c.onReceipt != nil {
+				if c.onReceipt != nil && !fromSelf {
 					c.onReceipt(received.ID, "delivered")
 				}
 				handledReceipt = true
@@ -928,7 +1035,11 @@ func (c *Client) handleMessage(msg *stanza.Message) {
 		case isMarkersNS && ext.XMLName.Local == "displayed":
 			var displayed chatmarkers.Displayed
 			if err := xml.Unmarshal(extXML, &displayed); err == nil && displayed.ID != "" {
-				if c.onReceipt != nil {
+				if fromSelf {
+					if c.onReadOnOther != nil && !msg.To.IsZero() && msg.Type != stanza.MessageGroupchat {
+						c.onReadOnOther(msg.To.Bare(), displayed.ID)
+					}
+				} else if c.onReceipt != nil {
 					c.onReceipt(displayed.ID, "read")
 				}
 				handledReceipt = true
@@ -936,7 +1047,7 @@ func (c *Client) handleMessage(msg *stanza.Message) {
 		case isMarkersNS && ext.XMLName.Local == "received":
 			var received chatmarkers.Received
 			if err := xml.Unmarshal(extXML, &received); err == nil && received.ID != "" {
-				if c.onReceipt != nil {
+				if c.onReceipt != nil && !fromSelf {
 					c.onReceipt(received.ID, "delivered")
 				}
 				handledReceipt = true
@@ -947,6 +1058,22 @@ func (c *Client) handleMessage(msg *stanza.Message) {
 		return
 	}
 
+	if state := chatStateFromExtensions(msg.Extensions); state != "" && c.onChatState != nil && !msg.From.IsZero() {
+		c.onChatState(msg.From, state)
+	}
+
+	if call, ok := callFromMessage(msg); ok {
+		c.emitCall(call)
+		if strings.TrimSpace(msg.Body) == "" {
+			return
+		}
+	}
+
+	if req, ok := voiceRequestFromMessage(msg); ok {
+		c.emitVoiceRequest(req)
+		return
+	}
+
 	if c.onMessage == nil {
 		return
 	}
@@ -957,6 +1084,9 @@ func (c *Client) handleMessage(msg *stanza.Message) {
 		Type:      msg.Type,
 		Timestamp: time.Now(),
 	}
+	if msg.Type == stanza.MessageGroupchat {
+		m.Subject = msg.Subject
+	}
 
 	if !msg.From.IsZero() {
 		m.From = msg.From
@@ -978,12 +1108,37 @@ func (c *Client) handleMessage(msg *stanza.Message) {
 		if isReceiptsNS && ext.XMLName.Local == "request" {
 			m.ReceiptRequested = true
 		}
+		if ext.XMLName.Space == "urn:xmpp:chat-markers:0" && ext.XMLName.Local == "markable" {
+			m.Markable = true
+		}
 		if ext.XMLName.Space == "urn:xmpp:message-correct:0" && ext.XMLName.Local == "replace" {
 			var replace correction.Replace
 			if err := xml.Unmarshal(extXML, &replace); err == nil {
 				m.CorrectedID = replace.ID
 			}
 		}
+		if ext.XMLName.Space == jsonContainerNS && ext.XMLName.Local == "json" {
+			var container jsonContainer
+			if err := xml.Unmarshal(extXML, &container); err == nil {
+				if payload := strings.TrimSpace(container.Data); json.Valid([]byte(payload)) {
+					m.JSON = payload
+				}
+			}
+		}
+		if ext.XMLName.Space == sidNS && ext.XMLName.Local == "origin-id" {
+			var origin stanzaid.OriginID
+			if err := xml.Unmarshal(extXML, &origin); err == nil {
+				m.OriginID = origin.ID
+			}
+		}
+		if ext.XMLName.Space == oobNS && ext.XMLName.Local == "x" {
+			var x oob.X
+			if err := xml.Unmarshal(extXML, &x); err == nil {
+				if url := strings.TrimSpace(x.URL); strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://") {
+					m.FileURL = url
+				}
+			}
+		}
 		if ext.XMLName.Space == "urn:xmpp:reactions:0" && ext.XMLName.Local == "reactions" {
 			var react reactions.Reactions
 			if err := xml.Unmarshal(extXML, &react); err == nil {
@@ -999,7 +1154,12 @@ func (c *Client) handleMessage(msg *stanza.Message) {
 		}
 	}
 
-	if strings.TrimSpace(m.Body) == "" && m.CorrectedID == "" && len(m.Reactions) == 0 {
+	if strings.TrimSpace(m.Body) == "" && m.FileURL != "" {
+		// Clients should repeat the URL in the body; show it for those that do not
+		m.Body = m.FileURL
+	}
+
+	if strings.TrimSpace(m.Body) == "" && m.CorrectedID == "" && len(m.Reactions) == 0 && m.JSON == "" && m.Subject == "" {
 		// Ignore protocol-only/empty stanzas that are not user-visible chat messages.
 		return
 	}
@@ -1008,13 +1168,17 @@ func (c *Client) handleMessage(msg *stanza.Message) {
 }
 
 func (c *Client) handlePresence(p *stanza.Presence) {
+	c.trackOnlineResource(p)
+
 	if c.onPresence == nil {
 		return
 	}
 
 	pr := Presence{
-		Show:   p.Show,
-		Status: p.Status,
+		Type:     p.Type,
+		Show:     p.Show,
+		Status:   p.Status,
+		Priority: int(p.Priority),
 	}
 
 	if !p.From.IsZero() {
@@ -1023,6 +1187,7 @@ func (c *Client) handlePresence(p *stanza.Presence) {
 	if !p.To.IsZero() {
 		pr.To = p.To
 	}
+	pr.Room = roomPresence(p)
 
 	c.onPresence(pr)
 }
@@ -1033,6 +1198,17 @@ func (c *Client) handleIQ(iq *stanza.IQ) {
 		if handled := c.handleRosterPush(iq); handled {
 			return
 		}
+		if handled := c.handleJingle(iq); handled {
+			return
+		}
+	}
+	if iq.Type == stanza.IQGet {
+		if handled := c.handleVersionQuery(iq); handled {
+			return
+		}
+		if handled := c.handleLastActivityQuery(iq); handled {
+			return
+		}
 	}
 
 	c.mu.Lock()
@@ -1127,55 +1303,10 @@ func (c *Client) handleRosterPush(iq *stanza.IQ) bool {
 	c.emitRosterFromStore()
 
 	// Ack roster push IQ set.
-	_ = c.session.Send(c.ctx, iq.ResultIQ())
+	_ = c.sendAsync(c.session, iq.ResultIQ(), sendOptions{Priority: priorityIQ})
 	return true
 }
 
-func (c *Client) sendIQAndWait(session *xmp.Session, iq *stanza.IQ, timeout time.Duration) (*stanza.IQ, error) {
-	respCh := make(chan *stanza.IQ, 1)
-	c.mu.Lock()
-	c.pendingIQs[iq.ID] = respCh
-	c.mu.Unlock()
-
-	if err := session.Send(c.ctx, iq); err != nil {
-		c.mu.Lock()
-		delete(c.pendingIQs, iq.ID)
-		c.mu.Unlock()
-		return nil, err
-	}
-
-	select {
-	case resp := <-respCh:
-		if resp == nil {
-			return nil, fmt.Errorf("empty iq response")
-		}
-		if resp.Type == stanza.IQError {
-			return nil, fmt.Errorf("iq request failed: %s", parseIQErrorDetails(resp))
-		}
-		return resp, nil
-	case <-time.After(timeout):
-		c.mu.Lock()
-		delete(c.pendingIQs, iq.ID)
-		c.mu.Unlock()
-		return nil, fmt.Errorf("iq request timed out")
-	case <-c.ctx.Done():
-		c.mu.Lock()
-		delete(c.pendingIQs, iq.ID)
-		c.mu.Unlock()
-		return nil, c.ctx.Err()
-	}
-}
-
-func (c *Client) handleDisconnect(err error) {
-	c.mu.Lock()
-	c.connected = false
-	c.mu.Unlock()
-
-	if c.onDisconnect != nil {
-		c.onDisconnect(err)
-	}
-}
-
 func (c *Client) Disconnect() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -1201,6 +1332,7 @@ func (c *Client) Disconnect() error {
 	c.connected = false
 	c.client = nil
 	c.session = nil
+	c.failPendingIQsLocked()
 
 	if c.onDisconnect != nil {
 		c.onDisconnect(nil)
@@ -1210,6 +1342,73 @@ func (c *Client) Disconnect() error {
 }
 
 func (c *Client) SendMessage(to, body string) (string, error) {
+	return c.sendChatMessage(to, body, false, "")
+}
+
+// SendUnstyledMessage sends a message whose body must be shown as plain
+// text, without XEP-0393 styling
+func (c *Client) SendUnstyledMessage(to, body string) (string, error) {
+	return c.sendChatMessage(to, body, true, "")
+}
+
+// SendFileMessage shares an uploaded file: the URL is the body, as most
+// clients expect, and is also sent as XEP-0066 out-of-band data for the
+// clients that show files from that only. It is never encrypted.
+func (c *Client) SendFileMessage(to, url string) (string, error) {
+	return c.sendChatMessage(to, url, true, url)
+}
+
+// SendRoomMessage sends a message to a room with the given ID, which the
+// caller picks so it can wait for the reflection before the room answers.
+// The ID goes along as XEP-0359 origin-id, which the room keeps when it
+// reflects the message back to us even if it gives the message an ID of its
+// own.
+func (c *Client) SendRoomMessage(room, id, body string, unstyled bool) error {
+	c.mu.RLock()
+	if !c.connected {
+		c.mu.RUnlock()
+		return fmt.Errorf("not connected")
+	}
+	session := c.session
+	c.mu.RUnlock()
+
+	roomJID, err := jid.Parse(room)
+	if err != nil {
+		return fmt.Errorf("invalid JID: %w", err)
+	}
+
+	msg := stanza.NewMessage(stanza.MessageGroupchat)
+	msg.To = roomJID.Bare()
+	msg.ID = id
+	msg.Body = body
+	msg.Extensions = append(msg.Extensions, stanza.Extension{
+		XMLName: xml.Name{Space: sidNS, Local: "origin-id"},
+		Attrs:   []xml.Attr{{Name: xml.Name{Local: "id"}, Value: id}},
+	})
+	if unstyled {
+		msg.Extensions = append(msg.Extensions, stanza.Extension{
+			XMLName: xml.Name{Space: "urn:xmpp:styling:0", Local: "unstyled"},
+		})
+	}
+
+	return c.sendQueued(c.ctx, session, msg, sendOptions{Priority: priorityMessage})
+}
+
+// oobExtension is the XEP-0066 element carrying a file URL
+func oobExtension(url string) stanza.Extension {
+	var inner bytes.Buffer
+	inner.WriteString("<url>")
+	_ = xml.EscapeText(&inner, []byte(url))
+	inner.WriteString("</url>")
+	return stanza.Extension{
+		XMLName: xml.Name{Space: oobNS, Local: "x"},
+		Inner:   inner.Bytes(),
+	}
+}
+
+// sendChatMessage sends a plaintext chat message, with the URL of a shared
+// file as out-of-band data when fileURL is set
+func (c *Client) sendChatMessage(to, body string, unstyled bool, fileURL string) (string, error) {
 	c.mu.RLock()
 	if !c.connected {
 		c.mu.RUnlock()
@@ -1241,32 +1440,56 @@ func (c *Client) SendMessage(to, body string) (string, error) {
 			Inner:   markableData,
 		})
 	}
+	if unstyled {
+		msg.Extensions = append(msg.Extensions, stanza.Extension{
+			XMLName: xml.Name{Space: "urn:xmpp:styling:0", Local: "unstyled"},
+		})
+	}
+	if fileURL != "" {
+		msg.Extensions = append(msg.Extensions, oobExtension(fileURL))
+	}
 
-	return id, session.Send(c.ctx, msg)
+	return id, c.sendQueued(c.ctx, session, msg, sendOptions{Priority: priorityMessage})
 }
 
-func (c *Client) SendEncryptedMessage(to, body string) (string, error) {
+// ErrEncryptionUnavailable is returned by SendEncryptedMessage when the
+// message cannot be encrypted and plaintext is not allowed
+var ErrEncryptionUnavailable = errors.New("OMEMO encryption unavailable for this contact")
+
+// SendEncryptedMessage sends an OMEMO encrypted message to all known devices
+// of the contact. When it cannot be encrypted it is sent in plaintext if
+// allowPlaintext is set, and fails with ErrEncryptionUnavailable otherwise.
+// It reports whether the message went out encrypted.
+func (c *Client) SendEncryptedMessage(to, body string, allowPlaintext bool) (string, bool, error) {
 	c.mu.RLock()
 	if !c.connected {
 		c.mu.RUnlock()
-		return "", fmt.Errorf("not connected")
+		return "", false, fmt.Errorf("not connected")
 	}
 	session := c.session
 	c.mu.RUnlock()
 
 	toJID, err := jid.Parse(to)
 	if err != nil {
-		return "", fmt.Errorf("invalid JID: %w", err)
+		return "", false, fmt.Errorf("invalid JID: %w", err)
+	}
+
+	plaintext := func() (string, bool, error) {
+		if !allowPlaintext {
+			return "", false, ErrEncryptionUnavailable
+		}
+		id, err := c.SendMessage(to, body)
+		return id, false, err
 	}
 
 	rp, err := c.getRosterPlugin()
 	if err != nil {
-		return c.SendMessage(to, body)
+		return plaintext()
 	}
 
 	items, err := rp.Items(c.ctx)
 	if err != nil {
-		return c.SendMessage(to, body)
+		return plaintext()
 	}
 
 	var devices []cryptoomemo.Address
@@ -1286,12 +1509,12 @@ func (c *Client) SendEncryptedMessage(to, body string) (string, error) {
 	}
 
 	if len(devices) == 0 {
-		return c.SendMessage(to, body)
+		return plaintext()
 	}
 
 	encMsg, err := c.omemoManager.Encrypt([]byte(body), devices...)
 	if err != nil {
-		return c.SendMessage(to, body)
+		return plaintext()
 	}
 
 	id := stanza.GenerateID()
@@ -1324,7 +1547,10 @@ func (c *Client) SendEncryptedMessage(to, body string) (string, error) {
 		Inner:   encData,
 	})
 
-	return id, session.Send(c.ctx, msg)
+	if err := c.sendQueued(c.ctx, session, msg, sendOptions{Priority: priorityMessage}); err != nil {
+		return id, false, err
+	}
+	return id, true, nil
 }
 
 func (c *Client) SendPresence(show, status string) error {
@@ -1340,7 +1566,7 @@ func (c *Client) SendPresence(show, status string) error {
 	p.Show = show
 	p.Status = status
 
-	return session.Send(c.ctx, p)
+	return c.sendAsync(session, p, sendOptions{Priority: priorityPresence})
 }
 
 func (c *Client) SendDirectedPresence(to, show, status string) error {
@@ -1362,7 +1588,7 @@ func (c *Client) SendDirectedPresence(to, show, status string) error {
 	p.Show = show
 	p.Status = status
 
-	return session.Send(c.ctx, p)
+	return c.sendAsync(session, p, sendOptions{Priority: priorityPresence})
 }
 
 func (c *Client) HideStatusFrom(contactJID string) error {
@@ -1382,7 +1608,7 @@ func (c *Client) HideStatusFrom(contactJID string) error {
 	p := stanza.NewPresence(stanza.PresenceUnavailable)
 	p.To = toJID.Bare()
 
-	return session.Send(c.ctx, p)
+	return c.sendAsync(session, p, sendOptions{Priority: priorityPresence})
 }
 
 func (c *Client) RequestRoster() error {
@@ -1401,13 +1627,9 @@ func (c *Client) RequestRoster() error {
 	}
 	iq.Query = queryXML
 
-	resp, err := c.sendIQAndWait(session, iq, 12*time.Second)
+	query, err := requestIQ[roster.Query](c.ctx, c, session, iq, iqOptions{Timeout: 12 * time.Second, Retries: 1})
 	if err != nil {
-		return err
-	}
-	query, ok := c.parseRosterQuery(resp)
-	if !ok {
-		return fmt.Errorf("invalid roster response payload")
+		return fmt.Errorf("roster request failed: %w", err)
 	}
 	if err := c.applyRosterItems(query); err != nil {
 		return err
@@ -1432,12 +1654,11 @@ func (c *Client) EnableCarbons() error {
 	}
 	iq.Query = queryXML
 
-	_, err = c.sendIQAndWait(session, iq, 8*time.Second)
+	_, err = c.doIQ(c.ctx, session, iq, iqOptions{Timeout: 8 * time.Second})
 	if err != nil {
-		lower := strings.ToLower(err.Error())
-		if strings.Contains(lower, "feature-not-implemented") ||
-			strings.Contains(lower, "service-unavailable") ||
-			strings.Contains(lower, "not-authorized") {
+		if errors.Is(err, ConditionFeatureNotImplemented) ||
+			errors.Is(err, ConditionServiceUnavailable) ||
+			errors.Is(err, ConditionNotAuthorized) {
 			return nil
 		}
 		return fmt.Errorf("carbons enable failed: %w", err)
@@ -1541,7 +1762,7 @@ func (c *Client) Subscribe(contactJID string) error {
 	p := stanza.NewPresence(stanza.PresenceSubscribe)
 	p.To = to
 
-	return session.Send(c.ctx, p)
+	return c.sendAsync(session, p, sendOptions{Priority: priorityPresence})
 }
 
 func (c *Client) Unsubscribe(contactJID string) error {
@@ -1561,7 +1782,76 @@ func (c *Client) Unsubscribe(contactJID string) error {
 	p := stanza.NewPresence(stanza.PresenceUnsubscribe)
 	p.To = to
 
-	return session.Send(c.ctx, p)
+	return c.sendAsync(session, p, sendOptions{Priority: priorityPresence})
+}
+
+// ApproveSubscription lets a contact who asked for it see our presence
+func (c *Client) ApproveSubscription(contactJID string) error {
+	return c.sendSubscription(contactJID, stanza.PresenceSubscribed)
+}
+
+// DenySubscription declines a contact's request to see our presence
+func (c *Client) DenySubscription(contactJID string) error {
+	return c.sendSubscription(contactJID, stanza.PresenceUnsubscribed)
+}
+
+func (c *Client) sendSubscription(contactJID, presenceType string) error {
+	c.mu.RLock()
+	if !c.connected {
+		c.mu.RUnlock()
+		return fmt.Errorf("not connected")
+	}
+	session := c.session
+	c.mu.RUnlock()
+
+	to, err := jid.Parse(contactJID)
+	if err != nil {
+		return fmt.Errorf("invalid JID: %w", err)
+	}
+
+	p := stanza.NewPresence(presenceType)
+	p.To = to
+
+	return c.sendAsync(session, p, sendOptions{Priority: priorityPresence})
+}
+
+// Block asks the server to block a JID (XEP-0191), which stops its
+// messages and presence reaching any of our devices. A server without
+// blocking support is not an error; the app still drops what comes in.
+func (c *Client) Block(contactJID string) error {
+	return c.sendBlocking(blocking.Block{Items: []blocking.BlockItem{{JID: contactJID}}})
+}
+
+// Unblock lifts a block set with Block
+func (c *Client) Unblock(contactJID string) error {
+	return c.sendBlocking(blocking.Unblock{Items: []blocking.BlockItem{{JID: contactJID}}})
+}
+
+func (c *Client) sendBlocking(command interface{}) error {
+	c.mu.RLock()
+	if !c.connected {
+		c.mu.RUnlock()
+		return fmt.Errorf("not connected")
+	}
+	session := c.session
+	c.mu.RUnlock()
+
+	iq := stanza.NewIQ(stanza.IQSet)
+	queryXML, err := xml.Marshal(command)
+	if err != nil {
+		return fmt.Errorf("failed to marshal blocking command: %w", err)
+	}
+	iq.Query = queryXML
+
+	_, err = c.doIQ(c.ctx, session, iq, iqOptions{Timeout: 8 * time.Second})
+	if err != nil {
+		if errors.Is(err, ConditionFeatureNotImplemented) ||
+			errors.Is(err, ConditionServiceUnavailable) {
+			return nil
+		}
+		return fmt.Errorf("blocking command failed: %w", err)
+	}
+	return nil
 }
 
 func (c *Client) JoinRoom(roomJID, nick, password string) error {
@@ -1619,7 +1909,7 @@ func (c *Client) SendReceipt(to, messageID string) error {
 		Inner:   recData,
 	})
 
-	return session.Send(c.ctx, msg)
+	return c.sendAsync(session, msg, sendOptions{Priority: priorityMessage})
 }
 
 func (c *Client) SendDisplayedMarker(to, messageID string) error {
@@ -1645,7 +1935,42 @@ func (c *Client) SendDisplayedMarker(to, messageID string) error {
 		Inner:   displayedData,
 	})
 
-	return session.Send(c.ctx, msg)
+	return c.sendAsync(session, msg, sendOptions{Priority: priorityMessage})
+}
+
+// SendChatState tells a contact whether we are typing (XEP-0085). Only the
+// latest state per contact waits in the send queue.
+func (c *Client) SendChatState(to, state string) error {
+	c.mu.RLock()
+	if !c.connected {
+		c.mu.RUnlock()
+		return fmt.Errorf("not connected")
+	}
+	session := c.session
+	c.mu.RUnlock()
+
+	switch state {
+	case chatstates.StateActive, chatstates.StateComposing, chatstates.StatePaused,
+		chatstates.StateInactive, chatstates.StateGone:
+	default:
+		return fmt.Errorf("unknown chat state %q", state)
+	}
+	toJID, err := jid.Parse(to)
+	if err != nil {
+		return fmt.Errorf("invalid JID: %w", err)
+	}
+
+	msg := stanza.NewMessage(stanza.MessageChat)
+	msg.To = toJID
+	msg.Extensions = append(msg.Extensions,
+		stanza.Extension{XMLName: xml.Name{Space: "http://jabber.org/protocol/chatstates", Local: state}},
+		stanza.Extension{XMLName: xml.Name{Space: "urn:xmpp:hints", Local: "no-store"}},
+	)
+
+	return c.sendAsync(session, msg, sendOptions{
+		Priority: priorityPresence,
+		Coalesce: "chatstate:" + toJID.String(),
+	})
 }
 
 func (c *Client) IsConnected() bool {
@@ -1688,6 +2013,18 @@ func (c *Client) SetConnectHandler(handler func()) {
 	c.onConnect = handler
 }
 
+// SetConnectStepHandler sets a handler told of each step of a connection
+// attempt: dialing, stream setup, TLS, authentication and resource binding
+func (c *Client) SetConnectStepHandler(handler func(step string)) {
+	c.onConnectStep = handler
+}
+
+func (c *Client) connectStep(step string) {
+	if c.onConnectStep != nil {
+		c.onConnectStep(step)
+	}
+}
+
 func (c *Client) SetDisconnectHandler(handler func(err error)) {
 	c.onDisconnect = handler
 }
@@ -1700,6 +2037,34 @@ func (c *Client) SetReceiptHandler(handler func(messageID string, status string)
 	c.onReceipt = handler
 }
 
+// SetReadOnOtherDeviceHandler is called when another of our devices marks a
+// conversation as read, with the peer and the last message it displayed.
+func (c *Client) SetReadOnOtherDeviceHandler(handler func(peer jid.JID, messageID string)) {
+	c.onReadOnOther = handler
+}
+
+func (c *Client) SetChatStateHandler(handler func(from jid.JID, state string)) {
+	c.onChatState = handler
+}
+
+// SetOMEMODeviceHandler is called when a contact device is first seen or
+// presents a different identity key than the one on record.
+func (c *Client) SetOMEMODeviceHandler(handler func(contactJID string, deviceID uint32, identityKey []byte, changed bool)) {
+	c.onOMEMODevice = handler
+}
+
+// SetCallHandler is called for voice/video call signals from contacts or
+// from our own other devices.
+func (c *Client) SetCallHandler(handler func(call CallEvent)) {
+	c.onCall = handler
+}
+
+// SetVoiceRequestHandler is called when a room asks us, as a moderator, to
+// approve a visitor's voice request.
+func (c *Client) SetVoiceRequestHandler(handler func(req VoiceRequest)) {
+	c.onVoiceRequest = handler
+}
+
 func (c *Client) GetRosterItems() ([]RosterItem, error) {
 	c.mu.RLock()
 	if !c.connected {
@@ -1842,7 +2207,7 @@ func (c *Client) CorrectMessage(to, originalID, newBody string) (string, error)
 		Inner:   replaceData,
 	})
 
-	if err := session.Send(c.ctx, msg); err != nil {
+	if err := c.sendQueued(c.ctx, session, msg, sendOptions{Priority: priorityMessage}); err != nil {
 		return "", err
 	}
 
@@ -1879,7 +2244,7 @@ func (c *Client) SendReaction(to, messageID, reaction string) error {
 		Inner:   reactData,
 	})
 
-	return session.Send(c.ctx, msg)
+	return c.sendQueued(c.ctx, session, msg, sendOptions{Priority: priorityMessage})
 }
 
 type UploadSlot struct {
@@ -1914,47 +2279,20 @@ func (c *Client) RequestUploadSlot(serviceJID, filename string, size int64, cont
 	reqData, _ := xml.Marshal(req)
 	iq.Query = reqData
 
-	respCh := make(chan *stanza.IQ, 1)
-	c.mu.Lock()
-	c.pendingIQs[iq.ID] = respCh
-	c.mu.Unlock()
-
-	defer func() {
-		c.mu.Lock()
-		delete(c.pendingIQs, iq.ID)
-		c.mu.Unlock()
-	}()
-
-	if err := session.Send(c.ctx, iq); err != nil {
-		return nil, err
+	slot, err := requestIQ[upload.Slot](c.ctx, c, session, iq, iqOptions{Timeout: 30 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("upload slot request failed: %w", err)
 	}
 
-	select {
-	case resp := <-respCh:
-		if resp.Type == stanza.IQError {
-			return nil, fmt.Errorf("upload slot request failed")
-		}
-
-		var slot upload.Slot
-		if err := xml.Unmarshal(resp.Query, &slot); err != nil {
-			return nil, fmt.Errorf("failed to parse slot response: %w", err)
-		}
-
-		result := &UploadSlot{
-			PutURL:  slot.Put.URL,
-			GetURL:  slot.Get.URL,
-			Headers: make(map[string]string),
-		}
-		for _, h := range slot.Put.Headers {
-			result.Headers[h.Name] = h.Value
-		}
-		return result, nil
-
-	case <-time.After(30 * time.Second):
-		return nil, fmt.Errorf("upload slot request timed out")
-	case <-c.ctx.Done():
-		return nil, c.ctx.Err()
+	result := &UploadSlot{
+		PutURL:  slot.Put.URL,
+		GetURL:  slot.Get.URL,
+		Headers: make(map[string]string),
 	}
+	for _, h := range slot.Put.Headers {
+		result.Headers[h.Name] = h.Value
+	}
+	return result, nil
 }
 
 func (c *Client) QueryMAM(jid, afterID string) error {
@@ -1963,6 +2301,7 @@ func (c *Client) QueryMAM(jid, afterID string) error {
 		c.mu.RUnlock()
 		return fmt.Errorf("not connected")
 	}
+	session := c.session
 	c.mu.RUnlock()
 
 	accountBare := c.jid.Bare()
@@ -2007,7 +2346,12 @@ func (c *Client) QueryMAM(jid, afterID string) error {
 	queryData, _ := xml.Marshal(query)
 	iq.Query = queryData
 
-	return c.session.SendElement(c.ctx, iq)
+	// The archived messages arrive before the <fin/> result, so waiting for
+	// it means the page has been handled
+	if _, err := requestIQ[mamplugin.Fin](c.ctx, c, session, iq, iqOptions{Timeout: 30 * time.Second}); err != nil {
+		return fmt.Errorf("archive query failed: %w", err)
+	}
+	return nil
 }
 
 func (c *Client) handleMAMResult(msg *stanza.Message) {
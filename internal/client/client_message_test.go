@@ -4,6 +4,7 @@ import (
 	"encoding/xml"
 	"testing"
 
+	"github.com/meszmate/xmpp-go/jid"
 	"github.com/meszmate/xmpp-go/plugins/correction"
 	"github.com/meszmate/xmpp-go/stanza"
 )
@@ -151,3 +152,288 @@ func TestHandleMessageParsesCorrectionIDFromAttributes(t *testing.T) {
 		t.Fatalf("expected corrected id orig-42, got %q", got.CorrectedID)
 	}
 }
+
+func TestHandleMessageEmitsChatStateWithoutChatMessage(t *testing.T) {
+	c := &Client{}
+
+	var gotFrom, gotState string
+	c.onChatState = func(from jid.JID, state string) {
+		gotFrom = from.Bare().String()
+		gotState = state
+	}
+
+	messageCalled := false
+	c.onMessage = func(msg Message) {
+		messageCalled = true
+	}
+
+	msg := &stanza.Message{
+		Extensions: []stanza.Extension{
+			{XMLName: xml.Name{Space: "http://jabber.org/protocol/chatstates", Local: "composing"}},
+		},
+	}
+	msg.From = jid.MustParse("alice@example.com/phone")
+
+	c.handleMessage(msg)
+
+	if gotState != "composing" {
+		t.Fatalf("expected composing chat state, got %q", gotState)
+	}
+	if gotFrom != "alice@example.com" {
+		t.Fatalf("unexpected chat state sender: %q", gotFrom)
+	}
+	if messageCalled {
+		t.Fatalf("did not expect onMessage callback for chat-state-only stanza")
+	}
+}
+
+func TestHandleMessageParsesJSONContainer(t *testing.T) {
+	c := &Client{}
+
+	called := false
+	var got Message
+	c.onMessage = func(msg Message) {
+		called = true
+		got = msg
+	}
+
+	msg := &stanza.Message{
+		Extensions: []stanza.Extension{
+			{
+				XMLName: xml.Name{Space: "urn:xmpp:json:0", Local: "json"},
+				Inner:   []byte(`{&quot;build&quot;:42,&quot;ok&quot;:true}`),
+			},
+		},
+	}
+
+	c.handleMessage(msg)
+
+	if !called {
+		t.Fatalf("expected onMessage for a JSON-only message")
+	}
+	if got.JSON != `{"build":42,"ok":true}` {
+		t.Fatalf("unexpected JSON payload %q", got.JSON)
+	}
+}
+
+func TestHandleMessageIgnoresInvalidJSONContainer(t *testing.T) {
+	c := &Client{}
+
+	called := false
+	c.onMessage = func(msg Message) {
+		called = true
+	}
+
+	msg := &stanza.Message{
+		Extensions: []stanza.Extension{
+			{
+				XMLName: xml.Name{Space: "urn:xmpp:json:0", Local: "json"},
+				Inner:   []byte(`not json`),
+			},
+		},
+	}
+
+	c.handleMessage(msg)
+
+	if called {
+		t.Fatalf("did not expect onMessage for an invalid JSON container")
+	}
+}
+
+func TestHandleMessageParsesOutOfBandURL(t *testing.T) {
+	c := &Client{}
+
+	var got Message
+	c.onMessage = func(msg Message) {
+		got = msg
+	}
+
+	msg := &stanza.Message{
+		Extensions: []stanza.Extension{
+			{
+				XMLName: xml.Name{Space: "jabber:x:oob", Local: "x"},
+				Inner:   []byte(`<url>https://upload.example.org/abc/photo.jpg</url><desc>A photo</desc>`),
+			},
+		},
+	}
+
+	c.handleMessage(msg)
+
+	if got.FileURL != "https://upload.example.org/abc/photo.jpg" {
+		t.Fatalf("unexpected file URL %q", got.FileURL)
+	}
+	if got.Body != got.FileURL {
+		t.Fatalf("expected the URL as body of an OOB-only message, got %q", got.Body)
+	}
+}
+
+func TestOutOfBandExtensionRoundTrip(t *testing.T) {
+	ext := oobExtension("https://upload.example.org/a&b.png")
+
+	msg := stanza.NewMessage(stanza.MessageChat)
+	msg.Body = "https://upload.example.org/a&b.png"
+	msg.Extensions = append(msg.Extensions, ext)
+	data, err := xml.Marshal(msg)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	var parsed stanza.Message
+	if err := xml.Unmarshal(data, &parsed); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	c := &Client{}
+	var got Message
+	c.onMessage = func(m Message) {
+		got = m
+	}
+	c.handleMessage(&parsed)
+
+	if got.FileURL != "https://upload.example.org/a&b.png" {
+		t.Fatalf("unexpected file URL %q in %s", got.FileURL, data)
+	}
+}
+
+func TestHandleMessageReportsCallProposal(t *testing.T) {
+	c := &Client{}
+	c.onMessage = func(msg Message) {
+		t.Fatalf("did not expect onMessage for a call proposal")
+	}
+
+	var got CallEvent
+	c.onCall = func(call CallEvent) {
+		got = call
+	}
+
+	from, _ := jid.Parse("alice@example.com/phone")
+	msg := &stanza.Message{
+		Header: stanza.Header{From: from},
+		Extensions: []stanza.Extension{
+			{
+				XMLName: xml.Name{Space: "urn:xmpp:jingle-message:0", Local: "propose"},
+				Attrs:   []xml.Attr{{Name: xml.Name{Local: "id"}, Value: "call1"}},
+				Inner:   []byte(`<description xmlns="urn:xmpp:jingle:apps:rtp:1" media="audio"/><description xmlns="urn:xmpp:jingle:apps:rtp:1" media="video"/>`),
+			},
+		},
+	}
+
+	c.handleMessage(msg)
+
+	if got.State != CallProposed || got.ID != "call1" {
+		t.Fatalf("unexpected call event %+v", got)
+	}
+	if len(got.Media) != 2 || got.Media[1] != "video" {
+		t.Fatalf("unexpected call media %v", got.Media)
+	}
+	if got.From.String() != "alice@example.com/phone" {
+		t.Fatalf("unexpected caller %s", got.From)
+	}
+}
+
+func TestHandleMessageReportsDisplayedMarkerFromOwnDevice(t *testing.T) {
+	forwarded := []byte(`<forwarded xmlns='urn:xmpp:forward:0'><message xmlns='jabber:client' from='bob@example.com/phone' to='alice@example.com/laptop' type='chat'><displayed xmlns='urn:xmpp:chat-markers:0' id='a7'/></message></forwarded>`)
+
+	own, _ := jid.Parse("bob@example.com/roster")
+	c := &Client{jid: own}
+	var peer, readID string
+	c.onReadOnOther = func(p jid.JID, messageID string) {
+		peer, readID = p.String(), messageID
+	}
+	receipts := 0
+	c.onReceipt = func(string, string) { receipts++ }
+
+	c.handleMessage(&stanza.Message{
+		Extensions: []stanza.Extension{
+			{
+				XMLName: xml.Name{Space: "urn:xmpp:carbons:2", Local: "sent"},
+				Inner:   forwarded,
+			},
+		},
+	})
+
+	if peer != "alice@example.com" || readID != "a7" {
+		t.Fatalf("expected read on other device for alice@example.com/a7, got %q/%q", peer, readID)
+	}
+	if receipts != 0 {
+		t.Fatalf("own marker must not be treated as a read receipt, got %d", receipts)
+	}
+}
+
+func TestHandleMessageParsesOriginID(t *testing.T) {
+	c := &Client{}
+
+	var got Message
+	c.onMessage = func(msg Message) {
+		got = msg
+	}
+
+	// A room reflecting our message with an ID of its own
+	msg := stanza.NewMessage(stanza.MessageGroupchat)
+	msg.ID = "room-assigned"
+	msg.Body = "hello"
+	msg.Extensions = append(msg.Extensions, stanza.Extension{
+		XMLName: xml.Name{Space: "urn:xmpp:sid:0", Local: "origin-id"},
+		Attrs:   []xml.Attr{{Name: xml.Name{Local: "id"}, Value: "room-1700000000"}},
+	})
+
+	c.handleMessage(msg)
+
+	if got.OriginID != "room-1700000000" {
+		t.Fatalf("unexpected origin-id %q", got.OriginID)
+	}
+	if got.ID != "room-assigned" || got.Type != "groupchat" {
+		t.Fatalf("unexpected message %+v", got)
+	}
+}
+
+func TestHandleMessageParsesRoomSubject(t *testing.T) {
+	c := &Client{}
+
+	var got []Message
+	c.onMessage = func(msg Message) {
+		got = append(got, msg)
+	}
+
+	// A subject without a body is still a room message
+	msg := stanza.NewMessage(stanza.MessageGroupchat)
+	msg.Subject = "Fire Burn and Cauldron Bubble!"
+	c.handleMessage(msg)
+
+	// Outside rooms a subject alone is not shown
+	chatMsg := stanza.NewMessage(stanza.MessageChat)
+	chatMsg.Subject = "Hello"
+	c.handleMessage(chatMsg)
+
+	if len(got) != 1 {
+		t.Fatalf("expected one message, got %+v", got)
+	}
+	if got[0].Subject != "Fire Burn and Cauldron Bubble!" || got[0].Body != "" {
+		t.Fatalf("unexpected message %+v", got[0])
+	}
+}
+
+func TestHandleMessageParsesMarkable(t *testing.T) {
+	c := &Client{}
+
+	var got []Message
+	c.onMessage = func(msg Message) {
+		got = append(got, msg)
+	}
+
+	markable := stanza.NewMessage(stanza.MessageChat)
+	markable.ID = "m1"
+	markable.Body = "read me"
+	markable.Extensions = append(markable.Extensions, stanza.Extension{
+		XMLName: xml.Name{Space: "urn:xmpp:chat-markers:0", Local: "markable"},
+	})
+	c.handleMessage(markable)
+
+	plain := stanza.NewMessage(stanza.MessageChat)
+	plain.ID = "m2"
+	plain.Body = "or not"
+	c.handleMessage(plain)
+
+	if len(got) != 2 || !got[0].Markable || got[1].Markable {
+		t.Fatalf("unexpected markable flags %+v", got)
+	}
+}
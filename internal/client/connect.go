@@ -0,0 +1,60 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Timeouts for each step of connecting
+const (
+	dialTimeout   = 30 * time.Second // DNS lookup and TCP connect
+	streamTimeout = 15 * time.Second // opening the stream and reading its features
+	tlsTimeout    = 20 * time.Second // STARTTLS handshake and stream restart
+	authTimeout   = 20 * time.Second // SASL and stream restart
+	bindTimeout   = 12 * time.Second
+)
+
+// ErrConnectCancelled is returned when the connection attempt was cancelled
+// before it completed
+var ErrConnectCancelled = errors.New("connection cancelled")
+
+// transportConn returns the network connection behind a transport, if any
+func transportConn(trans any) net.Conn {
+	if connGetter, ok := trans.(interface{ Conn() net.Conn }); ok {
+		return connGetter.Conn()
+	}
+	return nil
+}
+
+// runPhase runs one negotiation step with its own timeout. The negotiation
+// reads block on the connection, so when the phase times out or ctx is
+// cancelled the connection deadline is moved to now to unblock them.
+func runPhase(ctx context.Context, conn func() net.Conn, name string, timeout time.Duration, step func(ctx context.Context) error) error {
+	phaseCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	stop := context.AfterFunc(phaseCtx, func() {
+		if nc := conn(); nc != nil {
+			_ = nc.SetDeadline(time.Now())
+		}
+	})
+	err := step(phaseCtx)
+	if stop() {
+		return err
+	}
+
+	// The deadline was moved; undo it in case the step finished first
+	if nc := conn(); nc != nil {
+		_ = nc.SetDeadline(time.Time{})
+	}
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() != nil:
+		return ctx.Err()
+	}
+	return fmt.Errorf("%s timed out after %s", name, timeout)
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func blockedRead(conn net.Conn) func(context.Context) error {
+	return func(context.Context) error {
+		_, err := conn.Read(make([]byte, 1))
+		return err
+	}
+}
+
+func TestRunPhaseTimesOutBlockedRead(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	err := runPhase(context.Background(), func() net.Conn { return local }, "stream setup", 50*time.Millisecond, blockedRead(local))
+	if err == nil || !strings.Contains(err.Error(), "stream setup timed out") {
+		t.Fatalf("expected a phase timeout, got %v", err)
+	}
+
+	// The deadline is cleared again once the phase is over
+	go func() { _, _ = remote.Write([]byte("x")) }()
+	if _, err := local.Read(make([]byte, 1)); err != nil {
+		t.Fatalf("expected the connection to be readable after the phase, got %v", err)
+	}
+}
+
+func TestRunPhaseCancelAbortsBlockedRead(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	err := runPhase(ctx, func() net.Conn { return local }, "TLS", time.Minute, blockedRead(local))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestRunPhasePassesStepResult(t *testing.T) {
+	want := errors.New("bad credentials")
+	err := runPhase(context.Background(), func() net.Conn { return nil }, "authentication", time.Minute, func(context.Context) error {
+		return want
+	})
+	if !errors.Is(err, want) {
+		t.Fatalf("expected the step error, got %v", err)
+	}
+}
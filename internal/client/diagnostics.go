@@ -0,0 +1,358 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	xmp "github.com/meszmate/xmpp-go"
+	"github.com/meszmate/xmpp-go/jid"
+	"github.com/meszmate/xmpp-go/plugins/disco"
+	"github.com/meszmate/xmpp-go/stanza"
+)
+
+// CheckStatus is the outcome of one diagnostic check
+type CheckStatus int
+
+const (
+	CheckPass CheckStatus = iota
+	CheckWarn
+	CheckFail
+	CheckSkip
+)
+
+func (s CheckStatus) String() string {
+	switch s {
+	case CheckPass:
+		return "PASS"
+	case CheckWarn:
+		return "WARN"
+	case CheckFail:
+		return "FAIL"
+	}
+	return "SKIP"
+}
+
+// DiagnosticCheck is one line of an account health report
+type DiagnosticCheck struct {
+	Name   string
+	Status CheckStatus
+	Detail string
+}
+
+const (
+	probeTimeout = 10 * time.Second
+
+	// maxClockSkew is how far our clock may be off the server's before it
+	// is reported
+	maxClockSkew = time.Minute
+
+	// certExpiryWarning is how long before expiry a certificate is reported
+	certExpiryWarning = 14 * 24 * time.Hour
+
+	nsCarbons = "urn:xmpp:carbons:2"
+	nsMAM     = "urn:xmpp:mam:2"
+)
+
+// Probe runs the checks that need no credentials against the server of
+// domain: SRV records, TCP reachability, STARTTLS and the offered SASL
+// mechanisms. server and port override the SRV lookup like they do when
+// connecting.
+func Probe(ctx context.Context, domain, server string, port int) []DiagnosticCheck {
+	var checks []DiagnosticCheck
+
+	host, addrPort := strings.TrimSpace(server), port
+	if host != "" || (port != 0 && port != 5222) {
+		checks = append(checks, DiagnosticCheck{"DNS SRV", CheckSkip, "server set in account settings"})
+		if host == "" {
+			host = domain
+		}
+		if addrPort == 0 {
+			addrPort = 5222
+		}
+	} else {
+		host, addrPort = domain, 5222
+		check := DiagnosticCheck{Name: "DNS SRV"}
+		_, records, err := net.DefaultResolver.LookupSRV(ctx, "xmpp-client", "tcp", domain)
+		if err != nil || len(records) == 0 {
+			check.Status = CheckWarn
+			check.Detail = "no _xmpp-client._tcp records, falling back to " + domain + ":5222"
+		} else {
+			host, addrPort = strings.TrimSuffix(records[0].Target, "."), int(records[0].Port)
+			var targets []string
+			for _, r := range records {
+				targets = append(targets, net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port))))
+			}
+			check.Detail = strings.Join(targets, ", ")
+		}
+		checks = append(checks, check)
+
+		if _, tlsRecords, err := net.DefaultResolver.LookupSRV(ctx, "xmpps-client", "tcp", domain); err == nil && len(tlsRecords) > 0 {
+			r := tlsRecords[0]
+			checks = append(checks, DiagnosticCheck{"DNS SRV (direct TLS)", CheckPass,
+				net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port))) + " (not used by roster)"})
+		}
+	}
+
+	addr := net.JoinHostPort(host, strconv.Itoa(addrPort))
+	dialCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+	start := time.Now()
+	conn, err := (&net.Dialer{}).DialContext(dialCtx, "tcp", addr)
+	cancel()
+	if err != nil {
+		return append(checks, DiagnosticCheck{"TCP connect", CheckFail, err.Error()})
+	}
+	defer conn.Close()
+	checks = append(checks, DiagnosticCheck{"TCP connect", CheckPass,
+		fmt.Sprintf("%s in %s", addr, time.Since(start).Round(time.Millisecond))})
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else {
+		_ = conn.SetDeadline(time.Now().Add(probeTimeout))
+	}
+	return append(checks, probeStream(conn, domain)...)
+}
+
+// probeStream negotiates STARTTLS on conn and reports the SASL mechanisms
+// the server offers afterwards, without authenticating
+func probeStream(conn net.Conn, domain string) []DiagnosticCheck {
+	features, err := probeFeatures(conn, domain)
+	if err != nil {
+		return []DiagnosticCheck{{"XMPP stream", CheckFail, err.Error()}}
+	}
+	checks := []DiagnosticCheck{{"XMPP stream", CheckPass, "server answered the stream header"}}
+
+	if features.StartTLS == nil {
+		checks = append(checks, DiagnosticCheck{"STARTTLS", CheckFail, "not offered; the connection would be unencrypted"})
+		return append(checks, mechanismsCheck(features.Mechanisms))
+	}
+
+	if _, err := conn.Write([]byte(`<starttls xmlns='` + nsTLS + `'/>`)); err != nil {
+		return append(checks, DiagnosticCheck{"STARTTLS", CheckFail, err.Error()})
+	}
+	dec := xml.NewDecoder(conn)
+	proceed := false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return append(checks, DiagnosticCheck{"STARTTLS", CheckFail, err.Error()})
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Space == nsTLS {
+			proceed = start.Name.Local == "proceed"
+			break
+		}
+	}
+	if !proceed {
+		return append(checks, DiagnosticCheck{"STARTTLS", CheckFail, "server refused STARTTLS"})
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: domain, MinVersion: tls.VersionTLS12})
+	if err := tlsConn.Handshake(); err != nil {
+		return append(checks, DiagnosticCheck{"TLS handshake", CheckFail, err.Error()})
+	}
+	checks = append(checks, tlsCheck(tlsConn.ConnectionState(), time.Now()))
+
+	features, err = probeFeatures(tlsConn, domain)
+	if err != nil {
+		return append(checks, DiagnosticCheck{"SASL mechanisms", CheckFail, err.Error()})
+	}
+	return append(checks, mechanismsCheck(features.Mechanisms))
+}
+
+// probeFeatures opens a stream on conn and reads the features offered on it
+func probeFeatures(conn net.Conn, domain string) (*streamFeatures, error) {
+	header := fmt.Sprintf(`<?xml version='1.0'?><stream:stream to='%s' version='1.0' xmlns='jabber:client' xmlns:stream='%s'>`,
+		domain, nsStream)
+	if _, err := conn.Write([]byte(header)); err != nil {
+		return nil, err
+	}
+	dec := xml.NewDecoder(conn)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch {
+		case start.Name.Space == nsStream && start.Name.Local == "stream":
+			continue
+		case start.Name.Space == nsStream && start.Name.Local == "features":
+			var features streamFeatures
+			if err := dec.DecodeElement(&features, &start); err != nil {
+				return nil, err
+			}
+			return &features, nil
+		case start.Name.Space == nsStream && start.Name.Local == "error":
+			var streamErr struct {
+				Inner string `xml:",innerxml"`
+			}
+			_ = dec.DecodeElement(&streamErr, &start)
+			return nil, fmt.Errorf("stream error: %s", streamErr.Inner)
+		}
+		if err := dec.Skip(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func tlsCheck(state tls.ConnectionState, now time.Time) DiagnosticCheck {
+	check := DiagnosticCheck{Name: "TLS handshake", Detail: tls.VersionName(state.Version)}
+	if len(state.PeerCertificates) == 0 {
+		return check
+	}
+	cert := state.PeerCertificates[0]
+	left := cert.NotAfter.Sub(now)
+	check.Detail += ", certificate valid until " + cert.NotAfter.Format("2006-01-02")
+	if left < certExpiryWarning {
+		check.Status = CheckWarn
+		check.Detail += fmt.Sprintf(" (%d days left)", int(left.Hours()/24))
+	}
+	return check
+}
+
+// mechanismsCheck reports the offered SASL mechanisms; roster
+// authenticates with SCRAM or PLAIN, see sasl.go
+func mechanismsCheck(mechanisms []string) DiagnosticCheck {
+	check := DiagnosticCheck{Name: "SASL mechanisms", Detail: strings.Join(mechanisms, ", ")}
+	if len(mechanisms) == 0 {
+		check.Status = CheckFail
+		check.Detail = "none offered"
+		return check
+	}
+	for _, m := range mechanisms {
+		if SupportedMechanism(m) {
+			return check
+		}
+	}
+	check.Status = CheckFail
+	check.Detail += " (roster needs SCRAM or PLAIN)"
+	return check
+}
+
+// Diagnose runs the checks that need an authenticated session: server
+// features via service discovery, message carbons, the message archive,
+// HTTP upload and the server clock
+func (c *Client) Diagnose() []DiagnosticCheck {
+	c.mu.RLock()
+	if !c.connected {
+		c.mu.RUnlock()
+		return []DiagnosticCheck{{"Session", CheckFail, "not connected"}}
+	}
+	session := c.session
+	own := c.jid
+	c.mu.RUnlock()
+
+	checks := []DiagnosticCheck{{"Session", CheckPass, "authenticated and bound"}}
+
+	serverFeatures, err := c.discoFeatures(session, own.Domain())
+	if err != nil {
+		checks = append(checks, DiagnosticCheck{"Service discovery", CheckFail, err.Error()})
+	} else {
+		checks = append(checks, DiagnosticCheck{"Service discovery", CheckPass,
+			fmt.Sprintf("%d server features", len(serverFeatures))})
+		checks = append(checks, featureCheck("Message carbons", serverFeatures, nsCarbons,
+			"messages sent from other devices will not show up here"))
+	}
+
+	accountFeatures, err := c.discoFeatures(session, own.Bare().String())
+	if err != nil {
+		checks = append(checks, DiagnosticCheck{"Message archive", CheckWarn, err.Error()})
+	} else {
+		checks = append(checks, featureCheck("Message archive", accountFeatures, nsMAM,
+			"no history sync across devices or after being offline"))
+	}
+
+	if service, err := c.DiscoverUploadService(); err != nil {
+		checks = append(checks, DiagnosticCheck{"HTTP upload", CheckWarn, "not available; files cannot be sent"})
+	} else {
+		checks = append(checks, DiagnosticCheck{"HTTP upload", CheckPass, service})
+	}
+
+	return append(checks, c.clockCheck(session, own.Domain()))
+}
+
+// discoFeatures returns the features an entity announces
+func (c *Client) discoFeatures(session *xmp.Session, to string) (map[string]bool, error) {
+	target, err := jid.Parse(to)
+	if err != nil {
+		return nil, err
+	}
+	iq := stanza.NewIQ(stanza.IQGet)
+	iq.To = target
+	iq.Query, _ = xml.Marshal(disco.InfoQuery{})
+	resp, err := c.sendIQAndWait(session, iq, probeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	var info disco.InfoQuery
+	if err := xml.Unmarshal(resp.Query, &info); err != nil {
+		return nil, err
+	}
+	features := make(map[string]bool, len(info.Features))
+	for _, f := range info.Features {
+		features[f.Var] = true
+	}
+	return features, nil
+}
+
+func featureCheck(name string, features map[string]bool, ns, missing string) DiagnosticCheck {
+	if features[ns] {
+		return DiagnosticCheck{name, CheckPass, ns}
+	}
+	return DiagnosticCheck{name, CheckWarn, "not supported; " + missing}
+}
+
+// entityTime is a XEP-0202 time query and reply
+type entityTime struct {
+	XMLName xml.Name `xml:"urn:xmpp:time time"`
+	UTC     string   `xml:"utc,omitempty"`
+}
+
+// clockCheck compares the server clock (XEP-0202) with ours, allowing for
+// the round trip
+func (c *Client) clockCheck(session *xmp.Session, domain string) DiagnosticCheck {
+	target, err := jid.Parse(domain)
+	if err != nil {
+		return DiagnosticCheck{"Clock skew", CheckSkip, err.Error()}
+	}
+	iq := stanza.NewIQ(stanza.IQGet)
+	iq.To = target
+	iq.Query, _ = xml.Marshal(entityTime{})
+
+	sent := time.Now()
+	resp, err := c.sendIQAndWait(session, iq, probeTimeout)
+	if err != nil {
+		return DiagnosticCheck{"Clock skew", CheckSkip, "server does not report its time"}
+	}
+	var reply entityTime
+	if err := xml.Unmarshal(resp.Query, &reply); err != nil {
+		return DiagnosticCheck{"Clock skew", CheckSkip, "unreadable time reply"}
+	}
+	remote, err := time.Parse(time.RFC3339, reply.UTC)
+	if err != nil {
+		return DiagnosticCheck{"Clock skew", CheckSkip, "unreadable time reply"}
+	}
+	received := time.Now()
+	return skewCheck(remote, sent.Add(received.Sub(sent)/2))
+}
+
+func skewCheck(remote, local time.Time) DiagnosticCheck {
+	skew := remote.Sub(local)
+	if skew < 0 {
+		skew = -skew
+	}
+	detail := fmt.Sprintf("%s off the server clock", skew.Round(time.Second))
+	if skew > maxClockSkew {
+		return DiagnosticCheck{"Clock skew", CheckWarn, detail + "; message times may look wrong"}
+	}
+	return DiagnosticCheck{"Clock skew", CheckPass, detail}
+}
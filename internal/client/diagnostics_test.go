@@ -0,0 +1,47 @@
+package client
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProbeStreamReportsMissingSTARTTLSAndMechanism(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	go func() {
+		buf := make([]byte, 4096)
+		if _, err := serverConn.Read(buf); err != nil {
+			return
+		}
+		_, _ = serverConn.Write([]byte(`<stream:stream xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>` +
+			`<stream:features><mechanisms xmlns='urn:ietf:params:xml:ns:xmpp-sasl'>` +
+			`<mechanism>X-OAUTH2</mechanism></mechanisms></stream:features>`))
+	}()
+
+	checks := probeStream(clientConn, "example.com")
+	if len(checks) != 3 {
+		t.Fatalf("expected 3 checks, got %+v", checks)
+	}
+	if checks[0].Status != CheckPass {
+		t.Fatalf("expected the stream to open, got %+v", checks[0])
+	}
+	if checks[1].Name != "STARTTLS" || checks[1].Status != CheckFail {
+		t.Fatalf("expected STARTTLS to fail, got %+v", checks[1])
+	}
+	if checks[2].Status != CheckFail || checks[2].Detail != "X-OAUTH2 (roster needs SCRAM or PLAIN)" {
+		t.Fatalf("expected the missing mechanism to be reported, got %+v", checks[2])
+	}
+}
+
+func TestSkewCheck(t *testing.T) {
+	now := time.Now()
+	if c := skewCheck(now.Add(5*time.Second), now); c.Status != CheckPass {
+		t.Fatalf("expected a small skew to pass, got %+v", c)
+	}
+	if c := skewCheck(now.Add(-3*time.Minute), now); c.Status != CheckWarn {
+		t.Fatalf("expected a large skew to warn, got %+v", c)
+	}
+}
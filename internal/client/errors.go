@@ -0,0 +1,159 @@
+package client
+
+import (
+	"encoding/xml"
+	"errors"
+	"strings"
+)
+
+// Condition is a defined XMPP error condition, as carried by stream errors
+// (RFC 6120 4.9.3), SASL failures (6.5) and stanza errors (8.3.3). It is an
+// error itself so callers can match with errors.Is(err, ConditionConflict).
+type Condition string
+
+func (c Condition) Error() string { return string(c) }
+
+// Conditions the client and the UI act on
+const (
+	ConditionConflict              Condition = "conflict"
+	ConditionPolicyViolation       Condition = "policy-violation"
+	ConditionNotAuthorized         Condition = "not-authorized"
+	ConditionForbidden             Condition = "forbidden"
+	ConditionItemNotFound          Condition = "item-not-found"
+	ConditionServiceUnavailable    Condition = "service-unavailable"
+	ConditionFeatureNotImplemented Condition = "feature-not-implemented"
+	ConditionRemoteServerNotFound  Condition = "remote-server-not-found"
+	ConditionResourceConstraint    Condition = "resource-constraint"
+	ConditionNotAllowed            Condition = "not-allowed"
+	ConditionRegistrationRequired  Condition = "registration-required"
+	ConditionHostUnknown           Condition = "host-unknown"
+	ConditionSystemShutdown        Condition = "system-shutdown"
+	ConditionConnectionTimeout     Condition = "connection-timeout"
+	ConditionAccountDisabled       Condition = "account-disabled"
+	ConditionCredentialsExpired    Condition = "credentials-expired"
+	ConditionTemporaryAuthFailure  Condition = "temporary-auth-failure"
+	ConditionInvalidMechanism      Condition = "invalid-mechanism"
+	ConditionMechanismTooWeak      Condition = "mechanism-too-weak"
+	ConditionEncryptionRequired    Condition = "encryption-required"
+	ConditionMalformedRequest      Condition = "malformed-request"
+	ConditionIncorrectEncoding     Condition = "incorrect-encoding"
+)
+
+// AuthError is returned when the server refuses the credentials
+type AuthError struct {
+	Condition Condition // empty when the server gave none
+	Text      string
+}
+
+func (e *AuthError) Error() string {
+	msg := "sasl authentication failed"
+	if e.Condition != "" {
+		msg += ": " + string(e.Condition)
+	}
+	if e.Text != "" {
+		msg += ": " + e.Text
+	}
+	return msg
+}
+
+func (e *AuthError) Is(target error) bool {
+	c, ok := target.(Condition)
+	return ok && c == e.Condition
+}
+
+// TLSError is returned when the connection could not be encrypted: the
+// server refused STARTTLS or the handshake failed, for instance on an
+// untrusted certificate
+type TLSError struct {
+	Rejected bool // the server answered STARTTLS with a failure
+	Err      error
+}
+
+func (e *TLSError) Error() string {
+	if e.Rejected {
+		return "server rejected STARTTLS"
+	}
+	return "starttls handshake failed: " + e.Err.Error()
+}
+
+func (e *TLSError) Unwrap() error { return e.Err }
+
+// StreamError is sent by the server right before it closes the stream, for
+// instance when another client logs in with the same resource (conflict)
+type StreamError struct {
+	Condition Condition
+	Text      string
+}
+
+func (e *StreamError) Error() string {
+	msg := "stream error: " + string(e.Condition)
+	if e.Text != "" {
+		msg += ": " + e.Text
+	}
+	return msg
+}
+
+func (e *StreamError) Is(target error) bool {
+	c, ok := target.(Condition)
+	return ok && c == e.Condition
+}
+
+// Condition returns the defined condition of the error reply, or "" when
+// it carries none
+func (e *IQError) Condition() Condition {
+	return iqErrorCondition(e.Response)
+}
+
+func (e *IQError) Is(target error) bool {
+	c, ok := target.(Condition)
+	return ok && c != "" && c == e.Condition()
+}
+
+// ErrorCondition returns the XMPP error condition behind err, or "" when err
+// did not come from the server
+func ErrorCondition(err error) Condition {
+	var authErr *AuthError
+	if errors.As(err, &authErr) {
+		return authErr.Condition
+	}
+	var streamErr *StreamError
+	if errors.As(err, &streamErr) {
+		return streamErr.Condition
+	}
+	var iqErr *IQError
+	if errors.As(err, &iqErr) {
+		return iqErr.Condition()
+	}
+	var c Condition
+	if errors.As(err, &c) {
+		return c
+	}
+	return ""
+}
+
+// errorElement decodes the body of a stream error or SASL failure: one
+// element naming the condition and an optional text
+type errorElement struct {
+	Text     string `xml:"text"`
+	Children []struct {
+		XMLName xml.Name
+	} `xml:",any"`
+}
+
+func (e errorElement) condition() Condition {
+	for _, child := range e.Children {
+		if child.XMLName.Local != "text" {
+			return Condition(child.XMLName.Local)
+		}
+	}
+	return ""
+}
+
+// decodeStreamError reads a stream:error element
+func decodeStreamError(r stanzaReader, start *xml.StartElement) error {
+	var body errorElement
+	if err := r.DecodeElement(&body, start); err != nil {
+		return err
+	}
+	return &StreamError{Condition: body.condition(), Text: strings.TrimSpace(body.Text)}
+}
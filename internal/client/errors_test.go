@@ -0,0 +1,78 @@
+package client
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/meszmate/xmpp-go/stanza"
+	xmppxml "github.com/meszmate/xmpp-go/xml"
+)
+
+func TestNextStanzaReturnsStreamError(t *testing.T) {
+	input := testStreamHeader +
+		`<stream:error>` +
+		`<conflict xmlns="urn:ietf:params:xml:ns:xmpp-streams"/>` +
+		`<text xmlns="urn:ietf:params:xml:ns:xmpp-streams">Replaced by new connection</text>` +
+		`</stream:error>`
+	r := xmppxml.NewStreamReader(strings.NewReader(input))
+
+	if st, err := nextStanza(r); err != nil || st != nil {
+		t.Fatalf("expected the stream header to be skipped, got %v, %v", st, err)
+	}
+	_, err := nextStanza(r)
+	var streamErr *StreamError
+	if !errors.As(err, &streamErr) {
+		t.Fatalf("expected *StreamError, got %v", err)
+	}
+	if streamErr.Condition != ConditionConflict || streamErr.Text != "Replaced by new connection" {
+		t.Fatalf("unexpected stream error %+v", streamErr)
+	}
+	if !errors.Is(err, ConditionConflict) || errors.Is(err, ConditionPolicyViolation) {
+		t.Fatalf("errors.Is does not match the condition of %v", err)
+	}
+}
+
+func TestAuthErrorCondition(t *testing.T) {
+	input := `<failure xmlns="urn:ietf:params:xml:ns:xmpp-sasl">` +
+		`<not-authorized/><text xml:lang="en">Invalid username or password</text>` +
+		`</failure>`
+	var fail errorElement
+	if err := xml.Unmarshal([]byte(input), &fail); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	err := fmt.Errorf("xmpp negotiation failed: %w", &AuthError{Condition: fail.condition(), Text: fail.Text})
+
+	if got := ErrorCondition(err); got != ConditionNotAuthorized {
+		t.Fatalf("expected not-authorized, got %q", got)
+	}
+	if want := "sasl authentication failed: not-authorized: Invalid username or password"; !strings.HasSuffix(err.Error(), want) {
+		t.Fatalf("unexpected message %q", err.Error())
+	}
+}
+
+func TestIQErrorCondition(t *testing.T) {
+	resp := stanza.NewIQ(stanza.IQError)
+	resp.Error = &stanza.StanzaError{Type: "cancel"}
+	resp.Query = []byte(`<query xmlns="jabber:iq:roster"><error/></query>` +
+		`<error type="cancel"><policy-violation xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"/>` +
+		`<text xmlns="urn:ietf:params:xml:ns:xmpp-stanzas">Too many requests</text></error>`)
+	err := &IQError{Response: resp}
+
+	if got := err.Condition(); got != ConditionPolicyViolation {
+		t.Fatalf("expected policy-violation, got %q", got)
+	}
+	if !errors.Is(err, ConditionPolicyViolation) {
+		t.Fatalf("errors.Is should match the condition")
+	}
+	if got := err.Error(); got != "iq request failed: type=cancel, condition=policy-violation" {
+		t.Fatalf("unexpected message %q", got)
+	}
+
+	empty := &IQError{Response: stanza.NewIQ(stanza.IQError)}
+	if empty.Condition() != "" || errors.Is(empty, Condition("")) {
+		t.Fatalf("a reply without a condition should match none")
+	}
+}
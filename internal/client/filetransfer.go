@@ -0,0 +1,307 @@
+package client
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/meszmate/xmpp-go/jid"
+	"github.com/meszmate/xmpp-go/plugins/disco"
+	"github.com/meszmate/xmpp-go/plugins/filetransfer"
+	"github.com/meszmate/xmpp-go/plugins/ibb"
+	"github.com/meszmate/xmpp-go/plugins/jingle"
+	"github.com/meszmate/xmpp-go/stanza"
+)
+
+const (
+	nsHTTPUpload   = "urn:xmpp:http:upload:0"
+	ibbBlockSize   = 4096
+	jingleAnswerIn = 2 * time.Minute // how long the contact has to accept a file offer
+)
+
+// ErrNoUploadService is returned when the server offers no HTTP upload service
+var ErrNoUploadService = errors.New("no HTTP upload service")
+
+// ibbTransport is the XEP-0261 Jingle In-Band Bytestreams transport
+type ibbTransport struct {
+	XMLName   xml.Name `xml:"urn:xmpp:jingle:transports:ibb:1 transport"`
+	BlockSize int      `xml:"block-size,attr"`
+	SID       string   `xml:"sid,attr"`
+}
+
+// jingleTerminate ends a Jingle session with a reason condition
+type jingleTerminate struct {
+	XMLName xml.Name `xml:"urn:xmpp:jingle:1 jingle"`
+	Action  string   `xml:"action,attr"`
+	SID     string   `xml:"sid,attr"`
+	Reason  struct {
+		Inner string `xml:",innerxml"`
+	} `xml:"reason"`
+}
+
+// DiscoverUploadService finds the server's XEP-0363 HTTP upload component
+// via service discovery. The result is cached for the session.
+func (c *Client) DiscoverUploadService() (string, error) {
+	c.mu.RLock()
+	if !c.connected {
+		c.mu.RUnlock()
+		return "", fmt.Errorf("not connected")
+	}
+	session := c.session
+	cached, known := c.uploadService, c.uploadChecked
+	domain := c.jid.Domain()
+	c.mu.RUnlock()
+
+	if known {
+		if cached == "" {
+			return "", ErrNoUploadService
+		}
+		return cached, nil
+	}
+
+	candidates := []string{domain}
+	iq := stanza.NewIQ(stanza.IQGet)
+	iq.To, _ = jid.Parse(domain)
+	iq.Query, _ = xml.Marshal(disco.ItemsQuery{})
+	if resp, err := c.sendIQAndWait(session, iq, 10*time.Second); err == nil {
+		var items disco.ItemsQuery
+		if err := xml.Unmarshal(resp.Query, &items); err == nil {
+			for _, item := range items.Items {
+				candidates = append(candidates, item.JID)
+			}
+		}
+	}
+
+	service := ""
+	for _, candidate := range candidates {
+		target, err := jid.Parse(candidate)
+		if err != nil {
+			continue
+		}
+		iq := stanza.NewIQ(stanza.IQGet)
+		iq.To = target
+		iq.Query, _ = xml.Marshal(disco.InfoQuery{})
+		resp, err := c.sendIQAndWait(session, iq, 10*time.Second)
+		if err != nil {
+			continue
+		}
+		var info disco.InfoQuery
+		if err := xml.Unmarshal(resp.Query, &info); err != nil {
+			continue
+		}
+		for _, f := range info.Features {
+			if f.Var == nsHTTPUpload {
+				service = candidate
+				break
+			}
+		}
+		if service != "" {
+			break
+		}
+	}
+
+	c.mu.Lock()
+	c.uploadService, c.uploadChecked = service, true
+	c.mu.Unlock()
+
+	if service == "" {
+		return "", ErrNoUploadService
+	}
+	return service, nil
+}
+
+// handleJingle routes Jingle actions for sessions this client started and
+// for incoming calls.
+// It reports whether the IQ was consumed.
+func (c *Client) handleJingle(iq *stanza.IQ) bool {
+	var j jingle.Jingle
+	if err := xml.Unmarshal(iq.Query, &j); err != nil || j.XMLName.Space != "urn:xmpp:jingle:1" {
+		return false
+	}
+
+	c.mu.Lock()
+	ch, ok := c.jingleSessions[j.SID]
+	c.mu.Unlock()
+	if !ok {
+		return c.handleIncomingCall(iq, &j)
+	}
+
+	c.ackIQ(iq)
+	select {
+	case ch <- &j:
+	default:
+	}
+	return true
+}
+
+// SendFileInBand offers a file to a contact with Jingle File Transfer and
+// streams it over In-Band Bytestreams once accepted. It is the fallback for
+// servers without an HTTP upload service.
+func (c *Client) SendFileInBand(to, name string, size int64, r io.Reader) error {
+	c.mu.RLock()
+	if !c.connected {
+		c.mu.RUnlock()
+		return fmt.Errorf("not connected")
+	}
+	session := c.session
+	full := to
+	if !strings.Contains(to, "/") {
+		full = c.preferredResourceLocked(to)
+	}
+	own := c.jid.String()
+	c.mu.RUnlock()
+
+	if full == "" {
+		return fmt.Errorf("%s has no online client to send to", to)
+	}
+	peer, err := jid.Parse(full)
+	if err != nil {
+		return fmt.Errorf("invalid recipient: %w", err)
+	}
+
+	sid := stanza.GenerateID()
+	answers := make(chan *jingle.Jingle, 4)
+	c.mu.Lock()
+	if c.jingleSessions == nil {
+		c.jingleSessions = make(map[string]chan *jingle.Jingle)
+	}
+	c.jingleSessions[sid] = answers
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.jingleSessions, sid)
+		c.mu.Unlock()
+	}()
+
+	desc, err := xml.Marshal(filetransfer.Description{File: &filetransfer.File{Name: name, Size: size}})
+	if err != nil {
+		return err
+	}
+	transport, err := xml.Marshal(ibbTransport{BlockSize: ibbBlockSize, SID: sid})
+	if err != nil {
+		return err
+	}
+
+	initiate := stanza.NewIQ(stanza.IQSet)
+	initiate.To = peer
+	initiate.Query, err = xml.Marshal(jingle.Jingle{
+		Action:    jingle.ActionSessionInitiate,
+		Initiator: own,
+		SID:       sid,
+		Contents: []jingle.Content{{
+			Creator:     "initiator",
+			Name:        "file",
+			Senders:     "initiator",
+			Description: append(desc, transport...),
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := c.sendIQAndWait(session, initiate, 15*time.Second); err != nil {
+		return fmt.Errorf("file offer failed: %w", err)
+	}
+
+	blockSize := ibbBlockSize
+	select {
+	case answer := <-answers:
+		if answer.Action != jingle.ActionSessionAccept {
+			return fmt.Errorf("file offer declined")
+		}
+		if bs := acceptedBlockSize(answer); bs > 0 && bs < blockSize {
+			blockSize = bs
+		}
+	case <-time.After(jingleAnswerIn):
+		c.terminateJingle(peer, sid, "<timeout/>")
+		return fmt.Errorf("file offer was not answered")
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	}
+
+	if err := c.streamIBB(peer, sid, blockSize, r, answers); err != nil {
+		c.terminateJingle(peer, sid, "<failed-transport/>")
+		return err
+	}
+	c.terminateJingle(peer, sid, "<success/>")
+	return nil
+}
+
+// acceptedBlockSize returns the IBB block size the responder asked for
+func acceptedBlockSize(answer *jingle.Jingle) int {
+	for _, content := range answer.Contents {
+		var wrapper struct {
+			Transport ibbTransport `xml:"urn:xmpp:jingle:transports:ibb:1 transport"`
+		}
+		if err := xml.Unmarshal(append(append([]byte("<c>"), content.Description...), "</c>"...), &wrapper); err == nil {
+			return wrapper.Transport.BlockSize
+		}
+	}
+	return 0
+}
+
+// streamIBB sends the file as XEP-0047 data IQs, one block at a time
+func (c *Client) streamIBB(peer jid.JID, sid string, blockSize int, r io.Reader, answers <-chan *jingle.Jingle) error {
+	c.mu.RLock()
+	session := c.session
+	c.mu.RUnlock()
+
+	open := stanza.NewIQ(stanza.IQSet)
+	open.To = peer
+	open.Query, _ = xml.Marshal(ibb.Open{BlockSize: blockSize, SID: sid, Stanza: "iq"})
+	if _, err := c.sendIQAndWait(session, open, 30*time.Second); err != nil {
+		return fmt.Errorf("bytestream open failed: %w", err)
+	}
+
+	buf := make([]byte, blockSize)
+	var seq uint16
+	for {
+		select {
+		case answer := <-answers:
+			if answer.Action == jingle.ActionSessionTerminate {
+				return fmt.Errorf("transfer cancelled by contact")
+			}
+		default:
+		}
+
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			data := stanza.NewIQ(stanza.IQSet)
+			data.To = peer
+			data.Query, _ = xml.Marshal(ibb.Data{SID: sid, Seq: seq, Value: base64.StdEncoding.EncodeToString(buf[:n])})
+			if _, err := c.sendIQAndWait(session, data, 30*time.Second); err != nil {
+				return fmt.Errorf("sending data failed: %w", err)
+			}
+			seq++
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+
+	closeIQ := stanza.NewIQ(stanza.IQSet)
+	closeIQ.To = peer
+	closeIQ.Query, _ = xml.Marshal(ibb.Close{SID: sid})
+	_, err := c.sendIQAndWait(session, closeIQ, 30*time.Second)
+	return err
+}
+
+// terminateJingle ends a Jingle session with the given reason condition
+func (c *Client) terminateJingle(peer jid.JID, sid, condition string) {
+	c.mu.RLock()
+	session := c.session
+	c.mu.RUnlock()
+
+	term := jingleTerminate{Action: jingle.ActionSessionTerminate, SID: sid}
+	term.Reason.Inner = condition
+	iq := stanza.NewIQ(stanza.IQSet)
+	iq.To = peer
+	iq.Query, _ = xml.Marshal(term)
+	_, _ = c.sendIQAndWait(session, iq, 10*time.Second)
+}
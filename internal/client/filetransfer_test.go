@@ -0,0 +1,68 @@
+package client
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/meszmate/xmpp-go/plugins/jingle"
+	"github.com/meszmate/xmpp-go/stanza"
+)
+
+func TestPreferredResourceFollowsPresence(t *testing.T) {
+	c := &Client{}
+	presence := func(raw string) {
+		var p stanza.Presence
+		if err := xml.Unmarshal([]byte(raw), &p); err != nil {
+			t.Fatalf("unmarshal presence: %v", err)
+		}
+		c.trackOnlineResource(&p)
+	}
+
+	presence(`<presence from="alice@example.com/laptop"><priority>10</priority></presence>`)
+	presence(`<presence from="alice@example.com/phone"><priority>5</priority></presence>`)
+	if got := c.preferredResourceLocked("alice@example.com"); got != "alice@example.com/laptop" {
+		t.Fatalf("expected the highest priority resource, got %q", got)
+	}
+
+	// The client that came online last leaving keeps the other reachable
+	presence(`<presence from="alice@example.com/phone" type="unavailable"/>`)
+	if got := c.preferredResourceLocked("alice@example.com"); got != "alice@example.com/laptop" {
+		t.Fatalf("expected laptop to stay reachable, got %q", got)
+	}
+
+	presence(`<presence from="alice@example.com/laptop" type="unavailable"/>`)
+	if got := c.preferredResourceLocked("alice@example.com"); got != "" {
+		t.Fatalf("expected no resource once all went offline, got %q", got)
+	}
+}
+
+func TestHandleJingleRoutesKnownSessions(t *testing.T) {
+	answers := make(chan *jingle.Jingle, 1)
+	c := &Client{jingleSessions: map[string]chan *jingle.Jingle{"sid1": answers}}
+
+	accept := stanza.NewIQ(stanza.IQSet)
+	accept.Query = []byte(`<jingle xmlns="urn:xmpp:jingle:1" action="session-accept" sid="sid1">` +
+		`<content creator="initiator" name="file"><transport xmlns="urn:xmpp:jingle:transports:ibb:1" block-size="2048" sid="sid1"/></content>` +
+		`</jingle>`)
+	if !c.handleJingle(accept) {
+		t.Fatalf("expected jingle IQ for a known session to be handled")
+	}
+
+	select {
+	case got := <-answers:
+		if got.Action != jingle.ActionSessionAccept {
+			t.Fatalf("expected session-accept, got %q", got.Action)
+		}
+		if bs := acceptedBlockSize(got); bs != 2048 {
+			t.Fatalf("expected block size 2048, got %d", bs)
+		}
+	default:
+		t.Fatalf("expected answer to be delivered")
+	}
+
+	other := stanza.NewIQ(stanza.IQSet)
+	other.Query, _ = xml.Marshal(jingle.Jingle{Action: jingle.ActionSessionInitiate, SID: "unknown"})
+	if c.handleJingle(other) {
+		t.Fatalf("expected jingle IQ for an unknown session to be left alone")
+	}
+}
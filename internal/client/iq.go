@@ -0,0 +1,175 @@
+package client
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"time"
+
+	xmp "github.com/meszmate/xmpp-go"
+	"github.com/meszmate/xmpp-go/stanza"
+)
+
+// defaultIQTimeout is used when a request does not set its own timeout
+const defaultIQTimeout = 15 * time.Second
+
+// ErrIQTimeout is returned when no reply arrived within the request timeout
+var ErrIQTimeout = errors.New("iq request timed out")
+
+// ErrConnectionLost is returned to IQ requests still waiting for a reply
+// when the connection drops
+var ErrConnectionLost = errors.New("connection lost")
+
+// IQError is an error reply to an IQ request
+type IQError struct {
+	Response *stanza.IQ
+}
+
+func (e *IQError) Error() string {
+	return "iq request failed: " + parseIQErrorDetails(e.Response)
+}
+
+// iqOptions controls how an IQ request is waited for
+type iqOptions struct {
+	Timeout time.Duration // per attempt, defaultIQTimeout when zero
+	Retries int           // attempts resent after a timeout
+}
+
+func (o iqOptions) timeout() time.Duration {
+	if o.Timeout <= 0 {
+		return defaultIQTimeout
+	}
+	return o.Timeout
+}
+
+// clientContext returns a context that is done when either ctx or the client's
+// connection context is
+func (c *Client) clientContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	if c.ctx != nil {
+		stop := context.AfterFunc(c.ctx, cancel)
+		return ctx, func() {
+			stop()
+			cancel()
+		}
+	}
+	return ctx, cancel
+}
+
+// doIQ sends an IQ and waits for the reply routed by handleIQ. A timed out
+// attempt is resent with the same ID up to opts.Retries times, so a late
+// reply to an earlier attempt still completes the request. The pending
+// entry is always removed before returning. Requests fail with
+// ErrConnectionLost as soon as the session they were sent on goes away.
+func (c *Client) doIQ(ctx context.Context, session *xmp.Session, iq *stanza.IQ, opts iqOptions) (*stanza.IQ, error) {
+	if session == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+	if iq.ID == "" {
+		iq.ID = stanza.GenerateID()
+	}
+	ctx, cancel := c.clientContext(ctx)
+	defer cancel()
+
+	respCh := make(chan *stanza.IQ, 1)
+	c.mu.Lock()
+	if c.pendingIQs == nil {
+		c.pendingIQs = make(map[string]chan *stanza.IQ)
+	}
+	c.pendingIQs[iq.ID] = respCh
+	// A drop reported before the entry was added would leave it waiting
+	stale := !c.connected || c.session != session
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		if c.pendingIQs[iq.ID] == respCh {
+			delete(c.pendingIQs, iq.ID)
+		}
+		c.mu.Unlock()
+	}()
+	if stale {
+		return nil, ErrConnectionLost
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := c.sendQueued(ctx, session, iq, sendOptions{Priority: priorityIQ}); err != nil {
+			return nil, err
+		}
+
+		timer := time.NewTimer(opts.timeout())
+		select {
+		case resp, ok := <-respCh:
+			timer.Stop()
+			if !ok {
+				return nil, ErrConnectionLost
+			}
+			return checkIQResponse(resp)
+		case <-timer.C:
+			if attempt >= opts.Retries {
+				return nil, ErrIQTimeout
+			}
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// failPendingIQs wakes every request waiting for a reply with
+// ErrConnectionLost. Whoever removes an entry from pendingIQs owns its
+// channel, so a late reply routed by handleIQ cannot race the close.
+func (c *Client) failPendingIQs() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failPendingIQsLocked()
+}
+
+// failPendingIQsLocked is failPendingIQs for callers holding c.mu
+func (c *Client) failPendingIQsLocked() {
+	for id, ch := range c.pendingIQs {
+		delete(c.pendingIQs, id)
+		close(ch)
+	}
+}
+
+// checkIQResponse turns an error reply into an *IQError
+func checkIQResponse(resp *stanza.IQ) (*stanza.IQ, error) {
+	if resp == nil {
+		return nil, fmt.Errorf("empty iq response")
+	}
+	if resp.Type == stanza.IQError {
+		return nil, &IQError{Response: resp}
+	}
+	return resp, nil
+}
+
+// decodeIQ unmarshals the payload of an IQ result
+func decodeIQ[T any](resp *stanza.IQ) (T, error) {
+	var out T
+	if resp == nil || len(resp.Query) == 0 {
+		return out, fmt.Errorf("empty iq payload")
+	}
+	if err := xml.Unmarshal(resp.Query, &out); err != nil {
+		return out, fmt.Errorf("invalid iq payload: %w", err)
+	}
+	return out, nil
+}
+
+// requestIQ sends an IQ and decodes the payload of its result into T
+func requestIQ[T any](ctx context.Context, c *Client, session *xmp.Session, iq *stanza.IQ, opts iqOptions) (T, error) {
+	resp, err := c.doIQ(ctx, session, iq, opts)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return decodeIQ[T](resp)
+}
+
+// sendIQAndWait sends an IQ on the connection context with a single attempt
+func (c *Client) sendIQAndWait(session *xmp.Session, iq *stanza.IQ, timeout time.Duration) (*stanza.IQ, error) {
+	return c.doIQ(c.ctx, session, iq, iqOptions{Timeout: timeout})
+}
@@ -0,0 +1,224 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	xmp "github.com/meszmate/xmpp-go"
+	"github.com/meszmate/xmpp-go/plugins/roster"
+	"github.com/meszmate/xmpp-go/stanza"
+)
+
+func TestCheckIQResponseWrapsErrorReplies(t *testing.T) {
+	resp := stanza.NewIQ(stanza.IQError)
+	resp.Query = []byte(`<error type="cancel"><service-unavailable xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"/></error>`)
+
+	_, err := checkIQResponse(resp)
+	var iqErr *IQError
+	if !errors.As(err, &iqErr) {
+		t.Fatalf("expected *IQError, got %v", err)
+	}
+	if iqErr.Response != resp {
+		t.Fatalf("expected the error reply to be kept")
+	}
+
+	if _, err := checkIQResponse(nil); err == nil {
+		t.Fatalf("expected an error for a missing response")
+	}
+	if got, err := checkIQResponse(stanza.NewIQ(stanza.IQResult)); err != nil || got == nil {
+		t.Fatalf("expected a result to pass through, got %v, %v", got, err)
+	}
+}
+
+func TestDecodeIQ(t *testing.T) {
+	resp := stanza.NewIQ(stanza.IQResult)
+	resp.Query = []byte(`<query xmlns="jabber:iq:roster"><item jid="juliet@example.com" name="Juliet"/></query>`)
+
+	query, err := decodeIQ[roster.Query](resp)
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if len(query.Items) != 1 || query.Items[0].Name != "Juliet" {
+		t.Fatalf("unexpected roster items: %+v", query.Items)
+	}
+
+	if _, err := decodeIQ[roster.Query](stanza.NewIQ(stanza.IQResult)); err == nil {
+		t.Fatalf("expected an error for an empty payload")
+	}
+}
+
+func TestIQOptionsDefaultTimeout(t *testing.T) {
+	if got := (iqOptions{}).timeout(); got != defaultIQTimeout {
+		t.Fatalf("expected default timeout, got %v", got)
+	}
+}
+
+// startIQ sends an IQ in the background and waits until it is pending
+func startIQ(t *testing.T, c *Client, id string) <-chan error {
+	t.Helper()
+	c.mu.RLock()
+	session := c.session
+	c.mu.RUnlock()
+
+	result := make(chan error, 1)
+	go func() {
+		iq := stanza.NewIQ(stanza.IQGet)
+		iq.ID = id
+		_, err := c.doIQ(context.Background(), session, iq, iqOptions{Timeout: time.Minute})
+		result <- err
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.RLock()
+		_, pending := c.pendingIQs[id]
+		c.mu.RUnlock()
+		if pending {
+			return result
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("iq %s never became pending", id)
+	return nil
+}
+
+func waitIQ(t *testing.T, result <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(2 * time.Second):
+		t.Fatalf("iq request still waiting")
+		return nil
+	}
+}
+
+func TestPendingIQsFailWhenConnectionDrops(t *testing.T) {
+	c, _ := newStreamClient(t, nil)
+	first := startIQ(t, c, "a")
+	second := startIQ(t, c, "b")
+
+	c.handleDisconnect(io.EOF)
+
+	for _, result := range []<-chan error{first, second} {
+		if err := waitIQ(t, result); !errors.Is(err, ErrConnectionLost) {
+			t.Fatalf("expected ErrConnectionLost, got %v", err)
+		}
+	}
+	c.mu.RLock()
+	left := len(c.pendingIQs)
+	c.mu.RUnlock()
+	if left != 0 {
+		t.Fatalf("expected no pending IQs, %d left", left)
+	}
+}
+
+func TestPendingIQsFailOnExplicitDisconnect(t *testing.T) {
+	c, _ := newStreamClient(t, nil)
+	result := startIQ(t, c, "a")
+
+	if err := c.Disconnect(); err != nil {
+		t.Fatalf("disconnect: %v", err)
+	}
+	if err := waitIQ(t, result); !errors.Is(err, ErrConnectionLost) {
+		t.Fatalf("expected ErrConnectionLost, got %v", err)
+	}
+}
+
+func TestLateIQReplyAfterDropIsIgnored(t *testing.T) {
+	c, _ := newStreamClient(t, nil)
+	result := startIQ(t, c, "late")
+	c.handleDisconnect(io.EOF)
+	if err := waitIQ(t, result); !errors.Is(err, ErrConnectionLost) {
+		t.Fatalf("expected ErrConnectionLost, got %v", err)
+	}
+
+	// Must not send on the closed channel
+	reply := stanza.NewIQ(stanza.IQResult)
+	reply.ID = "late"
+	c.handleIQ(reply)
+}
+
+func TestIQReplyRoutedBeforeDropIsKept(t *testing.T) {
+	c, _ := newStreamClient(t, nil)
+	result := startIQ(t, c, "a")
+
+	reply := stanza.NewIQ(stanza.IQResult)
+	reply.ID = "a"
+	c.handleIQ(reply)
+	c.handleDisconnect(io.EOF)
+
+	if err := waitIQ(t, result); err != nil {
+		t.Fatalf("expected the routed reply, got %v", err)
+	}
+}
+
+func TestIQOnReplacedSessionFailsAtOnce(t *testing.T) {
+	c, trans := newStreamClient(t, nil)
+	old := c.session
+
+	// A reconnect swaps in a new session while a request still holds the old one
+	fresh, err := xmp.NewSession(context.Background(), newLimitedTransport(trans, maxStanzaSize))
+	if err != nil {
+		t.Fatalf("new session: %v", err)
+	}
+	c.mu.Lock()
+	c.session = fresh
+	c.mu.Unlock()
+
+	iq := stanza.NewIQ(stanza.IQGet)
+	start := time.Now()
+	if _, err := c.doIQ(context.Background(), old, iq, iqOptions{Timeout: time.Minute}); !errors.Is(err, ErrConnectionLost) {
+		t.Fatalf("expected ErrConnectionLost, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("request on a replaced session waited for its timeout")
+	}
+	c.mu.RLock()
+	left := len(c.pendingIQs)
+	c.mu.RUnlock()
+	if left != 0 {
+		t.Fatalf("expected no pending IQs, %d left", left)
+	}
+}
+
+func TestIQsRacingADropNeverHang(t *testing.T) {
+	c, _ := newStreamClient(t, nil)
+	c.mu.RLock()
+	session := c.session
+	c.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 32)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			iq := stanza.NewIQ(stanza.IQGet)
+			_, err := c.doIQ(context.Background(), session, iq, iqOptions{Timeout: time.Minute})
+			errs <- err
+		}()
+	}
+	c.handleDisconnect(io.EOF)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("requests racing the drop are still waiting")
+	}
+	close(errs)
+	for err := range errs {
+		if !errors.Is(err, ErrConnectionLost) && !errors.Is(err, errSendQueueClosed) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
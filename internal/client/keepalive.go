@@ -0,0 +1,118 @@
+package client
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	xmp "github.com/meszmate/xmpp-go"
+	"github.com/meszmate/xmpp-go/jid"
+	"github.com/meszmate/xmpp-go/plugins/ping"
+	"github.com/meszmate/xmpp-go/stanza"
+)
+
+const (
+	defaultKeepAliveInterval = 60 * time.Second
+	defaultPingInterval      = 5 * time.Minute
+	defaultPingTimeout       = 30 * time.Second
+)
+
+// keepAliveDuration resolves a configured keep-alive interval to its effective value.
+func keepAliveDuration(configured, fallback time.Duration) time.Duration {
+	if configured == 0 {
+		return fallback
+	}
+	if configured < 0 {
+		return 0
+	}
+	return configured
+}
+
+// keepAlive sends whitespace keepalives and server pings on the configured
+// intervals. A failed write or an unanswered ping tears the session down so
+// the disconnect handler can schedule a reconnect.
+func (c *Client) keepAlive(session *xmp.Session) {
+	var whitespaceC, pingC <-chan time.Time
+	if c.keepAliveInterval > 0 {
+		t := time.NewTicker(c.keepAliveInterval)
+		defer t.Stop()
+		whitespaceC = t.C
+	}
+	if c.pingInterval > 0 {
+		t := time.NewTicker(c.pingInterval)
+		defer t.Stop()
+		pingC = t.C
+	}
+	if whitespaceC == nil && pingC == nil {
+		return
+	}
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-whitespaceC:
+			if err := session.SendRaw(c.ctx, strings.NewReader(" ")); err != nil {
+				c.failConnection(session, fmt.Errorf("keepalive failed: %w", err))
+				return
+			}
+		case <-pingC:
+			if err := c.pingServer(session); err != nil {
+				c.failConnection(session, fmt.Errorf("server did not answer ping: %w", err))
+				return
+			}
+		}
+	}
+}
+
+// pingServer sends a XEP-0199 ping to the account's server.
+func (c *Client) pingServer(session *xmp.Session) error {
+	server, err := jid.Parse(c.jid.Domain())
+	if err != nil {
+		return err
+	}
+
+	iq := stanza.NewIQ(stanza.IQGet)
+	iq.ID = stanza.GenerateID()
+	iq.To = server
+	iq.Query, _ = xml.Marshal(&ping.Ping{})
+
+	timeout := c.pingTimeout
+	if timeout <= 0 {
+		timeout = defaultPingTimeout
+	}
+	start := time.Now()
+	_, err = c.sendIQAndWait(session, iq, timeout)
+	var iqErr *IQError
+	if err == nil || errors.As(err, &iqErr) {
+		// An error reply still proves the connection is alive.
+		c.traffic.pinged(time.Since(start))
+		return nil
+	}
+	return err
+}
+
+// failConnection reports a dead connection and closes the underlying session.
+func (c *Client) failConnection(session *xmp.Session, err error) {
+	c.handleDisconnect(err)
+	c.cancel()
+	_ = session.Close()
+}
+
+func (c *Client) handleDisconnect(err error) {
+	c.mu.Lock()
+	if !c.connected {
+		// Already reported (e.g. explicit Disconnect or dead-connection detection).
+		c.mu.Unlock()
+		return
+	}
+	c.connected = false
+	c.mu.Unlock()
+	c.failPendingIQs()
+
+	if c.onDisconnect != nil {
+		c.onDisconnect(err)
+	}
+}
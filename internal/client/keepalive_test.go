@@ -0,0 +1,34 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeepAliveDurationDefaultsAndDisable(t *testing.T) {
+	if got := keepAliveDuration(0, time.Minute); got != time.Minute {
+		t.Fatalf("expected default for zero, got %v", got)
+	}
+	if got := keepAliveDuration(-1, time.Minute); got != 0 {
+		t.Fatalf("expected negative value to disable, got %v", got)
+	}
+	if got := keepAliveDuration(10*time.Second, time.Minute); got != 10*time.Second {
+		t.Fatalf("expected configured value, got %v", got)
+	}
+}
+
+func TestHandleDisconnectReportsOnlyOnce(t *testing.T) {
+	c := &Client{connected: true}
+
+	calls := 0
+	c.onDisconnect = func(err error) {
+		calls++
+	}
+
+	c.handleDisconnect(nil)
+	c.handleDisconnect(nil)
+
+	if calls != 1 {
+		t.Fatalf("expected a single disconnect callback, got %d", calls)
+	}
+}
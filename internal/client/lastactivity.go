@@ -0,0 +1,57 @@
+package client
+
+import (
+	"encoding/xml"
+	"strconv"
+	"time"
+
+	"github.com/meszmate/xmpp-go/jid"
+	"github.com/meszmate/xmpp-go/plugins/lastactivity"
+	"github.com/meszmate/xmpp-go/stanza"
+)
+
+// SetLastActivityHandler sets how long the user has been idle, asked by
+// others with XEP-0012 last activity queries. The handler is given who asks;
+// when it or its result is nil the query is refused with
+// service-unavailable, as by a client without the feature.
+func (c *Client) SetLastActivityHandler(handler func(from jid.JID) *time.Duration) {
+	c.onLastActivity = handler
+}
+
+// handleLastActivityQuery answers a last activity query
+func (c *Client) handleLastActivityQuery(iq *stanza.IQ) bool {
+	var query lastactivity.Query
+	if err := xml.Unmarshal(iq.Query, &query); err != nil || query.XMLName.Space != "jabber:iq:last" {
+		return false
+	}
+
+	c.mu.RLock()
+	session := c.session
+	c.mu.RUnlock()
+	if session == nil {
+		return true
+	}
+
+	var idle *time.Duration
+	if c.onLastActivity != nil {
+		idle = c.onLastActivity(iq.From)
+	}
+	_ = c.sendAsync(session, lastActivityReply(iq, idle), sendOptions{Priority: priorityIQ})
+	return true
+}
+
+// lastActivityReply answers a last activity query with the idle time in
+// whole seconds, or refuses it when idle is nil
+func lastActivityReply(iq *stanza.IQ, idle *time.Duration) *stanza.IQ {
+	if idle == nil {
+		return iq.ErrorIQ(stanza.NewStanzaError(stanza.ErrorTypeCancel, stanza.ErrorServiceUnavailable, ""))
+	}
+	seconds := uint64(0)
+	if *idle > 0 {
+		seconds = uint64(*idle / time.Second)
+	}
+	reply := iq.ResultIQ()
+	// Zero seconds is meaningful, so the attribute is always written
+	reply.Query = []byte(`<query xmlns="jabber:iq:last" seconds="` + strconv.FormatUint(seconds, 10) + `"/>`)
+	return reply
+}
@@ -0,0 +1,45 @@
+package client
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/meszmate/xmpp-go/jid"
+	"github.com/meszmate/xmpp-go/stanza"
+)
+
+func TestLastActivityReply(t *testing.T) {
+	query := stanza.NewIQ(stanza.IQGet)
+	query.From = jid.MustParse("juliet@example.com/balcony")
+	query.Query = []byte(`<query xmlns="jabber:iq:last"/>`)
+
+	idle := 90*time.Second + 400*time.Millisecond
+	reply := lastActivityReply(query, &idle)
+	out, err := xml.Marshal(reply)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if reply.Type != stanza.IQResult || reply.ID != query.ID || reply.To.String() != "juliet@example.com/balcony" {
+		t.Fatalf("reply not addressed to the query: %s", out)
+	}
+	if !strings.Contains(string(out), `seconds="90"`) {
+		t.Fatalf("expected 90 idle seconds, got %s", out)
+	}
+
+	active := time.Duration(0)
+	out, _ = xml.Marshal(lastActivityReply(query, &active))
+	if !strings.Contains(string(out), `seconds="0"`) {
+		t.Fatalf("expected zero idle seconds to be written, got %s", out)
+	}
+
+	refusal := lastActivityReply(query, nil)
+	out, err = xml.Marshal(refusal)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if refusal.Type != stanza.IQError || !strings.Contains(string(out), "service-unavailable") || strings.Contains(string(out), "seconds") {
+		t.Fatalf("expected a bare service-unavailable refusal, got %s", out)
+	}
+}
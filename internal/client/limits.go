@@ -0,0 +1,146 @@
+package client
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	"github.com/meszmate/xmpp-go/stanza"
+	"github.com/meszmate/xmpp-go/transport"
+)
+
+// Limits that keep a hostile or broken server from exhausting memory
+const (
+	maxStanzaSize       = 1 << 20 // bytes read for a single top-level element
+	maxStanzaExtensions = 64      // payload elements in one message or presence
+)
+
+// ErrStanzaTooLarge is returned when the server sends an element larger
+// than maxStanzaSize. The connection is dropped, as the stream cannot be
+// resynchronised.
+var ErrStanzaTooLarge = fmt.Errorf("stanza exceeds %d bytes", maxStanzaSize)
+
+// limitedTransport counts the bytes read since the last stanza boundary and
+// fails the read once an element grows past the limit. The XML decoder
+// buffers ahead, so the count is approximate by up to one buffer.
+type limitedTransport struct {
+	transport.Transport
+	limit int64
+	read  atomic.Int64
+}
+
+func newLimitedTransport(trans transport.Transport, limit int64) *limitedTransport {
+	return &limitedTransport{Transport: trans, limit: limit}
+}
+
+func (t *limitedTransport) Read(p []byte) (int, error) {
+	if t.read.Load() > t.limit {
+		return 0, ErrStanzaTooLarge
+	}
+	n, err := t.Transport.Read(p)
+	t.read.Add(int64(n))
+	return n, err
+}
+
+// reset marks the start of a new top-level element
+func (t *limitedTransport) reset() {
+	t.read.Store(0)
+}
+
+// Conn exposes the underlying connection for read deadlines
+func (t *limitedTransport) Conn() net.Conn {
+	if connGetter, ok := t.Transport.(interface{ Conn() net.Conn }); ok {
+		return connGetter.Conn()
+	}
+	return nil
+}
+
+// resetStanzaLimit starts counting a new top-level element
+func (c *Client) resetStanzaLimit() {
+	if c.session == nil {
+		return
+	}
+	if lt, ok := c.session.Transport().(*limitedTransport); ok {
+		lt.reset()
+	}
+}
+
+// stanzaReader is the part of the stream reader used to read stanzas
+type stanzaReader interface {
+	Token() (xml.Token, error)
+	DecodeElement(v any, start *xml.StartElement) error
+	Skip() error
+}
+
+// errTooManyExtensions marks a stanza that was read but is not handled
+var errTooManyExtensions = errors.New("too many stanza extensions")
+
+// nextStanza reads the next top-level element and returns it as a
+// *stanza.Message, *stanza.Presence or *stanza.IQ. Stream-level and unknown
+// elements are skipped and reported as nil; a stream error is returned as a
+// *StreamError. A message or presence with more than maxStanzaExtensions
+// payloads is consumed and reported as errTooManyExtensions; the stream
+// stays usable after it.
+func nextStanza(r stanzaReader) (any, error) {
+	tok, err := r.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	start, ok := tok.(xml.StartElement)
+	if !ok {
+		return nil, nil
+	}
+
+	switch start.Name.Local {
+	case "message":
+		sanitizeEmptyJIDAttrs(&start)
+		var msg stanza.Message
+		if err := r.DecodeElement(&msg, &start); err != nil {
+			return nil, err
+		}
+		if len(msg.Extensions) > maxStanzaExtensions {
+			return nil, errTooManyExtensions
+		}
+		return &msg, nil
+
+	case "presence":
+		sanitizeEmptyJIDAttrs(&start)
+		var p stanza.Presence
+		if err := r.DecodeElement(&p, &start); err != nil {
+			return nil, err
+		}
+		if len(p.Extensions) > maxStanzaExtensions {
+			return nil, errTooManyExtensions
+		}
+		return &p, nil
+
+	case "iq":
+		sanitizeEmptyJIDAttrs(&start)
+		var iq stanza.IQ
+		if err := r.DecodeElement(&iq, &start); err != nil {
+			return nil, err
+		}
+		return &iq, nil
+	}
+
+	// Ignore stream-level elements (stream root/features/proceed/success/etc).
+	if start.Name.Space == nsStream {
+		switch start.Name.Local {
+		case "stream":
+			return nil, nil
+		case "error":
+			// The server closes the stream after it
+			return nil, decodeStreamError(r, &start)
+		}
+	}
+	return nil, r.Skip()
+}
+
+func (c *Client) emitError(err error) {
+	if c.onError != nil {
+		c.onError(err)
+	}
+}
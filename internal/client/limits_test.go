@@ -0,0 +1,174 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	xmp "github.com/meszmate/xmpp-go"
+	"github.com/meszmate/xmpp-go/stanza"
+	xmppxml "github.com/meszmate/xmpp-go/xml"
+)
+
+const testStreamHeader = `<stream:stream xmlns="jabber:client" xmlns:stream="http://etherx.jabber.org/streams" from="example.com" version="1.0">`
+
+// fakeTransport replays server input and discards everything written
+type fakeTransport struct {
+	r io.Reader
+}
+
+func (t *fakeTransport) Read(p []byte) (int, error)  { return t.r.Read(p) }
+func (t *fakeTransport) Write(p []byte) (int, error) { return len(p), nil }
+func (t *fakeTransport) Close() error                { return nil }
+func (t *fakeTransport) StartTLS(*tls.Config) error  { return errors.New("no tls in tests") }
+func (t *fakeTransport) ConnectionState() (tls.ConnectionState, bool) {
+	return tls.ConnectionState{}, false
+}
+func (t *fakeTransport) Peer() net.Addr         { return nil }
+func (t *fakeTransport) LocalAddress() net.Addr { return nil }
+
+// newStreamClient returns a connected client whose server sends input
+func newStreamClient(t *testing.T, input []byte) (*Client, *fakeTransport) {
+	t.Helper()
+	c, err := NewClient(ClientConfig{JID: "romeo@example.com/orchard", Password: "secret"})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	trans := &fakeTransport{r: bytes.NewReader(input)}
+	session, err := xmp.NewSession(context.Background(), newLimitedTransport(trans, maxStanzaSize))
+	if err != nil {
+		t.Fatalf("new session: %v", err)
+	}
+	c.session = session
+	c.connected = true
+	t.Cleanup(c.cancel)
+	return c, trans
+}
+
+func TestNextStanzaDecodesTopLevelElements(t *testing.T) {
+	input := testStreamHeader +
+		`<stream:features/>` +
+		`<message from="juliet@example.com/balcony" type="chat"><body>hi</body></message>` +
+		`<presence from="juliet@example.com/balcony"><show>away</show></presence>` +
+		`<iq from="example.com" type="result" id="1"/>` +
+		`</stream:stream>`
+	r := xmppxml.NewStreamReader(strings.NewReader(input))
+
+	var got []string
+	for {
+		st, err := nextStanza(r)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		switch st := st.(type) {
+		case *stanza.Message:
+			got = append(got, "message:"+st.Body)
+		case *stanza.Presence:
+			got = append(got, "presence:"+st.Show)
+		case *stanza.IQ:
+			got = append(got, "iq:"+st.ID)
+		}
+	}
+
+	want := []string{"message:hi", "presence:away", "iq:1"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestNextStanzaDropsStanzasWithTooManyExtensions(t *testing.T) {
+	payload := strings.Repeat(`<x xmlns="urn:example"/>`, maxStanzaExtensions+1)
+	input := testStreamHeader +
+		`<message from="juliet@example.com">` + payload + `</message>` +
+		`<message from="juliet@example.com"><body>after</body></message>`
+	r := xmppxml.NewStreamReader(strings.NewReader(input))
+
+	// The stream header is skipped first
+	if st, err := nextStanza(r); err != nil || st != nil {
+		t.Fatalf("expected the stream header to be skipped, got %v, %v", st, err)
+	}
+	if _, err := nextStanza(r); !errors.Is(err, errTooManyExtensions) {
+		t.Fatalf("expected errTooManyExtensions, got %v", err)
+	}
+	st, err := nextStanza(r)
+	if err != nil {
+		t.Fatalf("expected the stream to stay usable, got %v", err)
+	}
+	if msg, ok := st.(*stanza.Message); !ok || msg.Body != "after" {
+		t.Fatalf("expected the following message, got %#v", st)
+	}
+}
+
+func TestLimitedTransportRejectsOversizedStanza(t *testing.T) {
+	const limit = 1024
+	body := strings.Repeat("a", 8*limit)
+	input := testStreamHeader + `<message><body>` + body + `</body></message>`
+	lt := newLimitedTransport(&fakeTransport{r: strings.NewReader(input)}, limit)
+	r := xmppxml.NewStreamReader(lt)
+
+	var err error
+	for err == nil {
+		lt.reset()
+		_, err = nextStanza(r)
+	}
+	if !errors.Is(err, ErrStanzaTooLarge) {
+		t.Fatalf("expected ErrStanzaTooLarge, got %v", err)
+	}
+}
+
+func TestServeDisconnectsOnOversizedStanza(t *testing.T) {
+	body := strings.Repeat("a", 2*maxStanzaSize)
+	c, _ := newStreamClient(t, []byte(testStreamHeader+`<message><body>`+body+`</body></message>`))
+
+	var disconnectErr error
+	c.onDisconnect = func(err error) {
+		disconnectErr = err
+	}
+	c.serve()
+
+	if !errors.Is(disconnectErr, ErrStanzaTooLarge) {
+		t.Fatalf("expected ErrStanzaTooLarge, got %v", disconnectErr)
+	}
+}
+
+func FuzzServe(f *testing.F) {
+	f.Add([]byte(`<message from="juliet@example.com/balcony" type="chat" id="m1"><body>hi</body><request xmlns="urn:xmpp:receipts"/></message>`))
+	f.Add([]byte(`<presence from="juliet@example.com/balcony"><show>xa</show><status>away</status><priority>5</priority></presence>`))
+	f.Add([]byte(`<presence from="room@muc.example.com/nick"><x xmlns="http://jabber.org/protocol/muc#user"><item affiliation="member" role="participant"/></x></presence>`))
+	f.Add([]byte(`<iq from="example.com" type="set" id="push"><query xmlns="jabber:iq:roster"><item jid="nurse@example.com" subscription="both"/></query></iq>`))
+	f.Add([]byte(`<iq from="juliet@example.com/balcony" type="get" id="v"><query xmlns="jabber:iq:version"/></iq>`))
+	f.Add([]byte(`<message from="romeo@example.com"><sent xmlns="urn:xmpp:carbons:2"><forwarded xmlns="urn:xmpp:forward:0"><message from="romeo@example.com/a" to="juliet@example.com"><body>x</body></message></forwarded></sent></message>`))
+	f.Add([]byte(`<message from="juliet@example.com/balcony" to="romeo@example.com" type="chat"><propose xmlns="urn:xmpp:jingle-message:0" id="c1"><description xmlns="urn:xmpp:jingle:apps:rtp:1" media="audio"/></propose></message>`))
+	f.Add([]byte(`<message from="juliet@example.com"><body>`))
+	f.Add([]byte(`<iq from="" to="" type="result" id=""/>`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		input := append([]byte(testStreamHeader), data...)
+		c, _ := newStreamClient(t, input)
+		c.serve()
+	})
+}
+
+func FuzzStreamNegotiation(f *testing.F) {
+	f.Add([]byte(`<stream:features><mechanisms xmlns="urn:ietf:params:xml:ns:xmpp-sasl"><mechanism>PLAIN</mechanism></mechanisms></stream:features><success xmlns="urn:ietf:params:xml:ns:xmpp-sasl"/>` + testStreamHeader + `<stream:features><bind xmlns="urn:ietf:params:xml:ns:xmpp-bind"/></stream:features>`))
+	f.Add([]byte(`<stream:features><starttls xmlns="urn:ietf:params:xml:ns:xmpp-tls"/></stream:features><proceed xmlns="urn:ietf:params:xml:ns:xmpp-tls"/>`))
+	f.Add([]byte(`<stream:features><mechanisms xmlns="urn:ietf:params:xml:ns:xmpp-sasl"><mechanism>PLAIN</mechanism></mechanisms></stream:features><failure xmlns="urn:ietf:params:xml:ns:xmpp-sasl"><not-authorized/><text>bad</text></failure>`))
+	f.Add([]byte(`<stream:features><mechanisms xmlns="urn:ietf:params:xml:ns:xmpp-sasl"><mechanism>SCRAM-SHA-256</mechanism></mechanisms></stream:features><challenge xmlns="urn:ietf:params:xml:ns:xmpp-sasl">cj1mb28scz1iYXIsaT0x</challenge><success xmlns="urn:ietf:params:xml:ns:xmpp-sasl">dj1iYWQ=</success>`))
+	f.Add([]byte(`<stream:error><host-unknown xmlns="urn:ietf:params:xml:ns:xmpp-streams"/></stream:error>`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		input := append([]byte(testStreamHeader), data...)
+		c, trans := newStreamClient(t, input)
+		c.connected = false
+		// Negotiation must end with an error or success, never hang or panic
+		_ = c.negotiateClientSession(context.Background(), trans)
+	})
+}
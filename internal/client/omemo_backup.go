@@ -0,0 +1,190 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"database/sql"
+	"fmt"
+	"time"
+
+	cryptoomemo "github.com/meszmate/xmpp-go/crypto/omemo"
+)
+
+// OMEMOBackup is the portable form of an account's OMEMO state: identity
+// keys, device ID, pre-keys, known remote devices with their trust decisions
+// and established sessions.
+type OMEMOBackup struct {
+	JID             string               `json:"jid"`
+	DeviceID        uint32               `json:"device_id"`
+	IdentityPrivate []byte               `json:"identity_private"`
+	IdentityPublic  []byte               `json:"identity_public"`
+	PreKeys         []BackupPreKey       `json:"prekeys"`
+	SignedPreKeys   []BackupSignedPreKey `json:"signed_prekeys"`
+	RemoteDevices   []BackupRemoteDevice `json:"remote_devices"`
+	Sessions        []BackupSession      `json:"sessions"`
+	CreatedAt       time.Time            `json:"created_at"`
+}
+
+type BackupPreKey struct {
+	ID         uint32 `json:"id"`
+	PrivateKey []byte `json:"private_key"`
+	PublicKey  []byte `json:"public_key"`
+}
+
+type BackupSignedPreKey struct {
+	ID         uint32 `json:"id"`
+	PrivateKey []byte `json:"private_key"`
+	PublicKey  []byte `json:"public_key"`
+	Signature  []byte `json:"signature"`
+}
+
+type BackupRemoteDevice struct {
+	JID         string `json:"jid"`
+	DeviceID    uint32 `json:"device_id"`
+	IdentityKey []byte `json:"identity_key"`
+	TrustLevel  int    `json:"trust_level"`
+}
+
+type BackupSession struct {
+	JID      string `json:"jid"`
+	DeviceID uint32 `json:"device_id"`
+	Data     []byte `json:"data"`
+}
+
+// Export snapshots the store into a backup.
+func (s *OMEMOStore) Export() (*OMEMOBackup, error) {
+	s.mu.RLock()
+	if s.identityKey == nil {
+		s.mu.RUnlock()
+		return nil, fmt.Errorf("no OMEMO identity to export")
+	}
+
+	b := &OMEMOBackup{
+		JID:             s.jid,
+		DeviceID:        s.deviceID,
+		IdentityPrivate: append([]byte(nil), s.identityKey.PrivateKey...),
+		IdentityPublic:  append([]byte(nil), s.identityKey.PublicKey...),
+		CreatedAt:       time.Now(),
+	}
+	for _, pk := range s.preKeys {
+		b.PreKeys = append(b.PreKeys, BackupPreKey{ID: pk.ID, PrivateKey: pk.PrivateKey, PublicKey: pk.PublicKey})
+	}
+	for _, spk := range s.signedPreKeys {
+		b.SignedPreKeys = append(b.SignedPreKeys, BackupSignedPreKey{
+			ID:         spk.ID,
+			PrivateKey: spk.PrivateKey,
+			PublicKey:  spk.PublicKey,
+			Signature:  spk.Signature,
+		})
+	}
+	remote := make([]cryptoomemo.Address, 0, len(s.remoteKeys))
+	for addr, key := range s.remoteKeys {
+		b.RemoteDevices = append(b.RemoteDevices, BackupRemoteDevice{
+			JID:         addr.JID,
+			DeviceID:    addr.DeviceID,
+			IdentityKey: []byte(key),
+		})
+		remote = append(remote, addr)
+	}
+	for addr, data := range s.sessions {
+		b.Sessions = append(b.Sessions, BackupSession{
+			JID:      addr.JID,
+			DeviceID: addr.DeviceID,
+			Data:     append([]byte(nil), data...),
+		})
+	}
+	s.mu.RUnlock()
+
+	// Trust lookups take the read lock themselves.
+	for i, addr := range remote {
+		b.RemoteDevices[i].TrustLevel = s.GetTrustLevel(addr.JID, addr.DeviceID)
+	}
+
+	return b, nil
+}
+
+// Import replaces the local identity and pre-keys with those from a backup
+// and merges its remote devices, trust decisions and sessions into the
+// store.
+func (s *OMEMOStore) Import(b *OMEMOBackup) error {
+	if b == nil || len(b.IdentityPrivate) != ed25519.PrivateKeySize || len(b.IdentityPublic) != ed25519.PublicKeySize {
+		return fmt.Errorf("backup does not contain a valid identity key")
+	}
+
+	// Pre-keys are kept per account, and those of the replaced identity are
+	// signed by it
+	s.mu.Lock()
+	s.deviceID = b.DeviceID
+	s.preKeys = make(map[uint32]*cryptoomemo.PreKeyRecord)
+	s.signedPreKeys = make(map[uint32]*cryptoomemo.SignedPreKeyRecord)
+	s.mu.Unlock()
+	if s.db != nil {
+		if _, err := s.db.Exec(`DELETE FROM omemo_prekeys WHERE jid = ?`, s.jid); err != nil {
+			return fmt.Errorf("failed to clear pre-keys: %w", err)
+		}
+		if _, err := s.db.Exec(`DELETE FROM omemo_signed_prekeys WHERE jid = ?`, s.jid); err != nil {
+			return fmt.Errorf("failed to clear signed pre-keys: %w", err)
+		}
+	}
+
+	if err := s.SaveIdentityKeyPair(&cryptoomemo.IdentityKeyPair{
+		PrivateKey: ed25519.PrivateKey(b.IdentityPrivate),
+		PublicKey:  ed25519.PublicKey(b.IdentityPublic),
+	}); err != nil {
+		return fmt.Errorf("failed to save identity: %w", err)
+	}
+	for _, pk := range b.PreKeys {
+		if err := s.SavePreKey(&cryptoomemo.PreKeyRecord{ID: pk.ID, PrivateKey: pk.PrivateKey, PublicKey: pk.PublicKey}); err != nil {
+			return fmt.Errorf("failed to save pre-key %d: %w", pk.ID, err)
+		}
+	}
+	for _, spk := range b.SignedPreKeys {
+		if err := s.SaveSignedPreKey(&cryptoomemo.SignedPreKeyRecord{
+			ID:         spk.ID,
+			PrivateKey: spk.PrivateKey,
+			PublicKey:  spk.PublicKey,
+			Signature:  spk.Signature,
+		}); err != nil {
+			return fmt.Errorf("failed to save signed pre-key %d: %w", spk.ID, err)
+		}
+	}
+	for _, dev := range b.RemoteDevices {
+		addr := cryptoomemo.Address{JID: dev.JID, DeviceID: dev.DeviceID}
+		if err := s.SaveRemoteIdentity(addr, ed25519.PublicKey(dev.IdentityKey)); err != nil {
+			return fmt.Errorf("failed to save identity for %s/%d: %w", dev.JID, dev.DeviceID, err)
+		}
+		if err := s.SetTrustLevel(dev.JID, dev.DeviceID, dev.TrustLevel); err != nil {
+			return fmt.Errorf("failed to restore trust for %s/%d: %w", dev.JID, dev.DeviceID, err)
+		}
+	}
+	for _, sess := range b.Sessions {
+		addr := cryptoomemo.Address{JID: sess.JID, DeviceID: sess.DeviceID}
+		if err := s.SaveSession(addr, sess.Data); err != nil {
+			return fmt.Errorf("failed to save session for %s/%d: %w", sess.JID, sess.DeviceID, err)
+		}
+	}
+	return nil
+}
+
+// ExportOMEMOBackup snapshots the live OMEMO state of the client.
+func (c *Client) ExportOMEMOBackup() (*OMEMOBackup, error) {
+	store := c.OMEMOStore()
+	if store == nil {
+		return nil, fmt.Errorf("OMEMO is not initialized")
+	}
+	b, err := store.Export()
+	if err != nil {
+		return nil, err
+	}
+	b.JID = c.jid.Bare().String()
+	return b, nil
+}
+
+// ImportOMEMOBackup restores a backup into the OMEMO state an account keeps
+// in db. The account must be offline: its next connection takes the device
+// ID from the database, so the restored device is the one it announces.
+func ImportOMEMOBackup(db *sql.DB, accountJID string, b *OMEMOBackup) error {
+	if b.JID != "" && b.JID != accountJID {
+		return fmt.Errorf("backup belongs to %s, not %s", b.JID, accountJID)
+	}
+	return NewOMEMOStoreWithDB(accountJID, b.DeviceID, db).Import(b)
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"testing"
+
+	"github.com/meszmate/roster/internal/storage/sqlite"
+	cryptoomemo "github.com/meszmate/xmpp-go/crypto/omemo"
+)
+
+func TestOMEMOBackupRoundTrip(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	src := NewOMEMOStore("alice@example.com", 1234)
+	if err := src.SaveIdentityKeyPair(&cryptoomemo.IdentityKeyPair{PrivateKey: priv, PublicKey: pub}); err != nil {
+		t.Fatalf("SaveIdentityKeyPair returned error: %v", err)
+	}
+	remote := cryptoomemo.Address{JID: "bob@example.com", DeviceID: 42}
+	_ = src.SaveRemoteIdentity(remote, pub)
+	_ = src.SaveSession(remote, []byte("session-state"))
+
+	backup, err := src.Export()
+	if err != nil {
+		t.Fatalf("Export returned error: %v", err)
+	}
+	data, err := json.Marshal(backup)
+	if err != nil {
+		t.Fatalf("marshal backup: %v", err)
+	}
+	var restored OMEMOBackup
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatalf("unmarshal backup: %v", err)
+	}
+
+	dst := NewOMEMOStore("alice@example.com", 9999)
+	if err := dst.Import(&restored); err != nil {
+		t.Fatalf("Import returned error: %v", err)
+	}
+	if id, _ := dst.GetLocalDeviceID(); id != 1234 {
+		t.Fatalf("expected device id 1234, got %d", id)
+	}
+	if dst.GetFingerprint() != src.GetFingerprint() {
+		t.Fatalf("identity fingerprint was not restored")
+	}
+	session, err := dst.GetSession(remote)
+	if err != nil || string(session) != "session-state" {
+		t.Fatalf("session was not restored: %q, %v", session, err)
+	}
+}
+
+func TestImportOMEMOBackupReplacesStoredDevice(t *testing.T) {
+	db, err := sqlite.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer db.Close()
+
+	old := NewOMEMOStoreWithDB("alice@example.com", 9999, db.SQL())
+	if _, err := cryptoomemo.NewManager(old).GenerateBundle(5); err != nil {
+		t.Fatalf("generate bundle: %v", err)
+	}
+
+	src := NewOMEMOStore("alice@example.com", 1234)
+	if _, err := cryptoomemo.NewManager(src).GenerateBundle(5); err != nil {
+		t.Fatalf("generate bundle: %v", err)
+	}
+	backup, err := src.Export()
+	if err != nil {
+		t.Fatalf("Export returned error: %v", err)
+	}
+
+	if err := ImportOMEMOBackup(db.SQL(), "bob@example.com", backup); err == nil {
+		t.Fatal("expected a backup of another account to be refused")
+	}
+	if err := ImportOMEMOBackup(db.SQL(), "alice@example.com", backup); err != nil {
+		t.Fatalf("ImportOMEMOBackup returned error: %v", err)
+	}
+
+	// The next connection comes up as the restored device, with only its
+	// own pre-keys
+	id := StoredDeviceID(db.SQL(), "alice@example.com")
+	if id != 1234 {
+		t.Fatalf("expected device 1234 to be stored, got %d", id)
+	}
+	next := NewOMEMOStoreWithDB("alice@example.com", id, db.SQL())
+	if !next.hasKeys() || next.GetFingerprint() != src.GetFingerprint() {
+		t.Fatal("expected the restored identity and keys to be loaded")
+	}
+	if len(next.preKeys) != len(src.preKeys) || len(next.signedPreKeys) != len(src.signedPreKeys) {
+		t.Fatalf("expected only the restored pre-keys, got %d and %d signed", len(next.preKeys), len(next.signedPreKeys))
+	}
+}
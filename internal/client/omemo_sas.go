@@ -0,0 +1,102 @@
+package client
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"fmt"
+
+	cryptoomemo "github.com/meszmate/xmpp-go/crypto/omemo"
+)
+
+// sasInfo separates short authentication strings from other hashes of the
+// same keys
+const sasInfo = "roster OMEMO SAS v1"
+
+// sasEmoji are the symbols of a short authentication string, each with a
+// name to read out loud. Same set and order as the Matrix SAS emoji.
+var sasEmoji = [64][2]string{
+	{"🐶", "Dog"}, {"🐱", "Cat"}, {"🦁", "Lion"}, {"🐎", "Horse"},
+	{"🦄", "Unicorn"}, {"🐷", "Pig"}, {"🐘", "Elephant"}, {"🐰", "Rabbit"},
+	{"🐼", "Panda"}, {"🐓", "Rooster"}, {"🐧", "Penguin"}, {"🐢", "Turtle"},
+	{"🐟", "Fish"}, {"🐙", "Octopus"}, {"🦋", "Butterfly"}, {"🌷", "Flower"},
+	{"🌳", "Tree"}, {"🌵", "Cactus"}, {"🍄", "Mushroom"}, {"🌏", "Globe"},
+	{"🌙", "Moon"}, {"☁️", "Cloud"}, {"🔥", "Fire"}, {"🍌", "Banana"},
+	{"🍎", "Apple"}, {"🍓", "Strawberry"}, {"🌽", "Corn"}, {"🍕", "Pizza"},
+	{"🎂", "Cake"}, {"❤️", "Heart"}, {"😀", "Smiley"}, {"🤖", "Robot"},
+	{"🎩", "Hat"}, {"👓", "Glasses"}, {"🔧", "Spanner"}, {"🎅", "Santa"},
+	{"👍", "Thumbs Up"}, {"☂️", "Umbrella"}, {"⌛", "Hourglass"}, {"⏰", "Clock"},
+	{"🎁", "Gift"}, {"💡", "Light Bulb"}, {"📕", "Book"}, {"✏️", "Pencil"},
+	{"📎", "Paperclip"}, {"✂️", "Scissors"}, {"🔒", "Lock"}, {"🔑", "Key"},
+	{"🔨", "Hammer"}, {"☎️", "Telephone"}, {"🏁", "Flag"}, {"🚂", "Train"},
+	{"🚲", "Bicycle"}, {"✈️", "Aeroplane"}, {"🚀", "Rocket"}, {"🏆", "Trophy"},
+	{"⚽", "Ball"}, {"🎸", "Guitar"}, {"🎺", "Trumpet"}, {"🔔", "Bell"},
+	{"⚓", "Anchor"}, {"🎧", "Headphones"}, {"📁", "Folder"}, {"📌", "Pin"},
+}
+
+// SAS is a short authentication string: the same short sequence on both
+// ends when, and only when, both see the same pair of identity keys
+type SAS struct {
+	Emoji   []string // symbol and name, like "🐶 Dog"
+	Numbers [3]int   // each between 1000 and 9191
+}
+
+// String formats the numbers the way they are read out
+func (s SAS) String() string {
+	return fmt.Sprintf("%d %d %d", s.Numbers[0], s.Numbers[1], s.Numbers[2])
+}
+
+// ShortAuthString derives the SAS of two identity keys. The keys are
+// ordered before hashing, so both parties get the same result.
+func ShortAuthString(a, b ed25519.PublicKey) SAS {
+	if bytes.Compare(a, b) > 0 {
+		a, b = b, a
+	}
+	h := sha256.New()
+	h.Write([]byte(sasInfo))
+	h.Write(a)
+	h.Write(b)
+	r := bitReader{data: h.Sum(nil)}
+
+	var sas SAS
+	for i := 0; i < 7; i++ {
+		e := sasEmoji[r.next(6)]
+		sas.Emoji = append(sas.Emoji, e[0]+" "+e[1])
+	}
+	for i := range sas.Numbers {
+		sas.Numbers[i] = 1000 + r.next(13)
+	}
+	return sas
+}
+
+// ShortAuthString derives the SAS between our identity key and the key of
+// a contact's device
+func (s *OMEMOStore) ShortAuthString(jid string, deviceID uint32) (SAS, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.identityKey == nil {
+		return SAS{}, fmt.Errorf("no local identity key")
+	}
+	key, ok := s.remoteKeys[cryptoomemo.Address{JID: jid, DeviceID: deviceID}]
+	if !ok {
+		return SAS{}, fmt.Errorf("no identity key for device %d of %s", deviceID, jid)
+	}
+	return ShortAuthString(s.identityKey.PublicKey, key), nil
+}
+
+// bitReader reads a byte slice most significant bit first
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *bitReader) next(n int) int {
+	v := 0
+	for i := 0; i < n; i++ {
+		bit := r.data[r.pos/8] >> (7 - r.pos%8) & 1
+		v = v<<1 | int(bit)
+		r.pos++
+	}
+	return v
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"testing"
+
+	cryptoomemo "github.com/meszmate/xmpp-go/crypto/omemo"
+)
+
+func TestShortAuthStringIsSymmetric(t *testing.T) {
+	keyA, _, _ := ed25519.GenerateKey(nil)
+	keyB, _, _ := ed25519.GenerateKey(nil)
+	keyC, _, _ := ed25519.GenerateKey(nil)
+
+	ab := ShortAuthString(keyA, keyB)
+	ba := ShortAuthString(keyB, keyA)
+	if ab.String() != ba.String() || len(ab.Emoji) != 7 {
+		t.Fatalf("expected both sides to derive the same SAS, got %v and %v", ab, ba)
+	}
+	for i := range ab.Emoji {
+		if ab.Emoji[i] != ba.Emoji[i] {
+			t.Fatalf("emoji differ at %d: %q vs %q", i, ab.Emoji[i], ba.Emoji[i])
+		}
+	}
+	for _, n := range ab.Numbers {
+		if n < 1000 || n > 9191 {
+			t.Fatalf("number out of range: %d", n)
+		}
+	}
+
+	if ac := ShortAuthString(keyA, keyC); ac.String() == ab.String() {
+		t.Fatalf("expected a different SAS for a different key, got %v", ac)
+	}
+}
+
+func TestOMEMOStoreShortAuthString(t *testing.T) {
+	s := NewOMEMOStore("alice@example.com", 1)
+
+	own, priv, _ := ed25519.GenerateKey(nil)
+	remote, _, _ := ed25519.GenerateKey(nil)
+	addr := cryptoomemo.Address{JID: "bob@example.com", DeviceID: 7}
+
+	if _, err := s.ShortAuthString(addr.JID, addr.DeviceID); err == nil {
+		t.Fatal("expected an error without a local identity key")
+	}
+	_ = s.SaveIdentityKeyPair(&cryptoomemo.IdentityKeyPair{PrivateKey: priv, PublicKey: own})
+	if _, err := s.ShortAuthString(addr.JID, addr.DeviceID); err == nil {
+		t.Fatal("expected an error for an unknown device")
+	}
+
+	_ = s.SaveRemoteIdentity(addr, remote)
+	sas, err := s.ShortAuthString(addr.JID, addr.DeviceID)
+	if err != nil {
+		t.Fatalf("ShortAuthString: %v", err)
+	}
+	if want := ShortAuthString(remote, own); sas.String() != want.String() {
+		t.Fatalf("expected %v, got %v", want, sas)
+	}
+}
@@ -23,6 +23,9 @@ type OMEMOStore struct {
 	preKeys       map[uint32]*cryptoomemo.PreKeyRecord
 	signedPreKeys map[uint32]*cryptoomemo.SignedPreKeyRecord
 	sessions      map[cryptoomemo.Address][]byte
+	trust         map[cryptoomemo.Address]int
+
+	onIdentity func(addr cryptoomemo.Address, key ed25519.PublicKey, changed bool)
 }
 
 func NewOMEMOStore(jid string, deviceID uint32) *OMEMOStore {
@@ -33,6 +36,7 @@ func NewOMEMOStore(jid string, deviceID uint32) *OMEMOStore {
 		preKeys:       make(map[uint32]*cryptoomemo.PreKeyRecord),
 		signedPreKeys: make(map[uint32]*cryptoomemo.SignedPreKeyRecord),
 		sessions:      make(map[cryptoomemo.Address][]byte),
+		trust:         make(map[cryptoomemo.Address]int),
 	}
 }
 
@@ -45,6 +49,7 @@ func NewOMEMOStoreWithDB(jid string, deviceID uint32, db *sql.DB) *OMEMOStore {
 		preKeys:       make(map[uint32]*cryptoomemo.PreKeyRecord),
 		signedPreKeys: make(map[uint32]*cryptoomemo.SignedPreKeyRecord),
 		sessions:      make(map[cryptoomemo.Address][]byte),
+		trust:         make(map[cryptoomemo.Address]int),
 	}
 	s.loadFromDB()
 	return s
@@ -70,7 +75,7 @@ func (s *OMEMOStore) loadFromDB() {
 	}
 
 	rows, err := s.db.Query(`
-		SELECT jid, device_id, identity_key FROM omemo_remote_identities 
+		SELECT jid, device_id, identity_key, trust_level FROM omemo_remote_identities 
 		WHERE account_jid = ?`, s.jid)
 	if err == nil {
 		defer rows.Close()
@@ -78,9 +83,11 @@ func (s *OMEMOStore) loadFromDB() {
 			var jid string
 			var deviceID uint32
 			var identityKey []byte
-			if err := rows.Scan(&jid, &deviceID, &identityKey); err == nil {
+			var trust int
+			if err := rows.Scan(&jid, &deviceID, &identityKey, &trust); err == nil {
 				addr := cryptoomemo.Address{JID: jid, DeviceID: deviceID}
 				s.remoteKeys[addr] = ed25519.PublicKey(identityKey)
+				s.trust[addr] = trust
 			}
 		}
 	}
@@ -139,6 +146,27 @@ func (s *OMEMOStore) loadFromDB() {
 	}
 }
 
+// StoredDeviceID returns the OMEMO device ID an account had in db, or 0
+// when it has none yet
+func StoredDeviceID(db *sql.DB, jid string) uint32 {
+	var deviceID uint32
+	err := db.QueryRow(`
+		SELECT device_id FROM omemo_identity
+		WHERE jid = ? ORDER BY created_at DESC, rowid DESC LIMIT 1`, jid).Scan(&deviceID)
+	if err != nil {
+		return 0
+	}
+	return deviceID
+}
+
+// hasKeys reports whether the store holds the keys of a bundle, which a
+// store loaded from the database does after the first run
+func (s *OMEMOStore) hasKeys() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.identityKey != nil && len(s.signedPreKeys) > 0 && len(s.preKeys) > 0
+}
+
 func (s *OMEMOStore) GetIdentityKeyPair() (*cryptoomemo.IdentityKeyPair, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -172,16 +200,41 @@ func (s *OMEMOStore) GetRemoteIdentity(addr cryptoomemo.Address) (ed25519.Public
 	return s.remoteKeys[addr], nil
 }
 
+// SetIdentityHandler registers a callback for remote devices that appear for
+// the first time or come back with a different identity key.
+func (s *OMEMOStore) SetIdentityHandler(handler func(addr cryptoomemo.Address, key ed25519.PublicKey, changed bool)) {
+	s.mu.Lock()
+	s.onIdentity = handler
+	s.mu.Unlock()
+}
+
 func (s *OMEMOStore) SaveRemoteIdentity(addr cryptoomemo.Address, key ed25519.PublicKey) error {
 	s.mu.Lock()
+	existing, known := s.remoteKeys[addr]
 	s.remoteKeys[addr] = key
+	if known && !bytes.Equal(existing, key) {
+		// The trust was in the old key; the new one is undecided, as in
+		// the database below
+		delete(s.trust, addr)
+	}
+	handler := s.onIdentity
 	s.mu.Unlock()
 
+	if handler != nil && (!known || !bytes.Equal(existing, key)) {
+		handler(addr, key, known)
+	}
+
 	if s.db != nil {
+		// A device keeps its trust and first sighting while its key stays
+		// the same; a new key has to be trusted again
 		_, err := s.db.Exec(`
-			INSERT OR REPLACE INTO omemo_remote_identities (account_jid, jid, device_id, identity_key, trust_level, first_seen)
-			VALUES (?, ?, ?, ?, 1, ?)`,
-			s.jid, addr.JID, addr.DeviceID, []byte(key), time.Now().Unix())
+			INSERT INTO omemo_remote_identities (account_jid, jid, device_id, identity_key, trust_level, first_seen)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (account_jid, jid, device_id) DO UPDATE SET
+				trust_level = CASE WHEN identity_key = excluded.identity_key THEN trust_level ELSE excluded.trust_level END,
+				first_seen = CASE WHEN identity_key = excluded.identity_key THEN first_seen ELSE excluded.first_seen END,
+				identity_key = excluded.identity_key`,
+			s.jid, addr.JID, addr.DeviceID, []byte(key), TrustUndecided, time.Now().Unix())
 		return err
 	}
 	return nil
@@ -361,6 +414,9 @@ func (s *OMEMOStore) GetRemoteIdentitiesForJID(jid string) []RemoteIdentity {
 	for addr, key := range s.remoteKeys {
 		if addr.JID == jid {
 			trustLevel := TrustUndecided
+			if trust, ok := s.trust[addr]; ok {
+				trustLevel = trust
+			}
 			if s.db != nil {
 				var trust int
 				err := s.db.QueryRow(`
@@ -385,6 +441,8 @@ func (s *OMEMOStore) SetTrustLevel(jid string, deviceID uint32, level int) error
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	s.trust[cryptoomemo.Address{JID: jid, DeviceID: deviceID}] = level
+
 	if s.db != nil {
 		_, err := s.db.Exec(`
 			UPDATE omemo_remote_identities 
@@ -410,6 +468,9 @@ func (s *OMEMOStore) GetTrustLevel(jid string, deviceID uint32) int {
 			return trust
 		}
 	}
+	if trust, ok := s.trust[cryptoomemo.Address{JID: jid, DeviceID: deviceID}]; ok {
+		return trust
+	}
 	return TrustUndecided
 }
 
@@ -420,6 +481,7 @@ func (s *OMEMOStore) DeleteDevice(jid string, deviceID uint32) error {
 	addr := cryptoomemo.Address{JID: jid, DeviceID: deviceID}
 	delete(s.remoteKeys, addr)
 	delete(s.sessions, addr)
+	delete(s.trust, addr)
 
 	if s.db != nil {
 		_, err := s.db.Exec(`
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/meszmate/roster/internal/storage/sqlite"
+	cryptoomemo "github.com/meszmate/xmpp-go/crypto/omemo"
+)
+
+func TestOMEMOStoreReportsNewAndChangedIdentities(t *testing.T) {
+	s := NewOMEMOStore("alice@example.com", 1)
+
+	type seen struct {
+		deviceID uint32
+		changed  bool
+	}
+	var events []seen
+	s.SetIdentityHandler(func(addr cryptoomemo.Address, key ed25519.PublicKey, changed bool) {
+		events = append(events, seen{addr.DeviceID, changed})
+	})
+
+	addr := cryptoomemo.Address{JID: "bob@example.com", DeviceID: 7}
+	keyA, _, _ := ed25519.GenerateKey(nil)
+	keyB, _, _ := ed25519.GenerateKey(nil)
+
+	_ = s.SaveRemoteIdentity(addr, keyA)
+	_ = s.SaveRemoteIdentity(addr, keyA)
+	_ = s.SaveRemoteIdentity(addr, keyB)
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 identity events, got %d", len(events))
+	}
+	if events[0].changed || !events[1].changed {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+}
+
+func TestOMEMOStoreTrustLevelWithoutDB(t *testing.T) {
+	s := NewOMEMOStore("alice@example.com", 1)
+
+	if got := s.GetTrustLevel("bob@example.com", 7); got != TrustUndecided {
+		t.Fatalf("expected undecided trust, got %d", got)
+	}
+	if err := s.SetTrustLevel("bob@example.com", 7, TrustVerified); err != nil {
+		t.Fatalf("SetTrustLevel returned error: %v", err)
+	}
+	if got := s.GetTrustLevel("bob@example.com", 7); got != TrustVerified {
+		t.Fatalf("expected verified trust, got %d", got)
+	}
+}
+
+func TestOMEMOStoreChangedIdentityLosesTrustWithoutDB(t *testing.T) {
+	s := NewOMEMOStore("alice@example.com", 1)
+	addr := cryptoomemo.Address{JID: "bob@example.com", DeviceID: 7}
+	key, _, _ := ed25519.GenerateKey(nil)
+	_ = s.SaveRemoteIdentity(addr, key)
+	_ = s.SetTrustLevel(addr.JID, addr.DeviceID, TrustVerified)
+
+	// The same key keeps its trust, a new one starts undecided
+	_ = s.SaveRemoteIdentity(addr, key)
+	if got := s.GetTrustLevel(addr.JID, addr.DeviceID); got != TrustVerified {
+		t.Fatalf("expected trust to survive, got %d", got)
+	}
+	changed, _, _ := ed25519.GenerateKey(nil)
+	_ = s.SaveRemoteIdentity(addr, changed)
+	if got := s.GetTrustLevel(addr.JID, addr.DeviceID); got != TrustUndecided {
+		t.Fatalf("expected a changed key to be undecided, got %d", got)
+	}
+}
+
+func TestOMEMOStorePersistsAcrossRuns(t *testing.T) {
+	db, err := sqlite.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer db.Close()
+
+	first := NewOMEMOStoreWithDB("alice@example.com", 42, db.SQL())
+	if first.hasKeys() {
+		t.Fatal("a new store should have no keys")
+	}
+	if _, err := cryptoomemo.NewManager(first).GenerateBundle(5); err != nil {
+		t.Fatalf("generate bundle: %v", err)
+	}
+	addr := cryptoomemo.Address{JID: "bob@example.com", DeviceID: 7}
+	key, _, _ := ed25519.GenerateKey(nil)
+	_ = first.SaveRemoteIdentity(addr, key)
+	_ = first.SetTrustLevel(addr.JID, addr.DeviceID, TrustVerified)
+	_ = first.SaveSession(addr, []byte("session"))
+
+	if got := StoredDeviceID(db.SQL(), "alice@example.com"); got != 42 {
+		t.Fatalf("expected device 42 to be stored, got %d", got)
+	}
+
+	second := NewOMEMOStoreWithDB("alice@example.com", 42, db.SQL())
+	if !second.hasKeys() || second.GetFingerprint() != first.GetFingerprint() {
+		t.Fatal("expected the identity and keys to be loaded")
+	}
+	if ok, _ := second.ContainsSession(addr); !ok {
+		t.Fatal("expected the session to be loaded")
+	}
+
+	// The same key keeps its trust, a new one starts undecided
+	_ = second.SaveRemoteIdentity(addr, key)
+	if got := second.GetTrustLevel(addr.JID, addr.DeviceID); got != TrustVerified {
+		t.Fatalf("expected trust to survive, got %d", got)
+	}
+	changed, _, _ := ed25519.GenerateKey(nil)
+	_ = second.SaveRemoteIdentity(addr, changed)
+	if got := second.GetTrustLevel(addr.JID, addr.DeviceID); got != TrustUndecided {
+		t.Fatalf("expected a changed key to be undecided, got %d", got)
+	}
+}
@@ -0,0 +1,200 @@
+package client
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/meszmate/xmpp-go/jid"
+	"github.com/meszmate/xmpp-go/plugins/ping"
+	"github.com/meszmate/xmpp-go/stanza"
+)
+
+// capsNS is the namespace of the XEP-0115 element in presence
+const capsNS = "http://jabber.org/protocol/caps"
+
+// contactPingTimeout bounds how long a ping to a contact waits; a client on
+// a phone can take a while to wake up
+const contactPingTimeout = 15 * time.Second
+
+// Resource is one online client of a contact, as last announced in its
+// presence
+type Resource struct {
+	JID      string // full JID
+	Resource string
+	Show     string
+	Status   string
+	Priority int
+	CapsNode string // XEP-0115 node, identifies the client software
+}
+
+// ClientName names the software of the resource from its caps node, e.g.
+// "Conversations", or "" when it did not announce one
+func (r Resource) ClientName() string {
+	return capsClientName(r.CapsNode)
+}
+
+// knownCapsNodes maps the caps nodes of common clients to their names
+var knownCapsNodes = map[string]string{
+	"conversations.im":           "Conversations",
+	"gajim.org":                  "Gajim",
+	"dino.im":                    "Dino",
+	"monal.im":                   "Monal",
+	"monal-im.org":               "Monal",
+	"psi-im.org":                 "Psi",
+	"pidgin.im":                  "Pidgin",
+	"poez.io":                    "Poezio",
+	"profanity-im.github.io":     "Profanity",
+	"profanity.im":               "Profanity",
+	"siskin.im":                  "Siskin IM",
+	"movim.eu":                   "Movim",
+	"cheogram.com":               "Cheogram",
+	"blabber.im":                 "blabber.im",
+	"kaidan.im":                  "Kaidan",
+	"conversejs.org":             "Converse",
+	"github.com/meszmate/roster": "roster",
+	"snikket.org":                "Snikket",
+	"quicksy.im":                 "Quicksy",
+	"beagle.im":                  "Beagle IM",
+	"jabber.pix-art.de":          "Pix-Art Messenger",
+	"mcabber.com":                "mcabber",
+	"swift.im":                   "Swift",
+}
+
+// capsClientName turns a caps node such as "http://gajim.org" into a client
+// name. Unknown nodes are shown by their host.
+func capsClientName(node string) string {
+	if node == "" {
+		return ""
+	}
+	key := node
+	if i := strings.Index(key, "://"); i >= 0 {
+		key = key[i+3:]
+	}
+	key = strings.TrimSuffix(strings.TrimPrefix(key, "www."), "/")
+	if name, ok := knownCapsNodes[key]; ok {
+		return name
+	}
+	host := key
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+	if name, ok := knownCapsNodes[host]; ok {
+		return name
+	}
+	return host
+}
+
+// trackOnlineResource keeps every available resource of a contact with its
+// priority and caps node, for listing them in contact details
+func (c *Client) trackOnlineResource(p *stanza.Presence) {
+	if p.From.IsZero() || p.From.Resource() == "" {
+		return
+	}
+	bare := p.From.Bare().String()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch p.Type {
+	case "":
+		if c.onlineResources == nil {
+			c.onlineResources = make(map[string]map[string]Resource)
+		}
+		if c.onlineResources[bare] == nil {
+			c.onlineResources[bare] = make(map[string]Resource)
+		}
+		c.onlineResources[bare][p.From.Resource()] = Resource{
+			JID:      p.From.String(),
+			Resource: p.From.Resource(),
+			Show:     p.Show,
+			Status:   p.Status,
+			Priority: int(p.Priority),
+			CapsNode: presenceCapsNode(p),
+		}
+	case "unavailable":
+		delete(c.onlineResources[bare], p.From.Resource())
+		if len(c.onlineResources[bare]) == 0 {
+			delete(c.onlineResources, bare)
+		}
+	}
+}
+
+// presenceCapsNode returns the XEP-0115 node a presence carries, if any
+func presenceCapsNode(p *stanza.Presence) string {
+	for _, ext := range p.Extensions {
+		if ext.XMLName.Space != capsNS || ext.XMLName.Local != "c" {
+			continue
+		}
+		for _, attr := range ext.Attrs {
+			if attr.Name.Local == "node" {
+				return attr.Value
+			}
+		}
+	}
+	return ""
+}
+
+// Resources returns the online clients of a contact, the one messages go
+// to first: highest priority, then by name
+func (c *Client) Resources(bareJID string) []Resource {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.resourcesLocked(bareJID)
+}
+
+func (c *Client) resourcesLocked(bareJID string) []Resource {
+	list := make([]Resource, 0, len(c.onlineResources[bareJID]))
+	for _, r := range c.onlineResources[bareJID] {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Priority != list[j].Priority {
+			return list[i].Priority > list[j].Priority
+		}
+		return list[i].Resource < list[j].Resource
+	})
+	return list
+}
+
+// preferredResourceLocked returns the full JID of the client of a contact
+// that requests addressed to a full JID go to, or "" when none is online.
+// The caller holds c.mu.
+func (c *Client) preferredResourceLocked(bareJID string) string {
+	if list := c.resourcesLocked(bareJID); len(list) > 0 {
+		return list[0].JID
+	}
+	return ""
+}
+
+// Ping sends a XEP-0199 ping to an entity and returns the round trip time.
+// An error reply still came back from the other end, so its time is returned
+// along with the *IQError; only a timeout means no response.
+func (c *Client) Ping(to string) (time.Duration, error) {
+	c.mu.RLock()
+	if !c.connected {
+		c.mu.RUnlock()
+		return 0, fmt.Errorf("not connected")
+	}
+	session := c.session
+	c.mu.RUnlock()
+
+	target, err := jid.Parse(to)
+	if err != nil {
+		return 0, fmt.Errorf("invalid JID: %w", err)
+	}
+	iq := stanza.NewIQ(stanza.IQGet)
+	iq.To = target
+	iq.Query, _ = xml.Marshal(&ping.Ping{})
+
+	start := time.Now()
+	_, err = c.sendIQAndWait(session, iq, contactPingTimeout)
+	rtt := time.Since(start)
+	var iqErr *IQError
+	if err != nil && !errors.As(err, &iqErr) {
+		return 0, err
+	}
+	return rtt, err
+}
@@ -0,0 +1,64 @@
+package client
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/meszmate/xmpp-go/stanza"
+)
+
+func TestTrackOnlineResourceKeepsEveryClient(t *testing.T) {
+	c := &Client{}
+	presences := []string{
+		`<presence from="alice@example.com/phone"><priority>5</priority>` +
+			`<c xmlns="http://jabber.org/protocol/caps" hash="sha-1" node="http://conversations.im" ver="abc="/></presence>`,
+		`<presence from="alice@example.com/laptop"><show>away</show><priority>10</priority>` +
+			`<c xmlns="http://jabber.org/protocol/caps" hash="sha-1" node="https://gajim.org" ver="def="/></presence>`,
+		`<presence from="alice@example.com/bot"><priority>10</priority></presence>`,
+	}
+	for _, raw := range presences {
+		var p stanza.Presence
+		if err := xml.Unmarshal([]byte(raw), &p); err != nil {
+			t.Fatalf("unmarshal presence: %v", err)
+		}
+		c.trackOnlineResource(&p)
+	}
+
+	got := c.Resources("alice@example.com")
+	if len(got) != 3 {
+		t.Fatalf("expected 3 resources, got %+v", got)
+	}
+	if got[0].Resource != "bot" || got[1].Resource != "laptop" || got[2].Resource != "phone" {
+		t.Fatalf("expected priority then name order, got %+v", got)
+	}
+	if got[1].Show != "away" || got[1].ClientName() != "Gajim" || got[2].ClientName() != "Conversations" {
+		t.Fatalf("unexpected resource details %+v", got)
+	}
+	if got[0].ClientName() != "" {
+		t.Fatalf("expected no client name without caps, got %q", got[0].ClientName())
+	}
+
+	var gone stanza.Presence
+	if err := xml.Unmarshal([]byte(`<presence from="alice@example.com/laptop" type="unavailable"/>`), &gone); err != nil {
+		t.Fatalf("unmarshal presence: %v", err)
+	}
+	c.trackOnlineResource(&gone)
+	if got := c.Resources("alice@example.com"); len(got) != 2 {
+		t.Fatalf("expected laptop to be forgotten, got %+v", got)
+	}
+}
+
+func TestCapsClientName(t *testing.T) {
+	tests := map[string]string{
+		"":                           "",
+		"http://conversations.im":    "Conversations",
+		"https://www.gajim.org/":     "Gajim",
+		"https://dino.im":            "Dino",
+		"https://example.org/client": "example.org",
+	}
+	for node, want := range tests {
+		if got := capsClientName(node); got != want {
+			t.Errorf("capsClientName(%q) = %q, want %q", node, got, want)
+		}
+	}
+}
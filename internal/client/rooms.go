@@ -0,0 +1,214 @@
+package client
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/meszmate/xmpp-go/jid"
+	"github.com/meszmate/xmpp-go/plugins/disco"
+	"github.com/meszmate/xmpp-go/plugins/form"
+	"github.com/meszmate/xmpp-go/stanza"
+)
+
+const (
+	roomInfoForm    = "http://jabber.org/protocol/muc#roominfo"
+	roomInfoWorkers = 8 // rooms whose disco#info is requested at once
+	roomIQTimeout   = 10 * time.Second
+)
+
+// ErrNoMUCService is returned when the server offers no group chat service
+var ErrNoMUCService = errors.New("no group chat service")
+
+// RoomInfo describes a room listed by a MUC service
+type RoomInfo struct {
+	JID         string
+	Name        string
+	Description string
+	Occupants   int // -1 when the room does not say
+	Password    bool
+	MembersOnly bool
+}
+
+// roomInfoReply is a disco#info result with the XEP-0128 extension forms
+// rooms use to publish their description and occupant count
+type roomInfoReply struct {
+	XMLName    xml.Name         `xml:"http://jabber.org/protocol/disco#info query"`
+	Identities []disco.Identity `xml:"identity"`
+	Features   []disco.Feature  `xml:"feature"`
+	Forms      []form.Form      `xml:"jabber:x:data x"`
+}
+
+// DiscoverMUCService finds the group chat component of the account's
+// server: the first disco#items entry with a conference/text identity
+func (c *Client) DiscoverMUCService(ctx context.Context) (string, error) {
+	c.mu.RLock()
+	session, connected := c.session, c.connected
+	domain := c.jid.Domain()
+	c.mu.RUnlock()
+	if !connected {
+		return "", fmt.Errorf("not connected")
+	}
+
+	target, err := jid.Parse(domain)
+	if err != nil {
+		return "", err
+	}
+	iq := stanza.NewIQ(stanza.IQGet)
+	iq.To = target
+	iq.Query, _ = xml.Marshal(disco.ItemsQuery{})
+	items, err := requestIQ[disco.ItemsQuery](ctx, c, session, iq, iqOptions{Timeout: roomIQTimeout})
+	if err != nil {
+		return "", err
+	}
+
+	for _, item := range items.Items {
+		target, err := jid.Parse(item.JID)
+		if err != nil {
+			continue
+		}
+		iq := stanza.NewIQ(stanza.IQGet)
+		iq.To = target
+		iq.Query, _ = xml.Marshal(disco.InfoQuery{})
+		info, err := requestIQ[disco.InfoQuery](ctx, c, session, iq, iqOptions{Timeout: roomIQTimeout})
+		if err != nil {
+			continue
+		}
+		for _, id := range info.Identities {
+			if id.Category == "conference" && id.Type == "text" {
+				return item.JID, nil
+			}
+		}
+	}
+	return "", ErrNoMUCService
+}
+
+// ListRooms lists the public rooms of a MUC service with disco#items and
+// asks each room for its description and occupant count with disco#info.
+// Rooms that do not answer are still listed, by name only. The result is
+// sorted by occupants, busiest first.
+func (c *Client) ListRooms(ctx context.Context, service string) ([]RoomInfo, error) {
+	c.mu.RLock()
+	session, connected := c.session, c.connected
+	c.mu.RUnlock()
+	if !connected {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	target, err := jid.Parse(service)
+	if err != nil {
+		return nil, fmt.Errorf("invalid service %q: %w", service, err)
+	}
+	iq := stanza.NewIQ(stanza.IQGet)
+	iq.To = target
+	iq.Query, _ = xml.Marshal(disco.ItemsQuery{})
+	items, err := requestIQ[disco.ItemsQuery](ctx, c, session, iq, iqOptions{Timeout: roomIQTimeout})
+	if err != nil {
+		return nil, err
+	}
+
+	rooms := make([]RoomInfo, len(items.Items))
+	sem := make(chan struct{}, roomInfoWorkers)
+	var wg sync.WaitGroup
+	for i, item := range items.Items {
+		rooms[i] = RoomInfo{JID: item.JID, Name: item.Name, Occupants: -1}
+		target, err := jid.Parse(item.JID)
+		if err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(room *RoomInfo) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-sem }()
+
+			iq := stanza.NewIQ(stanza.IQGet)
+			iq.To = target
+			iq.Query, _ = xml.Marshal(disco.InfoQuery{})
+			reply, err := requestIQ[roomInfoReply](ctx, c, session, iq, iqOptions{Timeout: roomIQTimeout})
+			if err == nil {
+				applyRoomInfo(room, reply)
+			}
+		}(&rooms[i])
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sortRooms(rooms)
+	return rooms, nil
+}
+
+// applyRoomInfo fills in what a room's disco#info tells about it
+func applyRoomInfo(room *RoomInfo, reply roomInfoReply) {
+	for _, id := range reply.Identities {
+		if id.Category == "conference" && id.Name != "" {
+			room.Name = id.Name
+		}
+	}
+	for _, f := range reply.Features {
+		switch f.Var {
+		case "muc_passwordprotected":
+			room.Password = true
+		case "muc_membersonly":
+			room.MembersOnly = true
+		}
+	}
+	for _, x := range reply.Forms {
+		if formType(x) != roomInfoForm {
+			continue
+		}
+		for _, field := range x.Fields {
+			if len(field.Values) == 0 {
+				continue
+			}
+			switch field.Var {
+			case "muc#roominfo_occupants":
+				if n, err := strconv.Atoi(strings.TrimSpace(field.Values[0])); err == nil {
+					room.Occupants = n
+				}
+			case "muc#roominfo_description":
+				room.Description = field.Values[0]
+			}
+		}
+	}
+}
+
+// formType returns the FORM_TYPE of a data form
+func formType(x form.Form) string {
+	for _, field := range x.Fields {
+		if field.Var == "FORM_TYPE" && len(field.Values) > 0 {
+			return field.Values[0]
+		}
+	}
+	return ""
+}
+
+// sortRooms orders rooms busiest first, then by name
+func sortRooms(rooms []RoomInfo) {
+	sort.SliceStable(rooms, func(i, j int) bool {
+		if rooms[i].Occupants != rooms[j].Occupants {
+			return rooms[i].Occupants > rooms[j].Occupants
+		}
+		return strings.ToLower(roomLabel(rooms[i])) < strings.ToLower(roomLabel(rooms[j]))
+	})
+}
+
+// roomLabel is the name a room is listed under
+func roomLabel(r RoomInfo) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.JID
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestApplyRoomInfo(t *testing.T) {
+	payload := `<query xmlns='http://jabber.org/protocol/disco#info'>` +
+		`<identity category='conference' type='text' name='Go Developers'/>` +
+		`<feature var='http://jabber.org/protocol/muc'/>` +
+		`<feature var='muc_passwordprotected'/>` +
+		`<x xmlns='jabber:x:data' type='result'>` +
+		`<field var='FORM_TYPE' type='hidden'><value>http://jabber.org/protocol/muc#roominfo</value></field>` +
+		`<field var='muc#roominfo_description'><value>All things Go</value></field>` +
+		`<field var='muc#roominfo_occupants'><value>42</value></field>` +
+		`</x></query>`
+
+	var reply roomInfoReply
+	if err := xml.Unmarshal([]byte(payload), &reply); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	room := RoomInfo{JID: "go@conference.example.com", Occupants: -1}
+	applyRoomInfo(&room, reply)
+
+	if room.Name != "Go Developers" || room.Description != "All things Go" || room.Occupants != 42 {
+		t.Fatalf("unexpected room info: %+v", room)
+	}
+	if !room.Password || room.MembersOnly {
+		t.Fatalf("expected only the password flag, got %+v", room)
+	}
+}
+
+func TestSortRoomsBusiestFirst(t *testing.T) {
+	rooms := []RoomInfo{
+		{JID: "quiet@muc.example.com", Occupants: 2},
+		{JID: "unknown@muc.example.com", Occupants: -1},
+		{JID: "b@muc.example.com", Name: "beta", Occupants: 10},
+		{JID: "a@muc.example.com", Name: "Alpha", Occupants: 10},
+	}
+	sortRooms(rooms)
+
+	want := []string{"a@muc.example.com", "b@muc.example.com", "quiet@muc.example.com", "unknown@muc.example.com"}
+	for i, jid := range want {
+		if rooms[i].JID != jid {
+			t.Fatalf("position %d: expected %s, got %s", i, jid, rooms[i].JID)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"github.com/meszmate/xmpp-go/sasl"
+	"github.com/meszmate/xmpp-go/transport"
+)
+
+// roster logs in with the strongest SASL mechanism the server offers:
+// SCRAM (RFC 5802, RFC 7677) with the largest hash, and PLAIN only from a
+// server that offers no SCRAM at all. The -PLUS variants also bind the login
+// to the TLS connection with tls-exporter (RFC 9266), so it cannot be
+// relayed by a man in the middle; they are used when the server lists
+// tls-exporter among its channel bindings (XEP-0440) and the connection can
+// export keying material, which takes TLS 1.3 or the extended master secret.
+//
+// A refused mechanism only leads to a smaller hash of the same kind, never
+// from -PLUS to plain SCRAM or from SCRAM to PLAIN, as whoever can forge the
+// refusal could otherwise strip the binding or read the password. When the
+// login could be bound but the server offers no -PLUS mechanism, SCRAM says
+// so in its GS2 header, and a server that did offer one notices the offer
+// was stripped.
+
+// scramVariants are the SCRAM hashes roster supports, strongest first
+var scramVariants = []struct {
+	name string
+	hash func() hash.Hash
+}{
+	{"SCRAM-SHA-512", sha512.New},
+	{"SCRAM-SHA-256", sha256.New},
+	{"SCRAM-SHA-1", sha1.New},
+}
+
+// cbTLSExporter is the channel binding type of the -PLUS mechanisms
+const cbTLSExporter = "tls-exporter"
+
+// maxSCRAMIterations bounds the PBKDF2 work a server can ask for
+const maxSCRAMIterations = 1 << 20
+
+type saslResponse struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:xmpp-sasl response"`
+	Value   string   `xml:",chardata"`
+}
+
+type saslAbort struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:xmpp-sasl abort"`
+}
+
+// SupportedMechanism reports whether roster can log in with a SASL mechanism
+func SupportedMechanism(name string) bool {
+	if name == "PLAIN" {
+		return true
+	}
+	name = strings.TrimSuffix(name, "-PLUS")
+	for _, v := range scramVariants {
+		if v.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// saslMechanisms returns the mechanisms to try in turn, those of the
+// strongest kind the server offers: the -PLUS variants when the login can be
+// bound, else the other SCRAM variants, else PLAIN
+func saslMechanisms(features *streamFeatures, creds sasl.Credentials) []sasl.Mechanism {
+	offered := make(map[string]bool, len(features.Mechanisms))
+	offersPlus := false
+	for _, m := range features.Mechanisms {
+		m = strings.TrimSpace(m)
+		offered[m] = true
+		offersPlus = offersPlus || strings.HasSuffix(m, "-PLUS")
+	}
+	binding := len(creds.ChannelBinding) > 0 && features.offersChannelBinding(creds.CBType)
+
+	// RFC 5802 section 6: y when the client could bind and the server
+	// seems unable to
+	header := "n,,"
+	if len(creds.ChannelBinding) > 0 && !offersPlus {
+		header = "y,,"
+	}
+
+	var plus, plain []sasl.Mechanism
+	for _, v := range scramVariants {
+		if binding && offered[v.name+"-PLUS"] {
+			plus = append(plus, newSCRAM(v.name+"-PLUS", v.hash, creds, "p="+creds.CBType+",,"))
+		}
+		if offered[v.name] {
+			plain = append(plain, newSCRAM(v.name, v.hash, creds, header))
+		}
+	}
+	switch {
+	case len(plus) > 0:
+		return plus
+	case len(plain) > 0:
+		return plain
+	case offered["PLAIN"]:
+		return []sasl.Mechanism{sasl.NewPlain(creds)}
+	}
+	return nil
+}
+
+// scram is the client side of a SCRAM mechanism with the GS2 header it was
+// given: p=<type> to bind the login, y or n otherwise
+type scram struct {
+	name   string
+	hash   func() hash.Hash
+	creds  sasl.Credentials
+	header string
+
+	step            int
+	nonce           string
+	clientFirstBare string
+	authMessage     string
+	salted          []byte
+}
+
+func newSCRAM(name string, h func() hash.Hash, creds sasl.Credentials, header string) *scram {
+	return &scram{name: name, hash: h, creds: creds, header: header}
+}
+
+func (s *scram) Name() string    { return s.name }
+func (s *scram) Completed() bool { return s.step == 3 }
+
+// Start returns the client-first message
+func (s *scram) Start() ([]byte, error) {
+	if strings.HasPrefix(s.header, "p=") && len(s.creds.ChannelBinding) == 0 {
+		return nil, sasl.ErrChannelBinding
+	}
+	nonce := make([]byte, 24)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	s.nonce = base64.RawStdEncoding.EncodeToString(nonce)
+	s.clientFirstBare = "n=" + scramEscape(s.creds.Username) + ",r=" + s.nonce
+	s.step = 1
+	return []byte(s.header + s.clientFirstBare), nil
+}
+
+// Next answers the server-first message with the proof, then checks the
+// server's signature in the server-final one
+func (s *scram) Next(challenge []byte) ([]byte, error) {
+	switch s.step {
+	case 1:
+		return s.clientFinal(string(challenge))
+	case 2:
+		return nil, s.verifyServer(string(challenge))
+	}
+	return nil, errors.New("unexpected SCRAM message")
+}
+
+func (s *scram) clientFinal(serverFirst string) ([]byte, error) {
+	attrs := scramAttributes(serverFirst)
+	if _, ok := attrs["m"]; ok {
+		return nil, fmt.Errorf("%w: unsupported mandatory extension", sasl.ErrInvalidResponse)
+	}
+	nonce := attrs["r"]
+	if len(nonce) <= len(s.nonce) || !strings.HasPrefix(nonce, s.nonce) {
+		return nil, fmt.Errorf("%w: nonce does not extend ours", sasl.ErrInvalidResponse)
+	}
+	salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+	if err != nil || len(salt) == 0 {
+		return nil, fmt.Errorf("%w: bad salt", sasl.ErrInvalidResponse)
+	}
+	iterations, err := strconv.Atoi(attrs["i"])
+	if err != nil || iterations <= 0 || iterations > maxSCRAMIterations {
+		return nil, fmt.Errorf("%w: bad iteration count %q", sasl.ErrInvalidResponse, attrs["i"])
+	}
+
+	cbind := []byte(s.header)
+	if strings.HasPrefix(s.header, "p=") {
+		cbind = append(cbind, s.creds.ChannelBinding...)
+	}
+	withoutProof := "c=" + base64.StdEncoding.EncodeToString(cbind) + ",r=" + nonce
+	s.authMessage = s.clientFirstBare + "," + serverFirst + "," + withoutProof
+
+	s.salted, err = pbkdf2.Key(s.hash, s.creds.Password, salt, iterations, s.hash().Size())
+	if err != nil {
+		return nil, err
+	}
+	clientKey := s.hmac(s.salted, "Client Key")
+	h := s.hash()
+	h.Write(clientKey)
+	signature := s.hmac(h.Sum(nil), s.authMessage)
+	for i := range clientKey {
+		clientKey[i] ^= signature[i]
+	}
+	s.step = 2
+	return []byte(withoutProof + ",p=" + base64.StdEncoding.EncodeToString(clientKey)), nil
+}
+
+func (s *scram) verifyServer(serverFinal string) error {
+	attrs := scramAttributes(serverFinal)
+	if e, ok := attrs["e"]; ok {
+		return fmt.Errorf("%w: %s", sasl.ErrAuthFailed, e)
+	}
+	got, err := base64.StdEncoding.DecodeString(attrs["v"])
+	if err != nil {
+		return sasl.ErrInvalidResponse
+	}
+	if !hmac.Equal(got, s.hmac(s.hmac(s.salted, "Server Key"), s.authMessage)) {
+		return sasl.ErrAuthFailed
+	}
+	s.step = 3
+	return nil
+}
+
+func (s *scram) hmac(key []byte, msg string) []byte {
+	mac := hmac.New(s.hash, key)
+	mac.Write([]byte(msg))
+	return mac.Sum(nil)
+}
+
+// scramAttributes splits a SCRAM message into its attributes
+func scramAttributes(msg string) map[string]string {
+	attrs := make(map[string]string)
+	for _, part := range strings.Split(msg, ",") {
+		if len(part) >= 2 && part[1] == '=' {
+			attrs[part[:1]] = part[2:]
+		}
+	}
+	return attrs
+}
+
+// scramEscape escapes a SCRAM username (RFC 5802 section 5.1)
+func scramEscape(name string) string {
+	return strings.NewReplacer("=", "=3D", ",", "=2C").Replace(name)
+}
+
+// offersChannelBinding reports whether the server listed a channel binding
+// type in its features
+func (f *streamFeatures) offersChannelBinding(typ string) bool {
+	for _, cb := range f.ChannelBindings {
+		if cb.Type == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// tlsExporterBinding returns the tls-exporter channel binding data of a
+// connection, an error when it has none to export
+func tlsExporterBinding(state tls.ConnectionState) ([]byte, error) {
+	return state.ExportKeyingMaterial("EXPORTER-Channel-Binding", nil, 32)
+}
+
+// authenticate logs in with the best mechanism both sides support. When the
+// server turns a mechanism down, rather than the credentials, the next one
+// of the same kind is tried.
+func (c *Client) authenticate(features *streamFeatures, trans transport.Transport) error {
+	creds := sasl.Credentials{Username: c.jid.Local(), Password: c.password}
+	if state, ok := trans.ConnectionState(); ok {
+		if cb, err := tlsExporterBinding(state); err == nil {
+			creds.ChannelBinding = cb
+			creds.CBType = cbTLSExporter
+		}
+	}
+
+	mechs := saslMechanisms(features, creds)
+	if len(mechs) == 0 {
+		offered := strings.Join(features.Mechanisms, ", ")
+		if offered == "" {
+			offered = "none"
+		}
+		return fmt.Errorf("server offers no SASL mechanism roster supports (offered: %s)", offered)
+	}
+
+	var err error
+	for _, mech := range mechs {
+		if err = c.saslExchange(mech); err == nil || !retrySASL(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// retrySASL reports whether the next mechanism may be tried after err: the
+// server refused the mechanism itself rather than the credentials
+func retrySASL(err error) bool {
+	var authErr *AuthError
+	if !errors.As(err, &authErr) {
+		return false
+	}
+	switch authErr.Condition {
+	case ConditionInvalidMechanism, ConditionMechanismTooWeak, ConditionEncryptionRequired,
+		ConditionMalformedRequest, ConditionIncorrectEncoding:
+		return true
+	}
+	return false
+}
+
+// saslExchange runs one mechanism to the end: the initial response, the
+// server's challenges and its success, which SCRAM checks proves the server
+// knows the password too
+func (c *Client) saslExchange(mech sasl.Mechanism) error {
+	initial, err := mech.Start()
+	if err != nil {
+		return fmt.Errorf("%s: %w", mech.Name(), err)
+	}
+	value := base64.StdEncoding.EncodeToString(initial)
+	if value == "" {
+		// An empty initial response is sent as "=" (RFC 6120 6.4.2)
+		value = "="
+	}
+	if err := c.session.SendElement(c.ctx, saslAuth{Mechanism: mech.Name(), Value: value}); err != nil {
+		return fmt.Errorf("failed to send SASL auth: %w", err)
+	}
+
+	for {
+		tok, err := c.session.Reader().Token()
+		if err != nil {
+			return err
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		if start.Name.Space != nsSASL {
+			if err := c.session.Reader().Skip(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch start.Name.Local {
+		case "challenge":
+			data, err := c.readSASLData(&start)
+			if err != nil {
+				return err
+			}
+			resp, err := mech.Next(data)
+			if err != nil {
+				_ = c.session.SendElement(c.ctx, saslAbort{})
+				return fmt.Errorf("%s: bad server challenge: %w", mech.Name(), err)
+			}
+			if err := c.session.SendElement(c.ctx, saslResponse{Value: base64.StdEncoding.EncodeToString(resp)}); err != nil {
+				return fmt.Errorf("failed to send SASL response: %w", err)
+			}
+		case "success":
+			data, err := c.readSASLData(&start)
+			if err != nil {
+				return err
+			}
+			if !mech.Completed() && len(data) > 0 {
+				if _, err := mech.Next(data); err != nil {
+					return fmt.Errorf("%s: the server did not prove it knows the password: %w", mech.Name(), err)
+				}
+			}
+			if !mech.Completed() {
+				return fmt.Errorf("%s: the server ended the login without proving it knows the password", mech.Name())
+			}
+			return nil
+		case "failure":
+			var fail errorElement
+			if err := c.session.Reader().DecodeElement(&fail, &start); err != nil {
+				return err
+			}
+			return &AuthError{Condition: fail.condition(), Text: strings.TrimSpace(fail.Text)}
+		default:
+			if err := c.session.Reader().Skip(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readSASLData decodes the base64 payload of a challenge or success
+func (c *Client) readSASLData(start *xml.StartElement) ([]byte, error) {
+	var payload struct {
+		Value string `xml:",chardata"`
+	}
+	if err := c.session.Reader().DecodeElement(&payload, start); err != nil {
+		return nil, err
+	}
+	value := strings.TrimSpace(payload.Value)
+	if value == "" || value == "=" {
+		return nil, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SASL %s payload: %w", start.Name.Local, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,317 @@
+package client
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	xmp "github.com/meszmate/xmpp-go"
+	"github.com/meszmate/xmpp-go/sasl"
+)
+
+// pipeTransport is one end of a net.Pipe, without TLS
+type pipeTransport struct {
+	net.Conn
+}
+
+func (t *pipeTransport) StartTLS(*tls.Config) error { return errors.New("no tls in tests") }
+func (t *pipeTransport) ConnectionState() (tls.ConnectionState, bool) {
+	return tls.ConnectionState{}, false
+}
+func (t *pipeTransport) Peer() net.Addr         { return nil }
+func (t *pipeTransport) LocalAddress() net.Addr { return nil }
+
+// saslElement is what the client sends during authentication
+type saslElement struct {
+	XMLName   xml.Name
+	Mechanism string `xml:"mechanism,attr"`
+	Value     string `xml:",chardata"`
+}
+
+// newSASLClient returns a client talking to a server run by serve
+func newSASLClient(t *testing.T, serve func(dec *xml.Decoder, conn net.Conn)) (*Client, *pipeTransport) {
+	t.Helper()
+	clientConn, serverConn := net.Pipe()
+	t.Cleanup(func() {
+		clientConn.Close()
+		serverConn.Close()
+	})
+	go func() {
+		serve(xml.NewDecoder(serverConn), serverConn)
+		serverConn.Close()
+	}()
+
+	c, err := NewClient(ClientConfig{JID: "romeo@example.com/orchard", Password: "secret"})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	trans := &pipeTransport{Conn: clientConn}
+	session, err := xmp.NewSession(context.Background(), trans)
+	if err != nil {
+		t.Fatalf("new session: %v", err)
+	}
+	c.session = session
+	t.Cleanup(c.cancel)
+	return c, trans
+}
+
+func readSASL(dec *xml.Decoder) (saslElement, error) {
+	var el saslElement
+	err := dec.Decode(&el)
+	return el, err
+}
+
+func decode64(s string) string {
+	b, _ := base64.StdEncoding.DecodeString(s)
+	return string(b)
+}
+
+func sasl64(name, payload string) string {
+	return `<` + name + ` xmlns="urn:ietf:params:xml:ns:xmpp-sasl">` + base64.StdEncoding.EncodeToString([]byte(payload)) + `</` + name + `>`
+}
+
+// scramServer runs the server side of SCRAM-SHA-256 for password, signing
+// its final message with forged when that is set
+func scramServer(t *testing.T, password string, forged bool) func(*xml.Decoder, net.Conn) {
+	return scramServerBound(t, "SCRAM-SHA-256", "n,,", nil, password, forged)
+}
+
+// scramServerBound runs the server side of a SHA-256 SCRAM mechanism that
+// expects the GS2 header gs2 and, when it binds, the channel binding data cb
+func scramServerBound(t *testing.T, mechanism, gs2 string, cb []byte, password string, forged bool) func(*xml.Decoder, net.Conn) {
+	return func(dec *xml.Decoder, conn net.Conn) {
+		auth, err := readSASL(dec)
+		if err != nil || auth.Mechanism != mechanism {
+			t.Errorf("expected %s auth, got %+v (%v)", mechanism, auth, err)
+			return
+		}
+		clientFirst := decode64(auth.Value)
+		if !strings.HasPrefix(clientFirst, gs2) {
+			t.Errorf("expected GS2 header %q in %q", gs2, clientFirst)
+			return
+		}
+		clientFirstBare := strings.TrimPrefix(clientFirst, gs2)
+		var nonce string
+		for _, attr := range strings.Split(clientFirstBare, ",") {
+			if strings.HasPrefix(attr, "r=") {
+				nonce = attr[2:] + "server-nonce"
+			}
+		}
+		salt := []byte("pepper")
+		serverFirst := "r=" + nonce + ",s=" + base64.StdEncoding.EncodeToString(salt) + ",i=4096"
+		if _, err := conn.Write([]byte(sasl64("challenge", serverFirst))); err != nil {
+			return
+		}
+
+		resp, err := readSASL(dec)
+		if err != nil || resp.XMLName.Local != "response" {
+			t.Errorf("expected a response, got %+v (%v)", resp, err)
+			return
+		}
+		clientFinal := decode64(resp.Value)
+		i := strings.LastIndex(clientFinal, ",p=")
+		if i < 0 {
+			t.Errorf("no proof in %q", clientFinal)
+			return
+		}
+		if want := "c=" + base64.StdEncoding.EncodeToString(append([]byte(gs2), cb...)) + ","; !strings.HasPrefix(clientFinal, want) {
+			t.Errorf("expected channel binding %q in %q", want, clientFinal)
+			return
+		}
+		authMessage := clientFirstBare + "," + serverFirst + "," + clientFinal[:i]
+
+		salted, _ := pbkdf2.Key(sha256.New, password, salt, 4096, sha256.Size)
+		clientKey := scramHMAC(salted, "Client Key")
+		storedKey := sha256.Sum256(clientKey)
+		signature := scramHMAC(storedKey[:], authMessage)
+		proof, _ := base64.StdEncoding.DecodeString(clientFinal[i+3:])
+		for j := range proof {
+			proof[j] ^= signature[j]
+		}
+		if got := sha256.Sum256(proof); !hmac.Equal(got[:], storedKey[:]) {
+			_, _ = conn.Write([]byte(`<failure xmlns="urn:ietf:params:xml:ns:xmpp-sasl"><not-authorized/></failure>`))
+			return
+		}
+
+		serverKey := scramHMAC(salted, "Server Key")
+		if forged {
+			serverKey = scramHMAC([]byte("not the password"), "Server Key")
+		}
+		verifier := base64.StdEncoding.EncodeToString(scramHMAC(serverKey, authMessage))
+		_, _ = conn.Write([]byte(sasl64("success", "v="+verifier)))
+	}
+}
+
+func scramHMAC(key []byte, msg string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(msg))
+	return h.Sum(nil)
+}
+
+func mechanismNames(mechs []sasl.Mechanism) string {
+	names := make([]string, 0, len(mechs))
+	for _, m := range mechs {
+		names = append(names, m.Name())
+	}
+	return strings.Join(names, " ")
+}
+
+func TestSASLMechanismsPreferStrongest(t *testing.T) {
+	features := &streamFeatures{Mechanisms: []string{"PLAIN", "SCRAM-SHA-1", "X-OAUTH2", "SCRAM-SHA-256", "SCRAM-SHA-256-PLUS"}}
+	creds := sasl.Credentials{Username: "romeo", Password: "secret"}
+
+	if got := mechanismNames(saslMechanisms(features, creds)); got != "SCRAM-SHA-256 SCRAM-SHA-1" {
+		t.Fatalf("without channel binding data, got %q", got)
+	}
+
+	creds.ChannelBinding = []byte("exported")
+	creds.CBType = cbTLSExporter
+	if got := mechanismNames(saslMechanisms(features, creds)); got != "SCRAM-SHA-256 SCRAM-SHA-1" {
+		t.Fatalf("without tls-exporter offered, got %q", got)
+	}
+
+	features.ChannelBindings = append(features.ChannelBindings, struct {
+		Type string `xml:"type,attr"`
+	}{Type: cbTLSExporter})
+	if got := mechanismNames(saslMechanisms(features, creds)); got != "SCRAM-SHA-256-PLUS" {
+		t.Fatalf("with channel binding, got %q", got)
+	}
+
+	features = &streamFeatures{Mechanisms: []string{"PLAIN", "X-OAUTH2"}}
+	if got := mechanismNames(saslMechanisms(features, creds)); got != "PLAIN" {
+		t.Fatalf("without SCRAM, got %q", got)
+	}
+}
+
+func TestSASLMechanismsGS2Header(t *testing.T) {
+	creds := sasl.Credentials{Username: "romeo", Password: "secret"}
+	header := func(features *streamFeatures) string {
+		first, err := saslMechanisms(features, creds)[0].Start()
+		if err != nil {
+			t.Fatalf("start: %v", err)
+		}
+		return string(first[:strings.Index(string(first), "n=")])
+	}
+
+	if got := header(&streamFeatures{Mechanisms: []string{"SCRAM-SHA-256"}}); got != "n,," {
+		t.Fatalf("without channel binding data, got %q", got)
+	}
+	creds.ChannelBinding = []byte("exported")
+	creds.CBType = cbTLSExporter
+	if got := header(&streamFeatures{Mechanisms: []string{"SCRAM-SHA-256"}}); got != "y,," {
+		t.Fatalf("without -PLUS offered, got %q", got)
+	}
+	if got := header(&streamFeatures{Mechanisms: []string{"SCRAM-SHA-256", "SCRAM-SHA-256-PLUS"}}); got != "n,," {
+		t.Fatalf("with -PLUS offered but no tls-exporter, got %q", got)
+	}
+}
+
+func TestStreamFeaturesChannelBindings(t *testing.T) {
+	var features streamFeatures
+	raw := `<stream:features xmlns:stream="http://etherx.jabber.org/streams">` +
+		`<mechanisms xmlns="urn:ietf:params:xml:ns:xmpp-sasl"><mechanism>SCRAM-SHA-1-PLUS</mechanism></mechanisms>` +
+		`<sasl-channel-binding xmlns="urn:xmpp:sasl-cb:0"><channel-binding type="tls-server-end-point"/><channel-binding type="tls-exporter"/></sasl-channel-binding>` +
+		`</stream:features>`
+	if err := xml.Unmarshal([]byte(raw), &features); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if !features.offersChannelBinding(cbTLSExporter) || features.offersChannelBinding("tls-unique") {
+		t.Fatalf("unexpected channel bindings %+v", features.ChannelBindings)
+	}
+}
+
+func TestAuthenticateSCRAM(t *testing.T) {
+	c, trans := newSASLClient(t, scramServer(t, "secret", false))
+	features := &streamFeatures{Mechanisms: []string{"PLAIN", "SCRAM-SHA-256"}}
+	if err := c.authenticate(features, trans); err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+}
+
+func TestAuthenticateSCRAMRejectsForgedServerSignature(t *testing.T) {
+	c, trans := newSASLClient(t, scramServer(t, "secret", true))
+	features := &streamFeatures{Mechanisms: []string{"PLAIN", "SCRAM-SHA-256"}}
+	err := c.authenticate(features, trans)
+	if err == nil || !strings.Contains(err.Error(), "prove it knows the password") {
+		t.Fatalf("expected the forged signature to fail the login, got %v", err)
+	}
+}
+
+func TestAuthenticateSCRAMWithChannelBinding(t *testing.T) {
+	cb := []byte("exported keying material")
+	c, _ := newSASLClient(t, scramServerBound(t, "SCRAM-SHA-256-PLUS", "p=tls-exporter,,", cb, "secret", false))
+	creds := sasl.Credentials{Username: "romeo", Password: "secret", ChannelBinding: cb, CBType: cbTLSExporter}
+	if err := c.saslExchange(newSCRAM("SCRAM-SHA-256-PLUS", sha256.New, creds, "p=tls-exporter,,")); err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+}
+
+func TestAuthenticateFallsBackToSmallerHash(t *testing.T) {
+	c, trans := newSASLClient(t, func(dec *xml.Decoder, conn net.Conn) {
+		auth, err := readSASL(dec)
+		if err != nil || auth.Mechanism != "SCRAM-SHA-512" {
+			t.Errorf("expected SCRAM-SHA-512 first, got %+v (%v)", auth, err)
+			return
+		}
+		if _, err := conn.Write([]byte(`<failure xmlns="urn:ietf:params:xml:ns:xmpp-sasl"><invalid-mechanism/></failure>`)); err != nil {
+			return
+		}
+		scramServer(t, "secret", false)(dec, conn)
+	})
+	features := &streamFeatures{Mechanisms: []string{"PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512"}}
+	if err := c.authenticate(features, trans); err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+}
+
+func TestAuthenticateNeverFallsBackToPlain(t *testing.T) {
+	c, trans := newSASLClient(t, func(dec *xml.Decoder, conn net.Conn) {
+		auth, err := readSASL(dec)
+		if err != nil || auth.Mechanism != "SCRAM-SHA-1" {
+			t.Errorf("expected SCRAM-SHA-1, got %+v (%v)", auth, err)
+			return
+		}
+		if _, err := conn.Write([]byte(`<failure xmlns="urn:ietf:params:xml:ns:xmpp-sasl"><invalid-mechanism/></failure>`)); err != nil {
+			return
+		}
+		if auth, err := readSASL(dec); err == nil {
+			t.Errorf("expected no further auth, got %+v", auth)
+		}
+	})
+	features := &streamFeatures{Mechanisms: []string{"PLAIN", "SCRAM-SHA-1"}}
+	err := c.authenticate(features, trans)
+	if !errors.Is(err, ConditionInvalidMechanism) {
+		t.Fatalf("expected invalid-mechanism, got %v", err)
+	}
+	trans.Close()
+}
+
+func TestAuthenticateDoesNotRetryBadCredentials(t *testing.T) {
+	c, trans := newSASLClient(t, func(dec *xml.Decoder, conn net.Conn) {
+		if _, err := readSASL(dec); err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte(`<failure xmlns="urn:ietf:params:xml:ns:xmpp-sasl"><not-authorized/></failure>`))
+	})
+	features := &streamFeatures{Mechanisms: []string{"PLAIN", "SCRAM-SHA-1"}}
+	err := c.authenticate(features, trans)
+	if !errors.Is(err, ConditionNotAuthorized) {
+		t.Fatalf("expected not-authorized, got %v", err)
+	}
+}
+
+func TestAuthenticateWithoutSupportedMechanism(t *testing.T) {
+	c, _ := newStreamClient(t, nil)
+	err := c.authenticate(&streamFeatures{Mechanisms: []string{"X-OAUTH2"}}, &fakeTransport{})
+	if err == nil || !strings.Contains(err.Error(), "X-OAUTH2") {
+		t.Fatalf("expected the offered mechanisms in the error, got %v", err)
+	}
+}
@@ -0,0 +1,253 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	xmp "github.com/meszmate/xmpp-go"
+	"github.com/meszmate/xmpp-go/stanza"
+)
+
+// Outgoing stanzas go through a queue per connection that one goroutine
+// writes out, so a slow link delays sends instead of blocking whoever sends.
+// IQs go ahead of messages, and messages ahead of presence and chat states.
+
+// sendPriority orders the lanes of the send queue, most urgent first
+type sendPriority int
+
+const (
+	priorityIQ sendPriority = iota
+	priorityMessage
+	priorityPresence // presence and chat states
+	numSendPriorities
+)
+
+const (
+	defaultSendTimeout = 30 * time.Second
+	sendQueueLimit     = 512 // stanzas waiting in one lane
+)
+
+var (
+	// ErrSendTimeout is returned when a stanza could not be written before
+	// its deadline
+	ErrSendTimeout = errors.New("send timed out")
+	// ErrSendQueueFull is returned when too many stanzas are waiting
+	ErrSendQueueFull = errors.New("send queue full")
+
+	errSendQueueClosed = errors.New("not connected")
+)
+
+// sendOptions controls how a queued stanza is sent
+type sendOptions struct {
+	Priority sendPriority
+	Timeout  time.Duration // defaultSendTimeout when zero
+	// Coalesce replaces a stanza still waiting under the same key, so only
+	// the latest is sent
+	Coalesce string
+	// OnFail is called when the stanza could not be written
+	OnFail func(error)
+}
+
+// sendRequest is a stanza waiting to be written
+type sendRequest struct {
+	stanza   stanza.Stanza
+	opts     sendOptions
+	deadline time.Time
+	done     chan error
+}
+
+// finish reports the outcome of the request
+func (r *sendRequest) finish(err error) {
+	r.done <- err
+	if err != nil && r.opts.OnFail != nil {
+		r.opts.OnFail(err)
+	}
+}
+
+// sendQueue writes stanzas of one session in priority order
+type sendQueue struct {
+	send func(context.Context, stanza.Stanza) error
+	ctx  context.Context
+
+	mu     sync.Mutex
+	lanes  [numSendPriorities][]*sendRequest
+	wake   chan struct{}
+	closed error
+}
+
+// newSendQueue starts a queue that writes with send until ctx is done
+func newSendQueue(ctx context.Context, send func(context.Context, stanza.Stanza) error) *sendQueue {
+	q := &sendQueue{send: send, ctx: ctx, wake: make(chan struct{}, 1)}
+	go q.run()
+	return q
+}
+
+// push queues a stanza. The returned request reports the outcome on done.
+func (q *sendQueue) push(st stanza.Stanza, opts sendOptions) (*sendRequest, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultSendTimeout
+	}
+	req := &sendRequest{
+		stanza:   st,
+		opts:     opts,
+		deadline: time.Now().Add(opts.Timeout),
+		done:     make(chan error, 1),
+	}
+
+	q.mu.Lock()
+	if q.closed != nil {
+		q.mu.Unlock()
+		return nil, q.closed
+	}
+	lane := q.lanes[opts.Priority]
+	if opts.Coalesce != "" {
+		for i, queued := range lane {
+			if queued.opts.Coalesce == opts.Coalesce {
+				lane[i] = req
+				q.mu.Unlock()
+				// The newer stanza stands in for the one it replaced
+				queued.finish(nil)
+				q.signal()
+				return req, nil
+			}
+		}
+	}
+	if len(lane) >= sendQueueLimit {
+		q.mu.Unlock()
+		return nil, ErrSendQueueFull
+	}
+	q.lanes[opts.Priority] = append(lane, req)
+	q.mu.Unlock()
+	q.signal()
+	return req, nil
+}
+
+func (q *sendQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pop takes the oldest stanza of the most urgent non-empty lane
+func (q *sendQueue) pop() *sendRequest {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for p := range q.lanes {
+		if len(q.lanes[p]) > 0 {
+			req := q.lanes[p][0]
+			q.lanes[p][0] = nil
+			q.lanes[p] = q.lanes[p][1:]
+			return req
+		}
+	}
+	return nil
+}
+
+func (q *sendQueue) run() {
+	for {
+		req := q.pop()
+		if req == nil {
+			select {
+			case <-q.wake:
+				continue
+			case <-q.ctx.Done():
+				q.close(errSendQueueClosed)
+				return
+			}
+		}
+		if q.ctx.Err() != nil {
+			req.finish(errSendQueueClosed)
+			q.close(errSendQueueClosed)
+			return
+		}
+		if !time.Now().Before(req.deadline) {
+			req.finish(ErrSendTimeout)
+			continue
+		}
+		ctx, cancel := context.WithDeadline(q.ctx, req.deadline)
+		err := q.send(ctx, req.stanza)
+		cancel()
+		req.finish(err)
+	}
+}
+
+// close fails everything still waiting and refuses new stanzas
+func (q *sendQueue) close(err error) {
+	q.mu.Lock()
+	if q.closed == nil {
+		q.closed = err
+	}
+	var pending []*sendRequest
+	for p := range q.lanes {
+		pending = append(pending, q.lanes[p]...)
+		q.lanes[p] = nil
+	}
+	q.mu.Unlock()
+	for _, req := range pending {
+		req.finish(err)
+	}
+}
+
+// queueFor returns the send queue of a session, replacing the queue of an
+// earlier session
+func (c *Client) queueFor(session *xmp.Session) *sendQueue {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if c.sendQueue == nil || c.sendSession != session {
+		if c.sendQueue != nil {
+			c.sendQueue.close(errSendQueueClosed)
+		}
+		ctx := c.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		c.sendQueue = newSendQueue(ctx, c.countingSend(session.Send))
+		c.sendSession = session
+	}
+	return c.sendQueue
+}
+
+// sendQueued queues a stanza and waits until it is written, its deadline
+// passes or ctx is done
+func (c *Client) sendQueued(ctx context.Context, session *xmp.Session, st stanza.Stanza, opts sendOptions) error {
+	if session == nil {
+		return errSendQueueClosed
+	}
+	req, err := c.queueFor(session).push(st, opts)
+	if err != nil {
+		return err
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	timer := time.NewTimer(time.Until(req.deadline))
+	defer timer.Stop()
+	select {
+	case err := <-req.done:
+		return err
+	case <-timer.C:
+		return ErrSendTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sendAsync queues a stanza without waiting for it to be written. Failures
+// after queueing are reported to the error handler.
+func (c *Client) sendAsync(session *xmp.Session, st stanza.Stanza, opts sendOptions) error {
+	if session == nil {
+		return errSendQueueClosed
+	}
+	if opts.OnFail == nil {
+		opts.OnFail = func(err error) {
+			if !errors.Is(err, errSendQueueClosed) {
+				c.emitError(err)
+			}
+		}
+	}
+	_, err := c.queueFor(session).push(st, opts)
+	return err
+}
@@ -0,0 +1,237 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/meszmate/xmpp-go/stanza"
+)
+
+// blockingSender records sent stanzas and holds each write until released
+type blockingSender struct {
+	mu      sync.Mutex
+	sent    []string
+	release chan struct{}
+	started chan struct{}
+}
+
+func newBlockingSender() *blockingSender {
+	return &blockingSender{release: make(chan struct{}), started: make(chan struct{}, 16)}
+}
+
+func (s *blockingSender) send(ctx context.Context, st stanza.Stanza) error {
+	s.started <- struct{}{}
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch st := st.(type) {
+	case *stanza.IQ:
+		s.sent = append(s.sent, "iq:"+st.ID)
+	case *stanza.Message:
+		s.sent = append(s.sent, "message:"+st.ID)
+	case *stanza.Presence:
+		s.sent = append(s.sent, "presence:"+st.ID)
+	}
+	return nil
+}
+
+func (s *blockingSender) order() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.sent...)
+}
+
+func testMessage(id string) *stanza.Message {
+	msg := stanza.NewMessage(stanza.MessageChat)
+	msg.ID = id
+	return msg
+}
+
+func testPresence(id string) *stanza.Presence {
+	p := stanza.NewPresence(stanza.PresenceAvailable)
+	p.ID = id
+	return p
+}
+
+func testIQ(id string) *stanza.IQ {
+	iq := stanza.NewIQ(stanza.IQGet)
+	iq.ID = id
+	return iq
+}
+
+func waitDone(t *testing.T, req *sendRequest) error {
+	t.Helper()
+	select {
+	case err := <-req.done:
+		return err
+	case <-time.After(2 * time.Second):
+		t.Fatalf("request %v never finished", req.stanza)
+		return nil
+	}
+}
+
+func TestSendQueueWritesMostUrgentFirst(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newBlockingSender()
+	q := newSendQueue(ctx, s.send)
+
+	// Hold the writer on a first stanza while the rest queue up
+	first, _ := q.push(testPresence("p0"), sendOptions{Priority: priorityPresence})
+	<-s.started
+
+	var reqs []*sendRequest
+	for _, push := range []struct {
+		st stanza.Stanza
+		p  sendPriority
+	}{
+		{testPresence("p1"), priorityPresence},
+		{testMessage("m1"), priorityMessage},
+		{testIQ("i1"), priorityIQ},
+		{testMessage("m2"), priorityMessage},
+	} {
+		req, err := q.push(push.st, sendOptions{Priority: push.p})
+		if err != nil {
+			t.Fatalf("push: %v", err)
+		}
+		reqs = append(reqs, req)
+	}
+	close(s.release)
+
+	waitDone(t, first)
+	for _, req := range reqs {
+		if err := waitDone(t, req); err != nil {
+			t.Fatalf("send failed: %v", err)
+		}
+	}
+	want := []string{"presence:p0", "iq:i1", "message:m1", "message:m2", "presence:p1"}
+	got := s.order()
+	if len(got) != len(want) {
+		t.Fatalf("sent %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("sent %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSendQueueCoalescesWaitingStanzas(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newBlockingSender()
+	q := newSendQueue(ctx, s.send)
+
+	first, _ := q.push(testMessage("hold"), sendOptions{Priority: priorityMessage})
+	<-s.started
+
+	composing, _ := q.push(testMessage("composing"), sendOptions{Priority: priorityPresence, Coalesce: "chatstate:juliet@example.com"})
+	other, _ := q.push(testMessage("other"), sendOptions{Priority: priorityPresence, Coalesce: "chatstate:romeo@example.com"})
+	paused, _ := q.push(testMessage("paused"), sendOptions{Priority: priorityPresence, Coalesce: "chatstate:juliet@example.com"})
+
+	if err := waitDone(t, composing); err != nil {
+		t.Fatalf("replaced stanza should finish without error, got %v", err)
+	}
+	close(s.release)
+	waitDone(t, first)
+	waitDone(t, other)
+	waitDone(t, paused)
+
+	got := s.order()
+	want := []string{"message:hold", "message:paused", "message:other"}
+	if len(got) != len(want) {
+		t.Fatalf("sent %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("sent %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSendQueueTimesOutWaitingStanzas(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newBlockingSender()
+	q := newSendQueue(ctx, s.send)
+
+	first, _ := q.push(testMessage("hold"), sendOptions{Priority: priorityMessage, Timeout: 50 * time.Millisecond})
+	<-s.started
+
+	failed := make(chan error, 1)
+	late, _ := q.push(testPresence("late"), sendOptions{
+		Priority: priorityPresence,
+		Timeout:  10 * time.Millisecond,
+		OnFail:   func(err error) { failed <- err },
+	})
+
+	// The write in progress hits its deadline; the waiting stanza expired
+	// before it could be written
+	if err := waitDone(t, first); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the blocked write to time out, got %v", err)
+	}
+	if err := waitDone(t, late); !errors.Is(err, ErrSendTimeout) {
+		t.Fatalf("expected ErrSendTimeout, got %v", err)
+	}
+	select {
+	case err := <-failed:
+		if !errors.Is(err, ErrSendTimeout) {
+			t.Fatalf("failure callback got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("failure callback not called")
+	}
+	if got := s.order(); len(got) != 0 {
+		t.Fatalf("expected nothing written, got %v", got)
+	}
+}
+
+func TestSendQueueFailsPendingOnClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := newBlockingSender()
+	q := newSendQueue(ctx, s.send)
+
+	first, _ := q.push(testMessage("hold"), sendOptions{Priority: priorityMessage})
+	<-s.started
+	waiting, _ := q.push(testMessage("waiting"), sendOptions{Priority: priorityMessage})
+
+	cancel()
+	if err := waitDone(t, first); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the write in progress to be cancelled, got %v", err)
+	}
+	if err := waitDone(t, waiting); !errors.Is(err, errSendQueueClosed) {
+		t.Fatalf("expected waiting stanza to fail on close, got %v", err)
+	}
+	if _, err := q.push(testMessage("after"), sendOptions{}); !errors.Is(err, errSendQueueClosed) {
+		t.Fatalf("expected push after close to fail, got %v", err)
+	}
+}
+
+func TestSendQueueRejectsWhenLaneIsFull(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newBlockingSender()
+	q := newSendQueue(ctx, s.send)
+
+	q.push(testMessage("hold"), sendOptions{Priority: priorityMessage})
+	<-s.started
+	for i := 0; i < sendQueueLimit; i++ {
+		if _, err := q.push(testPresence(""), sendOptions{Priority: priorityPresence}); err != nil {
+			t.Fatalf("push %d: %v", i, err)
+		}
+	}
+	if _, err := q.push(testPresence(""), sendOptions{Priority: priorityPresence}); !errors.Is(err, ErrSendQueueFull) {
+		t.Fatalf("expected ErrSendQueueFull, got %v", err)
+	}
+	// Other lanes are unaffected
+	if _, err := q.push(testIQ("i"), sendOptions{Priority: priorityIQ}); err != nil {
+		t.Fatalf("expected the IQ lane to accept, got %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/meszmate/xmpp-go/stanza"
+	"github.com/meszmate/xmpp-go/transport"
+)
+
+// TrafficStats counts what goes over an account's connections. The app
+// passes the same one to every client it connects the account with, so the
+// counts add up across reconnects until Reset. Bytes are counted on the
+// wire after TLS, stanzas as they are read and as the send queue writes
+// them; whitespace keepalives count as bytes only.
+type TrafficStats struct {
+	stanzasIn  atomic.Int64
+	stanzasOut atomic.Int64
+	bytesIn    atomic.Int64
+	bytesOut   atomic.Int64
+	latency    atomic.Int64 // round trip of the last server ping, in ns
+}
+
+// Traffic is a snapshot of TrafficStats
+type Traffic struct {
+	StanzasIn  int64
+	StanzasOut int64
+	BytesIn    int64
+	BytesOut   int64
+	Latency    time.Duration // 0 until the server was pinged
+}
+
+// Snapshot returns the current counts
+func (s *TrafficStats) Snapshot() Traffic {
+	if s == nil {
+		return Traffic{}
+	}
+	return Traffic{
+		StanzasIn:  s.stanzasIn.Load(),
+		StanzasOut: s.stanzasOut.Load(),
+		BytesIn:    s.bytesIn.Load(),
+		BytesOut:   s.bytesOut.Load(),
+		Latency:    time.Duration(s.latency.Load()),
+	}
+}
+
+// Reset sets the counts back to zero. The last latency is kept, it is a
+// measurement rather than a count.
+func (s *TrafficStats) Reset() {
+	if s == nil {
+		return
+	}
+	s.stanzasIn.Store(0)
+	s.stanzasOut.Store(0)
+	s.bytesIn.Store(0)
+	s.bytesOut.Store(0)
+}
+
+// stanzaRead counts a stanza read from the server
+func (s *TrafficStats) stanzaRead() {
+	if s != nil {
+		s.stanzasIn.Add(1)
+	}
+}
+
+// stanzaWritten counts a stanza written to the server
+func (s *TrafficStats) stanzaWritten() {
+	if s != nil {
+		s.stanzasOut.Add(1)
+	}
+}
+
+// pinged records the round trip of a server ping
+func (s *TrafficStats) pinged(rtt time.Duration) {
+	if s != nil {
+		s.latency.Store(int64(rtt))
+	}
+}
+
+// countingTransport counts the bytes read and written on a transport
+type countingTransport struct {
+	transport.Transport
+	stats *TrafficStats
+}
+
+func newCountingTransport(trans transport.Transport, stats *TrafficStats) *countingTransport {
+	return &countingTransport{Transport: trans, stats: stats}
+}
+
+func (t *countingTransport) Read(p []byte) (int, error) {
+	n, err := t.Transport.Read(p)
+	t.stats.bytesIn.Add(int64(n))
+	return n, err
+}
+
+func (t *countingTransport) Write(p []byte) (int, error) {
+	n, err := t.Transport.Write(p)
+	t.stats.bytesOut.Add(int64(n))
+	return n, err
+}
+
+// Conn exposes the underlying connection for read deadlines
+func (t *countingTransport) Conn() net.Conn {
+	if connGetter, ok := t.Transport.(interface{ Conn() net.Conn }); ok {
+		return connGetter.Conn()
+	}
+	return nil
+}
+
+// countingSend wraps the send queue's writer to count the stanzas written
+func (c *Client) countingSend(send func(context.Context, stanza.Stanza) error) func(context.Context, stanza.Stanza) error {
+	return func(ctx context.Context, st stanza.Stanza) error {
+		err := send(ctx, st)
+		if err == nil {
+			c.traffic.stanzaWritten()
+		}
+		return err
+	}
+}
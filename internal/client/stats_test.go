@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/meszmate/xmpp-go/stanza"
+)
+
+func TestCountingTransport(t *testing.T) {
+	stats := &TrafficStats{}
+	trans := newCountingTransport(&fakeTransport{r: strings.NewReader("<presence/>")}, stats)
+
+	buf := make([]byte, 64)
+	if _, err := trans.Read(buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if _, err := trans.Write([]byte("<iq/>")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	got := stats.Snapshot()
+	if got.BytesIn != int64(len("<presence/>")) || got.BytesOut != int64(len("<iq/>")) {
+		t.Fatalf("unexpected byte counts: %+v", got)
+	}
+}
+
+func TestCountingSendSkipsFailures(t *testing.T) {
+	c := &Client{traffic: &TrafficStats{}}
+	fail := false
+	send := c.countingSend(func(context.Context, stanza.Stanza) error {
+		if fail {
+			return errors.New("broken pipe")
+		}
+		return nil
+	})
+
+	_ = send(context.Background(), stanza.NewMessage(stanza.MessageChat))
+	fail = true
+	_ = send(context.Background(), stanza.NewMessage(stanza.MessageChat))
+
+	if got := c.traffic.Snapshot().StanzasOut; got != 1 {
+		t.Fatalf("expected 1 stanza out, got %d", got)
+	}
+}
+
+func TestTrafficStatsResetKeepsLatency(t *testing.T) {
+	stats := &TrafficStats{}
+	stats.stanzaRead()
+	stats.stanzaWritten()
+	stats.pinged(40 * time.Millisecond)
+	stats.Reset()
+
+	got := stats.Snapshot()
+	if got.StanzasIn != 0 || got.StanzasOut != 0 || got.Latency != 40*time.Millisecond {
+		t.Fatalf("unexpected stats after reset: %+v", got)
+	}
+
+	// A client without shared stats must not panic
+	var none *TrafficStats
+	none.stanzaRead()
+	none.Reset()
+	if none.Snapshot() != (Traffic{}) {
+		t.Fatal("expected empty snapshot")
+	}
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"time"
+)
+
+// TLSInfo describes how the connection to the server is encrypted
+type TLSInfo struct {
+	Version     string
+	CipherSuite string
+	ServerName  string            // sent as SNI and checked against the certificate
+	Chain       []CertificateInfo // the server's certificate first
+}
+
+// CertificateInfo summarizes one certificate of the server's chain
+type CertificateInfo struct {
+	Subject  string
+	Issuer   string
+	NotAfter time.Time
+}
+
+// Expires returns when the server's certificate expires, or the zero time
+// when there is none
+func (i TLSInfo) Expires() time.Time {
+	if len(i.Chain) == 0 {
+		return time.Time{}
+	}
+	return i.Chain[0].NotAfter
+}
+
+// ExpiresSoon reports whether the server's certificate expires within
+// certExpiryWarning of now
+func (i TLSInfo) ExpiresSoon(now time.Time) bool {
+	expires := i.Expires()
+	return !expires.IsZero() && expires.Sub(now) < certExpiryWarning
+}
+
+// TLSInfo returns the TLS details of the current connection. It reports
+// false when the client is not connected or the connection is unencrypted.
+func (c *Client) TLSInfo() (TLSInfo, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if !c.connected || c.tlsState == nil {
+		return TLSInfo{}, false
+	}
+	return newTLSInfo(*c.tlsState), true
+}
+
+func newTLSInfo(state tls.ConnectionState) TLSInfo {
+	info := TLSInfo{
+		Version:     tls.VersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		ServerName:  state.ServerName,
+	}
+	for _, cert := range state.PeerCertificates {
+		info.Chain = append(info.Chain, CertificateInfo{
+			Subject:  certificateName(cert.Subject.CommonName, cert),
+			Issuer:   certificateName(cert.Issuer.CommonName, nil),
+			NotAfter: cert.NotAfter,
+		})
+	}
+	return info
+}
+
+// certificateName names a certificate by its common name, falling back to
+// its first DNS name, as current server certificates often leave CN empty
+func certificateName(cn string, cert *x509.Certificate) string {
+	if cn != "" {
+		return cn
+	}
+	if cert != nil && len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	return "(unnamed)"
+}
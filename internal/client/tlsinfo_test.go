@@ -0,0 +1,47 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+)
+
+func TestTLSInfoSummarizesChain(t *testing.T) {
+	now := time.Now()
+	state := tls.ConnectionState{
+		Version:     tls.VersionTLS13,
+		CipherSuite: tls.TLS_AES_128_GCM_SHA256,
+		ServerName:  "example.com",
+		PeerCertificates: []*x509.Certificate{
+			{
+				DNSNames: []string{"example.com", "conference.example.com"},
+				Issuer:   pkix.Name{CommonName: "R11"},
+				NotAfter: now.Add(10 * 24 * time.Hour),
+			},
+			{
+				Subject:  pkix.Name{CommonName: "R11"},
+				Issuer:   pkix.Name{CommonName: "ISRG Root X1"},
+				NotAfter: now.Add(365 * 24 * time.Hour),
+			},
+		},
+	}
+
+	info := newTLSInfo(state)
+	if info.Version != "TLS 1.3" || info.CipherSuite != "TLS_AES_128_GCM_SHA256" || info.ServerName != "example.com" {
+		t.Fatalf("unexpected summary %+v", info)
+	}
+	if len(info.Chain) != 2 || info.Chain[0].Subject != "example.com" || info.Chain[0].Issuer != "R11" || info.Chain[1].Subject != "R11" {
+		t.Fatalf("unexpected chain %+v", info.Chain)
+	}
+	if !info.ExpiresSoon(now) {
+		t.Fatalf("a certificate expiring in 10 days should be reported")
+	}
+	if info.ExpiresSoon(now.Add(-5 * 24 * time.Hour)) {
+		t.Fatalf("15 days before expiry is not soon")
+	}
+	if (TLSInfo{}).ExpiresSoon(now) {
+		t.Fatalf("no certificate should not be reported")
+	}
+}
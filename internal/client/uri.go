@@ -0,0 +1,127 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/meszmate/xmpp-go/jid"
+)
+
+// XEP-0147 query actions understood in xmpp: URIs
+const (
+	URIActionMessage   = "message"
+	URIActionJoin      = "join"
+	URIActionRoster    = "roster"
+	URIActionSubscribe = "subscribe"
+)
+
+// URI is an RFC 5122 xmpp: URI with an optional XEP-0147 query, such as
+// xmpp:room@conference.example.com?join or
+// xmpp:alice@example.com?message;body=Hello
+type URI struct {
+	JID    string
+	Action string            // empty when the URI has no query
+	Params map[string]string // query parameters after the action
+}
+
+// uriRe finds xmpp: URIs in message text
+var uriRe = regexp.MustCompile(`xmpp:[^\s<>"']+`)
+
+// ParseURI parses an xmpp: URI. Any //authority part naming the account to
+// use is ignored.
+func ParseURI(s string) (URI, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(s), "xmpp:")
+	if !ok {
+		return URI{}, fmt.Errorf("not an xmpp: URI")
+	}
+	if strings.HasPrefix(rest, "//") {
+		_, rest, _ = strings.Cut(rest[2:], "/")
+	}
+	path, query, _ := strings.Cut(rest, "?")
+	if frag := strings.IndexByte(query, '#'); frag >= 0 {
+		query = query[:frag]
+	}
+
+	address, err := url.PathUnescape(path)
+	if err != nil {
+		return URI{}, fmt.Errorf("invalid address: %w", err)
+	}
+	target, err := jid.Parse(address)
+	if err != nil || address == "" {
+		return URI{}, fmt.Errorf("invalid address %q", address)
+	}
+
+	u := URI{JID: target.String(), Params: make(map[string]string)}
+	if query == "" {
+		return u, nil
+	}
+	parts := strings.Split(query, ";")
+	u.Action = parts[0]
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(part, "=")
+		if key == "" {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(value); err == nil {
+			value = unescaped
+		}
+		u.Params[key] = value
+	}
+	return u, nil
+}
+
+// String formats the URI, percent-encoding the address and parameters.
+// Parameters are written in key order.
+func (u URI) String() string {
+	var b strings.Builder
+	b.WriteString("xmpp:")
+	bare, resource, hasResource := strings.Cut(u.JID, "/")
+	b.WriteString(escapeURIPart(bare))
+	if hasResource {
+		b.WriteString("/" + escapeURIPart(resource))
+	}
+	if u.Action == "" {
+		return b.String()
+	}
+	b.WriteString("?" + u.Action)
+
+	keys := make([]string, 0, len(u.Params))
+	for k := range u.Params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.WriteString(";" + k + "=" + escapeURIPart(u.Params[k]))
+	}
+	return b.String()
+}
+
+// escapeURIPart percent-encodes everything that is not safe inside a path
+// segment or query value of an xmpp: URI
+func escapeURIPart(s string) string {
+	return strings.NewReplacer("=", "%3D", "&", "%26").Replace(url.PathEscape(s))
+}
+
+// FindURI returns the first xmpp: URI in text, or "" if there is none.
+// Trailing punctuation that ends a sentence is not part of the URI.
+func FindURI(text string) string {
+	return strings.TrimRight(uriRe.FindString(text), ".,!?)")
+}
+
+// JoinURI returns the URI inviting someone to a room
+func JoinURI(roomJID string) URI {
+	return URI{JID: roomJID, Action: URIActionJoin}
+}
+
+// RosterURI returns the URI for adding a JID as a contact, with the name
+// it should be added under if known
+func RosterURI(contactJID, name string) URI {
+	u := URI{JID: contactJID, Action: URIActionRoster}
+	if name != "" {
+		u.Params = map[string]string{"name": name}
+	}
+	return u
+}
@@ -0,0 +1,51 @@
+package client
+
+import "testing"
+
+func TestParseURI(t *testing.T) {
+	u, err := ParseURI("xmpp://me@example.com/alice@example.com?message;subject=Hi;body=Hello%20there%3B%20friend")
+	if err != nil {
+		t.Fatalf("ParseURI: %v", err)
+	}
+	if u.JID != "alice@example.com" || u.Action != URIActionMessage {
+		t.Fatalf("unexpected URI: %+v", u)
+	}
+	if u.Params["body"] != "Hello there; friend" || u.Params["subject"] != "Hi" {
+		t.Fatalf("unexpected params: %+v", u.Params)
+	}
+
+	u, err = ParseURI("xmpp:room@conference.example.com?join;password=s3cret")
+	if err != nil || u.Action != URIActionJoin || u.Params["password"] != "s3cret" {
+		t.Fatalf("unexpected join URI: %+v, %v", u, err)
+	}
+
+	for _, bad := range []string{"https://example.com", "xmpp:", "xmpp:?join"} {
+		if _, err := ParseURI(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestURIRoundTrip(t *testing.T) {
+	u := RosterURI("bob@example.com", "Bob & Co = friends")
+	s := u.String()
+	if s != "xmpp:bob@example.com?roster;name=Bob%20%26%20Co%20%3D%20friends" {
+		t.Fatalf("unexpected URI string %q", s)
+	}
+	back, err := ParseURI(s)
+	if err != nil || back.JID != u.JID || back.Action != u.Action || back.Params["name"] != u.Params["name"] {
+		t.Fatalf("round trip changed the URI: %+v, %v", back, err)
+	}
+	if s := JoinURI("dev@muc.example.com").String(); s != "xmpp:dev@muc.example.com?join" {
+		t.Fatalf("unexpected join URI %q", s)
+	}
+}
+
+func TestFindURI(t *testing.T) {
+	if got := FindURI("come join us at xmpp:dev@muc.example.com?join."); got != "xmpp:dev@muc.example.com?join" {
+		t.Fatalf("FindURI = %q", got)
+	}
+	if got := FindURI("no links here"); got != "" {
+		t.Fatalf("FindURI = %q, expected none", got)
+	}
+}
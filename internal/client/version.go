@@ -0,0 +1,117 @@
+package client
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/meszmate/xmpp-go/jid"
+	"github.com/meszmate/xmpp-go/plugins/version"
+	"github.com/meszmate/xmpp-go/stanza"
+)
+
+// versionTimeout bounds how long a software version query waits; clients
+// that do not implement it often never answer
+const versionTimeout = 10 * time.Second
+
+// SoftwareVersion is the software an entity runs, as reported with XEP-0092
+type SoftwareVersion struct {
+	Name    string
+	Version string
+	OS      string // often withheld
+}
+
+// String formats the version for display, e.g. "Conversations 2.16 (Android)"
+func (v SoftwareVersion) String() string {
+	s := strings.TrimSpace(v.Name + " " + v.Version)
+	if s == "" {
+		s = "unknown"
+	}
+	if v.OS != "" {
+		s += " (" + v.OS + ")"
+	}
+	return s
+}
+
+// SetVersionHandler sets what incoming software version queries are
+// answered with. The handler runs for every query; when it or its result is
+// nil the query is refused with service-unavailable, the same reply as from
+// a client without the feature, so nothing about the software leaks.
+func (c *Client) SetVersionHandler(handler func() *SoftwareVersion) {
+	c.onVersionQuery = handler
+}
+
+// QueryVersion asks an entity which software it runs. A bare contact JID is
+// sent to its online client; servers and services are asked directly.
+func (c *Client) QueryVersion(to string) (SoftwareVersion, error) {
+	c.mu.RLock()
+	if !c.connected {
+		c.mu.RUnlock()
+		return SoftwareVersion{}, fmt.Errorf("not connected")
+	}
+	session := c.session
+	full := to
+	if !strings.Contains(to, "/") && strings.Contains(to, "@") {
+		full = c.preferredResourceLocked(to)
+	}
+	c.mu.RUnlock()
+
+	if full == "" {
+		return SoftwareVersion{}, fmt.Errorf("%s has no online client to ask", to)
+	}
+	target, err := jid.Parse(full)
+	if err != nil {
+		return SoftwareVersion{}, fmt.Errorf("invalid JID: %w", err)
+	}
+	iq := stanza.NewIQ(stanza.IQGet)
+	iq.To = target
+	iq.Query, _ = xml.Marshal(version.Query{})
+
+	resp, err := c.sendIQAndWait(session, iq, versionTimeout)
+	if err != nil {
+		return SoftwareVersion{}, err
+	}
+	var reply version.Query
+	if err := xml.Unmarshal(resp.Query, &reply); err != nil {
+		return SoftwareVersion{}, fmt.Errorf("unreadable version reply: %w", err)
+	}
+	return SoftwareVersion{
+		Name:    strings.TrimSpace(reply.Name),
+		Version: strings.TrimSpace(reply.Version),
+		OS:      strings.TrimSpace(reply.OS),
+	}, nil
+}
+
+// handleVersionQuery answers a software version query
+func (c *Client) handleVersionQuery(iq *stanza.IQ) bool {
+	var query version.Query
+	if err := xml.Unmarshal(iq.Query, &query); err != nil || query.XMLName.Space != "jabber:iq:version" {
+		return false
+	}
+
+	c.mu.RLock()
+	session := c.session
+	c.mu.RUnlock()
+	if session == nil {
+		return true
+	}
+
+	var info *SoftwareVersion
+	if c.onVersionQuery != nil {
+		info = c.onVersionQuery()
+	}
+	_ = c.sendAsync(session, versionReply(iq, info), sendOptions{Priority: priorityIQ})
+	return true
+}
+
+// versionReply answers a version query with info, or refuses it when info
+// is nil
+func versionReply(iq *stanza.IQ, info *SoftwareVersion) *stanza.IQ {
+	if info == nil {
+		return iq.ErrorIQ(stanza.NewStanzaError(stanza.ErrorTypeCancel, stanza.ErrorServiceUnavailable, ""))
+	}
+	reply := iq.ResultIQ()
+	reply.Query, _ = xml.Marshal(version.Query{Name: info.Name, Version: info.Version, OS: info.OS})
+	return reply
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/meszmate/xmpp-go/jid"
+	"github.com/meszmate/xmpp-go/stanza"
+)
+
+func TestVersionReply(t *testing.T) {
+	query := stanza.NewIQ(stanza.IQGet)
+	query.From = jid.MustParse("juliet@example.com/balcony")
+	query.Query = []byte(`<query xmlns="jabber:iq:version"/>`)
+
+	reply := versionReply(query, &SoftwareVersion{Name: "roster", Version: "1.2"})
+	out, err := xml.Marshal(reply)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if reply.Type != stanza.IQResult || reply.ID != query.ID || reply.To.String() != "juliet@example.com/balcony" {
+		t.Fatalf("reply not addressed to the query: %s", out)
+	}
+	if !strings.Contains(string(out), "<name>roster</name><version>1.2</version>") || strings.Contains(string(out), "<os>") {
+		t.Fatalf("unexpected reply %s", out)
+	}
+
+	refusal := versionReply(query, nil)
+	out, err = xml.Marshal(refusal)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if refusal.Type != stanza.IQError || !strings.Contains(string(out), "service-unavailable") || strings.Contains(string(out), "roster") {
+		t.Fatalf("expected a bare service-unavailable refusal, got %s", out)
+	}
+}
+
+func TestSoftwareVersionString(t *testing.T) {
+	cases := map[SoftwareVersion]string{
+		{Name: "Conversations", Version: "2.16", OS: "Android"}: "Conversations 2.16 (Android)",
+		{Name: "Gajim"}: "Gajim",
+		{}:              "unknown",
+	}
+	for v, want := range cases {
+		if got := v.String(); got != want {
+			t.Errorf("%+v: expected %q, got %q", v, want, got)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package client
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+
+	"github.com/meszmate/xmpp-go/jid"
+	"github.com/meszmate/xmpp-go/plugins/form"
+	"github.com/meszmate/xmpp-go/plugins/muc"
+	"github.com/meszmate/xmpp-go/stanza"
+)
+
+// In a moderated room only participants and moderators may speak; visitors
+// ask for voice with a muc#request form sent to the room, which passes it
+// on to the moderators as a form to approve or deny (XEP-0045 7.13, 8.6).
+
+const (
+	nsMUCUser        = "http://jabber.org/protocol/muc#user"
+	voiceRequestForm = "http://jabber.org/protocol/muc#request"
+
+	// mucSelfPresence is the status code of the presence about our own
+	// occupant
+	mucSelfPresence = 110
+)
+
+// RoomPresence is what a room tells about an occupant in its presence
+type RoomPresence struct {
+	Role        string // moderator, participant, visitor or none
+	Affiliation string
+	JID         string // the occupant's real JID, when the room reveals it
+	Self        bool   // the presence is about our own occupant
+}
+
+// VoiceRequest is a visitor asking a room's moderators for voice
+type VoiceRequest struct {
+	Room jid.JID
+	JID  string // the visitor's real JID, when the room reveals it
+	Nick string
+}
+
+// roomPresence extracts the muc#user data of a room presence
+func roomPresence(p *stanza.Presence) *RoomPresence {
+	for _, ext := range p.Extensions {
+		if ext.XMLName.Space != nsMUCUser || ext.XMLName.Local != "x" {
+			continue
+		}
+		extXML, err := extensionOuterXML(ext)
+		if err != nil {
+			continue
+		}
+		var x muc.UserX
+		if err := xml.Unmarshal(extXML, &x); err != nil {
+			continue
+		}
+		rp := &RoomPresence{}
+		if len(x.Items) > 0 {
+			rp.Role = x.Items[0].Role
+			rp.Affiliation = x.Items[0].Affiliation
+			rp.JID = x.Items[0].JID
+		}
+		for _, status := range x.Status {
+			if status.Code == mucSelfPresence {
+				rp.Self = true
+			}
+		}
+		return rp
+	}
+	return nil
+}
+
+// voiceRequestFromMessage extracts a voice request a room forwards to its
+// moderators
+func voiceRequestFromMessage(msg *stanza.Message) (VoiceRequest, bool) {
+	for _, ext := range msg.Extensions {
+		if ext.XMLName.Space != "jabber:x:data" || ext.XMLName.Local != "x" {
+			continue
+		}
+		extXML, err := extensionOuterXML(ext)
+		if err != nil {
+			continue
+		}
+		var x form.Form
+		if err := xml.Unmarshal(extXML, &x); err != nil || x.Type != form.TypeForm || formType(x) != voiceRequestForm {
+			continue
+		}
+		return VoiceRequest{
+			Room: msg.From.Bare(),
+			JID:  x.GetValue("muc#jid"),
+			Nick: x.GetValue("muc#roomnick"),
+		}, true
+	}
+	return VoiceRequest{}, false
+}
+
+func (c *Client) emitVoiceRequest(req VoiceRequest) {
+	if c.onVoiceRequest != nil {
+		c.onVoiceRequest(req)
+	}
+}
+
+// RequestVoice asks the moderators of a room for voice
+func (c *Client) RequestVoice(roomJID string) error {
+	return c.sendVoiceForm(roomJID, []form.Field{
+		{Var: "muc#role", Type: form.FieldListSingle, Values: []string{"participant"}},
+	})
+}
+
+// AnswerVoiceRequest grants or denies a voice request as a moderator
+func (c *Client) AnswerVoiceRequest(req VoiceRequest, allow bool) error {
+	fields := []form.Field{
+		{Var: "muc#role", Type: form.FieldListSingle, Values: []string{"participant"}},
+		{Var: "muc#roomnick", Type: form.FieldTextSingle, Values: []string{req.Nick}},
+		{Var: "muc#request_allow", Type: form.FieldBoolean, Values: []string{strconv.FormatBool(allow)}},
+	}
+	if req.JID != "" {
+		fields = append(fields, form.Field{Var: "muc#jid", Type: form.FieldJIDSingle, Values: []string{req.JID}})
+	}
+	return c.sendVoiceForm(req.Room.String(), fields)
+}
+
+// sendVoiceForm sends a submitted muc#request form to a room
+func (c *Client) sendVoiceForm(roomJID string, fields []form.Field) error {
+	c.mu.RLock()
+	if !c.connected {
+		c.mu.RUnlock()
+		return fmt.Errorf("not connected")
+	}
+	session := c.session
+	c.mu.RUnlock()
+
+	to, err := jid.Parse(roomJID)
+	if err != nil {
+		return fmt.Errorf("invalid JID: %w", err)
+	}
+
+	fields = append([]form.Field{
+		{Var: "FORM_TYPE", Type: form.FieldHidden, Values: []string{voiceRequestForm}},
+	}, fields...)
+	var inner []byte
+	for _, field := range fields {
+		fieldXML, err := xml.Marshal(field)
+		if err != nil {
+			return fmt.Errorf("failed to marshal voice request: %w", err)
+		}
+		inner = append(inner, fieldXML...)
+	}
+
+	msg := stanza.NewMessage(stanza.MessageNormal)
+	msg.To = to
+	msg.Extensions = append(msg.Extensions, stanza.Extension{
+		XMLName: xml.Name{Space: "jabber:x:data", Local: "x"},
+		Attrs:   []xml.Attr{{Name: xml.Name{Local: "type"}, Value: form.TypeSubmit}},
+		Inner:   inner,
+	})
+
+	return c.sendQueued(c.ctx, session, msg, sendOptions{Priority: priorityMessage})
+}
@@ -0,0 +1,85 @@
+package client
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/meszmate/xmpp-go/stanza"
+)
+
+func TestRoomPresence(t *testing.T) {
+	payload := `<presence from='coven@chat.shakespeare.lit/thirdwitch'>` +
+		`<x xmlns='http://jabber.org/protocol/muc#user'>` +
+		`<item affiliation='none' role='visitor'/>` +
+		`<status code='110'/>` +
+		`</x></presence>`
+
+	var p stanza.Presence
+	if err := xml.Unmarshal([]byte(payload), &p); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	rp := roomPresence(&p)
+	if rp == nil || rp.Role != "visitor" || rp.Affiliation != "none" || !rp.Self {
+		t.Fatalf("unexpected room presence: %+v", rp)
+	}
+
+	var plain stanza.Presence
+	if err := xml.Unmarshal([]byte(`<presence from='juliet@capulet.lit/balcony'/>`), &plain); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if rp := roomPresence(&plain); rp != nil {
+		t.Fatalf("expected no room data, got %+v", rp)
+	}
+}
+
+func TestRoomPresenceRealJID(t *testing.T) {
+	payload := `<presence from='coven@chat.shakespeare.lit/firstwitch'>` +
+		`<x xmlns='http://jabber.org/protocol/muc#user'>` +
+		`<item affiliation='owner' role='moderator' jid='crone1@shakespeare.lit/desktop'/>` +
+		`</x></presence>`
+
+	var p stanza.Presence
+	if err := xml.Unmarshal([]byte(payload), &p); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	rp := roomPresence(&p)
+	if rp == nil || rp.JID != "crone1@shakespeare.lit/desktop" || rp.Affiliation != "owner" || rp.Self {
+		t.Fatalf("unexpected room presence: %+v", rp)
+	}
+}
+
+func TestVoiceRequestFromMessage(t *testing.T) {
+	payload := `<message from='coven@chat.shakespeare.lit'>` +
+		`<x xmlns='jabber:x:data' type='form'>` +
+		`<field var='FORM_TYPE' type='hidden'><value>http://jabber.org/protocol/muc#request</value></field>` +
+		`<field var='muc#role' type='list-single'><value>participant</value></field>` +
+		`<field var='muc#jid' type='jid-single'><value>hag66@shakespeare.lit/pda</value></field>` +
+		`<field var='muc#roomnick' type='text-single'><value>thirdwitch</value></field>` +
+		`<field var='muc#request_allow' type='boolean'><value>false</value></field>` +
+		`</x></message>`
+
+	var msg stanza.Message
+	if err := xml.Unmarshal([]byte(payload), &msg); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	req, ok := voiceRequestFromMessage(&msg)
+	if !ok {
+		t.Fatal("expected a voice request")
+	}
+	if req.Room.String() != "coven@chat.shakespeare.lit" || req.JID != "hag66@shakespeare.lit/pda" || req.Nick != "thirdwitch" {
+		t.Fatalf("unexpected voice request: %+v", req)
+	}
+
+	// Our own submitted request is not one to answer
+	submitted := `<message from='coven@chat.shakespeare.lit'>` +
+		`<x xmlns='jabber:x:data' type='submit'>` +
+		`<field var='FORM_TYPE'><value>http://jabber.org/protocol/muc#request</value></field>` +
+		`</x></message>`
+	msg = stanza.Message{}
+	if err := xml.Unmarshal([]byte(submitted), &msg); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if _, ok := voiceRequestFromMessage(&msg); ok {
+		t.Fatal("a submitted form is not a voice request")
+	}
+}
@@ -1,9 +1,12 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 )
@@ -16,23 +19,163 @@ type Config struct {
 	Plugins    PluginsConfig    `toml:"plugins"`
 	Logging    LoggingConfig    `toml:"logging"`
 	Storage    StorageConfig    `toml:"storage"`
+
+	// Snippets are canned responses available to every account, by name.
+	// Typing ;name in the composer expands to the text.
+	Snippets map[string]string `toml:"snippets"`
+
+	// Aliases are user defined commands, by name. An alias runs one or more
+	// commands separated by &&, e.g. w1 = "window 1 && status online".
+	Aliases map[string]string `toml:"aliases"`
+
+	// Sounds select how incoming messages are announced, per conversation.
+	Sounds SoundsConfig `toml:"sounds"`
+
+	// LinkPreviews control inline URL previews in the chat.
+	LinkPreviews LinkPreviewConfig `toml:"link_previews"`
+
+	// SoftwareVersion is what contacts are told when they ask which client
+	// this is (XEP-0092).
+	SoftwareVersion SoftwareVersionConfig `toml:"software_version"`
+
+	// ChatLogs are plain text logs of conversations, one file per day.
+	ChatLogs ChatLogConfig `toml:"chat_logs"`
+
+	// StatusHook sets the presence from the output of a command, e.g. one
+	// that reads a calendar.
+	StatusHook StatusHookConfig `toml:"status_hook"`
+
+	// Requests decide what happens to subscription requests and messages
+	// from people who are not in the roster.
+	Requests RequestsConfig `toml:"requests"`
+
+	// Policy force-disables features, for deployments that must not offer
+	// them.
+	Policy PolicyConfig `toml:"policy"`
 }
 
 // GeneralConfig contains general application settings
 type GeneralConfig struct {
 	DataDir     string `toml:"data_dir"`
 	AutoConnect bool   `toml:"auto_connect"`
+	// Minutes the master passphrase of an encrypted accounts file is kept
+	// after it was entered, 0 for the whole session
+	PassphraseCacheMinutes int `toml:"passphrase_cache_minutes"`
 }
 
 // UIConfig contains UI-related settings
 type UIConfig struct {
-	Theme          string `toml:"theme"`
-	RosterPosition string `toml:"roster_position"`
-	RosterWidth    int    `toml:"roster_width"`
-	ShowTimestamps bool   `toml:"show_timestamps"`
-	TimeFormat     string `toml:"time_format"`
-	DateFormat     string `toml:"date_format"`
-	Notifications  bool   `toml:"notifications"`
+	Theme           string   `toml:"theme"`
+	RosterPosition  string   `toml:"roster_position"`
+	RosterWidth     int      `toml:"roster_width"`
+	ShowTimestamps  bool     `toml:"show_timestamps"`
+	TimeFormat      string   `toml:"time_format"`
+	DateFormat      string   `toml:"date_format"`
+	Notifications   bool     `toml:"notifications"`
+	MessageStyling  bool     `toml:"message_styling"`
+	WindowList      string   `toml:"window_list"`      // full, numbers, neighbors, unread or tabbar
+	WindowOrder     string   `toml:"window_order"`     // fixed or activity
+	RosterSort      string   `toml:"roster_sort"`      // recent or presence
+	RosterGroup     string   `toml:"roster_group"`     // none, groups or domain
+	WhichKeyDelay   int      `toml:"which_key_delay"`  // ms before the prefix key popup shows, negative disables it
+	ChatWrap        string   `toml:"chat_wrap"`        // indent or flush
+	ChatMaxWidth    int      `toml:"chat_max_width"`   // columns a message body may use, 0 for the full pane
+	NickColumn      int      `toml:"nick_column"`      // width nicks are aligned to, 0 disables alignment
+	ChatLayout      string   `toml:"chat_layout"`      // compact, cozy or bubble; empty uses the theme's layout
+	LowBandwidth    bool     `toml:"low_bandwidth"`    // fewer redraws and no animations, for slow links
+	Clipboard       string   `toml:"clipboard"`        // auto, osc52 or external
+	TerminalTitle   bool     `toml:"terminal_title"`   // name the active conversation and unread count in the terminal title
+	MultiplexerBell bool     `toml:"multiplexer_bell"` // ring the bell on new messages inside tmux or screen, for their activity flags
+	AwayOnBlur      int      `toml:"away_on_blur"`     // minutes without terminal focus before going away, 0 disables
+	RosterColumns   []string `toml:"roster_columns"`   // parts of a roster row in order; empty for the default
+}
+
+// SoundsConfig contains notification sound settings. A sound is "bell",
+// "bell:N" (N terminal bells), "none", or "exec:<command>".
+type SoundsConfig struct {
+	Default       string            `toml:"default"`
+	Conversations map[string]string `toml:"conversations"` // contact or room JID -> sound
+}
+
+// LinkPreviewConfig contains URL preview settings. Fetching a preview
+// reveals your IP address to the linked site, so previews are only loaded
+// for accounts and contacts that opted in with :preview.
+type LinkPreviewConfig struct {
+	Enabled    bool `toml:"enabled"`
+	AllowHTTP  bool `toml:"allow_http"`  // also preview plain http:// links
+	CacheHours int  `toml:"cache_hours"` // how long fetched previews are reused
+}
+
+// SoftwareVersionConfig controls the reply to software version queries.
+// Without Reply they are refused as if the feature did not exist. The
+// operating system is only told with ShowOS, as it helps pick exploits.
+type SoftwareVersionConfig struct {
+	Reply   bool   `toml:"reply"`
+	Name    string `toml:"name"`    // empty for "roster"
+	Version string `toml:"version"` // empty for the version of the build
+	ShowOS  bool   `toml:"show_os"`
+}
+
+// ChatLogConfig controls the plain text conversation logs. Template names
+// the file below Dir and may use {account}, {jid}, {date}, {year}, {month}
+// and {day}.
+type ChatLogConfig struct {
+	Enabled  bool   `toml:"enabled"`
+	Dir      string `toml:"dir"`      // empty for logs/chats in the data directory
+	Template string `toml:"template"` // empty for {account}/{jid}/{date}.log
+}
+
+// StatusHookConfig is a command run every Interval seconds (60 when 0)
+// that prints "show|message", e.g. "dnd|In a meeting until 15:00", to set
+// the status, or an empty line to restore the one set before.
+type StatusHookConfig struct {
+	Command  string `toml:"command"`
+	Interval int    `toml:"interval"`
+}
+
+// RequestsConfig is spam protection for contact requests. Messages from
+// senders who are not in the roster go to the Requests section of the
+// roster instead of opening a window, at most MaxPerHour from each; both
+// they and subscription requests from BlockedDomains (or their subdomains)
+// are declined without asking.
+type RequestsConfig struct {
+	Quarantine     bool     `toml:"quarantine"`
+	MaxPerHour     int      `toml:"max_per_hour"` // 0 for no limit
+	BlockedDomains []string `toml:"blocked_domains"`
+}
+
+// PolicyConfig lists features no account may use in Deny and those only
+// some accounts may not in Accounts, by account JID. The features are
+// file_upload, link_open, plaintext_fallback and plugins; plugins serve
+// every account, so they are off when any account denies them.
+type PolicyConfig struct {
+	Deny     []string            `toml:"deny"`
+	Accounts map[string][]string `toml:"accounts"`
+}
+
+// PolicyFeatures are the features a policy can deny
+var PolicyFeatures = []string{"file_upload", "link_open", "plaintext_fallback", "plugins"}
+
+// validate refuses features the policy does not know, so that a misspelt
+// one does not leave the feature on
+func (p PolicyConfig) validate() error {
+	check := func(features []string) error {
+		for _, f := range features {
+			if !slices.Contains(PolicyFeatures, f) {
+				return fmt.Errorf("unknown policy feature %q (known: %s)", f, strings.Join(PolicyFeatures, ", "))
+			}
+		}
+		return nil
+	}
+	if err := check(p.Deny); err != nil {
+		return err
+	}
+	for _, features := range p.Accounts {
+		if err := check(features); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // EncryptionConfig contains encryption settings
@@ -46,6 +189,11 @@ type EncryptionConfig struct {
 type PluginsConfig struct {
 	Enabled   []string `toml:"enabled"`
 	PluginDir string   `toml:"plugin_dir"`
+	IndexURL  string   `toml:"index_url"` // curated plugin index for :plugin
+
+	// Permissions granted to each plugin, checked against its manifest
+	// before it loads
+	Permissions map[string][]string `toml:"permissions"`
 }
 
 // LoggingConfig contains logging settings
@@ -74,6 +222,10 @@ type StorageConfig struct {
 
 	// VacuumOnStartup runs database vacuum on startup
 	VacuumOnStartup bool `toml:"vacuum_on_startup"`
+
+	// TrackPresenceHistory records contacts' online/offline transitions to
+	// show their typical availability
+	TrackPresenceHistory bool `toml:"track_presence_history"`
 }
 
 // Account represents an XMPP account configuration
@@ -87,7 +239,18 @@ type Account struct {
 	Port        int    `toml:"port"`
 	Priority    int    `toml:"priority"`
 	Resource    string `toml:"resource"`
-	Session     bool   `toml:"-"` // Session-only account, not saved to disk
+	// Keep-alive settings in seconds: 0 uses the client default, negative disables.
+	KeepAliveInterval int `toml:"keepalive_interval"`
+	PingInterval      int `toml:"ping_interval"`
+	PingTimeout       int `toml:"ping_timeout"`
+	// Auto-connect order: lower connect_order goes first, accounts with the same
+	// one keep their order in the file. connect_delay waits that many seconds
+	// after the previous account started, for servers that limit logins.
+	ConnectOrder int `toml:"connect_order"`
+	ConnectDelay int `toml:"connect_delay"`
+	// Snippets specific to this account; they override global ones with the same name.
+	Snippets map[string]string `toml:"snippets,omitempty"`
+	Session  bool              `toml:"-"` // Session-only account, not saved to disk
 }
 
 // AccountsConfig contains all account configurations
@@ -117,6 +280,29 @@ func DefaultConfig() *Config {
 			TimeFormat:     "15:04",
 			DateFormat:     "2006-01-02",
 			Notifications:  true,
+			MessageStyling: true,
+			WindowList:     "full",
+			WindowOrder:    "fixed",
+			RosterSort:     "recent",
+			RosterGroup:    "none",
+			WhichKeyDelay:  500,
+			ChatWrap:       "indent",
+			TerminalTitle:  true,
+		},
+		Sounds: SoundsConfig{
+			Default: "bell",
+		},
+		LinkPreviews: LinkPreviewConfig{
+			Enabled:    true,
+			AllowHTTP:  false,
+			CacheHours: 24,
+		},
+		SoftwareVersion: SoftwareVersionConfig{
+			Reply: true,
+		},
+		Requests: RequestsConfig{
+			Quarantine: true,
+			MaxPerHour: 5,
 		},
 		Encryption: EncryptionConfig{
 			Default:           "omemo",
@@ -139,6 +325,7 @@ func DefaultConfig() *Config {
 			SaveWindowState:      true,
 			MaxMessageSize:       1024 * 1024, // 1MB
 			VacuumOnStartup:      false,
+			TrackPresenceHistory: true,
 		},
 	}
 }
@@ -218,6 +405,9 @@ func Load() (*Config, error) {
 	if _, err := toml.DecodeFile(configPath, cfg); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
+	if err := cfg.Policy.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file: %w", err)
+	}
 
 	// Expand paths
 	if cfg.General.DataDir == "" {
@@ -238,10 +428,15 @@ func Load() (*Config, error) {
 		cfg.Logging.File = expandPath(cfg.Logging.File)
 	}
 
+	if cfg.ChatLogs.Dir != "" {
+		cfg.ChatLogs.Dir = expandPath(cfg.ChatLogs.Dir)
+	}
+
 	return cfg, nil
 }
 
-// LoadAccounts loads account configurations
+// LoadAccounts loads account configurations. An encrypted accounts file
+// is decrypted on the way, see vault.go.
 func LoadAccounts() (*AccountsConfig, error) {
 	paths, err := GetPaths()
 	if err != nil {
@@ -250,12 +445,21 @@ func LoadAccounts() (*AccountsConfig, error) {
 
 	accountsPath := filepath.Join(paths.ConfigDir, "accounts.toml")
 
-	if _, err := os.Stat(accountsPath); os.IsNotExist(err) {
+	data, err := os.ReadFile(accountsPath)
+	if os.IsNotExist(err) {
 		return &AccountsConfig{Accounts: []Account{}}, nil
 	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read accounts file: %w", err)
+	}
+	if env, ok := parseEncryptedAccounts(data); ok {
+		if data, err = decryptAccountsFile(env); err != nil {
+			return nil, fmt.Errorf("failed to decrypt accounts file: %w", err)
+		}
+	}
 
 	var accounts AccountsConfig
-	if _, err := toml.DecodeFile(accountsPath, &accounts); err != nil {
+	if _, err := toml.Decode(string(data), &accounts); err != nil {
 		return nil, fmt.Errorf("failed to parse accounts file: %w", err)
 	}
 
@@ -297,24 +501,31 @@ func Save(cfg *Config) error {
 	return nil
 }
 
-// SaveAccounts saves account configurations
+// SaveAccounts saves account configurations, encrypted when the accounts
+// file is kept encrypted. It fails with ErrAccountsLocked, leaving the file
+// as it was, when the passphrase is needed again.
 func SaveAccounts(accounts *AccountsConfig) error {
 	paths, err := GetPaths()
 	if err != nil {
 		return err
 	}
 
-	accountsPath := filepath.Join(paths.ConfigDir, "accounts.toml")
-	f, err := os.Create(accountsPath)
-	if err != nil {
-		return fmt.Errorf("failed to create accounts file: %w", err)
-	}
-	defer f.Close()
-
-	encoder := toml.NewEncoder(f)
+	var buf bytes.Buffer
+	encoder := toml.NewEncoder(&buf)
 	if err := encoder.Encode(accounts); err != nil {
 		return fmt.Errorf("failed to encode accounts: %w", err)
 	}
+	data := buf.Bytes()
+	if AccountsEncrypted() {
+		if data, err = encryptAccountsFile(data); err != nil {
+			return fmt.Errorf("failed to encrypt accounts: %w", err)
+		}
+	}
+
+	accountsPath := filepath.Join(paths.ConfigDir, "accounts.toml")
+	if err := os.WriteFile(accountsPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write accounts file: %w", err)
+	}
 
 	return nil
 }
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// What roster keeps encrypted with a passphrase, the accounts file and
+// accounts exports, is sealed the same way: AES-256-GCM with a key derived
+// by scrypt, in a JSON envelope that names its format. The format is also
+// the additional data, so one kind of envelope cannot pass for another.
+
+const (
+	sealKDF = "scrypt"
+
+	// scrypt parameters for deriving the key from the passphrase
+	sealScryptN = 1 << 15
+	sealScryptR = 8
+	sealScryptP = 1
+)
+
+// ErrWrongPassphrase is returned when the passphrase does not open a sealed
+// envelope
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted file")
+
+// sealedEnvelope is the on-disk form of sealed data
+type sealedEnvelope struct {
+	Format     string `json:"format"`
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// IsSealed reports whether data is an envelope of the format
+func IsSealed(data []byte, format string) bool {
+	_, ok := parseSealed(data, format)
+	return ok
+}
+
+// SealWithPassphrase encrypts plain with a key derived from the passphrase
+// and a fresh salt
+func SealWithPassphrase(format string, version int, passphrase string, plain []byte) ([]byte, error) {
+	salt, err := newSealSalt()
+	if err != nil {
+		return nil, err
+	}
+	key, err := sealKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	return seal(format, version, salt, key, plain)
+}
+
+// OpenWithPassphrase decrypts what SealWithPassphrase sealed, failing with
+// ErrWrongPassphrase when the passphrase does not fit
+func OpenWithPassphrase(data []byte, format string, version int, passphrase string) ([]byte, error) {
+	env, ok := parseSealed(data, format)
+	if !ok {
+		return nil, fmt.Errorf("not a %s file", format)
+	}
+	if err := env.check(version); err != nil {
+		return nil, err
+	}
+	key, err := sealKey(passphrase, env.Salt)
+	if err != nil {
+		return nil, err
+	}
+	return env.open(key)
+}
+
+// parseSealed returns the envelope when data is one of the format
+func parseSealed(data []byte, format string) (sealedEnvelope, bool) {
+	var env sealedEnvelope
+	if json.Unmarshal(data, &env) != nil || env.Format != format {
+		return env, false
+	}
+	return env, true
+}
+
+// seal encrypts plain with key, recording the salt it was derived from
+func seal(format string, version int, salt, key, plain []byte) ([]byte, error) {
+	gcm, err := sealCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(sealedEnvelope{
+		Format:     format,
+		Version:    version,
+		KDF:        sealKDF,
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plain, []byte(format)),
+	}, "", "  ")
+}
+
+// check fails for envelopes of another version or key derivation
+func (env sealedEnvelope) check(version int) error {
+	if env.Version != version || env.KDF != sealKDF {
+		return fmt.Errorf("unsupported %s version %d (%s)", env.Format, env.Version, env.KDF)
+	}
+	return nil
+}
+
+// open decrypts the envelope with the key derived from its salt
+func (env sealedEnvelope) open(key []byte) ([]byte, error) {
+	gcm, err := sealCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(env.Nonce) != gcm.NonceSize() {
+		return nil, ErrWrongPassphrase
+	}
+	plain, err := gcm.Open(nil, env.Nonce, env.Ciphertext, []byte(env.Format))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plain, nil
+}
+
+func newSealSalt() ([]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+func sealKey(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, sealScryptN, sealScryptR, sealScryptP, 32)
+}
+
+func sealCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
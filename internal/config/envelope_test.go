@@ -0,0 +1,27 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSealWithPassphrase(t *testing.T) {
+	sealed, err := SealWithPassphrase("roster-test", 1, "balcony", []byte("wherefore"))
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if !IsSealed(sealed, "roster-test") || IsSealed(sealed, encryptedAccountsFormat) {
+		t.Fatal("expected the envelope to carry its own format only")
+	}
+
+	plain, err := OpenWithPassphrase(sealed, "roster-test", 1, "balcony")
+	if err != nil || string(plain) != "wherefore" {
+		t.Fatalf("open: %q, %v", plain, err)
+	}
+	if _, err := OpenWithPassphrase(sealed, "roster-test", 1, "orchard"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("expected a wrong passphrase, got %v", err)
+	}
+	if _, err := OpenWithPassphrase(sealed, "roster-test", 2, "balcony"); err == nil {
+		t.Fatal("expected another version to be refused")
+	}
+}
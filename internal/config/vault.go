@@ -0,0 +1,227 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// accounts.toml can be kept encrypted with a master passphrase for systems
+// without a keyring. The file then holds a sealed envelope (envelope.go)
+// instead of TOML;
+// LoadAccounts and SaveAccounts tell the two apart, so callers do not need
+// to know which mode is on.
+
+const (
+	encryptedAccountsFormat  = "roster-encrypted-accounts"
+	encryptedAccountsVersion = 1
+
+	// unlockAttempts is how often the passphrase is asked for at startup
+	unlockAttempts = 3
+)
+
+// ErrAccountsLocked is returned when the accounts file is encrypted and the
+// passphrase is needed but not at hand, for instance after the session
+// cache expired. UnlockAccounts makes it available again.
+var ErrAccountsLocked = errors.New("accounts file is encrypted and locked")
+
+// accountsVault holds the key of the encrypted accounts file while it is
+// unlocked
+type accountsVault struct {
+	mu         sync.Mutex
+	enabled    bool // the file is, or is to be, written encrypted
+	salt       []byte
+	key        []byte
+	unlockedAt time.Time
+	timeout    time.Duration // 0 keeps the key for the whole session
+	prompt     func() (string, error)
+}
+
+var vault accountsVault
+
+// SetPassphrasePrompt sets how the master passphrase is asked for when the
+// accounts file must be opened and the key is not cached. With no prompt,
+// such reads and writes fail with ErrAccountsLocked.
+func SetPassphrasePrompt(prompt func() (string, error)) {
+	vault.mu.Lock()
+	defer vault.mu.Unlock()
+	vault.prompt = prompt
+}
+
+// SetPassphraseCacheTimeout sets how long the key is kept after the
+// passphrase was entered; 0 keeps it until roster exits
+func SetPassphraseCacheTimeout(d time.Duration) {
+	vault.mu.Lock()
+	defer vault.mu.Unlock()
+	vault.timeout = d
+}
+
+// AccountsEncrypted reports whether accounts.toml is kept encrypted
+func AccountsEncrypted() bool {
+	vault.mu.Lock()
+	defer vault.mu.Unlock()
+	return vault.enabled
+}
+
+// AccountsLocked reports whether the accounts file is encrypted and its key
+// is not cached, so saving needs the passphrase again
+func AccountsLocked() bool {
+	vault.mu.Lock()
+	defer vault.mu.Unlock()
+	return vault.enabled && vault.cachedKey() == nil
+}
+
+// UnlockAccounts checks the passphrase against the accounts file and caches
+// its key for the next writes
+func UnlockAccounts(passphrase string) error {
+	data, err := os.ReadFile(accountsPath())
+	if err != nil {
+		return err
+	}
+	env, ok := parseEncryptedAccounts(data)
+	if !ok {
+		return fmt.Errorf("accounts file is not encrypted")
+	}
+	vault.mu.Lock()
+	defer vault.mu.Unlock()
+	_, err = vault.open(env, passphrase)
+	return err
+}
+
+// EncryptAccounts switches to the encrypted mode and rewrites the accounts
+// file encrypted with the passphrase
+func EncryptAccounts(accounts *AccountsConfig, passphrase string) error {
+	if passphrase == "" {
+		return fmt.Errorf("a passphrase is required")
+	}
+	salt, err := newSealSalt()
+	if err != nil {
+		return err
+	}
+	key, err := sealKey(passphrase, salt)
+	if err != nil {
+		return err
+	}
+
+	vault.mu.Lock()
+	vault.enabled = true
+	vault.remember(salt, key)
+	vault.mu.Unlock()
+	return SaveAccounts(accounts)
+}
+
+// DecryptAccounts switches back to a plain accounts file. The file must be
+// unlocked.
+func DecryptAccounts(accounts *AccountsConfig) error {
+	vault.mu.Lock()
+	if vault.enabled && vault.cachedKey() == nil {
+		vault.mu.Unlock()
+		return ErrAccountsLocked
+	}
+	vault.enabled = false
+	vault.forget()
+	vault.mu.Unlock()
+	return SaveAccounts(accounts)
+}
+
+// accountsPath returns where accounts.toml is
+func accountsPath() string {
+	paths, err := GetPaths()
+	if err != nil {
+		return "accounts.toml"
+	}
+	return filepath.Join(paths.ConfigDir, "accounts.toml")
+}
+
+// parseEncryptedAccounts returns the envelope when data is an encrypted
+// accounts file
+func parseEncryptedAccounts(data []byte) (sealedEnvelope, bool) {
+	return parseSealed(data, encryptedAccountsFormat)
+}
+
+// decryptAccountsFile returns the TOML inside an encrypted accounts file,
+// asking for the passphrase when its key is not cached
+func decryptAccountsFile(env sealedEnvelope) ([]byte, error) {
+	if err := env.check(encryptedAccountsVersion); err != nil {
+		return nil, err
+	}
+
+	vault.mu.Lock()
+	defer vault.mu.Unlock()
+	vault.enabled = true
+	if key := vault.cachedKey(); key != nil && string(vault.salt) == string(env.Salt) {
+		return env.open(key)
+	}
+	if vault.prompt == nil {
+		return nil, ErrAccountsLocked
+	}
+	var err error
+	for i := 0; i < unlockAttempts; i++ {
+		var passphrase string
+		passphrase, err = vault.prompt()
+		if err != nil {
+			return nil, err
+		}
+		var plain []byte
+		if plain, err = vault.open(env, passphrase); err == nil {
+			return plain, nil
+		}
+	}
+	return nil, err
+}
+
+// encryptAccountsFile seals the TOML of the accounts file with the cached
+// key
+func encryptAccountsFile(plain []byte) ([]byte, error) {
+	vault.mu.Lock()
+	// A copy, since an expiring cache wipes the original
+	key := append([]byte(nil), vault.cachedKey()...)
+	salt := vault.salt
+	vault.mu.Unlock()
+	if len(key) == 0 {
+		return nil, ErrAccountsLocked
+	}
+	return seal(encryptedAccountsFormat, encryptedAccountsVersion, salt, key, plain)
+}
+
+// open derives the key from the passphrase, decrypts the envelope and
+// caches the key when it fits. The caller holds v.mu.
+func (v *accountsVault) open(env sealedEnvelope, passphrase string) ([]byte, error) {
+	key, err := sealKey(passphrase, env.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := env.open(key)
+	if err != nil {
+		return nil, err
+	}
+	v.remember(env.Salt, key)
+	return plain, nil
+}
+
+// cachedKey returns the key unless it expired, forgetting it when it did.
+// The caller holds v.mu.
+func (v *accountsVault) cachedKey() []byte {
+	if v.key != nil && v.timeout > 0 && time.Since(v.unlockedAt) > v.timeout {
+		v.forget()
+	}
+	return v.key
+}
+
+// remember caches a key. The caller holds v.mu.
+func (v *accountsVault) remember(salt, key []byte) {
+	v.salt = salt
+	v.key = key
+	v.unlockedAt = time.Now()
+}
+
+// forget wipes the cached key. The caller holds v.mu.
+func (v *accountsVault) forget() {
+	for i := range v.key {
+		v.key[i] = 0
+	}
+	v.key = nil
+}
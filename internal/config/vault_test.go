@@ -0,0 +1,128 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newVaultTest points the config directory at a temporary one and starts
+// from a plain accounts file with no cached key
+func newVaultTest(t *testing.T) *AccountsConfig {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	if err := os.MkdirAll(filepath.Join(dir, "roster"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	vault = accountsVault{}
+	t.Cleanup(func() { vault = accountsVault{} })
+	return &AccountsConfig{Accounts: []Account{{JID: "romeo@example.com", Password: "wherefore"}}}
+}
+
+// lockVault drops the cached key as if roster had been restarted
+func lockVault() {
+	vault.mu.Lock()
+	vault.forget()
+	vault.mu.Unlock()
+}
+
+func TestAccountsVaultRoundTrip(t *testing.T) {
+	accounts := newVaultTest(t)
+	if err := EncryptAccounts(accounts, "balcony"); err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+
+	data, err := os.ReadFile(accountsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := parseEncryptedAccounts(data); !ok || strings.Contains(string(data), "wherefore") {
+		t.Fatalf("expected an encrypted accounts file, got %s", data)
+	}
+
+	lockVault()
+	prompts := 0
+	SetPassphrasePrompt(func() (string, error) {
+		prompts++
+		return "balcony", nil
+	})
+	loaded, err := LoadAccounts()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(loaded.Accounts) != 1 || loaded.Accounts[0].Password != "wherefore" || prompts != 1 {
+		t.Fatalf("unexpected accounts %+v after %d prompts", loaded.Accounts, prompts)
+	}
+
+	// The key is cached now, so the next load does not ask again
+	if _, err := LoadAccounts(); err != nil || prompts != 1 {
+		t.Fatalf("expected the cached key to be used, got %v after %d prompts", err, prompts)
+	}
+
+	if err := DecryptAccounts(loaded); err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	if data, _ := os.ReadFile(accountsPath()); !strings.Contains(string(data), "wherefore") {
+		t.Fatalf("expected a plain accounts file, got %s", data)
+	}
+}
+
+func TestAccountsVaultWrongPassphrase(t *testing.T) {
+	accounts := newVaultTest(t)
+	if err := EncryptAccounts(accounts, "balcony"); err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	lockVault()
+
+	if err := UnlockAccounts("orchard"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("expected a wrong passphrase, got %v", err)
+	}
+	if !AccountsLocked() {
+		t.Fatal("a wrong passphrase unlocked the accounts file")
+	}
+
+	prompts := 0
+	SetPassphrasePrompt(func() (string, error) {
+		prompts++
+		return "orchard", nil
+	})
+	if _, err := LoadAccounts(); !errors.Is(err, ErrWrongPassphrase) || prompts != unlockAttempts {
+		t.Fatalf("expected %d failed prompts, got %d and %v", unlockAttempts, prompts, err)
+	}
+}
+
+func TestAccountsVaultCacheExpires(t *testing.T) {
+	accounts := newVaultTest(t)
+	SetPassphraseCacheTimeout(time.Minute)
+	if err := EncryptAccounts(accounts, "balcony"); err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	if AccountsLocked() {
+		t.Fatal("locked right after encrypting")
+	}
+
+	vault.mu.Lock()
+	vault.unlockedAt = time.Now().Add(-time.Hour)
+	vault.mu.Unlock()
+
+	if !AccountsLocked() {
+		t.Fatal("expected the cached key to expire")
+	}
+	if err := SaveAccounts(accounts); !errors.Is(err, ErrAccountsLocked) {
+		t.Fatalf("expected saving to need the passphrase, got %v", err)
+	}
+	if err := DecryptAccounts(accounts); !errors.Is(err, ErrAccountsLocked) {
+		t.Fatalf("expected decrypting to need the passphrase, got %v", err)
+	}
+
+	if err := UnlockAccounts("balcony"); err != nil {
+		t.Fatalf("unlock: %v", err)
+	}
+	if err := SaveAccounts(accounts); err != nil {
+		t.Fatalf("save after unlock: %v", err)
+	}
+}
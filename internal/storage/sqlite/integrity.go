@@ -0,0 +1,308 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// Recovery describes a database that failed its integrity check at startup
+// and was rebuilt
+type Recovery struct {
+	Problem    string    // what the integrity check reported first
+	BackupPath string    // where the damaged file was moved
+	Salvaged   []string  // tables copied back whole
+	Partial    []string  // tables of which only some rows could be read
+	Lost       []string  // tables that could not be read at all
+	At         time.Time // when it was rebuilt
+}
+
+// Recovered returns what happened when the database was rebuilt at startup,
+// or nil when it was healthy
+func (d *DB) Recovered() *Recovery {
+	return d.recovery
+}
+
+// IntegrityCheck runs the full PRAGMA integrity_check, which reads the
+// whole database, and returns the first problem it reports or "". Startup
+// only runs the quicker quick_check; this is for :doctor.
+func (d *DB) IntegrityCheck() (string, error) {
+	return integrityProblem(d.db, "integrity_check")
+}
+
+// integrityProblem runs PRAGMA integrity_check or quick_check and returns
+// the first problem it reports, or "" when the database is sound. A file
+// that is not a database at all counts as a problem; errors such as a
+// missing permission are returned as errors since rebuilding would not help.
+func integrityProblem(db *sql.DB, pragma string) (string, error) {
+	rows, err := db.Query(`PRAGMA ` + pragma)
+	if err != nil {
+		if isCorruption(err) {
+			return err.Error(), nil
+		}
+		return "", err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return "", err
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		if isCorruption(err) {
+			return err.Error(), nil
+		}
+		return "", err
+	}
+	if len(problems) == 0 {
+		return "", nil
+	}
+	if len(problems) > 1 {
+		return fmt.Sprintf("%s (and %d more problems)", problems[0], len(problems)-1), nil
+	}
+	return problems[0], nil
+}
+
+// isCorruption reports whether err says the file is damaged
+func isCorruption(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "malformed") ||
+		strings.Contains(msg, "not a database") ||
+		strings.Contains(msg, "corrupt")
+}
+
+// rebuild moves a damaged database aside, creates a fresh one in its place
+// and copies back what can still be read
+func rebuild(dbPath, problem string) (*DB, error) {
+	rec := &Recovery{
+		Problem:    problem,
+		BackupPath: backupPath(dbPath),
+		At:         time.Now(),
+	}
+	// The WAL and shared memory files belong to the damaged file and must
+	// not be replayed into the new one
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		err := os.Rename(dbPath+suffix, rec.BackupPath+suffix)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to back up damaged database: %w", err)
+		}
+	}
+
+	db, err := open(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	store := &DB{db: db, path: dbPath, recovery: rec}
+	if err := store.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate rebuilt database: %w", err)
+	}
+	store.salvage(rec)
+	return store, nil
+}
+
+// backupPath picks a name next to the database for moving it aside, one
+// that no earlier backup has
+func backupPath(dbPath string) string {
+	base := dbPath + ".corrupt-" + time.Now().Format("20060102-150405")
+	path := base
+	for i := 2; ; i++ {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return path
+		}
+		path = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// salvage copies the rows of every table that can still be read from the
+// backup into the fresh database. Columns are matched by name, so a backup
+// from an older schema works too.
+func (d *DB) salvage(rec *Recovery) {
+	ctx := context.Background()
+	// ATTACH holds for one connection only
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `ATTACH DATABASE ? AS damaged`, rec.BackupPath); err != nil {
+		return
+	}
+	defer conn.ExecContext(ctx, `DETACH DATABASE damaged`)
+
+	tables, err := tableNames(ctx, conn, "main")
+	if err != nil {
+		return
+	}
+	for _, table := range tables {
+		copied, err := copyTable(ctx, conn, table)
+		switch {
+		case errors.Is(err, errNotInBackup):
+		case err == nil:
+			rec.Salvaged = append(rec.Salvaged, table)
+		case copied > 0:
+			rec.Partial = append(rec.Partial, table)
+		default:
+			rec.Lost = append(rec.Lost, table)
+		}
+	}
+}
+
+// tableNames lists the tables of an attached schema
+func tableNames(ctx context.Context, conn *sql.Conn, schema string) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT name FROM `+schema+`.sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// tableColumns lists the columns of a table in an attached schema
+func tableColumns(ctx context.Context, conn *sql.Conn, schema, table string) (map[string]bool, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT name FROM pragma_table_info(?, ?)`, table, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		cols[name] = true
+	}
+	return cols, rows.Err()
+}
+
+// errNotInBackup is returned for tables newer than the damaged database
+var errNotInBackup = errors.New("table not in backup")
+
+// salvageChunk is how many rows are copied per statement once a table
+// turned out to be damaged
+const salvageChunk = 500
+
+// copyTable copies the rows of one table from the backup. When the table
+// cannot be read in one go it is copied in chunks of the rowids it lists,
+// and row by row within a chunk that fails, to get back everything outside
+// the damaged pages. It returns how many rows were copied and the first read error.
+func copyTable(ctx context.Context, conn *sql.Conn, table string) (int64, error) {
+	fresh, err := tableColumns(ctx, conn, "main", table)
+	if err != nil {
+		return 0, err
+	}
+	old, err := tableColumns(ctx, conn, "damaged", table)
+	if err != nil {
+		return 0, err
+	}
+	if len(old) == 0 {
+		return 0, errNotInBackup
+	}
+	var cols []string
+	for col := range fresh {
+		if old[col] {
+			cols = append(cols, `"`+col+`"`)
+		}
+	}
+	list := strings.Join(cols, ", ")
+	insert := fmt.Sprintf(`INSERT OR IGNORE INTO main."%s" (%s) SELECT %s FROM damaged."%s"`, table, list, list, table)
+
+	res, err := conn.ExecContext(ctx, insert)
+	if err == nil {
+		n, _ := res.RowsAffected()
+		return n, nil
+	}
+	readErr := err
+
+	// Walk the rowids the damaged table still lists, a chunk at a time, so a
+	// damaged page claiming a huge rowid costs nothing. A walk stops at the
+	// first page it cannot read, so the rows after it are walked from the
+	// end down.
+	var copied int64
+	exec := func(where string, args ...interface{}) bool {
+		res, err := conn.ExecContext(ctx, insert+" WHERE "+where, args...)
+		if err != nil {
+			return false
+		}
+		n, _ := res.RowsAffected()
+		copied += n
+		return true
+	}
+	copyChunk := func(ids []int64) {
+		lo, hi := min(ids[0], ids[len(ids)-1]), max(ids[0], ids[len(ids)-1])
+		if !exec(`rowid BETWEEN ? AND ?`, lo, hi) {
+			for _, id := range ids {
+				exec(`rowid = ?`, id)
+			}
+		}
+	}
+
+	up := fmt.Sprintf(`SELECT rowid FROM damaged."%s" WHERE rowid > ? ORDER BY rowid LIMIT %d`, table, salvageChunk)
+	down := fmt.Sprintf(`SELECT rowid FROM damaged."%s" WHERE rowid < ? ORDER BY rowid DESC LIMIT %d`, table, salvageChunk)
+	last := int64(math.MinInt64)
+	for {
+		ids, err := chunkRowIDs(ctx, conn, up, last)
+		if len(ids) > 0 {
+			copyChunk(ids)
+			last = ids[len(ids)-1]
+		}
+		if err != nil {
+			break
+		}
+		if len(ids) < salvageChunk {
+			return copied, readErr
+		}
+	}
+	for first := int64(math.MaxInt64); ; {
+		ids, err := chunkRowIDs(ctx, conn, down, first)
+		for len(ids) > 0 && ids[len(ids)-1] <= last {
+			ids = ids[:len(ids)-1]
+		}
+		if len(ids) > 0 {
+			copyChunk(ids)
+			first = ids[len(ids)-1]
+		}
+		if err != nil || len(ids) < salvageChunk {
+			return copied, readErr
+		}
+	}
+}
+
+// chunkRowIDs returns the rowids listed by query after last, as far as they
+// can be read
+func chunkRowIDs(ctx context.Context, conn *sql.Conn, query string, last int64) ([]int64, error) {
+	rows, err := conn.QueryContext(ctx, query, last)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
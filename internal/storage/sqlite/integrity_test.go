@@ -0,0 +1,164 @@
+package sqlite
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// salvageRows is how many messages the damaged database holds, enough to
+// spread the table over many pages
+const salvageRows = 2000
+
+func newTestDB(t *testing.T, dir string) *DB {
+	t.Helper()
+	db, err := New(dir)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	return db
+}
+
+// corruptPage overwrites one page of the database file
+func corruptPage(t *testing.T, dbPath string, page int64, pageSize int64) {
+	t.Helper()
+	f, err := os.OpenFile(dbPath, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteAt(bytes.Repeat([]byte{0xff}, int(pageSize)), (page-1)*pageSize); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewRebuildsNonDatabaseFile(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "roster.db")
+	garbage := bytes.Repeat([]byte("not a database "), 512)
+	if err := os.WriteFile(dbPath, garbage, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	db := newTestDB(t, dir)
+	defer db.Close()
+	rec := db.Recovered()
+	if rec == nil || rec.Problem == "" {
+		t.Fatalf("expected the file to be rebuilt, got %+v", rec)
+	}
+	if !strings.HasPrefix(rec.BackupPath, dbPath+".corrupt-") {
+		t.Fatalf("unexpected backup name %s", rec.BackupPath)
+	}
+	if moved, err := os.ReadFile(rec.BackupPath); err != nil || !bytes.Equal(moved, garbage) {
+		t.Fatalf("expected the damaged file at %s: %v", rec.BackupPath, err)
+	}
+	if len(rec.Salvaged)+len(rec.Partial) > 0 {
+		t.Fatalf("nothing should be salvaged from garbage, got %+v", rec)
+	}
+	if version, err := db.Version(); err != nil || version != SchemaVersion() {
+		t.Fatalf("expected a fresh schema, got version %d (%v)", version, err)
+	}
+}
+
+func TestNewSalvagesDamagedDatabase(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "roster.db")
+
+	db := newTestDB(t, dir)
+	now := time.Now()
+	for i := 0; i < salvageRows; i++ {
+		body := fmt.Sprintf("message-%04d %s", i, strings.Repeat("x", 80))
+		if err := db.SaveMessage("romeo@example.com", "juliet@example.com", "", fmt.Sprintf("m%d", i), body, "chat", now, false, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.SetAppState("theme", "dark"); err != nil {
+		t.Fatal(err)
+	}
+	var pageSize, appStateRoot int64
+	if err := db.SQL().QueryRow(`PRAGMA page_size`).Scan(&pageSize); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SQL().QueryRow(`SELECT rootpage FROM sqlite_master WHERE name = 'app_state'`).Scan(&appStateRoot); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// One leaf page in the middle of the messages, and the only page of
+	// app_state
+	data, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := bytes.Index(data, []byte(fmt.Sprintf("message-%04d", salvageRows/2)))
+	if at < 0 {
+		t.Fatal("message not found in the database file")
+	}
+	corruptPage(t, dbPath, int64(at)/pageSize+1, pageSize)
+	corruptPage(t, dbPath, appStateRoot, pageSize)
+
+	db = newTestDB(t, dir)
+	defer db.Close()
+	rec := db.Recovered()
+	if rec == nil {
+		t.Fatal("expected the damaged database to be rebuilt")
+	}
+	if !slices.Contains(rec.Partial, "messages") || !slices.Contains(rec.Lost, "app_state") || !slices.Contains(rec.Salvaged, "roster_cache") {
+		t.Fatalf("unexpected recovery %+v", rec)
+	}
+	if _, err := os.Stat(rec.BackupPath); err != nil {
+		t.Fatalf("expected the damaged file at %s: %v", rec.BackupPath, err)
+	}
+
+	if problem, err := db.IntegrityCheck(); problem != "" || err != nil {
+		t.Fatalf("expected the rebuilt database to be sound, got %q (%v)", problem, err)
+	}
+
+	var copied int
+	if err := db.SQL().QueryRow(`SELECT COUNT(*) FROM messages`).Scan(&copied); err != nil {
+		t.Fatal(err)
+	}
+	if copied == 0 || copied >= salvageRows {
+		t.Fatalf("expected some but not all of %d messages back, got %d", salvageRows, copied)
+	}
+	for _, id := range []string{"m0", fmt.Sprintf("m%d", salvageRows-1)} {
+		var n int
+		if err := db.SQL().QueryRow(`SELECT COUNT(*) FROM messages WHERE id = ?`, id).Scan(&n); err != nil || n != 1 {
+			t.Fatalf("expected message %s outside the damaged page to be salvaged", id)
+		}
+	}
+}
+
+func TestBackupPathAvoidsEarlierBackups(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "roster.db")
+	first := backupPath(dbPath)
+	if err := os.WriteFile(first, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if second := backupPath(dbPath); second == first || !strings.HasPrefix(second, dbPath+".corrupt-") {
+		t.Fatalf("expected a new backup name after %s, got %s", first, second)
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	dir := t.TempDir()
+	db := newTestDB(t, dir)
+	if _, err := db.SQL().Exec(fmt.Sprintf(`PRAGMA user_version = %d`, SchemaVersion()+1)); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err := New(dir)
+	if err == nil {
+		db.Close()
+		t.Fatal("expected a database from a newer build to be refused")
+	}
+	if !strings.Contains(err.Error(), "newer") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package sqlite
+
+import (
+	"fmt"
+)
+
+// The schema version is kept in PRAGMA user_version. Databases from before
+// versioning report 0 and get the baseline, which copes with any of them.
+
+// schemaMigration upgrades the schema by one version. Steps after the
+// baseline run once, in order, and should do their work in a transaction so
+// that a failure leaves the previous version intact.
+type schemaMigration struct {
+	version int
+	name    string
+	up      func(d *DB) error
+}
+
+// schemaMigrations lists every schema version, oldest first. New versions
+// are appended; released ones are never changed.
+var schemaMigrations = []schemaMigration{
+	{version: 1, name: "baseline", up: (*DB).migrateBaseline},
+	{version: 2, name: "message sender", up: (*DB).migrateMessageSender},
+	{version: 3, name: "starred messages", up: (*DB).migrateStarredMessages},
+}
+
+// SchemaVersion is the schema version this build writes
+func SchemaVersion() int {
+	return schemaMigrations[len(schemaMigrations)-1].version
+}
+
+// Version returns the schema version the database is at
+func (d *DB) Version() (int, error) {
+	var version int
+	err := d.db.QueryRow(`PRAGMA user_version`).Scan(&version)
+	return version, err
+}
+
+// migrate brings the schema up to SchemaVersion. A database written by a
+// newer build is left alone, since its data may not survive the older
+// schema.
+func (d *DB) migrate() error {
+	current, err := d.Version()
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if current > SchemaVersion() {
+		return fmt.Errorf("schema version %d is newer than this build supports (%d), update roster", current, SchemaVersion())
+	}
+
+	for _, m := range schemaMigrations {
+		if m.version <= current {
+			continue
+		}
+		if err := m.up(d); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+		}
+		// PRAGMA takes no placeholders; the version is one of ours
+		if _, err := d.db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, m.version)); err != nil {
+			return fmt.Errorf("failed to record schema version %d: %w", m.version, err)
+		}
+	}
+	return nil
+}
+
+// migrateMessageSender records who wrote each message, the full JID it came
+// from, so that room history (where that is room@service/nick) can be
+// searched by sender. Messages stored before have no sender.
+func (d *DB) migrateMessageSender() error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`ALTER TABLE messages ADD COLUMN sender TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrateStarredMessages adds the messages starred by the user. They keep a
+// copy of the message, so starring works in conversations whose history is
+// not saved and survives the history being cleared.
+func (d *DB) migrateStarredMessages() error {
+	_, err := d.db.Exec(`
+		CREATE TABLE IF NOT EXISTS starred_messages (
+			account TEXT NOT NULL,
+			jid TEXT NOT NULL,
+			id TEXT NOT NULL,
+			sender TEXT NOT NULL DEFAULT '',
+			body TEXT NOT NULL,
+			timestamp INTEGER NOT NULL,
+			outgoing INTEGER NOT NULL DEFAULT 0,
+			starred_at INTEGER NOT NULL,
+			PRIMARY KEY (account, jid, id)
+		)
+	`)
+	return err
+}
@@ -0,0 +1,94 @@
+package sqlite
+
+import (
+	"strings"
+	"time"
+)
+
+// MessageSearch selects stored messages of an account. Empty fields do not
+// filter.
+type MessageSearch struct {
+	Text   string    // words that must all appear in the body
+	From   string    // a nick, a JID or its local part; see SearchMessages
+	FromMe bool      // only our own messages
+	In     string    // a conversation JID or its local part
+	After  time.Time // sent at or after
+	Before time.Time // sent before
+	Limit  int
+}
+
+// SearchResult is a stored message found by SearchMessages
+type SearchResult struct {
+	Message
+	JID    string // the conversation
+	Sender string // the full JID it came from, empty for older messages
+}
+
+// likeEscape escapes the wildcards of a LIKE pattern, for use with
+// ESCAPE '\'
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// SearchMessages returns the newest messages matching the search. Text is
+// matched case-insensitively as separate words. From matches a sender's
+// full JID, its nick in a room (the resource), its bare JID or its local
+// part, so "bob" finds both room@service/bob and bob@example.com.
+func (d *DB) SearchMessages(account string, search MessageSearch) ([]SearchResult, error) {
+	where := []string{`account = ?`}
+	args := []interface{}{account}
+
+	for _, word := range strings.Fields(search.Text) {
+		where = append(where, `body LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+likeEscape(word)+"%")
+	}
+	if search.From != "" {
+		from := likeEscape(search.From)
+		where = append(where, `(sender = ? OR sender LIKE ? ESCAPE '\' OR sender LIKE ? ESCAPE '\' OR sender LIKE ? ESCAPE '\')`)
+		args = append(args, search.From, "%/"+from, from+"/%", from+"@%")
+	}
+	if search.FromMe {
+		where = append(where, `outgoing = 1`)
+	}
+	if search.In != "" {
+		where = append(where, `(jid = ? OR jid LIKE ? ESCAPE '\')`)
+		args = append(args, search.In, likeEscape(search.In)+"@%")
+	}
+	if !search.After.IsZero() {
+		where = append(where, `timestamp >= ?`)
+		args = append(args, search.After.Unix())
+	}
+	if !search.Before.IsZero() {
+		where = append(where, `timestamp < ?`)
+		args = append(args, search.Before.Unix())
+	}
+	limit := search.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	args = append(args, limit)
+
+	rows, err := d.db.Query(`
+		SELECT jid, sender, id, body, timestamp, outgoing, encrypted, type
+		FROM messages
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY timestamp DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var r SearchResult
+		var ts int64
+		if err := rows.Scan(&r.JID, &r.Sender, &r.ID, &r.Body, &ts, &r.Outgoing, &r.Encrypted, &r.Type); err != nil {
+			return nil, err
+		}
+		r.Timestamp = time.Unix(ts, 0)
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
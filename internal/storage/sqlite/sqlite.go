@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,18 +13,35 @@ import (
 )
 
 type DB struct {
-	db *sql.DB
+	db       *sql.DB
+	path     string
+	recovery *Recovery // set when the database was rebuilt at startup
 }
 
+// New opens the database in dataDir, checks its integrity and brings its
+// schema up to date. A damaged database is moved aside and rebuilt from
+// what can still be read, see integrity.go.
 func New(dataDir string) (*DB, error) {
 	dbPath := filepath.Join(dataDir, "roster.db")
 
-	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_foreign_keys=on")
+	db, err := open(dbPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+		return nil, err
+	}
+
+	// quick_check skips the index cross-checks that make integrity_check
+	// read the whole history; :doctor runs the full one
+	problem, err := integrityProblem(db, "quick_check")
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to check database integrity: %w", err)
+	}
+	if problem != "" {
+		db.Close()
+		return rebuild(dbPath, problem)
 	}
 
-	store := &DB{db: db}
+	store := &DB{db: db, path: dbPath}
 	if err := store.migrate(); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
@@ -32,14 +50,36 @@ func New(dataDir string) (*DB, error) {
 	return store, nil
 }
 
+// SQL returns the database itself, for the OMEMO key store of the clients
+// whose omemo_ tables the schema creates
+func (d *DB) SQL() *sql.DB {
+	return d.db
+}
+
+// Path returns the database file
+func (d *DB) Path() string {
+	return d.path
+}
+
+// open opens the database file without touching its contents
+func open(dbPath string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return db, nil
+}
+
 func (d *DB) Close() error {
 	return d.db.Close()
 }
 
-func (d *DB) migrate() error {
+// migrateBaseline creates the schema as it was before versioning, and
+// upgrades databases from older releases to it. Every step is idempotent.
+func (d *DB) migrateBaseline() error {
 	migrations := []string{
 		`CREATE TABLE IF NOT EXISTS messages (
-			id TEXT PRIMARY KEY,
+			id TEXT NOT NULL,
 			account TEXT NOT NULL,
 			jid TEXT NOT NULL,
 			body TEXT NOT NULL,
@@ -50,7 +90,8 @@ func (d *DB) migrate() error {
 			received INTEGER DEFAULT 0,
 			displayed INTEGER DEFAULT 0,
 			corrected INTEGER DEFAULT 0,
-			corrected_id TEXT
+			corrected_id TEXT,
+			PRIMARY KEY (account, id)
 		)`,
 		`CREATE INDEX IF NOT EXISTS idx_messages_jid ON messages(account, jid)`,
 		`CREATE INDEX IF NOT EXISTS idx_messages_timestamp ON messages(timestamp)`,
@@ -174,6 +215,49 @@ func (d *DB) migrate() error {
 			session_data BLOB NOT NULL,
 			PRIMARY KEY (account_jid, jid, device_id)
 		)`,
+
+		`CREATE TABLE IF NOT EXISTS omemo_security_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			account TEXT NOT NULL,
+			contact_jid TEXT NOT NULL,
+			device_id INTEGER NOT NULL,
+			event TEXT NOT NULL,
+			old_trust INTEGER NOT NULL DEFAULT 0,
+			new_trust INTEGER NOT NULL DEFAULT 0,
+			fingerprint TEXT,
+			timestamp INTEGER NOT NULL
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_security_events_account ON omemo_security_events(account, timestamp)`,
+
+		`CREATE TABLE IF NOT EXISTS contact_presence_history (
+			account TEXT NOT NULL,
+			contact_jid TEXT NOT NULL,
+			status TEXT NOT NULL,
+			timestamp INTEGER NOT NULL
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_presence_history_contact ON contact_presence_history(account, contact_jid, timestamp)`,
+
+		`CREATE TABLE IF NOT EXISTS contact_metadata (
+			account TEXT NOT NULL,
+			contact_jid TEXT NOT NULL,
+			favorite INTEGER NOT NULL DEFAULT 0,
+			pinned INTEGER NOT NULL DEFAULT 0,
+			muted INTEGER NOT NULL DEFAULT 0,
+			last_interaction INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (account, contact_jid)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS room_settings (
+			account TEXT NOT NULL,
+			room_jid TEXT NOT NULL,
+			nick TEXT NOT NULL DEFAULT '',
+			last_read INTEGER NOT NULL DEFAULT 0,
+			notify TEXT NOT NULL DEFAULT '',
+			history_limit INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (account, room_jid)
+		)`,
 	}
 
 	for _, migration := range migrations {
@@ -188,6 +272,9 @@ func (d *DB) migrate() error {
 			return fmt.Errorf("failed to ensure stanza_id column: %w", err)
 		}
 	}
+	if err := d.migrateMessagesKey(); err != nil {
+		return fmt.Errorf("failed to key messages by account: %w", err)
+	}
 	if _, err := d.db.Exec(`CREATE INDEX IF NOT EXISTS idx_messages_stanza_id ON messages(stanza_id)`); err != nil {
 		return fmt.Errorf("failed to ensure stanza_id index: %w", err)
 	}
@@ -196,21 +283,208 @@ func (d *DB) migrate() error {
 			return fmt.Errorf("failed to ensure added_to_roster column: %w", err)
 		}
 	}
+	if err := d.migrateContactMetadata(); err != nil {
+		return fmt.Errorf("failed to move contact metadata: %w", err)
+	}
+	if err := d.migrateRoomLastRead(); err != nil {
+		return fmt.Errorf("failed to move room read positions: %w", err)
+	}
 
 	return nil
 }
 
-func (d *DB) SaveMessage(account, jid, id, body, msgType string, timestamp time.Time, outgoing, encrypted bool) error {
+// Keys older versions kept contact favorites and last interaction times
+// under, as JSON in app_state
+const (
+	legacyFavoritesPrefix   = "contacts:favorites:"
+	legacyInteractionPrefix = "contacts:last_interaction:"
+)
+
+// migrateContactMetadata moves favorites and last interaction times out of
+// app_state into contact_metadata
+func (d *DB) migrateContactMetadata() error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT key, value FROM app_state WHERE key LIKE ? OR key LIKE ?`,
+		legacyFavoritesPrefix+"%", legacyInteractionPrefix+"%")
+	if err != nil {
+		return err
+	}
+	legacy := make(map[string]string)
+	for rows.Next() {
+		var key string
+		var value sql.NullString
+		if err := rows.Scan(&key, &value); err != nil {
+			rows.Close()
+			return err
+		}
+		legacy[key] = value.String
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(legacy) == 0 {
+		return nil
+	}
+
+	for key, value := range legacy {
+		switch {
+		case strings.HasPrefix(key, legacyFavoritesPrefix):
+			account := strings.TrimPrefix(key, legacyFavoritesPrefix)
+			var favorites []string
+			_ = json.Unmarshal([]byte(value), &favorites)
+			for _, jid := range favorites {
+				if jid == "" {
+					continue
+				}
+				if _, err := tx.Exec(`
+					INSERT INTO contact_metadata (account, contact_jid, favorite) VALUES (?, ?, 1)
+					ON CONFLICT(account, contact_jid) DO UPDATE SET favorite = 1
+				`, account, jid); err != nil {
+					return err
+				}
+			}
+		case strings.HasPrefix(key, legacyInteractionPrefix):
+			account := strings.TrimPrefix(key, legacyInteractionPrefix)
+			var interactions map[string]int64
+			_ = json.Unmarshal([]byte(value), &interactions)
+			for jid, ts := range interactions {
+				if jid == "" {
+					continue
+				}
+				if _, err := tx.Exec(`
+					INSERT INTO contact_metadata (account, contact_jid, last_interaction) VALUES (?, ?, ?)
+					ON CONFLICT(account, contact_jid) DO UPDATE SET last_interaction = MAX(last_interaction, excluded.last_interaction)
+				`, account, jid, ts); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := tx.Exec(`DELETE FROM app_state WHERE key = ?`, key); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// legacyRoomSeenPrefix is the app_state key older versions kept the time a
+// room was last viewed under, followed by "account:room"
+const legacyRoomSeenPrefix = "muc:last_seen:"
+
+// migrateRoomLastRead moves room read positions out of app_state into
+// room_settings
+func (d *DB) migrateRoomLastRead() error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT key, value FROM app_state WHERE key LIKE ?`, legacyRoomSeenPrefix+"%")
+	if err != nil {
+		return err
+	}
+	legacy := make(map[string]string)
+	for rows.Next() {
+		var key string
+		var value sql.NullString
+		if err := rows.Scan(&key, &value); err != nil {
+			rows.Close()
+			return err
+		}
+		legacy[key] = value.String
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(legacy) == 0 {
+		return nil
+	}
+
+	for key, value := range legacy {
+		account, room, ok := strings.Cut(strings.TrimPrefix(key, legacyRoomSeenPrefix), ":")
+		if ts, err := strconv.ParseInt(value, 10, 64); ok && err == nil && room != "" {
+			if _, err := tx.Exec(`
+				INSERT INTO room_settings (account, room_jid, last_read) VALUES (?, ?, ?)
+				ON CONFLICT(account, room_jid) DO UPDATE SET last_read = MAX(last_read, excluded.last_read)
+			`, account, room, ts); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(`DELETE FROM app_state WHERE key = ?`, key); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// migrateMessagesKey rebuilds a messages table from older versions, where
+// the message ID alone was the primary key and a message seen by two
+// accounts (one messaging the other) overwrote the first copy.
+func (d *DB) migrateMessagesKey() error {
+	var accountKeyed int
+	err := d.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('messages') WHERE name = 'account' AND pk > 0`).Scan(&accountKeyed)
+	if err != nil || accountKeyed > 0 {
+		return err
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	steps := []string{
+		`CREATE TABLE messages_keyed (
+			id TEXT NOT NULL,
+			account TEXT NOT NULL,
+			jid TEXT NOT NULL,
+			body TEXT NOT NULL,
+			timestamp INTEGER NOT NULL,
+			outgoing INTEGER NOT NULL,
+			encrypted INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			received INTEGER DEFAULT 0,
+			displayed INTEGER DEFAULT 0,
+			corrected INTEGER DEFAULT 0,
+			corrected_id TEXT,
+			stanza_id TEXT,
+			PRIMARY KEY (account, id)
+		)`,
+		`INSERT INTO messages_keyed (id, account, jid, body, timestamp, outgoing, encrypted, type, received, displayed, corrected, corrected_id, stanza_id)
+			SELECT id, account, jid, body, timestamp, outgoing, encrypted, type, received, displayed, corrected, corrected_id, stanza_id FROM messages`,
+		`DROP TABLE messages`,
+		`ALTER TABLE messages_keyed RENAME TO messages`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_jid ON messages(account, jid)`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_timestamp ON messages(timestamp)`,
+	}
+	for _, step := range steps {
+		if _, err := tx.Exec(step); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SaveMessage stores a message of the conversation with jid. sender is the
+// full JID the message came from, in rooms room@service/nick.
+func (d *DB) SaveMessage(account, jid, sender, id, body, msgType string, timestamp time.Time, outgoing, encrypted bool) error {
 	_, err := d.db.Exec(`
-		INSERT OR REPLACE INTO messages (id, account, jid, body, timestamp, outgoing, encrypted, type)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-	`, id, account, jid, body, timestamp.Unix(), outgoing, encrypted, msgType)
+		INSERT OR REPLACE INTO messages (id, account, jid, sender, body, timestamp, outgoing, encrypted, type)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, id, account, jid, sender, body, timestamp.Unix(), outgoing, encrypted, msgType)
 	return err
 }
 
 func (d *DB) GetMessages(account, jid string, limit, offset int) ([]Message, error) {
 	rows, err := d.db.Query(`
-		SELECT id, body, timestamp, outgoing, encrypted, type, received, displayed, corrected, corrected_id
+		SELECT id, sender, body, timestamp, outgoing, encrypted, type, received, displayed, corrected, corrected_id
 		FROM messages
 		WHERE account = ? AND jid = ?
 		ORDER BY timestamp DESC
@@ -227,7 +501,7 @@ func (d *DB) GetMessages(account, jid string, limit, offset int) ([]Message, err
 		var ts int64
 		var correctedID sql.NullString
 
-		err := rows.Scan(&msg.ID, &msg.Body, &ts, &msg.Outgoing, &msg.Encrypted,
+		err := rows.Scan(&msg.ID, &msg.Sender, &msg.Body, &ts, &msg.Outgoing, &msg.Encrypted,
 			&msg.Type, &msg.Received, &msg.Displayed, &msg.Corrected, &correctedID)
 		if err != nil {
 			return nil, err
@@ -247,13 +521,13 @@ func (d *DB) GetMessages(account, jid string, limit, offset int) ([]Message, err
 	return messages, nil
 }
 
-func (d *DB) MarkMessageReceived(id string) error {
-	_, err := d.db.Exec("UPDATE messages SET received = 1 WHERE id = ?", id)
+func (d *DB) MarkMessageReceived(account, id string) error {
+	_, err := d.db.Exec("UPDATE messages SET received = 1 WHERE account = ? AND id = ?", account, id)
 	return err
 }
 
-func (d *DB) MarkMessageDisplayed(id string) error {
-	_, err := d.db.Exec("UPDATE messages SET displayed = 1 WHERE id = ?", id)
+func (d *DB) MarkMessageDisplayed(account, id string) error {
+	_, err := d.db.Exec("UPDATE messages SET displayed = 1 WHERE account = ? AND id = ?", account, id)
 	return err
 }
 
@@ -264,6 +538,7 @@ func (d *DB) DeleteMessages(account, jid string) error {
 
 type Message struct {
 	ID          string
+	Sender      string // full JID it came from, in rooms room@service/nick
 	Body        string
 	Timestamp   time.Time
 	Outgoing    bool
@@ -512,6 +787,37 @@ func (d *DB) DeleteAppState(key string) error {
 	return err
 }
 
+// GetAppStateWithPrefix returns every app state value whose key starts with
+// prefix, keyed by the rest of the key
+func (d *DB) GetAppStateWithPrefix(prefix string) (map[string]string, error) {
+	rows, err := d.db.Query("SELECT key, value FROM app_state WHERE substr(key, 1, ?) = ?", len(prefix), prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make(map[string]string)
+	for rows.Next() {
+		var key string
+		var value sql.NullString
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		values[strings.TrimPrefix(key, prefix)] = value.String
+	}
+	return values, rows.Err()
+}
+
+// DeleteMessagesBefore removes the messages of one conversation older than
+// the given time
+func (d *DB) DeleteMessagesBefore(account, jid string, before time.Time) (int64, error) {
+	result, err := d.db.Exec("DELETE FROM messages WHERE account = ? AND jid = ? AND timestamp < ?", account, jid, before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 func (d *DB) DeleteOldMessages(days int) (int64, error) {
 	cutoff := time.Now().AddDate(0, 0, -days).Unix()
 	result, err := d.db.Exec("DELETE FROM messages WHERE timestamp < ?", cutoff)
@@ -618,6 +924,144 @@ func (d *DB) GetContactLastPresence(account, contactJID string) (show, statusMsg
 	return show, statusMsg, lastUpdated, nil
 }
 
+// PresenceTransition is a recorded change of a contact's presence
+type PresenceTransition struct {
+	Status    string
+	Timestamp time.Time
+}
+
+func (d *DB) AddPresenceTransition(account, contactJID, status string, at time.Time) error {
+	_, err := d.db.Exec(`
+		INSERT INTO contact_presence_history (account, contact_jid, status, timestamp)
+		VALUES (?, ?, ?, ?)
+	`, account, contactJID, status, at.Unix())
+	return err
+}
+
+// GetPresenceTransitions returns a contact's transitions since the given
+// time, oldest first. The last transition before since is included so the
+// state at the start of the range is known.
+func (d *DB) GetPresenceTransitions(account, contactJID string, since time.Time) ([]PresenceTransition, error) {
+	rows, err := d.db.Query(`
+		SELECT status, timestamp FROM (
+			SELECT status, timestamp FROM contact_presence_history
+			WHERE account = ? AND contact_jid = ? AND timestamp < ?
+			ORDER BY timestamp DESC LIMIT 1
+		)
+		UNION ALL
+		SELECT status, timestamp FROM contact_presence_history
+		WHERE account = ? AND contact_jid = ? AND timestamp >= ?
+		ORDER BY timestamp ASC
+	`, account, contactJID, since.Unix(), account, contactJID, since.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transitions []PresenceTransition
+	for rows.Next() {
+		var t PresenceTransition
+		var ts int64
+		if err := rows.Scan(&t.Status, &ts); err != nil {
+			return nil, err
+		}
+		t.Timestamp = time.Unix(ts, 0)
+		transitions = append(transitions, t)
+	}
+	return transitions, rows.Err()
+}
+
+// DeletePresenceHistory removes recorded transitions older than the given
+// time; a zero time removes everything
+func (d *DB) DeletePresenceHistory(before time.Time) error {
+	if before.IsZero() {
+		_, err := d.db.Exec(`DELETE FROM contact_presence_history`)
+		return err
+	}
+	_, err := d.db.Exec(`DELETE FROM contact_presence_history WHERE timestamp < ?`, before.Unix())
+	return err
+}
+
+// Security event kinds recorded in the OMEMO audit log
+const (
+	SecurityEventDeviceAdded     = "device_added"
+	SecurityEventIdentityChanged = "identity_changed"
+	SecurityEventTrustChanged    = "trust_changed"
+	SecurityEventDeviceRemoved   = "device_removed"
+)
+
+// SecurityEvent is an entry in the OMEMO audit log
+type SecurityEvent struct {
+	ID          int64
+	Account     string
+	ContactJID  string
+	DeviceID    uint32
+	Event       string
+	OldTrust    int
+	NewTrust    int
+	Fingerprint string
+	Timestamp   time.Time
+}
+
+func (d *DB) SaveSecurityEvent(ev SecurityEvent) error {
+	if ev.Timestamp.IsZero() {
+		ev.Timestamp = time.Now()
+	}
+	_, err := d.db.Exec(`
+		INSERT INTO omemo_security_events (account, contact_jid, device_id, event, old_trust, new_trust, fingerprint, timestamp)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, ev.Account, ev.ContactJID, ev.DeviceID, ev.Event, ev.OldTrust, ev.NewTrust, ev.Fingerprint, ev.Timestamp.Unix())
+	return err
+}
+
+// GetSecurityEvents returns the newest security events for an account first
+func (d *DB) GetSecurityEvents(account string, limit int) ([]SecurityEvent, error) {
+	rows, err := d.db.Query(`
+		SELECT id, account, contact_jid, device_id, event, old_trust, new_trust, fingerprint, timestamp
+		FROM omemo_security_events
+		WHERE account = ?
+		ORDER BY timestamp DESC, id DESC
+		LIMIT ?
+	`, account, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []SecurityEvent
+	for rows.Next() {
+		var ev SecurityEvent
+		var fingerprint sql.NullString
+		var ts int64
+		if err := rows.Scan(&ev.ID, &ev.Account, &ev.ContactJID, &ev.DeviceID, &ev.Event, &ev.OldTrust, &ev.NewTrust, &fingerprint, &ts); err != nil {
+			return nil, err
+		}
+		ev.Fingerprint = fingerprint.String
+		ev.Timestamp = time.Unix(ts, 0)
+		events = append(events, ev)
+	}
+	return events, rows.Err()
+}
+
+// LastDeviceFingerprint returns the identity fingerprint most recently logged
+// for a contact device, or an empty string if the device was never seen
+func (d *DB) LastDeviceFingerprint(account, contactJID string, deviceID uint32) (string, error) {
+	var fingerprint sql.NullString
+	err := d.db.QueryRow(`
+		SELECT fingerprint FROM omemo_security_events
+		WHERE account = ? AND contact_jid = ? AND device_id = ? AND event IN (?, ?)
+		ORDER BY timestamp DESC, id DESC
+		LIMIT 1
+	`, account, contactJID, deviceID, SecurityEventDeviceAdded, SecurityEventIdentityChanged).Scan(&fingerprint)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return fingerprint.String, nil
+}
+
 func (d *DB) SetStatusSharing(account, contactJID string, enabled bool) error {
 	val := 0
 	if enabled {
@@ -647,6 +1091,30 @@ func (d *DB) GetStatusSharing(account, contactJID string) (bool, error) {
 	return enabled == 1, nil
 }
 
+// GetStatusSharingSettings returns every stored status sharing choice of an
+// account, keyed by contact JID
+func (d *DB) GetStatusSharingSettings(account string) (map[string]bool, error) {
+	rows, err := d.db.Query(`
+		SELECT contact_jid, share_enabled FROM status_sharing
+		WHERE account = ?
+	`, account)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	settings := make(map[string]bool)
+	for rows.Next() {
+		var jid string
+		var enabled int
+		if err := rows.Scan(&jid, &enabled); err != nil {
+			return nil, err
+		}
+		settings[jid] = enabled == 1
+	}
+	return settings, rows.Err()
+}
+
 func (d *DB) GetContactsWithStatusSharing(account string) ([]string, error) {
 	rows, err := d.db.Query(`
 		SELECT contact_jid FROM status_sharing
@@ -713,9 +1181,9 @@ func (d *DB) DeleteMAMSync(account, jid string) error {
 	return err
 }
 
-func (d *DB) MessageExists(stanzaID string) (bool, error) {
+func (d *DB) MessageExists(account, stanzaID string) (bool, error) {
 	var one int
-	err := d.db.QueryRow("SELECT 1 FROM messages WHERE stanza_id = ?", stanzaID).Scan(&one)
+	err := d.db.QueryRow("SELECT 1 FROM messages WHERE account = ? AND stanza_id = ?", account, stanzaID).Scan(&one)
 	if err == sql.ErrNoRows {
 		return false, nil
 	}
@@ -809,6 +1277,145 @@ func (d *DB) GetRoster(account string) ([]RosterEntry, error) {
 	return entries, nil
 }
 
+// ContactMetadata is the local, per-account state kept for a contact
+type ContactMetadata struct {
+	JID             string
+	Favorite        bool
+	Pinned          bool
+	Muted           bool
+	LastInteraction time.Time
+}
+
+// GetContactMetadata returns the stored metadata of every contact of an account
+func (d *DB) GetContactMetadata(account string) ([]ContactMetadata, error) {
+	rows, err := d.db.Query(`
+		SELECT contact_jid, favorite, pinned, muted, last_interaction
+		FROM contact_metadata
+		WHERE account = ?
+	`, account)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []ContactMetadata
+	for rows.Next() {
+		var entry ContactMetadata
+		var favorite, pinned, muted int
+		var lastInteraction int64
+		if err := rows.Scan(&entry.JID, &favorite, &pinned, &muted, &lastInteraction); err != nil {
+			return nil, err
+		}
+		entry.Favorite = favorite != 0
+		entry.Pinned = pinned != 0
+		entry.Muted = muted != 0
+		if lastInteraction > 0 {
+			entry.LastInteraction = time.Unix(lastInteraction, 0)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// setContactFlag sets one of the boolean contact_metadata columns
+func (d *DB) setContactFlag(column, account, contactJID string, on bool) error {
+	_, err := d.db.Exec(`
+		INSERT INTO contact_metadata (account, contact_jid, `+column+`) VALUES (?, ?, ?)
+		ON CONFLICT(account, contact_jid) DO UPDATE SET `+column+` = excluded.`+column,
+		account, contactJID, boolToInt(on))
+	return err
+}
+
+func (d *DB) SetContactFavorite(account, contactJID string, favorite bool) error {
+	return d.setContactFlag("favorite", account, contactJID, favorite)
+}
+
+func (d *DB) SetContactPinned(account, contactJID string, pinned bool) error {
+	return d.setContactFlag("pinned", account, contactJID, pinned)
+}
+
+func (d *DB) SetContactMuted(account, contactJID string, muted bool) error {
+	return d.setContactFlag("muted", account, contactJID, muted)
+}
+
+// TouchContactInteraction records an interaction with a contact, keeping the
+// stored time if it is later
+func (d *DB) TouchContactInteraction(account, contactJID string, at time.Time) error {
+	_, err := d.db.Exec(`
+		INSERT INTO contact_metadata (account, contact_jid, last_interaction) VALUES (?, ?, ?)
+		ON CONFLICT(account, contact_jid) DO UPDATE SET last_interaction = MAX(last_interaction, excluded.last_interaction)
+	`, account, contactJID, at.Unix())
+	return err
+}
+
+// DeleteContactMetadata removes the contact metadata of an account
+func (d *DB) DeleteContactMetadata(account string) error {
+	_, err := d.db.Exec("DELETE FROM contact_metadata WHERE account = ?", account)
+	return err
+}
+
+// RoomSettings is what is remembered about a room apart from its bookmark
+type RoomSettings struct {
+	JID          string
+	Nick         string
+	LastRead     time.Time
+	Notify       string
+	HistoryLimit int
+}
+
+// GetRoomSettings returns the stored settings of a room. A room without
+// any has zero settings.
+func (d *DB) GetRoomSettings(account, roomJID string) (RoomSettings, error) {
+	settings := RoomSettings{JID: roomJID}
+	var lastRead int64
+	err := d.db.QueryRow(`
+		SELECT nick, last_read, notify, history_limit
+		FROM room_settings
+		WHERE account = ? AND room_jid = ?
+	`, account, roomJID).Scan(&settings.Nick, &lastRead, &settings.Notify, &settings.HistoryLimit)
+	if err == sql.ErrNoRows {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, err
+	}
+	if lastRead > 0 {
+		settings.LastRead = time.Unix(lastRead, 0)
+	}
+	return settings, nil
+}
+
+// setRoomColumn sets one room_settings column
+func (d *DB) setRoomColumn(column, account, roomJID string, value interface{}) error {
+	_, err := d.db.Exec(`
+		INSERT INTO room_settings (account, room_jid, `+column+`) VALUES (?, ?, ?)
+		ON CONFLICT(account, room_jid) DO UPDATE SET `+column+` = excluded.`+column,
+		account, roomJID, value)
+	return err
+}
+
+func (d *DB) SetRoomNick(account, roomJID, nick string) error {
+	return d.setRoomColumn("nick", account, roomJID, nick)
+}
+
+func (d *DB) SetRoomLastRead(account, roomJID string, at time.Time) error {
+	return d.setRoomColumn("last_read", account, roomJID, at.Unix())
+}
+
+func (d *DB) SetRoomNotify(account, roomJID, mode string) error {
+	return d.setRoomColumn("notify", account, roomJID, mode)
+}
+
+func (d *DB) SetRoomHistoryLimit(account, roomJID string, limit int) error {
+	return d.setRoomColumn("history_limit", account, roomJID, limit)
+}
+
+// DeleteRoomSettings removes the room settings of an account
+func (d *DB) DeleteRoomSettings(account string) error {
+	_, err := d.db.Exec("DELETE FROM room_settings WHERE account = ?", account)
+	return err
+}
+
 func boolToInt(v bool) int {
 	if v {
 		return 1
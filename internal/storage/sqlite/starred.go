@@ -0,0 +1,58 @@
+package sqlite
+
+import "time"
+
+// StarredMessage is a message the user starred, with a copy of its content
+type StarredMessage struct {
+	JID       string // the conversation
+	ID        string
+	Sender    string
+	Body      string
+	Timestamp time.Time
+	Outgoing  bool
+	StarredAt time.Time
+}
+
+// StarMessage stars a message, or updates the copy of one already starred
+func (d *DB) StarMessage(account string, msg StarredMessage) error {
+	_, err := d.db.Exec(`
+		INSERT INTO starred_messages (account, jid, id, sender, body, timestamp, outgoing, starred_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(account, jid, id) DO UPDATE SET body = excluded.body
+	`, account, msg.JID, msg.ID, msg.Sender, msg.Body, msg.Timestamp.Unix(), msg.Outgoing, msg.StarredAt.Unix())
+	return err
+}
+
+// UnstarMessage removes the star of a message
+func (d *DB) UnstarMessage(account, jid, id string) error {
+	_, err := d.db.Exec(`DELETE FROM starred_messages WHERE account = ? AND jid = ? AND id = ?`, account, jid, id)
+	return err
+}
+
+// GetStarredMessages returns the starred messages of an account, most
+// recently starred first
+func (d *DB) GetStarredMessages(account string) ([]StarredMessage, error) {
+	rows, err := d.db.Query(`
+		SELECT jid, id, sender, body, timestamp, outgoing, starred_at
+		FROM starred_messages
+		WHERE account = ?
+		ORDER BY starred_at DESC, timestamp DESC
+	`, account)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var starred []StarredMessage
+	for rows.Next() {
+		var msg StarredMessage
+		var ts, starredAt int64
+		if err := rows.Scan(&msg.JID, &msg.ID, &msg.Sender, &msg.Body, &ts, &msg.Outgoing, &starredAt); err != nil {
+			return nil, err
+		}
+		msg.Timestamp = time.Unix(ts, 0)
+		msg.StarredAt = time.Unix(starredAt, 0)
+		starred = append(starred, msg)
+	}
+	return starred, rows.Err()
+}
@@ -0,0 +1,92 @@
+package ui
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/meszmate/roster/internal/app"
+	"github.com/meszmate/roster/internal/ui/components/roster"
+)
+
+// archiveAccount is the account :archive, :privacy and M act on: the one
+// whose details are shown, the one selected in the account list, or the
+// active window's
+func (m *Model) archiveAccount() string {
+	switch {
+	case m.viewMode == ViewModeAccountDetails && m.detailAccountJID != "":
+		return m.detailAccountJID
+	case m.focus == FocusAccounts || (m.focus == FocusRoster && m.roster.FocusSection() == roster.SectionAccounts):
+		return m.roster.SelectedAccountJID()
+	}
+	return m.rosterAccountJID()
+}
+
+// archiveCommand shows the server archiving of an account in its details,
+// or sets it to the mode given
+func (m *Model) archiveCommand(msg app.CommandActionMsg) tea.Cmd {
+	accountJID := m.archiveAccount()
+	if accountJID == "" {
+		m.chat = m.chat.SetStatusMsg("No account selected")
+		return nil
+	}
+	m.viewMode = ViewModeAccountDetails
+	m.detailAccountJID = accountJID
+	if mode, _ := msg.Data["mode"].(string); mode != "" {
+		return m.setArchiveDefault(accountJID, mode)
+	}
+	if m.app.ArchivePreference(accountJID) == "" {
+		return m.app.FetchArchivePrefs(accountJID)
+	}
+	return nil
+}
+
+// cycleArchive moves an account's server archiving to the next default
+func (m *Model) cycleArchive(accountJID string) tea.Cmd {
+	current := m.app.ArchivePreference(accountJID)
+	if current == "asking..." {
+		m.chat = m.chat.SetStatusMsg("Still asking the server of " + accountJID + " how it archives")
+		return nil
+	}
+	return m.setArchiveDefault(accountJID, app.NextArchiveDefault(current))
+}
+
+// setArchiveDefault asks the server of an account to archive by mode
+func (m *Model) setArchiveDefault(accountJID, mode string) tea.Cmd {
+	if !m.app.IsAccountConnected(accountJID) {
+		m.chat = m.chat.SetStatusMsg("Connect " + accountJID + " to change what its server archives")
+		return nil
+	}
+	m.chat = m.chat.SetStatusMsg("Asking the server of " + accountJID + " to archive " + archiveModeText(mode) + "...")
+	return m.app.SetArchiveDefault(accountJID, mode)
+}
+
+// handleArchivePrefs reports a fetched or changed archiving preference,
+// warning when the server keeps messages that are not saved locally
+func (m *Model) handleArchivePrefs(msg app.ArchivePrefsMsg) {
+	status := ""
+	switch {
+	case msg.Error != "" && msg.Changed:
+		status = "Could not change what the server of " + msg.AccountJID + " archives: " + msg.Error
+	case msg.Changed:
+		status = "The server of " + msg.AccountJID + " archives " + archiveModeText(msg.Default)
+	}
+	if msg.Conflict {
+		status = "Message saving is off, but the server of " + msg.AccountJID + " archives " +
+			archiveModeText(msg.Default) + " (M in the account details or :archive never stops it)"
+	}
+	if status != "" {
+		m.chat = m.chat.SetStatusMsg(status)
+	}
+	m.refreshPrivacyDialog(msg.AccountJID)
+}
+
+// archiveModeText says what an archiving default keeps
+func archiveModeText(mode string) string {
+	switch mode {
+	case "always":
+		return "all messages"
+	case "roster":
+		return "messages with roster contacts"
+	case "never":
+		return "no messages"
+	}
+	return mode
+}
@@ -0,0 +1,95 @@
+package ui
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/charmbracelet/x/term"
+)
+
+// Copying goes through the terminal with OSC 52 where it can, which works
+// over SSH and without any clipboard tool installed, as the terminal sets
+// the clipboard of the machine it runs on. Terminals that ignore OSC 52 do
+// so silently, so on a local session the external tool (pbcopy, clip,
+// wl-copy, xclip or xsel) is run as well. ui.clipboard picks one of the two
+// with "osc52" or "external"; "auto" or empty uses both as described.
+
+// maxOSC52 is the most text sent with OSC 52. Terminals limit the sequence
+// (xterm to about 100 KB), longer text only goes to the external tool.
+const maxOSC52 = 74994
+
+var errNoClipboard = errors.New("no clipboard: the terminal does not take OSC 52 and no clipboard tool was found")
+
+// copyToClipboard copies text to the system clipboard
+func (m *Model) copyToClipboard(text string) error {
+	mode := m.app.Config().UI.Clipboard
+	osc := mode != "external" && len(text) <= maxOSC52 && copyOSC52(text) == nil
+	if mode == "osc52" {
+		if !osc {
+			return errNoClipboard
+		}
+		return nil
+	}
+	if osc && remoteSession() {
+		// The tool would fill the remote machine's clipboard
+		return nil
+	}
+	if err := copyExternal(text); err != nil && !osc {
+		return err
+	}
+	return nil
+}
+
+// copyOSC52 asks the terminal to set its clipboard. Inside tmux or screen
+// the sequence is wrapped to reach the outer terminal.
+func copyOSC52(text string) error {
+	if !term.IsTerminal(os.Stdout.Fd()) || os.Getenv("TERM") == "dumb" {
+		return errNoClipboard
+	}
+	seq := "\x1b]52;c;" + base64.StdEncoding.EncodeToString([]byte(text)) + "\a"
+	switch {
+	case os.Getenv("TMUX") != "":
+		seq = "\x1bPtmux;" + strings.ReplaceAll(seq, "\x1b", "\x1b\x1b") + "\x1b\\"
+	case strings.HasPrefix(os.Getenv("TERM"), "screen"):
+		seq = "\x1bP" + seq + "\x1b\\"
+	}
+	// One write, so the sequence is not split by a redraw
+	_, err := os.Stdout.WriteString(seq)
+	return err
+}
+
+// remoteSession reports whether roster runs over SSH
+func remoteSession() bool {
+	return os.Getenv("SSH_TTY") != "" || os.Getenv("SSH_CONNECTION") != ""
+}
+
+// copyExternal copies text with the clipboard tool of the platform
+func copyExternal(text string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("pbcopy")
+	case "windows":
+		cmd = exec.Command("clip")
+	default:
+		tools := [][]string{{"xclip", "-selection", "clipboard"}, {"xsel", "--clipboard", "--input"}}
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			tools = append([][]string{{"wl-copy"}}, tools...)
+		}
+		for _, tool := range tools {
+			if _, err := exec.LookPath(tool[0]); err == nil {
+				cmd = exec.Command(tool[0], tool[1:]...)
+				break
+			}
+		}
+		if cmd == nil {
+			return errNoClipboard
+		}
+	}
+	cmd.Stdin = strings.NewReader(text)
+	return cmd.Run()
+}
@@ -1,14 +1,20 @@
 package chat
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-runewidth"
 	"github.com/meszmate/roster/internal/ui/theme"
+	"github.com/meszmate/roster/internal/ui/timefmt"
 )
 
 // MessageStatus represents the delivery status of a message
@@ -50,6 +56,8 @@ type Message struct {
 	Status      MessageStatus
 	CorrectedID string
 	Reactions   map[string]string
+	JSON        string // XEP-0335 JSON container payload
+	Warning     bool   // a system message shown as a security warning
 
 	FileURL  string
 	FileName string
@@ -69,11 +77,21 @@ type Model struct {
 	jid           string
 	input         string
 	cursorPos     int
+	sent          []string // composer history of the conversation, oldest first
+	sentPos       int      // entry of sent shown, len(sent) for the draft
+	sentDraft     string   // what was being written before going back
 	offset        int
 	width         int
 	height        int
 	styles        *theme.Styles
 	encrypted     bool
+	notSaved      bool          // conversation is not written to disk
+	expireAfter   time.Duration // local auto-delete timer, 0 when off
+	timeFmt       timefmt.Formatter
+	wrapMode      string // WrapIndent or WrapFlush
+	maxBodyWidth  int    // columns a message body may use, 0 for the whole pane
+	nickColumn    int    // width nicks are aligned to, 0 when unaligned
+	layout        string // LayoutCompact, LayoutCozy or LayoutBubble
 	typing        bool
 	peerTyping    bool
 	searchQuery   string
@@ -85,9 +103,40 @@ type Model struct {
 
 	// Chat header state
 	headerFocused  bool
-	headerSelected int                // 0=edit, 1=sharing, 2=verify, 3=details
+	headerSelected int                // 0=edit, 1=sharing, 2=verify, 3=details, 4=resource
 	contactData    *ContactDetailData // Contact info for header display
 	infoExpanded   bool               // Expanded inline contact info panel
+
+	// New OMEMO device warning
+	securityBanner string
+	sendPaused     bool // encrypted sending held until the banner is acknowledged
+
+	// Canned responses expanded from ;name in the composer
+	snippets map[string]string
+
+	// Room occupants whose messages are collapsed
+	ignoredNicks map[string]bool
+
+	// IDs of the starred messages of the conversation
+	starred map[string]bool
+
+	// Room activity since the last visit
+	activityBanner string
+	firstNew       int   // index of the first new message, -1 if none
+	mentions       []int // indices of messages mentioning the own nick
+	mentionIdx     int
+
+	// Warning about the message database, shown in every window
+	storageBanner string
+
+	// Fetched link previews: URL -> preview
+	previews map[string]LinkPreview
+}
+
+// LinkPreview is the title and description shown below a linked message
+type LinkPreview struct {
+	Title       string
+	Description string
 }
 
 // New creates a new chat model
@@ -101,6 +150,14 @@ func New(styles *theme.Styles) Model {
 
 // SetJID sets the current chat JID
 func (m Model) SetJID(jid string) Model {
+	if jid != m.jid {
+		m.peerTyping = false
+		m.securityBanner = ""
+		m.sendPaused = false
+		m.activityBanner = ""
+		m.mentions = nil
+		m = m.SetSentHistory(nil)
+	}
 	m.jid = jid
 	m.input = ""
 	m.cursorPos = 0
@@ -268,12 +325,234 @@ func (m Model) SetEncrypted(encrypted bool) Model {
 	return m
 }
 
+// SetSaving sets whether the conversation is saved to disk
+func (m Model) SetSaving(saving bool) Model {
+	m.notSaved = !saving
+	return m
+}
+
+// SetExpiry sets how long local copies of the conversation's messages are
+// kept, 0 when they are not auto-deleted
+func (m Model) SetExpiry(d time.Duration) Model {
+	m.expireAfter = d
+	return m
+}
+
+// SetInput replaces the composer text, leaving the cursor at its end
+func (m Model) SetInput(text string) Model {
+	m.input = text
+	m.cursorPos = len(text)
+	return m
+}
+
+// SetSentHistory sets the messages sent in the conversation, oldest first,
+// for HistoryPrev and HistoryNext
+func (m Model) SetSentHistory(sent []string) Model {
+	m.sent = sent
+	m.sentPos = len(sent)
+	m.sentDraft = ""
+	return m
+}
+
+// HistoryPrev shows the message sent before the one in the composer, like
+// Up in a shell. What was being written comes back with HistoryNext, and a
+// recalled message that was edited counts as being written.
+func (m Model) HistoryPrev() Model {
+	m = m.syncSentPos()
+	if m.sentPos == 0 {
+		return m
+	}
+	if m.sentPos == len(m.sent) {
+		m.sentDraft = m.input
+	}
+	m.sentPos--
+	return m.SetInput(m.sent[m.sentPos])
+}
+
+// HistoryNext shows the message sent after the one in the composer, and
+// after the last one what was being written
+func (m Model) HistoryNext() Model {
+	m = m.syncSentPos()
+	if m.sentPos >= len(m.sent) {
+		return m
+	}
+	m.sentPos++
+	if m.sentPos == len(m.sent) {
+		return m.SetInput(m.sentDraft)
+	}
+	return m.SetInput(m.sent[m.sentPos])
+}
+
+// syncSentPos goes back to the draft when a recalled message was edited
+func (m Model) syncSentPos() Model {
+	if m.sentPos < len(m.sent) && m.input != m.sent[m.sentPos] {
+		m.sentDraft = m.input
+		m.sentPos = len(m.sent)
+	}
+	return m
+}
+
+// Input returns the composer text
+func (m Model) Input() string {
+	return m.input
+}
+
+// SetTimeFormat sets how timestamps and dates are rendered
+func (m Model) SetTimeFormat(f timefmt.Formatter) Model {
+	m.timeFmt = f
+	return m
+}
+
+// expiryHint returns the countdown until a message is deleted locally
+func (m Model) expiryHint(msg Message) string {
+	if m.expireAfter <= 0 || msg.Timestamp.IsZero() {
+		return ""
+	}
+	left := time.Until(msg.Timestamp.Add(m.expireAfter))
+	var text string
+	switch {
+	case left >= 24*time.Hour:
+		text = fmt.Sprintf("%dd", left/(24*time.Hour))
+	case left >= time.Hour:
+		text = fmt.Sprintf("%dh", left/time.Hour)
+	default:
+		text = fmt.Sprintf("%dm", max(left/time.Minute, 1))
+	}
+	return " " + m.styles.ChatSystem.Render("⏳"+text)
+}
+
 // SetPeerTyping sets whether the peer is typing
 func (m Model) SetPeerTyping(typing bool) Model {
 	m.peerTyping = typing
 	return m
 }
 
+// SetStorageBanner shows a warning about the message database above the
+// messages of every chat, or hides it when banner is ""
+func (m Model) SetStorageBanner(banner string) Model {
+	m.storageBanner = banner
+	return m
+}
+
+// SetSecurityBanner shows a warning banner above the messages. When paused is
+// set, encrypted messages are held back until the banner is cleared.
+func (m Model) SetSecurityBanner(banner string, paused bool) Model {
+	m.securityBanner = banner
+	m.sendPaused = paused && banner != ""
+	return m
+}
+
+// SetSnippets sets the snippets that ;name expands to in the composer
+func (m Model) SetSnippets(snippets map[string]string) Model {
+	m.snippets = snippets
+	return m
+}
+
+// expandSnippet replaces a ;name word right before the cursor with the
+// snippet text
+func (m Model) expandSnippet() Model {
+	start := strings.LastIndexAny(m.input[:m.cursorPos], " \n") + 1
+	word := m.input[start:m.cursorPos]
+	if len(word) < 2 || word[0] != ';' {
+		return m
+	}
+	text, ok := m.snippets[word[1:]]
+	if !ok {
+		return m
+	}
+	m.input = m.input[:start] + text + m.input[m.cursorPos:]
+	m.cursorPos = start + len(text)
+	return m
+}
+
+// SetRoomActivity shows a summary banner of the room activity since the last
+// visit, with the message indices the jump keys move to
+func (m Model) SetRoomActivity(banner string, firstNew int, mentions []int) Model {
+	m.activityBanner = banner
+	m.firstNew = firstNew
+	m.mentions = mentions
+	m.mentionIdx = -1
+	return m
+}
+
+// JumpToFirstNew scrolls to the first message that arrived since the last visit
+func (m Model) JumpToFirstNew() Model {
+	if m.activityBanner == "" || m.firstNew < 0 {
+		return m
+	}
+	return m.scrollToMessage(m.firstNew)
+}
+
+// JumpToNextMention scrolls to the next message mentioning the own nick,
+// wrapping around after the last one
+func (m Model) JumpToNextMention() Model {
+	if len(m.mentions) == 0 {
+		return m
+	}
+	m.mentionIdx = (m.mentionIdx + 1) % len(m.mentions)
+	return m.scrollToMessage(m.mentions[m.mentionIdx])
+}
+
+// scrollToMessage selects a message and scrolls it into view
+func (m Model) scrollToMessage(idx int) Model {
+	if idx < 0 || idx >= len(m.messages) {
+		return m
+	}
+	m.selectedMsg = idx
+	if idx < m.offset {
+		m.offset = idx
+	} else if idx >= m.offset+m.height-3 {
+		m.offset = idx - m.height + 4
+	}
+	return m
+}
+
+// SetIgnoredNicks sets the room occupants whose messages are hidden
+func (m Model) SetIgnoredNicks(nicks []string) Model {
+	m.ignoredNicks = make(map[string]bool, len(nicks))
+	for _, nick := range nicks {
+		m.ignoredNicks[nick] = true
+	}
+	return m
+}
+
+// SetStarred sets the IDs of the conversation's starred messages
+func (m Model) SetStarred(ids []string) Model {
+	m.starred = make(map[string]bool, len(ids))
+	for _, id := range ids {
+		m.starred[id] = true
+	}
+	return m
+}
+
+// starMarker marks a starred message
+func (m Model) starMarker(msg Message) string {
+	if msg.ID == "" || !m.starred[msg.ID] {
+		return ""
+	}
+	return " " + m.styles.ChatSystem.Render("★")
+}
+
+// JumpToMessage selects the message with an ID and scrolls it into view.
+// It reports false when the message is not among those loaded.
+func (m Model) JumpToMessage(id string) (Model, bool) {
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].ID == id {
+			return m.scrollToMessage(i), true
+		}
+	}
+	return m, false
+}
+
+// isIgnored reports whether a message comes from an ignored room occupant
+func (m Model) isIgnored(msg Message) bool {
+	if msg.Outgoing || len(m.ignoredNicks) == 0 {
+		return false
+	}
+	idx := strings.Index(msg.From, "/")
+	return idx >= 0 && m.ignoredNicks[msg.From[idx+1:]]
+}
+
 // SetStatusMsg sets a status message to display
 func (m Model) SetStatusMsg(msg string) Model {
 	m.statusMsg = msg
@@ -328,14 +607,14 @@ func (m Model) HeaderNavigateLeft() Model {
 
 // HeaderNavigateRight moves header selection right
 func (m Model) HeaderNavigateRight() Model {
-	if m.headerSelected < 3 {
+	if m.headerSelected < 4 {
 		m.headerSelected++
 	}
 	return m
 }
 
 // HeaderSelectedAction returns the currently selected header action
-// 0=edit, 1=sharing, 2=verify, 3=details
+// 0=edit, 1=sharing, 2=verify, 3=details, 4=resource
 func (m Model) HeaderSelectedAction() int {
 	return m.headerSelected
 }
@@ -488,6 +767,54 @@ func (m Model) SelectPrevFileMessage() Model {
 	return m
 }
 
+// linkRe matches http(s) links in a message body
+var linkRe = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// FirstLink returns the first http(s) link in a message body
+func FirstLink(body string) string {
+	return strings.TrimRight(linkRe.FindString(body), ".,;:!?)")
+}
+
+// SetLinkPreview attaches a fetched preview to every message linking to url
+func (m Model) SetLinkPreview(url string, preview LinkPreview) Model {
+	if m.previews == nil {
+		m.previews = make(map[string]LinkPreview)
+	}
+	m.previews[url] = preview
+	return m
+}
+
+// renderLinkPreview returns the preview lines shown below a message
+func (m Model) renderLinkPreview(msg Message) []string {
+	if len(m.previews) == 0 || msg.Type == "system" {
+		return nil
+	}
+	preview, ok := m.previews[FirstLink(msg.Body)]
+	if !ok || preview.Title == "" {
+		return nil
+	}
+
+	maxWidth := m.width - 12
+	if maxWidth < 10 {
+		maxWidth = 10
+	}
+	title := preview.Title
+	if runes := []rune(title); len(runes) > maxWidth {
+		title = string(runes[:maxWidth-1]) + "…"
+	}
+	indent := strings.Repeat(" ", 8)
+	lines := []string{indent + m.styles.ChatSystem.Render("▏ "+title)}
+	if preview.Description != "" {
+		for i, line := range wordWrap(preview.Description, maxWidth) {
+			if i == 2 {
+				break
+			}
+			lines = append(lines, indent+m.styles.ChatSystem.Render("▏ "+line))
+		}
+	}
+	return lines
+}
+
 // extractFileURL extracts HTTPS URLs from message body
 func extractFileURL(body string) (string, bool) {
 	// Match HTTPS URLs only (security)
@@ -574,11 +901,20 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 			m.cursorPos = len(m.input)
 
 		case tea.KeyEnter:
+			m = m.expandSnippet()
+			if m.input != "" && m.sendPaused && m.encrypted {
+				m.statusMsg = "Encrypted sending paused: verify (v) or dismiss (gD) the new device first"
+				return m, nil
+			}
 			if m.input != "" {
 				sendMsg := SendMsg{
 					To:   m.jid,
 					Body: m.input,
 				}
+				if len(m.sent) == 0 || m.sent[len(m.sent)-1] != m.input {
+					m.sent = append(m.sent, m.input)
+				}
+				m = m.SetSentHistory(m.sent)
 				m.input = ""
 				m.cursorPos = 0
 				m.typing = false
@@ -588,6 +924,7 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 			}
 
 		case tea.KeySpace:
+			m = m.expandSnippet()
 			m.input = m.input[:m.cursorPos] + " " + m.input[m.cursorPos:]
 			m.cursorPos++
 		}
@@ -619,28 +956,34 @@ func (m Model) View() string {
 		}
 
 		// Status icon
-		var statusIcon string
-		switch m.contactData.Status {
-		case "online":
-			statusIcon = m.styles.PresenceOnline.Render("●")
-		case "away":
-			statusIcon = m.styles.PresenceAway.Render("◐")
-		case "dnd":
-			statusIcon = m.styles.PresenceDND.Render("⊘")
-		case "xa":
-			statusIcon = m.styles.PresenceXA.Render("◯")
-		default:
-			statusIcon = m.styles.PresenceOffline.Render("○")
-		}
+		statusIcon := m.styles.PresenceIcon(m.contactData.Status)
 
 		header = fmt.Sprintf("%s %s", statusIcon, displayName)
 
+		// Messages go to one client only
+		if m.contactData.LockedTo != "" {
+			lock := "→ " + m.contactData.LockedTo
+			if !m.contactData.LockedOnline {
+				lock += " (offline)"
+			}
+			header += " " + m.styles.PresenceAway.Render("["+lock+"]")
+		}
+
 		// Encryption indicator
 		if m.encrypted {
 			header += " " + m.styles.ChatEncrypted.Render("🔒")
 		} else {
 			header += " " + m.styles.ChatUnencrypted.Render("🔓")
 		}
+
+		if m.notSaved {
+			header += " " + m.styles.ChatSystem.Render("[not logged]")
+		}
+
+		// Live typing indicator
+		if m.peerTyping {
+			header += " " + m.styles.ChatTyping.Render("typing...")
+		}
 	} else if m.jid != "" {
 		// Encryption indicator (fallback when no contact data)
 		if m.encrypted {
@@ -648,6 +991,9 @@ func (m Model) View() string {
 		} else {
 			header += " " + m.styles.ChatUnencrypted.Render("🔓")
 		}
+		if m.notSaved {
+			header += " " + m.styles.ChatSystem.Render("[not logged]")
+		}
 	}
 
 	b.WriteString(m.styles.ChatNick.Render(header))
@@ -655,7 +1001,7 @@ func (m Model) View() string {
 
 	// Header line 2: Action buttons (when focused)
 	if m.headerFocused && m.jid != "" {
-		actions := []string{"[E]dit", "[S]haring", "[V]erify", "[D]etails"}
+		actions := []string{"[E]dit", "[S]haring", "[V]erify", "[D]etails", "[R]esource"}
 		var actionLine strings.Builder
 		actionLine.WriteString("  ")
 		for i, action := range actions {
@@ -688,6 +1034,22 @@ func (m Model) View() string {
 		visibleHeight-- // Account for action buttons line
 	}
 	visibleHeight -= len(infoLines)
+
+	if m.securityBanner != "" && m.jid != "" {
+		b.WriteString(m.styles.ChatUnencrypted.Render("⚠ " + m.securityBanner))
+		b.WriteString("\n")
+		visibleHeight--
+	}
+	if m.activityBanner != "" && m.jid != "" {
+		b.WriteString(m.styles.ChatSystem.Render("» " + m.activityBanner))
+		b.WriteString("\n")
+		visibleHeight--
+	}
+	if m.storageBanner != "" {
+		b.WriteString(m.styles.ChatUnencrypted.Render("⚠ " + m.storageBanner))
+		b.WriteString("\n")
+		visibleHeight--
+	}
 	if visibleHeight < 1 {
 		visibleHeight = 1
 	}
@@ -743,7 +1105,27 @@ func (m Model) View() string {
 	msgCount := 0
 	for i := m.offset; i < len(m.messages) && msgCount < visibleHeight; i++ {
 		msg := m.messages[i]
-		lines := m.renderMessage(msg)
+		var lines []string
+		if m.isIgnored(msg) {
+			// Collapse a run of ignored messages into a single marker
+			hidden := 1
+			for i+1 < len(m.messages) && m.isIgnored(m.messages[i+1]) {
+				i++
+				hidden++
+			}
+			marker := fmt.Sprintf("%d messages hidden", hidden)
+			if hidden == 1 {
+				marker = "1 message hidden"
+			}
+			lines = []string{m.styles.ChatSystem.Render("··· " + marker)}
+		} else {
+			var prev *Message
+			if i > m.offset && !m.isIgnored(m.messages[i-1]) {
+				prev = &m.messages[i-1]
+			}
+			lines = m.renderLayout(msg, prev)
+			lines = append(lines, m.renderLinkPreview(msg)...)
+		}
 		for _, line := range lines {
 			if msgCount < visibleHeight {
 				b.WriteString(line)
@@ -791,6 +1173,15 @@ func (m Model) renderInlineInfo() []string {
 	if m.contactData.StatusMsg != "" {
 		compact += " | " + m.contactData.StatusMsg
 	}
+	if status == "offline" && !m.contactData.LastSeen.IsZero() {
+		compact += " | last seen " + m.timeFmt.Ago(m.contactData.LastSeen, time.Now())
+	}
+	compact += " | " + subscriptionLabel(m.contactData.Subscription)
+	if m.encrypted {
+		compact += " | OMEMO"
+	} else {
+		compact += " | plain"
+	}
 	if m.infoExpanded {
 		compact += " | gi collapse"
 	} else {
@@ -816,10 +1207,18 @@ func (m Model) renderInlineInfo() []string {
 		sharing = "on"
 	}
 
+	lastSeen := "(unknown)"
+	if status != "offline" {
+		lastSeen = "now"
+	} else if !m.contactData.LastSeen.IsZero() {
+		lastSeen = m.timeFmt.When(m.contactData.LastSeen, time.Now())
+	}
+
 	details := []string{
 		"  Groups: " + groups,
-		"  Subscription: " + m.contactData.Subscription,
+		"  Subscription: " + m.contactData.Subscription + " (" + subscriptionLabel(m.contactData.Subscription) + ")",
 		"  Status sharing: " + sharing,
+		"  Last seen: " + lastSeen,
 	}
 	for _, detail := range details {
 		if len(detail) > maxWidth {
@@ -831,85 +1230,261 @@ func (m Model) renderInlineInfo() []string {
 	return lines
 }
 
-// renderMessage renders a single message
+// subscriptionLabel describes a roster subscription state from the user's point of view
+func subscriptionLabel(sub string) string {
+	switch sub {
+	case "both":
+		return "mutual"
+	case "to":
+		return "you see them"
+	case "from":
+		return "they see you"
+	default:
+		return "not subscribed"
+	}
+}
+
+// renderMessage renders a single message in the compact layout
 func (m Model) renderMessage(msg Message) []string {
 	var lines []string
 
 	// Timestamp
-	timestamp := m.styles.ChatTimestamp.Render(msg.Timestamp.Format("15:04"))
+	timestamp := m.styles.ChatTimestamp.Render(m.timeFmt.Stamp(msg.Timestamp, time.Now()))
 
 	// Sender nick
-	nick := msg.From
-	if msg.Outgoing {
-		nick = "me"
-	}
-	nickStr := m.styles.ChatNick.Render(nick)
-
-	// Status icon for outgoing messages
-	statusStr := ""
-	if msg.Outgoing && msg.Status != StatusNone {
-		statusStr = " " + m.statusIcon(msg.Status)
-	}
+	nick := alignNick(senderNick(msg), m.nickColumn)
+	padded := strings.TrimLeft(nick, " ")
+	nickStr := nick[:len(nick)-len(padded)] + m.styles.ChatNick.Render(padded)
 
-	// Message body style
-	var bodyStyle lipgloss.Style
-	if msg.Outgoing {
-		bodyStyle = m.styles.ChatMyMessage
-	} else {
-		bodyStyle = m.styles.ChatTheirMessage
-	}
+	statusStr := m.messageStatus(msg)
+	bodyStyle := m.bodyStyle(msg)
 
 	// Handle system messages
 	if msg.Type == "system" {
+		if msg.Warning {
+			return []string{m.styles.ChatUnencrypted.Render(fmt.Sprintf("⚠ %s", msg.Body))}
+		}
 		line := m.styles.ChatSystem.Render(fmt.Sprintf("*** %s", msg.Body))
 		return []string{line}
 	}
 
-	// Check if message contains a file URL
-	if msg.FileURL != "" || (msg.Body != "" && strings.HasPrefix(msg.Body, "https://")) {
-		return m.renderFileMessage(msg, timestamp, nickStr, statusStr)
+	// Structured bot payloads are pretty-printed
+	if payload, ok := messageJSON(msg); ok {
+		return m.renderJSONMessage(msg, payload, timestamp, nickStr, statusStr)
 	}
 
-	// Word wrap message body
-	maxWidth := m.width - 15 // timestamp + nick + padding
-	if maxWidth < 10 {
-		maxWidth = 10
+	// Check if message contains a file URL
+	if isFileMessage(msg) {
+		return m.renderFileMessage(msg, timestamp, nickStr, statusStr)
 	}
 
-	wrapped := wordWrap(msg.Body, maxWidth)
-	correctedMarker := ""
-	if msg.CorrectedID != "" {
-		correctedMarker = " " + m.styles.ChatSystem.Render("(edited)")
-	}
+	// Word wrap message body after the "timestamp nick: " prefix
+	first, rest, indent := m.bodyLayout(m.prefixWidth(timestamp, nickStr))
+	padding := strings.Repeat(" ", indent)
+	wrapped := wrapText(msg.Body, first, rest)
+	correctedMarker := m.editedMarker(msg)
 	for i, line := range wrapped {
 		var formatted string
 		if i == 0 {
 			formatted = fmt.Sprintf("%s %s: %s%s%s", timestamp, nickStr, bodyStyle.Render(line), correctedMarker, statusStr)
 		} else {
-			padding := strings.Repeat(" ", 6+len(nick)+2)
 			formatted = padding + bodyStyle.Render(line)
 		}
 		lines = append(lines, formatted)
 	}
 
-	if len(msg.Reactions) > 0 {
-		var reactionEmojis []string
-		seenReactions := make(map[string]bool)
-		for _, r := range msg.Reactions {
-			if !seenReactions[r] {
-				reactionEmojis = append(reactionEmojis, r)
-				seenReactions[r] = true
-			}
+	if reactions := m.reactionsText(msg); reactions != "" {
+		lines = append(lines, padding+reactions)
+	}
+
+	return lines
+}
+
+// senderNick returns the name a message is shown from
+func senderNick(msg Message) string {
+	if msg.Outgoing {
+		return "me"
+	}
+	return msg.From
+}
+
+// isFileMessage reports whether a message is rendered as a file card
+func isFileMessage(msg Message) bool {
+	return msg.FileURL != "" || (msg.Body != "" && strings.HasPrefix(msg.Body, "https://"))
+}
+
+// messageStatus returns the delivery icon of an outgoing message, with
+// whether it was encrypted, its expiry countdown and its star
+func (m Model) messageStatus(msg Message) string {
+	statusStr := ""
+	if msg.Outgoing && msg.Status != StatusNone {
+		statusStr = " " + m.statusIcon(msg.Status)
+		if msg.Encrypted {
+			statusStr += m.styles.ChatEncrypted.Render("🔒")
+		} else {
+			statusStr += m.styles.ChatUnencrypted.Render("🔓")
 		}
-		if len(reactionEmojis) > 0 {
-			reactionsLine := strings.Repeat(" ", 8) + m.styles.ChatSystem.Render(strings.Join(reactionEmojis, " "))
-			lines = append(lines, reactionsLine)
+	}
+	return statusStr + m.expiryHint(msg) + m.starMarker(msg)
+}
+
+// bodyStyle returns the style of a message body
+func (m Model) bodyStyle(msg Message) lipgloss.Style {
+	if msg.Outgoing {
+		return m.styles.ChatMyMessage
+	}
+	return m.styles.ChatTheirMessage
+}
+
+// editedMarker marks a corrected message
+func (m Model) editedMarker(msg Message) string {
+	if msg.CorrectedID == "" {
+		return ""
+	}
+	return " " + m.styles.ChatSystem.Render("(edited)")
+}
+
+// reactionsText renders the distinct reactions to a message, or "" when
+// there are none
+func (m Model) reactionsText(msg Message) string {
+	var reactionEmojis []string
+	seenReactions := make(map[string]bool)
+	for _, r := range msg.Reactions {
+		if !seenReactions[r] {
+			reactionEmojis = append(reactionEmojis, r)
+			seenReactions[r] = true
 		}
 	}
+	if len(reactionEmojis) == 0 {
+		return ""
+	}
+	return m.styles.ChatSystem.Render(strings.Join(reactionEmojis, " "))
+}
+
+// maxJSONLines limits how much of a JSON payload is shown inline
+const maxJSONLines = 30
+
+// jsonFenceRe matches a body that is a single fenced ```json block
+var jsonFenceRe = regexp.MustCompile("(?s)^\\s*```json[ \\t]*\\n(.*?)\\n?```\\s*$")
+
+// messageJSON returns the JSON payload of a message: its XEP-0335 container,
+// a fenced ```json block, or a body that is a JSON object or array
+func messageJSON(msg Message) (string, bool) {
+	if msg.JSON != "" {
+		return msg.JSON, true
+	}
+	body := strings.TrimSpace(msg.Body)
+	if match := jsonFenceRe.FindStringSubmatch(body); match != nil {
+		body = strings.TrimSpace(match[1])
+	} else if !strings.HasPrefix(body, "{") && !strings.HasPrefix(body, "[") {
+		return "", false
+	}
+	if !json.Valid([]byte(body)) {
+		return "", false
+	}
+	return body, true
+}
 
+// prettyJSON indents a JSON payload, returning it unchanged if it is invalid
+func prettyJSON(payload string) string {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(payload), "", "  "); err != nil {
+		return payload
+	}
+	return buf.String()
+}
+
+// renderJSONMessage renders a message carrying a JSON payload as an indented,
+// highlighted block below the sender line
+func (m Model) renderJSONMessage(msg Message, payload, timestamp, nickStr, statusStr string) []string {
+	header := fmt.Sprintf("%s %s:%s", timestamp, nickStr, statusStr)
+	if msg.JSON != "" && strings.TrimSpace(msg.Body) != "" && strings.TrimSpace(msg.Body) != msg.JSON {
+		header = fmt.Sprintf("%s %s: %s%s", timestamp, nickStr, m.styles.ChatTheirMessage.Render(strings.SplitN(msg.Body, "\n", 2)[0]), statusStr)
+	}
+	lines := []string{header}
+
+	_, maxWidth, indent := m.bodyLayout(m.prefixWidth(timestamp, nickStr))
+	padding := strings.Repeat(" ", indent)
+	jsonLines := strings.Split(prettyJSON(payload), "\n")
+	for i, line := range jsonLines {
+		if i == maxJSONLines {
+			more := fmt.Sprintf("… %d more lines (gY copies JSON)", len(jsonLines)-i)
+			lines = append(lines, padding+m.styles.ChatSystem.Render(more))
+			break
+		}
+		line = runewidth.Truncate(line, maxWidth, "…")
+		lines = append(lines, padding+m.highlightJSONLine(line))
+	}
 	return lines
 }
 
+// highlightJSONLine colours the keys, strings, numbers and literals of one
+// line of indented JSON
+func (m Model) highlightJSONLine(line string) string {
+	var b strings.Builder
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == '"':
+			j := i + 1
+			for j < len(line) && line[j] != '"' {
+				if line[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j < len(line) {
+				j++
+			}
+			token := line[i:j]
+			if strings.HasPrefix(strings.TrimLeft(line[j:], " "), ":") {
+				b.WriteString(m.styles.ChatJSONKey.Render(token))
+			} else {
+				b.WriteString(m.styles.ChatJSONString.Render(token))
+			}
+			i = j
+		case c == '-' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(line) && strings.IndexByte("0123456789.eE+-", line[j]) >= 0 {
+				j++
+			}
+			b.WriteString(m.styles.ChatJSONNumber.Render(line[i:j]))
+			i = j
+		case c >= 'a' && c <= 'z':
+			j := i + 1
+			for j < len(line) && line[j] >= 'a' && line[j] <= 'z' {
+				j++
+			}
+			b.WriteString(m.styles.ChatJSONLiteral.Render(line[i:j]))
+			i = j
+		case c == ' ':
+			b.WriteByte(c)
+			i++
+		default:
+			r, size := utf8.DecodeRuneInString(line[i:])
+			b.WriteString(m.styles.ChatJSONPunct.Render(string(r)))
+			i += size
+		}
+	}
+	return b.String()
+}
+
+// SelectedJSON returns the indented JSON payload of the selected message, or
+// of the most recent message carrying one
+func (m Model) SelectedJSON() (string, bool) {
+	if selMsg := m.SelectedMessage(); selMsg != nil {
+		if payload, ok := messageJSON(*selMsg); ok {
+			return prettyJSON(payload), true
+		}
+	}
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if payload, ok := messageJSON(m.messages[i]); ok {
+			return prettyJSON(payload), true
+		}
+	}
+	return "", false
+}
+
 // renderFileMessage renders a message that contains a file URL
 func (m Model) renderFileMessage(msg Message, timestamp, nickStr, statusStr string) []string {
 	var lines []string
@@ -939,16 +1514,17 @@ func (m Model) renderFileMessage(msg Message, timestamp, nickStr, statusStr stri
 	lines = append(lines, firstLine)
 
 	// Second line: URL (truncated if needed)
+	_, maxURLWidth, indent := m.bodyLayout(m.prefixWidth(timestamp, nickStr))
+	padding := strings.Repeat(" ", indent)
 	urlDisplay := fileURL
-	maxURLWidth := m.width - 10
-	if len(urlDisplay) > maxURLWidth && maxURLWidth > 20 {
-		urlDisplay = urlDisplay[:maxURLWidth-3] + "..."
+	if maxURLWidth > 20 {
+		urlDisplay = runewidth.Truncate(urlDisplay, maxURLWidth, "...")
 	}
-	urlLine := strings.Repeat(" ", 6+4+2) + m.styles.ChatSystem.Render(urlDisplay)
+	urlLine := padding + m.styles.ChatSystem.Render(urlDisplay)
 	lines = append(lines, urlLine)
 
 	// Third line: actions hint
-	actionsLine := strings.Repeat(" ", 6+4+2) + m.styles.ChatTimestamp.Render("[o=open  c=copy URL]")
+	actionsLine := padding + m.styles.ChatTimestamp.Render("[o=open  c=copy URL]")
 	lines = append(lines, actionsLine)
 
 	return lines
@@ -992,38 +1568,6 @@ func formatFingerprint(fp string) string {
 	return result.String()
 }
 
-// wordWrap wraps text to the specified width
-func wordWrap(text string, width int) []string {
-	if width <= 0 {
-		return []string{text}
-	}
-
-	var lines []string
-	words := strings.Fields(text)
-	if len(words) == 0 {
-		return []string{""}
-	}
-
-	var currentLine strings.Builder
-	for _, word := range words {
-		if currentLine.Len() == 0 {
-			currentLine.WriteString(word)
-		} else if currentLine.Len()+1+len(word) <= width {
-			currentLine.WriteString(" ")
-			currentLine.WriteString(word)
-		} else {
-			lines = append(lines, currentLine.String())
-			currentLine.Reset()
-			currentLine.WriteString(word)
-		}
-	}
-	if currentLine.Len() > 0 {
-		lines = append(lines, currentLine.String())
-	}
-
-	return lines
-}
-
 // AccountDetailData holds data for rendering account details
 type AccountDetailData struct {
 	JID              string
@@ -1038,8 +1582,34 @@ type AccountDetailData struct {
 	Session          bool
 	UnreadMsgs       int
 	UnreadChats      int
-	OMEMOFingerprint string // Own OMEMO fingerprint
-	OMEMODeviceID    uint32 // Own device ID
+	OMEMOFingerprint string     // Own OMEMO fingerprint
+	OMEMODeviceID    uint32     // Own device ID
+	TLS              *TLSDetail // nil unless connected over TLS
+	Archive          string     // server archiving default, or why it is not known
+	ArchiveConflict  bool       // the server archives while message saving is off
+	Traffic          TrafficDetail
+}
+
+// TrafficDetail holds an account's traffic statistics
+type TrafficDetail struct {
+	StanzasIn   int64
+	StanzasOut  int64
+	BytesIn     int64
+	BytesOut    int64
+	Reconnects  int
+	ConnectedAt time.Time     // zero while not connected
+	Latency     time.Duration // 0 until the server was pinged
+	Since       time.Time     // when counting started or was last reset
+}
+
+// TLSDetail holds the encryption details of an account's connection
+type TLSDetail struct {
+	Version      string
+	CipherSuite  string
+	ServerName   string   // SNI
+	Chain        []string // "subject, issued by issuer", server certificate first
+	Expires      time.Time
+	ExpiringSoon bool
 }
 
 // ContactDetailData holds data for rendering contact details
@@ -1055,9 +1625,21 @@ type ContactDetailData struct {
 	MyPresence    string // Your custom presence for this contact (empty = default)
 	MyPresenceMsg string
 	LastSeen      time.Time
-	StatusSharing bool // Whether you share your status with this contact
-	OMEMOEnabled  bool // Whether OMEMO is enabled for this contact
+	StatusSharing bool   // Whether you share your status with this contact
+	Saving        string // Message saving preference (empty = default)
+	SavingEnabled bool   // Whether messages are saved to disk
+	Expiry        string // Local auto-delete timer (empty = off)
+	Software      string // Client the contact runs, as last asked with :version
+	LockedTo      string // Resource messages are locked to (empty = bare JID)
+	LockedOnline  bool   // Whether that resource is still online
+	OMEMOEnabled  bool   // Whether OMEMO is enabled for this contact
 	Fingerprints  []FingerprintDisplay
+	Resources     []ResourceDetail
+
+	// Availability history (empty when presence tracking is off)
+	Activity24h []float64 // Fraction online per hour, oldest first
+	Activity7d  []float64 // Fraction online per day, oldest first
+	UsualHours  string
 }
 
 // FingerprintDisplay holds fingerprint info for display
@@ -1067,6 +1649,16 @@ type FingerprintDisplay struct {
 	Trust       string // "verified", "trusted", "untrusted", "undecided"
 }
 
+// ResourceDetail is one online client of a contact
+type ResourceDetail struct {
+	Resource string
+	Priority int
+	Show     string // "" when plainly available
+	Client   string // from its caps node, "" when unknown
+	Ping     string // outcome of the last ping, "" when not pinged
+	Locked   bool   // messages of the conversation only go here
+}
+
 // RenderAccountDetails renders the account details view
 func (m Model) RenderAccountDetails(acc AccountDetailData) string {
 	if m.width == 0 || m.height == 0 {
@@ -1129,6 +1721,29 @@ func (m Model) RenderAccountDetails(acc AccountDetailData) string {
 
 	b.WriteString("\n")
 
+	// Encryption of the connection
+	if acc.TLS != nil && acc.Status == "online" {
+		b.WriteString(fmt.Sprintf("  TLS: %s, %s\n", acc.TLS.Version, acc.TLS.CipherSuite))
+		if acc.TLS.ServerName != "" {
+			b.WriteString(fmt.Sprintf("  SNI: %s\n", acc.TLS.ServerName))
+		}
+		if !acc.TLS.Expires.IsZero() {
+			expiry := "  Certificate expires: " + acc.TLS.Expires.Format("2006-01-02")
+			if acc.TLS.ExpiringSoon {
+				days := int(time.Until(acc.TLS.Expires).Hours() / 24)
+				expiry += " " + m.styles.PresenceDND.Render(fmt.Sprintf("⚠ %d days left", days))
+			}
+			b.WriteString(expiry + "\n")
+		}
+		if len(acc.TLS.Chain) > 0 {
+			b.WriteString("  Certificate chain:\n")
+			for _, cert := range acc.TLS.Chain {
+				b.WriteString(m.styles.ChatSystem.Render("    "+cert) + "\n")
+			}
+		}
+		b.WriteString("\n")
+	}
+
 	// Features
 	omemoStr := "OFF"
 	if acc.OMEMO {
@@ -1156,6 +1771,14 @@ func (m Model) RenderAccountDetails(acc AccountDetailData) string {
 	}
 	b.WriteString(fmt.Sprintf("  AutoConnect: [%s]\n", autoStr))
 
+	// What the server keeps in its message archive
+	if acc.Archive != "" && acc.Status == "online" {
+		b.WriteString(fmt.Sprintf("  Server archive: [%s] (M or :archive to change)\n", acc.Archive))
+		if acc.ArchiveConflict {
+			b.WriteString(m.styles.PresenceDND.Render("  ⚠ Message saving is off, but the server keeps a copy") + "\n")
+		}
+	}
+
 	// Account type
 	typeStr := "Saved"
 	if acc.Session {
@@ -1165,6 +1788,23 @@ func (m Model) RenderAccountDetails(acc AccountDetailData) string {
 
 	b.WriteString("\n")
 
+	// Traffic statistics
+	t := acc.Traffic
+	b.WriteString(fmt.Sprintf("  Stanzas: %d in, %d out\n", t.StanzasIn, t.StanzasOut))
+	b.WriteString(fmt.Sprintf("  Traffic: %s in, %s out\n", formatTrafficBytes(t.BytesIn), formatTrafficBytes(t.BytesOut)))
+	b.WriteString(fmt.Sprintf("  Reconnects: %d\n", t.Reconnects))
+	if !t.ConnectedAt.IsZero() {
+		b.WriteString(fmt.Sprintf("  Uptime: %s\n", time.Since(t.ConnectedAt).Truncate(time.Second)))
+	}
+	if t.Latency > 0 {
+		b.WriteString(fmt.Sprintf("  Latency: %s\n", t.Latency.Round(time.Millisecond)))
+	}
+	if !t.Since.IsZero() {
+		b.WriteString(m.styles.ChatSystem.Render("  (counted since "+t.Since.Format("2006-01-02 15:04")+", :stats reset clears)") + "\n")
+	}
+
+	b.WriteString("\n")
+
 	// Unread summary
 	if acc.UnreadMsgs > 0 {
 		unreadStr := fmt.Sprintf("  Unread: %d messages", acc.UnreadMsgs)
@@ -1185,12 +1825,24 @@ func (m Model) RenderAccountDetails(acc AccountDetailData) string {
 	// Actions hint at bottom
 	b.WriteString(strings.Repeat("─", m.width-2))
 	b.WriteString("\n")
-	b.WriteString(m.styles.ChatSystem.Render("  [E] Edit  [C] Connect  [D] Disconnect  [T] Toggle Auto  [X] Remove  [Esc] Back"))
+	b.WriteString(m.styles.ChatSystem.Render("  [E] Edit  [C] Connect  [D] Disconnect  [T] Toggle Auto  [M] Archive  [gP] Privacy  [X] Remove  [Esc] Back"))
 	b.WriteString("\n")
 
 	return b.String()
 }
 
+// formatTrafficBytes formats a byte count to a human readable string
+func formatTrafficBytes(n int64) string {
+	switch {
+	case n < 1024:
+		return strconv.FormatInt(n, 10) + " B"
+	case n < 1024*1024:
+		return strconv.FormatFloat(float64(n)/1024, 'f', 1, 64) + " KB"
+	default:
+		return strconv.FormatFloat(float64(n)/(1024*1024), 'f', 1, 64) + " MB"
+	}
+}
+
 // RenderContactDetails renders the contact details view
 func (m Model) RenderContactDetails(contact ContactDetailData) string {
 	if m.width == 0 || m.height == 0 {
@@ -1206,33 +1858,19 @@ func (m Model) RenderContactDetails(contact ContactDetailData) string {
 	b.WriteString(strings.Repeat("─", m.width-2))
 	b.WriteString("\n\n")
 
-	// Name and JID
+	// Name and JID beside the contact's initials
+	var ident strings.Builder
 	if contact.Name != "" && contact.Name != contact.JID {
-		b.WriteString(fmt.Sprintf("  Name: %s\n", contact.Name))
+		ident.WriteString(fmt.Sprintf("Name: %s\n", contact.Name))
 	}
-	b.WriteString(fmt.Sprintf("  JID: %s\n", contact.JID))
-
-	b.WriteString("\n")
+	ident.WriteString(fmt.Sprintf("JID: %s", contact.JID))
+	avatar := theme.AvatarBlock(contact.Name, contact.JID, 6, 3)
+	b.WriteString(lipgloss.JoinHorizontal(lipgloss.Top, "  ", avatar, "  ", ident.String()))
+	b.WriteString("\n\n")
 
 	// Status with icon and friendly text
-	var statusIcon, statusText string
-	switch contact.Status {
-	case "online":
-		statusIcon = m.styles.PresenceOnline.Render("●")
-		statusText = "Online"
-	case "away":
-		statusIcon = m.styles.PresenceAway.Render("◐")
-		statusText = "Away"
-	case "dnd":
-		statusIcon = m.styles.PresenceDND.Render("⊘")
-		statusText = "Do Not Disturb"
-	case "xa":
-		statusIcon = m.styles.PresenceXA.Render("◯")
-		statusText = "Extended Away"
-	default:
-		statusIcon = m.styles.PresenceOffline.Render("○")
-		statusText = "Offline"
-	}
+	statusIcon := m.styles.PresenceIcon(contact.Status)
+	statusText := theme.PresenceLabel(contact.Status)
 
 	b.WriteString(fmt.Sprintf("  Status: %s %s\n", statusIcon, statusText))
 
@@ -1244,7 +1882,7 @@ func (m Model) RenderContactDetails(contact ContactDetailData) string {
 	// For offline contacts, show last seen or indicate status not shared
 	if contact.Status == "offline" {
 		if !contact.LastSeen.IsZero() {
-			lastSeenStr := contact.LastSeen.Format("2006-01-02 15:04")
+			lastSeenStr := m.timeFmt.When(contact.LastSeen, time.Now())
 			b.WriteString(fmt.Sprintf("  Last seen: %s\n", lastSeenStr))
 		} else {
 			// Contact is offline and we have no last seen info - status not shared
@@ -1252,6 +1890,12 @@ func (m Model) RenderContactDetails(contact ContactDetailData) string {
 		}
 	}
 
+	if contact.Software != "" {
+		b.WriteString(fmt.Sprintf("  Client: %s\n", contact.Software))
+	} else {
+		b.WriteString(m.styles.ChatSystem.Render("  Client: (ask with :version)") + "\n")
+	}
+
 	b.WriteString("\n")
 
 	// Groups
@@ -1287,6 +1931,21 @@ func (m Model) RenderContactDetails(contact ContactDetailData) string {
 	}
 	b.WriteString(fmt.Sprintf("  Status sharing: %s\n", sharingStr))
 
+	savingStr := "[OFF]"
+	if contact.SavingEnabled {
+		savingStr = m.styles.PresenceOnline.Render("[ON]")
+	}
+	savingMode := contact.Saving
+	if savingMode == "" {
+		savingMode = "default"
+	}
+	b.WriteString(fmt.Sprintf("  Message saving: %s (%s, change with :logging)\n", savingStr, savingMode))
+	if contact.Expiry != "" {
+		b.WriteString(fmt.Sprintf("  Disappearing messages: after %s (local copies only)\n", contact.Expiry))
+	} else {
+		b.WriteString("  Disappearing messages: [OFF] (set with :expire)\n")
+	}
+
 	// Your presence for this roster entry
 	if contact.MyPresence != "" {
 		presenceStr := contact.MyPresence
@@ -1300,6 +1959,40 @@ func (m Model) RenderContactDetails(contact ContactDetailData) string {
 
 	b.WriteString("\n")
 
+	// Availability history
+	if len(contact.Activity24h) > 0 {
+		b.WriteString("  ── Activity ──\n")
+		b.WriteString(fmt.Sprintf("  24h: %s\n", m.styles.PresenceOnline.Render(sparkline(contact.Activity24h))))
+		b.WriteString(fmt.Sprintf("  7d:  %s\n", m.styles.PresenceOnline.Render(sparkline(contact.Activity7d))))
+		if contact.UsualHours != "" {
+			b.WriteString(fmt.Sprintf("  Usually online %s\n", contact.UsualHours))
+		}
+		b.WriteString("\n")
+	}
+
+	// Online clients, with the last ping of each
+	if len(contact.Resources) > 0 {
+		b.WriteString("  ── Online clients ──\n")
+		for _, r := range contact.Resources {
+			line := fmt.Sprintf("  %s (priority %d", r.Resource, r.Priority)
+			if r.Client != "" {
+				line += ", " + r.Client
+			}
+			line += ")"
+			if r.Show != "" {
+				line += " " + theme.PresenceLabel(r.Show)
+			}
+			if r.Ping != "" {
+				line += " · ping: " + r.Ping
+			}
+			if r.Locked {
+				line += " · " + m.styles.PresenceAway.Render("messages locked here")
+			}
+			b.WriteString(line + "\n")
+		}
+		b.WriteString("\n")
+	}
+
 	// Encryption section
 	b.WriteString("  ── Encryption ──\n")
 	if contact.OMEMOEnabled {
@@ -1340,7 +2033,7 @@ func (m Model) RenderContactDetails(contact ContactDetailData) string {
 	// Actions hint at bottom
 	b.WriteString(strings.Repeat("─", m.width-2))
 	b.WriteString("\n")
-	actions := "  [E] Edit  [Enter] Chat  [F] Favorite  [S] Toggle Sharing  [V] Verify"
+	actions := "  [E] Edit  [Enter] Chat  [F] Favorite  [S] Toggle Sharing  [V] Verify  [P] Ping"
 	if !contact.AddedToRoster {
 		actions += "  [A] Add to roster"
 	}
@@ -1351,6 +2044,24 @@ func (m Model) RenderContactDetails(contact ContactDetailData) string {
 	return b.String()
 }
 
+// sparkline renders values in [0, 1] as a row of block characters
+func sparkline(values []float64) string {
+	blocks := []rune("▁▂▃▄▅▆▇█")
+	var b strings.Builder
+	for _, v := range values {
+		if v <= 0 {
+			b.WriteRune(' ')
+			continue
+		}
+		idx := int(v * float64(len(blocks)-1))
+		if idx >= len(blocks) {
+			idx = len(blocks) - 1
+		}
+		b.WriteRune(blocks[idx])
+	}
+	return b.String()
+}
+
 // AccountEditData holds data for editing an account
 type AccountEditData struct {
 	JID           string
@@ -0,0 +1,176 @@
+package chat
+
+import (
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-runewidth"
+)
+
+// Chat layouts
+const (
+	LayoutCompact = "compact" // IRC-style, one "time nick: body" line per message
+	LayoutCozy    = "cozy"    // sender and time above each run of messages, blank lines between senders
+	LayoutBubble  = "bubble"  // incoming messages on the left, outgoing on the right
+)
+
+// Layouts lists the chat layouts in the order :layout cycles through them
+var Layouts = []string{LayoutCompact, LayoutCozy, LayoutBubble}
+
+// groupGap is how far apart two messages from the same sender may be and
+// still be shown as one run in the cozy and bubble layouts
+const groupGap = 5 * time.Minute
+
+// cozyIndent indents message bodies under the sender line in the cozy layout
+const cozyIndent = 2
+
+// SetLayout sets the chat layout. Unknown layouts render as compact.
+func (m Model) SetLayout(layout string) Model {
+	m.layout = layout
+	return m
+}
+
+// Layout returns the chat layout in use
+func (m Model) Layout() string {
+	for _, l := range Layouts {
+		if l == m.layout {
+			return l
+		}
+	}
+	return LayoutCompact
+}
+
+// NextLayout returns the layout after the given one in Layouts
+func NextLayout(layout string) string {
+	for i, l := range Layouts {
+		if l == layout {
+			return Layouts[(i+1)%len(Layouts)]
+		}
+	}
+	return Layouts[0]
+}
+
+// renderLayout renders a message in the current layout. prev is the
+// message shown right above it, nil at the top of the view.
+func (m Model) renderLayout(msg Message, prev *Message) []string {
+	switch m.Layout() {
+	case LayoutCozy:
+		return m.renderCozyMessage(msg, prev)
+	case LayoutBubble:
+		return m.renderBubbleMessage(msg, prev)
+	default:
+		return m.renderMessage(msg)
+	}
+}
+
+// sameGroup reports whether msg continues the run of messages prev is in
+func sameGroup(msg Message, prev *Message) bool {
+	return prev != nil && prev.Type != "system" && msg.Type != "system" &&
+		prev.Outgoing == msg.Outgoing && prev.From == msg.From &&
+		msg.Timestamp.Sub(prev.Timestamp) < groupGap
+}
+
+// isPlainText reports whether a message is text the cozy and bubble layouts
+// rearrange. System notices, JSON payloads and files keep their compact
+// rendering.
+func isPlainText(msg Message) bool {
+	if msg.Type == "system" || isFileMessage(msg) {
+		return false
+	}
+	_, isJSON := messageJSON(msg)
+	return !isJSON
+}
+
+// renderCozyMessage renders a message below a "nick  time" line that starts
+// each run of messages from one sender
+func (m Model) renderCozyMessage(msg Message, prev *Message) []string {
+	grouped := sameGroup(msg, prev)
+	var lines []string
+	if prev != nil && !grouped {
+		lines = append(lines, "")
+	}
+	if !isPlainText(msg) {
+		return append(lines, m.renderMessage(msg)...)
+	}
+	if !grouped || !isPlainText(*prev) {
+		timestamp := m.styles.ChatTimestamp.Render(m.timeFmt.Stamp(msg.Timestamp, time.Now()))
+		lines = append(lines, m.styles.ChatNick.Render(senderNick(msg))+"  "+timestamp)
+	}
+
+	first, rest, indent := m.bodyLayout(cozyIndent)
+	padding := strings.Repeat(" ", cozyIndent)
+	continuation := strings.Repeat(" ", indent)
+	bodyStyle := m.bodyStyle(msg)
+	for i, line := range wrapText(msg.Body, first, rest) {
+		if i == 0 {
+			lines = append(lines, padding+bodyStyle.Render(line)+m.editedMarker(msg)+m.messageStatus(msg))
+		} else {
+			lines = append(lines, continuation+bodyStyle.Render(line))
+		}
+	}
+	if reactions := m.reactionsText(msg); reactions != "" {
+		lines = append(lines, padding+reactions)
+	}
+	return lines
+}
+
+// renderBubbleMessage renders a message as a block in the message colours,
+// incoming ones on the left under the sender's nick and outgoing ones on
+// the right
+func (m Model) renderBubbleMessage(msg Message, prev *Message) []string {
+	grouped := sameGroup(msg, prev)
+	var lines []string
+	if prev != nil && !grouped {
+		lines = append(lines, "")
+	}
+	if !isPlainText(msg) {
+		return append(lines, m.renderMessage(msg)...)
+	}
+	if !msg.Outgoing && (!grouped || !isPlainText(*prev)) {
+		lines = append(lines, m.styles.ChatNick.Render(senderNick(msg)))
+	}
+
+	avail := m.width - 2
+	meta := m.styles.ChatTimestamp.Render(m.timeFmt.Stamp(msg.Timestamp, time.Now())) +
+		m.messageStatus(msg) + m.editedMarker(msg)
+
+	// The bubble takes up to two thirds of the pane, leaving room for the
+	// time next to it and a space of padding on each side
+	width := min(avail*2/3, avail-lipgloss.Width(meta)-3)
+	if m.maxBodyWidth > 0 {
+		width = min(width, m.maxBodyWidth)
+	}
+	width = max(width, minBodyWidth)
+
+	wrapped := wrapText(msg.Body, width, width)
+	inner := 0
+	for _, line := range wrapped {
+		inner = max(inner, runewidth.StringWidth(line))
+	}
+
+	bodyStyle := m.bodyStyle(msg)
+	for i, line := range wrapped {
+		bubble := bodyStyle.Render(" " + line + strings.Repeat(" ", inner-runewidth.StringWidth(line)) + " ")
+		if i == 0 {
+			if msg.Outgoing {
+				bubble = meta + " " + bubble
+			} else {
+				bubble += " " + meta
+			}
+		}
+		lines = append(lines, alignBubble(bubble, msg.Outgoing, avail))
+	}
+	if reactions := m.reactionsText(msg); reactions != "" {
+		lines = append(lines, alignBubble(reactions, msg.Outgoing, avail))
+	}
+	return lines
+}
+
+// alignBubble right-aligns a line of an outgoing message within width
+func alignBubble(line string, outgoing bool, width int) string {
+	if !outgoing {
+		return line
+	}
+	return strings.Repeat(" ", max(width-lipgloss.Width(line), 0)) + line
+}
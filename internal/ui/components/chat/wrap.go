@@ -0,0 +1,120 @@
+package chat
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-runewidth"
+)
+
+// Wrap modes for message bodies longer than a line
+const (
+	WrapIndent = "indent" // continuation lines start under the message body
+	WrapFlush  = "flush"  // continuation lines start at the left edge
+)
+
+// minBodyWidth keeps wrapping usable in very narrow panes
+const minBodyWidth = 10
+
+// SetWrap sets how message bodies wrap: the wrap mode, the maximum width
+// of a body in columns (0 for the whole pane) and the width nicks are
+// aligned to (0 to leave them unaligned)
+func (m Model) SetWrap(mode string, maxWidth, nickColumn int) Model {
+	m.wrapMode = mode
+	m.maxBodyWidth = maxWidth
+	m.nickColumn = nickColumn
+	return m
+}
+
+// bodyLayout returns the widths of the first and following lines of a
+// message body whose first line follows a prefix of the given width, and
+// how far following lines are indented
+func (m Model) bodyLayout(prefixWidth int) (first, rest, indent int) {
+	avail := m.width - 2
+	if m.wrapMode != WrapFlush {
+		indent = prefixWidth
+	}
+	first = avail - prefixWidth
+	rest = avail - indent
+	if m.maxBodyWidth > 0 {
+		first = min(first, m.maxBodyWidth)
+		rest = min(rest, m.maxBodyWidth)
+	}
+	return max(first, minBodyWidth), max(rest, minBodyWidth), indent
+}
+
+// prefixWidth returns the display width of the "timestamp nick: " prefix
+// in front of a message body
+func (m Model) prefixWidth(timestamp, nickStr string) int {
+	return lipgloss.Width(timestamp) + 1 + lipgloss.Width(nickStr) + 2
+}
+
+// alignNick fits a nick into the nick column: shorter nicks are padded on
+// the left so the separators line up, longer ones are truncated
+func alignNick(nick string, column int) string {
+	if column <= 0 {
+		return nick
+	}
+	width := runewidth.StringWidth(nick)
+	if width > column {
+		return runewidth.Truncate(nick, column, "…")
+	}
+	return strings.Repeat(" ", column-width) + nick
+}
+
+// wordWrap wraps text to the specified width
+func wordWrap(text string, width int) []string {
+	return wrapText(text, width, width)
+}
+
+// wrapText wraps text so the first line is at most first columns wide and
+// the following lines at most rest. Widths are display widths, so wide
+// characters and emoji count as two columns. Line breaks in the text are
+// kept, and words longer than a line are split.
+func wrapText(text string, first, rest int) []string {
+	if first <= 0 || rest <= 0 {
+		return []string{text}
+	}
+
+	var lines []string
+	var line strings.Builder
+	lineWidth := 0
+	limit := first
+	flush := func() {
+		lines = append(lines, line.String())
+		line.Reset()
+		lineWidth = 0
+		limit = rest
+	}
+
+	for _, paragraph := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		for _, word := range strings.Fields(paragraph) {
+			wordWidth := runewidth.StringWidth(word)
+			if lineWidth > 0 && lineWidth+1+wordWidth > limit {
+				flush()
+			}
+			if lineWidth > 0 {
+				line.WriteByte(' ')
+				lineWidth++
+			}
+			// Split words that do not fit on a line of their own
+			for lineWidth+wordWidth > limit {
+				head := runewidth.Truncate(word, limit-lineWidth, "")
+				if head == "" {
+					// A single character wider than the line
+					_, size := utf8.DecodeRuneInString(word)
+					head = word[:size]
+				}
+				line.WriteString(head)
+				word = word[len(head):]
+				wordWidth = runewidth.StringWidth(word)
+				flush()
+			}
+			line.WriteString(word)
+			lineWidth += wordWidth
+		}
+		flush()
+	}
+	return lines
+}
@@ -23,6 +23,12 @@ type Command struct {
 	Description string
 	Args        []string
 	Handler     func(args []string) tea.Cmd
+	Help        string // longer text for :help <name>
+	Plugin      string // the plugin that added the command, "" for built-ins
+
+	// Complete returns candidates for the last argument, given the
+	// arguments typed so far
+	Complete func(args []string) []string
 }
 
 // Model represents the command line component
@@ -64,13 +70,16 @@ func (m *Model) registerDefaultCommands() {
 		{Name: "q", Description: "Quit the application (alias)", Args: []string{}},
 
 		// Account management
-		{Name: "account", Description: "Manage accounts: list, add, remove, edit, default", Args: []string{"subcommand", "[args...]"}},
+		{Name: "account", Description: "Manage accounts: list, add, remove, edit, default, encrypt, decrypt, unlock", Args: []string{"subcommand", "[args...]"}},
 		{Name: "connect", Description: "Connect to an account (prompts for password if needed)", Args: []string{"[jid]"}},
 		{Name: "disconnect", Description: "Disconnect from current account", Args: []string{}},
+		{Name: "doctor", Description: "Diagnose why an account does not work (DNS, TLS, SASL, server features, clock)", Args: []string{"[jid]"}},
 
 		// Settings
 		{Name: "set", Description: "View or change settings: theme, roster_width, notifications, etc.", Args: []string{"[setting]", "[value]"}},
 		{Name: "settings", Description: "Open settings menu", Args: []string{}},
+		{Name: "layout", Description: "Switch the chat layout: compact, cozy, bubble or theme (cycles without an argument)", Args: []string{"[layout]"}},
+		{Name: "group", Description: "Group the roster by roster group or server domain, or show it flat (cycles without an argument)", Args: []string{"[none|groups|domain]"}},
 
 		// Contacts
 		{Name: "add", Description: "Add to roster", Args: []string{"jid", "[name]"}},
@@ -83,9 +92,37 @@ func (m *Model) registerDefaultCommands() {
 		{Name: "msg", Description: "Send a message to a JID", Args: []string{"jid", "message"}},
 		{Name: "clear", Description: "Clear current chat history", Args: []string{}},
 		{Name: "close", Description: "Close current chat window", Args: []string{}},
+		{Name: "snippets", Description: "Manage canned responses (type ;name to insert)", Args: []string{}},
+		{Name: "queue", Description: "Show pending outgoing messages", Args: []string{}},
+		{Name: "participants", Description: "Show room occupants and manage the ignore list", Args: []string{}},
+		{Name: "notify", Description: "Notifications for this room: all, mentions or none", Args: []string{"[mode]"}},
+		{Name: "history", Description: "Stored messages loaded when this room opens (a number or default)", Args: []string{"[size]"}},
+		{Name: "sound", Description: "Notification sound for this chat (bell, bell:N, none, exec:cmd, default)", Args: []string{"[sound]"}},
+		{Name: "decline", Description: "Decline the incoming call in this chat"},
+		{Name: "logging", Description: "Save messages of this chat to disk (always, never, default)", Args: []string{"[mode]"}},
+		{Name: "expire", Description: "Delete local copies of this chat's messages after a time (12h, 7d, off)", Args: []string{"[duration]"}},
+		{Name: "preview", Description: "Link previews for this chat or account (on, off, account on|off)", Args: []string{"[account] [on|off]"}},
+		{Name: "export", Description: "Export this chat or room as Markdown, one file per month", Args: []string{"[markdown]", "[dir]"}},
+		{Name: "db", Description: "Show the message database file, its schema version and whether it had to be rebuilt at startup", Args: []string{}},
+		{Name: "search", Description: "Search stored chat and room history; filter with from:nick, in:room, after: and before: (2024-05-01 or 30d)", Args: []string{"<query>"}},
+		{Name: "starred", Description: "List starred messages of all conversations, to open one in context or unstar it", Args: []string{}},
+		{Name: "archive", Description: "Show or set what the server archives of the account (XEP-0441)", Args: []string{"[always|roster|never]"}},
+		{Name: "privacy", Description: "Show what the account shares (receipts, read markers, typing, idle time, archive, ...) and switch each on or off", Args: []string{}},
+		{Name: "stats", Description: "Show the account's stanza and byte counts, reconnects, uptime and latency, or reset them", Args: []string{"[reset]"}},
+		{Name: "ping", Description: "Ping a contact's client, or all its online clients, and show the latency (XEP-0199)", Args: []string{"[jid[/resource]]"}},
+		{Name: "version", Description: "Ask a contact which client it runs (XEP-0092)", Args: []string{"[jid]"}},
+		{Name: "conflicts", Description: "Resolve offline roster changes that clash with another client's", Args: []string{}},
+		{Name: "requests", Description: "List subscription requests and messages from people not in the roster", Args: []string{}},
+		{Name: "accept", Description: "Accept a contact request and add the sender to the roster", Args: []string{"[jid]"}},
+		{Name: "reject", Description: "Reject a contact request (declines a subscription request)", Args: []string{"[jid]"}},
+		{Name: "block", Description: "Block a contact request or any JID, locally and on the server", Args: []string{"[jid]"}},
+		{Name: "unblock", Description: "Unblock a JID, or list the blocked JIDs", Args: []string{"[jid]"}},
+		{Name: "voice", Description: "Ask for voice in a moderated room, or answer visitors' voice requests", Args: []string{}},
+		{Name: "bulk", Description: "Act on the contacts marked with space or V: group, tag, remove, mute, unmute or export", Args: []string{"action", "[groups]"}},
+		{Name: "schedule", Description: "Send a message later (+30m or 18:30)", Args: []string{"jid", "time", "message"}},
 
 		// Status
-		{Name: "status", Description: "Set your status (online, away, dnd, xa, offline)", Args: []string{"status", "[message]"}},
+		{Name: "status", Description: "Set your status (online, chat, away, dnd, xa, offline)", Args: []string{"status", "[message]"}},
 		{Name: "away", Description: "Set away status with optional message", Args: []string{"[message]"}},
 		{Name: "dnd", Description: "Set do-not-disturb status", Args: []string{"[message]"}},
 		{Name: "xa", Description: "Set extended away status", Args: []string{"[message]"}},
@@ -94,6 +131,9 @@ func (m *Model) registerDefaultCommands() {
 
 		// MUC (Multi-User Chat)
 		{Name: "join", Description: "Join a MUC room", Args: []string{"room@server", "[nick]"}},
+		{Name: "open", Description: "Open an xmpp: link: a chat, a room to join or a contact to add", Args: []string{"xmpp:uri"}},
+		{Name: "link", Description: "Copy an invite link for the current room, a room JID, or yourself (me)", Args: []string{"[room|me]"}},
+		{Name: "rooms", Description: "Browse and filter the public rooms of a MUC service, then join one", Args: []string{"[service]"}},
 		{Name: "leave", Description: "Leave current room", Args: []string{}},
 		{Name: "invite", Description: "Invite someone to current room", Args: []string{"jid"}},
 		{Name: "kick", Description: "Kick user from room (moderator)", Args: []string{"nick", "[reason]"}},
@@ -105,18 +145,21 @@ func (m *Model) registerDefaultCommands() {
 		{Name: "bookmark", Description: "Manage room bookmarks: list, add, remove", Args: []string{"subcommand", "[args...]"}},
 
 		// Encryption
-		{Name: "omemo", Description: "OMEMO encryption: status, enable, disable, fingerprints", Args: []string{"subcommand", "[args...]"}},
+		{Name: "omemo", Description: "OMEMO encryption: enable, disable or default for this chat, export, import, log", Args: []string{"subcommand", "[args...]"}},
 		{Name: "fingerprint", Description: "Show OMEMO fingerprints for roster entry", Args: []string{"[jid]"}},
 		{Name: "trust", Description: "Trust an OMEMO fingerprint", Args: []string{"jid", "fingerprint"}},
 		{Name: "untrust", Description: "Untrust an OMEMO fingerprint", Args: []string{"jid", "fingerprint"}},
+		{Name: "security", Description: "Show OMEMO security events (new devices, trust changes)", Args: []string{}},
 
 		// Windows
 		{Name: "window", Description: "Switch to window by number (1-20)", Args: []string{"number"}},
 		{Name: "win", Description: "Switch to window (alias)", Args: []string{"number"}},
+		{Name: "title", Description: "Rename the active window (no name resets it)", Args: []string{"[name]"}},
 
 		// Plugins
-		{Name: "plugins", Description: "List installed plugins", Args: []string{}},
-		{Name: "plugin", Description: "Manage plugins: enable, disable, info", Args: []string{"subcommand", "name"}},
+		{Name: "plugins", Description: "List plugins from the plugin index and which are installed", Args: []string{}},
+		{Name: "statusbar", Description: "List plugin status bar items, or activate one", Args: []string{"[number|id]"}},
+		{Name: "plugin", Description: "Manage plugins: list, install, remove, enable, disable, perms (permissions)", Args: []string{"subcommand", "[name]"}},
 	}
 
 	for _, cmd := range commands {
@@ -313,8 +356,24 @@ func (m Model) getCompletions() []string {
 				completions = append(completions, name)
 			}
 		}
+		return completions
 	}
 
+	// Complete the last argument of commands that know how
+	cmd, ok := m.commands[parts[0]]
+	if !ok || cmd.Complete == nil {
+		return nil
+	}
+	args := parts[1:]
+	if strings.HasSuffix(m.input, " ") {
+		args = append(args, "")
+	}
+	prefix := args[len(args)-1]
+	for _, candidate := range cmd.Complete(args) {
+		if strings.HasPrefix(candidate, prefix) && !strings.ContainsAny(candidate, " \t") {
+			completions = append(completions, candidate)
+		}
+	}
 	return completions
 }
 
@@ -366,6 +425,11 @@ func (m *Model) RegisterCommand(cmd Command) {
 	m.commands[cmd.Name] = cmd
 }
 
+// UnregisterCommand removes a command
+func (m *Model) UnregisterCommand(name string) {
+	delete(m.commands, name)
+}
+
 // GetCommands returns all registered commands
 func (m Model) GetCommands() map[string]Command {
 	return m.commands
@@ -1,13 +1,18 @@
 package dialogs
 
 import (
+	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/meszmate/roster/internal/ui/components/commandline"
+	"github.com/meszmate/roster/internal/ui/keybindings"
 	"github.com/meszmate/roster/internal/ui/theme"
+	"github.com/meszmate/roster/internal/ui/timefmt"
 )
 
 // DialogType represents the type of dialog
@@ -45,6 +50,31 @@ const (
 	DialogUploadFile
 	DialogExportAccounts
 	DialogImportAccounts
+	DialogClientImport
+	DialogOMEMOExport
+	DialogOMEMOImport
+	DialogSecurityEvents
+	DialogSnippets
+	DialogSnippetAdd
+	DialogQueue
+	DialogQueueEdit
+	DialogParticipants
+	DialogOMEMOShortCode
+	DialogDiagnostics
+	DialogBrowseRooms
+	DialogBulkConfirm
+	DialogEncryptAccounts
+	DialogUnlockAccounts
+	DialogRosterConflict
+	DialogPluginConsent
+	DialogPlaintextConfirm
+	DialogAskVoice
+	DialogVoiceApproval
+	DialogQuickActions
+	DialogSearchResults
+	DialogStarred
+	DialogResendRoomMessage
+	DialogPrivacy
 )
 
 // DialogAction represents what action triggered the dialog result
@@ -55,6 +85,7 @@ const (
 	ActionCancel
 	ActionViewCaptcha
 	ActionCopyURL
+	ActionCopy // Values["text"] is to be copied, the dialog stays open
 )
 
 // OperationType identifies which async operation is in progress
@@ -69,6 +100,8 @@ const (
 	OpDisconnect     OperationType = "disconnect"
 	OpRegisterFetch  OperationType = "register_fetch"
 	OpRegisterSubmit OperationType = "register_submit"
+	OpDiagnostics    OperationType = "diagnostics"
+	OpBrowseRooms    OperationType = "browse_rooms"
 )
 
 // Spinner frames for loading animation
@@ -106,12 +139,18 @@ type Model struct {
 	width          int
 	height         int
 	styles         *theme.Styles
+	timeFmt        timefmt.Formatter
 	data           map[string]string
 
 	// Loading dialog state
 	spinnerFrame  int
 	operationType OperationType
 
+	// Error dialog: the short message and the technical details d shows
+	errorSummary string
+	errorDetails string
+	detailsShown bool
+
 	// Scroll state for help dialog
 	scrollOffset    int
 	maxVisibleLines int
@@ -123,6 +162,34 @@ type Model struct {
 	// Bookmarks
 	bookmarks        []BookmarkInfo
 	selectedBookmark int
+
+	// Snippets
+	snippets        []SnippetInfo
+	selectedSnippet int
+
+	// Outgoing queue
+	queue         []QueuedInfo
+	selectedQueue int
+
+	// Room occupants
+	participants        []ParticipantInfo
+	selectedParticipant int
+
+	// Public rooms of a MUC service, filtered by the filter input
+	rooms        []RoomEntry
+	selectedRoom int
+
+	// Quick actions menu
+	menu         []MenuItem
+	selectedItem int
+
+	// Messages listed by history search and starred messages
+	hits        []MessageHit
+	selectedHit int
+
+	// Privacy dashboard
+	privacy         []PrivacySetting
+	selectedPrivacy int
 }
 
 // OMEMODeviceInfo represents info about an OMEMO device
@@ -148,6 +215,7 @@ type DialogInput struct {
 	Value    string
 	Cursor   int
 	Password bool
+	Revealed bool // Password shown in clear text, toggled with ctrl+r
 	ReadOnly bool // If true, field is not editable (for display only)
 }
 
@@ -186,6 +254,42 @@ func (m Model) ShowError(message string) Model {
 	m.buttons = []string{"OK"}
 	m.activeBtn = 0
 	m.inputs = nil
+	m.errorSummary = ""
+	m.errorDetails = ""
+	m.detailsShown = false
+	return m
+}
+
+// ShowErrorDetails shows an error dialog with a short message, below which
+// d expands the technical details. Details that say no more than the
+// message are left out.
+func (m Model) ShowErrorDetails(title, message, details string) Model {
+	m = m.ShowErrorWithTitle(title, message)
+	if details == "" || strings.Contains(message, details) {
+		return m
+	}
+	m.errorSummary = message
+	m.errorDetails = details
+	m.message = message + "\n\n(press d for details)"
+	m.buttons = []string{"OK", "Copy details"}
+	return m
+}
+
+// toggleErrorDetails shows or hides the details of an error dialog
+func (m Model) toggleErrorDetails() Model {
+	m.detailsShown = !m.detailsShown
+	if m.detailsShown {
+		m.message = m.errorSummary + "\n\nDetails (d to hide):\n" + m.errorDetails
+	} else {
+		m.message = m.errorSummary + "\n\n(press d for details)"
+	}
+	return m
+}
+
+// ShowErrorWithTitle shows an error dialog under its own title
+func (m Model) ShowErrorWithTitle(title, message string) Model {
+	m = m.ShowError(message)
+	m.title = title
 	return m
 }
 
@@ -232,6 +336,17 @@ func (m Model) ShowAddContact() Model {
 	return m
 }
 
+// ShowAddContactFor shows the add contact dialog filled in for a JID, with
+// the cursor in the name field
+func (m Model) ShowAddContactFor(jid, name, group string) Model {
+	m = m.ShowAddContact()
+	m.inputs[0].Value, m.inputs[0].Cursor = jid, len(jid)
+	m.inputs[1].Value, m.inputs[1].Cursor = name, len(name)
+	m.inputs[2].Value, m.inputs[2].Cursor = group, len(group)
+	m.activeInput = 1
+	return m
+}
+
 // ShowJoinRoom shows the join room dialog
 func (m Model) ShowJoinRoom() Model {
 	m.dialogType = DialogJoinRoom
@@ -250,6 +365,17 @@ func (m Model) ShowJoinRoom() Model {
 	return m
 }
 
+// ShowJoinRoomFor shows the join room dialog for a known room, with the
+// cursor in the nickname field
+func (m Model) ShowJoinRoomFor(roomJID, nick, password string) Model {
+	m = m.ShowJoinRoom()
+	m.inputs[0].Value, m.inputs[0].Cursor = roomJID, len(roomJID)
+	m.inputs[1].Value, m.inputs[1].Cursor = nick, len(nick)
+	m.inputs[2].Value, m.inputs[2].Cursor = password, len(password)
+	m.activeInput = 1
+	return m
+}
+
 // ShowContactInfo shows contact info dialog
 func (m Model) ShowContactInfo(jid string) Model {
 	m.dialogType = DialogContactInfo
@@ -280,10 +406,26 @@ func (m Model) ShowOMEMODevices(jid string, devices []OMEMODeviceInfo) Model {
 	m.title = "OMEMO Devices - " + jid
 	m.omemoDevices = devices
 	m.selectedDevice = 0
-	m.buttons = []string{"Trust", "Verify", "Untrust", "Delete", "Close"}
-	m.activeBtn = 4
+	m.buttons = []string{"Trust", "Verify", "Short code", "Untrust", "Delete", "Close"}
+	m.activeBtn = 5
+	m.inputs = nil
+	m.data["jid"] = jid
+	return m
+}
+
+// ShowOMEMOShortCode shows the short authentication string of a device, to
+// be compared with the contact over a call or in person
+func (m Model) ShowOMEMOShortCode(jid string, deviceID uint32, emoji []string, numbers string) Model {
+	m.dialogType = DialogOMEMOShortCode
+	m.title = "Compare Short Code"
+	m.message = "Ask " + jid + " to open the short code for your device and read it out.\n\n" +
+		strings.Join(emoji, "  ") + "\n\nor the numbers:  " + numbers + "\n\n" +
+		"Both must match exactly. Only mark the device verified if they do."
+	m.buttons = []string{"They match", "They differ", "Cancel"}
+	m.activeBtn = 2
 	m.inputs = nil
 	m.data["jid"] = jid
+	m.data["device"] = strconv.FormatUint(uint64(deviceID), 10)
 	return m
 }
 
@@ -307,6 +449,55 @@ func (m Model) ShowBookmarks(bookmarks []BookmarkInfo) Model {
 	return m
 }
 
+// readOnly reports whether the dialog takes no typing
+func (m Model) readOnly() bool {
+	return len(m.inputs) == 0 && m.dialogType != DialogBrowseRooms
+}
+
+// CopyContent returns what copying in the dialog takes: the selected entry
+// of a list, such as a device's fingerprint, or else the dialog's text
+func (m Model) CopyContent() string {
+	switch m.dialogType {
+	case DialogError:
+		if m.errorDetails != "" {
+			return m.errorSummary + "\n\n" + m.errorDetails
+		}
+	case DialogOMEMODevices:
+		if dev, _, ok := m.GetSelectedOMEMODevice(); ok {
+			return dev.Fingerprint
+		}
+	case DialogBookmarks:
+		if bm, _, ok := m.GetSelectedBookmark(); ok {
+			return bm.RoomJID
+		}
+	case DialogSnippets:
+		if sn, ok := m.GetSelectedSnippet(); ok {
+			return sn.Text
+		}
+	case DialogQueue:
+		if qm, _, ok := m.GetSelectedQueued(); ok {
+			return qm.Body
+		}
+	case DialogParticipants:
+		if p, _, ok := m.GetSelectedParticipant(); ok {
+			return p.Nick
+		}
+	case DialogPrivacy:
+		if p, _, ok := m.GetSelectedPrivacy(); ok {
+			return p.Label + ": " + p.Detail
+		}
+	case DialogSearchResults, DialogStarred:
+		if hit, _, ok := m.GetSelectedHit(); ok {
+			return hit.Body
+		}
+	case DialogBrowseRooms:
+		if room, ok := m.GetSelectedRoom(); ok {
+			return room.JID
+		}
+	}
+	return strings.TrimSpace(m.message)
+}
+
 // GetSelectedBookmark returns the currently selected bookmark
 func (m Model) GetSelectedBookmark() (BookmarkInfo, int, bool) {
 	if len(m.bookmarks) == 0 || m.selectedBookmark >= len(m.bookmarks) {
@@ -315,6 +506,407 @@ func (m Model) GetSelectedBookmark() (BookmarkInfo, int, bool) {
 	return m.bookmarks[m.selectedBookmark], m.selectedBookmark, true
 }
 
+// SnippetInfo represents a canned response
+type SnippetInfo struct {
+	Name    string
+	Text    string
+	Account bool // Belongs to the account's own set rather than the global one
+}
+
+// ShowSnippets shows the snippets management dialog
+func (m Model) ShowSnippets(accountJID string, snippets []SnippetInfo) Model {
+	m.dialogType = DialogSnippets
+	m.title = "Snippets"
+	m.message = ""
+	m.snippets = snippets
+	m.selectedSnippet = 0
+	m.buttons = []string{"Add", "Delete", "Close"}
+	m.activeBtn = 0
+	m.inputs = nil
+	m.data["account"] = accountJID
+	return m
+}
+
+// GetSelectedSnippet returns the currently selected snippet
+func (m Model) GetSelectedSnippet() (SnippetInfo, bool) {
+	if len(m.snippets) == 0 || m.selectedSnippet >= len(m.snippets) {
+		return SnippetInfo{}, false
+	}
+	return m.snippets[m.selectedSnippet], true
+}
+
+// ShowSnippetAdd shows the dialog for adding a snippet
+func (m Model) ShowSnippetAdd(accountJID string) Model {
+	m.dialogType = DialogSnippetAdd
+	m.title = "Add Snippet"
+	m.message = "Type ;name in a message to insert the text."
+	m.inputs = []DialogInput{
+		{Label: "Name", Key: "name", Value: ""},
+		{Label: "Text", Key: "text", Value: ""},
+	}
+	m.checkboxes = nil
+	if accountJID != "" {
+		m.checkboxes = []DialogCheckbox{
+			{Label: "Only for " + accountJID, Key: "account_only", Checked: false},
+		}
+	}
+	m.activeInput = 0
+	m.activeCheckbox = 0
+	m.inCheckboxes = false
+	m.buttons = []string{"Save", "Cancel"}
+	m.activeBtn = 0
+	m.data["account"] = accountJID
+	return m
+}
+
+// QueuedInfo represents a pending outgoing message
+type QueuedInfo struct {
+	ID         string
+	AccountJID string
+	To         string
+	Body       string
+	State      string // queued, scheduled, retrying, sending
+	SendAt     time.Time
+	Attempts   int
+	LastError  string
+}
+
+// ShowQueue shows the outgoing message queue
+func (m Model) ShowQueue(queue []QueuedInfo, selected int) Model {
+	m.dialogType = DialogQueue
+	m.title = "Outgoing Queue"
+	m.message = ""
+	m.queue = queue
+	m.selectedQueue = selected
+	if m.selectedQueue >= len(queue) {
+		m.selectedQueue = len(queue) - 1
+	}
+	if m.selectedQueue < 0 {
+		m.selectedQueue = 0
+	}
+	m.buttons = []string{"Send Now", "Edit", "Delete", "Close"}
+	m.activeBtn = 0
+	m.inputs = nil
+	m.checkboxes = nil
+	return m
+}
+
+// GetSelectedQueued returns the currently selected pending message
+func (m Model) GetSelectedQueued() (QueuedInfo, int, bool) {
+	if len(m.queue) == 0 || m.selectedQueue >= len(m.queue) {
+		return QueuedInfo{}, 0, false
+	}
+	return m.queue[m.selectedQueue], m.selectedQueue, true
+}
+
+// ShowQueueEdit shows the dialog for editing a pending message
+func (m Model) ShowQueueEdit(item QueuedInfo) Model {
+	m.dialogType = DialogQueueEdit
+	m.title = "Edit Queued Message"
+	m.message = "To: " + item.To
+	m.inputs = []DialogInput{
+		{Label: "Message", Key: "body", Value: item.Body, Cursor: len(item.Body)},
+	}
+	m.checkboxes = nil
+	m.activeInput = 0
+	m.buttons = []string{"Save", "Cancel"}
+	m.activeBtn = 0
+	m.data["id"] = item.ID
+	return m
+}
+
+// ParticipantInfo represents a room occupant
+type ParticipantInfo struct {
+	Nick    string
+	Ignored bool
+}
+
+// ShowParticipants shows the occupants of a room with their ignore state
+func (m Model) ShowParticipants(accountJID, roomJID string, participants []ParticipantInfo, selected int) Model {
+	m.dialogType = DialogParticipants
+	m.title = "Participants: " + roomJID
+	m.message = ""
+	m.participants = participants
+	m.selectedParticipant = selected
+	if m.selectedParticipant >= len(participants) {
+		m.selectedParticipant = len(participants) - 1
+	}
+	if m.selectedParticipant < 0 {
+		m.selectedParticipant = 0
+	}
+	m.buttons = []string{"Ignore/Unignore", "Close"}
+	m.activeBtn = 0
+	m.inputs = nil
+	m.checkboxes = nil
+	m.data["account"] = accountJID
+	m.data["room"] = roomJID
+	return m
+}
+
+// GetSelectedParticipant returns the currently selected occupant
+func (m Model) GetSelectedParticipant() (ParticipantInfo, int, bool) {
+	if len(m.participants) == 0 || m.selectedParticipant >= len(m.participants) {
+		return ParticipantInfo{}, 0, false
+	}
+	return m.participants[m.selectedParticipant], m.selectedParticipant, true
+}
+
+// MenuItem is one entry of the quick actions menu
+type MenuItem struct {
+	ID    string
+	Label string
+	Key   string // the key that does the same outside the menu, "" for none
+}
+
+// ShowQuickActions shows a menu of what can be done with a contact
+func (m Model) ShowQuickActions(jid string, items []MenuItem) Model {
+	m.dialogType = DialogQuickActions
+	m.title = jid
+	m.message = ""
+	m.menu = items
+	m.selectedItem = 0
+	m.buttons = []string{"Select", "Close"}
+	m.activeBtn = 0
+	m.inputs = nil
+	m.checkboxes = nil
+	m.data["jid"] = jid
+	return m
+}
+
+// GetSelectedMenuItem returns the currently selected quick action
+func (m Model) GetSelectedMenuItem() (MenuItem, bool) {
+	if len(m.menu) == 0 || m.selectedItem >= len(m.menu) {
+		return MenuItem{}, false
+	}
+	return m.menu[m.selectedItem], true
+}
+
+// PrivacySetting is one line of the privacy dashboard
+type PrivacySetting struct {
+	ID     string
+	Label  string
+	On     bool
+	State  string // shown instead of on or off when set
+	Detail string // what is shared and with whom
+	Fixed  bool   // cannot be switched
+}
+
+// ShowPrivacy shows what an account shares, to switch each item on or off.
+// selected is kept in range.
+func (m Model) ShowPrivacy(accountJID string, settings []PrivacySetting, selected int) Model {
+	m.dialogType = DialogPrivacy
+	m.title = "Privacy: " + accountJID
+	m.message = ""
+	m.privacy = settings
+	m.selectedPrivacy = max(min(selected, len(settings)-1), 0)
+	m.buttons = []string{"Switch", "Close"}
+	m.activeBtn = 0
+	m.inputs = nil
+	m.checkboxes = nil
+	m.data["account"] = accountJID
+	return m
+}
+
+// GetSelectedPrivacy returns the privacy dashboard line selected, with its
+// index
+func (m Model) GetSelectedPrivacy() (PrivacySetting, int, bool) {
+	if m.selectedPrivacy < 0 || m.selectedPrivacy >= len(m.privacy) {
+		return PrivacySetting{}, 0, false
+	}
+	return m.privacy[m.selectedPrivacy], m.selectedPrivacy, true
+}
+
+// PrivacyAccount returns the account whose privacy dashboard is shown, ""
+// when it is not
+func (m Model) PrivacyAccount() string {
+	if m.dialogType != DialogPrivacy {
+		return ""
+	}
+	return m.data["account"]
+}
+
+// renderPrivacy renders the privacy dashboard, the selected line with what
+// it shares below it
+func (m Model) renderPrivacy() string {
+	var b strings.Builder
+	for i, p := range m.privacy {
+		prefix := "  "
+		if i == m.selectedPrivacy {
+			prefix = "> "
+		}
+		box := "[ ] "
+		switch {
+		case p.Fixed:
+			box = "[-] "
+		case p.On:
+			box = "[x] "
+		}
+		line := prefix + box + p.Label
+		if p.State != "" {
+			line += ": " + p.State
+		}
+		b.WriteString(m.styles.DialogContent.Render(line))
+		b.WriteString("\n")
+		if i == m.selectedPrivacy && p.Detail != "" {
+			b.WriteString(m.styles.DialogContent.Foreground(lipgloss.Color("242")).Render("      " + p.Detail))
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// MessageHit is a message listed by history search or among the starred
+type MessageHit struct {
+	JID       string // the conversation
+	Room      bool
+	ID        string
+	Who       string
+	Body      string
+	Timestamp time.Time
+}
+
+// maxHitRows limits how many listed messages are shown at once
+const maxHitRows = 10
+
+// ShowSearchResults shows the messages a history search found, newest
+// first. Confirming opens the conversation of the selected one.
+func (m Model) ShowSearchResults(accountJID, query string, hits []MessageHit) Model {
+	m.dialogType = DialogSearchResults
+	m.title = "Search: " + query
+	m.message = ""
+	m.hits = hits
+	m.selectedHit = 0
+	m.buttons = []string{"Open", "Close"}
+	m.activeBtn = 0
+	m.inputs = nil
+	m.checkboxes = nil
+	m.data["account"] = accountJID
+	return m
+}
+
+// ShowStarred shows the starred messages, most recently starred first,
+// to open in their conversation or to unstar. selected is kept in range.
+func (m Model) ShowStarred(accountJID string, hits []MessageHit, selected int) Model {
+	m.dialogType = DialogStarred
+	m.title = "Starred Messages"
+	m.message = ""
+	m.hits = hits
+	m.selectedHit = max(min(selected, len(hits)-1), 0)
+	m.buttons = []string{"Open", "Unstar", "Close"}
+	m.activeBtn = 0
+	m.inputs = nil
+	m.checkboxes = nil
+	m.data["account"] = accountJID
+	return m
+}
+
+// GetSelectedHit returns the message selected in the search results or
+// starred messages, with its index
+func (m Model) GetSelectedHit() (MessageHit, int, bool) {
+	if m.selectedHit < 0 || m.selectedHit >= len(m.hits) {
+		return MessageHit{}, 0, false
+	}
+	return m.hits[m.selectedHit], m.selectedHit, true
+}
+
+// renderHits renders the listed messages around the selection, each with
+// its day, conversation and sender above the start of its body
+func (m Model) renderHits() string {
+	var b strings.Builder
+	start := 0
+	if m.selectedHit >= maxHitRows {
+		start = m.selectedHit - maxHitRows + 1
+	}
+	end := min(start+maxHitRows, len(m.hits))
+	for i := start; i < end; i++ {
+		h := m.hits[i]
+		prefix := "  "
+		if i == m.selectedHit {
+			prefix = "> "
+		}
+		line := prefix + m.timeFmt.Date(h.Timestamp) + " " + truncateRunes(h.JID, 24)
+		if h.Room {
+			line += " <" + truncateRunes(h.Who, 16) + ">"
+		} else if h.Who == "me" {
+			line += " (me)"
+		}
+		b.WriteString(m.styles.DialogContent.Render(line))
+		b.WriteString("\n")
+		b.WriteString(m.styles.DialogContent.Render("   " + truncateRunes(strings.Join(strings.Fields(h.Body), " "), 48)))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// RoomEntry is a public room listed in the browse rooms dialog
+type RoomEntry struct {
+	JID         string
+	Name        string
+	Description string
+	Occupants   int // -1 when unknown
+	Locked      bool
+}
+
+// maxRoomRows limits how many rooms the browse rooms dialog shows at once
+const maxRoomRows = 10
+
+// ShowBrowseRooms shows the public rooms of a MUC service. Typing filters
+// them and confirming joins the selected one.
+func (m Model) ShowBrowseRooms(service string, rooms []RoomEntry) Model {
+	m.dialogType = DialogBrowseRooms
+	m.title = "Rooms on " + service
+	m.message = ""
+	m.rooms = rooms
+	m.selectedRoom = 0
+	m.inputs = []DialogInput{
+		{Label: "Filter", Key: "filter", Value: ""},
+	}
+	m.checkboxes = nil
+	m.inCheckboxes = false
+	m.activeInput = 0
+	m.buttons = []string{"Join", "Cancel"}
+	m.activeBtn = 0
+	m.data["service"] = service
+	m.data["room"] = ""
+	return m
+}
+
+// filteredRooms returns the rooms matching the filter input by name, JID
+// or description
+func (m Model) filteredRooms() []RoomEntry {
+	if len(m.inputs) == 0 || m.inputs[0].Value == "" {
+		return m.rooms
+	}
+	query := strings.ToLower(m.inputs[0].Value)
+	var matches []RoomEntry
+	for _, r := range m.rooms {
+		if strings.Contains(strings.ToLower(r.Name), query) ||
+			strings.Contains(strings.ToLower(r.JID), query) ||
+			strings.Contains(strings.ToLower(r.Description), query) {
+			matches = append(matches, r)
+		}
+	}
+	return matches
+}
+
+// truncateRunes shortens s to at most n characters, marking the cut with …
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// GetSelectedRoom returns the room selected in the browse rooms dialog
+func (m Model) GetSelectedRoom() (RoomEntry, bool) {
+	rooms := m.filteredRooms()
+	if m.selectedRoom < 0 || m.selectedRoom >= len(rooms) {
+		return RoomEntry{}, false
+	}
+	return rooms[m.selectedRoom], true
+}
+
 // ShowSetStatus shows status setting dialog
 func (m Model) ShowSetStatus(currentStatus, currentMsg string) Model {
 	m.dialogType = DialogSetStatus
@@ -323,7 +915,7 @@ func (m Model) ShowSetStatus(currentStatus, currentMsg string) Model {
 		{Label: "Status", Key: "status", Value: currentStatus, ReadOnly: true},
 		{Label: "Message", Key: "message", Value: currentMsg, Cursor: len(currentMsg)},
 	}
-	m.buttons = []string{"Online", "Away", "DND", "XA", "Offline", "Cancel"}
+	m.buttons = []string{"Online", "Chat", "Away", "DND", "XA", "Offline", "Cancel"}
 	m.activeBtn = 0
 	m.activeInput = 1
 	m.inCheckboxes = false
@@ -354,51 +946,341 @@ func (m Model) ShowReactions(jid, messageID string) Model {
 	m.buttons = []string{"👍", "👎", "❤️", "😂", "😮", "😢", "🙏", "🔥", "Cancel"}
 	m.activeBtn = 0
 	m.inputs = nil
-	m.data["jid"] = jid
-	m.data["message_id"] = messageID
+	m.data["jid"] = jid
+	m.data["message_id"] = messageID
+	return m
+}
+
+func (m Model) ShowUploadFile(jid string) Model {
+	m.dialogType = DialogUploadFile
+	m.title = "Upload File"
+	m.message = "Enter file path to upload:"
+	m.inputs = []DialogInput{
+		{Label: "File path", Key: "filepath", Value: ""},
+	}
+	m.buttons = []string{"Upload", "Cancel"}
+	m.activeBtn = 0
+	m.activeInput = 0
+	m.inCheckboxes = false
+	m.data["jid"] = jid
+	return m
+}
+
+func (m Model) ShowExportAccounts() Model {
+	m.dialogType = DialogExportAccounts
+	m.title = "Export Accounts"
+	m.message = "Accounts and their passwords are encrypted with the\n" +
+		"passphrase. --insecure writes plain JSON instead, without\n" +
+		"passwords, readable by anyone with the file."
+	m.inputs = []DialogInput{
+		{Label: "File path", Key: "filepath", Value: ""},
+		{Label: "Passphrase", Key: "passphrase", Value: "", Password: true},
+		{Label: "Repeat passphrase", Key: "passphrase_confirm", Value: "", Password: true},
+	}
+	m.checkboxes = []DialogCheckbox{
+		{Label: "--insecure: export unencrypted", Key: "insecure", Checked: false},
+	}
+	m.buttons = []string{"Export", "Cancel"}
+	m.activeBtn = 0
+	m.activeInput = 0
+	m.inCheckboxes = false
+	return m
+}
+
+func (m Model) ShowImportAccounts() Model {
+	m.dialogType = DialogImportAccounts
+	m.title = "Import Accounts"
+	m.message = "Enter the path of a roster export, Pidgin accounts.xml,\n" +
+		"Gajim config or Dino dino.db:"
+	m.inputs = []DialogInput{
+		{Label: "File path", Key: "filepath", Value: ""},
+		{Label: "Passphrase (encrypted exports)", Key: "passphrase", Value: "", Password: true},
+	}
+	m.checkboxes = nil
+	m.buttons = []string{"Import", "Cancel"}
+	m.activeBtn = 0
+	m.activeInput = 0
+	m.inCheckboxes = false
+	return m
+}
+
+// ImportAccountInfo describes an account found by the import wizard
+type ImportAccountInfo struct {
+	JID           string
+	Server        string
+	Port          int
+	Contacts      int
+	NeedsPassword bool
+	Exists        bool
+}
+
+// ShowClientImport shows what will be imported from another client, with
+// password fields for the accounts whose password could not be read
+func (m Model) ShowClientImport(source string, accounts []ImportAccountInfo) Model {
+	m.dialogType = DialogClientImport
+	m.title = "Import from " + source
+
+	var sb strings.Builder
+	m.inputs = nil
+	for _, acc := range accounts {
+		line := "  " + acc.JID
+		if acc.Server != "" {
+			line += " via " + acc.Server + ":" + strconv.Itoa(acc.Port)
+		}
+		switch {
+		case acc.Exists:
+			line += " (already configured, skipped)"
+		case acc.Contacts > 0:
+			line += " (" + strconv.Itoa(acc.Contacts) + " contacts)"
+		}
+		sb.WriteString(line + "\n")
+		if acc.NeedsPassword && !acc.Exists {
+			m.inputs = append(m.inputs, DialogInput{
+				Label:    "Password for " + acc.JID,
+				Key:      "password:" + acc.JID,
+				Password: true,
+			})
+		}
+	}
+	if len(m.inputs) > 0 {
+		sb.WriteString("\nThese passwords were not stored by " + source + ".\n")
+		sb.WriteString("Leave one empty to enter it when connecting.\n")
+	}
+	sb.WriteString("\nMissing contacts are added to your roster when\n")
+	sb.WriteString("each account connects for the first time.")
+	m.message = sb.String()
+
+	m.checkboxes = nil
+	m.buttons = []string{"Import", "Cancel"}
+	m.activeBtn = 0
+	m.activeInput = 0
+	m.inCheckboxes = false
+	return m
+}
+
+// ShowOMEMOExport shows the OMEMO backup export dialog
+func (m Model) ShowOMEMOExport(accountJID string) Model {
+	m.dialogType = DialogOMEMOExport
+	m.title = "Export OMEMO Keys"
+	m.message = "Exports the private identity key, sessions and trust\n" +
+		"decisions of " + accountJID + ".\n\n" +
+		"Anyone with this file and its passphrase can read your\n" +
+		"encrypted messages. Store it safely and delete it once\n" +
+		"it has been imported on the other machine."
+	m.inputs = []DialogInput{
+		{Label: "File path", Key: "filepath", Value: ""},
+		{Label: "Passphrase", Key: "passphrase", Value: "", Password: true},
+		{Label: "Repeat passphrase", Key: "passphrase_confirm", Value: "", Password: true},
+	}
+	m.checkboxes = nil
+	m.buttons = []string{"Export", "Cancel"}
+	m.activeBtn = 0
+	m.activeInput = 0
+	m.inCheckboxes = false
+	m.data["account"] = accountJID
+	return m
+}
+
+// ShowEncryptAccounts asks for the master passphrase to encrypt the
+// accounts file with
+func (m Model) ShowEncryptAccounts() Model {
+	m.dialogType = DialogEncryptAccounts
+	m.title = "Encrypt Accounts"
+	m.message = "Stores accounts.toml encrypted with a master passphrase,\n" +
+		"which roster asks for at every start. The passphrase\n" +
+		"cannot be recovered: without it the accounts are lost."
+	m.inputs = []DialogInput{
+		{Label: "Passphrase", Key: "passphrase", Value: "", Password: true},
+		{Label: "Repeat passphrase", Key: "passphrase_confirm", Value: "", Password: true},
+	}
+	m.checkboxes = nil
+	m.buttons = []string{"Encrypt", "Cancel"}
+	m.activeBtn = 0
+	m.activeInput = 0
+	m.inCheckboxes = false
+	return m
+}
+
+// ShowRosterConflict asks which version of a contact to keep when a change
+// made offline meets a different change from another client
+func (m Model) ShowRosterConflict(accountJID, jid, mine, server string) Model {
+	m.dialogType = DialogRosterConflict
+	m.title = "Roster Conflict"
+	m.message = jid + " was changed on another client while\n" +
+		"this one was offline.\n\n" +
+		"  Mine:   " + mine + "\n" +
+		"  Server: " + server
+	m.buttons = []string{"Keep mine", "Take server", "Later"}
+	m.activeBtn = 0
+	m.inputs = nil
+	m.checkboxes = nil
+	m.data["account"] = accountJID
+	m.data["jid"] = jid
+	return m
+}
+
+// ShowPluginConsent asks before enabling a plugin whose manifest declares
+// permissions it was not granted. permissions describes them and grants
+// names them, to be granted when the user allows exactly what was shown.
+func (m Model) ShowPluginConsent(name, version string, permissions, grants []string) Model {
+	m.dialogType = DialogPluginConsent
+	m.title = "Plugin Permissions"
+	label := name
+	if version != "" {
+		label += " " + version
+	}
+	m.message = label + " asks to:\n\n  " + strings.Join(permissions, "\n  ") +
+		"\n\nIt cannot do anything else. If a later version asks\n" +
+		"for more, it stays off until you allow it again."
+	m.buttons = []string{"Allow", "Deny"}
+	m.activeBtn = 1
+	m.inputs = nil
+	m.checkboxes = nil
+	m.data["name"] = name
+	m.data["permissions"] = strings.Join(grants, ",")
+	return m
+}
+
+// ShowPlaintextConfirm asks before a message goes out unencrypted in a
+// conversation that was OMEMO encrypted so far
+func (m Model) ShowPlaintextConfirm(jid string) Model {
+	m.dialogType = DialogPlaintextConfirm
+	m.title = "Send Unencrypted?"
+	m.message = "The conversation with " + jid + " has been OMEMO\n" +
+		"encrypted, but this message would be sent in\n" +
+		"plaintext. Anyone on the way, including the servers,\n" +
+		"could read it."
+	m.buttons = []string{"Send unencrypted", "Cancel"}
+	m.activeBtn = 1
+	m.inputs = nil
+	m.checkboxes = nil
+	return m
+}
+
+// ShowAskVoice offers to request voice after a message to a moderated room
+// was not sent
+func (m Model) ShowAskVoice(room string) Model {
+	m.dialogType = DialogAskVoice
+	m.title = "No Voice"
+	m.message = "You are a visitor in " + room + " and cannot\n" +
+		"send messages there. Ask the moderators for voice?"
+	m.buttons = []string{"Request voice", "Cancel"}
+	m.activeBtn = 0
+	m.inputs = nil
+	m.checkboxes = nil
+	m.data["room"] = room
+	return m
+}
+
+// ShowResendRoomMessage offers to resend a message a room did not reflect
+func (m Model) ShowResendRoomMessage(room, body string) Model {
+	m.dialogType = DialogResendRoomMessage
+	m.title = "Message Not Delivered"
+	m.message = room + " did not send your message back,\n" +
+		"so the others probably did not get it:\n\n" +
+		truncateRunes(strings.Join(strings.Fields(body), " "), 60) + "\n\nSend it again?"
+	m.buttons = []string{"Resend", "Cancel"}
+	m.activeBtn = 0
+	m.inputs = nil
+	m.checkboxes = nil
+	return m
+}
+
+// ShowVoiceApproval asks a moderator to grant or deny a visitor's request
+// for voice
+func (m Model) ShowVoiceApproval(room, who string) Model {
+	m.dialogType = DialogVoiceApproval
+	m.title = "Voice Request"
+	m.message = who + " asks for voice in\n" + room + "."
+	m.buttons = []string{"Grant voice", "Deny", "Later"}
+	m.activeBtn = 0
+	m.inputs = nil
+	m.checkboxes = nil
 	return m
 }
 
-func (m Model) ShowUploadFile(jid string) Model {
-	m.dialogType = DialogUploadFile
-	m.title = "Upload File"
-	m.message = "Enter file path to upload:"
+// ShowUnlockAccounts asks for the master passphrase once the cached one
+// expired and account changes need to be written
+func (m Model) ShowUnlockAccounts() Model {
+	m.dialogType = DialogUnlockAccounts
+	m.title = "Unlock Accounts"
+	m.message = "The accounts file is encrypted and the passphrase is\n" +
+		"no longer cached. Account changes are saved once it\n" +
+		"is unlocked."
 	m.inputs = []DialogInput{
-		{Label: "File path", Key: "filepath", Value: ""},
+		{Label: "Passphrase", Key: "passphrase", Value: "", Password: true},
 	}
-	m.buttons = []string{"Upload", "Cancel"}
+	m.checkboxes = nil
+	m.buttons = []string{"Unlock", "Cancel"}
 	m.activeBtn = 0
 	m.activeInput = 0
 	m.inCheckboxes = false
-	m.data["jid"] = jid
 	return m
 }
 
-func (m Model) ShowExportAccounts() Model {
-	m.dialogType = DialogExportAccounts
-	m.title = "Export Accounts"
-	m.message = "Enter file path to export accounts:"
+// ShowOMEMOImport shows the OMEMO backup import dialog
+func (m Model) ShowOMEMOImport(accountJID string) Model {
+	m.dialogType = DialogOMEMOImport
+	m.title = "Import OMEMO Keys"
+	m.message = "Replaces the OMEMO identity of " + accountJID + "\n" +
+		"with the one from the backup, from its next connection.\n" +
+		"Do not keep using the identity on the old machine afterwards."
 	m.inputs = []DialogInput{
 		{Label: "File path", Key: "filepath", Value: ""},
+		{Label: "Passphrase", Key: "passphrase", Value: "", Password: true},
 	}
-	m.buttons = []string{"Export", "Cancel"}
+	m.checkboxes = nil
+	m.buttons = []string{"Import", "Cancel"}
 	m.activeBtn = 0
 	m.activeInput = 0
 	m.inCheckboxes = false
+	m.data["account"] = accountJID
 	return m
 }
 
-func (m Model) ShowImportAccounts() Model {
-	m.dialogType = DialogImportAccounts
-	m.title = "Import Accounts"
-	m.message = "Enter file path to import accounts:"
-	m.inputs = []DialogInput{
-		{Label: "File path", Key: "filepath", Value: ""},
+// ShowSecurityEvents shows the OMEMO security audit log for an account
+func (m Model) ShowSecurityEvents(accountJID string, events []string) Model {
+	m.dialogType = DialogSecurityEvents
+	m.title = "Security Events - " + accountJID
+	if len(events) == 0 {
+		m.message = "No security events recorded yet."
+	} else {
+		m.message = strings.Join(events, "\n")
 	}
-	m.buttons = []string{"Import", "Cancel"}
+	m.buttons = []string{"Close"}
 	m.activeBtn = 0
-	m.activeInput = 0
-	m.inCheckboxes = false
+	m.inputs = nil
+	m.scrollOffset = 0
+	m.maxVisibleLines = 20
+	return m
+}
+
+// DiagnosticInfo is one line of an account health report
+type DiagnosticInfo struct {
+	Name   string
+	Status string
+	Detail string
+}
+
+// ShowDiagnostics shows the report of a :doctor run
+func (m Model) ShowDiagnostics(jid string, checks []DiagnosticInfo) Model {
+	m.dialogType = DialogDiagnostics
+	m.title = "Diagnostics - " + jid
+
+	counts := make(map[string]int)
+	lines := make([]string, 0, len(checks)+2)
+	for _, c := range checks {
+		counts[c.Status]++
+		lines = append(lines, fmt.Sprintf("[%s] %s: %s", c.Status, c.Name, c.Detail))
+	}
+	summary := fmt.Sprintf("%d passed, %d warnings, %d failed", counts["PASS"], counts["WARN"], counts["FAIL"])
+	m.message = summary + "\n\n" + strings.Join(lines, "\n")
+
+	m.buttons = []string{"Close"}
+	m.activeBtn = 0
+	m.inputs = nil
+	m.scrollOffset = 0
+	m.maxVisibleLines = 20
 	return m
 }
 
@@ -413,67 +1295,37 @@ func (m Model) ShowSubscription(jid string) Model {
 	return m
 }
 
-// ShowHelp shows the help dialog with all commands
-func (m Model) ShowHelp(commands map[string]string) Model {
+// helpKeyWidth is the width of the key column in the help dialog
+const helpKeyWidth = 14
+
+// ShowHelp shows the help dialog with the current keybindings and all
+// commands
+func (m Model) ShowHelp(keys []keybindings.HelpSection, commands []commandline.Command) Model {
 	m.dialogType = DialogHelp
 	m.title = "Help - Available Commands"
 
-	// Build help message
 	var sb strings.Builder
-	sb.WriteString("Keybindings (Normal Mode):\n")
-	sb.WriteString("  j/k       Move down/up\n")
-	sb.WriteString("  gg/G      Top/bottom\n")
-	sb.WriteString("  Ctrl+u/d  Half page up/down\n")
-	sb.WriteString("  /         Search\n")
-	sb.WriteString("  n/N       Next/prev search result\n")
-	sb.WriteString("  :         Command mode\n")
-	sb.WriteString("  i         Insert mode (chat)\n")
-	sb.WriteString("  Enter     Open chat\n")
-	sb.WriteString("  q         Close chat\n")
-	sb.WriteString("  Esc       Back to normal mode\n")
-	sb.WriteString("  H         Context help popup\n")
-	sb.WriteString("\nFocus (g prefix):\n")
-	sb.WriteString("  gr        Focus roster\n")
-	sb.WriteString("  gc        Focus chat\n")
-	sb.WriteString("  gA        Focus accounts\n")
-	sb.WriteString("  gl        Toggle account list\n")
-	sb.WriteString("\nAccount Actions (in accounts section):\n")
-	sb.WriteString("  H         Show account info tooltip\n")
-	sb.WriteString("  C         Connect account\n")
-	sb.WriteString("  D         Disconnect account\n")
-	sb.WriteString("  E         Edit account\n")
-	sb.WriteString("  X         Remove account\n")
-	sb.WriteString("\nActions (g prefix):\n")
-	sb.WriteString("  ga        Add to roster\n")
-	sb.WriteString("  gx        Remove from roster\n")
-	sb.WriteString("  gR        Rename roster entry\n")
-	sb.WriteString("  gj        Join room\n")
-	sb.WriteString("  gC        Create room\n")
-	sb.WriteString("  gs/S      Settings\n")
-	sb.WriteString("  gw        Save windows\n")
-	sb.WriteString("\nRoster Details:\n")
-	sb.WriteString("  s         Toggle status sharing\n")
-	sb.WriteString("  v         Verify fingerprint\n")
-	sb.WriteString("  Space     Bind account to window\n")
-	sb.WriteString("\nWindows:\n")
-	sb.WriteString("  Alt+1-0   Windows 1-10\n")
-	sb.WriteString("  Tab       Next window\n")
-	sb.WriteString("\nCommands (press : first):\n")
-
-	// Add command summaries
-	cmdList := []string{
-		"connect <jid> <pass> [server] [port]",
-		"account add    - Add saved account",
-		"account edit <jid> - Edit account",
-		"account resource <jid> <name> - Set resource",
-		"disconnect     - Disconnect",
-		"1-20           - Switch window",
-		"set <k> <v>    - Change setting",
-		"quit           - Exit",
-	}
-	for _, cmd := range cmdList {
-		sb.WriteString("  :" + cmd + "\n")
+	for i, section := range keys {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(section.Title + ":\n")
+		for _, entry := range section.Entries {
+			keyStr := strings.Join(entry.Keys, "/")
+			desc := entry.Description
+			if entry.Disabled {
+				desc += " (disabled)"
+			}
+			sb.WriteString(fmt.Sprintf("  %-*s %s\n", helpKeyWidth, keyStr, desc))
+		}
 	}
+
+	sb.WriteString("\nCommands (press : first, :help <command> for details):\n")
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+	for _, cmd := range commands {
+		sb.WriteString(fmt.Sprintf("  :%-*s %s\n", helpKeyWidth-1, cmd.Name, cmd.Description))
+	}
+	sb.WriteString("\nIn dialogs y copies the selected entry or the text (ctrl+y while typing).\n")
 	sb.WriteString("\nThemes: matrix, nord, gruvbox, dracula, rainbow")
 
 	m.message = sb.String()
@@ -485,6 +1337,37 @@ func (m Model) ShowHelp(commands map[string]string) Model {
 	return m
 }
 
+// ShowCommandHelp shows the usage of a single command
+func (m Model) ShowCommandHelp(cmd commandline.Command) Model {
+	m.dialogType = DialogHelp
+	m.title = "Help - :" + cmd.Name
+
+	usage := ":" + cmd.Name
+	if len(cmd.Args) > 0 {
+		usage += " " + strings.Join(cmd.Args, " ")
+	}
+	var sb strings.Builder
+	sb.WriteString("Usage: " + usage + "\n\n")
+	sb.WriteString(cmd.Description + "\n")
+	if cmd.Help != "" {
+		sb.WriteString("\n" + cmd.Help + "\n")
+	}
+	if len(cmd.Args) > 0 {
+		sb.WriteString("\nArguments in [brackets] are optional.")
+	}
+	if cmd.Plugin != "" {
+		sb.WriteString("\nAdded by the " + cmd.Plugin + " plugin.")
+	}
+
+	m.message = sb.String()
+	m.buttons = []string{"Close"}
+	m.activeBtn = 0
+	m.inputs = nil
+	m.scrollOffset = 0
+	m.maxVisibleLines = 20
+	return m
+}
+
 // ShowAccountAdd shows the add account dialog
 func (m Model) ShowAccountAdd() Model {
 	m.dialogType = DialogAccountAdd
@@ -607,8 +1490,23 @@ func (m Model) ShowSettingsList(settings map[string]string) Model {
 		{"roster_width", "Roster panel width"},
 		{"roster_position", "Roster position (left, right)"},
 		{"show_timestamps", "Show message timestamps"},
-		{"time_format", "Time format (e.g., 15:04)"},
+		{"time_format", "Time format (24h, 12h, relative or a Go layout like 15:04)"},
+		{"date_format", "Date format (iso, us, eu or a Go layout like 2006-01-02)"},
 		{"notifications", "Desktop notifications"},
+		{"window_list", "Window list (full, numbers, neighbors, unread, tabbar)"},
+		{"window_order", "Window order (fixed, activity)"},
+		{"roster_sort", "Roster sort (recent, presence)"},
+		{"roster_group", "Roster grouping (none, groups, domain)"},
+		{"which_key_delay", "Prefix key popup delay in ms (-1 disables)"},
+		{"chat_layout", "Chat layout (compact, cozy, bubble; theme)"},
+		{"chat_wrap", "Message wrapping (indent, flush)"},
+		{"chat_max_width", "Maximum message width in columns (0 = pane width)"},
+		{"nick_column", "Nick column width (0 disables alignment)"},
+		{"clipboard", "Clipboard (auto, osc52, external)"},
+		{"terminal_title", "Conversation and unread count in the terminal title"},
+		{"multiplexer_bell", "Bell on new messages inside tmux/screen"},
+		{"away_on_blur", "Minutes unfocused before going away (0 disables)"},
+		{"roster_columns", "Roster row columns in order (presence, avatar, source, favorite, name, status, unread, account, lock, activity)"},
 		{"encryption", "Default encryption (omemo, none)"},
 		{"require_encryption", "Require encryption"},
 	}
@@ -664,6 +1562,36 @@ func (m Model) ShowAccountRemoveConfirm(jid string, isSession bool) Model {
 	return m
 }
 
+// ShowBulkConfirm asks once before an action is applied to several
+// contacts, listing the first of them. The data comes back in the result.
+func (m Model) ShowBulkConfirm(summary string, jids []string, data map[string]string) Model {
+	const listed = 8
+
+	m.dialogType = DialogBulkConfirm
+	m.title = "Bulk Action"
+
+	var sb strings.Builder
+	sb.WriteString(summary + "?\n\n")
+	for i, jid := range jids {
+		if i == listed {
+			sb.WriteString(fmt.Sprintf("  … and %d more\n", len(jids)-listed))
+			break
+		}
+		sb.WriteString("  " + jid + "\n")
+	}
+	m.message = strings.TrimRight(sb.String(), "\n")
+
+	m.buttons = []string{"Apply", "Cancel"}
+	m.activeBtn = 1 // Default to Cancel for safety
+	m.inputs = nil
+	m.checkboxes = nil
+	m.data = make(map[string]string, len(data))
+	for k, v := range data {
+		m.data[k] = v
+	}
+	return m
+}
+
 // ShowCreateRoom shows the create room dialog
 func (m Model) ShowCreateRoom() Model {
 	m.dialogType = DialogCreateRoom
@@ -884,6 +1812,23 @@ func formatBytes(bytes int) string {
 	}
 }
 
+// capsLockLikely guesses from a typed password whether Caps Lock is on.
+// Terminals do not report the key's state, so it looks for the last few
+// letters all being upper case.
+func capsLockLikely(value string) bool {
+	letters := 0
+	for i := len(value) - 1; i >= 0 && letters < 3; i-- {
+		c := value[i]
+		switch {
+		case c >= 'a' && c <= 'z':
+			return false
+		case c >= 'A' && c <= 'Z':
+			letters++
+		}
+	}
+	return letters == 3
+}
+
 // formatCaptchaInfo formats CAPTCHA information for display
 func formatCaptchaInfo(captcha *CaptchaInfo) string {
 	var msg string
@@ -1057,6 +2002,12 @@ func (m Model) RestoreViewer() Model {
 	return m
 }
 
+// SetTimeFormat sets how times in dialogs are rendered
+func (m Model) SetTimeFormat(f timefmt.Formatter) Model {
+	m.timeFmt = f
+	return m
+}
+
 // ShowLoading shows a loading dialog with spinner and cancel button
 func (m Model) ShowLoading(message string, operation OperationType) Model {
 	m.dialogType = DialogLoading
@@ -1130,6 +2081,38 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 			return m, nil
 		}
 
+		// y copies the selected entry or the text of a dialog without text
+		// fields, ctrl+y of any dialog
+		if msg.String() == "ctrl+y" || (msg.String() == "y" && m.readOnly()) {
+			if text := m.CopyContent(); text != "" {
+				result := DialogResult{
+					Type:   m.dialogType,
+					Action: ActionCopy,
+					Values: map[string]string{"text": text},
+				}
+				return m, func() tea.Msg { return result }
+			}
+			return m, nil
+		}
+
+		// An error dialog with details shows them on d, and its Copy
+		// details button copies them leaving the dialog open
+		if m.dialogType == DialogError && m.errorDetails != "" {
+			switch {
+			case msg.String() == "d":
+				m = m.toggleErrorDetails()
+				return m, nil
+			case msg.String() == "2" || (msg.Type == tea.KeyEnter && m.activeBtn == 1):
+				m.activeBtn = 1
+				result := DialogResult{
+					Type:   m.dialogType,
+					Action: ActionCopy,
+					Values: map[string]string{"text": m.CopyContent()},
+				}
+				return m, func() tea.Msg { return result }
+			}
+		}
+
 		// Handle 'v' key for viewing CAPTCHA in registration form
 		// Only trigger when focused on the read-only CAPTCHA viewer field
 		keyStr := strings.ToLower(msg.String())
@@ -1185,7 +2168,7 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 		}
 
 		// Handle scrolling for help dialog
-		if m.dialogType == DialogHelp {
+		if m.dialogType == DialogHelp || m.dialogType == DialogSecurityEvents || m.dialogType == DialogDiagnostics {
 			lines := strings.Split(m.message, "\n")
 			maxScroll := len(lines) - m.maxVisibleLines
 			if maxScroll < 0 {
@@ -1244,6 +2227,125 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 			}
 		}
 
+		// Handle Snippets dialog
+		if m.dialogType == DialogSnippets {
+			switch msg.String() {
+			case "j", "down":
+				if m.selectedSnippet < len(m.snippets)-1 {
+					m.selectedSnippet++
+				}
+				return m, nil
+			case "k", "up":
+				if m.selectedSnippet > 0 {
+					m.selectedSnippet--
+				}
+				return m, nil
+			}
+		}
+
+		// Handle Quick Actions menu
+		if m.dialogType == DialogQuickActions {
+			switch msg.String() {
+			case "j", "down":
+				if m.selectedItem < len(m.menu)-1 {
+					m.selectedItem++
+				}
+				return m, nil
+			case "k", "up":
+				if m.selectedItem > 0 {
+					m.selectedItem--
+				}
+				return m, nil
+			}
+		}
+
+		// Handle Privacy dashboard
+		if m.dialogType == DialogPrivacy {
+			switch msg.String() {
+			case "j", "down":
+				if m.selectedPrivacy < len(m.privacy)-1 {
+					m.selectedPrivacy++
+				}
+				return m, nil
+			case "k", "up":
+				if m.selectedPrivacy > 0 {
+					m.selectedPrivacy--
+				}
+				return m, nil
+			}
+		}
+
+		// Handle Participants dialog
+		if m.dialogType == DialogParticipants {
+			switch msg.String() {
+			case "j", "down":
+				if m.selectedParticipant < len(m.participants)-1 {
+					m.selectedParticipant++
+				}
+				return m, nil
+			case "k", "up":
+				if m.selectedParticipant > 0 {
+					m.selectedParticipant--
+				}
+				return m, nil
+			}
+		}
+
+		// Handle Search Results and Starred dialogs
+		if m.dialogType == DialogSearchResults || m.dialogType == DialogStarred {
+			switch msg.String() {
+			case "j", "down":
+				if m.selectedHit < len(m.hits)-1 {
+					m.selectedHit++
+				}
+				return m, nil
+			case "k", "up":
+				if m.selectedHit > 0 {
+					m.selectedHit--
+				}
+				return m, nil
+			}
+		}
+
+		// Handle Browse Rooms dialog: arrows select while typing filters
+		if m.dialogType == DialogBrowseRooms {
+			switch msg.String() {
+			case "down", "ctrl+n":
+				if m.selectedRoom < len(m.filteredRooms())-1 {
+					m.selectedRoom++
+				}
+				return m, nil
+			case "up", "ctrl+p":
+				if m.selectedRoom > 0 {
+					m.selectedRoom--
+				}
+				return m, nil
+			case "enter":
+				room, _ := m.GetSelectedRoom()
+				m.data["room"] = room.JID
+			default:
+				if msg.Type == tea.KeyRunes || msg.Type == tea.KeySpace || msg.Type == tea.KeyBackspace {
+					m.selectedRoom = 0
+				}
+			}
+		}
+
+		// Handle Queue dialog
+		if m.dialogType == DialogQueue {
+			switch msg.String() {
+			case "j", "down":
+				if m.selectedQueue < len(m.queue)-1 {
+					m.selectedQueue++
+				}
+				return m, nil
+			case "k", "up":
+				if m.selectedQueue > 0 {
+					m.selectedQueue--
+				}
+				return m, nil
+			}
+		}
+
 		// Handle Bookmarks dialog
 		if m.dialogType == DialogBookmarks {
 			switch msg.String() {
@@ -1299,6 +2401,12 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 			}
 		}
 
+		// Ctrl+R shows or hides the focused password while typing it
+		if msg.Type == tea.KeyCtrlR && !m.inCheckboxes && m.activeInput < len(m.inputs) && m.inputs[m.activeInput].Password {
+			m.inputs[m.activeInput].Revealed = !m.inputs[m.activeInput].Revealed
+			return m, nil
+		}
+
 		switch msg.Type {
 		case tea.KeyEsc:
 			// Cancel dialog
@@ -1501,7 +2609,7 @@ func (m Model) View() string {
 
 	// Message (with scroll support for help dialog)
 	if m.message != "" {
-		if m.dialogType == DialogHelp && m.maxVisibleLines > 0 {
+		if (m.dialogType == DialogHelp || m.dialogType == DialogSecurityEvents || m.dialogType == DialogDiagnostics) && m.maxVisibleLines > 0 {
 			// Scrollable help content
 			lines := strings.Split(m.message, "\n")
 			totalLines := len(lines)
@@ -1544,7 +2652,7 @@ func (m Model) View() string {
 
 	// OMEMO Devices list
 	if m.dialogType == DialogOMEMODevices && len(m.omemoDevices) > 0 {
-		b.WriteString("Devices (j/k to select):\n\n")
+		b.WriteString("Devices (j/k to select, y to copy the fingerprint):\n\n")
 		for i, dev := range m.omemoDevices {
 			prefix := "  "
 			if i == m.selectedDevice {
@@ -1565,8 +2673,12 @@ func (m Model) View() string {
 			b.WriteString(m.styles.DialogContent.Render(line))
 			b.WriteString("\n")
 
-			fpLine := "   " + dev.Fingerprint
-			b.WriteString(m.styles.DialogContent.Render(fpLine))
+			// The selected fingerprint is what y copies
+			fpLine := m.styles.DialogContent.Render("   " + dev.Fingerprint)
+			if i == m.selectedDevice {
+				fpLine = m.styles.DialogContent.Render("   ") + m.styles.RosterSelected.Render(dev.Fingerprint)
+			}
+			b.WriteString(fpLine)
 			b.WriteString("\n\n")
 		}
 	}
@@ -1610,13 +2722,194 @@ func (m Model) View() string {
 		b.WriteString("\n\n")
 	}
 
+	// Snippets list
+	if m.dialogType == DialogSnippets && len(m.snippets) > 0 {
+		b.WriteString("Snippets (j/k to select):\n\n")
+		for i, sn := range m.snippets {
+			prefix := "  "
+			if i == m.selectedSnippet {
+				prefix = "> "
+			}
+			scope := ""
+			if sn.Account {
+				scope = " [account]"
+			}
+			b.WriteString(m.styles.DialogContent.Render(prefix + ";" + sn.Name + scope))
+			b.WriteString("\n")
+
+			b.WriteString(m.styles.DialogContent.Render("   " + truncateRunes(sn.Text, 40)))
+			b.WriteString("\n")
+		}
+		b.WriteString("\n")
+	} else if m.dialogType == DialogSnippets {
+		b.WriteString(m.styles.DialogContent.Render("No snippets defined."))
+		b.WriteString("\n\n")
+	}
+
+	// Quick actions menu
+	if m.dialogType == DialogQuickActions {
+		b.WriteString("Actions (j/k to select):\n\n")
+		for i, item := range m.menu {
+			prefix := "  "
+			if i == m.selectedItem {
+				prefix = "> "
+			}
+			line := prefix + item.Label
+			if item.Key != "" {
+				line += " (" + item.Key + ")"
+			}
+			b.WriteString(m.styles.DialogContent.Render(line))
+			b.WriteString("\n")
+		}
+		b.WriteString("\n")
+	}
+
+	// Privacy dashboard
+	if m.dialogType == DialogPrivacy {
+		b.WriteString("What this account shares (j/k to select):\n\n")
+		b.WriteString(m.renderPrivacy())
+		b.WriteString("\n")
+	}
+
+	// Room occupants
+	if m.dialogType == DialogParticipants && len(m.participants) > 0 {
+		b.WriteString("Occupants (j/k to select):\n\n")
+		for i, p := range m.participants {
+			prefix := "  "
+			if i == m.selectedParticipant {
+				prefix = "> "
+			}
+			line := prefix + p.Nick
+			if p.Ignored {
+				line += " [ignored]"
+			}
+			b.WriteString(m.styles.DialogContent.Render(line))
+			b.WriteString("\n")
+		}
+		b.WriteString("\n")
+	} else if m.dialogType == DialogParticipants {
+		b.WriteString(m.styles.DialogContent.Render("No occupants seen yet."))
+		b.WriteString("\n\n")
+	}
+
+	// Listed messages, a window of them around the selection
+	if m.dialogType == DialogSearchResults || m.dialogType == DialogStarred {
+		switch {
+		case len(m.hits) == 0 && m.dialogType == DialogStarred:
+			b.WriteString(m.styles.DialogContent.Render("No starred messages. cs in a chat stars the selected one."))
+			b.WriteString("\n\n")
+		case len(m.hits) == 0:
+			b.WriteString(m.styles.DialogContent.Render("No stored messages match."))
+			b.WriteString("\n\n")
+		default:
+			if m.dialogType == DialogStarred {
+				b.WriteString(fmt.Sprintf("%d starred (j/k to select):\n\n", len(m.hits)))
+			} else {
+				b.WriteString(fmt.Sprintf("%d messages, newest first (j/k to select):\n\n", len(m.hits)))
+			}
+			b.WriteString(m.renderHits())
+			b.WriteString("\n")
+		}
+	}
+
+	// Public rooms, a window of them around the selection
+	if m.dialogType == DialogBrowseRooms {
+		rooms := m.filteredRooms()
+		if len(rooms) == 0 {
+			if len(m.rooms) == 0 {
+				b.WriteString(m.styles.DialogContent.Render("This service lists no public rooms."))
+			} else {
+				b.WriteString(m.styles.DialogContent.Render("No rooms match the filter."))
+			}
+			b.WriteString("\n\n")
+		} else {
+			b.WriteString(fmt.Sprintf("%d of %d rooms (up/down to select, type to filter):\n\n", len(rooms), len(m.rooms)))
+			start := 0
+			if m.selectedRoom >= maxRoomRows {
+				start = m.selectedRoom - maxRoomRows + 1
+			}
+			end := min(start+maxRoomRows, len(rooms))
+			for i := start; i < end; i++ {
+				r := rooms[i]
+				prefix := "  "
+				if i == m.selectedRoom {
+					prefix = "> "
+				}
+				name := r.Name
+				if name == "" {
+					name = r.JID
+				}
+				line := prefix + truncateRunes(name, 32)
+				if r.Occupants >= 0 {
+					line += " (" + strconv.Itoa(r.Occupants) + ")"
+				}
+				if r.Locked {
+					line += " [locked]"
+				}
+				b.WriteString(m.styles.DialogContent.Render(line))
+				b.WriteString("\n")
+				if i == m.selectedRoom {
+					b.WriteString(m.styles.DialogContent.Render("   " + truncateRunes(r.JID, 40)))
+					b.WriteString("\n")
+					if r.Description != "" {
+						b.WriteString(m.styles.DialogContent.Render("   " + truncateRunes(r.Description, 40)))
+						b.WriteString("\n")
+					}
+				}
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	// Outgoing queue, grouped by account
+	if m.dialogType == DialogQueue && len(m.queue) > 0 {
+		b.WriteString("Pending messages (j/k to select):\n")
+		account := ""
+		for i, item := range m.queue {
+			if item.AccountJID != account {
+				account = item.AccountJID
+				b.WriteString("\n")
+				b.WriteString(m.styles.DialogContent.Render(account))
+				b.WriteString("\n")
+			}
+			prefix := "  "
+			if i == m.selectedQueue {
+				prefix = "> "
+			}
+			status := item.State
+			switch item.State {
+			case "scheduled":
+				status += " " + m.timeFmt.DateTime(item.SendAt)
+			case "retrying":
+				status += " (" + strconv.Itoa(item.Attempts) + " attempts"
+				if !item.SendAt.IsZero() {
+					status += ", next " + m.timeFmt.Clock(item.SendAt)
+				}
+				status += ")"
+			}
+			b.WriteString(m.styles.DialogContent.Render(prefix + item.To + " [" + status + "]"))
+			b.WriteString("\n")
+
+			b.WriteString(m.styles.DialogContent.Render("   " + truncateRunes(item.Body, 40)))
+			b.WriteString("\n")
+			if item.LastError != "" && i == m.selectedQueue {
+				b.WriteString(m.styles.DialogContent.Render("   error: " + item.LastError))
+				b.WriteString("\n")
+			}
+		}
+		b.WriteString("\n")
+	} else if m.dialogType == DialogQueue {
+		b.WriteString(m.styles.DialogContent.Render("No pending messages."))
+		b.WriteString("\n\n")
+	}
+
 	// Inputs
 	for i, input := range m.inputs {
 		label := input.Label + ": "
 
 		// Value with cursor
 		value := input.Value
-		if input.Password {
+		if input.Password && !input.Revealed {
 			value = strings.Repeat("*", len(value))
 		}
 
@@ -1687,6 +2980,18 @@ func (m Model) View() string {
 
 		b.WriteString(rendered)
 		b.WriteString("\n")
+
+		if input.Password && i == m.activeInput && !m.inCheckboxes {
+			hint := "ctrl+r show"
+			if input.Revealed {
+				hint = "ctrl+r hide"
+			}
+			b.WriteString(m.styles.DialogContent.Render(hint))
+			if capsLockLikely(input.Value) {
+				b.WriteString("  " + m.styles.PresenceDND.Render("⇪ Caps Lock on?"))
+			}
+			b.WriteString("\n")
+		}
 	}
 
 	if len(m.inputs) > 0 {
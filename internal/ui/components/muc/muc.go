@@ -1,6 +1,7 @@
 package muc
 
 import (
+	"sort"
 	"strings"
 
 	"github.com/meszmate/roster/internal/ui/theme"
@@ -82,6 +83,16 @@ func (m Model) JoinRoom(roomJID, nick, password string) Model {
 	return m
 }
 
+// EnsureRoom adds a room shown in a window, keeping the active room
+func (m Model) EnsureRoom(roomJID, nick string) Model {
+	if room, ok := m.rooms[roomJID]; ok {
+		room.Nick = nick
+		return m
+	}
+	m.rooms[roomJID] = &Room{JID: roomJID, Nick: nick}
+	return m
+}
+
 // LeaveRoom leaves a MUC room
 func (m Model) LeaveRoom(roomJID string) Model {
 	delete(m.rooms, roomJID)
@@ -114,6 +125,14 @@ func (m Model) AddParticipant(roomJID string, p Participant) Model {
 	return m
 }
 
+// SetParticipants replaces the participants of a room
+func (m Model) SetParticipants(roomJID string, participants []Participant) Model {
+	if room, ok := m.rooms[roomJID]; ok {
+		room.Participants = participants
+	}
+	return m
+}
+
 // RemoveParticipant removes a participant from a room
 func (m Model) RemoveParticipant(roomJID, nick string) Model {
 	if room, ok := m.rooms[roomJID]; ok {
@@ -145,10 +164,12 @@ func (m Model) GetActiveRoom() *Room {
 	return m.rooms[m.activeRoom]
 }
 
-// SetActiveRoom sets the active room
+// SetActiveRoom sets the active room, none for a JID that is not a room
 func (m Model) SetActiveRoom(roomJID string) Model {
 	if _, ok := m.rooms[roomJID]; ok {
 		m.activeRoom = roomJID
+	} else {
+		m.activeRoom = ""
 	}
 	return m
 }
@@ -159,6 +180,15 @@ func (m Model) ToggleParticipants() Model {
 	return m
 }
 
+// ParticipantsWidth returns the width of the participant list with its
+// border, 0 when it is not shown
+func (m Model) ParticipantsWidth() int {
+	if !m.showParticipants || m.GetActiveRoom() == nil {
+		return 0
+	}
+	return m.participantWidth + 2
+}
+
 // SetSize sets the component size
 func (m Model) SetSize(width, height int) Model {
 	m.width = width
@@ -177,6 +207,12 @@ func (m Model) ParticipantsView() string {
 		return ""
 	}
 
+	sorted := make([]Participant, len(room.Participants))
+	copy(sorted, room.Participants)
+	sort.Slice(sorted, func(i, j int) bool {
+		return strings.ToLower(sorted[i].Nick) < strings.ToLower(sorted[j].Nick)
+	})
+
 	var b strings.Builder
 
 	// Header
@@ -189,11 +225,11 @@ func (m Model) ParticipantsView() string {
 	participants := []Participant{}
 	visitors := []Participant{}
 
-	for _, p := range room.Participants {
+	for _, p := range sorted {
 		switch p.Role {
 		case RoleModerator:
 			moderators = append(moderators, p)
-		case RoleParticipant:
+		case RoleParticipant, "":
 			participants = append(participants, p)
 		case RoleVisitor:
 			visitors = append(visitors, p)
@@ -228,25 +264,22 @@ func (m Model) ParticipantsView() string {
 		}
 	}
 
-	return b.String()
+	// Crop to the height, the list is not scrolled
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if m.height > 0 && len(lines) > m.height {
+		lines = lines[:m.height]
+	}
+	return strings.Join(lines, "\n")
 }
 
 // renderParticipant renders a single participant
 func (m Model) renderParticipant(p Participant) string {
 	// Presence indicator
-	var indicator string
-	switch p.Status {
-	case "online", "":
-		indicator = m.styles.PresenceOnline.Render("●")
-	case "away":
-		indicator = m.styles.PresenceAway.Render("◐")
-	case "dnd":
-		indicator = m.styles.PresenceDND.Render("⊘")
-	case "xa":
-		indicator = m.styles.PresenceXA.Render("◯")
-	default:
-		indicator = m.styles.PresenceOffline.Render("○")
+	status := p.Status
+	if status == "" {
+		status = "online"
 	}
+	indicator := m.styles.PresenceIcon(status)
 
 	// Affiliation badge
 	badge := ""
@@ -0,0 +1,207 @@
+package roster
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/meszmate/roster/internal/ui/theme"
+)
+
+// Roster columns, the parts of a contact row. ui.roster_columns lists the
+// ones shown in the order they appear; the name always shows.
+const (
+	ColumnPresence = "presence" // presence glyph
+	ColumnAvatar   = "avatar"   // initials on the contact's colour
+	ColumnSource   = "source"   // [ROSTER] or [INCOMING]
+	ColumnFavorite = "favorite" // favorite star
+	ColumnName     = "name"     // name, or the JID without one
+	ColumnStatus   = "status"   // status message snippet
+	ColumnUnread   = "unread"   // unread count badge
+	ColumnAccount  = "account"  // the account the contact belongs to
+	ColumnLock     = "lock"     // whether messages to the contact are encrypted
+	ColumnActivity = "activity" // time since the last message
+)
+
+// Columns lists every roster column
+var Columns = []string{
+	ColumnPresence, ColumnAvatar, ColumnSource, ColumnFavorite, ColumnName,
+	ColumnStatus, ColumnUnread, ColumnAccount, ColumnLock, ColumnActivity,
+}
+
+// DefaultColumns are the columns shown when ui.roster_columns is empty
+var DefaultColumns = []string{
+	ColumnPresence, ColumnSource, ColumnFavorite, ColumnName, ColumnStatus, ColumnUnread,
+}
+
+// ValidColumn reports whether name is a known roster column
+func ValidColumn(name string) bool {
+	return containsColumn(Columns, name)
+}
+
+// ParseColumns cleans up a column list, dropping unknown names and
+// repeats. The name is added at the end when it is missing, and an empty
+// list gives the default columns.
+func ParseColumns(list []string) []string {
+	var columns []string
+	seen := make(map[string]bool)
+	for _, c := range list {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if !ValidColumn(c) || seen[c] {
+			continue
+		}
+		seen[c] = true
+		columns = append(columns, c)
+	}
+	if len(columns) == 0 {
+		return DefaultColumns
+	}
+	if !seen[ColumnName] {
+		columns = append(columns, ColumnName)
+	}
+	return columns
+}
+
+// SetColumns picks the columns of the contact rows, see ParseColumns
+func (m Model) SetColumns(list []string) Model {
+	m.columns = ParseColumns(list)
+	return m
+}
+
+// ShowsColumn reports whether a column is shown
+func (m Model) ShowsColumn(name string) bool {
+	return containsColumn(m.rowColumns(), name)
+}
+
+// rowColumns returns the columns shown, the defaults until SetColumns
+func (m Model) rowColumns() []string {
+	if len(m.columns) == 0 {
+		return DefaultColumns
+	}
+	return m.columns
+}
+
+// renderColumn renders a fixed width column of a contact row, empty when it
+// has nothing to show for the contact. The name and status are not fixed
+// and are handled by renderRoster.
+func (m Model) renderColumn(column string, r Roster) string {
+	dim := lipgloss.NewStyle().Foreground(lipgloss.Color("242"))
+	switch column {
+	case ColumnPresence:
+		if r.StatusHidden {
+			// Status is not shared, so presence is unknown
+			return m.styles.RosterContact.Foreground(lipgloss.Color("242")).Render("?")
+		}
+		return m.styles.Presence(r.Status).Render(theme.PresenceGlyph(r.Status))
+	case ColumnAvatar:
+		return theme.Avatar(r.Name, r.JID)
+	case ColumnSource:
+		if r.AddedToRoster {
+			return lipgloss.NewStyle().Foreground(lipgloss.Color("35")).Render("[ROSTER]")
+		}
+		return lipgloss.NewStyle().Foreground(lipgloss.Color("214")).Render("[INCOMING]")
+	case ColumnFavorite:
+		if r.Favorite {
+			return lipgloss.NewStyle().Foreground(lipgloss.Color("220")).Render("★")
+		}
+		return dim.Render("☆")
+	case ColumnUnread:
+		if r.Unread > 0 {
+			return m.styles.RosterUnread.Render(fmt.Sprintf("[%d]", r.Unread))
+		}
+	case ColumnAccount:
+		if local, _, ok := strings.Cut(r.AccountJID, "@"); ok {
+			return dim.Render("@" + local)
+		}
+		if r.AccountJID != "" {
+			return dim.Render("@" + r.AccountJID)
+		}
+	case ColumnLock:
+		if r.Encrypted {
+			return m.styles.ChatEncrypted.Render("🔒")
+		}
+	case ColumnActivity:
+		if !r.LastActivity.IsZero() {
+			return dim.Render(activityAge(r.LastActivity, time.Now()))
+		}
+	}
+	return ""
+}
+
+// statusText is the status message snippet of a contact, empty when there is
+// nothing to say
+func statusText(r Roster) string {
+	switch {
+	case r.StatusHidden:
+		return "(hidden)"
+	case r.StatusMsg == "":
+		return ""
+	case r.Status == "online":
+		// Online with status message: just show the message
+		return "(" + r.StatusMsg + ")"
+	case r.Status == "offline":
+		return "(off: " + r.StatusMsg + ")"
+	default:
+		// Abbreviated status with message: "(away: lunch)"
+		return "(" + r.Status + ": " + r.StatusMsg + ")"
+	}
+}
+
+// activityAge is how long ago t was, in the shortest form that fits a row
+func activityAge(t, now time.Time) string {
+	d := now.Sub(t)
+	switch {
+	case d < time.Minute:
+		return "now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	case d < 7*24*time.Hour:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	case d < 365*24*time.Hour:
+		return fmt.Sprintf("%dw", int(d.Hours()/(7*24)))
+	default:
+		return fmt.Sprintf("%dy", int(d.Hours()/(365*24)))
+	}
+}
+
+// truncateText shortens s to at most width columns, ending it with … when
+// cut
+func truncateText(s string, width int) string {
+	if lipgloss.Width(s) <= width {
+		return s
+	}
+	if width <= 0 {
+		return ""
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && lipgloss.Width(string(runes))+1 > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "…"
+}
+
+// containsColumn reports whether a column list has name
+func containsColumn(columns []string, name string) bool {
+	for _, c := range columns {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// withStatusAfterName returns a copy of columns with the status placed
+// right after the name
+func withStatusAfterName(columns []string) []string {
+	out := make([]string, 0, len(columns)+1)
+	for _, c := range columns {
+		out = append(out, c)
+		if c == ColumnName {
+			out = append(out, ColumnStatus)
+		}
+	}
+	return out
+}
@@ -0,0 +1,211 @@
+package roster
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Roster grouping modes
+const (
+	GroupingNone   = "none"   // one flat list
+	GroupingGroups = "groups" // by roster group
+	GroupingDomain = "domain" // by the server part of the JID
+)
+
+// Groupings lists the grouping modes in the order :group cycles through them
+var Groupings = []string{GroupingNone, GroupingGroups, GroupingDomain}
+
+// Headers of the groups that are not roster groups or domains
+const (
+	ungroupedLabel = "Ungrouped" // contacts in no roster group
+	contactsLabel  = "Contacts"  // everyone else when only requests are grouped
+	requestsLabel  = "Requests"  // subscription requests and strangers' messages
+)
+
+// NextGrouping returns the mode after current, wrapping around
+func NextGrouping(current string) string {
+	for i, g := range Groupings {
+		if g == current {
+			return Groupings[(i+1)%len(Groupings)]
+		}
+	}
+	return Groupings[1]
+}
+
+// ValidGrouping reports whether mode is a known grouping mode
+func ValidGrouping(mode string) bool {
+	for _, g := range Groupings {
+		if g == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// SetGrouping switches how contacts are grouped under headers. Unknown
+// modes show a flat list.
+func (m Model) SetGrouping(mode string) Model {
+	if !ValidGrouping(mode) {
+		mode = GroupingNone
+	}
+	if mode == m.grouping {
+		return m
+	}
+	m.grouping = mode
+	return m.SetRosters(m.rosters)
+}
+
+// Grouping returns the current grouping mode
+func (m Model) Grouping() string {
+	return m.grouping
+}
+
+// grouped reports whether the list is shown under group headers, which it
+// is whenever there are requests to set apart. Filter results stay a flat
+// list ranked by how well they match.
+func (m Model) grouped() bool {
+	return (m.requests > 0 || (m.grouping != "" && m.grouping != GroupingNone)) && !m.filterMode
+}
+
+// groupKey names the group a contact is listed under. A contact in several
+// roster groups is listed once, under the first of them alphabetically.
+// Requests are listed apart from everyone else.
+func (m Model) groupKey(r Roster) string {
+	if r.Request {
+		return requestsLabel
+	}
+	switch m.grouping {
+	case GroupingGroups:
+		if len(r.Groups) == 0 {
+			return ungroupedLabel
+		}
+		first := r.Groups[0]
+		for _, g := range r.Groups[1:] {
+			if g < first {
+				first = g
+			}
+		}
+		return first
+	case GroupingDomain:
+		return jidDomain(r.JID)
+	}
+	return contactsLabel
+}
+
+// sortByGroup orders contacts by group, keeping their order within each
+// group. Ungrouped contacts come last but for requests.
+func (m Model) sortByGroup(rosters []Roster) {
+	sort.SliceStable(rosters, func(i, j int) bool {
+		if rosters[i].Request != rosters[j].Request {
+			return rosters[j].Request
+		}
+		ki, kj := m.groupKey(rosters[i]), m.groupKey(rosters[j])
+		if (ki == ungroupedLabel) != (kj == ungroupedLabel) {
+			return kj == ungroupedLabel
+		}
+		return strings.ToLower(ki) < strings.ToLower(kj)
+	})
+}
+
+// jidDomain returns the domain of a JID. Gateways and other services are
+// addressed by a bare domain, which is returned as is.
+func jidDomain(jid string) string {
+	if i := strings.Index(jid, "/"); i >= 0 {
+		jid = jid[:i]
+	}
+	if i := strings.LastIndex(jid, "@"); i >= 0 {
+		jid = jid[i+1:]
+	}
+	return strings.ToLower(jid)
+}
+
+// groupStartsAt reports whether a group header goes before roster[i] when
+// the list is shown from start
+func (m Model) groupStartsAt(roster []Roster, start, i int) bool {
+	return i == start || m.groupKey(roster[i]) != m.groupKey(roster[i-1])
+}
+
+// groupedEnd returns the index after the last contact that fits in height
+// lines when the grouped list is shown from start, counting the headers and
+// the "more" markers
+func (m Model) groupedEnd(roster []Roster, start, height int) int {
+	lines := 0
+	if start > 0 {
+		lines++
+	}
+	i := start
+	for ; i < len(roster); i++ {
+		need := 1
+		if m.groupStartsAt(roster, start, i) {
+			need++
+		}
+		reserve := 0
+		if i < len(roster)-1 {
+			reserve = 1
+		}
+		if lines+need+reserve > height {
+			break
+		}
+		lines += need
+	}
+	return i
+}
+
+// renderGroupedRoster renders the contacts under their group headers,
+// scrolled so that the selection stays visible
+func (m Model) renderGroupedRoster(roster []Roster, height int) string {
+	start := m.offset
+	if start > m.selected {
+		start = m.selected
+	}
+	for start < m.selected && m.groupedEnd(roster, start, height) <= m.selected {
+		start++
+	}
+	end := m.groupedEnd(roster, start, height)
+
+	counts := make(map[string]int)
+	for _, r := range roster {
+		counts[m.groupKey(r)]++
+	}
+
+	lo, hi := m.visualRange(roster)
+	var b strings.Builder
+	lines := 0
+	dim := m.styles.RosterContact.Foreground(lipgloss.Color("242"))
+	if start > 0 {
+		b.WriteString(dim.Width(m.width - 2).Render(fmt.Sprintf("  ↑ %d more", start)))
+		b.WriteString("\n")
+		lines++
+	}
+	for i := start; i < end; i++ {
+		if m.groupStartsAt(roster, start, i) {
+			key := m.groupKey(roster[i])
+			header := fmt.Sprintf("▾ %s (%d)", key, counts[key])
+			if len(header) > m.width-3 && m.width > 4 {
+				header = header[:m.width-4] + "…"
+			}
+			b.WriteString(m.styles.RosterGroup.Width(m.width - 2).Render(header))
+			b.WriteString("\n")
+			lines++
+		}
+		selected := i == m.selected && m.focusSection == SectionContacts
+		b.WriteString(m.renderRoster(roster[i], selected, m.isMarked(roster[i], i, lo, hi)))
+		b.WriteString("\n")
+		lines++
+	}
+	if end < len(roster) {
+		b.WriteString(dim.Width(m.width - 2).Render(fmt.Sprintf("  ↓ %d more", len(roster)-end)))
+		b.WriteString("\n")
+		lines++
+	}
+
+	blankLine := strings.Repeat(" ", m.width-2)
+	for ; lines < height; lines++ {
+		b.WriteString(blankLine)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,134 @@
+package roster
+
+// Contacts are marked for bulk actions one at a time with space, or as a
+// range in visual mode, which spans from where V was pressed to the
+// selection. Marks are kept by JID so they survive re-sorting.
+
+// ToggleMark marks or unmarks the selected contact and moves down to the
+// next one
+func (m Model) ToggleMark() Model {
+	jid := m.SelectedJID()
+	if jid == "" {
+		return m
+	}
+	if m.marked == nil {
+		m.marked = make(map[string]bool)
+	}
+	if m.marked[jid] {
+		delete(m.marked, jid)
+	} else {
+		m.marked[jid] = true
+	}
+	return m.MoveDown()
+}
+
+// ToggleVisual starts visual mode at the selected contact, or ends it and
+// keeps the range marked
+func (m Model) ToggleVisual() Model {
+	if m.visualAnchor != "" {
+		return m.foldVisual()
+	}
+	m.visualAnchor = m.SelectedJID()
+	return m
+}
+
+// InVisualMode reports whether a range is being selected
+func (m Model) InVisualMode() bool {
+	return m.visualAnchor != ""
+}
+
+// HasMarks reports whether any contact is marked, including a visual range
+func (m Model) HasMarks() bool {
+	return len(m.marked) > 0 || m.visualAnchor != ""
+}
+
+// ClearMarks unmarks every contact and leaves visual mode
+func (m Model) ClearMarks() Model {
+	m.marked = nil
+	m.visualAnchor = ""
+	return m
+}
+
+// MarkedJIDs returns the marked contacts in roster order
+func (m Model) MarkedJIDs() []string {
+	roster := m.currentList()
+	lo, hi := m.visualRange(roster)
+	var jids []string
+	for i, r := range roster {
+		if m.marked[r.JID] || (i >= lo && i <= hi) {
+			jids = append(jids, r.JID)
+		}
+	}
+	return jids
+}
+
+// foldVisual turns the visual range into marks
+func (m Model) foldVisual() Model {
+	roster := m.currentList()
+	lo, hi := m.visualRange(roster)
+	if lo >= 0 && m.marked == nil {
+		m.marked = make(map[string]bool)
+	}
+	for i := lo; i >= 0 && i <= hi; i++ {
+		m.marked[roster[i].JID] = true
+	}
+	m.visualAnchor = ""
+	return m
+}
+
+// visualRange returns the indexes the visual range spans, or -1, -1 when
+// not in visual mode
+func (m Model) visualRange(roster []Roster) (int, int) {
+	if m.visualAnchor == "" {
+		return -1, -1
+	}
+	anchor := -1
+	for i, r := range roster {
+		if r.JID == m.visualAnchor {
+			anchor = i
+			break
+		}
+	}
+	if anchor < 0 || m.selected < 0 || m.selected >= len(roster) {
+		return -1, -1
+	}
+	if anchor > m.selected {
+		return m.selected, anchor
+	}
+	return anchor, m.selected
+}
+
+// isMarked reports whether roster[i] is marked or in the visual range
+func (m Model) isMarked(r Roster, i, lo, hi int) bool {
+	return m.marked[r.JID] || (i >= lo && i <= hi)
+}
+
+// pruneMarks drops marks of contacts no longer listed, such as after
+// switching accounts
+func (m Model) pruneMarks() Model {
+	if len(m.marked) == 0 && m.visualAnchor == "" {
+		return m
+	}
+	listed := make(map[string]bool, len(m.rosters))
+	for _, r := range m.rosters {
+		listed[r.JID] = true
+	}
+	for jid := range m.marked {
+		if !listed[jid] {
+			delete(m.marked, jid)
+		}
+	}
+	if !listed[m.visualAnchor] {
+		m.visualAnchor = ""
+	}
+	return m
+}
+
+// currentList returns the contacts as listed: the filter results while
+// filtering, the whole roster otherwise
+func (m Model) currentList() []Roster {
+	if m.filterMode && m.filteredRoster != nil {
+		return m.filteredRoster
+	}
+	return m.rosters
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -19,10 +20,13 @@ type Roster struct {
 	StatusMsg     string
 	Unread        int
 	Favorite      bool
-	AccountJID    string // Which account owns this contact
-	AddedToRoster bool   // True when this entry comes from roster management, false when discovered from incoming chat only
-	StatusHidden  bool   // True if we don't share status with this contact
-	Subscription  string // "none", "to", "from", "both"
+	AccountJID    string    // Which account owns this contact
+	AddedToRoster bool      // True when this entry comes from roster management, false when discovered from incoming chat only
+	StatusHidden  bool      // True if we don't share status with this contact
+	Subscription  string    // "none", "to", "from", "both"
+	Request       bool      // A pending subscription request or a message from a stranger
+	Encrypted     bool      // Messages to this contact are sent with OMEMO
+	LastActivity  time.Time // Last message with this contact, zero if none
 }
 
 // AccountDisplay represents an account for display in the sidebar
@@ -38,6 +42,8 @@ type AccountDisplay struct {
 	Session     bool   // Session-only (not saved)
 	AutoConnect bool   // Auto-connect on startup
 	RosterSync  bool   // True when roster sync is in progress for this account
+	Step        string // Connection step while connecting
+	Failure     string // Why the last connection attempt failed
 }
 
 // Section represents which section is focused in the roster
@@ -69,6 +75,10 @@ type Model struct {
 	styles         *theme.Styles
 	showGroups     bool
 	expandedGroups map[string]bool
+	grouping       string
+	requests       int // entries in the Requests section
+	marked         map[string]bool
+	visualAnchor   string // JID where visual mode started, empty outside it
 	searchQuery    string
 	searchMatches  []int
 	searchIndex    int
@@ -87,6 +97,8 @@ type Model struct {
 
 	loading      bool
 	spinnerFrame int
+
+	columns []string // columns of a contact row, see SetColumns
 }
 
 var loadingFrames = []string{"|", "/", "-", `\`}
@@ -99,6 +111,7 @@ func New(styles *theme.Styles) Model {
 		styles:              styles,
 		showGroups:          true,
 		expandedGroups:      make(map[string]bool),
+		grouping:            GroupingNone,
 		accounts:            []AccountDisplay{},
 		focusSection:        SectionContacts,
 		maxVisibleAccounts:  3,
@@ -154,6 +167,15 @@ func (m Model) SetRosters(rosters []Roster) Model {
 		}
 		return sorted[i].Favorite
 	})
+	m.requests = 0
+	for _, r := range sorted {
+		if r.Request {
+			m.requests++
+		}
+	}
+	if m.grouping != GroupingNone || m.requests > 0 {
+		m.sortByGroup(sorted)
+	}
 
 	m.rosters = sorted
 	m.groups = make(map[string][]Roster)
@@ -181,6 +203,7 @@ func (m Model) SetRosters(rosters []Roster) Model {
 	}
 
 	m = m.normalizeContactSelection()
+	m = m.pruneMarks()
 
 	return m
 }
@@ -532,9 +555,7 @@ func (m Model) findMatches(query string) []int {
 	var matches []int
 	query = strings.ToLower(query)
 	for i, r := range m.rosters {
-		name := strings.ToLower(r.Name)
-		jid := strings.ToLower(r.JID)
-		if strings.Contains(name, query) || strings.Contains(jid, query) {
+		if matchEntry(r, query) != noMatch {
 			matches = append(matches, i)
 		}
 	}
@@ -559,6 +580,8 @@ func (m Model) InFilterMode() bool {
 	return m.filterMode
 }
 
+// UpdateFilter narrows the roster to entries matching query by name, JID or
+// group, best matches first, and selects the best one
 func (m Model) UpdateFilter(query string) Model {
 	m.filterQuery = query
 	if query == "" {
@@ -566,18 +589,19 @@ func (m Model) UpdateFilter(query string) Model {
 	} else {
 		m.filteredRoster = nil
 		queryLower := strings.ToLower(query)
+		ranks := make(map[string]int)
 		for _, r := range m.rosters {
-			name := strings.ToLower(r.Name)
-			jid := strings.ToLower(r.JID)
-			if strings.Contains(name, queryLower) || strings.Contains(jid, queryLower) {
+			if rank := matchEntry(r, queryLower); rank != noMatch {
+				ranks[r.JID] = rank
 				m.filteredRoster = append(m.filteredRoster, r)
 			}
 		}
+		sort.SliceStable(m.filteredRoster, func(i, j int) bool {
+			return ranks[m.filteredRoster[i].JID] < ranks[m.filteredRoster[j].JID]
+		})
 	}
-	if m.selected >= len(m.filteredRoster) {
-		m.selected = 0
-		m.offset = 0
-	}
+	m.selected = 0
+	m.offset = 0
 	return m
 }
 
@@ -689,7 +713,14 @@ func (m Model) View() string {
 		headerText = fmt.Sprintf("Roster %s loading...", loadingFrames[m.spinnerFrame%len(loadingFrames)])
 	}
 	if m.filterMode {
-		headerText = fmt.Sprintf("Filter: %s", m.filterQuery)
+		headerText = fmt.Sprintf("/%s  (%d found)", m.filterQuery, len(m.filteredRoster))
+	}
+	if m.HasMarks() {
+		marks := fmt.Sprintf("%d marked", len(m.MarkedJIDs()))
+		if m.InVisualMode() {
+			marks = "VISUAL " + marks
+		}
+		headerText += "  [" + marks + "]"
 	}
 	header := m.styles.RosterHeader.Width(m.width - 2).Render(headerText)
 	b.WriteString(header)
@@ -746,6 +777,8 @@ func (m Model) View() string {
 			b.WriteString(blankLine)
 			b.WriteString("\n")
 		}
+	} else if m.grouped() {
+		b.WriteString(m.renderGroupedRoster(roster, visibleHeight))
 	} else {
 		actualVisibleHeight := visibleHeight
 		if m.offset > 0 {
@@ -763,10 +796,11 @@ func (m Model) View() string {
 			b.WriteString("\n")
 		}
 
+		lo, hi := m.visualRange(roster)
 		for i := m.offset; i < len(roster) && i < m.offset+actualVisibleHeight; i++ {
 			r := roster[i]
 			selected := i == m.selected && m.focusSection == SectionContacts
-			line := m.renderRoster(r, selected)
+			line := m.renderRoster(r, selected, m.isMarked(r, i, lo, hi))
 			b.WriteString(line)
 			b.WriteString("\n")
 		}
@@ -1030,7 +1064,9 @@ func (m Model) renderAccountWithIndicator(acc AccountDisplay, selected bool, pos
 	// Second line: stats
 	var statsLine string
 	spin := loadingFrames[m.spinnerFrame%len(loadingFrames)]
-	if acc.Status == "connecting" {
+	if acc.Status == "connecting" && acc.Step != "" {
+		statsLine = "  connecting: " + acc.Step + " " + spin
+	} else if acc.Status == "connecting" {
 		statsLine = "  connecting " + spin + "..."
 	} else if acc.Status == "disconnecting" {
 		statsLine = "  disconnecting " + spin + "..."
@@ -1041,6 +1077,12 @@ func (m Model) renderAccountWithIndicator(acc AccountDisplay, selected bool, pos
 		if acc.OMEMO {
 			statsLine += " · OMEMO"
 		}
+	} else if acc.Status == "failed" && acc.Failure != "" {
+		statsLine = "  failed: " + acc.Failure
+		// Kept to one line in a narrow sidebar
+		if max := m.width - 2; max > 1 && len(statsLine) > max {
+			statsLine = statsLine[:max-1] + "…"
+		}
 	} else if acc.Status == "failed" {
 		statsLine = "  connection failed"
 	} else {
@@ -1079,105 +1121,85 @@ func (m Model) renderAccountWithIndicator(acc AccountDisplay, selected bool, pos
 	return result
 }
 
-// renderRoster renders a single roster entry line
-func (m Model) renderRoster(r Roster, selected bool) string {
-	// Presence indicator
-	var presenceStyle lipgloss.Style
-	var indicator string
-
-	// If status is hidden, show "?" indicator in gray
-	if r.StatusHidden {
-		presenceStyle = m.styles.RosterContact.Foreground(lipgloss.Color("242"))
-		indicator = "?"
-	} else {
-		switch r.Status {
-		case "online":
-			presenceStyle = m.styles.PresenceOnline
-			indicator = "●"
-		case "away":
-			presenceStyle = m.styles.PresenceAway
-			indicator = "◐"
-		case "dnd":
-			presenceStyle = m.styles.PresenceDND
-			indicator = "⊘"
-		case "xa":
-			presenceStyle = m.styles.PresenceXA
-			indicator = "◯"
-		default:
-			presenceStyle = m.styles.PresenceOffline
-			indicator = "○"
-		}
-	}
-
-	presence := presenceStyle.Render(indicator)
+// renderRoster renders a single roster entry line with the columns picked
+// by SetColumns. The name and status message share what the other columns
+// leave, the status message being cut first.
+func (m Model) renderRoster(r Roster, selected, marked bool) string {
+	columns := m.rowColumns()
 
 	// Roster entry name
 	name := r.Name
 	if name == "" {
 		name = r.JID
 	}
+	status := statusText(r)
 
-	sourceTagText := "[ROSTER]"
-	sourceTagStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("35"))
-	if !r.AddedToRoster {
-		sourceTagText = "[INCOMING]"
-		sourceTagStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
-	}
-	sourceTag := sourceTagStyle.Render(sourceTagText)
-	favoriteSymbol := "☆"
-	favoriteStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("242"))
-	if r.Favorite {
-		favoriteSymbol = "★"
-		favoriteStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("220"))
-	}
-	favoriteTag := favoriteStyle.Render(favoriteSymbol)
-
-	// Build status suffix for non-online entries with status message
-	statusSuffix := ""
-	if r.StatusHidden {
-		// Show "(hidden)" for entries where status is not shared
-		statusSuffix = " (hidden)"
-	} else if r.StatusMsg != "" && r.Status != "online" {
-		// Show abbreviated status with message: "(away: lunch)"
-		shortStatus := r.Status
-		if r.Status == "offline" {
-			shortStatus = "off"
-		}
-		statusSuffix = fmt.Sprintf(" (%s: %s)", shortStatus, r.StatusMsg)
-	} else if r.StatusMsg != "" && r.Status == "online" {
-		// Online with status message: just show the message
-		statusSuffix = fmt.Sprintf(" (%s)", r.StatusMsg)
-	}
-	// Unread indicator
-	unread := ""
-	if r.Unread > 0 {
-		unread = fmt.Sprintf(" [%d]", r.Unread)
-	}
-
-	// Calculate available width for name + status
-	// Format: " ● name (status) [N]"
-	// Reserve: indicator + source-tag + padding + unread length
-	unreadLen := len(unread)
-	sourceTagLen := len(sourceTagText) + 1
-	favoriteLen := 2                                                 // symbol + following space
-	maxWidth := m.width - 5 - unreadLen - sourceTagLen - favoriteLen // presence + tags + padding + unread
+	// While filtering, show where an entry matched if not in its name
+	query := ""
+	if m.filterMode {
+		query = strings.ToLower(m.filterQuery)
+		hint := ""
+		if query != "" && !strings.Contains(strings.ToLower(name), query) {
+			if strings.Contains(strings.ToLower(r.JID), query) {
+				hint = r.JID
+			} else if g := matchedGroup(r, query); g != "" {
+				hint = "[" + g + "]"
+			}
+		}
+		if hint != "" {
+			status = hint
+			if !m.ShowsColumn(ColumnStatus) {
+				columns = withStatusAfterName(columns)
+			}
+		}
+	}
+	if !containsColumn(columns, ColumnStatus) {
+		status = ""
+	}
+
+	// Calculate available width for name + status: the mark, then every
+	// other column with the space before it
+	fixed := make(map[string]string)
+	used := 1
+	for _, c := range columns {
+		if c == ColumnName || c == ColumnStatus {
+			continue
+		}
+		if rendered := m.renderColumn(c, r); rendered != "" {
+			fixed[c] = rendered
+			used += lipgloss.Width(rendered) + 1
+		}
+	}
+	maxWidth := m.width - 2 - used
 	if maxWidth < 5 {
 		maxWidth = 5
 	}
 
-	// Combine name and status suffix, then truncate if needed
-	displayText := name + statusSuffix
-	if len(displayText) > maxWidth {
-		// Truncate, preferring to keep the name
-		if len(name) >= maxWidth {
-			displayText = name[:maxWidth-1] + "…"
+	// Truncate if needed, preferring to keep the name
+	if lipgloss.Width(name) >= maxWidth {
+		name = truncateText(name, maxWidth)
+		status = ""
+	} else if status != "" {
+		remaining := maxWidth - lipgloss.Width(name) - 1
+		if remaining > 3 {
+			status = truncateText(status, remaining)
 		} else {
-			// Truncate status message
-			remaining := maxWidth - len(name) - 1
-			if remaining > 3 && len(statusSuffix) > 0 {
-				displayText = name + statusSuffix[:remaining] + "…"
-			} else {
-				displayText = name
+			status = ""
+		}
+	}
+
+	parts := make([]string, 0, len(columns))
+	for _, c := range columns {
+		switch c {
+		case ColumnName:
+			parts = append(parts, highlightMatch(name, query))
+		case ColumnStatus:
+			if status != "" {
+				parts = append(parts, highlightMatch(status, query))
+			}
+		default:
+			if rendered := fixed[c]; rendered != "" {
+				parts = append(parts, rendered)
 			}
 		}
 	}
@@ -1190,14 +1212,11 @@ func (m Model) renderRoster(r Roster, selected bool) string {
 		style = m.styles.RosterContact
 	}
 
-	// Build the content
-	var content string
-	favoritePrefix := favoriteTag + " "
-	if r.Unread > 0 {
-		content = fmt.Sprintf(" %s %s %s%s%s", presence, sourceTag, favoritePrefix, displayText, m.styles.RosterUnread.Render(unread))
-	} else {
-		content = fmt.Sprintf(" %s %s %s%s", presence, sourceTag, favoritePrefix, displayText)
+	markPrefix := " "
+	if marked {
+		markPrefix = lipgloss.NewStyle().Foreground(lipgloss.Color("214")).Bold(true).Render("+")
 	}
+	content := markPrefix + strings.Join(parts, " ")
 
 	return style.Width(m.width - 2).Render(content)
 }
@@ -0,0 +1,86 @@
+package roster
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Where a search query matched an entry, best first
+const (
+	matchNamePrefix = iota
+	matchJIDPrefix
+	matchNameWord
+	matchName
+	matchJID
+	matchGroup
+	noMatch
+)
+
+// matchEntry ranks how well query matches an entry's name (its roster
+// alias), JID or groups. query must be lower case.
+func matchEntry(r Roster, query string) int {
+	if query == "" {
+		return matchNamePrefix
+	}
+	name := strings.ToLower(r.Name)
+	jid := strings.ToLower(r.JID)
+
+	switch {
+	case name != "" && strings.HasPrefix(name, query):
+		return matchNamePrefix
+	case strings.HasPrefix(jid, query):
+		return matchJIDPrefix
+	case strings.Contains(name, " "+query):
+		return matchNameWord
+	case strings.Contains(name, query):
+		return matchName
+	case strings.Contains(jid, query):
+		return matchJID
+	}
+	for _, g := range r.Groups {
+		if strings.Contains(strings.ToLower(g), query) {
+			return matchGroup
+		}
+	}
+	return noMatch
+}
+
+// matchedGroup returns the first group of an entry containing query
+func matchedGroup(r Roster, query string) string {
+	for _, g := range r.Groups {
+		if strings.Contains(strings.ToLower(g), query) {
+			return g
+		}
+	}
+	return ""
+}
+
+// highlightMatch renders text with the first case-insensitive occurrence of
+// query emphasised. Runes are compared one by one, as lowering the whole
+// text can change its length in bytes.
+func highlightMatch(text, query string) string {
+	q := []rune(strings.ToLower(query))
+	if len(q) == 0 {
+		return text
+	}
+	runes := []rune(text)
+	for i := 0; i+len(q) <= len(runes); i++ {
+		if runesFold(runes[i:i+len(q)], q) {
+			hl := lipgloss.NewStyle().Bold(true).Underline(true).Foreground(lipgloss.Color("220"))
+			return string(runes[:i]) + hl.Render(string(runes[i:i+len(q)])) + string(runes[i+len(q):])
+		}
+	}
+	return text
+}
+
+// runesFold reports whether runes lowered equal the lower case query
+func runesFold(runes, query []rune) bool {
+	for i, r := range runes {
+		if unicode.ToLower(r) != query[i] {
+			return false
+		}
+	}
+	return true
+}
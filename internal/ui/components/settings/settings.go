@@ -7,6 +7,7 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/meszmate/roster/internal/config"
+	"github.com/meszmate/roster/internal/ui/components/roster"
 	"github.com/meszmate/roster/internal/ui/theme"
 )
 
@@ -124,6 +125,34 @@ func (m *Model) loadSettings() {
 				Type:        SettingBool,
 				Value:       m.cfg.Storage.SaveWindowState,
 			},
+			{
+				Key:         "track_presence_history",
+				Label:       "Track Presence History",
+				Description: "Record when contacts come online to show their usual hours (off deletes history)",
+				Type:        SettingBool,
+				Value:       m.cfg.Storage.TrackPresenceHistory,
+			},
+			{
+				Key:         "chat_logs",
+				Label:       "Plain Text Logs",
+				Description: "Also append conversations to text files under logs/chats, one per day",
+				Type:        SettingBool,
+				Value:       m.cfg.ChatLogs.Enabled,
+			},
+			{
+				Key:         "version_reply",
+				Label:       "Tell Client Version",
+				Description: "Answer contacts who ask which client you use (off refuses them)",
+				Type:        SettingBool,
+				Value:       m.cfg.SoftwareVersion.Reply,
+			},
+			{
+				Key:         "version_show_os",
+				Label:       "Tell Operating System",
+				Description: "Include your operating system in that answer",
+				Type:        SettingBool,
+				Value:       m.cfg.SoftwareVersion.ShowOS,
+			},
 		}
 
 	case SectionUI:
@@ -163,10 +192,17 @@ func (m *Model) loadSettings() {
 			{
 				Key:         "time_format",
 				Label:       "Time Format",
-				Description: "Format for timestamps (Go time format)",
+				Description: "24h, 12h, relative, or a Go layout like 15:04",
 				Type:        SettingString,
 				Value:       m.cfg.UI.TimeFormat,
 			},
+			{
+				Key:         "date_format",
+				Label:       "Date Format",
+				Description: "iso, us, eu, or a Go layout like 2006-01-02",
+				Type:        SettingString,
+				Value:       m.cfg.UI.DateFormat,
+			},
 			{
 				Key:         "notifications",
 				Label:       "Desktop Notifications",
@@ -174,6 +210,124 @@ func (m *Model) loadSettings() {
 				Type:        SettingBool,
 				Value:       m.cfg.UI.Notifications,
 			},
+			{
+				Key:         "message_styling",
+				Label:       "Message Styling",
+				Description: "Send markdown as XEP-0393 styling",
+				Type:        SettingBool,
+				Value:       m.cfg.UI.MessageStyling,
+			},
+			{
+				Key:         "window_list",
+				Label:       "Window List",
+				Description: "How open windows are listed",
+				Type:        SettingSelect,
+				Value:       m.cfg.UI.WindowList,
+				Options:     []string{"full", "numbers", "neighbors", "unread", "tabbar"},
+			},
+			{
+				Key:         "window_order",
+				Label:       "Window Order",
+				Description: "Keep windows fixed or sort by activity",
+				Type:        SettingSelect,
+				Value:       m.cfg.UI.WindowOrder,
+				Options:     []string{"fixed", "activity"},
+			},
+			{
+				Key:         "roster_sort",
+				Label:       "Roster Sort",
+				Description: "Order contacts by recent chats or presence",
+				Type:        SettingSelect,
+				Value:       m.cfg.UI.RosterSort,
+				Options:     []string{"recent", "presence"},
+			},
+			{
+				Key:         "roster_group",
+				Label:       "Roster Grouping",
+				Description: "List contacts flat, under their roster groups or by server domain",
+				Type:        SettingSelect,
+				Value:       rosterGroupValue(m.cfg.UI.RosterGroup),
+				Options:     []string{"none", "groups", "domain"},
+			},
+			{
+				Key:         "chat_layout",
+				Label:       "Chat Layout",
+				Description: "Compact lines, cozy groups or bubbles, or the theme's layout",
+				Type:        SettingSelect,
+				Value:       chatLayoutValue(m.cfg.UI.ChatLayout),
+				Options:     []string{"theme", "compact", "cozy", "bubble"},
+			},
+			{
+				Key:         "chat_wrap",
+				Label:       "Message Wrapping",
+				Description: "Indent continuation lines under the body or start them flush left",
+				Type:        SettingSelect,
+				Value:       m.cfg.UI.ChatWrap,
+				Options:     []string{"indent", "flush"},
+			},
+			{
+				Key:         "chat_max_width",
+				Label:       "Max Message Width",
+				Description: "Columns a message body may use, 0 for the whole pane",
+				Type:        SettingNumber,
+				Value:       m.cfg.UI.ChatMaxWidth,
+				Min:         0,
+				Max:         400,
+			},
+			{
+				Key:         "nick_column",
+				Label:       "Nick Column",
+				Description: "Align nicks to this width, 0 disables alignment",
+				Type:        SettingNumber,
+				Value:       m.cfg.UI.NickColumn,
+				Min:         0,
+				Max:         40,
+			},
+			{
+				Key:         "low_bandwidth",
+				Label:       "Low Bandwidth",
+				Description: "Redraw less often and stop animations, for slow SSH links",
+				Type:        SettingBool,
+				Value:       m.cfg.UI.LowBandwidth,
+			},
+			{
+				Key:         "clipboard",
+				Label:       "Clipboard",
+				Description: "Copy through the terminal (OSC 52), with clipboard tools, or both",
+				Type:        SettingSelect,
+				Value:       clipboardValue(m.cfg.UI.Clipboard),
+				Options:     []string{"auto", "osc52", "external"},
+			},
+			{
+				Key:         "terminal_title",
+				Label:       "Terminal Title",
+				Description: "Show the active conversation and unread count in the terminal title",
+				Type:        SettingBool,
+				Value:       m.cfg.UI.TerminalTitle,
+			},
+			{
+				Key:         "multiplexer_bell",
+				Label:       "Multiplexer Bell",
+				Description: "Ring the bell on new messages inside tmux or screen, so their status bar flags roster",
+				Type:        SettingBool,
+				Value:       m.cfg.UI.MultiplexerBell,
+			},
+			{
+				Key:         "away_on_blur",
+				Label:       "Away On Blur",
+				Description: "Minutes without terminal focus before going away, 0 disables",
+				Type:        SettingNumber,
+				Value:       m.cfg.UI.AwayOnBlur,
+				Min:         0,
+				Max:         240,
+			},
+			{
+				Key:         "roster_columns",
+				Label:       "Roster Columns",
+				Description: "Comma-separated parts of a roster row in order: presence, avatar, source, favorite, name, status, unread, account, lock, activity",
+				Type:        SettingString,
+				Value:       strings.Join(roster.ParseColumns(m.cfg.UI.RosterColumns), ","),
+			},
 		}
 
 	case SectionEncryption:
@@ -428,6 +582,31 @@ func (m Model) handleEditMode(msg tea.KeyMsg) (Model, tea.Cmd) {
 	return m, nil
 }
 
+// chatLayoutValue shows an unset chat layout as the theme's
+func chatLayoutValue(layout string) string {
+	if layout == "" {
+		return "theme"
+	}
+	return layout
+}
+
+// clipboardValue shows an unset clipboard backend as auto
+func clipboardValue(clipboard string) string {
+	if clipboard == "" {
+		return "auto"
+	}
+	return clipboard
+}
+
+// rosterGroupValue shows configs written before roster grouping existed as
+// a flat list
+func rosterGroupValue(mode string) string {
+	if mode == "" {
+		return "none"
+	}
+	return mode
+}
+
 // applyChange applies a setting change to the config
 func (m *Model) applyChange(setting *Setting) {
 	switch setting.Key {
@@ -442,8 +621,43 @@ func (m *Model) applyChange(setting *Setting) {
 		m.cfg.UI.ShowTimestamps = setting.Value.(bool)
 	case "time_format":
 		m.cfg.UI.TimeFormat = setting.Value.(string)
+	case "date_format":
+		m.cfg.UI.DateFormat = setting.Value.(string)
 	case "notifications":
 		m.cfg.UI.Notifications = setting.Value.(bool)
+	case "message_styling":
+		m.cfg.UI.MessageStyling = setting.Value.(bool)
+	case "window_list":
+		m.cfg.UI.WindowList = setting.Value.(string)
+	case "window_order":
+		m.cfg.UI.WindowOrder = setting.Value.(string)
+	case "roster_sort":
+		m.cfg.UI.RosterSort = setting.Value.(string)
+	case "roster_group":
+		m.cfg.UI.RosterGroup = setting.Value.(string)
+	case "chat_layout":
+		m.cfg.UI.ChatLayout = setting.Value.(string)
+		if m.cfg.UI.ChatLayout == "theme" {
+			m.cfg.UI.ChatLayout = ""
+		}
+	case "chat_wrap":
+		m.cfg.UI.ChatWrap = setting.Value.(string)
+	case "chat_max_width":
+		m.cfg.UI.ChatMaxWidth = setting.Value.(int)
+	case "nick_column":
+		m.cfg.UI.NickColumn = setting.Value.(int)
+	case "low_bandwidth":
+		m.cfg.UI.LowBandwidth = setting.Value.(bool)
+	case "clipboard":
+		m.cfg.UI.Clipboard = setting.Value.(string)
+	case "terminal_title":
+		m.cfg.UI.TerminalTitle = setting.Value.(bool)
+	case "multiplexer_bell":
+		m.cfg.UI.MultiplexerBell = setting.Value.(bool)
+	case "away_on_blur":
+		m.cfg.UI.AwayOnBlur = setting.Value.(int)
+	case "roster_columns":
+		m.cfg.UI.RosterColumns = roster.ParseColumns(strings.Split(setting.Value.(string), ","))
 
 	// Encryption
 	case "default_encryption":
@@ -472,6 +686,14 @@ func (m *Model) applyChange(setting *Setting) {
 		m.cfg.Storage.SaveMessages = setting.Value.(bool)
 	case "save_window_state":
 		m.cfg.Storage.SaveWindowState = setting.Value.(bool)
+	case "track_presence_history":
+		m.cfg.Storage.TrackPresenceHistory = setting.Value.(bool)
+	case "chat_logs":
+		m.cfg.ChatLogs.Enabled = setting.Value.(bool)
+	case "version_reply":
+		m.cfg.SoftwareVersion.Reply = setting.Value.(bool)
+	case "version_show_os":
+		m.cfg.SoftwareVersion.ShowOS = setting.Value.(bool)
 	}
 }
 
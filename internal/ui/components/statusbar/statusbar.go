@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-runewidth"
 	"github.com/meszmate/roster/internal/ui/keybindings"
 	"github.com/meszmate/roster/internal/ui/theme"
 )
@@ -32,8 +33,34 @@ type Model struct {
 	syncProgress  string
 	rosterLoading bool
 	rosterSpinner string
+	recording     string
+	windowList    string
+	items         []Item
 }
 
+// Item is a plugin item shown on the right of the status bar
+type Item struct {
+	ID       string
+	Text     string
+	MaxWidth int // in cells
+}
+
+// Window list display modes
+const (
+	WindowListFull      = "full"      // number and title of every window
+	WindowListNumbers   = "numbers"   // window numbers only
+	WindowListNeighbors = "neighbors" // the active window and two on each side
+	WindowListUnread    = "unread"    // the active window and windows with unread messages
+	WindowListTabBar    = "tabbar"    // a separate tab bar line above the main area
+)
+
+// WindowListModes lists the valid window list display modes
+var WindowListModes = []string{WindowListFull, WindowListNumbers, WindowListNeighbors, WindowListUnread, WindowListTabBar}
+
+// neighborWindows is how many windows are shown on each side of the active
+// one in neighbors mode
+const neighborWindows = 2
+
 // New creates a new status bar model
 func New(styles *theme.Styles) Model {
 	return Model{
@@ -91,6 +118,17 @@ func (m Model) SetWindowAccount(acc string) Model {
 	return m
 }
 
+// SetWindowListMode sets how the window list is displayed
+func (m Model) SetWindowListMode(mode string) Model {
+	m.windowList = mode
+	return m
+}
+
+// HasTabBar reports whether the window list is shown as a separate tab bar
+func (m Model) HasTabBar() bool {
+	return m.windowList == WindowListTabBar
+}
+
 // SetSyncing sets the MAM sync state
 func (m Model) SetSyncing(syncing bool, progress string) Model {
 	m.syncing = syncing
@@ -105,12 +143,59 @@ func (m Model) SetRosterLoading(loading bool, spinner string) Model {
 	return m
 }
 
+// SetRecording sets the macro register being recorded ("" when not recording)
+func (m Model) SetRecording(reg string) Model {
+	m.recording = reg
+	return m
+}
+
+// SetItems sets the plugin items, in display order
+func (m Model) SetItems(items []Item) Model {
+	m.items = items
+	return m
+}
+
+// ItemAt returns the id of the plugin item at column x, or ""
+func (m Model) ItemAt(x int) string {
+	_, _, spans := m.layout()
+	for _, span := range spans {
+		if x >= span.from && x < span.to {
+			return span.id
+		}
+	}
+	return ""
+}
+
+// itemSpan is where a plugin item is drawn
+type itemSpan struct {
+	id       string
+	from, to int
+}
+
 // View renders the status bar
 func (m Model) View() string {
 	if m.width == 0 {
 		return ""
 	}
 
+	left, right, _ := m.layout()
+
+	// Calculate padding
+	padding := m.width - lipgloss.Width(left) - lipgloss.Width(right)
+	if padding < 0 {
+		padding = 0
+	}
+
+	// Combine
+	result := left + strings.Repeat(" ", padding) + right
+
+	return m.styles.StatusBar.Width(m.width).Render(result)
+}
+
+// layout builds the left and right parts of the status bar and where the
+// plugin items in the right part are. Items that do not fit are dropped,
+// lowest priority first.
+func (m Model) layout() (string, string, []itemSpan) {
 	// Mode indicator
 	var modeStyle lipgloss.Style
 	switch m.mode {
@@ -127,6 +212,9 @@ func (m Model) View() string {
 	}
 
 	modeText := modeStyle.Render(m.mode.String())
+	if m.recording != "" {
+		modeText += m.styles.PresenceDND.Render(" recording @" + m.recording)
+	}
 
 	// Extra info (like encryption status, typing, etc.)
 	extra := ""
@@ -152,33 +240,8 @@ func (m Model) View() string {
 
 	// Window indicators
 	var windowsStr string
-	if len(m.windows) > 0 {
-		var parts []string
-		for _, w := range m.windows {
-			label := fmt.Sprintf("%d", w.Num)
-			if w.Title != "" && w.Title != "Console" {
-				// Shorten title
-				title := w.Title
-				if len(title) > 10 {
-					title = title[:10]
-				}
-				label = fmt.Sprintf("%d:%s", w.Num, title)
-			}
-
-			if w.Unread > 0 {
-				label = fmt.Sprintf("%s(%d)", label, w.Unread)
-			}
-
-			if w.Active {
-				label = m.styles.StatusModeInsert.Render(label)
-			} else if w.Unread > 0 {
-				label = m.styles.PresenceAway.Render(label)
-			} else {
-				label = m.styles.StatusAccount.Render(label)
-			}
-			parts = append(parts, label)
-		}
-		windowsStr = "[" + strings.Join(parts, " ") + "]"
+	if m.windowList != WindowListTabBar {
+		windowsStr = m.windowIndicators()
 	}
 
 	// Build account section only if an account is active
@@ -223,14 +286,164 @@ func (m Model) View() string {
 	left := fmt.Sprintf(" %s%s%s%s", modeText, accountSection, syncIndicator, rosterIndicator)
 	right := windowsStr + extra + " "
 
-	// Calculate padding
-	padding := m.width - lipgloss.Width(left) - lipgloss.Width(right)
-	if padding < 0 {
-		padding = 0
+	// Plugin items go before the window list, as many as fit
+	var labels []string
+	var ids []string
+	for _, item := range m.items {
+		text := strings.Join(strings.Fields(item.Text), " ")
+		if text == "" {
+			continue
+		}
+		if item.MaxWidth > 0 && runewidth.StringWidth(text) > item.MaxWidth {
+			text = runewidth.Truncate(text, item.MaxWidth, "…")
+		}
+		labels = append(labels, "["+text+"] ")
+		ids = append(ids, item.ID)
+	}
+	used := lipgloss.Width(left) + lipgloss.Width(right)
+	for len(labels) > 0 && used+lipgloss.Width(strings.Join(labels, "")) > m.width {
+		labels = labels[:len(labels)-1]
 	}
 
-	// Combine
-	result := left + strings.Repeat(" ", padding) + right
+	var spans []itemSpan
+	pos := m.width - lipgloss.Width(right) - lipgloss.Width(strings.Join(labels, ""))
+	for i, label := range labels {
+		w := lipgloss.Width(label)
+		spans = append(spans, itemSpan{id: ids[i], from: pos, to: pos + w - 1})
+		pos += w
+	}
+	right = m.styles.StatusAccount.Render(strings.Join(labels, "")) + right
 
-	return m.styles.StatusBar.Width(m.width).Render(result)
+	return left, right, spans
+}
+
+// windowLabel renders a window indicator, with the title unless numbersOnly
+func (m Model) windowLabel(w WindowInfo, numbersOnly bool) string {
+	label := fmt.Sprintf("%d", w.Num)
+	if !numbersOnly && w.Title != "" && w.Title != "Console" {
+		// Shorten title
+		title := w.Title
+		if len(title) > 10 {
+			title = title[:10]
+		}
+		label = fmt.Sprintf("%d:%s", w.Num, title)
+	}
+
+	if w.Unread > 0 {
+		label = fmt.Sprintf("%s(%d)", label, w.Unread)
+	}
+
+	if w.Active {
+		return m.styles.StatusModeInsert.Render(label)
+	} else if w.Unread > 0 {
+		return m.styles.PresenceAway.Render(label)
+	}
+	return m.styles.StatusAccount.Render(label)
+}
+
+// windowIndicators renders the status bar window list for the display mode
+func (m Model) windowIndicators() string {
+	if len(m.windows) == 0 {
+		return ""
+	}
+
+	active := 0
+	for i, w := range m.windows {
+		if w.Active {
+			active = i
+			break
+		}
+	}
+
+	var parts []string
+	switch m.windowList {
+	case WindowListNumbers:
+		for _, w := range m.windows {
+			parts = append(parts, m.windowLabel(w, true))
+		}
+	case WindowListNeighbors:
+		from, to := active-neighborWindows, active+neighborWindows
+		if from < 0 {
+			from = 0
+		}
+		if to > len(m.windows)-1 {
+			to = len(m.windows) - 1
+		}
+		if from > 0 {
+			parts = append(parts, m.hiddenWindows(m.windows[:from], "«"))
+		}
+		for _, w := range m.windows[from : to+1] {
+			parts = append(parts, m.windowLabel(w, false))
+		}
+		if to < len(m.windows)-1 {
+			parts = append(parts, m.hiddenWindows(m.windows[to+1:], "»"))
+		}
+	case WindowListUnread:
+		for _, w := range m.windows {
+			if w.Active || w.Unread > 0 {
+				parts = append(parts, m.windowLabel(w, false))
+			}
+		}
+	default:
+		for _, w := range m.windows {
+			parts = append(parts, m.windowLabel(w, false))
+		}
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+// hiddenWindows renders the marker for windows left out in neighbors mode,
+// highlighted when any of them has unread messages
+func (m Model) hiddenWindows(hidden []WindowInfo, arrow string) string {
+	for _, w := range hidden {
+		if w.Unread > 0 {
+			return m.styles.PresenceAway.Render(arrow + "*")
+		}
+	}
+	return m.styles.StatusAccount.Render(arrow)
+}
+
+// TabBar renders the window list as a full width line of tabs. When the
+// tabs do not fit, windows furthest from the active one are dropped.
+func (m Model) TabBar() string {
+	if m.width == 0 || len(m.windows) == 0 {
+		return ""
+	}
+
+	active := 0
+	tabs := make([]string, len(m.windows))
+	for i, w := range m.windows {
+		if w.Active {
+			active = i
+		}
+		tabs[i] = " " + m.windowLabel(w, false) + " "
+	}
+
+	from, to := active, active+1
+	used := lipgloss.Width(tabs[active])
+	for {
+		grew := false
+		if to < len(tabs) && used+lipgloss.Width(tabs[to])+1 <= m.width {
+			used += lipgloss.Width(tabs[to])
+			to++
+			grew = true
+		}
+		if from > 0 && used+lipgloss.Width(tabs[from-1])+1 <= m.width {
+			used += lipgloss.Width(tabs[from-1])
+			from--
+			grew = true
+		}
+		if !grew {
+			break
+		}
+	}
+
+	bar := strings.Join(tabs[from:to], "")
+	if from > 0 {
+		bar = "«" + bar
+	}
+	if to < len(tabs) {
+		bar += "»"
+	}
+	return m.styles.StatusBar.Width(m.width).Render(bar)
 }
@@ -20,6 +20,7 @@ type Window struct {
 	Type       WindowType
 	JID        string
 	Title      string
+	Name       string // Set with :title, shown instead of Title
 	Unread     int
 	Active     bool
 	AccountJID string // Which account this window uses
@@ -193,6 +194,17 @@ func (m Model) OpenMUCWithAccount(roomJID, nick, accountJID string) Model {
 	return m
 }
 
+// MarkMUC makes the window of a room a MUC window, for rooms whose window
+// was opened by a message
+func (m Model) MarkMUC(roomJID, accountJID string) Model {
+	for i, w := range m.windows {
+		if w.JID == roomJID && (accountJID == "" || w.AccountJID == "" || w.AccountJID == accountJID) {
+			m.windows[i].Type = WindowMUC
+		}
+	}
+	return m
+}
+
 // CloseActive closes the active window
 func (m Model) CloseActive() Model {
 	if m.active == 0 {
@@ -316,6 +328,88 @@ func (m Model) ClearUnread(id int) Model {
 	return m
 }
 
+// ClearUnreadFor clears the unread count of the chat window of a JID on an
+// account, or of an unbound window of that JID
+func (m Model) ClearUnreadFor(jid, accountJID string) Model {
+	for i, w := range m.windows {
+		if w.JID == jid && (w.AccountJID == accountJID || w.AccountJID == "") {
+			m.windows[i].Unread = 0
+		}
+	}
+	return m
+}
+
+// SetActiveName renames the active window. An empty name restores the
+// title derived from the JID. The console cannot be renamed.
+func (m Model) SetActiveName(name string) Model {
+	if m.active > 0 && m.active < len(m.windows) {
+		m.windows[m.active].Name = name
+	}
+	return m
+}
+
+// SetName renames a window by JID
+func (m Model) SetName(jid, name string) Model {
+	for i, w := range m.windows {
+		if w.JID == jid && i > 0 {
+			m.windows[i].Name = name
+			break
+		}
+	}
+	return m
+}
+
+// MoveActive swaps the active window with its neighbour, delta -1 for left
+// and 1 for right. The console always stays first.
+func (m Model) MoveActive(delta int) (Model, bool) {
+	target := m.active + delta
+	if m.active <= 0 || target <= 0 || target >= len(m.windows) {
+		return m, false
+	}
+
+	windows := make([]Window, len(m.windows))
+	copy(windows, m.windows)
+	windows[m.active], windows[target] = windows[target], windows[m.active]
+	for i := range windows {
+		windows[i].ID = i
+	}
+	m.windows = windows
+	m.active = target
+	return m, true
+}
+
+// Bump moves a chat window right after the console, keeping the same window
+// active, so windows are ordered by most recent activity
+func (m Model) Bump(jid, accountJID string) Model {
+	from := -1
+	for i, w := range m.windows {
+		if i > 0 && w.JID == jid && (accountJID == "" || w.AccountJID == "" || w.AccountJID == accountJID) {
+			from = i
+			break
+		}
+	}
+	if from <= 1 {
+		return m
+	}
+
+	windows := make([]Window, 0, len(m.windows))
+	windows = append(windows, m.windows[0], m.windows[from])
+	windows = append(windows, m.windows[1:from]...)
+	windows = append(windows, m.windows[from+1:]...)
+	for i := range windows {
+		windows[i].ID = i
+	}
+	m.windows = windows
+
+	switch {
+	case m.active == from:
+		m.active = 1
+	case m.active >= 1 && m.active < from:
+		m.active++
+	}
+	return m
+}
+
 // GetWindows returns all windows
 func (m Model) GetWindows() []Window {
 	return m.windows
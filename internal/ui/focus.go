@@ -0,0 +1,34 @@
+package ui
+
+import (
+	"strconv"
+	"strings"
+)
+
+// terminalFocused marks the open conversation read once the terminal has
+// focus again and says what was held back meanwhile
+func (m *Model) terminalFocused() {
+	ret := m.app.TerminalFocused()
+
+	if jid := m.windows.ActiveJID(); jid != "" {
+		m.windows = m.windows.ClearUnread(m.windows.ActiveNum())
+		if accountJID := m.rosterAccountJID(); accountJID != "" {
+			m.app.ClearContactUnread(accountJID, jid)
+			if roomJID := m.activeRoomJID(); roomJID != "" {
+				m.app.MarkRoomSeen(accountJID, roomJID)
+			}
+			m.refreshRosterContacts()
+		}
+	}
+
+	var notes []string
+	if ret.Messages > 0 {
+		notes = append(notes, strconv.Itoa(ret.Messages)+" more messages while away, from "+strings.Join(ret.From, ", "))
+	}
+	if ret.WasAway {
+		notes = append(notes, "presence back from away")
+	}
+	if len(notes) > 0 {
+		m.chat = m.chat.SetStatusMsg(strings.Join(notes, "; "))
+	}
+}
@@ -0,0 +1,119 @@
+package ui
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/meszmate/roster/internal/ui/keybindings"
+)
+
+// Every message makes Bubble Tea render a whole frame, and rendering the
+// roster and chat panes means wrapping and styling every visible message.
+// Keystrokes that only edit the composer or the command line leave those
+// panes as they were, so their last rendering is reused; over a slow SSH
+// link this keeps typing from lagging behind. Anything else redraws them.
+
+// LowBandwidthFPS caps the frame rate in low bandwidth mode (ui.low_bandwidth),
+// where the renderer coalesces the updates between frames into one redraw.
+// That mode also stops the spinners, which otherwise redraw several times
+// a second while anything is loading.
+const LowBandwidthFPS = 10
+
+// frameCache keeps the rendered main area between frames. It is shared by
+// the copies of the model, which is passed by value.
+type frameCache struct {
+	main   string
+	width  int
+	height int
+	valid  bool
+}
+
+// lookup returns the cached main area if it is still valid for the size
+func (f *frameCache) lookup(width, height int) (string, bool) {
+	if f == nil || !f.valid || f.width != width || f.height != height {
+		return "", false
+	}
+	return f.main, true
+}
+
+// store keeps a rendered main area
+func (f *frameCache) store(main string, width, height int) {
+	if f == nil {
+		return
+	}
+	f.main, f.width, f.height, f.valid = main, width, height, true
+}
+
+func (f *frameCache) invalidate() {
+	if f != nil {
+		f.valid = false
+	}
+}
+
+// frameState is what the main area looks like apart from the components'
+// own state
+type frameState struct {
+	focus        Focus
+	mode         keybindings.Mode
+	viewMode     ViewMode
+	showRoster   bool
+	showSettings bool
+	width        int
+	height       int
+}
+
+func (m Model) frameState() frameState {
+	return frameState{
+		focus:        m.focus,
+		mode:         m.keys.Mode(),
+		viewMode:     m.viewMode,
+		showRoster:   m.showRoster,
+		showSettings: m.showSettings,
+		width:        m.width,
+		height:       m.height,
+	}
+}
+
+// Update handles a message and keeps the cached main area only when the
+// message was a keystroke that just edited the input line
+func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	before := m.frameState()
+	editing := m.editingKey(msg)
+	updated, cmd := m.update(msg)
+	um, ok := updated.(Model)
+	if !ok {
+		return updated, cmd
+	}
+	if !editing || um.frameState() != before {
+		um.frame.invalidate()
+	}
+	if title := um.updateTitle(); title != nil {
+		cmd = tea.Batch(cmd, title)
+	}
+	return updated, cmd
+}
+
+// editingKey reports whether a message is a key that goes to the composer
+// or the command line without touching anything else
+func (m Model) editingKey(msg tea.Msg) bool {
+	key, ok := msg.(tea.KeyMsg)
+	if !ok || m.dialog.Active() || m.showSettings || m.macroPending != keybindings.ActionNone {
+		return false
+	}
+	switch {
+	case m.focus == FocusChat && m.keys.Mode() == keybindings.ModeInsert:
+	case m.focus == FocusCommandLine && (m.keys.Mode() == keybindings.ModeCommand || m.keys.Mode() == keybindings.ModeSearch):
+	default:
+		return false
+	}
+	switch key.Type {
+	case tea.KeyRunes, tea.KeySpace, tea.KeyBackspace, tea.KeyDelete,
+		tea.KeyLeft, tea.KeyRight, tea.KeyHome, tea.KeyEnd:
+		return true
+	}
+	return false
+}
+
+// animate reports whether spinners should move
+func (m Model) animate() bool {
+	return !m.app.Config().UI.LowBandwidth
+}
@@ -0,0 +1,279 @@
+package keybindings
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// actionHelp describes an action for the help dialog
+type actionHelp struct {
+	group string
+	desc  string
+}
+
+// Help groups of normal mode, in the order they are shown
+const (
+	groupMovement = "Movement"
+	groupModes    = "Modes"
+	groupWindows  = "Windows"
+	groupFocus    = "Focus and Layout"
+	groupRoster   = "Roster"
+	groupDetails  = "Roster Details"
+	groupAccounts = "Account Actions (in accounts section)"
+	groupRooms    = "Rooms"
+	groupChat     = "Chat"
+	groupEditing  = "Editing"
+	groupMacros   = "Macros"
+	groupGeneral  = "General"
+)
+
+var helpGroupOrder = []string{
+	groupMovement, groupModes, groupWindows, groupFocus, groupRoster, groupDetails,
+	groupAccounts, groupRooms, groupChat, groupEditing, groupMacros, groupGeneral,
+}
+
+var actionHelps = map[Action]actionHelp{
+	ActionNone: {desc: "none"},
+
+	ActionMoveUp:       {groupMovement, "move up"},
+	ActionMoveDown:     {groupMovement, "move down"},
+	ActionMoveLeft:     {groupMovement, "move left"},
+	ActionMoveRight:    {groupMovement, "move right"},
+	ActionMoveTop:      {groupMovement, "move to top"},
+	ActionMoveBottom:   {groupMovement, "move to bottom"},
+	ActionPageUp:       {groupMovement, "page up"},
+	ActionPageDown:     {groupMovement, "page down"},
+	ActionHalfPageUp:   {groupMovement, "half page up"},
+	ActionHalfPageDown: {groupMovement, "half page down"},
+	ActionScrollUp:     {groupMovement, "scroll up"},
+	ActionScrollDown:   {groupMovement, "scroll down"},
+	ActionSearchNext:   {groupMovement, "next search result"},
+	ActionSearchPrev:   {groupMovement, "previous search result"},
+	ActionClearSearch:  {groupMovement, "clear search"},
+	ActionMark:         {groupMovement, "set mark"},
+	ActionJumpToMark:   {groupMovement, "jump to mark"},
+
+	ActionEnterInsert:          {groupModes, "enter insert mode"},
+	ActionEnterInsertAfter:     {groupModes, "enter insert mode after cursor"},
+	ActionEnterInsertLineStart: {groupModes, "enter insert mode at line start"},
+	ActionEnterInsertLineEnd:   {groupModes, "enter insert mode at line end"},
+	ActionEnterCommand:         {groupModes, "enter command mode"},
+	ActionEnterSearch:          {groupModes, "search"},
+	ActionEnterSearchBackward:  {groupModes, "search backward"},
+	ActionExitMode:             {groupModes, "exit mode"},
+
+	ActionOpenChat:        {groupWindows, "open chat"},
+	ActionOpenChatNew:     {groupWindows, "open chat in new window"},
+	ActionCloseChat:       {groupWindows, "close chat"},
+	ActionNextWindow:      {groupWindows, "next window"},
+	ActionPrevWindow:      {groupWindows, "previous window"},
+	ActionSaveWindows:     {groupWindows, "save windows"},
+	ActionMoveWindowLeft:  {groupWindows, "move window left"},
+	ActionMoveWindowRight: {groupWindows, "move window right"},
+
+	ActionToggleRoster:      {groupFocus, "toggle roster"},
+	ActionRosterNarrower:    {groupFocus, "narrow roster"},
+	ActionRosterWider:       {groupFocus, "widen roster"},
+	ActionFocusRoster:       {groupFocus, "focus roster"},
+	ActionFocusChat:         {groupFocus, "focus chat"},
+	ActionFocusAccounts:     {groupFocus, "focus accounts"},
+	ActionFocusInput:        {groupFocus, "focus input"},
+	ActionToggleAccountList: {groupFocus, "toggle account list"},
+	ActionNextAccount:       {groupFocus, "next connected account ({count} picks one)"},
+	ActionPrevAccount:       {groupFocus, "previous connected account"},
+	ActionFocusHeader:       {groupFocus, "focus chat header actions"},
+
+	ActionAddContact:          {groupRoster, "add contact"},
+	ActionAddSelectedToRoster: {groupRoster, "add selected to roster"},
+	ActionToggleFavorite:      {groupRoster, "toggle favorite"},
+	ActionRemoveContact:       {groupRoster, "remove from roster"},
+	ActionRenameContact:       {groupRoster, "rename roster entry"},
+	ActionShowInfo:            {groupRoster, "show info"},
+	ActionShowDetails:         {groupRoster, "show details"},
+	ActionSearchContacts:      {groupRoster, "filter contacts"},
+	ActionShowContextHelp:     {groupRoster, "context help popup"},
+	ActionVisualSelect:        {groupRoster, "mark a range of contacts (space marks one)"},
+	ActionQuickActions:        {groupRoster, "actions menu for the selected contact"},
+
+	ActionToggleStatusSharing: {groupDetails, "toggle status sharing"},
+	ActionVerifyFingerprint:   {groupDetails, "verify fingerprint"},
+	ActionPingContact:         {groupDetails, "ping the contact's online clients"},
+	ActionDismissDeviceAlert:  {groupDetails, "dismiss new-device banner"},
+	ActionSetWindowAccount:    {groupDetails, "bind account to window"},
+
+	ActionAccountConnect:    {groupAccounts, "connect account"},
+	ActionAccountDisconnect: {groupAccounts, "disconnect account"},
+	ActionAccountRemove:     {groupAccounts, "remove account"},
+	ActionAccountEdit:       {groupAccounts, "edit account"},
+	ActionToggleAutoConnect: {groupAccounts, "toggle auto-connect"},
+	ActionCycleArchive:      {groupAccounts, "cycle server archiving (always, roster, never)"},
+	ActionShowPrivacy:       {groupAccounts, "privacy dashboard: what the account shares"},
+	ActionExportAccounts:    {groupAccounts, "export accounts"},
+	ActionImportAccounts:    {groupAccounts, "import accounts"},
+
+	ActionJoinRoom:         {groupRooms, "join room"},
+	ActionLeaveRoom:        {groupRooms, "leave room"},
+	ActionCreateRoom:       {groupRooms, "create room"},
+	ActionShowParticipants: {groupRooms, "toggle room nick list"},
+	ActionShowBookmarks:    {groupRooms, "bookmarks"},
+	ActionJumpToNew:        {groupRooms, "first new message"},
+	ActionNextMention:      {groupRooms, "next mention"},
+
+	ActionSendMessage:     {groupChat, "send message"},
+	ActionNewLine:         {groupChat, "new line"},
+	ActionCycleEncryption: {groupChat, "cycle encryption"},
+	ActionHistoryPrev:     {groupChat, "previous sent message"},
+	ActionHistoryNext:     {groupChat, "next sent message"},
+	ActionCorrectMessage:  {groupChat, "correct last message"},
+	ActionAddReaction:     {groupChat, "add reaction"},
+	ActionStarMessage:     {groupChat, "star/unstar selected message"},
+	ActionUploadFile:      {groupChat, "upload file"},
+	ActionOpenFileURL:     {groupChat, "open file URL or xmpp: link"},
+	ActionCopyFileURL:     {groupChat, "copy file URL"},
+	ActionCopyJSON:        {groupChat, "copy JSON payload"},
+
+	ActionDeleteChar:      {groupEditing, "delete character"},
+	ActionDeleteWord:      {groupEditing, "delete word"},
+	ActionDeleteLine:      {groupEditing, "delete line"},
+	ActionUndo:            {groupEditing, "undo"},
+	ActionRedo:            {groupEditing, "redo"},
+	ActionYank:            {groupEditing, "copy message or fingerprint"},
+	ActionPaste:           {groupEditing, "paste"},
+	ActionExecuteCommand:  {groupEditing, "execute"},
+	ActionCancelCommand:   {groupEditing, "cancel"},
+	ActionCompleteCommand: {groupEditing, "complete command"},
+
+	ActionRecordMacro: {groupMacros, "record macro into register (again to stop)"},
+	ActionPlayMacro:   {groupMacros, "replay register (@@ repeats last)"},
+
+	ActionSelect:       {groupGeneral, "select"},
+	ActionToggleHelp:   {groupGeneral, "help"},
+	ActionRefresh:      {groupGeneral, "refresh"},
+	ActionShowSettings: {groupGeneral, "settings"},
+	ActionSetStatus:    {groupGeneral, "set status"},
+	ActionQuit:         {groupGeneral, "quit"},
+}
+
+// describeAction returns the help entry of an action
+func describeAction(action Action) (actionHelp, bool) {
+	if action >= ActionWindow1 && action <= ActionWindow20 {
+		return actionHelp{groupWindows, fmt.Sprintf("window %d", action-ActionWindow1+1)}, true
+	}
+	help, ok := actionHelps[action]
+	return help, ok
+}
+
+// HelpEntry is an action with the keys currently bound to it
+type HelpEntry struct {
+	Keys        []string
+	Description string
+	Disabled    bool // the action is unavailable, see SetDisabled
+}
+
+// HelpSection is a titled group of help entries
+type HelpSection struct {
+	Title   string
+	Entries []HelpEntry
+}
+
+// Help lists the current bindings grouped by context: normal mode by what
+// the actions act on, then one section per other mode
+func (m *Manager) Help() []HelpSection {
+	var sections []HelpSection
+
+	normal := m.helpEntries(ModeNormal)
+	for _, group := range helpGroupOrder {
+		if entries := normal[group]; len(entries) > 0 {
+			sections = append(sections, HelpSection{Title: group, Entries: entries})
+		}
+	}
+
+	for _, mode := range []Mode{ModeInsert, ModeCommand, ModeSearch} {
+		var entries []HelpEntry
+		grouped := m.helpEntries(mode)
+		for _, group := range helpGroupOrder {
+			entries = append(entries, grouped[group]...)
+		}
+		if len(entries) > 0 {
+			sections = append(sections, HelpSection{Title: modeTitle(mode), Entries: entries})
+		}
+	}
+	return sections
+}
+
+func modeTitle(mode Mode) string {
+	switch mode {
+	case ModeInsert:
+		return "Insert Mode"
+	case ModeCommand:
+		return "Command Mode"
+	case ModeSearch:
+		return "Search Mode"
+	}
+	return "Normal Mode"
+}
+
+// helpEntries collects the keys bound to each action of a mode, grouped by
+// help group and in action declaration order
+func (m *Manager) helpEntries(mode Mode) map[string][]HelpEntry {
+	keysByAction := make(map[Action][]string)
+	for key, action := range m.bindings[mode] {
+		if action == ActionNone {
+			continue
+		}
+		keysByAction[action] = append(keysByAction[action], key)
+	}
+
+	actions := make([]Action, 0, len(keysByAction))
+	for action := range keysByAction {
+		actions = append(actions, action)
+	}
+	sort.Slice(actions, func(i, j int) bool { return actions[i] < actions[j] })
+
+	grouped := make(map[string][]HelpEntry)
+	for _, action := range actions {
+		help, ok := describeAction(action)
+		if !ok {
+			help = actionHelp{group: groupGeneral, desc: "unknown"}
+		}
+		keys := keysByAction[action]
+		sort.Slice(keys, func(i, j int) bool {
+			if len(keys[i]) != len(keys[j]) {
+				return len(keys[i]) < len(keys[j])
+			}
+			return keys[i] < keys[j]
+		})
+		grouped[help.group] = append(grouped[help.group], HelpEntry{Keys: keys, Description: help.desc, Disabled: m.disabled[action]})
+	}
+	return grouped
+}
+
+// PendingKeys returns the keys typed so far of an unfinished multi-key binding
+func (m *Manager) PendingKeys() string {
+	return m.pendingKeys
+}
+
+// Continuations lists the bindings of the current mode that start with
+// prefix, keyed by the keys still to be typed
+func (m *Manager) Continuations(prefix string) []HelpEntry {
+	var entries []HelpEntry
+	for key, action := range m.bindings[m.mode] {
+		if prefix == "" || key == prefix || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		entries = append(entries, HelpEntry{
+			Keys:        []string{strings.TrimPrefix(key, prefix)},
+			Description: ActionName(action),
+			Disabled:    m.disabled[action],
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].Keys[0], entries[j].Keys[0]
+		if !strings.EqualFold(a, b) {
+			return strings.ToLower(a) < strings.ToLower(b)
+		}
+		return a > b // lowercase before uppercase
+	})
+	return entries
+}
@@ -110,6 +110,8 @@ const (
 
 	// UI
 	ActionToggleRoster
+	ActionRosterNarrower
+	ActionRosterWider
 	ActionToggleHelp
 	ActionFocusRoster
 	ActionFocusChat
@@ -120,6 +122,8 @@ const (
 	ActionSendMessage
 	ActionNewLine
 	ActionCycleEncryption
+	ActionHistoryPrev
+	ActionHistoryNext
 
 	// Roster
 	ActionAddContact
@@ -145,6 +149,8 @@ const (
 
 	// Window management
 	ActionSaveWindows
+	ActionMoveWindowLeft
+	ActionMoveWindowRight
 
 	// Focus
 	ActionFocusAccounts
@@ -163,6 +169,8 @@ const (
 	// Detail view actions
 	ActionShowDetails
 	ActionToggleAutoConnect
+	ActionCycleArchive
+	ActionShowPrivacy
 
 	// Multi-account window binding
 	ActionSetWindowAccount
@@ -175,6 +183,7 @@ const (
 
 	// Fingerprint verification
 	ActionVerifyFingerprint
+	ActionPingContact
 
 	// Chat header focus
 	ActionFocusHeader
@@ -182,12 +191,35 @@ const (
 	// File handling
 	ActionOpenFileURL
 	ActionCopyFileURL
+	ActionCopyJSON
 	ActionCorrectMessage
 	ActionAddReaction
+	ActionStarMessage
 	ActionUploadFile
 	ActionSearchContacts
 	ActionExportAccounts
 	ActionImportAccounts
+
+	// OMEMO new-device banner
+	ActionDismissDeviceAlert
+
+	// Room activity summary
+	ActionJumpToNew
+	ActionNextMention
+
+	// Macros
+	ActionRecordMacro
+	ActionPlayMacro
+
+	// Bulk roster selection
+	ActionVisualSelect
+
+	// Account switching
+	ActionNextAccount
+	ActionPrevAccount
+
+	// Roster entry actions menu
+	ActionQuickActions
 )
 
 // KeyBinding represents a key binding
@@ -207,6 +239,15 @@ type Manager struct {
 	marks          map[rune]int
 	count          int
 	countBuffer    string
+
+	// Macro recording
+	recordingReg rune
+	recordBuf    []tea.KeyMsg
+	macros       map[rune][]tea.KeyMsg
+	lastMacro    rune
+
+	// Actions turned off, e.g. by policy, shown greyed out in help
+	disabled map[Action]bool
 }
 
 // NewManager creates a new keybinding manager
@@ -215,6 +256,7 @@ func NewManager() *Manager {
 		mode:     ModeNormal,
 		bindings: make(map[Mode]map[string]Action),
 		marks:    make(map[rune]int),
+		macros:   make(map[rune][]tea.KeyMsg),
 	}
 	m.setupDefaultBindings()
 	return m
@@ -292,6 +334,8 @@ func (m *Manager) setupDefaultBindings() {
 
 		// UI
 		"ctrl+r": ActionToggleRoster,
+		"<":      ActionRosterNarrower,
+		">":      ActionRosterWider,
 		"ctrl+h": ActionToggleHelp,
 		"ctrl+l": ActionRefresh,
 		"ctrl+c": ActionQuit,
@@ -306,6 +350,8 @@ func (m *Manager) setupDefaultBindings() {
 		"p":       ActionPaste,
 		"m":       ActionMark,
 		"'":       ActionJumpToMark,
+		"Q":       ActionRecordMacro, // Q<reg> starts recording, Q stops ('q' closes chats)
+		"@":       ActionPlayMacro,   // @<reg> replays, @@ repeats the last macro
 
 		// Roster actions (avoiding ctrl+a for tmux users)
 		"ga": ActionAddContact,     // 'g' prefix + 'a' for add
@@ -316,14 +362,19 @@ func (m *Manager) setupDefaultBindings() {
 		"f":  ActionToggleFavorite, // Toggle favorite on selected contact
 		"F":  ActionToggleFavorite, // Toggle favorite on selected contact
 		"gF": ActionToggleFavorite, // Alternative favorite toggle
+		"V":  ActionVisualSelect,   // Mark a range of contacts for :bulk
+		".":  ActionQuickActions,   // Menu of what can be done with the selected contact
 
 		// MUC (avoiding ctrl conflicts for tmux)
 		"gj": ActionJoinRoom,         // 'g' prefix + 'j' for join
 		"gC": ActionCreateRoom,       // 'g' prefix + 'C' for create room
 		"gp": ActionShowParticipants, // 'g' prefix + 'p' for participants
 		"gb": ActionShowBookmarks,    // 'g' prefix + 'b' for bookmarks
+		"gn": ActionJumpToNew,        // 'g' prefix + 'n' for first new room message
+		"gm": ActionNextMention,      // 'g' prefix + 'm' for next mention
 		"cc": ActionCorrectMessage,   // 'c' prefix + 'c' for correct last message
 		"cr": ActionAddReaction,      // 'c' prefix + 'r' for add reaction
+		"cs": ActionStarMessage,      // 'c' prefix + 's' for star selected message
 		"cf": ActionUploadFile,       // 'c' prefix + 'f' for upload file
 		"gf": ActionSearchContacts,   // 'g' prefix + 'f' for filter/search contacts
 		"ge": ActionExportAccounts,   // 'g' prefix + 'e' for export accounts
@@ -335,13 +386,17 @@ func (m *Manager) setupDefaultBindings() {
 		"Gs": ActionSetStatus, // 'G' prefix + 's' for set status
 
 		// Window management
-		"gw": ActionSaveWindows, // 'g' prefix + 'w' for save windows
+		"gw": ActionSaveWindows,     // 'g' prefix + 'w' for save windows
+		"g<": ActionMoveWindowLeft,  // Swap active window with the one on its left
+		"g>": ActionMoveWindowRight, // Swap active window with the one on its right
 
 		// Focus keybindings
 		"gr": ActionFocusRoster,       // 'g' prefix + 'r' for roster focus
 		"gc": ActionFocusChat,         // 'g' prefix + 'c' for chat focus
 		"gA": ActionFocusAccounts,     // 'g' prefix + 'A' for accounts focus
 		"gl": ActionToggleAccountList, // 'g' prefix + 'l' for account list toggle
+		"gu": ActionNextAccount,       // 'g' prefix + 'u' for next user account ({count}gu picks one)
+		"gU": ActionPrevAccount,       // 'g' prefix + 'U' for previous user account
 
 		// Context help
 		"H": ActionShowContextHelp, // Show context-sensitive help/info popup
@@ -353,15 +408,21 @@ func (m *Manager) setupDefaultBindings() {
 		"X": ActionAccountRemove,     // Remove selected account (with confirmation)
 		"E": ActionAccountEdit,       // Edit selected account
 		"T": ActionToggleAutoConnect, // Toggle auto-connect for selected account
+		"M": ActionCycleArchive,      // Cycle what the server archives of the selected account
+
+		// Privacy dashboard of the selected account
+		"gP": ActionShowPrivacy,
 
 		// Multi-account window binding
-		"space": ActionSetWindowAccount, // Bind selected account to current window
+		"space": ActionSetWindowAccount, // Bind selected account to current window, or mark the selected contact
 
 		// Status sharing (in contact details)
 		"s": ActionToggleStatusSharing, // Toggle status sharing for contact
 
 		// Fingerprint verification (in contact details)
-		"v": ActionVerifyFingerprint, // Verify fingerprint
+		"v":  ActionVerifyFingerprint,  // Verify fingerprint
+		"P":  ActionPingContact,        // Ping the contact's online clients
+		"gD": ActionDismissDeviceAlert, // Dismiss new OMEMO device banner
 
 		// Chat header focus
 		"gh": ActionFocusHeader, // Focus chat header for contact actions
@@ -369,6 +430,7 @@ func (m *Manager) setupDefaultBindings() {
 		// File handling (in chat view)
 		"go": ActionOpenFileURL, // Open selected file URL
 		"gO": ActionCopyFileURL, // Copy file URL to clipboard
+		"gY": ActionCopyJSON,    // Copy JSON payload to clipboard
 	}
 
 	// Insert mode bindings
@@ -378,8 +440,10 @@ func (m *Manager) setupDefaultBindings() {
 		"enter":       ActionSendMessage,
 		"shift+enter": ActionNewLine,
 		"ctrl+e":      ActionCycleEncryption,
-		"up":          ActionMoveUp,
-		"down":        ActionMoveDown,
+		"up":          ActionHistoryPrev,
+		"down":        ActionHistoryNext,
+		"pgup":        ActionHalfPageUp,
+		"pgdown":      ActionHalfPageDown,
 		"ctrl+u":      ActionDeleteLine,
 		"ctrl+w":      ActionDeleteWord,
 		"ctrl+h":      ActionDeleteChar,
@@ -422,6 +486,11 @@ func (m *Manager) SetMode(mode Mode) {
 	m.count = 0
 }
 
+// HasCount reports whether the current action was given a count prefix
+func (m *Manager) HasCount() bool {
+	return m.count > 0
+}
+
 // Count returns the current count prefix (for commands like 5j)
 func (m *Manager) Count() int {
 	if m.count == 0 {
@@ -430,6 +499,31 @@ func (m *Manager) Count() int {
 	return m.count
 }
 
+// KeyFor returns the shortest normal mode key bound to an action, or ""
+// when it has none
+func (m *Manager) KeyFor(action Action) string {
+	key := ""
+	for k, a := range m.bindings[ModeNormal] {
+		if a == action && (key == "" || len(k) < len(key) || (len(k) == len(key) && k < key)) {
+			key = k
+		}
+	}
+	return key
+}
+
+// SetDisabled replaces the actions shown as unavailable
+func (m *Manager) SetDisabled(actions ...Action) {
+	m.disabled = make(map[Action]bool, len(actions))
+	for _, action := range actions {
+		m.disabled[action] = true
+	}
+}
+
+// Disabled reports whether an action is unavailable
+func (m *Manager) Disabled(action Action) bool {
+	return m.disabled[action]
+}
+
 // SearchQuery returns the current search query
 func (m *Manager) SearchQuery() string {
 	return m.searchQuery
@@ -457,9 +551,11 @@ func (m *Manager) HandleKey(msg tea.KeyMsg) Action {
 		}
 	}
 
-	// Parse count buffer
+	// Parse count buffer; a new sequence without one drops the last count
 	if m.countBuffer != "" {
 		m.count = parseInt(m.countBuffer)
+	} else if m.pendingKeys == "" {
+		m.count = 0
 	}
 
 	// Check for multi-key bindings (like gg, ZZ)
@@ -634,33 +730,8 @@ func parseInt(s string) int {
 
 // ActionName returns a human-readable name for an action
 func ActionName(action Action) string {
-	names := map[Action]string{
-		ActionNone:                "none",
-		ActionMoveUp:              "move up",
-		ActionMoveDown:            "move down",
-		ActionMoveLeft:            "move left",
-		ActionMoveRight:           "move right",
-		ActionMoveTop:             "move to top",
-		ActionMoveBottom:          "move to bottom",
-		ActionPageUp:              "page up",
-		ActionPageDown:            "page down",
-		ActionHalfPageUp:          "half page up",
-		ActionHalfPageDown:        "half page down",
-		ActionEnterInsert:         "enter insert mode",
-		ActionEnterCommand:        "enter command mode",
-		ActionEnterSearch:         "search",
-		ActionExitMode:            "exit mode",
-		ActionOpenChat:            "open chat",
-		ActionOpenChatNew:         "open chat in new window",
-		ActionCloseChat:           "close chat",
-		ActionAddContact:          "add contact",
-		ActionAddSelectedToRoster: "add selected to roster",
-		ActionToggleFavorite:      "toggle favorite",
-		ActionSendMessage:         "send message",
-		ActionQuit:                "quit",
-	}
-	if name, ok := names[action]; ok {
-		return name
+	if help, ok := describeAction(action); ok {
+		return help.desc
 	}
 	return "unknown"
 }
@@ -0,0 +1,63 @@
+package keybindings
+
+import tea "github.com/charmbracelet/bubbletea"
+
+// maxMacroKeys bounds a single recording so a forgotten recorder can't grow forever
+const maxMacroKeys = 1000
+
+// IsMacroRegister reports whether key names a valid macro register (a-z, 0-9)
+func IsMacroRegister(key string) bool {
+	if len(key) != 1 {
+		return false
+	}
+	c := key[0]
+	return (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')
+}
+
+// StartRecording begins recording keys into the given register
+func (m *Manager) StartRecording(reg rune) {
+	m.recordingReg = reg
+	m.recordBuf = nil
+}
+
+// StopRecording stores the recorded keys in the register. The last recorded
+// key is dropped since it is the key that stopped the recording.
+func (m *Manager) StopRecording() {
+	if m.recordingReg == 0 {
+		return
+	}
+	keys := m.recordBuf
+	if len(keys) > 0 {
+		keys = keys[:len(keys)-1]
+	}
+	m.macros[m.recordingReg] = keys
+	m.recordingReg = 0
+	m.recordBuf = nil
+}
+
+// Recording returns the register being recorded into, if any
+func (m *Manager) Recording() (rune, bool) {
+	return m.recordingReg, m.recordingReg != 0
+}
+
+// RecordKey appends a key to the active recording
+func (m *Manager) RecordKey(msg tea.KeyMsg) {
+	if m.recordingReg == 0 || len(m.recordBuf) >= maxMacroKeys {
+		return
+	}
+	m.recordBuf = append(m.recordBuf, msg)
+}
+
+// Macro returns the keys stored in a register. "@" refers to the register
+// that was replayed last.
+func (m *Manager) Macro(reg rune) ([]tea.KeyMsg, rune) {
+	if reg == '@' {
+		reg = m.lastMacro
+	}
+	keys, ok := m.macros[reg]
+	if !ok {
+		return nil, reg
+	}
+	m.lastMacro = reg
+	return keys, reg
+}
@@ -1,6 +1,7 @@
 package ui
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -8,6 +9,7 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -26,6 +28,7 @@ import (
 	"github.com/meszmate/roster/internal/ui/components/windows"
 	"github.com/meszmate/roster/internal/ui/keybindings"
 	"github.com/meszmate/roster/internal/ui/theme"
+	"github.com/meszmate/roster/pkg/plugin/manifest"
 )
 
 // Focus represents which component is focused
@@ -93,6 +96,60 @@ type Model struct {
 
 	// Roster loading state by account for sidebar indicator.
 	rosterLoadingByAccount map[string]bool
+
+	// Macro state: action waiting for a register key, repeat count, and
+	// whether keys are currently being replayed from a register.
+	macroPending   keybindings.Action
+	macroCount     int
+	replayingMacro bool
+
+	// Links whose previews should be loaded after this update
+	pendingPreviews []string
+
+	// The roster and chat panes as last rendered
+	frame *frameCache
+
+	// The terminal title as last set
+	title *titleState
+
+	// A plaintext message to an encrypted conversation, held until the
+	// downgrade is confirmed
+	pendingPlaintext *chat.SendMsg
+	pendingVoice     *app.VoiceRequest // voice request shown for approval
+	pendingResend    *app.DroppedRoomMessage
+
+	// Whether the roster/chat divider is being dragged with the mouse
+	draggingDivider bool
+
+	// Accounts read from another client, waiting for the import wizard
+	clientImport *app.ClientImport
+
+	// Account whose connection the loading dialog is showing
+	connectDialogJID string
+
+	// Startup connections still running, and how many there were and failed
+	autoConnectPending map[string]bool
+	autoConnectTotal   int
+	autoConnectFailed  int
+
+	// Prefix whose continuations the which-key popup lists, and a counter
+	// so only the timer of the latest key press opens it
+	whichKeyPrefix string
+	whichKeySeq    int
+
+	// xmpp: URI given on the command line, opened once the UI runs
+	startupURI string
+}
+
+// whichKeyMsg opens the which-key popup if prefix is still pending
+type whichKeyMsg struct {
+	prefix string
+	seq    int
+}
+
+// openURIMsg opens an xmpp: URI
+type openURIMsg struct {
+	uri string
 }
 
 type rosterSpinnerTickMsg struct{}
@@ -105,6 +162,11 @@ func rosterSpinnerTick() tea.Cmd {
 
 const localRosterOpKey = "__local__favorite_toggle__"
 
+// macroKeyMsg carries a key replayed from a macro register
+type macroKeyMsg struct {
+	key tea.KeyMsg
+}
+
 type favoriteToggleResultMsg struct {
 	AccountJID string
 	JID        string
@@ -123,6 +185,14 @@ func NewModel(application *app.App) Model {
 
 	keysManager := keybindings.NewManager()
 
+	// Plugins cannot take the names of built-in commands
+	cmdline := commandline.New(themeManager.Styles())
+	builtins := make([]string, 0, len(cmdline.GetCommands()))
+	for name := range cmdline.GetCommands() {
+		builtins = append(builtins, name)
+	}
+	application.ReserveCommands(builtins)
+
 	return Model{
 		app:                    application,
 		focus:                  FocusRoster,
@@ -130,27 +200,41 @@ func NewModel(application *app.App) Model {
 		keys:                   keysManager,
 		themes:                 themeManager,
 		roster:                 roster.New(themeManager.Styles()).SetContacts(nil),
-		chat:                   chat.New(themeManager.Styles()),
+		chat:                   chat.New(themeManager.Styles()).SetStorageBanner(application.StorageBanner()),
 		statusbar:              statusbar.New(themeManager.Styles()),
-		commandline:            commandline.New(themeManager.Styles()),
+		commandline:            cmdline,
 		windows:                windows.New(themeManager.Styles()),
 		dialog:                 dialogs.New(themeManager.Styles()),
 		settings:               settings.New(cfg, themeManager.Styles(), themeManager.AvailableThemes()),
 		muc:                    muc.New(themeManager.Styles()),
 		rosterLoadingByAccount: make(map[string]bool),
+		frame:                  &frameCache{},
+		title:                  &titleState{},
 	}
 }
 
 // Init initializes the model
 func (m Model) Init() tea.Cmd {
-	return tea.Batch(
+	cmds := []tea.Cmd{
 		tea.EnterAltScreen,
 		m.app.Init(),
-	)
+	}
+	if m.startupURI != "" {
+		uri := m.startupURI
+		cmds = append(cmds, func() tea.Msg { return openURIMsg{uri: uri} })
+	}
+	return tea.Batch(cmds...)
+}
+
+// WithStartupURI makes the model open an xmpp: URI when it starts, as if
+// it was clicked: a chat, a room join or an add contact dialog
+func (m Model) WithStartupURI(uri string) Model {
+	m.startupURI = uri
+	return m
 }
 
-// Update handles messages
-func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+// update handles messages
+func (m Model) update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmds []tea.Cmd
 
 	switch msg := msg.(type) {
@@ -160,13 +244,48 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.ready = true
 		m.updateComponentSizes()
 
+	case tea.MouseMsg:
+		m.handleMouse(msg)
+
+	case tea.FocusMsg:
+		m.terminalFocused()
+
+	case tea.BlurMsg:
+		m.app.TerminalBlurred()
+
+	case macroKeyMsg:
+		m.replayingMacro = true
+		updated, cmd := m.Update(msg.key)
+		um := updated.(Model)
+		um.replayingMacro = false
+		return um, cmd
+
+	case whichKeyMsg:
+		if msg.seq == m.whichKeySeq && m.keys.PendingKeys() == msg.prefix {
+			m.whichKeyPrefix = msg.prefix
+		}
+
 	case tea.KeyMsg:
+		// Any key closes the which-key popup
+		m.whichKeyPrefix = ""
+		m.whichKeySeq++
+		m.app.NoteUserInput()
+		m.syncPolicy()
+
 		// Handle quitting
 		if msg.Type == tea.KeyCtrlC {
 			m.quitting = true
 			return m, tea.Quit
 		}
 
+		// Register name after Q or @
+		if m.macroPending != keybindings.ActionNone {
+			return m, m.handleMacroRegister(msg)
+		}
+		if !m.replayingMacro {
+			m.keys.RecordKey(msg)
+		}
+
 		// Handle settings menu if active
 		if m.showSettings {
 			var cmd tea.Cmd
@@ -229,6 +348,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if cmd != nil {
 			cmds = append(cmds, cmd)
 		}
+		if cmd := m.scheduleWhichKey(); cmd != nil {
+			cmds = append(cmds, cmd)
+		}
 
 		// Don't pass the key to component if it was a mode-switching action
 		// (the key that triggered the mode switch shouldn't be typed)
@@ -270,7 +392,17 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case chat.SendMsg:
 		// User wants to send a message
-		if msg.To != "" && msg.Body != "" {
+		m.app.RememberSentInput(m.rosterAccountJID(), bareJID(msg.To), msg.Body)
+		if msg.To != "" && msg.Body != "" && m.app.VoiceBlocked(m.app.CurrentAccount(), bareJID(msg.To)) {
+			m.app.BlockSendWithoutVoice(m.app.CurrentAccount(), bareJID(msg.To), msg.Body)
+			m.dialog = m.dialog.ShowAskVoice(bareJID(msg.To))
+			m.focus = FocusDialog
+		} else if msg.To != "" && msg.Body != "" && m.app.PlaintextDowngrade(m.app.CurrentAccount(), bareJID(msg.To)) {
+			pending := msg
+			m.pendingPlaintext = &pending
+			m.dialog = m.dialog.ShowPlaintextConfirm(bareJID(msg.To))
+			m.focus = FocusDialog
+		} else if msg.To != "" && msg.Body != "" {
 			cmds = append(cmds, m.app.SendChatMessage(msg.To, msg.Body))
 			// Start spinner animation
 			cmds = append(cmds, chat.SpinnerTick())
@@ -278,21 +410,44 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case chat.SpinnerTickMsg:
 		// Forward spinner tick to chat for message status animation
-		var cmd tea.Cmd
-		m.chat, cmd = m.chat.Update(msg)
-		if cmd != nil {
-			cmds = append(cmds, cmd)
+		if m.animate() {
+			var cmd tea.Cmd
+			m.chat, cmd = m.chat.Update(msg)
+			if cmd != nil {
+				cmds = append(cmds, cmd)
+			}
 		}
 
 	case app.SendMessageResultMsg:
 		// Handle message send result
-		if !msg.Success {
+		if msg.Queued {
+			m.chat = m.chat.SetStatusMsg("Message queued (" + msg.Error + "), see :queue")
+		} else if !msg.Success {
+			m.chat = m.chat.SetStatusMsg("Failed to send: " + msg.Error)
+		}
+
+	case app.LinkPreviewMsg:
+		if msg.Error == "" {
+			m.chat = m.chat.SetLinkPreview(msg.Preview.URL, chat.LinkPreview{
+				Title:       msg.Preview.Title,
+				Description: msg.Preview.Description,
+			})
+		}
+
+	case app.QueueSendResultMsg:
+		if msg.Error != "" {
 			m.chat = m.chat.SetStatusMsg("Failed to send: " + msg.Error)
 		}
+		if m.dialog.Type() == dialogs.DialogQueue {
+			_, selected, _ := m.dialog.GetSelectedQueued()
+			m.showQueueDialog(selected)
+		}
 
 	case app.MessageStatusUpdateMsg:
 		// Update message status in chat (delivery/read receipt)
-		m.chat = m.chat.UpdateMessageStatus(msg.MessageID, chat.MessageStatus(msg.Status))
+		if msg.AccountJID == "" || msg.AccountJID == m.rosterAccountJID() {
+			m.chat = m.chat.UpdateMessageStatus(msg.MessageID, chat.MessageStatus(msg.Status))
+		}
 
 	case commandline.CommandMsg:
 		// Command executed
@@ -308,7 +463,62 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case app.CommandActionMsg:
 		// Handle command actions that need UI
-		m.handleCommandAction(msg)
+		switch msg.Action {
+		case app.ActionRunDiagnostics:
+			cmds = append(cmds, m.startDiagnostics(msg))
+		case app.ActionBrowseRooms:
+			cmds = append(cmds, m.startBrowseRooms(msg))
+		case app.ActionSoftwareVersion:
+			cmds = append(cmds, m.querySoftwareVersion(msg))
+		case app.ActionArchivePrefs:
+			cmds = append(cmds, m.archiveCommand(msg))
+		case app.ActionPrivacy:
+			cmds = append(cmds, m.privacyCommand())
+		case app.ActionPing:
+			target, _ := msg.Data["jid"].(string)
+			cmds = append(cmds, m.pingContact(target))
+		default:
+			m.handleCommandAction(msg)
+		}
+
+	case app.DiagnosticsMsg:
+		// Ignore reports of runs cancelled from the loading dialog
+		if m.dialog.IsLoading() && m.dialog.GetOperationType() == dialogs.OpDiagnostics {
+			m.app.CompleteOperation(dialogs.OpDiagnostics)
+			checks := make([]dialogs.DiagnosticInfo, 0, len(msg.Checks))
+			for _, c := range msg.Checks {
+				checks = append(checks, dialogs.DiagnosticInfo{Name: c.Name, Status: c.Status, Detail: c.Detail})
+			}
+			m.dialog = m.dialog.HideLoading()
+			m.dialog = m.dialog.ShowDiagnostics(msg.JID, checks)
+			m.focus = FocusDialog
+		}
+
+	case openURIMsg:
+		m.openXMPPURI(msg.uri)
+
+	case app.RoomListMsg:
+		// Ignore listings cancelled from the loading dialog
+		if m.dialog.IsLoading() && m.dialog.GetOperationType() == dialogs.OpBrowseRooms {
+			m.app.CompleteOperation(dialogs.OpBrowseRooms)
+			m.dialog = m.dialog.HideLoading()
+			if msg.Error != "" {
+				m.dialog = m.dialog.ShowError("Failed to list rooms: " + msg.Error)
+			} else {
+				rooms := make([]dialogs.RoomEntry, 0, len(msg.Rooms))
+				for _, r := range msg.Rooms {
+					rooms = append(rooms, dialogs.RoomEntry{
+						JID:         r.JID,
+						Name:        r.Name,
+						Description: r.Description,
+						Occupants:   r.Occupants,
+						Locked:      r.Password || r.MembersOnly,
+					})
+				}
+				m.dialog = m.dialog.ShowBrowseRooms(msg.Service, rooms)
+			}
+			m.focus = FocusDialog
+		}
 
 	case dialogs.DialogResult:
 		// Handle dialog results
@@ -316,13 +526,13 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case dialogs.SpinnerTickMsg:
 		// Forward spinner tick to dialog if loading
-		if m.dialog.IsLoading() {
+		if m.dialog.IsLoading() && m.animate() {
 			m.dialog = m.dialog.AdvanceSpinner()
 			cmds = append(cmds, dialogs.SpinnerTick())
 		}
 
 	case rosterSpinnerTickMsg:
-		if m.roster.IsLoading() {
+		if m.roster.IsLoading() && m.animate() {
 			m.roster = m.roster.AdvanceLoadingSpinner()
 			cmds = append(cmds, rosterSpinnerTick())
 		}
@@ -371,17 +581,69 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case app.ConnectingMsg:
 		// Show connecting status and start actual connection
 		m.chat = m.chat.SetStatusMsg("Connecting to " + msg.JID + "...")
-		cmds = append(cmds, m.app.DoConnect(msg.JID))
+		cmds = append(cmds, m.connectAccount(msg.JID))
+
+	case app.AutoConnectMsg:
+		m.autoConnectPending = make(map[string]bool, len(msg.JIDs))
+		m.autoConnectTotal = len(msg.JIDs)
+		m.autoConnectFailed = 0
+		for i, jid := range msg.JIDs {
+			m.autoConnectPending[jid] = true
+			var delay time.Duration
+			if i < len(msg.Delays) {
+				delay = msg.Delays[i]
+			}
+			cmds = append(cmds, m.app.ConnectAfter(jid, delay))
+		}
+		if len(msg.JIDs) == 1 {
+			m.chat = m.chat.SetStatusMsg("Connecting to " + msg.JIDs[0] + "...")
+		} else {
+			m.chat = m.chat.SetStatusMsg(fmt.Sprintf("Connecting %d accounts...", len(msg.JIDs)))
+		}
+		m.roster = m.roster.SetAccounts(m.getAccountDisplays())
 
 	case app.ConnectResultMsg:
+		if m.autoConnectPending[msg.JID] {
+			delete(m.autoConnectPending, msg.JID)
+			if !msg.Success && !msg.Cancelled {
+				m.autoConnectFailed++
+			}
+		}
+		if msg.JID == m.connectDialogJID {
+			if m.dialog.IsLoading() && m.dialog.GetOperationType() == dialogs.OpConnect {
+				m.dialog = m.dialog.HideLoading()
+				m.focus = FocusRoster
+			}
+			m.app.CompleteOperation(dialogs.OpConnect)
+			m.connectDialogJID = ""
+		}
 		// Handle connection result
-		if msg.Success {
+		if msg.Cancelled {
+			m.chat = m.chat.SetStatusMsg("Cancelled connecting to " + msg.JID)
+			m.roster = m.roster.SetAccounts(m.getAccountDisplays())
+		} else if msg.Background && m.autoConnectTotal > 0 {
+			// Startup connections report together; failures stay in the
+			// account list instead of stacking dialogs
+			m.chat = m.chat.SetStatusMsg(m.autoConnectProgress())
+			if len(m.autoConnectPending) == 0 {
+				m.autoConnectTotal = 0
+			}
+			m.roster = m.roster.SetAccounts(m.getAccountDisplays())
+		} else if msg.Success {
 			m.chat = m.chat.SetStatusMsg("Connected to " + msg.JID)
 			// Update roster to show new status
 			m.roster = m.roster.SetAccounts(m.getAccountDisplays())
+		} else if msg.Background {
+			m.chat = m.chat.SetStatusMsg("Reconnecting " + msg.JID + " failed: " + app.DescribeError(msg.Err).Title)
+			m.roster = m.roster.SetAccounts(m.getAccountDisplays())
 		} else {
 			m.chat = m.chat.SetStatusMsg("Connection failed: " + msg.Error)
-			m.dialog = m.dialog.ShowError("Connection failed: " + msg.Error)
+			if msg.Err != nil {
+				info := app.DescribeError(msg.Err)
+				m.dialog = m.dialog.ShowErrorDetails(info.Title, info.String(), info.Details)
+			} else {
+				m.dialog = m.dialog.ShowError("Connection failed: " + msg.Error)
+			}
 			m.focus = FocusDialog
 			// Update roster to show failed status
 			m.roster = m.roster.SetAccounts(m.getAccountDisplays())
@@ -397,6 +659,87 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		// Update roster to show new status
 		m.roster = m.roster.SetAccounts(m.getAccountDisplays())
 
+	case app.PingResultMsg:
+		m.chat = m.chat.SetStatusMsg("Ping " + msg.JID + ": " + msg.Describe())
+
+	case app.ArchivePrefsMsg:
+		m.handleArchivePrefs(msg)
+
+	case app.SoftwareVersionMsg:
+		if msg.Error != "" {
+			m.chat = m.chat.SetStatusMsg(msg.JID + " did not tell its client: " + msg.Error)
+		} else {
+			m.chat = m.chat.SetStatusMsg(msg.JID + " runs " + msg.Version.String())
+		}
+
+	case app.RosterConflictResolvedMsg:
+		m.refreshRosterContacts()
+		switch {
+		case msg.Error != "":
+			m.chat = m.chat.SetStatusMsg("Failed to keep your change of " + msg.JID + ": " + msg.Error)
+		case msg.Queued:
+			m.chat = m.chat.SetStatusMsg("Your change of " + msg.JID + " is sent when the account connects")
+		case msg.KeptMine:
+			m.chat = m.chat.SetStatusMsg("Kept your change of " + msg.JID)
+		default:
+			m.chat = m.chat.SetStatusMsg("Took the server's version of " + msg.JID)
+		}
+		if m.focus != FocusDialog {
+			m.showRosterConflict()
+		}
+
+	case app.BulkResultMsg:
+		m.roster = m.roster.ClearMarks()
+		m.refreshRosterContacts()
+		status := fmt.Sprintf("%s: done for %d", msg.Op, msg.Done)
+		if msg.Queued > 0 {
+			status += fmt.Sprintf(", %d queued until the account connects", msg.Queued)
+		}
+		if msg.Dir != "" {
+			status += ", exported to " + msg.Dir
+		}
+		if len(msg.Failed) > 0 {
+			status += fmt.Sprintf(", %d failed", len(msg.Failed))
+			m.dialog = m.dialog.ShowErrorWithTitle("Bulk "+string(msg.Op)+" failed for some contacts",
+				strings.Join(msg.Failed, "\n"))
+			m.focus = FocusDialog
+		}
+		m.chat = m.chat.SetStatusMsg(status)
+
+	case app.PluginListMsg:
+		if msg.Error != "" {
+			m.dialog = m.dialog.ShowError("Plugin index: " + msg.Error)
+			m.focus = FocusDialog
+			break
+		}
+		m.dialog = m.dialog.ShowContextHelp("Plugins", formatPluginList(msg.Plugins))
+		m.focus = FocusDialog
+
+	case app.PluginActionMsg:
+		if msg.Error != "" {
+			m.chat = m.chat.SetStatusMsg("Plugin " + msg.Action + " failed for " + msg.Name + ": " + msg.Error)
+			break
+		}
+		if len(msg.Consent) > 0 {
+			perms := make([]string, len(msg.Consent))
+			grants := make([]string, len(msg.Consent))
+			for i, p := range msg.Consent {
+				perms[i] = p.Description()
+				grants[i] = string(p)
+			}
+			m.dialog = m.dialog.ShowPluginConsent(msg.Name, msg.Version, perms, grants)
+			m.focus = FocusDialog
+			break
+		}
+		switch msg.Action {
+		case "install":
+			m.chat = m.chat.SetStatusMsg("Installed and enabled " + msg.Name + " " + msg.Version + ", restart roster to load it")
+		case "remove":
+			m.chat = m.chat.SetStatusMsg("Removed " + msg.Name)
+		default:
+			m.chat = m.chat.SetStatusMsg(msg.Name + " " + msg.Action + "d, restart roster to apply")
+		}
+
 	case app.AddContactResultMsg:
 		// Hide loading dialog if it was showing
 		if m.dialog.IsLoading() && m.dialog.GetOperationType() == dialogs.OpAddContact {
@@ -409,7 +752,11 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			if displayName == "" {
 				displayName = msg.JID
 			}
-			m.chat = m.chat.SetStatusMsg("Added to roster: " + displayName)
+			if msg.Queued {
+				m.chat = m.chat.SetStatusMsg("Added to roster: " + displayName + " (sent when the account connects)")
+			} else {
+				m.chat = m.chat.SetStatusMsg("Added to roster: " + displayName)
+			}
 			m.focus = FocusRoster
 			// Trigger roster refresh from server
 			cmds = append(cmds, m.app.RequestRosterRefreshForAccount(msg.AccountJID))
@@ -421,17 +768,14 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 
 	case settings.SaveMsg:
-		// Settings saved, apply theme change if needed
-		if err := m.themes.SetTheme(m.app.Config().UI.Theme); err == nil {
-			// Update all component styles
-			styles := m.themes.Styles()
-			m.roster = roster.New(styles).SetContacts(m.currentRosterContacts())
-			m.chat = chat.New(styles)
-			m.statusbar = statusbar.New(styles)
-			m.commandline = commandline.New(styles)
-			m.dialog = dialogs.New(styles)
-			m.updateComponentSizes()
+		if !m.app.Config().Storage.TrackPresenceHistory {
+			_ = m.app.ClearPresenceHistory()
 		}
+		m.app.ApplyChatLogs()
+		// Settings saved, apply theme change if needed
+		m.applyTheme()
+		m.updateComponentSizes()
+		m.refreshRosterContacts()
 
 	case settings.ConfirmSaveMessagesMsg:
 		// User wants to enable message saving - show confirmation dialog
@@ -498,10 +842,21 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	m.statusbar = m.statusbar.SetWindows(m.getWindowInfos())
 	m.statusbar = m.statusbar.SetWindowAccount(m.windows.GetActiveAccountJID())
 	m.statusbar = m.statusbar.SetRosterLoading(m.roster.IsLoading(), m.roster.LoadingFrame())
+	m.statusbar = m.statusbar.SetItems(m.statusItems())
+	if reg, ok := m.keys.Recording(); ok {
+		m.statusbar = m.statusbar.SetRecording(string(reg))
+	} else {
+		m.statusbar = m.statusbar.SetRecording("")
+	}
 
 	// Update roster with connected accounts
 	m.roster = m.roster.SetAccounts(m.getAccountDisplays())
 
+	for _, link := range m.pendingPreviews {
+		cmds = append(cmds, m.app.FetchLinkPreview(link))
+	}
+	m.pendingPreviews = nil
+
 	return m, tea.Batch(cmds...)
 }
 
@@ -520,79 +875,12 @@ func (m Model) View() string {
 	// Calculate dimensions
 	statusHeight := 1
 	cmdHeight := 1
-	mainHeight := m.height - statusHeight - cmdHeight
-
-	// Build main area
-	var mainView string
-	rosterWidth := m.app.Config().UI.RosterWidth
-	if !m.showRoster {
-		rosterWidth = 0
-	}
-	chatWidth := m.width - rosterWidth
-
-	if m.showRoster && rosterWidth > 0 {
-		rosterView := m.roster.View()
-
-		// Render chat view based on current view mode
-		var chatView string
-		switch m.viewMode {
-		case ViewModeAccountDetails:
-			// Render account details instead of chat
-			acc := m.getAccountDetailData(m.detailAccountJID)
-			chatView = m.chat.RenderAccountDetails(acc)
-		case ViewModeContactDetails:
-			// Render contact details instead of chat
-			contact := m.getContactDetailData(m.detailContactJID)
-			chatView = m.chat.RenderContactDetails(contact)
-		case ViewModeAccountEdit:
-			// Render account edit view
-			chatView = m.chat.RenderAccountEdit(m.accountEditData)
-		default:
-			chatView = m.chat.View()
-		}
-
-		// Apply focus styling using lipgloss.Place to avoid corrupting ANSI codes in long messages
-		rosterView = lipgloss.Place(rosterWidth-2, mainHeight-2, lipgloss.Left, lipgloss.Top, rosterView)
-		if m.focus == FocusRoster || m.focus == FocusAccounts {
-			rosterView = styles.WindowActive.Render(rosterView)
-		} else {
-			rosterView = styles.WindowInactive.Render(rosterView)
-		}
-
-		// Detail/edit views are not "focused" in the traditional sense, but still active
-		isDetailOrEditView := m.viewMode == ViewModeAccountDetails || m.viewMode == ViewModeContactDetails || m.viewMode == ViewModeAccountEdit
-		chatView = lipgloss.Place(chatWidth-2, mainHeight-2, lipgloss.Left, lipgloss.Top, chatView)
-		if m.focus == FocusChat || isDetailOrEditView {
-			chatView = styles.WindowActive.Render(chatView)
-		} else {
-			chatView = styles.WindowInactive.Render(chatView)
-		}
-
-		mainView = lipgloss.JoinHorizontal(lipgloss.Top, rosterView, chatView)
-	} else {
-		// Render chat view based on current view mode
-		var chatView string
-		switch m.viewMode {
-		case ViewModeAccountDetails:
-			acc := m.getAccountDetailData(m.detailAccountJID)
-			chatView = m.chat.RenderAccountDetails(acc)
-		case ViewModeContactDetails:
-			contact := m.getContactDetailData(m.detailContactJID)
-			chatView = m.chat.RenderContactDetails(contact)
-		case ViewModeAccountEdit:
-			chatView = m.chat.RenderAccountEdit(m.accountEditData)
-		default:
-			chatView = m.chat.View()
-		}
+	mainHeight := m.height - statusHeight - cmdHeight - m.tabBarHeight()
 
-		isDetailOrEditView := m.viewMode == ViewModeAccountDetails || m.viewMode == ViewModeContactDetails || m.viewMode == ViewModeAccountEdit
-		chatView = lipgloss.Place(m.width-2, mainHeight-2, lipgloss.Left, lipgloss.Top, chatView)
-		if m.focus == FocusChat || isDetailOrEditView {
-			chatView = styles.WindowActive.Render(chatView)
-		} else {
-			chatView = styles.WindowInactive.Render(chatView)
-		}
-		mainView = chatView
+	mainView, ok := m.frame.lookup(m.width, mainHeight)
+	if !ok {
+		mainView = m.mainView(styles, mainHeight)
+		m.frame.store(mainView, m.width, mainHeight)
 	}
 
 	// Build command/input line
@@ -614,6 +902,13 @@ func (m Model) View() string {
 		cmdView,
 		statusView,
 	)
+	if m.statusbar.HasTabBar() {
+		result = lipgloss.JoinVertical(lipgloss.Left, m.statusbar.TabBar(), result)
+	}
+
+	if m.whichKeyPrefix != "" {
+		result = m.overlayWhichKey(result)
+	}
 
 	// Overlay dialog if active
 	if m.dialog.Active() {
@@ -658,6 +953,11 @@ func (m *Model) handleAction(action keybindings.Action, msg tea.KeyMsg) tea.Cmd
 		m.commandline = m.commandline.Clear()
 
 	case keybindings.ActionEnterSearch:
+		// In the roster, / filters as you type
+		if m.focus == FocusRoster && m.roster.FocusSection() == roster.SectionContacts {
+			m.roster = m.roster.EnterFilterMode()
+			return nil
+		}
 		m.keys.SetMode(keybindings.ModeSearch)
 		m.focus = FocusCommandLine
 		m.commandline = m.commandline.SetPrefix("/")
@@ -678,6 +978,9 @@ func (m *Model) handleAction(action keybindings.Action, msg tea.KeyMsg) tea.Cmd
 			m.detailAccountJID = ""
 			m.detailContactJID = ""
 			m.focus = FocusRoster
+		} else if m.focus == FocusRoster && m.roster.HasMarks() {
+			m.roster = m.roster.ClearMarks()
+			m.chat = m.chat.SetStatusMsg("Marks cleared")
 		} else {
 			// Normal escape behavior
 			m.keys.SetMode(keybindings.ModeNormal)
@@ -860,13 +1163,29 @@ func (m *Model) handleAction(action keybindings.Action, msg tea.KeyMsg) tea.Cmd
 		}
 
 	case keybindings.ActionCloseChat:
+		if jid := m.windows.ActiveJID(); jid != "" {
+			m.app.ConversationClosed(m.rosterAccountJID(), bareJID(jid))
+		}
 		m.windows = m.windows.CloseActive()
 		m.focus = FocusRoster
 
+	case keybindings.ActionToggleHelp:
+		return m.app.ExecuteCommand("help", nil)
+
 	case keybindings.ActionToggleRoster:
 		m.showRoster = !m.showRoster
 		m.updateComponentSizes()
 
+	case keybindings.ActionRosterNarrower:
+		if m.showRoster {
+			m.resizeRoster(m.rosterPaneWidth()-rosterResizeStep, true)
+		}
+
+	case keybindings.ActionRosterWider:
+		if m.showRoster {
+			m.resizeRoster(m.rosterPaneWidth()+rosterResizeStep, true)
+		}
+
 	case keybindings.ActionFocusRoster:
 		m.focus = FocusRoster
 		m.roster = m.roster.MoveToContacts()
@@ -994,27 +1313,7 @@ func (m *Model) handleAction(action keybindings.Action, msg tea.KeyMsg) tea.Cmd
 			m.chat = m.chat.SetStatusMsg("Select a contact to toggle favorite")
 			return nil
 		}
-
-		targetAccount := m.rosterAccountJID()
-		if targetAccount == "" {
-			m.dialog = m.dialog.ShowError("Select an account first.")
-			m.focus = FocusDialog
-			return nil
-		}
-
-		// Ensure we are not stuck in filter input state when toggling favorite.
-		if m.roster.InFilterMode() {
-			m.roster = m.roster.ExitFilterMode()
-			m.keys.SetMode(keybindings.ModeNormal)
-		}
-
-		// Show roster loading spinner while favorite is persisted.
-		m.rosterLoadingByAccount[localRosterOpKey] = true
-		m.roster = m.roster.SetLoading(true)
-		return tea.Batch(
-			rosterSpinnerTick(),
-			m.doToggleFavorite(targetAccount, targetJID),
-		)
+		return m.toggleFavorite(targetJID)
 
 	case keybindings.ActionJoinRoom:
 		m.dialog = m.dialog.ShowJoinRoom()
@@ -1067,6 +1366,16 @@ func (m *Model) handleAction(action keybindings.Action, msg tea.KeyMsg) tea.Cmd
 	case keybindings.ActionSaveWindows:
 		m.saveWindows()
 
+	case keybindings.ActionMoveWindowLeft, keybindings.ActionMoveWindowRight:
+		delta := 1
+		if action == keybindings.ActionMoveWindowLeft {
+			delta = -1
+		}
+		if moved, ok := m.windows.MoveActive(delta); ok {
+			m.windows = moved
+			m.persistWindows()
+		}
+
 	case keybindings.ActionRefresh:
 		// Refresh roster from server
 		m.refreshRosterContacts()
@@ -1086,6 +1395,15 @@ func (m *Model) handleAction(action keybindings.Action, msg tea.KeyMsg) tea.Cmd
 	case keybindings.ActionToggleAccountList:
 		m.roster = m.roster.ToggleAccountList()
 
+	case keybindings.ActionNextAccount:
+		if m.keys.HasCount() {
+			return m.switchToAccountNumber(m.keys.Count())
+		}
+		return m.cycleAccount(1)
+
+	case keybindings.ActionPrevAccount:
+		return m.cycleAccount(-1)
+
 	case keybindings.ActionShowContextHelp:
 		m.showContextHelp()
 
@@ -1230,7 +1548,28 @@ func (m *Model) handleAction(action keybindings.Action, msg tea.KeyMsg) tea.Cmd
 			m.chat = m.chat.SetStatusMsg("AutoConnect " + stateStr + " for " + targetJID)
 		}
 
+	case keybindings.ActionShowPrivacy:
+		return m.privacyCommand()
+
+	case keybindings.ActionCycleArchive:
+		// Cycle server archiving from detail view or accounts section
+		var targetJID string
+		if m.viewMode == ViewModeAccountDetails && m.detailAccountJID != "" {
+			targetJID = m.detailAccountJID
+		} else if m.focus == FocusAccounts || (m.focus == FocusRoster && m.roster.FocusSection() == roster.SectionAccounts) {
+			targetJID = m.roster.SelectedAccountJID()
+		}
+
+		if targetJID != "" {
+			return m.cycleArchive(targetJID)
+		}
+
 	case keybindings.ActionSetWindowAccount:
+		// Space key on contacts: mark for :bulk
+		if m.focus == FocusRoster && m.roster.FocusSection() == roster.SectionContacts {
+			m.roster = m.roster.ToggleMark()
+			return nil
+		}
 		// Space key on accounts: connect if offline, switch if online, deselect if active
 		if m.roster.FocusSection() == roster.SectionAccounts {
 			if jid := m.roster.SelectedAccountJID(); jid != "" {
@@ -1254,7 +1593,7 @@ func (m *Model) handleAction(action keybindings.Action, msg tea.KeyMsg) tea.Cmd
 							m.chat = m.chat.SetStatusMsg("Connecting " + jid + "...")
 							m.app.SetAccountStatus(jid, "connecting")
 							m.roster = m.roster.SetAccounts(m.getAccountDisplays())
-							return m.app.DoConnect(jid)
+							return m.connectAccount(jid)
 						} else if acc.Status == "online" || acc.Status == "connecting" {
 							// Account is online - switch to it
 							m.app.SwitchActiveAccount(jid)
@@ -1274,6 +1613,33 @@ func (m *Model) handleAction(action keybindings.Action, msg tea.KeyMsg) tea.Cmd
 			}
 		}
 
+	case keybindings.ActionQuickActions:
+		jid := m.selectedContactJID()
+		if jid == "" {
+			m.chat = m.chat.SetStatusMsg("Select a contact first")
+			return nil
+		}
+		m.showQuickActions(jid)
+
+	case keybindings.ActionRemoveContact:
+		jid := m.selectedContactJID()
+		if jid == "" {
+			m.chat = m.chat.SetStatusMsg("Select a contact to remove")
+			return nil
+		}
+		m.confirmBulk(app.BulkRemove, "", []string{jid})
+
+	case keybindings.ActionVisualSelect:
+		if m.focus != FocusRoster || m.roster.FocusSection() != roster.SectionContacts {
+			return nil
+		}
+		m.roster = m.roster.ToggleVisual()
+		if m.roster.InVisualMode() {
+			m.chat = m.chat.SetStatusMsg("-- VISUAL -- move to extend, V to keep the range, :bulk to act")
+		} else {
+			m.chat = m.chat.SetStatusMsg(fmt.Sprintf("%d marked, :bulk to act, Esc to clear", len(m.roster.MarkedJIDs())))
+		}
+
 	case keybindings.ActionToggleStatusSharing:
 		// Toggle status sharing for current contact
 		var targetJID string
@@ -1286,9 +1652,9 @@ func (m *Model) handleAction(action keybindings.Action, msg tea.KeyMsg) tea.Cmd
 		}
 
 		if targetJID != "" {
-			enabled, err := m.app.ToggleStatusSharing(targetJID)
+			enabled, err := m.app.ToggleStatusSharingForAccount(m.rosterAccountJID(), targetJID)
 			if err != nil {
-				m.dialog = m.dialog.ShowError("Failed to toggle status sharing: " + err.Error())
+				m.showError("Failed to toggle status sharing", err)
 				m.focus = FocusDialog
 			} else {
 				stateStr := "OFF"
@@ -1301,6 +1667,9 @@ func (m *Model) handleAction(action keybindings.Action, msg tea.KeyMsg) tea.Cmd
 			}
 		}
 
+	case keybindings.ActionPingContact:
+		return m.pingContact("")
+
 	case keybindings.ActionVerifyFingerprint:
 		// Open OMEMO verification from details, roster selection, or active chat.
 		targetJID := ""
@@ -1318,8 +1687,35 @@ func (m *Model) handleAction(action keybindings.Action, msg tea.KeyMsg) tea.Cmd
 		}
 		m.showOMEMOVerifyDialog(targetJID)
 
-	case keybindings.ActionFocusHeader:
-		// Focus the chat header for contact actions
+	case keybindings.ActionRecordMacro:
+		if _, recording := m.keys.Recording(); recording {
+			m.keys.StopRecording()
+			m.chat = m.chat.SetStatusMsg("Macro recorded")
+		} else if !m.replayingMacro {
+			m.macroPending = keybindings.ActionRecordMacro
+		}
+
+	case keybindings.ActionPlayMacro:
+		if m.replayingMacro {
+			return nil
+		}
+		m.macroPending = keybindings.ActionPlayMacro
+		m.macroCount = m.keys.Count()
+
+	case keybindings.ActionJumpToNew:
+		m.chat = m.chat.JumpToFirstNew()
+
+	case keybindings.ActionNextMention:
+		m.chat = m.chat.JumpToNextMention()
+
+	case keybindings.ActionDismissDeviceAlert:
+		if jid := m.windows.ActiveJID(); jid != "" {
+			m.app.AcknowledgeNewDevices(m.rosterAccountJID(), jid)
+			m.refreshSecurityBanner()
+		}
+
+	case keybindings.ActionFocusHeader:
+		// Focus the chat header for contact actions
 		if m.focus == FocusChat && m.windows.ActiveJID() != "" {
 			jid := m.windows.ActiveJID()
 			contactData := m.getContactDetailData(jid)
@@ -1331,7 +1727,17 @@ func (m *Model) handleAction(action keybindings.Action, msg tea.KeyMsg) tea.Cmd
 	case keybindings.ActionOpenFileURL:
 		// Open file URL in browser (with security checks)
 		if m.focus == FocusChat {
+			if selMsg := m.chat.SelectedMessage(); selMsg != nil && selMsg.FileURL == "" {
+				// xmpp: links are handled here rather than by the browser
+				if uri := app.FindXMPPURI(selMsg.Body); uri != "" {
+					m.openXMPPURI(uri)
+					return nil
+				}
+			}
 			if selMsg := m.chat.SelectedMessage(); selMsg != nil && selMsg.FileURL != "" {
+				if m.policyDenied(app.PolicyLinkOpen) {
+					return nil
+				}
 				url := selMsg.FileURL
 
 				// Security: Validate HTTPS
@@ -1357,7 +1763,7 @@ func (m *Model) handleAction(action keybindings.Action, msg tea.KeyMsg) tea.Cmd
 		// Copy file URL to clipboard
 		if m.focus == FocusChat {
 			if selMsg := m.chat.SelectedMessage(); selMsg != nil && selMsg.FileURL != "" {
-				if err := copyToClipboard(selMsg.FileURL); err == nil {
+				if err := m.copyToClipboard(selMsg.FileURL); err == nil {
 					m.chat = m.chat.SetStatusMsg("URL copied to clipboard")
 				} else {
 					m.chat = m.chat.SetStatusMsg("Failed to copy URL")
@@ -1365,6 +1771,42 @@ func (m *Model) handleAction(action keybindings.Action, msg tea.KeyMsg) tea.Cmd
 			}
 		}
 
+	case keybindings.ActionYank:
+		// Copy the selected message, or our fingerprint in account details
+		switch {
+		case m.viewMode == ViewModeAccountDetails && m.detailAccountJID != "":
+			fingerprint, _ := m.app.GetOwnFingerprint(m.detailAccountJID)
+			if fingerprint == "" {
+				m.chat = m.chat.SetStatusMsg("No OMEMO fingerprint for " + m.detailAccountJID)
+			} else if err := m.copyToClipboard(fingerprint); err == nil {
+				m.chat = m.chat.SetStatusMsg("Fingerprint copied to clipboard")
+			} else {
+				m.chat = m.chat.SetStatusMsg("Failed to copy fingerprint: " + err.Error())
+			}
+		case m.focus == FocusChat:
+			if selMsg := m.chat.SelectedMessage(); selMsg != nil {
+				if err := m.copyToClipboard(selMsg.Body); err == nil {
+					m.chat = m.chat.SetStatusMsg("Message copied to clipboard")
+				} else {
+					m.chat = m.chat.SetStatusMsg("Failed to copy message: " + err.Error())
+				}
+			}
+		}
+
+	case keybindings.ActionCopyJSON:
+		// Copy the JSON payload of the selected or latest bot message
+		if m.focus == FocusChat {
+			if payload, ok := m.chat.SelectedJSON(); ok {
+				if err := m.copyToClipboard(payload); err == nil {
+					m.chat = m.chat.SetStatusMsg("JSON copied to clipboard")
+				} else {
+					m.chat = m.chat.SetStatusMsg("Failed to copy JSON")
+				}
+			} else {
+				m.chat = m.chat.SetStatusMsg("No JSON in this chat")
+			}
+		}
+
 	case keybindings.ActionShowBookmarks:
 		bookmarks := m.app.GetBookmarks()
 		var dialogBookmarks []dialogs.BookmarkInfo
@@ -1380,8 +1822,9 @@ func (m *Model) handleAction(action keybindings.Action, msg tea.KeyMsg) tea.Cmd
 		m.focus = FocusDialog
 
 	case keybindings.ActionShowParticipants:
-		// Toggle participant list for current MUC room
+		// Toggle the nick list beside rooms; :participants opens the dialog
 		m.muc = m.muc.ToggleParticipants()
+		m.updateComponentSizes()
 
 	case keybindings.ActionSetStatus:
 		currentStatus := m.app.Status()
@@ -1400,6 +1843,26 @@ func (m *Model) handleAction(action keybindings.Action, msg tea.KeyMsg) tea.Cmd
 			}
 		}
 
+	case keybindings.ActionStarMessage:
+		if m.focus == FocusChat && m.windows.ActiveJID() != "" {
+			selMsg := m.chat.SelectedMessage()
+			if selMsg == nil {
+				m.chat = m.chat.SetStatusMsg("Select a message to star")
+				return nil
+			}
+			starred, err := m.app.ToggleStar(m.rosterAccountJID(), m.windows.ActiveJID(), *selMsg)
+			if err != nil {
+				m.chat = m.chat.SetStatusMsg("Failed to star: " + err.Error())
+				return nil
+			}
+			m.refreshStarred()
+			if starred {
+				m.chat = m.chat.SetStatusMsg("Message starred, :starred lists them")
+			} else {
+				m.chat = m.chat.SetStatusMsg("Star removed")
+			}
+		}
+
 	case keybindings.ActionAddReaction:
 		if m.focus == FocusChat && m.windows.ActiveJID() != "" {
 			jid := m.windows.ActiveJID()
@@ -1416,8 +1879,27 @@ func (m *Model) handleAction(action keybindings.Action, msg tea.KeyMsg) tea.Cmd
 			}
 		}
 
+	case keybindings.ActionHistoryPrev, keybindings.ActionHistoryNext:
+		// Up and Down in the composer go through what was sent, elsewhere
+		// they move
+		if m.focus != FocusChat {
+			move := keybindings.ActionMoveUp
+			if action == keybindings.ActionHistoryNext {
+				move = keybindings.ActionMoveDown
+			}
+			return m.handleAction(move, msg)
+		}
+		if action == keybindings.ActionHistoryPrev {
+			m.chat = m.chat.HistoryPrev()
+		} else {
+			m.chat = m.chat.HistoryNext()
+		}
+
 	case keybindings.ActionUploadFile:
 		if m.focus == FocusChat && m.windows.ActiveJID() != "" {
+			if m.policyDenied(app.PolicyFileUpload) {
+				return nil
+			}
 			jid := m.windows.ActiveJID()
 			m.dialog = m.dialog.ShowUploadFile(jid)
 			m.focus = FocusDialog
@@ -1593,9 +2075,9 @@ func (m *Model) handleChatHeaderKey(msg tea.KeyMsg) (bool, tea.Cmd) {
 			m.chat = m.chat.SetHeaderFocused(false)
 			m.focus = FocusChat
 		case 1: // Sharing - toggle status sharing
-			enabled, err := m.app.ToggleStatusSharing(jid)
+			enabled, err := m.app.ToggleStatusSharingForAccount(m.rosterAccountJID(), jid)
 			if err != nil {
-				m.dialog = m.dialog.ShowError("Failed to toggle status sharing: " + err.Error())
+				m.showError("Failed to toggle status sharing", err)
 				m.focus = FocusDialog
 			} else {
 				stateStr := "OFF"
@@ -1612,6 +2094,19 @@ func (m *Model) handleChatHeaderKey(msg tea.KeyMsg) (bool, tea.Cmd) {
 			m.detailContactJID = jid
 			m.chat = m.chat.SetHeaderFocused(false)
 			m.focus = FocusChat
+		case 4: // Resource - lock to the next online client, then back to the bare JID
+			locked, err := m.app.CycleResourceLock(m.rosterAccountJID(), bareJID(jid))
+			if err != nil {
+				m.chat = m.chat.SetStatusMsg(err.Error())
+				return true, nil
+			}
+			if locked == "" {
+				m.chat = m.chat.SetStatusMsg("Messages to " + bareJID(jid) + " go to its preferred client again")
+			} else {
+				m.chat = m.chat.SetStatusMsg("Messages to " + bareJID(jid) + " now only go to " + locked)
+			}
+			contactData := m.getContactDetailData(jid)
+			m.chat = m.chat.SetContactData(&contactData)
 		}
 		return true, nil
 	}
@@ -1642,6 +2137,9 @@ func (m *Model) handleChatHeaderKey(msg tea.KeyMsg) (bool, tea.Cmd) {
 	case "d", "D":
 		return executeHeaderAction(3)
 
+	case "r", "R":
+		return executeHeaderAction(4)
+
 	case "esc", "escape":
 		// Exit header focus, return to chat
 		m.chat = m.chat.SetHeaderFocused(false)
@@ -1664,7 +2162,7 @@ func (m *Model) showContextHelp() {
 		if jid == "" {
 			content = "No active chat.\n\nOpen a chat with Enter on a roster entry,\nor use :1 to switch to window 1."
 		} else {
-			history := m.app.GetChatHistory(jid)
+			history := m.app.GetChatHistoryForAccount(m.rosterAccountJID(), jid)
 			if len(history) == 0 {
 				content = "No messages in this chat yet.\n\nPress 'i' to enter insert mode and type a message."
 			} else {
@@ -1765,6 +2263,64 @@ func truncate(s string, maxLen int) string {
 	return s[:maxLen-1] + "…"
 }
 
+// syncPluginCommands makes the command line know the commands plugins
+// added, for completion and :help
+func (m *Model) syncPluginCommands() {
+	for name, cmd := range m.commandline.GetCommands() {
+		if cmd.Plugin != "" {
+			m.commandline.UnregisterCommand(name)
+		}
+	}
+	for _, cmd := range m.app.PluginCommands() {
+		m.commandline.RegisterCommand(commandline.Command{
+			Name:        cmd.Name,
+			Description: cmd.Description,
+			Args:        cmd.Args,
+			Help:        cmd.Help,
+			Plugin:      cmd.Plugin,
+			Complete:    cmd.Complete,
+		})
+	}
+}
+
+// statusItems returns the plugin items of the status bar
+func (m *Model) statusItems() []statusbar.Item {
+	items := m.app.StatusItems()
+	result := make([]statusbar.Item, len(items))
+	for i, item := range items {
+		result[i] = statusbar.Item{ID: item.ID, Text: item.Text, MaxWidth: item.MaxWidth}
+	}
+	return result
+}
+
+// formatPluginList renders :plugin list, one plugin per line
+func formatPluginList(plugins []app.PluginStatus) string {
+	if len(plugins) == 0 {
+		return "The plugin index lists no plugins"
+	}
+	var b strings.Builder
+	for _, p := range plugins {
+		state := "available"
+		switch {
+		case p.Installed != "" && p.Enabled:
+			state = "installed " + p.Installed + ", enabled"
+		case p.Installed != "":
+			state = "installed " + p.Installed + ", disabled"
+		case !p.Available:
+			state = "not available for this platform"
+		}
+		if p.Installed != "" && p.Version != "" && p.Version != p.Installed {
+			state += ", " + p.Version + " available"
+		}
+		fmt.Fprintf(&b, "%s %s (%s)\n", p.Name, p.Version, state)
+		if p.Description != "" {
+			b.WriteString("  " + p.Description + "\n")
+		}
+	}
+	b.WriteString("\n:plugin install|remove|enable|disable <name>")
+	return b.String()
+}
+
 func bareJID(j string) string {
 	if idx := strings.Index(j, "/"); idx > 0 {
 		return j[:idx]
@@ -1772,6 +2328,57 @@ func bareJID(j string) string {
 	return j
 }
 
+// cycleAccount makes the next (step 1) or previous (step -1) connected
+// account active, wrapping around the account list
+func (m *Model) cycleAccount(step int) tea.Cmd {
+	var jids []string
+	for _, acc := range m.app.GetAllAccountsDisplay() {
+		if acc.Status == "online" || acc.Status == "connecting" {
+			jids = append(jids, acc.JID)
+		}
+	}
+	if len(jids) == 0 {
+		m.chat = m.chat.SetStatusMsg("No connected accounts")
+		return nil
+	}
+	next := 0
+	for i, jid := range jids {
+		if jid == m.app.CurrentAccount() {
+			next = (i + step + len(jids)) % len(jids)
+			break
+		}
+	}
+	if jids[next] == m.app.CurrentAccount() {
+		m.chat = m.chat.SetStatusMsg("No other connected account")
+		return nil
+	}
+	return m.switchAccount(jids[next])
+}
+
+// switchToAccountNumber makes the n-th account of the account list active
+func (m *Model) switchToAccountNumber(n int) tea.Cmd {
+	accounts := m.app.GetAllAccountsDisplay()
+	if n < 1 || n > len(accounts) {
+		m.chat = m.chat.SetStatusMsg(fmt.Sprintf("No account %d, there are %d", n, len(accounts)))
+		return nil
+	}
+	return m.switchAccount(accounts[n-1].JID)
+}
+
+// switchAccount makes an account active from anywhere: the roster, the
+// active window's account and the status bar all follow it
+func (m *Model) switchAccount(jid string) tea.Cmd {
+	m.app.SwitchActiveAccount(jid)
+	m.windows = m.windows.SetAccountForActive(jid)
+	m.refreshRosterContacts()
+	if m.viewMode == ViewModeAccountDetails {
+		m.detailAccountJID = jid
+	}
+	m.loadActiveWindow()
+	m.chat = m.chat.SetStatusMsg("Switched to " + jid)
+	return m.app.RequestRosterRefreshForAccount(jid)
+}
+
 func (m *Model) resetDetailViewState() {
 	m.viewMode = ViewModeNormal
 	m.detailAccountJID = ""
@@ -1799,6 +2406,146 @@ func (m *Model) showOMEMOVerifyDialog(jid string) {
 	m.focus = FocusDialog
 }
 
+// startDiagnostics runs :doctor for the given JID, or the current account,
+// behind a cancellable loading dialog
+func (m *Model) startDiagnostics(msg app.CommandActionMsg) tea.Cmd {
+	jid, _ := msg.Data["jid"].(string)
+	if jid == "" {
+		jid = m.rosterAccountJID()
+	}
+	if jid == "" {
+		m.chat = m.chat.SetStatusMsg("Usage: :doctor <jid>")
+		return nil
+	}
+	m.dialog = m.dialog.ShowLoading("Diagnosing "+jid+"...", dialogs.OpDiagnostics)
+	m.focus = FocusDialog
+	return tea.Batch(dialogs.SpinnerTick(), m.app.RunDiagnostics(jid))
+}
+
+// openXMPPURI acts on an xmpp: URI: ?join opens the join room dialog,
+// ?roster and ?subscribe the add contact dialog, and anything else a chat
+// with the composer holding the ?message body
+func (m *Model) openXMPPURI(raw string) {
+	uri, err := app.ParseXMPPURI(raw)
+	if err != nil {
+		m.chat = m.chat.SetStatusMsg("Invalid xmpp: link: " + err.Error())
+		return
+	}
+
+	switch uri.Action {
+	case "join":
+		nick := m.app.RoomNick(m.rosterAccountJID(), uri.JID)
+		m.dialog = m.dialog.ShowJoinRoomFor(uri.JID, nick, uri.Params["password"])
+		m.focus = FocusDialog
+	case "roster", "subscribe":
+		m.addContactAccountJID = m.rosterAccountJID()
+		m.dialog = m.dialog.ShowAddContactFor(uri.JID, uri.Params["name"], uri.Params["group"])
+		m.focus = FocusDialog
+	default:
+		m.openChatNewWindow(uri.JID)
+		if m.dialog.Active() {
+			return
+		}
+		if body := uri.Params["body"]; body != "" {
+			m.chat = m.chat.SetInput(body)
+		}
+		m.focus = FocusChat
+	}
+}
+
+// shareLink copies an invite link to the clipboard: for the room given, the
+// active room, or with "me" or outside a room, for the current account
+func (m *Model) shareLink(target string) {
+	var link string
+	switch {
+	case target != "" && target != "me":
+		link = app.RoomInviteURI(target)
+	case target == "" && m.activeRoomJID() != "":
+		link = app.RoomInviteURI(m.activeRoomJID())
+	default:
+		own, err := m.app.OwnInviteURI()
+		if err != nil {
+			m.chat = m.chat.SetStatusMsg("No invite link: " + err.Error())
+			return
+		}
+		link = own
+	}
+	if err := m.copyToClipboard(link); err != nil {
+		m.chat = m.chat.SetStatusMsg("Invite link: " + link)
+		return
+	}
+	m.chat = m.chat.SetStatusMsg("Copied invite link: " + link)
+}
+
+// startBrowseRooms lists the public rooms of a MUC service, by default the
+// one of the current account's server
+func (m *Model) startBrowseRooms(msg app.CommandActionMsg) tea.Cmd {
+	service, _ := msg.Data["service"].(string)
+	label := service
+	if label == "" {
+		label = "your server"
+	}
+	m.dialog = m.dialog.ShowLoading("Listing rooms on "+label+"...", dialogs.OpBrowseRooms)
+	m.focus = FocusDialog
+	return tea.Batch(dialogs.SpinnerTick(), m.app.BrowseRooms(service))
+}
+
+// querySoftwareVersion asks a contact which client it runs: the one given,
+// else the one whose details are shown, the open chat or the roster
+// selection
+func (m *Model) querySoftwareVersion(msg app.CommandActionMsg) tea.Cmd {
+	target, _ := msg.Data["jid"].(string)
+	switch {
+	case target != "":
+	case m.viewMode == ViewModeContactDetails && m.detailContactJID != "":
+		target = m.detailContactJID
+	case m.focus == FocusChat && m.windows.ActiveJID() != "":
+		target = bareJID(m.windows.ActiveJID())
+	default:
+		target = m.roster.SelectedJID()
+	}
+	if target == "" {
+		m.chat = m.chat.SetStatusMsg("Usage: :version [jid]")
+		return nil
+	}
+	m.chat = m.chat.SetStatusMsg("Asking " + target + " which client it runs...")
+	return m.app.QuerySoftwareVersion(m.rosterAccountJID(), target, true)
+}
+
+// showRosterConflict asks about the oldest unresolved roster conflict and
+// reports whether there was one
+func (m *Model) showRosterConflict() bool {
+	conflicts := m.app.RosterConflicts()
+	if len(conflicts) == 0 {
+		return false
+	}
+	c := conflicts[0]
+	m.dialog = m.dialog.ShowRosterConflict(c.Edit.AccountJID, c.Edit.JID, c.Mine(), c.Server())
+	m.focus = FocusDialog
+	return true
+}
+
+// pingContact pings a full JID, or every online client of a contact: the
+// one given, else the one whose details are shown, the open chat or the
+// roster selection
+func (m *Model) pingContact(target string) tea.Cmd {
+	switch {
+	case target != "":
+	case m.viewMode == ViewModeContactDetails && m.detailContactJID != "":
+		target = m.detailContactJID
+	case m.focus == FocusChat && m.windows.ActiveJID() != "":
+		target = bareJID(m.windows.ActiveJID())
+	default:
+		target = m.roster.SelectedJID()
+	}
+	if target == "" {
+		m.chat = m.chat.SetStatusMsg("Usage: :ping [jid[/resource]]")
+		return nil
+	}
+	m.chat = m.chat.SetStatusMsg("Pinging " + target + "...")
+	return m.app.PingContact(m.rosterAccountJID(), target)
+}
+
 // updateFocusedComponent sends the key message to the focused component
 func (m *Model) updateFocusedComponent(msg tea.KeyMsg) []tea.Cmd {
 	var cmds []tea.Cmd
@@ -1806,10 +2553,15 @@ func (m *Model) updateFocusedComponent(msg tea.KeyMsg) []tea.Cmd {
 	switch m.focus {
 	case FocusChat:
 		var cmd tea.Cmd
+		draft := m.chat.Input()
 		m.chat, cmd = m.chat.Update(msg)
 		if cmd != nil {
 			cmds = append(cmds, cmd)
 		}
+		// A sent message ends the typing by itself
+		if input := m.chat.Input(); input != draft && cmd == nil {
+			m.app.UserTyping(m.rosterAccountJID(), bareJID(m.windows.ActiveJID()), input == "")
+		}
 
 	case FocusCommandLine:
 		var cmd tea.Cmd
@@ -1826,11 +2578,17 @@ func (m *Model) updateFocusedComponent(msg tea.KeyMsg) []tea.Cmd {
 				m.keys.SetMode(keybindings.ModeNormal)
 			case tea.KeyEnter:
 				if jid := m.roster.SelectedJID(); jid != "" {
+					// n/N keep jumping between the matches afterwards
+					m.keys.SetSearchQuery(m.roster.FilterQuery())
 					m.openChat(jid)
 					m.roster = m.roster.ExitFilterMode()
 					m.focus = FocusChat
 					m.keys.SetMode(keybindings.ModeInsert)
 				}
+			case tea.KeyUp, tea.KeyCtrlP:
+				m.roster = m.roster.MoveUp()
+			case tea.KeyDown, tea.KeyCtrlN:
+				m.roster = m.roster.MoveDown()
 			case tea.KeyBackspace:
 				query := m.roster.FilterQuery()
 				if len(query) > 0 {
@@ -1908,6 +2666,9 @@ func (m *Model) handleAppEvent(event app.EventMsg) tea.Cmd {
 				Status:      chat.MessageStatus(msg.Status),
 				CorrectedID: msg.CorrectedID,
 				Reactions:   msg.Reactions,
+				JSON:        msg.JSON,
+				FileURL:     msg.FileURL,
+				Warning:     msg.Warning,
 			}
 			peerJID := bareJID(chatMsg.From)
 			if chatMsg.Outgoing {
@@ -1917,15 +2678,37 @@ func (m *Model) handleAppEvent(event app.EventMsg) tea.Cmd {
 				peerJID = activeJID
 			}
 
-			if shouldApplyToActive(peerJID, msg.AccountJID) {
+			if shouldApplyToActive(peerJID, msg.AccountJID) && !m.app.Focused() {
+				// Nobody is looking: unread until the terminal has focus
+				applyChatEvent(chatMsg)
+				m.queueLinkPreviews([]chat.Message{chatMsg})
+				if !chatMsg.Outgoing && !chatMsg.Warning {
+					m.windows = m.windows.IncrementUnread(peerJID)
+					if !m.isIgnoredOccupant(msg.AccountJID, peerJID, chatMsg.From) &&
+						m.app.RoomWantsNotification(msg.AccountJID, peerJID, chatMsg.Body) {
+						m.app.PlayNotificationSound(peerJID)
+					}
+				}
+			} else if shouldApplyToActive(peerJID, msg.AccountJID) {
 				applyChatEvent(chatMsg)
+				m.queueLinkPreviews([]chat.Message{chatMsg})
+				if roomJID := m.activeRoomJID(); roomJID != "" {
+					m.app.MarkRoomSeen(m.rosterAccountJID(), roomJID)
+				}
 				if msg.AccountJID != "" {
 					m.app.ClearContactUnread(msg.AccountJID, peerJID)
 					m.roster = m.roster.SetContacts(m.app.GetContactsForAccount(msg.AccountJID))
 				}
 				m.windows = m.windows.ClearUnread(m.windows.ActiveNum())
-			} else if !chatMsg.Outgoing && peerJID != "" {
+			} else if !chatMsg.Outgoing && !chatMsg.Warning && peerJID != "" && !m.app.HeldAsRequest(msg.AccountJID, peerJID) {
 				m.windows = m.windows.OpenOrIncrementUnreadForAccount(peerJID, msg.AccountJID)
+				if !m.isIgnoredOccupant(msg.AccountJID, peerJID, chatMsg.From) &&
+					m.app.RoomWantsNotification(msg.AccountJID, peerJID, chatMsg.Body) {
+					m.app.PlayNotificationSound(peerJID)
+				}
+			}
+			if !chatMsg.Outgoing && peerJID != "" && chatMsg.Type != "system" && !m.app.HeldAsRequest(msg.AccountJID, peerJID) {
+				m.bumpWindow(peerJID, msg.AccountJID)
 			}
 		case chat.Message:
 			peerJID := bareJID(msg.From)
@@ -1938,6 +2721,10 @@ func (m *Model) handleAppEvent(event app.EventMsg) tea.Cmd {
 				m.windows = m.windows.ClearUnread(m.windows.ActiveNum())
 			} else if !msg.Outgoing && peerJID != "" {
 				m.windows = m.windows.OpenOrIncrementUnreadForAccount(peerJID, "")
+				m.app.PlayNotificationSound(peerJID)
+			}
+			if !msg.Outgoing && peerJID != "" {
+				m.bumpWindow(peerJID, "")
 			}
 		}
 		// Keep contact unread indicators live in the roster.
@@ -1945,12 +2732,17 @@ func (m *Model) handleAppEvent(event app.EventMsg) tea.Cmd {
 
 	case app.EventPresence:
 		if presence, ok := event.Data.(app.PresenceUpdate); ok {
-			m.roster = m.roster.UpdatePresence(presence.JID, presence.Status)
-			// Also update status message if present
-			if presence.StatusMsg != "" {
+			if presence.AccountJID == "" || presence.AccountJID == m.rosterAccountJID() {
+				m.roster = m.roster.UpdatePresence(presence.JID, presence.Status)
 				m.roster = m.roster.UpdatePresenceMessage(presence.JID, presence.StatusMsg)
+				if m.app.Config().UI.RosterSort == "presence" {
+					m.refreshRosterContacts()
+				}
 			}
 			if jid := m.windows.ActiveJID(); jid != "" && jid == presence.JID {
+				if presence.Status == "offline" {
+					m.chat = m.chat.SetPeerTyping(false)
+				}
 				contactData := m.getContactDetailData(jid)
 				m.chat = m.chat.SetContactData(&contactData)
 			}
@@ -1960,6 +2752,20 @@ func (m *Model) handleAppEvent(event app.EventMsg) tea.Cmd {
 			m.chat = m.chat.SetStatusMsg("Connecting to " + m.app.CurrentAccount() + "...")
 		}
 
+	case app.EventNewDevice:
+		if alert, ok := event.Data.(app.NewDeviceAlert); ok {
+			if alert.JID == m.windows.ActiveJID() && alert.AccountJID == m.rosterAccountJID() {
+				m.refreshSecurityBanner()
+			}
+		}
+
+	case app.EventTyping:
+		if update, ok := event.Data.(app.TypingUpdate); ok {
+			if jid := m.windows.ActiveJID(); jid != "" && jid == update.JID && update.AccountJID == m.rosterAccountJID() {
+				m.chat = m.chat.SetPeerTyping(update.Typing)
+			}
+		}
+
 	case app.EventRosterLoading:
 		if update, ok := event.Data.(app.RosterLoadingUpdate); ok {
 			if update.Loading {
@@ -1984,31 +2790,249 @@ func (m *Model) handleAppEvent(event app.EventMsg) tea.Cmd {
 		m.chat = m.chat.ClearStatusMsg()
 
 	case app.EventError:
-		if errMsg, ok := event.Data.(string); ok {
-			m.dialog = m.dialog.ShowError(errMsg)
+		switch data := event.Data.(type) {
+		case string:
+			m.dialog = m.dialog.ShowError(data)
+			m.focus = FocusDialog
+		case app.ErrorInfo:
+			m.dialog = m.dialog.ShowErrorDetails(data.Title, data.String(), data.Details)
+			m.focus = FocusDialog
+		case error:
+			info := app.DescribeError(data)
+			m.dialog = m.dialog.ShowErrorDetails(info.Title, info.String(), info.Details)
 			m.focus = FocusDialog
 		}
 
 	case app.EventReceipt:
 		// Handle message status update (delivery/read receipt)
-		if statusUpdate, ok := event.Data.(app.MessageStatusUpdateMsg); ok {
+		if statusUpdate, ok := event.Data.(app.MessageStatusUpdateMsg); ok && statusUpdate.AccountJID == m.rosterAccountJID() {
 			m.chat = m.chat.UpdateMessageStatus(statusUpdate.MessageID, chat.MessageStatus(statusUpdate.Status))
 		}
 
+	case app.EventMessagesExpired:
+		if expired, ok := event.Data.(app.MessagesExpired); ok && expired.AccountJID == m.rosterAccountJID() && bareJID(m.windows.ActiveJID()) == expired.JID {
+			m.chat = m.chat.SetHistory(m.app.GetChatHistoryForAccount(expired.AccountJID, m.windows.ActiveJID()))
+		}
+
+	case app.EventConnectProgress:
+		m.roster = m.roster.SetAccounts(m.getAccountDisplays())
+
+	case app.EventOpenURI:
+		if uri, ok := event.Data.(string); ok {
+			m.openXMPPURI(uri)
+		}
+
+	case app.EventReadOnOtherDevice:
+		if read, ok := event.Data.(app.ReadOnOtherDevice); ok {
+			m.windows = m.windows.ClearUnreadFor(read.JID, read.AccountJID)
+			m.refreshRosterContacts()
+		}
+
 	case app.EventMAMSyncing:
 		if syncing, ok := event.Data.(bool); ok {
 			m.statusbar = m.statusbar.SetSyncing(syncing, "")
 		}
+
+	case app.EventRosterConflict:
+		if conflict, ok := event.Data.(app.RosterConflict); ok {
+			if m.focus == FocusDialog {
+				m.chat = m.chat.SetStatusMsg("Roster conflict for " + conflict.Edit.JID + ", resolve it with :conflicts")
+			} else {
+				m.showRosterConflict()
+			}
+		}
+
+	case app.EventCommandsChanged:
+		m.syncPluginCommands()
+
+	case app.EventStatusItemsChanged:
+		m.statusbar = m.statusbar.SetItems(m.statusItems())
+
+	case app.EventVoiceRequest:
+		if req, ok := event.Data.(app.VoiceRequest); ok {
+			if m.dialog.Active() {
+				m.chat = m.chat.SetStatusMsg(req.Who() + " asks for voice in " + req.Room + ", answer with :voice")
+			} else {
+				m.showVoiceApproval(req)
+			}
+		}
+
+	case app.EventMUCJoined, app.EventMUCLeft, app.EventMUCPresence, app.EventMUCMessage:
+		return m.handleMUCEvent(event)
+
+	case app.EventArchivePrefs:
+		if prefs, ok := event.Data.(app.ArchivePrefsMsg); ok {
+			m.handleArchivePrefs(prefs)
+		}
+
+	case app.EventRoomMessageDropped:
+		if dropped, ok := event.Data.(app.DroppedRoomMessage); ok {
+			if m.dialog.Active() {
+				m.chat = m.chat.SetStatusMsg(dropped.Room + " did not send your message back, it may not have been delivered")
+			} else {
+				m.pendingResend = &dropped
+				m.dialog = m.dialog.ShowResendRoomMessage(dropped.Room, dropped.Body)
+				m.focus = FocusDialog
+			}
+		}
+
+	case app.EventPluginNotice:
+		if notice, ok := event.Data.(app.PluginNotice); ok {
+			m.chat = m.chat.SetStatusMsg(notice.String())
+		}
+
+	case app.EventStatusHook:
+		if hook, ok := event.Data.(app.StatusHookEvent); ok {
+			switch {
+			case hook.Error != "":
+				m.chat = m.chat.SetStatusMsg(hook.Error)
+			case hook.Message != "":
+				m.chat = m.chat.SetStatusMsg("Status hook set " + hook.Status + ": " + hook.Message)
+			default:
+				m.chat = m.chat.SetStatusMsg("Status hook set " + hook.Status)
+			}
+		}
+
+	case app.EventAccountsLocked:
+		if m.focus != FocusDialog {
+			m.dialog = m.dialog.ShowUnlockAccounts()
+			m.focus = FocusDialog
+		}
 	}
 
 	return nil
 }
 
+// showError opens an error dialog saying what failed and why, with the
+// error chain as its details
+func (m *Model) showError(what string, err error) {
+	info := app.DescribeError(err)
+	m.dialog = m.dialog.ShowErrorDetails(info.Title, what+": "+info.String(), info.Details)
+}
+
 // executeCommand executes a command from the command line
 func (m *Model) executeCommand(cmd string, args []string) tea.Cmd {
 	return m.app.ExecuteCommand(cmd, args)
 }
 
+// confirmBulk asks before op is applied to contacts of the current account
+func (m *Model) confirmBulk(op app.BulkOp, arg string, jids []string) {
+	accountJID := m.rosterAccountJID()
+	if accountJID == "" {
+		m.chat = m.chat.SetStatusMsg("Select an account first")
+		return
+	}
+	m.dialog = m.dialog.ShowBulkConfirm(op.Describe(len(jids), arg), jids, map[string]string{
+		"op":      string(op),
+		"arg":     arg,
+		"account": accountJID,
+		"jids":    strings.Join(jids, "\n"),
+	})
+	m.focus = FocusDialog
+}
+
+// selectedContactJID returns the contact the roster, the contact details
+// or the chat is on, in that order
+func (m *Model) selectedContactJID() string {
+	switch {
+	case m.focus == FocusRoster && m.roster.FocusSection() == roster.SectionContacts:
+		return m.roster.SelectedJID()
+	case m.viewMode == ViewModeContactDetails:
+		return m.detailContactJID
+	case m.focus == FocusChat:
+		return m.windows.ActiveJID()
+	}
+	return ""
+}
+
+// showQuickActions opens the menu of what can be done with a contact,
+// each entry with the key that does the same directly
+func (m *Model) showQuickActions(jid string) {
+	contact := m.getContactDetailData(jid)
+	favorite := "Add to favorites"
+	if contact.Favorite {
+		favorite = "Remove from favorites"
+	}
+	mute := "Mute notifications"
+	if sound, set := m.app.GetConversationSound(jid); set && sound == "none" {
+		mute = "Unmute notifications"
+	}
+	items := []dialogs.MenuItem{
+		{ID: "open", Label: "Open chat", Key: m.keys.KeyFor(keybindings.ActionOpenChat)},
+		{ID: "window", Label: "Open in new window", Key: m.keys.KeyFor(keybindings.ActionOpenChatNew)},
+		{ID: "details", Label: "Details", Key: m.keys.KeyFor(keybindings.ActionShowDetails)},
+		{ID: "favorite", Label: favorite, Key: m.keys.KeyFor(keybindings.ActionToggleFavorite)},
+		{ID: "mute", Label: mute},
+		{ID: "verify", Label: "Verify OMEMO devices", Key: m.keys.KeyFor(keybindings.ActionVerifyFingerprint)},
+	}
+	if contact.AddedToRoster {
+		items = append(items, dialogs.MenuItem{ID: "remove", Label: "Remove from roster", Key: m.keys.KeyFor(keybindings.ActionRemoveContact)})
+	}
+	m.dialog = m.dialog.ShowQuickActions(jid, items)
+	m.focus = FocusDialog
+}
+
+// runQuickAction does what was picked from the quick actions menu
+func (m *Model) runQuickAction(id, jid string) tea.Cmd {
+	m.focus = FocusRoster
+	switch id {
+	case "open", "window":
+		if id == "open" {
+			m.openChat(jid)
+		} else {
+			m.openChatNewWindow(jid)
+		}
+		m.resetDetailViewState()
+		m.focus = FocusChat
+		m.keys.SetMode(keybindings.ModeInsert)
+	case "details":
+		m.viewMode = ViewModeContactDetails
+		m.detailContactJID = jid
+		m.detailAccountJID = ""
+	case "favorite":
+		return m.toggleFavorite(jid)
+	case "mute":
+		sound, note := "none", "Muted "
+		if current, set := m.app.GetConversationSound(jid); set && current == "none" {
+			sound, note = "default", "Unmuted "
+		}
+		if err := m.app.SetConversationSound(jid, sound); err != nil {
+			m.chat = m.chat.SetStatusMsg("Failed to change sound: " + err.Error())
+			return nil
+		}
+		m.chat = m.chat.SetStatusMsg(note + jid)
+	case "verify":
+		m.showOMEMOVerifyDialog(jid)
+	case "remove":
+		m.confirmBulk(app.BulkRemove, "", []string{jid})
+	}
+	return nil
+}
+
+// toggleFavorite adds a contact to the favorites or takes it out
+func (m *Model) toggleFavorite(jid string) tea.Cmd {
+	targetAccount := m.rosterAccountJID()
+	if targetAccount == "" {
+		m.dialog = m.dialog.ShowError("Select an account first.")
+		m.focus = FocusDialog
+		return nil
+	}
+
+	// Ensure we are not stuck in filter input state when toggling favorite.
+	if m.roster.InFilterMode() {
+		m.roster = m.roster.ExitFilterMode()
+		m.keys.SetMode(keybindings.ModeNormal)
+	}
+
+	// Show roster loading spinner while favorite is persisted.
+	m.rosterLoadingByAccount[localRosterOpKey] = true
+	m.roster = m.roster.SetLoading(true)
+	return tea.Batch(
+		rosterSpinnerTick(),
+		m.doToggleFavorite(targetAccount, jid),
+	)
+}
+
 func (m *Model) doToggleFavorite(accountJID, contactJID string) tea.Cmd {
 	return func() tea.Msg {
 		newState, err := m.app.ToggleContactFavoriteForAccount(accountJID, contactJID)
@@ -2045,6 +3069,53 @@ func (m *Model) openChat(jid string) {
 	m.chat = m.chat.SetInfoExpanded(false)
 }
 
+// openMessageHit opens the conversation of a listed message and scrolls to
+// it when it is among the messages loaded
+func (m *Model) openMessageHit(accountJID string, hit dialogs.MessageHit) {
+	if hit.Room {
+		m.windows = m.windows.OpenMUC(hit.JID, m.app.RoomNick(accountJID, hit.JID))
+		m.loadActiveWindow()
+	} else {
+		m.openChat(hit.JID)
+	}
+	m.resetDetailViewState()
+	m.focus = FocusChat
+	if hit.ID != "" {
+		var found bool
+		if m.chat, found = m.chat.JumpToMessage(hit.ID); !found {
+			m.chat = m.chat.SetStatusMsg("The message is older than the loaded history")
+		}
+		return
+	}
+	m.chat = m.chat.SearchPrev(hit.Body)
+}
+
+// showStarredDialog lists the account's starred messages
+func (m *Model) showStarredDialog(accountJID string, selected int) {
+	starred, err := m.app.StarredMessages(accountJID)
+	if err != nil {
+		m.showError("Failed to load starred messages", err)
+		m.focus = FocusDialog
+		return
+	}
+	hits := make([]dialogs.MessageHit, 0, len(starred))
+	for _, s := range starred {
+		hits = append(hits, dialogs.MessageHit{JID: s.JID, Room: s.Room, ID: s.ID, Who: s.Who, Body: s.Body, Timestamp: s.Timestamp})
+	}
+	m.dialog = m.dialog.ShowStarred(accountJID, hits, selected)
+	m.focus = FocusDialog
+}
+
+// refreshStarred marks the starred messages of the active conversation
+func (m *Model) refreshStarred() {
+	jid := m.windows.ActiveJID()
+	if jid == "" {
+		m.chat = m.chat.SetStarred(nil)
+		return
+	}
+	m.chat = m.chat.SetStarred(m.app.StarredIDs(m.rosterAccountJID(), jid))
+}
+
 // openChatNewWindow opens a chat in a separate window (or switches to existing matching one).
 func (m *Model) openChatNewWindow(jid string) {
 	accountJID := m.windows.GetActiveAccountJID()
@@ -2073,10 +3144,12 @@ func (m *Model) loadActiveWindow() {
 
 	// When no account is selected for the active context, hide chat content.
 	if m.rosterAccountJID() == "" {
+		m.muc = m.muc.SetActiveRoom("")
 		m.chat = m.chat.SetJID("")
 		m.chat = m.chat.SetHistory(nil)
 		m.chat = m.chat.SetContactData(nil)
 		m.chat = m.chat.SetInfoExpanded(false)
+		m.app.ConversationFocused("", "")
 		m.refreshRosterContacts()
 		return
 	}
@@ -2089,62 +3162,416 @@ func (m *Model) loadActiveWindow() {
 			m.app.TouchContactInteractionForAccount(accountJID, jid, time.Now())
 			m.refreshRosterContacts()
 		}
-		history := m.app.GetChatHistory(jid)
+		m.app.ConversationFocused(m.rosterAccountJID(), bareJID(jid))
+		history := m.app.GetChatHistoryForAccount(m.rosterAccountJID(), jid)
 		contactData := m.getContactDetailData(jid)
 		m.chat = m.chat.SetJID(jid)
+		m.chat = m.chat.SetSentHistory(m.app.SentInput(m.rosterAccountJID(), bareJID(jid)))
 		m.chat = m.chat.SetHistory(history)
 		m.chat = m.chat.SetContactData(&contactData)
+		m.chat = m.chat.SetSnippets(m.app.GetSnippets(m.rosterAccountJID()))
+		m.chat = m.chat.SetEncrypted(m.app.EncryptionEnabled(m.rosterAccountJID(), jid))
+		m.chat = m.chat.SetSaving(m.app.SavingEnabled(m.rosterAccountJID(), bareJID(jid)))
+		m.chat = m.chat.SetExpiry(m.app.ConversationExpiry(m.rosterAccountJID(), bareJID(jid)))
+		m.refreshIgnoredNicks()
+		m.refreshStarred()
+		m.refreshRoomActivity(history)
+		m.refreshSecurityBanner()
+		m.queueLinkPreviews(history)
 	} else {
 		// Console window - clear chat
+		m.app.ConversationFocused("", "")
 		m.chat = m.chat.SetJID("")
 		m.chat = m.chat.SetHistory(nil)
 		m.chat = m.chat.SetContactData(nil)
 		m.chat = m.chat.SetInfoExpanded(false)
 	}
-}
 
-// updateComponentSizes updates component dimensions based on window size
-func (m *Model) updateComponentSizes() {
-	rosterWidth := m.app.Config().UI.RosterWidth
+	// A room brings its nick list, which narrows the chat
+	nickList := m.muc.ParticipantsWidth()
+	m.syncActiveRoom()
+	if m.muc.ParticipantsWidth() != nickList {
+		m.updateComponentSizes()
+	}
+}
+
+// maxPreviewScan is how many recent messages are checked for links when a
+// conversation is opened
+const maxPreviewScan = 50
+
+// queueLinkPreviews schedules preview fetches for links in the active
+// conversation, if the user opted in for it
+func (m *Model) queueLinkPreviews(messages []chat.Message) {
+	jid := bareJID(m.windows.ActiveJID())
+	if jid == "" || !m.app.LinkPreviewsAllowed(m.rosterAccountJID(), jid) {
+		return
+	}
+	if len(messages) > maxPreviewScan {
+		messages = messages[len(messages)-maxPreviewScan:]
+	}
+	seen := make(map[string]bool)
+	for _, msg := range messages {
+		link := chat.FirstLink(msg.Body)
+		if link == "" || msg.Type == "system" || seen[link] {
+			continue
+		}
+		seen[link] = true
+		m.pendingPreviews = append(m.pendingPreviews, link)
+	}
+}
+
+// handleMacroRegister consumes the register key that follows Q or @ and
+// starts a recording or replays the register's keys.
+func (m *Model) handleMacroRegister(msg tea.KeyMsg) tea.Cmd {
+	action := m.macroPending
+	m.macroPending = keybindings.ActionNone
+	key := msg.String()
+
+	if action == keybindings.ActionRecordMacro {
+		if keybindings.IsMacroRegister(key) {
+			m.keys.StartRecording(rune(key[0]))
+		}
+		return nil
+	}
+
+	if key != "@" && !keybindings.IsMacroRegister(key) {
+		return nil
+	}
+	keys, reg := m.keys.Macro(rune(key[0]))
+	if len(keys) == 0 {
+		if reg == 0 {
+			m.chat = m.chat.SetStatusMsg("No macro replayed yet")
+		} else {
+			m.chat = m.chat.SetStatusMsg("Macro register @" + string(reg) + " is empty")
+		}
+		return nil
+	}
+
+	count := m.macroCount
+	if count < 1 {
+		count = 1
+	}
+	seq := make([]tea.Cmd, 0, len(keys)*count)
+	for i := 0; i < count; i++ {
+		for _, k := range keys {
+			k := k
+			seq = append(seq, func() tea.Msg { return macroKeyMsg{key: k} })
+		}
+	}
+	return tea.Sequence(seq...)
+}
+
+// activeRoomJID returns the room JID of the active window, or "" when the
+// active window is not a MUC
+func (m *Model) activeRoomJID() string {
+	if w := m.windows.Active(); w != nil && w.Type == windows.WindowMUC {
+		return w.JID
+	}
+	return ""
+}
+
+// refreshIgnoredNicks hides messages from occupants ignored in the active room
+func (m *Model) refreshIgnoredNicks() {
+	roomJID := m.activeRoomJID()
+	if roomJID == "" {
+		m.chat = m.chat.SetIgnoredNicks(nil)
+		return
+	}
+	m.chat = m.chat.SetIgnoredNicks(m.app.GetIgnoredOccupants(m.rosterAccountJID(), roomJID))
+}
+
+// refreshRoomActivity summarises what happened in the active room since the
+// last visit and marks it seen
+func (m *Model) refreshRoomActivity(history []chat.Message) {
+	roomJID := m.activeRoomJID()
+	if roomJID == "" {
+		m.chat = m.chat.SetRoomActivity("", -1, nil)
+		return
+	}
+	accountJID := m.rosterAccountJID()
+	activity, ok := m.app.GetRoomActivity(accountJID, roomJID, history)
+	m.app.MarkRoomSeen(accountJID, roomJID)
+	if !ok {
+		m.chat = m.chat.SetRoomActivity("", -1, nil)
+		return
+	}
+
+	banner := fmt.Sprintf("%d messages since you left", activity.Messages)
+	if activity.Messages == 1 {
+		banner = "1 message since you left"
+	}
+	switch len(activity.Mentions) {
+	case 0:
+	case 1:
+		banner += ", 1 mention"
+	default:
+		banner += fmt.Sprintf(", %d mentions", len(activity.Mentions))
+	}
+	banner += " — gn: first new"
+	if len(activity.Mentions) > 0 {
+		banner += ", gm: next mention"
+	}
+	m.chat = m.chat.SetRoomActivity(banner, activity.FirstNew, activity.Mentions)
+}
+
+// isIgnoredOccupant reports whether a room message comes from an occupant on
+// the room's ignore list
+func (m *Model) isIgnoredOccupant(accountJID, roomJID, from string) bool {
+	idx := strings.Index(from, "/")
+	if idx < 0 {
+		return false
+	}
+	nick := from[idx+1:]
+	for _, ignored := range m.app.GetIgnoredOccupants(accountJID, roomJID) {
+		if ignored == nick {
+			return true
+		}
+	}
+	return false
+}
+
+// showParticipantsDialog lists the occupants of the active room, keeping the
+// selection close to the given index
+func (m *Model) showParticipantsDialog(selected int) {
+	roomJID := m.activeRoomJID()
+	if roomJID == "" {
+		m.chat = m.chat.SetStatusMsg("Not in a room window")
+		return
+	}
+	accountJID := m.rosterAccountJID()
+	ignored := make(map[string]bool)
+	for _, nick := range m.app.GetIgnoredOccupants(accountJID, roomJID) {
+		ignored[nick] = true
+	}
+	var infos []dialogs.ParticipantInfo
+	for _, nick := range m.app.GetRoomOccupants(accountJID, roomJID) {
+		infos = append(infos, dialogs.ParticipantInfo{Nick: nick, Ignored: ignored[nick]})
+	}
+	m.dialog = m.dialog.ShowParticipants(accountJID, roomJID, infos, selected)
+	m.focus = FocusDialog
+}
+
+// showQueueDialog opens the outgoing message queue, keeping the selection
+// close to the given index
+func (m *Model) showQueueDialog(selected int) {
+	queue := m.app.GetOutgoingQueue()
+	infos := make([]dialogs.QueuedInfo, 0, len(queue))
+	for _, qm := range queue {
+		infos = append(infos, dialogs.QueuedInfo{
+			ID:         qm.ID,
+			AccountJID: qm.AccountJID,
+			To:         qm.To,
+			Body:       qm.Body,
+			State:      string(qm.State),
+			SendAt:     qm.SendAt,
+			Attempts:   qm.Attempts,
+			LastError:  qm.LastError,
+		})
+	}
+	m.dialog = m.dialog.ShowQueue(infos, selected)
+	m.focus = FocusDialog
+}
+
+// showSnippetsDialog opens the snippet manager for the active account
+func (m *Model) showSnippetsDialog() {
+	accountJID := m.rosterAccountJID()
+	snippets := m.app.GetSnippets(accountJID)
+
+	names := make([]string, 0, len(snippets))
+	for name := range snippets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	infos := make([]dialogs.SnippetInfo, 0, len(names))
+	for _, name := range names {
+		infos = append(infos, dialogs.SnippetInfo{
+			Name:    name,
+			Text:    snippets[name],
+			Account: m.app.IsAccountSnippet(accountJID, name),
+		})
+	}
+	m.dialog = m.dialog.ShowSnippets(accountJID, infos)
+	m.focus = FocusDialog
+}
+
+// showVoiceApproval asks to grant or deny a visitor's voice request
+func (m *Model) showVoiceApproval(req app.VoiceRequest) {
+	m.pendingVoice = &req
+	m.dialog = m.dialog.ShowVoiceApproval(req.Room, req.Who())
+	m.focus = FocusDialog
+}
+
+// refreshSecurityBanner shows the new OMEMO device banner if the active
+// contact has devices the user has not acknowledged yet, and otherwise the
+// message request banner for a sender not in the roster
+func (m *Model) refreshSecurityBanner() {
+	jid := m.windows.ActiveJID()
+	if jid == "" {
+		return
+	}
+	accountJID := m.rosterAccountJID()
+	devices := m.app.PendingNewDevices(accountJID, jid)
+	if len(devices) == 0 {
+		banner := ""
+		if m.app.HeldAsRequest(accountJID, bareJID(jid)) {
+			banner = "Message request — :accept adds them to the roster, :reject or :block"
+		}
+		m.chat = m.chat.SetSecurityBanner(banner, false)
+		return
+	}
+
+	banner := "New device detected — verify fingerprints (v) or dismiss (gD)"
+	if len(devices) > 1 {
+		banner = fmt.Sprintf("%d new devices detected — verify fingerprints (v) or dismiss (gD)", len(devices))
+	}
+	m.chat = m.chat.SetSecurityBanner(banner, m.app.EncryptedSendingPaused(accountJID, jid))
+}
+
+// Roster pane limits, matching the range offered in settings
+const (
+	minRosterWidth   = 20
+	maxRosterWidth   = 60
+	minChatWidth     = 60
+	rosterResizeStep = 2
+)
+
+// rosterPaneWidth returns the roster width that fits the terminal. View and
+// updateComponentSizes both use it so the panes always agree.
+func (m *Model) rosterPaneWidth() int {
 	if !m.showRoster {
+		return 0
+	}
+	rosterWidth := m.app.Config().UI.RosterWidth
+	if m.width-rosterWidth < minChatWidth {
+		rosterWidth = m.width - minChatWidth
+	}
+	if rosterWidth < minRosterWidth {
+		rosterWidth = minRosterWidth
+	}
+	if rosterWidth > m.width-20 {
+		rosterWidth = m.width - 20
+	}
+	if rosterWidth < 0 {
 		rosterWidth = 0
+	}
+	return rosterWidth
+}
+
+// resizeRoster sets the roster width, saving it to the config when persist
+// is set. Dragging only persists once the mouse is released.
+func (m *Model) resizeRoster(width int, persist bool) {
+	if width < minRosterWidth {
+		width = minRosterWidth
+	}
+	if width > maxRosterWidth {
+		width = maxRosterWidth
+	}
+	if persist {
+		m.app.SetSetting("roster_width", strconv.Itoa(width))
 	} else {
-		// Scale sidebar a bit with terminal width so it doesn't feel too narrow
-		// on larger terminals, while preserving a comfortable chat area.
-		proportionalWidth := m.width / 5 // ~20% of terminal width
-		if proportionalWidth > rosterWidth {
-			rosterWidth = proportionalWidth
-		}
+		m.app.Config().UI.RosterWidth = width
+	}
+	m.updateComponentSizes()
+}
 
-		minChatWidth := 60
-		if m.width-rosterWidth < minChatWidth {
-			rosterWidth = m.width - minChatWidth
+// handleMouse lets the divider between roster and chat be dragged and
+// status bar items be clicked
+func (m *Model) handleMouse(msg tea.MouseMsg) {
+	rosterWidth := m.rosterPaneWidth()
+	switch msg.Action {
+	case tea.MouseActionPress:
+		if msg.Button == tea.MouseButtonLeft && msg.Y == m.height-1 {
+			if id := m.statusbar.ItemAt(msg.X); id != "" {
+				m.app.ActivateStatusItem(id)
+			}
+			return
 		}
-		if rosterWidth < 24 {
-			rosterWidth = 24
+		top := m.tabBarHeight()
+		mainHeight := m.height - 2 - top // status bar and command line
+		onDivider := msg.X == rosterWidth-1 || msg.X == rosterWidth
+		if msg.Button == tea.MouseButtonLeft && rosterWidth > 0 && onDivider && msg.Y >= top && msg.Y < top+mainHeight {
+			m.draggingDivider = true
 		}
-		if rosterWidth > m.width-20 {
-			rosterWidth = m.width - 20
+	case tea.MouseActionMotion:
+		if m.draggingDivider {
+			m.resizeRoster(msg.X+1, false)
 		}
-		if rosterWidth < 0 {
-			rosterWidth = 0
+	case tea.MouseActionRelease:
+		if m.draggingDivider {
+			m.draggingDivider = false
+			m.resizeRoster(msg.X+1, true)
 		}
 	}
+}
+
+// applyTheme switches to the configured theme and restyles all components
+func (m *Model) applyTheme() {
+	if err := m.themes.SetTheme(m.app.Config().UI.Theme); err != nil {
+		return
+	}
+	styles := m.themes.Styles()
+	m.roster = roster.New(styles).SetContacts(m.currentRosterContacts())
+	m.chat = chat.New(styles)
+	m.statusbar = statusbar.New(styles)
+	m.commandline = commandline.New(styles)
+	m.syncPluginCommands()
+	m.dialog = dialogs.New(styles)
+}
+
+// chatLayout returns the configured chat layout, falling back to the theme's
+func (m *Model) chatLayout() string {
+	if layout := m.app.Config().UI.ChatLayout; layout != "" {
+		return layout
+	}
+	return m.themes.Current().Chat.Layout
+}
+
+// updateComponentSizes updates component dimensions based on window size
+func (m *Model) updateComponentSizes() {
+	rosterWidth := m.rosterPaneWidth()
 	chatWidth := m.width - rosterWidth
 	if chatWidth < 20 {
 		chatWidth = 20
 	}
 
+	m.statusbar = m.statusbar.SetWindowListMode(m.app.Config().UI.WindowList)
+	m.chat = m.chat.SetTimeFormat(m.app.TimeFormat())
+	ui := m.app.Config().UI
+	m.chat = m.chat.SetWrap(ui.ChatWrap, ui.ChatMaxWidth, ui.NickColumn)
+	m.chat = m.chat.SetLayout(m.chatLayout())
+	m.roster = m.roster.SetGrouping(ui.RosterGroup)
+	m.roster = m.roster.SetColumns(ui.RosterColumns)
+	m.dialog = m.dialog.SetTimeFormat(m.app.TimeFormat())
+
 	statusHeight := 1
 	cmdHeight := 1
-	mainHeight := m.height - statusHeight - cmdHeight
+	mainHeight := m.height - statusHeight - cmdHeight - m.tabBarHeight()
 
+	nickList := m.nickListWidth(chatWidth)
 	m.roster = m.roster.SetSize(rosterWidth, mainHeight)
-	m.chat = m.chat.SetSize(chatWidth, mainHeight)
+	m.chat = m.chat.SetSize(chatWidth-nickList, mainHeight)
+	m.muc = m.muc.SetSize(nickList, mainHeight-2)
 	m.statusbar = m.statusbar.SetWidth(m.width)
 	m.commandline = m.commandline.SetWidth(m.width)
 }
 
+// bumpWindow moves the window of a conversation that received a message to
+// the front when windows are ordered by activity
+func (m *Model) bumpWindow(jid, accountJID string) {
+	if m.app.Config().UI.WindowOrder == "activity" {
+		m.windows = m.windows.Bump(jid, accountJID)
+	}
+}
+
+// tabBarHeight is the number of lines taken by the window tab bar
+func (m Model) tabBarHeight() int {
+	if m.statusbar.HasTabBar() {
+		return 1
+	}
+	return 0
+}
+
 func (m *Model) rosterAccountJID() string {
 	accountJID := m.windows.GetActiveAccountJID()
 	if accountJID != "" {
@@ -2158,13 +3585,56 @@ func (m *Model) currentRosterContacts() []roster.Roster {
 	if accountJID == "" {
 		return nil
 	}
-	return m.app.GetContactsForAccount(accountJID)
+	contacts := m.app.GetContactsForAccount(accountJID)
+	if m.roster.ShowsColumn(roster.ColumnLock) {
+		for i := range contacts {
+			contacts[i].Encrypted = m.app.EncryptionEnabled(contacts[i].AccountJID, contacts[i].JID)
+		}
+	}
+	return contacts
+}
+
+// connectAccount starts connecting an account behind a loading dialog, whose
+// Cancel aborts the attempt. If another dialog is open the connection runs
+// without one.
+func (m *Model) connectAccount(jid string) tea.Cmd {
+	cmd := m.app.DoConnect(jid)
+	if m.dialog.Active() {
+		return cmd
+	}
+
+	a := m.app
+	m.dialog = m.dialog.ShowLoading("Connecting to "+jid+"...", dialogs.OpConnect)
+	m.focus = FocusDialog
+	m.connectDialogJID = jid
+	a.RegisterOperation(dialogs.OpConnect, func() { a.CancelConnect(jid) })
+	return tea.Batch(cmd, dialogs.SpinnerTick())
 }
 
 func (m *Model) refreshRosterContacts() {
 	m.roster = m.roster.SetContacts(m.currentRosterContacts())
 }
 
+// autoConnectProgress summarizes the startup connections
+func (m Model) autoConnectProgress() string {
+	done := m.autoConnectTotal - len(m.autoConnectPending)
+	if len(m.autoConnectPending) > 0 {
+		msg := fmt.Sprintf("Connecting accounts: %d of %d done", done, m.autoConnectTotal)
+		if m.autoConnectFailed > 0 {
+			msg += fmt.Sprintf(", %d failed", m.autoConnectFailed)
+		}
+		return msg
+	}
+	if m.autoConnectFailed > 0 {
+		return fmt.Sprintf("Connected %d of %d accounts; %d failed, see the account list",
+			done-m.autoConnectFailed, m.autoConnectTotal, m.autoConnectFailed)
+	}
+	if m.autoConnectTotal == 1 {
+		return "Connected 1 account"
+	}
+	return fmt.Sprintf("Connected %d accounts", m.autoConnectTotal)
+}
+
 // getAccountDisplays converts all accounts to roster display format
 func (m Model) getAccountDisplays() []roster.AccountDisplay {
 	accounts := m.app.GetAllAccountsDisplay()
@@ -2183,6 +3653,8 @@ func (m Model) getAccountDisplays() []roster.AccountDisplay {
 			Session:     acc.Session,
 			AutoConnect: acc.AutoConnect,
 			RosterSync:  syncing,
+			Step:        acc.Step,
+			Failure:     acc.Failure,
 		}
 	}
 	return displays
@@ -2203,6 +3675,9 @@ func (m *Model) getWindowInfos() []statusbar.WindowInfo {
 		if idx := strings.Index(title, "@"); idx > 0 {
 			title = title[:idx]
 		}
+		if w.Name != "" {
+			title = w.Name
+		}
 
 		infos[i] = statusbar.WindowInfo{
 			Num:    i + 1, // 1-indexed for display
@@ -2235,6 +3710,7 @@ func (m *Model) saveWindows() {
 			Type:   windowType,
 			JID:    w.JID,
 			Title:  w.Title,
+			Name:   w.Name,
 			Active: i == activeNum,
 		}
 	}
@@ -2242,6 +3718,14 @@ func (m *Model) saveWindows() {
 	_ = m.app.SaveWindowState(states)
 }
 
+// persistWindows saves window names and order when window state saving
+// is enabled
+func (m *Model) persistWindows() {
+	if m.app.Config().Storage.SaveWindowState {
+		m.saveWindows()
+	}
+}
+
 // loadWindows loads the saved window state
 func (m *Model) loadWindows() {
 	states, err := m.app.LoadWindowState()
@@ -2263,6 +3747,7 @@ func (m *Model) loadWindows() {
 			case "muc":
 				m.windows = m.windows.OpenMUC(state.JID, "")
 			}
+			m.windows = m.windows.SetName(state.JID, state.Name)
 		}
 
 		if state.Active {
@@ -2301,6 +3786,149 @@ func (m *Model) overlayDialog(base string) string {
 	)
 }
 
+// scheduleWhichKey starts the timer that opens the which-key popup when the
+// last key left a multi-key binding unfinished
+func (m *Model) scheduleWhichKey() tea.Cmd {
+	prefix := m.keys.PendingKeys()
+	delay := m.app.Config().UI.WhichKeyDelay
+	if prefix == "" || delay < 0 || m.keys.Mode() != keybindings.ModeNormal {
+		return nil
+	}
+	seq := m.whichKeySeq
+	return tea.Tick(time.Duration(delay)*time.Millisecond, func(time.Time) tea.Msg {
+		return whichKeyMsg{prefix: prefix, seq: seq}
+	})
+}
+
+// mainView renders the roster and chat panes
+func (m Model) mainView(styles *theme.Styles, mainHeight int) string {
+	var mainView string
+	rosterWidth := m.rosterPaneWidth()
+	chatWidth := m.width - rosterWidth
+
+	if m.showRoster && rosterWidth > 0 {
+		rosterView := m.roster.View()
+
+		// Render chat view based on current view mode
+		var chatView string
+		switch m.viewMode {
+		case ViewModeAccountDetails:
+			// Render account details instead of chat
+			acc := m.getAccountDetailData(m.detailAccountJID)
+			chatView = m.chat.RenderAccountDetails(acc)
+		case ViewModeContactDetails:
+			// Render contact details instead of chat
+			contact := m.getContactDetailData(m.detailContactJID)
+			chatView = m.chat.RenderContactDetails(contact)
+		case ViewModeAccountEdit:
+			// Render account edit view
+			chatView = m.chat.RenderAccountEdit(m.accountEditData)
+		default:
+			chatView = m.chat.View()
+		}
+
+		// Apply focus styling using lipgloss.Place to avoid corrupting ANSI codes in long messages
+		rosterView = lipgloss.Place(rosterWidth-2, mainHeight-2, lipgloss.Left, lipgloss.Top, rosterView)
+		if m.focus == FocusRoster || m.focus == FocusAccounts {
+			rosterView = styles.WindowActive.Render(rosterView)
+		} else {
+			rosterView = styles.WindowInactive.Render(rosterView)
+		}
+
+		// Detail/edit views are not "focused" in the traditional sense, but still active
+		isDetailOrEditView := m.viewMode == ViewModeAccountDetails || m.viewMode == ViewModeContactDetails || m.viewMode == ViewModeAccountEdit
+		nickList := m.nickListWidth(chatWidth)
+		chatView = lipgloss.Place(chatWidth-nickList-2, mainHeight-2, lipgloss.Left, lipgloss.Top, chatView)
+		if m.focus == FocusChat || isDetailOrEditView {
+			chatView = styles.WindowActive.Render(chatView)
+		} else {
+			chatView = styles.WindowInactive.Render(chatView)
+		}
+
+		mainView = lipgloss.JoinHorizontal(lipgloss.Top, rosterView, chatView, m.nickListView(styles, nickList, mainHeight))
+	} else {
+		// Render chat view based on current view mode
+		var chatView string
+		switch m.viewMode {
+		case ViewModeAccountDetails:
+			acc := m.getAccountDetailData(m.detailAccountJID)
+			chatView = m.chat.RenderAccountDetails(acc)
+		case ViewModeContactDetails:
+			contact := m.getContactDetailData(m.detailContactJID)
+			chatView = m.chat.RenderContactDetails(contact)
+		case ViewModeAccountEdit:
+			chatView = m.chat.RenderAccountEdit(m.accountEditData)
+		default:
+			chatView = m.chat.View()
+		}
+
+		isDetailOrEditView := m.viewMode == ViewModeAccountDetails || m.viewMode == ViewModeContactDetails || m.viewMode == ViewModeAccountEdit
+		nickList := m.nickListWidth(m.width)
+		chatView = lipgloss.Place(m.width-nickList-2, mainHeight-2, lipgloss.Left, lipgloss.Top, chatView)
+		if m.focus == FocusChat || isDetailOrEditView {
+			chatView = styles.WindowActive.Render(chatView)
+		} else {
+			chatView = styles.WindowInactive.Render(chatView)
+		}
+		mainView = lipgloss.JoinHorizontal(lipgloss.Top, chatView, m.nickListView(styles, nickList, mainHeight))
+	}
+	return mainView
+}
+
+// overlayWhichKey draws the continuations of the pending prefix in columns
+// over the bottom of the main view, above the status bar
+func (m *Model) overlayWhichKey(base string) string {
+	entries := m.keys.Continuations(m.whichKeyPrefix)
+	if len(entries) == 0 {
+		return base
+	}
+	styles := m.themes.Styles()
+
+	cellWidth := 0
+	cells := make([]string, len(entries))
+	for i, entry := range entries {
+		cells[i] = m.whichKeyPrefix + entry.Keys[0] + "  " + entry.Description
+		cellWidth = max(cellWidth, lipgloss.Width(cells[i]))
+	}
+	cellWidth += 3
+	innerWidth := max(m.width-4, cellWidth)
+	columns := max(innerWidth/cellWidth, 1)
+	rowCount := (len(cells) + columns - 1) / columns
+
+	var rows []string
+	for r := 0; r < rowCount; r++ {
+		var row strings.Builder
+		for c := 0; c < columns; c++ {
+			// Fill columns top to bottom so entries read downwards
+			i := c*rowCount + r
+			if i >= len(cells) {
+				break
+			}
+			key := m.whichKeyPrefix + entries[i].Keys[0]
+			if entries[i].Disabled {
+				// Greyed out, the key only says it is not available
+				row.WriteString(styles.PresenceOffline.Render(cells[i]))
+			} else {
+				row.WriteString(styles.DialogTitle.Render(key) + "  " + entries[i].Description)
+			}
+			row.WriteString(strings.Repeat(" ", cellWidth-lipgloss.Width(cells[i])))
+		}
+		rows = append(rows, row.String())
+	}
+	popup := styles.DialogBorder.Width(innerWidth).Render(strings.Join(rows, "\n"))
+
+	// Replace the lines just above the status bar
+	lines := strings.Split(base, "\n")
+	popupLines := strings.Split(popup, "\n")
+	start := max(len(lines)-1-len(popupLines), 0)
+	for i, line := range popupLines {
+		if start+i < len(lines)-1 {
+			lines[start+i] = line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
 // overlaySettings overlays the settings menu on top of the main view
 func (m *Model) overlaySettings(base string) string {
 	settingsView := m.settings.View()
@@ -2317,44 +3945,564 @@ func (m *Model) overlaySettings(base string) string {
 func (m *Model) handleCommandAction(msg app.CommandActionMsg) {
 	switch msg.Action {
 	case app.ActionShowHelp:
-		m.dialog = m.dialog.ShowHelp(nil)
+		commands := m.commandline.GetCommands()
+		if name, _ := msg.Data["command"].(string); name != "" {
+			cmd, ok := commands[name]
+			if !ok {
+				m.chat = m.chat.SetStatusMsg("Unknown command: " + name)
+				return
+			}
+			m.dialog = m.dialog.ShowCommandHelp(cmd)
+			m.focus = FocusDialog
+			return
+		}
+		list := make([]commandline.Command, 0, len(commands))
+		for _, cmd := range commands {
+			list = append(list, cmd)
+		}
+		m.dialog = m.dialog.ShowHelp(m.keys.Help(), list)
+		m.focus = FocusDialog
+
+	case app.ActionShowAccountList:
+		appAccounts := m.app.GetAccountInfos()
+		accounts := make([]dialogs.AccountInfo, len(appAccounts))
+		for i, a := range appAccounts {
+			accounts[i] = dialogs.AccountInfo{JID: a.JID, Session: a.Session}
+		}
+		m.dialog = m.dialog.ShowAccountList(accounts, m.app.CurrentAccount())
+		m.focus = FocusDialog
+
+	case app.ActionShowAccountAdd:
+		m.dialog = m.dialog.ShowAccountAdd()
+		m.focus = FocusDialog
+
+	case app.ActionShowAccountEdit:
+		if jid, ok := msg.Data["jid"].(string); ok {
+			acc := m.app.GetAccount(jid)
+			if acc != nil {
+				m.dialog = m.dialog.ShowAccountEdit(acc.JID, acc.Server, acc.Port, acc.Resource)
+				m.focus = FocusDialog
+			}
+		}
+
+	case app.ActionShowPassword:
+		if jid, ok := msg.Data["jid"].(string); ok {
+			m.dialog = m.dialog.ShowPassword(jid)
+			m.focus = FocusDialog
+		}
+
+	case app.ActionShowSettings:
+		settings := m.app.GetSettings()
+		m.dialog = m.dialog.ShowSettingsList(settings)
+		m.focus = FocusDialog
+
+	case app.ActionShowRegister:
+		m.dialog = m.dialog.ShowRegister()
+		m.focus = FocusDialog
+
+	case app.ActionShowOMEMOExport, app.ActionShowOMEMOImport:
+		accountJID := m.rosterAccountJID()
+		connected := accountJID != "" && m.app.IsAccountConnected(accountJID)
+		switch {
+		case accountJID == "":
+			m.dialog = m.dialog.ShowError("Select an account to manage its OMEMO keys")
+		case msg.Action == app.ActionShowOMEMOExport && !connected:
+			m.dialog = m.dialog.ShowError("Connect the account before exporting its OMEMO keys")
+		case msg.Action == app.ActionShowOMEMOExport:
+			m.dialog = m.dialog.ShowOMEMOExport(accountJID)
+		case connected:
+			m.dialog = m.dialog.ShowError("Disconnect the account before importing OMEMO keys")
+		default:
+			m.dialog = m.dialog.ShowOMEMOImport(accountJID)
+		}
+		m.focus = FocusDialog
+
+	case app.ActionOpenURI:
+		raw, _ := msg.Data["uri"].(string)
+		m.openXMPPURI(raw)
+
+	case app.ActionShareLink:
+		target, _ := msg.Data["target"].(string)
+		m.shareLink(target)
+
+	case app.ActionShowSnippets:
+		m.showSnippetsDialog()
+
+	case app.ActionShowQueue:
+		m.showQueueDialog(0)
+
+	case app.ActionShowParticipants:
+		m.showParticipantsDialog(0)
+
+	case app.ActionSetRoomNotify:
+		roomJID := m.activeRoomJID()
+		if roomJID == "" {
+			m.chat = m.chat.SetStatusMsg("Open a room to set its notifications")
+			return
+		}
+		accountJID := m.rosterAccountJID()
+		if mode, _ := msg.Data["mode"].(string); mode != "" {
+			if err := m.app.SetRoomNotify(accountJID, roomJID, mode); err != nil {
+				m.chat = m.chat.SetStatusMsg("Failed to set notifications: " + err.Error())
+				return
+			}
+		}
+		m.chat = m.chat.SetStatusMsg("Notifications for " + roomJID + ": " + m.app.GetRoomSettings(accountJID, roomJID).Notify)
+
+	case app.ActionSetRoomHistory:
+		roomJID := m.activeRoomJID()
+		if roomJID == "" {
+			m.chat = m.chat.SetStatusMsg("Open a room to set its history size")
+			return
+		}
+		accountJID := m.rosterAccountJID()
+		if arg, _ := msg.Data["size"].(string); arg != "" {
+			size := 0
+			if arg != "default" {
+				n, err := strconv.Atoi(arg)
+				if err != nil || n < 1 {
+					m.chat = m.chat.SetStatusMsg("Usage: :history [size|default]")
+					return
+				}
+				size = n
+			}
+			if err := m.app.SetRoomHistoryLimit(accountJID, roomJID, size); err != nil {
+				m.chat = m.chat.SetStatusMsg("Failed to set history size: " + err.Error())
+				return
+			}
+		}
+		size := m.app.GetRoomSettings(accountJID, roomJID).HistoryLimit
+		m.chat = m.chat.SetStatusMsg(fmt.Sprintf("%s loads its last %d stored messages when opened", roomJID, size))
+
+	case app.ActionStatusItems:
+		items := m.app.StatusItems()
+		if len(items) == 0 {
+			m.chat = m.chat.SetStatusMsg("No plugin has put items in the status bar")
+			return
+		}
+		var b strings.Builder
+		for i, item := range items {
+			fmt.Fprintf(&b, "%d  %-16s %s\n", i+1, item.ID, item.Text)
+		}
+		b.WriteString("\nActivate one with :statusbar <number|id> or by clicking it")
+		m.dialog = m.dialog.ShowContextHelp("Status Bar Items", b.String())
+		m.focus = FocusDialog
+
+	case app.ActionContactRequests:
+		requests := m.app.ContactRequests(m.rosterAccountJID())
+		if len(requests) == 0 {
+			m.chat = m.chat.SetStatusMsg("No pending contact requests")
+			return
+		}
+		var b strings.Builder
+		for _, r := range requests {
+			what := "wrote to you"
+			if r.Kind == app.RequestSubscription {
+				what = "asks to see your presence"
+			}
+			fmt.Fprintf(&b, "%-32s %s\n", r.JID, what)
+		}
+		b.WriteString("\nOpen one from the Requests section of the roster,\nthen :accept, :reject or :block it")
+		m.dialog = m.dialog.ShowContextHelp("Contact Requests", b.String())
+		m.focus = FocusDialog
+
+	case app.ActionAnswerRequest:
+		jid, _ := msg.Data["jid"].(string)
+		answer, _ := msg.Data["answer"].(string)
+		if jid == "" {
+			jid = bareJID(m.windows.ActiveJID())
+		}
+		if jid == "" && m.focus == FocusRoster {
+			jid = m.roster.SelectedJID()
+		}
+		accountJID := m.rosterAccountJID()
+		if answer == "unblock" && jid == "" && accountJID != "" {
+			blocked := m.app.BlockedContacts(accountJID)
+			if len(blocked) == 0 {
+				m.chat = m.chat.SetStatusMsg("No blocked JIDs")
+				return
+			}
+			m.dialog = m.dialog.ShowContextHelp("Blocked", strings.Join(blocked, "\n")+"\n\nUnblock one with :unblock <jid>")
+			m.focus = FocusDialog
+			return
+		}
+		if jid == "" || accountJID == "" {
+			m.chat = m.chat.SetStatusMsg("Open the request's chat or give its JID")
+			return
+		}
+		var err error
+		switch answer {
+		case "accept":
+			err = m.app.AcceptContactRequest(accountJID, jid)
+		case "reject":
+			err = m.app.RejectContactRequest(accountJID, jid)
+		case "block":
+			err = m.app.BlockContact(accountJID, jid)
+		case "unblock":
+			err = m.app.UnblockContact(accountJID, jid)
+		}
+		switch {
+		case err != nil:
+			m.chat = m.chat.SetStatusMsg(err.Error())
+		default:
+			done := map[string]string{
+				"accept":  "Accepted " + jid + " into the roster",
+				"reject":  "Rejected " + jid,
+				"block":   "Blocked " + jid,
+				"unblock": "Unblocked " + jid,
+			}
+			m.chat = m.chat.SetStatusMsg(done[answer])
+		}
+		m.refreshRosterContacts()
+		switch {
+		case answer == "accept" && err == nil && bareJID(m.windows.ActiveJID()) != jid:
+			// The conversation so far continues as a normal chat
+			m.openChat(jid)
+		case answer == "block" && bareJID(m.windows.ActiveJID()) == jid:
+			m.windows = m.windows.CloseActive()
+			m.loadActiveWindow()
+		default:
+			m.refreshSecurityBanner()
+		}
+
+	case app.ActionRequestVoice:
+		if pending := m.app.PendingVoiceRequests(); len(pending) > 0 {
+			m.showVoiceApproval(pending[0])
+			return
+		}
+		room := bareJID(m.windows.ActiveJID())
+		if room == "" || !m.app.VoiceBlocked(m.rosterAccountJID(), room) {
+			m.chat = m.chat.SetStatusMsg("No voice to ask for here and no voice requests waiting")
+			return
+		}
+		if err := m.app.RequestVoice(m.rosterAccountJID(), room); err != nil {
+			m.chat = m.chat.SetStatusMsg("Voice request failed: " + err.Error())
+			return
+		}
+		m.chat = m.chat.SetStatusMsg("Asked the moderators of " + room + " for voice")
+
+	case app.ActionAccountStats:
+		accountJID := m.rosterAccountJID()
+		if accountJID == "" {
+			m.chat = m.chat.SetStatusMsg("No account selected")
+			return
+		}
+		if reset, _ := msg.Data["reset"].(bool); reset {
+			m.app.ResetAccountStats(accountJID)
+			m.chat = m.chat.SetStatusMsg("Statistics of " + accountJID + " reset")
+		}
+		m.viewMode = ViewModeAccountDetails
+		m.detailAccountJID = accountJID
+
+	case app.ActionSearchHistory:
+		accountJID := m.rosterAccountJID()
+		if accountJID == "" {
+			m.chat = m.chat.SetStatusMsg("No account selected")
+			return
+		}
+		query, _ := msg.Data["query"].(string)
+		found, err := m.app.SearchHistory(accountJID, query)
+		if err != nil {
+			m.chat = m.chat.SetStatusMsg("Search failed: " + err.Error())
+			return
+		}
+		hits := make([]dialogs.MessageHit, 0, len(found))
+		for _, h := range found {
+			hits = append(hits, dialogs.MessageHit{JID: h.JID, Room: h.Room, Who: h.Who, Body: h.Body, Timestamp: h.Timestamp})
+		}
+		m.dialog = m.dialog.ShowSearchResults(accountJID, query, hits)
 		m.focus = FocusDialog
 
-	case app.ActionShowAccountList:
-		appAccounts := m.app.GetAccountInfos()
-		accounts := make([]dialogs.AccountInfo, len(appAccounts))
-		for i, a := range appAccounts {
-			accounts[i] = dialogs.AccountInfo{JID: a.JID, Session: a.Session}
+	case app.ActionShowStarred:
+		accountJID := m.rosterAccountJID()
+		if accountJID == "" {
+			m.chat = m.chat.SetStatusMsg("No account selected")
+			return
+		}
+		m.showStarredDialog(accountJID, 0)
+
+	case app.ActionPluginPermissions:
+		name, _ := msg.Data["name"].(string)
+		m.dialog = m.dialog.ShowContextHelp("Plugin Permissions", m.app.PluginPermissionReport(name))
+		m.focus = FocusDialog
+
+	case app.ActionDatabaseInfo:
+		m.dialog = m.dialog.ShowContextHelp("Database", m.app.DatabaseReport())
+		m.focus = FocusDialog
+		m.chat = m.chat.SetStorageBanner(m.app.StorageBanner())
+
+	case app.ActionEncryptAccounts:
+		if m.app.AccountsEncrypted() {
+			m.chat = m.chat.SetStatusMsg("The accounts file is already encrypted")
+			return
+		}
+		m.dialog = m.dialog.ShowEncryptAccounts()
+		m.focus = FocusDialog
+
+	case app.ActionDecryptAccounts:
+		if !m.app.AccountsEncrypted() {
+			m.chat = m.chat.SetStatusMsg("The accounts file is not encrypted")
+			return
+		}
+		if err := m.app.DecryptAccounts(); err != nil {
+			if errors.Is(err, config.ErrAccountsLocked) {
+				m.dialog = m.dialog.ShowUnlockAccounts()
+				m.focus = FocusDialog
+				return
+			}
+			m.chat = m.chat.SetStatusMsg("Failed to decrypt accounts: " + err.Error())
+			return
+		}
+		m.chat = m.chat.SetStatusMsg("The accounts file is stored in plain text again")
+
+	case app.ActionRosterConflicts:
+		if !m.showRosterConflict() {
+			m.chat = m.chat.SetStatusMsg("No roster conflicts")
+		}
+
+	case app.ActionUnlockAccounts:
+		if !m.app.AccountsEncrypted() {
+			m.chat = m.chat.SetStatusMsg("The accounts file is not encrypted")
+			return
+		}
+		m.dialog = m.dialog.ShowUnlockAccounts()
+		m.focus = FocusDialog
+
+	case app.ActionExportChat:
+		jid := bareJID(m.windows.ActiveJID())
+		accountJID := m.rosterAccountJID()
+		if jid == "" || accountJID == "" {
+			m.chat = m.chat.SetStatusMsg("Open a chat or room to export it")
+			return
+		}
+		args, _ := msg.Data["args"].([]string)
+		profile := app.ExportMarkdown
+		if len(args) > 0 && args[0] == string(app.ExportMarkdown) {
+			args = args[1:]
+		}
+		dir := strings.Join(args, " ")
+		result, err := m.app.ExportChat(accountJID, jid, profile, dir)
+		if err != nil {
+			m.chat = m.chat.SetStatusMsg("Export failed: " + err.Error())
+			return
+		}
+		m.chat = m.chat.SetStatusMsg(fmt.Sprintf("Exported %d messages in %d files to %s", result.Messages, len(result.Files), result.Dir))
+
+	case app.ActionBulk:
+		args, _ := msg.Data["args"].([]string)
+		usage := "Usage: :bulk group <groups>|tag <groups>|remove|mute|unmute|export"
+		if len(args) == 0 {
+			m.chat = m.chat.SetStatusMsg(usage)
+			return
+		}
+		op, ok := app.ParseBulkOp(args[0])
+		if !ok {
+			m.chat = m.chat.SetStatusMsg(usage)
+			return
+		}
+		arg := strings.Join(args[1:], " ")
+		if op == app.BulkTag && arg == "" {
+			m.chat = m.chat.SetStatusMsg("Usage: :bulk tag <groups>")
+			return
+		}
+		jids := m.roster.MarkedJIDs()
+		if len(jids) == 0 {
+			m.chat = m.chat.SetStatusMsg("Mark contacts with space or V first")
+			return
+		}
+		m.confirmBulk(op, arg, jids)
+
+	case app.ActionSetSound:
+		jid := m.windows.ActiveJID()
+		if jid == "" {
+			m.chat = m.chat.SetStatusMsg("Open a chat or room to set its sound")
+			return
+		}
+		sound, _ := msg.Data["sound"].(string)
+		if sound == "" {
+			current, own := m.app.GetConversationSound(jid)
+			if !own {
+				current += " (default)"
+			}
+			m.chat = m.chat.SetStatusMsg("Sound for " + jid + ": " + current)
+			return
+		}
+		if err := m.app.SetConversationSound(jid, sound); err != nil {
+			m.chat = m.chat.SetStatusMsg("Failed to set sound: " + err.Error())
+			return
+		}
+		current, _ := m.app.GetConversationSound(jid)
+		m.chat = m.chat.SetStatusMsg("Sound for " + jid + ": " + current)
+
+	case app.ActionCycleChatLayout:
+		layout := chat.NextLayout(m.chat.Layout())
+		m.app.SetSetting("chat_layout", layout)
+		m.chat = m.chat.SetLayout(layout)
+		m.chat = m.chat.SetStatusMsg("Chat layout: " + layout)
+
+	case app.ActionSettingChanged:
+		// Apply :set changes right away
+		if key, _ := msg.Data["key"].(string); key == "theme" {
+			m.applyTheme()
+			m.loadActiveWindow()
 		}
-		m.dialog = m.dialog.ShowAccountList(accounts, m.app.CurrentAccount())
-		m.focus = FocusDialog
+		m.updateComponentSizes()
+		m.refreshRosterContacts()
+		if key, _ := msg.Data["key"].(string); key == "roster_group" {
+			m.chat = m.chat.SetStatusMsg("Roster grouping: " + m.roster.Grouping())
+		}
+
+	case app.ActionLinkPreview:
+		jid := bareJID(m.windows.ActiveJID())
+		accountJID := m.rosterAccountJID()
+		if jid == "" || accountJID == "" {
+			m.chat = m.chat.SetStatusMsg("Open a chat to change its link previews")
+			return
+		}
+		args, _ := msg.Data["args"].([]string)
+		contact := jid
+		if len(args) > 0 && args[0] == "account" {
+			contact = ""
+			args = args[1:]
+		}
+		if len(args) > 0 {
+			if args[0] != "on" && args[0] != "off" {
+				m.chat = m.chat.SetStatusMsg("Usage: :preview [account] [on|off]")
+				return
+			}
+			if err := m.app.SetLinkPreviewOptIn(accountJID, contact, args[0] == "on"); err != nil {
+				m.chat = m.chat.SetStatusMsg("Failed to change link previews: " + err.Error())
+				return
+			}
+			m.queueLinkPreviews(m.app.GetChatHistoryForAccount(accountJID, jid))
+		}
+		onOff := func(on bool) string {
+			if on {
+				return "on"
+			}
+			return "off"
+		}
+		contactOn, accountOn := m.app.LinkPreviewOptIn(accountJID, jid)
+		status := fmt.Sprintf("Link previews: %s for %s, %s for %s", onOff(contactOn), jid, onOff(accountOn), accountJID)
+		if !m.app.Config().LinkPreviews.Enabled {
+			status += " (disabled in config)"
+		}
+		m.chat = m.chat.SetStatusMsg(status)
 
-	case app.ActionShowAccountAdd:
-		m.dialog = m.dialog.ShowAccountAdd()
-		m.focus = FocusDialog
+	case app.ActionSetEncryption:
+		jid := m.windows.ActiveJID()
+		if jid == "" || m.activeRoomJID() != "" {
+			m.chat = m.chat.SetStatusMsg("Open a chat to change its encryption")
+			return
+		}
+		pref := app.EncryptionDefault
+		switch msg.Data["mode"] {
+		case "enable":
+			pref = app.EncryptionOMEMO
+		case "disable":
+			pref = app.EncryptionNone
+		}
+		accountJID := m.rosterAccountJID()
+		if err := m.app.SetContactEncryption(accountJID, bareJID(jid), pref); err != nil {
+			m.chat = m.chat.SetStatusMsg("Failed to change encryption: " + err.Error())
+			return
+		}
+		enabled := m.app.EncryptionEnabled(accountJID, bareJID(jid))
+		m.chat = m.chat.SetEncrypted(enabled)
+		if enabled {
+			m.chat = m.chat.SetStatusMsg("Messages to " + bareJID(jid) + " are encrypted with OMEMO")
+		} else {
+			m.chat = m.chat.SetStatusMsg("Messages to " + bareJID(jid) + " are sent unencrypted")
+		}
 
-	case app.ActionShowAccountEdit:
-		if jid, ok := msg.Data["jid"].(string); ok {
-			acc := m.app.GetAccount(jid)
-			if acc != nil {
-				m.dialog = m.dialog.ShowAccountEdit(acc.JID, acc.Server, acc.Port, acc.Resource)
-				m.focus = FocusDialog
+	case app.ActionSetSaving:
+		jid := bareJID(m.windows.ActiveJID())
+		accountJID := m.rosterAccountJID()
+		if jid == "" || accountJID == "" {
+			m.chat = m.chat.SetStatusMsg("Open a chat or room to change its message saving")
+			return
+		}
+		mode, _ := msg.Data["mode"].(string)
+		if mode != "" {
+			pref := mode
+			if mode == "default" {
+				pref = app.SavingDefault
+			}
+			if err := m.app.SetConversationSaving(accountJID, jid, pref); err != nil {
+				m.chat = m.chat.SetStatusMsg("Failed to change message saving: " + err.Error())
+				return
+			}
+		}
+		saving := m.app.SavingEnabled(accountJID, jid)
+		m.chat = m.chat.SetSaving(saving)
+		current := m.app.ConversationSaving(accountJID, jid)
+		if current == app.SavingDefault {
+			current = "default"
+		}
+		status := "Messages with " + jid + " are not saved (" + current + ")"
+		if saving {
+			status = "Messages with " + jid + " are saved (" + current + ")"
+		}
+		if path := m.app.ChatLogPath(accountJID, jid); path != "" && current != app.SavingNever {
+			status += ", text log: " + path
+		}
+		m.chat = m.chat.SetStatusMsg(status)
+
+	case app.ActionSetExpiry:
+		jid := bareJID(m.windows.ActiveJID())
+		accountJID := m.rosterAccountJID()
+		if jid == "" || accountJID == "" {
+			m.chat = m.chat.SetStatusMsg("Open a chat or room to set its disappearing messages")
+			return
+		}
+		if arg, _ := msg.Data["duration"].(string); arg != "" {
+			var d time.Duration
+			if arg != "off" {
+				var err error
+				if d, err = app.ParseExpiry(arg); err != nil {
+					m.chat = m.chat.SetStatusMsg("Usage: :expire [12h|7d|off]: " + err.Error())
+					return
+				}
 			}
+			if err := m.app.SetConversationExpiry(accountJID, jid, d); err != nil {
+				m.chat = m.chat.SetStatusMsg("Failed to set disappearing messages: " + err.Error())
+				return
+			}
+		}
+		d := m.app.ConversationExpiry(accountJID, jid)
+		m.chat = m.chat.SetExpiry(d)
+		if d > 0 {
+			m.chat = m.chat.SetStatusMsg("Local copies of messages with " + jid + " are deleted after " + app.FormatExpiry(d) + " (the contact and server keep theirs)")
+		} else {
+			m.chat = m.chat.SetStatusMsg("Messages with " + jid + " are kept locally")
 		}
 
-	case app.ActionShowPassword:
-		if jid, ok := msg.Data["jid"].(string); ok {
-			m.dialog = m.dialog.ShowPassword(jid)
-			m.focus = FocusDialog
+	case app.ActionCommandError:
+		if errMsg, ok := msg.Data["error"].(string); ok {
+			m.chat = m.chat.SetStatusMsg(errMsg)
 		}
 
-	case app.ActionShowSettings:
-		settings := m.app.GetSettings()
-		m.dialog = m.dialog.ShowSettingsList(settings)
-		m.focus = FocusDialog
+	case app.ActionDeclineCall:
+		jid := m.windows.ActiveJID()
+		if jid == "" {
+			m.chat = m.chat.SetStatusMsg("Open the chat of the caller to decline")
+			return
+		}
+		if err := m.app.DeclineCall(m.rosterAccountJID(), bareJID(jid)); err != nil {
+			m.chat = m.chat.SetStatusMsg("Failed to decline: " + err.Error())
+		}
 
-	case app.ActionShowRegister:
-		m.dialog = m.dialog.ShowRegister()
+	case app.ActionShowSecurityEvents:
+		accountJID := m.rosterAccountJID()
+		var lines []string
+		for _, ev := range m.app.GetSecurityEvents(accountJID, 200) {
+			line := fmt.Sprintf("%s  %s #%d: %s", m.app.TimeFormat().DateTime(ev.Timestamp), ev.ContactJID, ev.DeviceID, ev.Description)
+			lines = append(lines, line)
+			if ev.Fingerprint != "" {
+				lines = append(lines, "    "+ev.Fingerprint)
+			}
+		}
+		m.dialog = m.dialog.ShowSecurityEvents(accountJID, lines)
 		m.focus = FocusDialog
 
 	case app.ActionSwitchWindow:
@@ -2380,6 +4528,15 @@ func (m *Model) handleCommandAction(msg app.CommandActionMsg) {
 	case app.ActionSaveWindows:
 		m.saveWindows()
 
+	case app.ActionRenameWindow:
+		if m.windows.ActiveNum() == 0 {
+			m.chat = m.chat.SetStatusMsg("The console window cannot be renamed")
+			return
+		}
+		name, _ := msg.Data["name"].(string)
+		m.windows = m.windows.SetActiveName(strings.TrimSpace(name))
+		m.persistWindows()
+
 	case app.ActionLoadWindows:
 		m.loadWindows()
 	}
@@ -2387,6 +4544,16 @@ func (m *Model) handleCommandAction(msg app.CommandActionMsg) {
 
 // handleDialogResult handles dialog results
 func (m *Model) handleDialogResult(result dialogs.DialogResult) tea.Cmd {
+	if result.Action == dialogs.ActionCopy {
+		// Copied from an open dialog, which stays
+		if err := m.copyToClipboard(result.Values["text"]); err != nil {
+			m.chat = m.chat.SetStatusMsg("Failed to copy: " + err.Error())
+		} else {
+			m.chat = m.chat.SetStatusMsg("Copied to clipboard")
+		}
+		return nil
+	}
+
 	switch result.Type {
 	case dialogs.DialogAccountAdd:
 		if result.Confirmed {
@@ -2509,7 +4676,7 @@ func (m *Model) handleDialogResult(result dialogs.DialogResult) tea.Cmd {
 			password := result.Values["password"]
 			if roomJID != "" && nick != "" {
 				if err := m.app.JoinRoom(roomJID, nick, password); err != nil {
-					m.dialog = m.dialog.ShowError("Failed to join room: " + err.Error())
+					m.showError("Failed to join room", err)
 					m.focus = FocusDialog
 					return nil
 				}
@@ -2520,6 +4687,14 @@ func (m *Model) handleDialogResult(result dialogs.DialogResult) tea.Cmd {
 			}
 		}
 
+	case dialogs.DialogBrowseRooms:
+		if result.Confirmed && result.Values["room"] != "" {
+			nick := m.app.RoomNick(m.rosterAccountJID(), result.Values["room"])
+			m.dialog = m.dialog.ShowJoinRoomFor(result.Values["room"], nick, "")
+			m.focus = FocusDialog
+			return nil
+		}
+
 	case dialogs.DialogCreateRoom:
 		if result.Confirmed {
 			roomJID := result.Values["room_jid"]
@@ -2531,7 +4706,7 @@ func (m *Model) handleDialogResult(result dialogs.DialogResult) tea.Cmd {
 
 			if roomJID != "" && nick != "" {
 				if err := m.app.CreateRoom(roomJID, nick, password, useDefaults, membersOnly, persistent); err != nil {
-					m.dialog = m.dialog.ShowError("Failed to create room: " + err.Error())
+					m.showError("Failed to create room", err)
 					m.focus = FocusDialog
 					return nil
 				}
@@ -2542,6 +4717,14 @@ func (m *Model) handleDialogResult(result dialogs.DialogResult) tea.Cmd {
 			}
 		}
 
+	case dialogs.DialogBulkConfirm:
+		if result.Confirmed {
+			op, _ := app.ParseBulkOp(result.Values["op"])
+			jids := strings.Split(result.Values["jids"], "\n")
+			m.chat = m.chat.SetStatusMsg(op.Describe(len(jids), result.Values["arg"]) + "...")
+			return m.app.DoBulk(result.Values["account"], jids, op, result.Values["arg"])
+		}
+
 	case dialogs.DialogAccountRemove:
 		if result.Confirmed {
 			jid := result.Values["jid"]
@@ -2594,6 +4777,10 @@ func (m *Model) handleDialogResult(result dialogs.DialogResult) tea.Cmd {
 		if result.Action == dialogs.ActionViewCaptcha {
 			// Open CAPTCHA URL in browser
 			captchaURL := result.Values["_captchaURL"]
+			if captchaURL != "" && m.app.PolicyDenies("", app.PolicyLinkOpen) {
+				m.statusbar = m.statusbar.SetExtraInfo(app.PolicyError(app.PolicyLinkOpen).Error() + ", copy the URL instead")
+				return nil
+			}
 			if captchaURL != "" && !strings.HasPrefix(captchaURL, "cid:") {
 				var cmd *exec.Cmd
 				switch runtime.GOOS {
@@ -2612,17 +4799,7 @@ func (m *Model) handleDialogResult(result dialogs.DialogResult) tea.Cmd {
 			// Copy CAPTCHA URL to clipboard
 			captchaURL := result.Values["_captchaURL"]
 			if captchaURL != "" {
-				var cmd *exec.Cmd
-				switch runtime.GOOS {
-				case "darwin":
-					cmd = exec.Command("pbcopy")
-				case "windows":
-					cmd = exec.Command("clip")
-				default:
-					cmd = exec.Command("xclip", "-selection", "clipboard")
-				}
-				cmd.Stdin = strings.NewReader(captchaURL)
-				if err := cmd.Run(); err == nil {
+				if err := m.copyToClipboard(captchaURL); err == nil {
 					m.statusbar = m.statusbar.SetExtraInfo("URL copied to clipboard")
 				}
 			}
@@ -2733,23 +4910,166 @@ func (m *Model) handleDialogResult(result dialogs.DialogResult) tea.Cmd {
 			case 1:
 				_ = m.app.SetOMEMOTrust(jid, device.DeviceID, 2)
 			case 2:
-				_ = m.app.SetOMEMOTrust(jid, device.DeviceID, 3)
+				code, err := m.app.GetOMEMOShortCode(jid, device.DeviceID)
+				if err != nil {
+					m.showError("Failed to derive short code", err)
+				} else {
+					m.dialog = m.dialog.ShowOMEMOShortCode(jid, device.DeviceID, code.Emoji, code.Numbers)
+				}
+				m.focus = FocusDialog
+				return nil
 			case 3:
+				_ = m.app.SetOMEMOTrust(jid, device.DeviceID, 3)
+			case 4:
 				_ = m.app.DeleteOMEMODevice(jid, device.DeviceID)
 			}
+			m.refreshSecurityBanner()
+		}
+
+	case dialogs.DialogOMEMOShortCode:
+		jid := result.Values["jid"]
+		deviceID, err := strconv.ParseUint(result.Values["device"], 10, 32)
+		if jid == "" || err != nil {
+			break
+		}
+		switch result.Button {
+		case 0:
+			if err := m.app.SetOMEMOTrust(jid, uint32(deviceID), 2); err != nil {
+				m.showError("Failed to verify device", err)
+				m.focus = FocusDialog
+				return nil
+			}
+			m.chat = m.chat.SetStatusMsg(fmt.Sprintf("Device %d of %s verified", deviceID, jid))
+			m.refreshSecurityBanner()
+		case 1:
+			m.chat = m.chat.SetStatusMsg(fmt.Sprintf("Short codes differ: device %d of %s was NOT verified", deviceID, jid))
+		}
+		m.showOMEMOVerifyDialog(jid)
+		return nil
+
+	case dialogs.DialogSnippets:
+		accountJID := result.Values["account"]
+		switch result.Button {
+		case 0:
+			m.dialog = m.dialog.ShowSnippetAdd(accountJID)
+			m.focus = FocusDialog
+			return nil
+		case 1:
+			if sn, ok := m.dialog.GetSelectedSnippet(); ok {
+				if err := m.app.DeleteSnippet(accountJID, sn.Name); err != nil {
+					m.showError("Failed to delete snippet", err)
+					m.focus = FocusDialog
+					return nil
+				}
+				m.chat = m.chat.SetSnippets(m.app.GetSnippets(m.rosterAccountJID()))
+				m.showSnippetsDialog()
+				return nil
+			}
+		}
+
+	case dialogs.DialogQuickActions:
+		if item, ok := m.dialog.GetSelectedMenuItem(); ok && result.Action != dialogs.ActionCancel && result.Button == 0 {
+			return m.runQuickAction(item.ID, result.Values["jid"])
+		}
+
+	case dialogs.DialogSearchResults:
+		if hit, _, ok := m.dialog.GetSelectedHit(); ok && result.Action != dialogs.ActionCancel && result.Button == 0 {
+			m.openMessageHit(result.Values["account"], hit)
+			return nil
+		}
+
+	case dialogs.DialogStarred:
+		if hit, selected, ok := m.dialog.GetSelectedHit(); ok && result.Action != dialogs.ActionCancel {
+			switch result.Button {
+			case 0:
+				m.openMessageHit(result.Values["account"], hit)
+				return nil
+			case 1:
+				if err := m.app.UnstarMessage(result.Values["account"], hit.JID, hit.ID); err != nil {
+					m.showError("Failed to unstar", err)
+					m.focus = FocusDialog
+					return nil
+				}
+				m.refreshStarred()
+				m.showStarredDialog(result.Values["account"], selected)
+				return nil
+			}
+		}
+
+	case dialogs.DialogPrivacy:
+		if setting, selected, ok := m.dialog.GetSelectedPrivacy(); ok && result.Action != dialogs.ActionCancel && result.Button == 0 {
+			return m.switchPrivacy(result.Values["account"], setting, selected)
+		}
+
+	case dialogs.DialogParticipants:
+		if p, selected, ok := m.dialog.GetSelectedParticipant(); ok && result.Action != dialogs.ActionCancel && result.Button == 0 {
+			if err := m.app.SetOccupantIgnored(result.Values["account"], result.Values["room"], p.Nick, !p.Ignored); err != nil {
+				m.showError("Failed to update ignore list", err)
+				m.focus = FocusDialog
+				return nil
+			}
+			m.refreshIgnoredNicks()
+			m.showParticipantsDialog(selected)
+			return nil
+		}
+
+	case dialogs.DialogQueue:
+		if item, selected, ok := m.dialog.GetSelectedQueued(); ok && result.Action != dialogs.ActionCancel {
+			switch result.Button {
+			case 0:
+				m.showQueueDialog(selected)
+				return m.app.SendQueuedCmd(item.ID)
+			case 1:
+				m.dialog = m.dialog.ShowQueueEdit(item)
+				m.focus = FocusDialog
+				return nil
+			case 2:
+				_ = m.app.DeleteQueuedMessage(item.ID)
+				m.showQueueDialog(selected)
+				return nil
+			}
+		}
+
+	case dialogs.DialogQueueEdit:
+		if result.Confirmed {
+			if err := m.app.UpdateQueuedMessage(result.Values["id"], result.Values["body"]); err != nil {
+				m.showError("Failed to edit message", err)
+				m.focus = FocusDialog
+				return nil
+			}
+		}
+		m.showQueueDialog(0)
+		return nil
+
+	case dialogs.DialogSnippetAdd:
+		if result.Confirmed {
+			accountJID := ""
+			if result.Values["account_only"] == "true" {
+				accountJID = result.Values["account"]
+			}
+			if err := m.app.SetSnippet(accountJID, result.Values["name"], result.Values["text"]); err != nil {
+				m.showError("Failed to save snippet", err)
+				m.focus = FocusDialog
+				return nil
+			}
+			m.chat = m.chat.SetSnippets(m.app.GetSnippets(m.rosterAccountJID()))
+			m.showSnippetsDialog()
+			return nil
 		}
 
 	case dialogs.DialogBookmarks:
 		if bm, _, ok := m.dialog.GetSelectedBookmark(); ok {
 			switch result.Button {
 			case 0:
-				// Join room
-				if bm.Nick != "" {
-					_ = m.app.JoinRoom(bm.RoomJID, bm.Nick, "")
-				} else {
-					_ = m.app.JoinRoom(bm.RoomJID, "", "")
+				// Join room, preferring the nick last used there over the
+				// bookmark's
+				accountJID := m.rosterAccountJID()
+				nick := m.app.GetRoomSettings(accountJID, bm.RoomJID).Nick
+				if nick == "" {
+					nick = bm.Nick
 				}
-				m.windows = m.windows.OpenMUC(bm.RoomJID, bm.Nick)
+				_ = m.app.JoinRoom(bm.RoomJID, nick, "")
+				m.windows = m.windows.OpenMUC(bm.RoomJID, m.app.RoomNick(accountJID, bm.RoomJID))
 				m.loadActiveWindow()
 			case 1:
 				// Delete bookmark
@@ -2758,8 +5078,8 @@ func (m *Model) handleDialogResult(result dialogs.DialogResult) tea.Cmd {
 		}
 
 	case dialogs.DialogSetStatus:
-		if result.Button < 5 {
-			statuses := []string{"online", "away", "dnd", "xa", "offline"}
+		statuses := []string{"online", "chat", "away", "dnd", "xa", "offline"}
+		if result.Button < len(statuses) {
 			status := statuses[result.Button]
 			message := result.Values["message"]
 			_ = m.app.SetStatusAndSend(status, message)
@@ -2771,7 +5091,7 @@ func (m *Model) handleDialogResult(result dialogs.DialogResult) tea.Cmd {
 			originalID := result.Values["original_id"]
 			correction := result.Values["correction"]
 			if jid != "" && originalID != "" && correction != "" {
-				return m.app.CorrectMessage(jid, originalID, correction)
+				return m.app.CorrectMessageForAccount(m.rosterAccountJID(), jid, originalID, correction)
 			}
 		}
 
@@ -2782,8 +5102,9 @@ func (m *Model) handleDialogResult(result dialogs.DialogResult) tea.Cmd {
 			messageID := result.Values["message_id"]
 			reaction := reactions[result.Button]
 			if jid != "" && messageID != "" {
-				_ = m.app.SendReaction(jid, messageID, reaction)
-				m.chat = m.chat.AddReaction(messageID, m.app.CurrentAccount(), reaction)
+				accountJID := m.rosterAccountJID()
+				_ = m.app.SendReactionForAccount(accountJID, jid, messageID, reaction)
+				m.chat = m.chat.AddReaction(messageID, accountJID, reaction)
 			}
 		}
 
@@ -2804,14 +5125,24 @@ func (m *Model) handleDialogResult(result dialogs.DialogResult) tea.Cmd {
 		if result.Confirmed {
 			filepath := result.Values["filepath"]
 			if filepath != "" {
-				data, err := m.app.ExportAccounts()
+				var data []byte
+				var err error
+				if result.Values["insecure"] == "true" {
+					data, err = m.app.ExportAccounts()
+				} else if result.Values["passphrase"] != result.Values["passphrase_confirm"] {
+					m.dialog = m.dialog.ShowError("Passphrases do not match")
+					m.focus = FocusDialog
+					return nil
+				} else {
+					data, err = m.app.ExportAccountsEncrypted(result.Values["passphrase"])
+				}
 				if err != nil {
-					m.dialog = m.dialog.ShowError("Failed to export: " + err.Error())
+					m.showError("Failed to export", err)
 					m.focus = FocusDialog
 					return nil
 				}
 				if err := os.WriteFile(filepath, data, 0600); err != nil {
-					m.dialog = m.dialog.ShowError("Failed to write file: " + err.Error())
+					m.showError("Failed to write file", err)
 					m.focus = FocusDialog
 					return nil
 				}
@@ -2825,12 +5156,36 @@ func (m *Model) handleDialogResult(result dialogs.DialogResult) tea.Cmd {
 			if filepath != "" {
 				data, err := os.ReadFile(filepath)
 				if err != nil {
-					m.dialog = m.dialog.ShowError("Failed to read file: " + err.Error())
+					m.showError("Failed to read file", err)
+					m.focus = FocusDialog
+					return nil
+				}
+				if trimmed := strings.TrimSpace(string(data)); !strings.HasPrefix(trimmed, "[") && !strings.HasPrefix(trimmed, "{") {
+					// Not our own export, try the formats of other clients
+					imp, err := m.app.ReadClientImport(filepath)
+					if err != nil {
+						m.showError("Failed to import", err)
+						m.focus = FocusDialog
+						return nil
+					}
+					m.clientImport = imp
+					infos := make([]dialogs.ImportAccountInfo, len(imp.Accounts))
+					for i, acc := range imp.Accounts {
+						infos[i] = dialogs.ImportAccountInfo{
+							JID:           acc.Account.JID,
+							Server:        acc.Account.Server,
+							Port:          acc.Account.Port,
+							Contacts:      len(acc.Contacts),
+							NeedsPassword: acc.NeedsPassword,
+							Exists:        acc.Exists,
+						}
+					}
+					m.dialog = m.dialog.ShowClientImport(imp.Source, infos)
 					m.focus = FocusDialog
 					return nil
 				}
-				if err := m.app.ImportAccounts(data); err != nil {
-					m.dialog = m.dialog.ShowError("Failed to import: " + err.Error())
+				if err := m.app.ImportAccounts(data, result.Values["passphrase"]); err != nil {
+					m.showError("Failed to import", err)
 					m.focus = FocusDialog
 					return nil
 				}
@@ -2838,6 +5193,167 @@ func (m *Model) handleDialogResult(result dialogs.DialogResult) tea.Cmd {
 				m.roster = m.roster.SetAccounts(m.getAccountDisplays())
 			}
 		}
+
+	case dialogs.DialogClientImport:
+		imp := m.clientImport
+		m.clientImport = nil
+		if result.Confirmed && imp != nil {
+			passwords := make(map[string]string)
+			for key, value := range result.Values {
+				if jid, ok := strings.CutPrefix(key, "password:"); ok && value != "" {
+					passwords[jid] = value
+				}
+			}
+			added := m.app.ApplyClientImport(imp, passwords)
+			m.chat = m.chat.SetStatusMsg(fmt.Sprintf("Imported %d accounts from %s", added, imp.Source))
+			m.roster = m.roster.SetAccounts(m.getAccountDisplays())
+		}
+
+	case dialogs.DialogOMEMOExport:
+		if result.Confirmed {
+			filepath := result.Values["filepath"]
+			if filepath != "" {
+				if result.Values["passphrase"] != result.Values["passphrase_confirm"] {
+					m.dialog = m.dialog.ShowError("Passphrases do not match")
+					m.focus = FocusDialog
+					return nil
+				}
+				if err := m.app.ExportOMEMO(result.Values["account"], filepath, result.Values["passphrase"]); err != nil {
+					m.showError("Failed to export OMEMO keys", err)
+					m.focus = FocusDialog
+					return nil
+				}
+				m.chat = m.chat.SetStatusMsg("OMEMO keys exported to " + filepath)
+			}
+		}
+
+	case dialogs.DialogEncryptAccounts:
+		if result.Confirmed {
+			if result.Values["passphrase"] == "" {
+				m.dialog = m.dialog.ShowError("A passphrase is required")
+				m.focus = FocusDialog
+				return nil
+			}
+			if result.Values["passphrase"] != result.Values["passphrase_confirm"] {
+				m.dialog = m.dialog.ShowError("Passphrases do not match")
+				m.focus = FocusDialog
+				return nil
+			}
+			if err := m.app.EncryptAccounts(result.Values["passphrase"]); err != nil {
+				m.showError("Failed to encrypt accounts", err)
+				m.focus = FocusDialog
+				return nil
+			}
+			m.chat = m.chat.SetStatusMsg("The accounts file is encrypted, its passphrase is asked for at startup")
+		}
+
+	case dialogs.DialogRosterConflict:
+		switch {
+		case result.Confirmed:
+			m.focus = FocusRoster
+			return m.app.ResolveRosterConflict(result.Values["account"], result.Values["jid"], true)
+		case result.Button == 1:
+			m.focus = FocusRoster
+			return m.app.ResolveRosterConflict(result.Values["account"], result.Values["jid"], false)
+		}
+
+	case dialogs.DialogPluginConsent:
+		if result.Confirmed {
+			name := result.Values["name"]
+			var shown []manifest.Permission
+			for _, p := range strings.Split(result.Values["permissions"], ",") {
+				if p != "" {
+					shown = append(shown, manifest.Permission(p))
+				}
+			}
+			if err := m.app.AllowPlugin(name, shown); err != nil {
+				m.dialog = m.dialog.ShowError("Failed to enable " + name + ": " + err.Error())
+				m.focus = FocusDialog
+				return nil
+			}
+			m.chat = m.chat.SetStatusMsg(name + " enabled, restart roster to load it")
+		}
+
+	case dialogs.DialogAskVoice:
+		m.focus = FocusChat
+		if result.Confirmed {
+			room := result.Values["room"]
+			if err := m.app.RequestVoice(m.rosterAccountJID(), room); err != nil {
+				m.chat = m.chat.SetStatusMsg("Voice request failed: " + err.Error())
+				return nil
+			}
+			m.chat = m.chat.SetStatusMsg("Asked the moderators of " + room + " for voice")
+		}
+
+	case dialogs.DialogVoiceApproval:
+		req := m.pendingVoice
+		m.pendingVoice = nil
+		m.focus = FocusChat
+		if req == nil || (!result.Confirmed && result.Button != 1) {
+			// Later: it stays pending for :voice
+			return nil
+		}
+		if err := m.app.AnswerVoiceRequest(*req, result.Confirmed); err != nil {
+			m.chat = m.chat.SetStatusMsg("Failed to answer the voice request: " + err.Error())
+			return nil
+		}
+		if result.Confirmed {
+			m.chat = m.chat.SetStatusMsg("Granted voice to " + req.Nick + " in " + req.Room)
+		} else {
+			m.chat = m.chat.SetStatusMsg("Denied voice to " + req.Nick + " in " + req.Room)
+		}
+		if pending := m.app.PendingVoiceRequests(); len(pending) > 0 {
+			m.showVoiceApproval(pending[0])
+		}
+
+	case dialogs.DialogResendRoomMessage:
+		dropped := m.pendingResend
+		m.pendingResend = nil
+		m.focus = FocusChat
+		if dropped == nil || !result.Confirmed {
+			return nil
+		}
+		if dropped.AccountJID != m.app.CurrentAccount() {
+			m.chat = m.chat.SetStatusMsg("Switch to " + dropped.AccountJID + " to resend the message")
+			return nil
+		}
+		return tea.Batch(m.app.SendChatMessage(dropped.Room, dropped.Body), chat.SpinnerTick())
+
+	case dialogs.DialogPlaintextConfirm:
+		pending := m.pendingPlaintext
+		m.pendingPlaintext = nil
+		m.focus = FocusChat
+		if pending == nil {
+			return nil
+		}
+		if result.Confirmed {
+			return tea.Batch(m.app.SendChatMessage(pending.To, pending.Body), chat.SpinnerTick())
+		}
+		m.chat = m.chat.SetInput(pending.Body)
+		m.chat = m.chat.SetStatusMsg("Not sent: the conversation is encrypted, turn OMEMO back on with :omemo enable")
+
+	case dialogs.DialogUnlockAccounts:
+		if result.Confirmed {
+			if err := m.app.UnlockAccounts(result.Values["passphrase"]); err != nil {
+				m.showError("Failed to unlock accounts", err)
+				m.focus = FocusDialog
+				return nil
+			}
+			m.chat = m.chat.SetStatusMsg("Accounts unlocked")
+		}
+
+	case dialogs.DialogOMEMOImport:
+		if result.Confirmed {
+			filepath := result.Values["filepath"]
+			if filepath != "" {
+				if err := m.app.ImportOMEMO(result.Values["account"], filepath, result.Values["passphrase"]); err != nil {
+					m.showError("Failed to import OMEMO keys", err)
+					m.focus = FocusDialog
+					return nil
+				}
+				m.chat = m.chat.SetStatusMsg("OMEMO keys imported from " + filepath + ", used from the next connection")
+			}
+		}
 	}
 
 	m.focus = FocusRoster
@@ -2854,6 +5370,22 @@ func (m *Model) getAccountDetailData(jid string) chat.AccountDetailData {
 			// Get OMEMO fingerprint if enabled
 			fingerprint, deviceID := m.app.GetOwnFingerprint(jid)
 
+			var tlsDetail *chat.TLSDetail
+			if info, ok := m.app.GetTLSInfo(jid); ok {
+				tlsDetail = &chat.TLSDetail{
+					Version:      info.Version,
+					CipherSuite:  info.CipherSuite,
+					ServerName:   info.ServerName,
+					Expires:      info.Expires(),
+					ExpiringSoon: info.ExpiresSoon(time.Now()),
+				}
+				for _, cert := range info.Chain {
+					tlsDetail.Chain = append(tlsDetail.Chain, cert.Subject+", issued by "+cert.Issuer)
+				}
+			}
+
+			stats := m.app.GetAccountStats(jid)
+
 			return chat.AccountDetailData{
 				JID:              acc.JID,
 				Status:           acc.Status,
@@ -2869,6 +5401,19 @@ func (m *Model) getAccountDetailData(jid string) chat.AccountDetailData {
 				UnreadChats:      acc.UnreadChats,
 				OMEMOFingerprint: fingerprint,
 				OMEMODeviceID:    deviceID,
+				TLS:              tlsDetail,
+				Archive:          m.app.ArchivePreference(jid),
+				ArchiveConflict:  m.app.ArchiveConflict(jid),
+				Traffic: chat.TrafficDetail{
+					StanzasIn:   stats.StanzasIn,
+					StanzasOut:  stats.StanzasOut,
+					BytesIn:     stats.BytesIn,
+					BytesOut:    stats.BytesOut,
+					Reconnects:  stats.Reconnects,
+					ConnectedAt: stats.ConnectedAt,
+					Latency:     stats.Latency,
+					Since:       stats.Since,
+				},
 			}
 		}
 	}
@@ -2881,7 +5426,8 @@ func (m *Model) getContactDetailData(jid string) chat.ContactDetailData {
 	contacts := m.currentRosterContacts()
 	for _, c := range contacts {
 		if c.JID == jid {
-			return chat.ContactDetailData{
+			_, _, lastSeen := m.app.GetContactLastPresenceForAccount(m.rosterAccountJID(), jid)
+			data := chat.ContactDetailData{
 				JID:           c.JID,
 				Name:          c.Name,
 				Status:        c.Status,
@@ -2890,10 +5436,40 @@ func (m *Model) getContactDetailData(jid string) chat.ContactDetailData {
 				Subscription:  c.Subscription,
 				AddedToRoster: c.AddedToRoster,
 				Favorite:      c.Favorite,
-				StatusSharing: m.app.IsStatusSharingEnabled(jid),
+				LastSeen:      lastSeen,
+				StatusSharing: m.app.IsStatusSharingEnabledForAccount(m.rosterAccountJID(), jid),
+				Saving:        m.app.ConversationSaving(m.rosterAccountJID(), jid),
+				SavingEnabled: m.app.SavingEnabled(m.rosterAccountJID(), jid),
+				Software:      m.app.SoftwareVersion(m.rosterAccountJID(), jid),
 				OMEMOEnabled:  true, // TODO: Get from contact settings
 				// Fingerprints would be populated from OMEMO storage
 			}
+			locked := m.app.LockedResource(m.rosterAccountJID(), jid)
+			if locked != "" {
+				data.LockedTo = locked[strings.Index(locked, "/")+1:]
+			}
+			for _, r := range m.app.ContactResources(m.rosterAccountJID(), jid) {
+				if r.JID == locked {
+					data.LockedOnline = true
+				}
+				data.Resources = append(data.Resources, chat.ResourceDetail{
+					Resource: r.Resource,
+					Priority: r.Priority,
+					Show:     r.Show,
+					Client:   r.ClientName(),
+					Ping:     m.app.PingStatus(m.rosterAccountJID(), r.JID),
+					Locked:   r.JID == locked,
+				})
+			}
+			if d := m.app.ConversationExpiry(m.rosterAccountJID(), jid); d > 0 {
+				data.Expiry = app.FormatExpiry(d)
+			}
+			if activity := m.app.GetPresenceActivity(m.rosterAccountJID(), jid); activity != nil {
+				data.Activity24h = activity.Last24h
+				data.Activity7d = activity.Last7d
+				data.UsualHours = activity.UsualHours
+			}
+			return data
 		}
 	}
 	// Return empty data if not found
@@ -2949,14 +5525,14 @@ func (m *Model) uploadFile(jid, filepath string) tea.Cmd {
 	return func() tea.Msg {
 		fileInfo, err := os.Stat(filepath)
 		if err != nil {
-			m.dialog = m.dialog.ShowError("Cannot access file: " + err.Error())
+			m.showError("Cannot access file", err)
 			m.focus = FocusDialog
 			return nil
 		}
 
 		file, err := os.Open(filepath)
 		if err != nil {
-			m.dialog = m.dialog.ShowError("Cannot open file: " + err.Error())
+			m.showError("Cannot open file", err)
 			m.focus = FocusDialog
 			return nil
 		}
@@ -2966,21 +5542,27 @@ func (m *Model) uploadFile(jid, filepath string) tea.Cmd {
 		size := fileInfo.Size()
 		contentType := "application/octet-stream"
 
-		serviceJID := "upload." + m.app.CurrentAccount()
-		if idx := strings.Index(m.app.CurrentAccount(), "@"); idx > 0 {
-			serviceJID = "upload." + m.app.CurrentAccount()[idx+1:]
+		serviceJID, err := m.app.DiscoverUploadService()
+		if err != nil {
+			m.showError("Failed to find upload service", err)
+			m.focus = FocusDialog
+			return nil
+		}
+		if serviceJID == "" {
+			// No HTTP upload on this server, send the file directly instead
+			return m.app.SendFileDirect(jid, filename, size, file)()
 		}
 
 		slot, err := m.app.RequestUploadSlot(serviceJID, filename, size, contentType)
 		if err != nil {
-			m.dialog = m.dialog.ShowError("Failed to get upload slot: " + err.Error())
+			m.showError("Failed to get upload slot", err)
 			m.focus = FocusDialog
 			return nil
 		}
 
 		putURL, err := validateUploadPutURL(slot.PutURL)
 		if err != nil {
-			m.dialog = m.dialog.ShowError("Unsafe upload URL: " + err.Error())
+			m.showError("Unsafe upload URL", err)
 			m.focus = FocusDialog
 			return nil
 		}
@@ -2988,7 +5570,7 @@ func (m *Model) uploadFile(jid, filepath string) tea.Cmd {
 		// #nosec G704 -- URL comes from server slot but is strictly validated by validateUploadPutURL.
 		req, err := http.NewRequest("PUT", putURL, file)
 		if err != nil {
-			m.dialog = m.dialog.ShowError("Failed to create upload request: " + err.Error())
+			m.showError("Failed to create upload request", err)
 			m.focus = FocusDialog
 			return nil
 		}
@@ -3002,7 +5584,7 @@ func (m *Model) uploadFile(jid, filepath string) tea.Cmd {
 		// #nosec G704 -- Request target is validated by validateUploadPutURL before use.
 		resp, err := client.Do(req)
 		if err != nil {
-			m.dialog = m.dialog.ShowError("Upload failed: " + err.Error())
+			m.showError("Upload failed", err)
 			m.focus = FocusDialog
 			return nil
 		}
@@ -3030,18 +5612,3 @@ func openURL(url string) error {
 	}
 	return cmd.Start()
 }
-
-// copyToClipboard copies text to the system clipboard
-func copyToClipboard(text string) error {
-	var cmd *exec.Cmd
-	switch runtime.GOOS {
-	case "darwin":
-		cmd = exec.Command("pbcopy")
-	case "windows":
-		cmd = exec.Command("clip")
-	default:
-		cmd = exec.Command("xclip", "-selection", "clipboard")
-	}
-	cmd.Stdin = strings.NewReader(text)
-	return cmd.Run()
-}
@@ -0,0 +1,141 @@
+package ui
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/meszmate/roster/internal/app"
+	"github.com/meszmate/roster/internal/ui/components/chat"
+	"github.com/meszmate/roster/internal/ui/components/muc"
+	"github.com/meszmate/roster/internal/ui/theme"
+)
+
+// The muc component keeps the rooms of the shown account with who is in
+// them. Events of other accounts are left out; switching windows rebuilds
+// the active room from the app, which keeps the rooms of every account.
+
+// minRoomChatWidth is the narrowest the chat of a room gets before the nick
+// list beside it is left out
+const minRoomChatWidth = 40
+
+// handleMUCEvent applies a room event to the muc component and the chat
+func (m *Model) handleMUCEvent(event app.EventMsg) tea.Cmd {
+	switch data := event.Data.(type) {
+	case app.MUCRoom:
+		if data.AccountJID != m.rosterAccountJID() {
+			return nil
+		}
+		if event.Type == app.EventMUCLeft {
+			m.muc = m.muc.LeaveRoom(data.Room)
+		} else {
+			m.muc = m.muc.EnsureRoom(data.Room, data.Nick)
+			m.muc = m.muc.SetRoomJoined(data.Room)
+			m.windows = m.windows.MarkMUC(data.Room, data.AccountJID)
+		}
+		if data.Room == m.activeRoomJID() {
+			m.syncActiveRoom()
+			m.updateComponentSizes()
+		}
+
+	case app.MUCPresence:
+		if data.AccountJID != m.rosterAccountJID() {
+			return nil
+		}
+		if data.Left {
+			m.muc = m.muc.RemoveParticipant(data.Room, data.Occupant.Nick)
+		} else {
+			m.muc = m.muc.EnsureRoom(data.Room, m.app.RoomNick(data.AccountJID, data.Room))
+			m.muc = m.muc.AddParticipant(data.Room, toParticipant(data.Occupant))
+		}
+		if data.Room == m.activeRoomJID() && m.muc.GetActiveRoom() == nil {
+			// The first occupant of the open room brings up the nick list
+			m.syncActiveRoom()
+			m.updateComponentSizes()
+		}
+
+	case app.MUCMessage:
+		if data.Subject != "" && data.AccountJID == m.rosterAccountJID() {
+			m.muc = m.muc.SetSubject(data.Room, data.Subject)
+		}
+		if data.Type == "system" {
+			// Notices only show in an open room, they are not worth an unread
+			if data.Room == m.activeRoomJID() && data.AccountJID == m.rosterAccountJID() {
+				m.chat = m.chat.AddMessage(chat.Message{
+					ID:        data.ID,
+					From:      data.From,
+					Body:      data.Body,
+					Timestamp: data.Timestamp,
+					Type:      data.Type,
+				})
+			}
+			return nil
+		}
+		cmd := m.handleAppEvent(app.EventMsg{Type: app.EventMessage, Data: data.ChatMessage})
+		m.windows = m.windows.MarkMUC(data.Room, data.AccountJID)
+		return cmd
+	}
+	return nil
+}
+
+// syncActiveRoom shows the occupants of the active window's room, or no
+// nick list for a chat or a room we are not in
+func (m *Model) syncActiveRoom() {
+	roomJID := m.activeRoomJID()
+	accountJID := m.rosterAccountJID()
+	occupants := m.app.GetRoomParticipants(accountJID, roomJID)
+	if roomJID == "" || len(occupants) == 0 {
+		m.muc = m.muc.SetActiveRoom("")
+		return
+	}
+	participants := make([]muc.Participant, 0, len(occupants))
+	for _, o := range occupants {
+		participants = append(participants, toParticipant(o))
+	}
+	m.muc = m.muc.EnsureRoom(roomJID, m.app.RoomNick(accountJID, roomJID))
+	m.muc = m.muc.SetRoomJoined(roomJID)
+	m.muc = m.muc.SetParticipants(roomJID, participants)
+	m.muc = m.muc.SetSubject(roomJID, m.app.RoomSubject(accountJID, roomJID))
+	m.muc = m.muc.SetActiveRoom(roomJID)
+}
+
+// nickListWidth is the width of the nick list beside the chat of a room
+// given the width of the chat pane, 0 when it is not shown
+func (m Model) nickListWidth(chatWidth int) int {
+	if m.viewMode != ViewModeNormal {
+		return 0
+	}
+	width := m.muc.ParticipantsWidth()
+	if width == 0 || chatWidth-width < minRoomChatWidth {
+		return 0
+	}
+	return width
+}
+
+// nickListView renders the nick list in its frame, nothing when it is not
+// shown
+func (m Model) nickListView(styles *theme.Styles, width, mainHeight int) string {
+	if width == 0 {
+		return ""
+	}
+	view := lipgloss.Place(width-2, mainHeight-2, lipgloss.Left, lipgloss.Top, m.muc.ParticipantsView())
+	return styles.WindowInactive.Render(view)
+}
+
+// toParticipant converts a room occupant for the muc component
+func toParticipant(o app.RoomOccupant) muc.Participant {
+	role := muc.Role(o.Role)
+	if role == "" {
+		role = muc.RoleParticipant
+	}
+	affiliation := muc.Affiliation(o.Affiliation)
+	if affiliation == "" {
+		affiliation = muc.AffiliationNone
+	}
+	return muc.Participant{
+		Nick:        o.Nick,
+		JID:         o.JID,
+		Role:        role,
+		Affiliation: affiliation,
+		Status:      o.Status,
+		StatusMsg:   o.StatusMsg,
+	}
+}
@@ -0,0 +1,35 @@
+package ui
+
+import (
+	"github.com/meszmate/roster/internal/app"
+	"github.com/meszmate/roster/internal/ui/keybindings"
+)
+
+// policyActions are the keys that lead to a feature a policy can deny
+var policyActions = map[string][]keybindings.Action{
+	app.PolicyFileUpload: {keybindings.ActionUploadFile},
+	app.PolicyLinkOpen:   {keybindings.ActionOpenFileURL},
+}
+
+// syncPolicy greys out the keys of what the policy denies the account in
+// use
+func (m *Model) syncPolicy() {
+	accountJID := m.rosterAccountJID()
+	var disabled []keybindings.Action
+	for feature, actions := range policyActions {
+		if m.app.PolicyDenies(accountJID, feature) {
+			disabled = append(disabled, actions...)
+		}
+	}
+	m.keys.SetDisabled(disabled...)
+}
+
+// policyDenied tells on the status line when the policy denies a feature
+// to the account in use, and reports whether it does
+func (m *Model) policyDenied(feature string) bool {
+	if !m.app.PolicyDenies(m.rosterAccountJID(), feature) {
+		return false
+	}
+	m.chat = m.chat.SetStatusMsg(app.PolicyError(feature).Error())
+	return true
+}
@@ -0,0 +1,73 @@
+package ui
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/meszmate/roster/internal/app"
+	"github.com/meszmate/roster/internal/ui/components/dialogs"
+)
+
+// privacyCommand opens the privacy dashboard of the account :archive would
+// act on, asking the server how it archives when that is not known yet
+func (m *Model) privacyCommand() tea.Cmd {
+	accountJID := m.archiveAccount()
+	if accountJID == "" {
+		m.chat = m.chat.SetStatusMsg("No account selected")
+		return nil
+	}
+	m.showPrivacyDialog(accountJID, 0)
+	if m.app.ArchivePreference(accountJID) == "" && m.app.IsAccountConnected(accountJID) {
+		return m.app.FetchArchivePrefs(accountJID)
+	}
+	return nil
+}
+
+// showPrivacyDialog lists what an account shares, keeping the selection
+func (m *Model) showPrivacyDialog(accountJID string, selected int) {
+	items := m.app.PrivacyItems(accountJID)
+	settings := make([]dialogs.PrivacySetting, 0, len(items))
+	for _, it := range items {
+		settings = append(settings, dialogs.PrivacySetting{
+			ID: it.ID, Label: it.Label, On: it.On, State: it.State, Detail: it.Detail, Fixed: it.Fixed,
+		})
+	}
+	m.dialog = m.dialog.ShowPrivacy(accountJID, settings, selected)
+	m.focus = FocusDialog
+}
+
+// switchPrivacy turns the selected item of the privacy dashboard over and
+// shows the dashboard again
+func (m *Model) switchPrivacy(accountJID string, setting dialogs.PrivacySetting, selected int) tea.Cmd {
+	var cmd tea.Cmd
+	switch {
+	case setting.Fixed:
+		m.chat = m.chat.SetStatusMsg(setting.Label + " cannot be changed here: " + setting.Detail)
+	case setting.ID == app.PrivacyArchive && m.app.ArchivePreference(accountJID) == "":
+		cmd = m.app.FetchArchivePrefs(accountJID)
+	case setting.ID == app.PrivacyArchive:
+		cmd = m.cycleArchive(accountJID)
+	default:
+		if err := m.app.SetPrivacy(accountJID, setting.ID, !setting.On); err != nil {
+			m.showError("Failed to change "+setting.Label, err)
+			m.focus = FocusDialog
+			return nil
+		}
+		state := "off"
+		if !setting.On {
+			state = "on"
+		}
+		m.chat = m.chat.SetStatusMsg(setting.Label + " " + state + " for " + accountJID)
+	}
+	m.showPrivacyDialog(accountJID, selected)
+	return cmd
+}
+
+// refreshPrivacyDialog shows an open privacy dashboard of an account again,
+// after what it lists changed
+func (m *Model) refreshPrivacyDialog(accountJID string) {
+	if m.dialog.PrivacyAccount() != accountJID {
+		return
+	}
+	if _, selected, ok := m.dialog.GetSelectedPrivacy(); ok {
+		m.showPrivacyDialog(accountJID, selected)
+	}
+}
@@ -0,0 +1,181 @@
+package theme
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Contacts are told apart by two letters on a background of their own
+// colour, as roster shows no pictures. The colour is the XEP-0392
+// consistent colour of the bare JID, the same other clients give the
+// contact: a hue from the SHA-1 of the JID, made an RGB colour with HSLuv
+// at full saturation and half lightness.
+
+// ConsistentColor returns the XEP-0392 colour of an identifier
+func ConsistentColor(id string) lipgloss.Color {
+	sum := sha1.Sum([]byte(id))
+	hue := float64(uint16(sum[0])|uint16(sum[1])<<8) / 65536 * 360
+	r, g, b := hsluvToRGB(hue, 100, 50)
+	return lipgloss.Color(fmt.Sprintf("#%02x%02x%02x", toByte(r), toByte(g), toByte(b)))
+}
+
+// Initials returns two letters for a contact: the first letters of the
+// first two words of its name, or the first two of a one word name. Without
+// a name the local part of the JID is used.
+func Initials(name, jid string) string {
+	source := strings.TrimSpace(name)
+	if source == "" {
+		source, _, _ = strings.Cut(jid, "@")
+		if source == "" {
+			source = jid
+		}
+	}
+
+	words := strings.FieldsFunc(source, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var letters []rune
+	switch {
+	case len(words) >= 2:
+		letters = []rune{[]rune(words[0])[0], []rune(words[1])[0]}
+	case len(words) == 1:
+		letters = []rune(words[0])
+		if len(letters) > 2 {
+			letters = letters[:2]
+		}
+	default:
+		return "?"
+	}
+	// Wide letters take two cells each, one of them is enough
+	for len(letters) > 1 && lipgloss.Width(string(letters)) > 2 {
+		letters = letters[:len(letters)-1]
+	}
+	return strings.ToUpper(string(letters))
+}
+
+// AvatarStyle is the style of a contact's initials: its consistent colour
+// as the background with black or white letters, whichever reads better
+func AvatarStyle(jid string) lipgloss.Style {
+	color := ConsistentColor(jid)
+	var r, g, b int
+	_, _ = fmt.Sscanf(string(color), "#%02x%02x%02x", &r, &g, &b)
+	fg := lipgloss.Color("#ffffff")
+	// Relative luminance by the sRGB weights, good enough to pick a side
+	if 0.2126*float64(r)+0.7152*float64(g)+0.0722*float64(b) > 140 {
+		fg = lipgloss.Color("#000000")
+	}
+	return lipgloss.NewStyle().Background(color).Foreground(fg).Bold(true)
+}
+
+// Avatar renders the initials of a contact on its colour in two cells
+func Avatar(name, jid string) string {
+	initials := Initials(name, jid)
+	if lipgloss.Width(initials) < 2 {
+		initials += " "
+	}
+	return AvatarStyle(jid).Render(initials)
+}
+
+// AvatarBlock renders the initials of a contact centred in a block of
+// width by height cells
+func AvatarBlock(name, jid string, width, height int) string {
+	return AvatarStyle(jid).
+		Width(width).
+		Height(height).
+		Align(lipgloss.Center, lipgloss.Center).
+		Render(Initials(name, jid))
+}
+
+func toByte(c float64) int {
+	return int(math.Round(math.Max(0, math.Min(1, c)) * 255))
+}
+
+// HSLuv, after the reference implementation at hsluv.org
+
+var hsluvM = [3][3]float64{
+	{3.240969941904521, -1.537383177570093, -0.498610760293},
+	{-0.96924363628087, 1.87596750150772, 0.041555057407175},
+	{0.055630079696993, -0.20397695888897, 1.056971514242878},
+}
+
+const (
+	hsluvRefU    = 0.19783000664283
+	hsluvRefV    = 0.46831999493879
+	hsluvKappa   = 903.2962962
+	hsluvEpsilon = 0.0088564516
+)
+
+// hsluvToRGB converts hue (degrees), saturation and lightness (0-100) to
+// sRGB components from 0 to 1
+func hsluvToRGB(h, s, l float64) (float64, float64, float64) {
+	// HSLuv to LCh
+	var c float64
+	switch {
+	case l > 99.9999999:
+		l, c = 100, 0
+	case l < 0.00000001:
+		l, c = 0, 0
+	default:
+		c = maxChroma(l, h) / 100 * s
+	}
+
+	// LCh to Luv
+	hrad := h / 360 * 2 * math.Pi
+	u, v := math.Cos(hrad)*c, math.Sin(hrad)*c
+	if l == 0 {
+		return 0, 0, 0
+	}
+
+	// Luv to XYZ
+	varU := u/(13*l) + hsluvRefU
+	varV := v/(13*l) + hsluvRefV
+	y := l / hsluvKappa
+	if l > 8 {
+		y = math.Pow((l+16)/116, 3)
+	}
+	x := -(9 * y * varU) / ((varU-4)*varV - varU*varV)
+	z := (9*y - 15*varV*y - varV*x) / (3 * varV)
+
+	// XYZ to sRGB
+	var rgb [3]float64
+	for i, row := range hsluvM {
+		linear := row[0]*x + row[1]*y + row[2]*z
+		if linear <= 0.0031308 {
+			rgb[i] = 12.92 * linear
+		} else {
+			rgb[i] = 1.055*math.Pow(linear, 1/2.4) - 0.055
+		}
+	}
+	return rgb[0], rgb[1], rgb[2]
+}
+
+// maxChroma is the most chroma lightness l at hue h has inside sRGB
+func maxChroma(l, h float64) float64 {
+	hrad := h / 360 * 2 * math.Pi
+	sub1 := math.Pow(l+16, 3) / 1560896
+	sub2 := sub1
+	if sub1 <= hsluvEpsilon {
+		sub2 = l / hsluvKappa
+	}
+
+	shortest := math.MaxFloat64
+	for _, row := range hsluvM {
+		m1, m2, m3 := row[0], row[1], row[2]
+		for t := 0.0; t < 2; t++ {
+			top1 := (284517*m1 - 94839*m3) * sub2
+			top2 := (838422*m3+769860*m2+731718*m1)*l*sub2 - 769860*t*l
+			bottom := (632260*m3-126452*m2)*sub2 + 126452*t
+			slope, intercept := top1/bottom, top2/bottom
+			length := intercept / (math.Sin(hrad) - slope*math.Cos(hrad))
+			if length >= 0 && length < shortest {
+				shortest = length
+			}
+		}
+	}
+	return shortest
+}
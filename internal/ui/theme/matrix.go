@@ -17,6 +17,7 @@ func MatrixTheme() *Theme {
 			Warning:    "#FFFF00",
 			Success:    "#00FF00",
 			Online:     "#00FF00",
+			Chat:       "#ADFF2F",
 			Away:       "#CCFF00",
 			DND:        "#FF0000",
 			XA:         "#FF6600",
@@ -43,6 +44,7 @@ func MatrixTheme() *Theme {
 			UnencryptedIndicator: "#FF0000",
 			SystemMessageFg:      "#006600",
 			TypingIndicatorFg:    "#CCFF00",
+			Layout:               "compact",
 		},
 		StatusBar: StatusBarConfig{
 			Fg:          "#00FF00",
@@ -90,6 +92,7 @@ func NordTheme() *Theme {
 			Warning:    "#EBCB8B",
 			Success:    "#A3BE8C",
 			Online:     "#A3BE8C",
+			Chat:       "#8FBCBB",
 			Away:       "#EBCB8B",
 			DND:        "#BF616A",
 			XA:         "#D08770",
@@ -116,6 +119,7 @@ func NordTheme() *Theme {
 			UnencryptedIndicator: "#BF616A",
 			SystemMessageFg:      "#4C566A",
 			TypingIndicatorFg:    "#EBCB8B",
+			Layout:               "cozy",
 		},
 		StatusBar: StatusBarConfig{
 			Fg:          "#ECEFF4",
@@ -163,6 +167,7 @@ func GruvboxTheme() *Theme {
 			Warning:    "#FABD2F",
 			Success:    "#B8BB26",
 			Online:     "#B8BB26",
+			Chat:       "#8EC07C",
 			Away:       "#FABD2F",
 			DND:        "#FB4934",
 			XA:         "#FE8019",
@@ -189,6 +194,7 @@ func GruvboxTheme() *Theme {
 			UnencryptedIndicator: "#FB4934",
 			SystemMessageFg:      "#928374",
 			TypingIndicatorFg:    "#FABD2F",
+			Layout:               "compact",
 		},
 		StatusBar: StatusBarConfig{
 			Fg:          "#EBDBB2",
@@ -236,6 +242,7 @@ func DraculaTheme() *Theme {
 			Warning:    "#F1FA8C",
 			Success:    "#50FA7B",
 			Online:     "#50FA7B",
+			Chat:       "#8BE9FD",
 			Away:       "#F1FA8C",
 			DND:        "#FF5555",
 			XA:         "#FFB86C",
@@ -262,6 +269,7 @@ func DraculaTheme() *Theme {
 			UnencryptedIndicator: "#FF5555",
 			SystemMessageFg:      "#6272A4",
 			TypingIndicatorFg:    "#F1FA8C",
+			Layout:               "bubble",
 		},
 		StatusBar: StatusBarConfig{
 			Fg:          "#F8F8F2",
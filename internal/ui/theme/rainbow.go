@@ -17,6 +17,7 @@ func RainbowTheme() *Theme {
 			Warning:    "#F39C12",
 			Success:    "#27AE60",
 			Online:     "#00B894",
+			Chat:       "#55EFC4",
 			Away:       "#FDCB6E",
 			DND:        "#E74C3C",
 			XA:         "#E17055",
@@ -43,6 +44,7 @@ func RainbowTheme() *Theme {
 			UnencryptedIndicator: "#E74C3C",
 			SystemMessageFg:      "#636E72",
 			TypingIndicatorFg:    "#FDCB6E",
+			Layout:               "compact",
 		},
 		StatusBar: StatusBarConfig{
 			Fg:          "#DFE6E9",
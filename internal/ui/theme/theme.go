@@ -34,6 +34,7 @@ type ColorsConfig struct {
 	Warning    string `toml:"warning"`
 	Success    string `toml:"success"`
 	Online     string `toml:"online"`
+	Chat       string `toml:"chat"` // free for chat, defaults to the online colour
 	Away       string `toml:"away"`
 	DND        string `toml:"dnd"`
 	XA         string `toml:"xa"`
@@ -64,6 +65,7 @@ type ChatConfig struct {
 	UnencryptedIndicator string `toml:"unencrypted_indicator"`
 	SystemMessageFg      string `toml:"system_message_fg"`
 	TypingIndicatorFg    string `toml:"typing_indicator_fg"`
+	Layout               string `toml:"layout"` // compact, cozy or bubble, unless ui.chat_layout is set
 }
 
 // StatusBarConfig contains status bar styles
@@ -114,6 +116,7 @@ type Styles struct {
 
 	// Presence styles
 	PresenceOnline  lipgloss.Style
+	PresenceChat    lipgloss.Style
 	PresenceAway    lipgloss.Style
 	PresenceDND     lipgloss.Style
 	PresenceXA      lipgloss.Style
@@ -129,6 +132,13 @@ type Styles struct {
 	ChatSystem       lipgloss.Style
 	ChatTyping       lipgloss.Style
 
+	// JSON payload highlighting
+	ChatJSONKey     lipgloss.Style
+	ChatJSONString  lipgloss.Style
+	ChatJSONNumber  lipgloss.Style
+	ChatJSONLiteral lipgloss.Style
+	ChatJSONPunct   lipgloss.Style
+
 	// Status bar styles
 	StatusBar         lipgloss.Style
 	StatusModeNormal  lipgloss.Style
@@ -288,6 +298,14 @@ func (m *Manager) compileStyles(t *Theme) *Styles {
 	s.PresenceOnline = lipgloss.NewStyle().
 		Foreground(lipgloss.Color(t.Colors.Online))
 
+	chatColor := t.Colors.Chat
+	if chatColor == "" {
+		chatColor = t.Colors.Online
+	}
+	s.PresenceChat = lipgloss.NewStyle().
+		Foreground(lipgloss.Color(chatColor)).
+		Bold(true)
+
 	s.PresenceAway = lipgloss.NewStyle().
 		Foreground(lipgloss.Color(t.Colors.Away))
 
@@ -330,6 +348,21 @@ func (m *Manager) compileStyles(t *Theme) *Styles {
 		Foreground(lipgloss.Color(t.Chat.TypingIndicatorFg)).
 		Italic(true)
 
+	s.ChatJSONKey = lipgloss.NewStyle().
+		Foreground(lipgloss.Color(t.Colors.Primary))
+
+	s.ChatJSONString = lipgloss.NewStyle().
+		Foreground(lipgloss.Color(t.Colors.Success))
+
+	s.ChatJSONNumber = lipgloss.NewStyle().
+		Foreground(lipgloss.Color(t.Colors.Accent))
+
+	s.ChatJSONLiteral = lipgloss.NewStyle().
+		Foreground(lipgloss.Color(t.Colors.Warning))
+
+	s.ChatJSONPunct = lipgloss.NewStyle().
+		Foreground(lipgloss.Color(t.Colors.Muted))
+
 	// Status bar styles
 	s.StatusBar = lipgloss.NewStyle().
 		Foreground(lipgloss.Color(t.StatusBar.Fg)).
@@ -414,3 +447,82 @@ func (m *Manager) compileStyles(t *Theme) *Styles {
 
 	return s
 }
+
+// PresenceGlyph returns the indicator for a presence status: online, chat,
+// away, dnd, xa or offline
+func PresenceGlyph(status string) string {
+	switch status {
+	case "online":
+		return "●"
+	case "chat":
+		return "◉"
+	case "away":
+		return "◐"
+	case "dnd":
+		return "⊘"
+	case "xa":
+		return "◌"
+	default:
+		return "○"
+	}
+}
+
+// PresenceLabel returns the human readable name of a presence status
+func PresenceLabel(status string) string {
+	switch status {
+	case "online":
+		return "Online"
+	case "chat":
+		return "Free for Chat"
+	case "away":
+		return "Away"
+	case "dnd":
+		return "Do Not Disturb"
+	case "xa":
+		return "Extended Away"
+	default:
+		return "Offline"
+	}
+}
+
+// PresenceRank orders presence statuses from most to least reachable, for
+// sorting contacts by presence
+func PresenceRank(status string) int {
+	switch status {
+	case "chat":
+		return 0
+	case "online":
+		return 1
+	case "away":
+		return 2
+	case "dnd":
+		return 3
+	case "xa":
+		return 4
+	default:
+		return 5
+	}
+}
+
+// Presence returns the style for a presence status
+func (s *Styles) Presence(status string) lipgloss.Style {
+	switch status {
+	case "online":
+		return s.PresenceOnline
+	case "chat":
+		return s.PresenceChat
+	case "away":
+		return s.PresenceAway
+	case "dnd":
+		return s.PresenceDND
+	case "xa":
+		return s.PresenceXA
+	default:
+		return s.PresenceOffline
+	}
+}
+
+// PresenceIcon renders the coloured indicator for a presence status
+func (s *Styles) PresenceIcon(status string) string {
+	return s.Presence(status).Render(PresenceGlyph(status))
+}
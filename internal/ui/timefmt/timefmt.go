@@ -0,0 +1,122 @@
+// Package timefmt formats times the way the user configured with
+// ui.time_format and ui.date_format.
+package timefmt
+
+import (
+	"fmt"
+	"time"
+)
+
+// Named formats accepted besides Go layouts
+const (
+	Clock24  = "24h"      // 15:04
+	Clock12  = "12h"      // 3:04 PM
+	Relative = "relative" // 5m ago, falling back to the date for old times
+
+	DateISO = "iso" // 2006-01-02
+	DateUS  = "us"  // 01/02/2006
+	DateEU  = "eu"  // 02.01.2006
+)
+
+const (
+	defaultClock = "15:04"
+	defaultDate  = "2006-01-02"
+)
+
+// Formatter renders clock times and dates. The zero value uses 24h time
+// and ISO dates.
+type Formatter struct {
+	clock    string
+	date     string
+	relative bool
+}
+
+// New creates a Formatter from the configured time and date formats. Each
+// is a name above or a Go layout; empty means the default.
+func New(timeFormat, dateFormat string) Formatter {
+	f := Formatter{clock: defaultClock, date: defaultDate}
+	switch timeFormat {
+	case "", Clock24:
+	case Clock12:
+		f.clock = "3:04 PM"
+	case Relative:
+		f.relative = true
+	default:
+		f.clock = timeFormat
+	}
+	switch dateFormat {
+	case "", DateISO:
+	case DateUS:
+		f.date = "01/02/2006"
+	case DateEU:
+		f.date = "02.01.2006"
+	default:
+		f.date = dateFormat
+	}
+	return f
+}
+
+func (f Formatter) clockLayout() string {
+	if f.clock == "" {
+		return defaultClock
+	}
+	return f.clock
+}
+
+func (f Formatter) dateLayout() string {
+	if f.date == "" {
+		return defaultDate
+	}
+	return f.date
+}
+
+// Clock formats the time of day. It is never relative, for text that is
+// not re-rendered as time passes.
+func (f Formatter) Clock(t time.Time) string {
+	return t.Format(f.clockLayout())
+}
+
+// Date formats the date
+func (f Formatter) Date(t time.Time) string {
+	return t.Format(f.dateLayout())
+}
+
+// DateTime formats the date and time of day
+func (f Formatter) DateTime(t time.Time) string {
+	return f.Date(t) + " " + f.Clock(t)
+}
+
+// Stamp formats a message timestamp: relative to now in relative mode,
+// otherwise the time of day
+func (f Formatter) Stamp(t, now time.Time) string {
+	if f.relative {
+		return f.Ago(t, now)
+	}
+	return f.Clock(t)
+}
+
+// When formats a past moment like a last-seen time: relative to now in
+// relative mode, otherwise date and time
+func (f Formatter) When(t, now time.Time) string {
+	if f.relative {
+		return f.Ago(t, now)
+	}
+	return f.DateTime(t)
+}
+
+// Ago renders t relative to now, falling back to the date after a week
+func (f Formatter) Ago(t, now time.Time) string {
+	d := now.Sub(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	case d < 7*24*time.Hour:
+		return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+	default:
+		return f.Date(t)
+	}
+}
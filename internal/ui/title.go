@@ -0,0 +1,77 @@
+package ui
+
+import (
+	"os"
+	"strconv"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/meszmate/roster/internal/app"
+	"github.com/meszmate/roster/internal/ui/components/windows"
+)
+
+// With ui.terminal_title the terminal's title names the active conversation
+// and counts the unread messages of all windows, "roster (3) - alice"
+// or just "roster". Inside tmux or screen the multiplexer window is renamed
+// too, which tmux only allows with allow-rename on. The title is only sent
+// when it changes.
+
+// titleState is the title last sent. It is shared by the copies of the
+// model, which is passed by value.
+type titleState struct {
+	last string
+}
+
+// terminalTitle is the title for the current state
+func (m Model) terminalTitle() string {
+	title := "roster"
+	unread := 0
+	var active *windows.Window
+	for _, w := range m.windows.GetWindows() {
+		if w.Active {
+			w := w
+			active = &w
+		}
+		unread += w.Unread
+	}
+	if unread > 0 {
+		title += " (" + strconv.Itoa(unread) + ")"
+	}
+	if active != nil && active.Type != windows.WindowConsole {
+		name := active.Name
+		if name == "" {
+			name = active.Title
+		}
+		if name == "" {
+			name = active.JID
+		}
+		title += " - " + name
+	}
+	return title
+}
+
+// updateTitle returns the commands setting a changed title, nil otherwise
+func (m Model) updateTitle() tea.Cmd {
+	if m.title == nil || !m.app.Config().UI.TerminalTitle {
+		return nil
+	}
+	title := m.terminalTitle()
+	if title == m.title.last {
+		return nil
+	}
+	m.title.last = title
+	cmds := []tea.Cmd{tea.SetWindowTitle(title)}
+	if app.InMultiplexer() {
+		cmds = append(cmds, renameMultiplexerWindow(title))
+	}
+	return tea.Batch(cmds...)
+}
+
+// renameMultiplexerWindow names the tmux or screen window roster runs in
+func renameMultiplexerWindow(title string) tea.Cmd {
+	return func() tea.Msg {
+		// One write, so the sequence is not split by a redraw
+		_, _ = os.Stdout.WriteString("\x1bk" + title + "\x1b\\")
+		return nil
+	}
+}
@@ -1,6 +1,10 @@
 package api
 
 import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -22,30 +26,30 @@ type PluginAPI struct {
 	getUnreadCount   func(jid string) int
 	showNotification func(title, body string) error
 	showDialog       func(title, message string, buttons []string) (int, error)
-
-	// Event handlers
-	messageHandlers    []func(msg plugin.Message)
-	presenceHandlers   []func(jid, status string)
-	connectHandlers    []func()
-	disconnectHandlers []func()
+	setStatusBar     func(item plugin.StatusBarItem) error
+	removeStatusBar  func(id string) error
+	registerCommand  func(spec plugin.CommandSpec) error
+	removeCommand    func(name string)
+
+	// Event handlers, by pointer so that unsubscribing can find them
+	messageHandlers    []*func(msg plugin.Message)
+	urlHandlers        []*func(ev plugin.URLEvent)
+	presenceHandlers   []*func(jid, status string)
+	connectHandlers    []*func()
+	disconnectHandlers []*func()
 
 	// Commands
-	commands map[string]registeredCommand
+	commands map[string]plugin.CommandSpec
 
 	// Status bar items
-	statusBarItems map[string]string
-}
-
-type registeredCommand struct {
-	description string
-	handler     plugin.CommandHandler
+	statusBarItems map[string]plugin.StatusBarItem
 }
 
 // NewPluginAPI creates a new plugin API
 func NewPluginAPI() *PluginAPI {
 	return &PluginAPI{
-		commands:       make(map[string]registeredCommand),
-		statusBarItems: make(map[string]string),
+		commands:       make(map[string]plugin.CommandSpec),
+		statusBarItems: make(map[string]plugin.StatusBarItem),
 	}
 }
 
@@ -99,6 +103,27 @@ func (a *PluginAPI) SetShowDialog(f func(title, message string, buttons []string
 	a.showDialog = f
 }
 
+// SetStatusBarItem sets the callback that shows a status bar item
+func (a *PluginAPI) SetStatusBarItem(f func(item plugin.StatusBarItem) error) {
+	a.setStatusBar = f
+}
+
+// SetRemoveStatusBarItem sets the callback that removes a status bar item
+func (a *PluginAPI) SetRemoveStatusBarItem(f func(id string) error) {
+	a.removeStatusBar = f
+}
+
+// SetRegisterCommand sets the callback that adds a plugin command to the
+// command line
+func (a *PluginAPI) SetRegisterCommand(f func(spec plugin.CommandSpec) error) {
+	a.registerCommand = f
+}
+
+// SetUnregisterCommand sets the callback that removes a plugin command
+func (a *PluginAPI) SetUnregisterCommand(f func(name string)) {
+	a.removeCommand = f
+}
+
 // RosterAPI implementation
 
 // GetContacts returns all contacts
@@ -179,17 +204,31 @@ func (a *PluginAPI) ShowNotification(title, body string) error {
 
 // AddStatusBarItem adds an item to the status bar
 func (a *PluginAPI) AddStatusBarItem(id, text string) error {
+	return a.AddStatusBarWidget(plugin.StatusBarItem{ID: id, Text: text})
+}
+
+// AddStatusBarWidget adds or replaces a status bar item
+func (a *PluginAPI) AddStatusBarWidget(item plugin.StatusBarItem) error {
+	if item.ID == "" {
+		return fmt.Errorf("status bar item needs an id")
+	}
 	a.mu.Lock()
-	defer a.mu.Unlock()
-	a.statusBarItems[id] = text
+	a.statusBarItems[item.ID] = item
+	a.mu.Unlock()
+	if a.setStatusBar != nil {
+		return a.setStatusBar(item)
+	}
 	return nil
 }
 
 // RemoveStatusBarItem removes a status bar item
 func (a *PluginAPI) RemoveStatusBarItem(id string) error {
 	a.mu.Lock()
-	defer a.mu.Unlock()
 	delete(a.statusBarItems, id)
+	a.mu.Unlock()
+	if a.removeStatusBar != nil {
+		return a.removeStatusBar(id)
+	}
 	return nil
 }
 
@@ -208,7 +247,7 @@ func (a *PluginAPI) GetStatusBarItems() map[string]string {
 
 	result := make(map[string]string)
 	for k, v := range a.statusBarItems {
-		result[k] = v
+		result[k] = v.Text
 	}
 	return result
 }
@@ -217,103 +256,139 @@ func (a *PluginAPI) GetStatusBarItems() map[string]string {
 
 // OnMessage registers a message handler
 func (a *PluginAPI) OnMessage(handler func(msg plugin.Message)) func() {
-	a.mu.Lock()
-	defer a.mu.Unlock()
-
-	a.messageHandlers = append(a.messageHandlers, handler)
+	return addHandler(a, &a.messageHandlers, handler)
+}
 
-	// Return unsubscribe function
-	return func() {
-		a.mu.Lock()
-		defer a.mu.Unlock()
-		// Remove handler (simplified - in practice would track by ID)
-	}
+// OnURL registers a handler for received messages with links
+func (a *PluginAPI) OnURL(handler func(ev plugin.URLEvent)) func() {
+	return addHandler(a, &a.urlHandlers, handler)
 }
 
 // OnPresence registers a presence handler
 func (a *PluginAPI) OnPresence(handler func(jid, status string)) func() {
-	a.mu.Lock()
-	defer a.mu.Unlock()
-
-	a.presenceHandlers = append(a.presenceHandlers, handler)
-
-	return func() {
-		a.mu.Lock()
-		defer a.mu.Unlock()
-	}
+	return addHandler(a, &a.presenceHandlers, handler)
 }
 
 // OnConnect registers a connect handler
 func (a *PluginAPI) OnConnect(handler func()) func() {
-	a.mu.Lock()
-	defer a.mu.Unlock()
-
-	a.connectHandlers = append(a.connectHandlers, handler)
-
-	return func() {
-		a.mu.Lock()
-		defer a.mu.Unlock()
-	}
+	return addHandler(a, &a.connectHandlers, handler)
 }
 
 // OnDisconnect registers a disconnect handler
 func (a *PluginAPI) OnDisconnect(handler func()) func() {
-	a.mu.Lock()
-	defer a.mu.Unlock()
+	return addHandler(a, &a.disconnectHandlers, handler)
+}
 
-	a.disconnectHandlers = append(a.disconnectHandlers, handler)
+// addHandler adds an event handler to a list and returns the function that
+// takes it out again
+func addHandler[T any](a *PluginAPI, list *[]*T, handler T) func() {
+	h := &handler
+	a.mu.Lock()
+	*list = append(*list, h)
+	a.mu.Unlock()
 
 	return func() {
 		a.mu.Lock()
 		defer a.mu.Unlock()
+		*list = slices.DeleteFunc(*list, func(other *T) bool { return other == h })
 	}
 }
 
+// handlersOf returns a copy of a list of event handlers to call without
+// the lock
+func handlersOf[T any](a *PluginAPI, list *[]*T) []*T {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return slices.Clone(*list)
+}
+
 // EmitMessage emits a message event to all handlers
 func (a *PluginAPI) EmitMessage(msg plugin.Message) {
-	a.mu.RLock()
-	handlers := make([]func(plugin.Message), len(a.messageHandlers))
-	copy(handlers, a.messageHandlers)
-	a.mu.RUnlock()
+	for _, handler := range handlersOf(a, &a.messageHandlers) {
+		go (*handler)(msg)
+	}
+}
+
+// EmitURLs emits a URL event for a received message that contains links.
+// The application calls it for every received message, along with
+// EmitMessage, with the conversation the message belongs to; outgoing
+// messages and those without links are skipped here.
+func (a *PluginAPI) EmitURLs(msg plugin.Message, conversation string, room bool) {
+	if msg.Outgoing {
+		return
+	}
+	urls := ExtractURLs(msg.Body)
+	if len(urls) == 0 {
+		return
+	}
 
+	handlers := handlersOf(a, &a.urlHandlers)
+	if len(handlers) == 0 {
+		return
+	}
+
+	ev := plugin.URLEvent{
+		URLs:         urls,
+		Message:      msg,
+		Conversation: conversation,
+		Room:         room,
+		Sender:       urlSender(msg.From, conversation, room),
+	}
 	for _, handler := range handlers {
-		go handler(msg)
+		go (*handler)(ev)
+	}
+}
+
+// urlRe matches http(s) links in a message body
+var urlRe = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// ExtractURLs returns the http(s) links in a message body, without the
+// punctuation that ends a sentence after them, each once
+func ExtractURLs(body string) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, match := range urlRe.FindAllString(body, -1) {
+		url := strings.TrimRight(match, ".,;:!?)")
+		if strings.HasSuffix(url, "://") || seen[url] {
+			continue
+		}
+		seen[url] = true
+		urls = append(urls, url)
 	}
+	return urls
+}
+
+// urlSender names the sender of a message: the nick for a room, the bare
+// JID otherwise
+func urlSender(from, conversation string, room bool) string {
+	bare, resource, _ := strings.Cut(from, "/")
+	if room && resource != "" {
+		return resource
+	}
+	if bare == "" {
+		return conversation
+	}
+	return bare
 }
 
 // EmitPresence emits a presence event to all handlers
 func (a *PluginAPI) EmitPresence(jid, status string) {
-	a.mu.RLock()
-	handlers := make([]func(string, string), len(a.presenceHandlers))
-	copy(handlers, a.presenceHandlers)
-	a.mu.RUnlock()
-
-	for _, handler := range handlers {
-		go handler(jid, status)
+	for _, handler := range handlersOf(a, &a.presenceHandlers) {
+		go (*handler)(jid, status)
 	}
 }
 
 // EmitConnect emits a connect event to all handlers
 func (a *PluginAPI) EmitConnect() {
-	a.mu.RLock()
-	handlers := make([]func(), len(a.connectHandlers))
-	copy(handlers, a.connectHandlers)
-	a.mu.RUnlock()
-
-	for _, handler := range handlers {
-		go handler()
+	for _, handler := range handlersOf(a, &a.connectHandlers) {
+		go (*handler)()
 	}
 }
 
 // EmitDisconnect emits a disconnect event to all handlers
 func (a *PluginAPI) EmitDisconnect() {
-	a.mu.RLock()
-	handlers := make([]func(), len(a.disconnectHandlers))
-	copy(handlers, a.disconnectHandlers)
-	a.mu.RUnlock()
-
-	for _, handler := range handlers {
-		go handler()
+	for _, handler := range handlersOf(a, &a.disconnectHandlers) {
+		go (*handler)()
 	}
 }
 
@@ -321,22 +396,36 @@ func (a *PluginAPI) EmitDisconnect() {
 
 // RegisterCommand registers a custom command
 func (a *PluginAPI) RegisterCommand(name, description string, handler plugin.CommandHandler) error {
-	a.mu.Lock()
-	defer a.mu.Unlock()
+	return a.RegisterCommandSpec(plugin.CommandSpec{Name: name, Description: description, Handler: handler})
+}
 
-	a.commands[name] = registeredCommand{
-		description: description,
-		handler:     handler,
+// RegisterCommandSpec registers a custom command with help and completion
+func (a *PluginAPI) RegisterCommandSpec(spec plugin.CommandSpec) error {
+	if spec.Name == "" || spec.Handler == nil {
+		return fmt.Errorf("command needs a name and a handler")
 	}
+	if a.registerCommand != nil {
+		if err := a.registerCommand(spec); err != nil {
+			return err
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.commands[spec.Name] = spec
 	return nil
 }
 
 // UnregisterCommand removes a custom command
 func (a *PluginAPI) UnregisterCommand(name string) error {
 	a.mu.Lock()
-	defer a.mu.Unlock()
-
+	_, ok := a.commands[name]
 	delete(a.commands, name)
+	a.mu.Unlock()
+
+	if ok && a.removeCommand != nil {
+		a.removeCommand(name)
+	}
 	return nil
 }
 
@@ -347,7 +436,7 @@ func (a *PluginAPI) GetCommands() map[string]string {
 
 	result := make(map[string]string)
 	for name, cmd := range a.commands {
-		result[name] = cmd.description
+		result[name] = cmd.Description
 	}
 	return result
 }
@@ -362,7 +451,7 @@ func (a *PluginAPI) ExecuteCommand(name string, args []string) error {
 		return nil
 	}
 
-	return cmd.handler(args)
+	return cmd.Handler(args)
 }
 
 // CreateMessage creates a plugin message from app data
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/meszmate/roster/pkg/plugin"
+)
+
+func TestUnsubscribeRemovesHandler(t *testing.T) {
+	a := NewPluginAPI()
+	first := make(chan plugin.URLEvent, 1)
+	second := make(chan plugin.URLEvent, 1)
+	unsubscribe := a.OnURL(func(ev plugin.URLEvent) { first <- ev })
+	a.OnURL(func(ev plugin.URLEvent) { second <- ev })
+
+	unsubscribe()
+	unsubscribe() // a second call finds nothing to remove
+	a.EmitURLs(plugin.Message{From: "room@conference.example.com/juliet", Body: "see https://example.org/balcony."}, "room@conference.example.com", true)
+
+	select {
+	case ev := <-second:
+		if len(ev.URLs) != 1 || ev.URLs[0] != "https://example.org/balcony" || ev.Sender != "juliet" {
+			t.Fatalf("unexpected event %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the remaining handler was not called")
+	}
+	select {
+	case ev := <-first:
+		t.Fatalf("an unsubscribed handler got %+v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if n := len(a.urlHandlers); n != 1 {
+		t.Fatalf("expected one handler left, got %d", n)
+	}
+}
@@ -0,0 +1,412 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+// Plugins run in processes of their own and talk to roster over gRPC through
+// go-plugin. There are no generated stubs: each side serves a service with a
+// single Call method, whose request names the method and carries its
+// arguments as JSON. roster calls the plugin to initialize, start and stop
+// it and to deliver events; the plugin calls the API back on a connection
+// set up by the go-plugin broker.
+
+const (
+	pluginService = "roster.plugin.Plugin" // served by the plugin
+	apiService    = "roster.plugin.API"    // served by roster to the plugin
+
+	// callCodec names the JSON codec both sides register for the calls
+	callCodec = "roster-json"
+)
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// jsonCodec encodes calls as JSON instead of protocol buffers
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                       { return callCodec }
+
+// callRequest calls a method on the other side
+type callRequest struct {
+	Method string          `json:"method"`
+	Args   json.RawMessage `json:"args,omitempty"`
+}
+
+// callResponse carries the result of a call, or the error it returned
+type callResponse struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// callHandler answers the calls made to a service
+type callHandler interface {
+	handle(ctx context.Context, method string, args json.RawMessage) (any, error)
+}
+
+// serviceDesc describes a service whose Call method is answered by a
+// callHandler
+func serviceDesc(name string) *grpc.ServiceDesc {
+	return &grpc.ServiceDesc{
+		ServiceName: name,
+		HandlerType: (*callHandler)(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "Call",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				req := new(callRequest)
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+				call := func(ctx context.Context, req any) (any, error) {
+					return answer(ctx, srv.(callHandler), req.(*callRequest))
+				}
+				if interceptor == nil {
+					return call(ctx, req)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + name + "/Call"}
+				return interceptor(ctx, req, info, call)
+			},
+		}},
+		Metadata: name,
+	}
+}
+
+// answer runs a call and puts what it returned in a response; errors of the
+// method travel in the response, not as gRPC errors
+func answer(ctx context.Context, h callHandler, req *callRequest) (*callResponse, error) {
+	result, err := h.handle(ctx, req.Method, req.Args)
+	if err != nil {
+		return &callResponse{Error: err.Error()}, nil
+	}
+	resp := &callResponse{}
+	if result != nil {
+		if resp.Result, err = json.Marshal(result); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// caller calls the methods of a service on the other side
+type caller struct {
+	conn    *grpc.ClientConn
+	service string
+}
+
+// call runs a method with args and decodes what it returned into result,
+// which may be nil
+func (c caller) call(method string, args, result any) error {
+	req := &callRequest{Method: method}
+	if args != nil {
+		raw, err := json.Marshal(args)
+		if err != nil {
+			return err
+		}
+		req.Args = raw
+	}
+	var resp callResponse
+	if err := c.conn.Invoke(context.Background(), "/"+c.service+"/Call", req, &resp, grpc.CallContentSubtype(callCodec)); err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return remoteError(resp.Error)
+	}
+	if result != nil && len(resp.Result) > 0 {
+		return json.Unmarshal(resp.Result, result)
+	}
+	return nil
+}
+
+// remoteError turns an error from the other side back into one, keeping
+// ErrPermissionDenied comparable with errors.Is
+func remoteError(msg string) error {
+	if msg == ErrPermissionDenied.Error() {
+		return ErrPermissionDenied
+	}
+	return errors.New(msg)
+}
+
+// decodeArgs reads the arguments of a call
+func decodeArgs(raw json.RawMessage, v any) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	return nil
+}
+
+// Events a plugin can subscribe to
+const (
+	eventMessage    = "message"
+	eventURL        = "url"
+	eventPresence   = "presence"
+	eventConnect    = "connect"
+	eventDisconnect = "disconnect"
+)
+
+// pluginInfo describes a plugin, fetched once when it is loaded
+type pluginInfo struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+}
+
+// pluginArgs are the arguments of the calls roster makes to a plugin
+type pluginArgs struct {
+	API          uint32    `json:"api,omitempty"` // broker id of the API service
+	Subscription uint64    `json:"subscription,omitempty"`
+	Message      *Message  `json:"message,omitempty"`
+	URL          *URLEvent `json:"url,omitempty"`
+	JID          string    `json:"jid,omitempty"`
+	Status       string    `json:"status,omitempty"`
+	Item         string    `json:"item,omitempty"` // id of a status bar item
+	Command      string    `json:"command,omitempty"`
+	Args         []string  `json:"args,omitempty"` // of the command
+}
+
+// apiArgs are the arguments of the calls a plugin makes to the API
+type apiArgs struct {
+	JID          string   `json:"jid,omitempty"`
+	Name         string   `json:"name,omitempty"`
+	Groups       []string `json:"groups,omitempty"`
+	To           string   `json:"to,omitempty"`
+	Body         string   `json:"body,omitempty"`
+	Limit        int      `json:"limit,omitempty"`
+	Title        string   `json:"title,omitempty"`
+	Buttons      []string `json:"buttons,omitempty"`
+	Event        string   `json:"event,omitempty"`
+	Subscription uint64   `json:"subscription,omitempty"`
+
+	Item    *statusItem  `json:"item,omitempty"`
+	Command *commandSpec `json:"command,omitempty"`
+}
+
+// statusItem is a status bar item as it is sent to roster. The callbacks
+// stay in the plugin; roster calls back for them.
+type statusItem struct {
+	ID        string        `json:"id"`
+	Text      string        `json:"text"`
+	Priority  int           `json:"priority,omitempty"`
+	MaxWidth  int           `json:"max_width,omitempty"`
+	Refresh   time.Duration `json:"refresh,omitempty"`
+	Refreshes bool          `json:"refreshes,omitempty"` // has OnRefresh
+	Activates bool          `json:"activates,omitempty"` // has OnActivate
+}
+
+// commandSpec is a command as it is sent to roster. The handler and the
+// completion stay in the plugin; roster calls back for them.
+type commandSpec struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Args        []string `json:"args,omitempty"`
+	Help        string   `json:"help,omitempty"`
+	Completes   bool     `json:"completes,omitempty"` // has Complete
+}
+
+// GRPCPlugin connects roster and a plugin process through go-plugin
+type GRPCPlugin struct {
+	plugin.Plugin
+	Impl Plugin
+}
+
+// GRPCServer serves the plugin in its process
+func (p *GRPCPlugin) GRPCServer(broker *plugin.GRPCBroker, s *grpc.Server) error {
+	s.RegisterService(serviceDesc(pluginService), &pluginServer{impl: p.Impl, broker: broker})
+	return nil
+}
+
+// GRPCClient returns the plugin as roster uses it
+func (p *GRPCPlugin) GRPCClient(ctx context.Context, broker *plugin.GRPCBroker, c *grpc.ClientConn) (interface{}, error) {
+	client := &pluginClient{caller: caller{conn: c, service: pluginService}, broker: broker}
+	if err := client.call("describe", nil, &client.info); err != nil {
+		return nil, fmt.Errorf("failed to describe plugin: %w", err)
+	}
+	return client, nil
+}
+
+// pluginClient is a plugin process as roster sees it
+type pluginClient struct {
+	caller
+	broker *plugin.GRPCBroker
+	info   pluginInfo
+}
+
+func (p *pluginClient) Name() string        { return p.info.Name }
+func (p *pluginClient) Version() string     { return p.info.Version }
+func (p *pluginClient) Description() string { return p.info.Description }
+
+// Init serves api to the plugin and hands it over
+func (p *pluginClient) Init(ctx context.Context, api API) error {
+	server := &apiServer{api: api, plugin: p.caller, subscriptions: make(map[uint64]func()), commands: make(map[string]bool)}
+	id := p.broker.NextId()
+	go p.broker.AcceptAndServe(id, func(opts []grpc.ServerOption) *grpc.Server {
+		s := grpc.NewServer(opts...)
+		s.RegisterService(serviceDesc(apiService), server)
+		return s
+	})
+	return p.call("init", pluginArgs{API: id}, nil)
+}
+
+func (p *pluginClient) Start() error { return p.call("start", nil, nil) }
+func (p *pluginClient) Stop() error  { return p.call("stop", nil, nil) }
+
+// apiServer answers the API calls of a plugin with api, which the host has
+// limited to what the plugin was granted
+type apiServer struct {
+	api    API
+	plugin caller
+
+	mu            sync.Mutex
+	subscriptions map[uint64]func() // unsubscribe functions by subscription
+	commands      map[string]bool   // registered by this plugin, the only ones it may unregister
+}
+
+func (s *apiServer) handle(ctx context.Context, method string, raw json.RawMessage) (any, error) {
+	var args apiArgs
+	if err := decodeArgs(raw, &args); err != nil {
+		return nil, err
+	}
+	switch method {
+	case "get_contacts":
+		return s.api.GetContacts(), nil
+	case "get_contact":
+		return s.api.GetContact(args.JID), nil
+	case "add_contact":
+		return nil, s.api.AddContact(args.JID, args.Name, args.Groups)
+	case "remove_contact":
+		return nil, s.api.RemoveContact(args.JID)
+	case "get_presence":
+		return s.api.GetPresence(args.JID), nil
+	case "send_message":
+		return nil, s.api.SendMessage(args.To, args.Body)
+	case "get_history":
+		return s.api.GetHistory(args.JID, args.Limit), nil
+	case "get_unread_count":
+		return s.api.GetUnreadCount(args.JID), nil
+	case "show_notification":
+		return nil, s.api.ShowNotification(args.Title, args.Body)
+	case "show_dialog":
+		return s.api.ShowDialog(args.Title, args.Body, args.Buttons)
+	case "set_status_item", "remove_status_item":
+		if args.Item == nil {
+			return nil, fmt.Errorf("no status bar item")
+		}
+		if method == "remove_status_item" {
+			return nil, s.api.RemoveStatusBarItem(args.Item.ID)
+		}
+		return nil, s.api.AddStatusBarWidget(s.statusItem(*args.Item))
+	case "register_command":
+		if args.Command == nil {
+			return nil, fmt.Errorf("no command")
+		}
+		if err := s.api.RegisterCommandSpec(s.command(*args.Command)); err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		s.commands[args.Command.Name] = true
+		s.mu.Unlock()
+		return nil, nil
+	case "unregister_command":
+		s.mu.Lock()
+		ours := s.commands[args.Name]
+		delete(s.commands, args.Name)
+		s.mu.Unlock()
+		if !ours {
+			return nil, nil
+		}
+		return nil, s.api.UnregisterCommand(args.Name)
+	case "subscribe":
+		return nil, s.subscribe(args.Event, args.Subscription)
+	case "unsubscribe":
+		s.mu.Lock()
+		unsubscribe := s.subscriptions[args.Subscription]
+		delete(s.subscriptions, args.Subscription)
+		s.mu.Unlock()
+		if unsubscribe != nil {
+			unsubscribe()
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown method %q", method)
+}
+
+// subscribe forwards an event to the plugin, which runs the handler it
+// registered as the subscription
+func (s *apiServer) subscribe(event string, id uint64) error {
+	deliver := func(args pluginArgs) {
+		args.Subscription = id
+		_ = s.plugin.call("event", args, nil)
+	}
+	var unsubscribe func()
+	switch event {
+	case eventMessage:
+		unsubscribe = s.api.OnMessage(func(msg Message) { deliver(pluginArgs{Message: &msg}) })
+	case eventURL:
+		unsubscribe = s.api.OnURL(func(ev URLEvent) { deliver(pluginArgs{URL: &ev}) })
+	case eventPresence:
+		unsubscribe = s.api.OnPresence(func(jid, status string) { deliver(pluginArgs{JID: jid, Status: status}) })
+	case eventConnect:
+		unsubscribe = s.api.OnConnect(func() { deliver(pluginArgs{}) })
+	case eventDisconnect:
+		unsubscribe = s.api.OnDisconnect(func() { deliver(pluginArgs{}) })
+	default:
+		return fmt.Errorf("unknown event %q", event)
+	}
+	s.mu.Lock()
+	s.subscriptions[id] = unsubscribe
+	s.mu.Unlock()
+	return nil
+}
+
+// statusItem rebuilds a status bar item of the plugin, with callbacks that
+// call the plugin. A refresh that fails keeps the last text.
+func (s *apiServer) statusItem(w statusItem) StatusBarItem {
+	item := StatusBarItem{ID: w.ID, Text: w.Text, Priority: w.Priority, MaxWidth: w.MaxWidth, Refresh: w.Refresh}
+	if w.Refreshes {
+		text := w.Text
+		item.OnRefresh = func() string {
+			var fresh string
+			if err := s.plugin.call("refresh_status_item", pluginArgs{Item: w.ID}, &fresh); err == nil {
+				text = fresh
+			}
+			return text
+		}
+	}
+	if w.Activates {
+		item.OnActivate = func() {
+			_ = s.plugin.call("activate_status_item", pluginArgs{Item: w.ID}, nil)
+		}
+	}
+	return item
+}
+
+// command rebuilds a command of the plugin, with a handler and completion
+// that call the plugin
+func (s *apiServer) command(c commandSpec) CommandSpec {
+	spec := CommandSpec{Name: c.Name, Description: c.Description, Args: c.Args, Help: c.Help}
+	spec.Handler = func(args []string) error {
+		return s.plugin.call("run_command", pluginArgs{Command: c.Name, Args: args}, nil)
+	}
+	if c.Completes {
+		spec.Complete = func(args []string) []string {
+			var candidates []string
+			_ = s.plugin.call("complete_command", pluginArgs{Command: c.Name, Args: args}, &candidates)
+			return candidates
+		}
+	}
+	return spec
+}
@@ -7,10 +7,12 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
 
+	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/go-plugin"
-	"google.golang.org/grpc"
+	"github.com/meszmate/roster/pkg/plugin/manifest"
 )
 
 // Host manages plugin lifecycle
@@ -19,15 +21,20 @@ type Host struct {
 	plugins   map[string]*LoadedPlugin
 	pluginDir string
 	api       API
+	consent   ConsentFunc
 }
 
 // LoadedPlugin represents a loaded plugin
 type LoadedPlugin struct {
-	Name    string
-	Version string
-	Plugin  Plugin
-	Client  *plugin.Client
-	Running bool
+	Name        string
+	Version     string
+	Plugin      Plugin
+	Client      *plugin.Client
+	Running     bool
+	Permissions []manifest.Permission // granted from the manifest
+	// Unsandboxed says why the plugin can reach the network although it was
+	// not granted to, empty when it cannot or was granted it
+	Unsandboxed string
 }
 
 // Handshake is the plugin handshake config
@@ -51,6 +58,14 @@ func NewHost(pluginDir string, api API) *Host {
 	}
 }
 
+// SetConsent sets who decides whether a plugin may have the permissions
+// its manifest declares. Without it, only plugins that declare none load.
+func (h *Host) SetConsent(consent ConsentFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.consent = consent
+}
+
 // LoadAll loads all plugins from the plugin directory
 func (h *Host) LoadAll() error {
 	if h.pluginDir == "" {
@@ -66,7 +81,8 @@ func (h *Host) LoadAll() error {
 	}
 
 	for _, entry := range entries {
-		if entry.IsDir() {
+		// Manifests and the install record are not plugins
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".json") {
 			continue
 		}
 
@@ -79,16 +95,48 @@ func (h *Host) LoadAll() error {
 	return nil
 }
 
-// Load loads a single plugin
+// Load loads a single plugin, once the permissions in its manifest were
+// consented to
 func (h *Host) Load(path string) error {
+	m, err := manifest.Read(path)
+	if err != nil {
+		return err
+	}
+	// Consent is keyed by the file name, so a manifest cannot borrow the
+	// grant of another plugin
+	m.Name = filepath.Base(path)
+	h.mu.RLock()
+	consent := h.consent
+	h.mu.RUnlock()
+	if len(m.Permissions) > 0 && (consent == nil || !consent(m)) {
+		return fmt.Errorf("permissions not granted: %s", manifest.Format(m.Permissions))
+	}
+
+	api := newGuardedAPI(h.api, m.Name, m.Permissions)
+	cmd := exec.Command(path)
+	var unsandboxed string
+	if err := sandboxCommand(cmd, api.perms[manifest.PermNetwork]); err != nil {
+		// Run it anyway, as refusing would break every plugin on such
+		// systems, but say so; :plugin perms tells as well
+		unsandboxed = err.Error()
+		log.Printf("Plugin %s can reach the network, the sandbox is unavailable: %v", m.Name, err)
+	}
+
 	// Create the plugin client
 	client := plugin.NewClient(&plugin.ClientConfig{
 		HandshakeConfig: Handshake,
 		Plugins:         PluginMap,
-		Cmd:             exec.Command(path),
+		Cmd:             cmd,
 		AllowedProtocols: []plugin.Protocol{
 			plugin.ProtocolGRPC,
 		},
+		// go-plugin logs every line at trace level to stderr otherwise,
+		// over the TUI
+		Logger: hclog.New(&hclog.LoggerOptions{
+			Name:   "plugin",
+			Level:  hclog.Warn,
+			Output: log.Writer(),
+		}),
 	})
 
 	// Connect via RPC
@@ -105,21 +153,27 @@ func (h *Host) Load(path string) error {
 		return fmt.Errorf("failed to dispense plugin: %w", err)
 	}
 
-	p := raw.(Plugin)
+	p, ok := raw.(Plugin)
+	if !ok {
+		client.Kill()
+		return fmt.Errorf("failed to dispense plugin: unexpected type %T", raw)
+	}
 
-	// Initialize the plugin
+	// Initialize the plugin with only what it may use
 	ctx := context.Background()
-	if err := p.Init(ctx, h.api); err != nil {
+	if err := p.Init(ctx, api); err != nil {
 		client.Kill()
 		return fmt.Errorf("failed to initialize plugin: %w", err)
 	}
 
 	h.mu.Lock()
 	h.plugins[p.Name()] = &LoadedPlugin{
-		Name:    p.Name(),
-		Version: p.Version(),
-		Plugin:  p,
-		Client:  client,
+		Name:        p.Name(),
+		Version:     p.Version(),
+		Plugin:      p,
+		Client:      client,
+		Permissions: m.Permissions,
+		Unsandboxed: unsandboxed,
 	}
 	h.mu.Unlock()
 
@@ -222,21 +276,3 @@ func (h *Host) Get(name string) *LoadedPlugin {
 	defer h.mu.RUnlock()
 	return h.plugins[name]
 }
-
-// GRPCPlugin is the gRPC plugin implementation
-type GRPCPlugin struct {
-	plugin.Plugin
-	Impl Plugin
-}
-
-// GRPCServer returns the gRPC server
-func (p *GRPCPlugin) GRPCServer(broker *plugin.GRPCBroker, s *grpc.Server) error {
-	// Would register the gRPC service here
-	return nil
-}
-
-// GRPCClient returns the gRPC client
-func (p *GRPCPlugin) GRPCClient(ctx context.Context, broker *plugin.GRPCBroker, c *grpc.ClientConn) (interface{}, error) {
-	// Would create gRPC client here
-	return nil, nil
-}
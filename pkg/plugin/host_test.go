@@ -0,0 +1,261 @@
+package plugin_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/meszmate/roster/pkg/plugin"
+	"github.com/meszmate/roster/pkg/plugin/api"
+	"github.com/meszmate/roster/pkg/plugin/manifest"
+)
+
+// The test binary doubles as a plugin: started by the host, it serves
+// echoPlugin instead of running the tests
+func TestMain(m *testing.M) {
+	if os.Getenv(plugin.Handshake.MagicCookieKey) == plugin.Handshake.MagicCookieValue {
+		plugin.Serve(&echoPlugin{})
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// echoPlugin answers every message, saying whether it was allowed to add a
+// contact, which it was not granted. Its status bar item counts refreshes
+// and sends a message when activated.
+type echoPlugin struct {
+	api       plugin.API
+	refreshes int
+}
+
+func (p *echoPlugin) Name() string        { return "echo" }
+func (p *echoPlugin) Version() string     { return "1.0.0" }
+func (p *echoPlugin) Description() string { return "Answers every message" }
+func (p *echoPlugin) Stop() error         { return nil }
+
+func (p *echoPlugin) Start() error {
+	_ = p.api.AddStatusBarWidget(plugin.StatusBarItem{
+		ID:   "echo",
+		Text: "echo: 0",
+		OnRefresh: func() string {
+			p.refreshes++
+			return fmt.Sprintf("echo: %d", p.refreshes)
+		},
+		OnActivate: func() { _ = p.api.SendMessage("romeo@example.com", "activated") },
+	})
+	_ = p.api.RegisterCommandSpec(plugin.CommandSpec{
+		Name: "shout",
+		Args: []string{"jid", "text"},
+		Complete: func(args []string) []string {
+			return []string{"romeo@example.com", "juliet@example.com"}
+		},
+		Handler: func(args []string) error {
+			if len(args) < 2 {
+				return errors.New("usage: shout <jid> <text>")
+			}
+			return p.api.SendMessage(args[0], strings.ToUpper(args[1]))
+		},
+	})
+	return nil
+}
+
+func (p *echoPlugin) Init(ctx context.Context, api plugin.API) error {
+	p.api = api
+	api.OnMessage(func(msg plugin.Message) {
+		denied := errors.Is(api.AddContact(msg.From, "", nil), plugin.ErrPermissionDenied)
+		_ = api.SendMessage(msg.From, fmt.Sprintf("echo: %s (denied %v)", msg.Body, denied))
+	})
+	api.OnURL(func(ev plugin.URLEvent) {
+		_ = api.SendMessage(ev.Conversation, ev.Sender+" shared "+strings.Join(ev.URLs, " "))
+	})
+	return nil
+}
+
+// installPlugin puts the test binary in a plugin directory with a manifest
+// declaring perms
+func installPlugin(t *testing.T, perms ...manifest.Permission) string {
+	t.Helper()
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "echo")
+	if err := os.Symlink(self, path); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.Write(path, manifest.Manifest{Name: "echo", Permissions: perms}); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadRefusesMissingGrant(t *testing.T) {
+	path := installPlugin(t, manifest.PermSendMessages)
+	host := plugin.NewHost(filepath.Dir(path), api.NewPluginAPI())
+	defer host.UnloadAll()
+
+	if err := host.Load(path); err == nil || !strings.Contains(err.Error(), "permissions not granted") {
+		t.Fatalf("expected a plugin without consent to be refused, got %v", err)
+	}
+
+	var asked string
+	host.SetConsent(func(m manifest.Manifest) bool {
+		asked = m.Name
+		return false
+	})
+	if err := host.Load(path); err == nil || !strings.Contains(err.Error(), "permissions not granted") {
+		t.Fatalf("expected a refused grant to stop the plugin, got %v", err)
+	}
+	if asked != "echo" {
+		t.Fatalf("expected consent to be asked for the file name, got %q", asked)
+	}
+	if len(host.List()) != 0 {
+		t.Fatal("expected no plugin to be loaded")
+	}
+}
+
+func TestLoadServesPlugin(t *testing.T) {
+	path := installPlugin(t, manifest.PermReadMessages, manifest.PermSendMessages)
+	sent := make(chan string, 1)
+	pluginAPI := api.NewPluginAPI()
+	pluginAPI.SetSendMessage(func(to, body string) error {
+		sent <- to + " " + body
+		return nil
+	})
+	pluginAPI.SetAddContact(func(jid, name string, groups []string) error {
+		t.Error("a plugin without edit_roster added a contact")
+		return nil
+	})
+	host := plugin.NewHost(filepath.Dir(path), pluginAPI)
+	host.SetConsent(func(manifest.Manifest) bool { return true })
+	defer host.UnloadAll()
+
+	if err := host.Load(path); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if err := host.Start("echo"); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if lp := host.Get("echo"); lp == nil || lp.Version != "1.0.0" || !lp.Running {
+		t.Fatalf("expected echo to be running, got %+v", lp)
+	}
+
+	pluginAPI.EmitMessage(plugin.Message{From: "juliet@example.com", Body: "hi"})
+	select {
+	case got := <-sent:
+		if want := "juliet@example.com echo: hi (denied true)"; got != want {
+			t.Fatalf("expected %q, got %q", want, got)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the plugin did not answer")
+	}
+
+	pluginAPI.EmitURLs(plugin.Message{From: "room@conference.example.com/juliet", Body: "https://example.org/balcony"}, "room@conference.example.com", true)
+	select {
+	case got := <-sent:
+		if want := "room@conference.example.com juliet shared https://example.org/balcony"; got != want {
+			t.Fatalf("expected %q, got %q", want, got)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the plugin got no link")
+	}
+}
+
+func TestStatusBarItemCallsBack(t *testing.T) {
+	path := installPlugin(t, manifest.PermStatusBar, manifest.PermSendMessages)
+	items := make(chan plugin.StatusBarItem, 1)
+	sent := make(chan string, 1)
+	pluginAPI := api.NewPluginAPI()
+	pluginAPI.SetStatusBarItem(func(item plugin.StatusBarItem) error {
+		items <- item
+		return nil
+	})
+	pluginAPI.SetSendMessage(func(to, body string) error {
+		sent <- to + " " + body
+		return nil
+	})
+	host := plugin.NewHost(filepath.Dir(path), pluginAPI)
+	host.SetConsent(func(manifest.Manifest) bool { return true })
+	defer host.UnloadAll()
+
+	if err := host.Load(path); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if err := host.Start("echo"); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	var item plugin.StatusBarItem
+	select {
+	case item = <-items:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the plugin added no status bar item")
+	}
+	if item.ID != "echo" || item.Text != "echo: 0" || item.OnRefresh == nil || item.OnActivate == nil {
+		t.Fatalf("unexpected item %+v", item)
+	}
+	if text := item.OnRefresh(); text != "echo: 1" {
+		t.Fatalf("expected the plugin to refresh the text, got %q", text)
+	}
+	item.OnActivate()
+	select {
+	case got := <-sent:
+		if got != "romeo@example.com activated" {
+			t.Fatalf("unexpected message %q", got)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("activating the item did not reach the plugin")
+	}
+}
+
+func TestCommandCallsBack(t *testing.T) {
+	path := installPlugin(t, manifest.PermCommands, manifest.PermSendMessages)
+	commands := make(chan plugin.CommandSpec, 1)
+	sent := make(chan string, 1)
+	pluginAPI := api.NewPluginAPI()
+	pluginAPI.SetRegisterCommand(func(spec plugin.CommandSpec) error {
+		commands <- spec
+		return nil
+	})
+	pluginAPI.SetSendMessage(func(to, body string) error {
+		sent <- to + " " + body
+		return nil
+	})
+	host := plugin.NewHost(filepath.Dir(path), pluginAPI)
+	host.SetConsent(func(manifest.Manifest) bool { return true })
+	defer host.UnloadAll()
+
+	if err := host.Load(path); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if err := host.Start("echo"); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	var spec plugin.CommandSpec
+	select {
+	case spec = <-commands:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the plugin registered no command")
+	}
+	if spec.Name != "shout" || spec.Plugin != "echo" || len(spec.Args) != 2 || spec.Complete == nil {
+		t.Fatalf("unexpected command %+v", spec)
+	}
+	if got := spec.Complete([]string{"ro"}); len(got) != 2 || got[0] != "romeo@example.com" {
+		t.Fatalf("unexpected candidates %v", got)
+	}
+	if err := spec.Handler(nil); err == nil || !strings.Contains(err.Error(), "usage") {
+		t.Fatalf("expected the plugin's error, got %v", err)
+	}
+	if err := spec.Handler([]string{"romeo@example.com", "hey"}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got := <-sent; got != "romeo@example.com HEY" {
+		t.Fatalf("unexpected message %q", got)
+	}
+}
@@ -0,0 +1,147 @@
+// Package manifest describes what a plugin declares it needs. It has no
+// dependencies, so the installer can use it without the plugin host.
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Permission is something a plugin must declare in its manifest before the
+// host lets it do it
+type Permission string
+
+// Plugin permissions
+const (
+	PermReadMessages  Permission = "read_messages" // message events, history and unread counts
+	PermSendMessages  Permission = "send_messages" // send messages as the user
+	PermReadRoster    Permission = "read_roster"   // contacts and presence
+	PermEditRoster    Permission = "edit_roster"   // add and remove contacts
+	PermNetwork       Permission = "network"       // open network connections of its own
+	PermStatusBar     Permission = "status_bar"    // add items to the status bar
+	PermNotifications Permission = "notifications" // desktop notifications and dialogs
+	PermCommands      Permission = "commands"      // register : commands
+)
+
+// All lists every permission, in the order they are shown
+var All = []Permission{
+	PermReadMessages,
+	PermSendMessages,
+	PermReadRoster,
+	PermEditRoster,
+	PermNetwork,
+	PermStatusBar,
+	PermNotifications,
+	PermCommands,
+}
+
+// Description explains a permission to the user
+func (p Permission) Description() string {
+	switch p {
+	case PermReadMessages:
+		return "Read your messages"
+	case PermSendMessages:
+		return "Send messages in your name"
+	case PermReadRoster:
+		return "See your contacts and their presence"
+	case PermEditRoster:
+		return "Add and remove contacts"
+	case PermNetwork:
+		return "Connect to the network"
+	case PermStatusBar:
+		return "Show items in the status bar"
+	case PermNotifications:
+		return "Show notifications and dialogs"
+	case PermCommands:
+		return "Add : commands"
+	}
+	return string(p)
+}
+
+// Valid reports whether p is a known permission
+func (p Permission) Valid() bool {
+	for _, known := range All {
+		if p == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Manifest declares what a plugin needs. It is kept next to the plugin
+// binary as <binary>.manifest.json; a plugin without one gets no
+// permissions.
+type Manifest struct {
+	Name        string       `json:"name"`
+	Version     string       `json:"version,omitempty"`
+	Permissions []Permission `json:"permissions"`
+}
+
+// Path returns where the manifest of a plugin binary is
+func Path(binary string) string {
+	return binary + ".manifest.json"
+}
+
+// Read reads the manifest of a plugin binary. A missing manifest gives an
+// empty one.
+func Read(binary string) (Manifest, error) {
+	var m Manifest
+	data, err := os.ReadFile(Path(binary))
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("invalid plugin manifest: %w", err)
+	}
+	for _, p := range m.Permissions {
+		if !p.Valid() {
+			return m, fmt.Errorf("invalid plugin manifest: unknown permission %q", p)
+		}
+	}
+	return m, nil
+}
+
+// Write stores the manifest of a plugin binary
+func Write(binary string, m Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(Path(binary), data, 0644)
+}
+
+// Missing returns the permissions in requested that granted does not
+// contain
+func Missing(requested, granted []Permission) []Permission {
+	var missing []Permission
+	for _, r := range requested {
+		found := false
+		for _, g := range granted {
+			if r == g {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, r)
+		}
+	}
+	return missing
+}
+
+// Format joins permissions for messages
+func Format(perms []Permission) string {
+	if len(perms) == 0 {
+		return "none"
+	}
+	names := make([]string, len(perms))
+	for i, p := range perms {
+		names[i] = string(p)
+	}
+	return strings.Join(names, ", ")
+}
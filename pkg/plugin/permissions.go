@@ -0,0 +1,170 @@
+package plugin
+
+import (
+	"errors"
+
+	"github.com/meszmate/roster/pkg/plugin/manifest"
+)
+
+// ErrPermissionDenied is returned by API calls the plugin has no permission
+// for, as declared in its manifest (see the manifest package)
+var ErrPermissionDenied = errors.New("permission denied")
+
+// ConsentFunc decides whether a plugin may be loaded with the permissions
+// its manifest declares
+type ConsentFunc func(m manifest.Manifest) bool
+
+// guardedAPI hands a plugin only the parts of the API its manifest
+// declared, and names the plugin in the commands it registers
+type guardedAPI struct {
+	api   API
+	name  string
+	perms map[manifest.Permission]bool
+}
+
+func newGuardedAPI(api API, name string, perms []manifest.Permission) *guardedAPI {
+	g := &guardedAPI{api: api, name: name, perms: make(map[manifest.Permission]bool)}
+	for _, p := range perms {
+		g.perms[p] = true
+	}
+	return g
+}
+
+func (g *guardedAPI) GetContacts() []Contact {
+	if !g.perms[manifest.PermReadRoster] {
+		return nil
+	}
+	return g.api.GetContacts()
+}
+
+func (g *guardedAPI) GetContact(jid string) *Contact {
+	if !g.perms[manifest.PermReadRoster] {
+		return nil
+	}
+	return g.api.GetContact(jid)
+}
+
+func (g *guardedAPI) AddContact(jid, name string, groups []string) error {
+	if !g.perms[manifest.PermEditRoster] {
+		return ErrPermissionDenied
+	}
+	return g.api.AddContact(jid, name, groups)
+}
+
+func (g *guardedAPI) RemoveContact(jid string) error {
+	if !g.perms[manifest.PermEditRoster] {
+		return ErrPermissionDenied
+	}
+	return g.api.RemoveContact(jid)
+}
+
+func (g *guardedAPI) GetPresence(jid string) string {
+	if !g.perms[manifest.PermReadRoster] {
+		return ""
+	}
+	return g.api.GetPresence(jid)
+}
+
+func (g *guardedAPI) SendMessage(to, body string) error {
+	if !g.perms[manifest.PermSendMessages] {
+		return ErrPermissionDenied
+	}
+	return g.api.SendMessage(to, body)
+}
+
+func (g *guardedAPI) GetHistory(jid string, limit int) []Message {
+	if !g.perms[manifest.PermReadMessages] {
+		return nil
+	}
+	return g.api.GetHistory(jid, limit)
+}
+
+func (g *guardedAPI) GetUnreadCount(jid string) int {
+	if !g.perms[manifest.PermReadMessages] {
+		return 0
+	}
+	return g.api.GetUnreadCount(jid)
+}
+
+func (g *guardedAPI) ShowNotification(title, body string) error {
+	if !g.perms[manifest.PermNotifications] {
+		return ErrPermissionDenied
+	}
+	return g.api.ShowNotification(title, body)
+}
+
+func (g *guardedAPI) AddStatusBarItem(id, text string) error {
+	if !g.perms[manifest.PermStatusBar] {
+		return ErrPermissionDenied
+	}
+	return g.api.AddStatusBarItem(id, text)
+}
+
+func (g *guardedAPI) AddStatusBarWidget(item StatusBarItem) error {
+	if !g.perms[manifest.PermStatusBar] {
+		return ErrPermissionDenied
+	}
+	return g.api.AddStatusBarWidget(item)
+}
+
+func (g *guardedAPI) RemoveStatusBarItem(id string) error {
+	if !g.perms[manifest.PermStatusBar] {
+		return ErrPermissionDenied
+	}
+	return g.api.RemoveStatusBarItem(id)
+}
+
+func (g *guardedAPI) ShowDialog(title, message string, buttons []string) (int, error) {
+	if !g.perms[manifest.PermNotifications] {
+		return 0, ErrPermissionDenied
+	}
+	return g.api.ShowDialog(title, message, buttons)
+}
+
+func (g *guardedAPI) OnMessage(handler func(msg Message)) func() {
+	if !g.perms[manifest.PermReadMessages] {
+		return func() {}
+	}
+	return g.api.OnMessage(handler)
+}
+
+func (g *guardedAPI) OnURL(handler func(ev URLEvent)) func() {
+	if !g.perms[manifest.PermReadMessages] {
+		return func() {}
+	}
+	return g.api.OnURL(handler)
+}
+
+func (g *guardedAPI) OnPresence(handler func(jid, status string)) func() {
+	if !g.perms[manifest.PermReadRoster] {
+		return func() {}
+	}
+	return g.api.OnPresence(handler)
+}
+
+func (g *guardedAPI) OnConnect(handler func()) func() {
+	return g.api.OnConnect(handler)
+}
+
+func (g *guardedAPI) OnDisconnect(handler func()) func() {
+	return g.api.OnDisconnect(handler)
+}
+
+func (g *guardedAPI) RegisterCommand(name, description string, handler CommandHandler) error {
+	return g.RegisterCommandSpec(CommandSpec{Name: name, Description: description, Handler: handler})
+}
+
+func (g *guardedAPI) RegisterCommandSpec(spec CommandSpec) error {
+	if !g.perms[manifest.PermCommands] {
+		return ErrPermissionDenied
+	}
+	spec.Plugin = g.name
+	return g.api.RegisterCommandSpec(spec)
+}
+
+func (g *guardedAPI) UnregisterCommand(name string) error {
+	if !g.perms[manifest.PermCommands] {
+		return ErrPermissionDenied
+	}
+	return g.api.UnregisterCommand(name)
+}
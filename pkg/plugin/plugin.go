@@ -73,6 +73,10 @@ type UIAPI interface {
 	// AddStatusBarItem adds an item to the status bar
 	AddStatusBarItem(id, text string) error
 
+	// AddStatusBarWidget adds or replaces a status bar item with ordering,
+	// a width limit, periodic refresh and an activation callback
+	AddStatusBarWidget(item StatusBarItem) error
+
 	// RemoveStatusBarItem removes a status bar item
 	RemoveStatusBarItem(id string) error
 
@@ -85,6 +89,10 @@ type EventsAPI interface {
 	// OnMessage registers a message handler
 	OnMessage(handler func(msg Message)) func()
 
+	// OnURL registers a handler for received messages that contain links,
+	// called once per message with all of its links
+	OnURL(handler func(ev URLEvent)) func()
+
 	// OnPresence registers a presence handler
 	OnPresence(handler func(jid, status string)) func()
 
@@ -100,6 +108,10 @@ type CommandsAPI interface {
 	// RegisterCommand registers a custom command
 	RegisterCommand(name, description string, handler CommandHandler) error
 
+	// RegisterCommandSpec registers a custom command with help text and
+	// completion
+	RegisterCommandSpec(spec CommandSpec) error
+
 	// UnregisterCommand removes a custom command
 	UnregisterCommand(name string) error
 }
@@ -124,6 +136,47 @@ type Message struct {
 	Outgoing  bool
 }
 
+// URLEvent is a received message that contains links, with the
+// conversation it was received in
+type URLEvent struct {
+	URLs         []string // http(s) links in the order they appear, without duplicates
+	Message      Message
+	Conversation string // bare JID of the chat or room
+	Room         bool
+	Sender       string // the nick in a room, the bare JID otherwise
+}
+
+// CommandSpec describes a plugin : command
+type CommandSpec struct {
+	Name        string
+	Description string   // one line, shown in :help
+	Args        []string // argument names for the usage line, [optional] ones in brackets
+	Help        string   // longer text for :help <name>
+	Plugin      string   // the plugin that registered it, set by the host
+
+	// Complete returns candidates for the last argument, given the
+	// arguments typed so far; nil turns completion off
+	Complete func(args []string) []string
+
+	Handler CommandHandler
+}
+
+// StatusBarItem is a plugin item in the status bar
+type StatusBarItem struct {
+	ID       string
+	Text     string
+	Priority int // items with a higher priority are shown first
+	MaxWidth int // longer text is cut, in cells; 0 uses the default
+
+	// Refresh is how often OnRefresh is called for new text; 0 never
+	Refresh   time.Duration
+	OnRefresh func() string
+
+	// OnActivate is called when the item is clicked or chosen with
+	// :statusbar
+	OnActivate func()
+}
+
 // CommandHandler handles a plugin command
 type CommandHandler func(args []string) error
 
@@ -0,0 +1,61 @@
+//go:build linux
+
+package plugin
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+)
+
+var (
+	sandboxOnce sync.Once
+	sandboxErr  error
+)
+
+// sandboxCommand starts a plugin without network access unless it was
+// granted, by giving it network and user namespaces of its own. The host
+// still reaches it through its Unix socket, which lives on the filesystem.
+// It returns why the plugin cannot be kept off the network, leaving cmd
+// unsandboxed, when the system does not allow the namespaces.
+func sandboxCommand(cmd *exec.Cmd, network bool) error {
+	if network {
+		return nil
+	}
+	if err := SandboxUnavailable(); err != nil {
+		return err
+	}
+	cmd.SysProcAttr = sandboxAttr()
+	return nil
+}
+
+// SandboxUnavailable returns why plugins cannot be kept off the network on
+// this system, nil when they can. Unprivileged user namespaces may be turned
+// off (kernel.unprivileged_userns_clone, AppArmor's userns restriction) or
+// refused by a container's seccomp profile.
+func SandboxUnavailable() error {
+	sandboxOnce.Do(func() {
+		// Executing a directory: when the namespaces can be made, the child
+		// gets as far as execve, which fails without running anything
+		p, err := os.StartProcess("/", []string{"/"}, &os.ProcAttr{Sys: sandboxAttr()})
+		switch {
+		case err == nil:
+			_ = p.Kill()
+			_, _ = p.Wait()
+		case errors.Is(err, syscall.EPERM), errors.Is(err, syscall.EINVAL), errors.Is(err, syscall.ENOSPC):
+			sandboxErr = err
+		}
+	})
+	return sandboxErr
+}
+
+func sandboxAttr() *syscall.SysProcAttr {
+	uid, gid := os.Getuid(), os.Getgid()
+	return &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: uid, HostID: uid, Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: gid, HostID: gid, Size: 1}},
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+
+package plugin
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// errNoSandbox is why plugins keep network access on this system; the
+// network permission is only declared and shown there
+var errNoSandbox = errors.New("network isolation needs Linux")
+
+// sandboxCommand cannot take network access away on this system
+func sandboxCommand(cmd *exec.Cmd, network bool) error {
+	if network {
+		return nil
+	}
+	return errNoSandbox
+}
+
+// SandboxUnavailable returns why plugins cannot be kept off the network on
+// this system
+func SandboxUnavailable() error {
+	return errNoSandbox
+}
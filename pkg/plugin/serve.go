@@ -0,0 +1,316 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/go-plugin"
+)
+
+// Serve runs a plugin for roster. The main function of a plugin calls it,
+// and it returns when roster stops the plugin.
+func Serve(p Plugin) {
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: Handshake,
+		Plugins:         map[string]plugin.Plugin{"plugin": &GRPCPlugin{Impl: p}},
+		GRPCServer:      plugin.DefaultGRPCServer,
+	})
+}
+
+// pluginServer answers the calls roster makes to a plugin in its process
+type pluginServer struct {
+	impl   Plugin
+	broker *plugin.GRPCBroker
+	api    *remoteAPI
+}
+
+func (s *pluginServer) handle(ctx context.Context, method string, raw json.RawMessage) (any, error) {
+	var args pluginArgs
+	if err := decodeArgs(raw, &args); err != nil {
+		return nil, err
+	}
+	switch method {
+	case "describe":
+		return pluginInfo{Name: s.impl.Name(), Version: s.impl.Version(), Description: s.impl.Description()}, nil
+	case "init":
+		conn, err := s.broker.Dial(args.API)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reach the API: %w", err)
+		}
+		s.api = newRemoteAPI(caller{conn: conn, service: apiService})
+		return nil, s.impl.Init(context.Background(), s.api)
+	case "start":
+		return nil, s.impl.Start()
+	case "stop":
+		return nil, s.impl.Stop()
+	case "event":
+		if s.api != nil {
+			s.api.deliver(args)
+		}
+		return nil, nil
+	case "refresh_status_item", "activate_status_item":
+		if s.api == nil {
+			return nil, fmt.Errorf("plugin not initialized")
+		}
+		item, ok := s.api.statusItem(args.Item)
+		if !ok {
+			return nil, fmt.Errorf("unknown status bar item %q", args.Item)
+		}
+		if method == "activate_status_item" {
+			if item.OnActivate != nil {
+				item.OnActivate()
+			}
+			return nil, nil
+		}
+		if item.OnRefresh == nil {
+			return item.Text, nil
+		}
+		return item.OnRefresh(), nil
+	case "run_command", "complete_command":
+		if s.api == nil {
+			return nil, fmt.Errorf("plugin not initialized")
+		}
+		spec, ok := s.api.command(args.Command)
+		if !ok {
+			return nil, fmt.Errorf("unknown command :%s", args.Command)
+		}
+		if method == "run_command" {
+			return nil, spec.Handler(args.Args)
+		}
+		if spec.Complete == nil {
+			return nil, nil
+		}
+		return spec.Complete(args.Args), nil
+	}
+	return nil, fmt.Errorf("unknown method %q", method)
+}
+
+// remoteAPI is the API as a plugin sees it, each call made to roster.
+// Event handlers and the callbacks of status bar items and commands stay in
+// the plugin; roster is told to forward the events and calls back for the
+// callbacks.
+type remoteAPI struct {
+	caller
+
+	mu       sync.Mutex
+	lastID   uint64
+	handlers map[uint64]any // event handlers by subscription
+	items    map[string]StatusBarItem
+	commands map[string]CommandSpec
+}
+
+func newRemoteAPI(c caller) *remoteAPI {
+	return &remoteAPI{
+		caller:   c,
+		handlers: make(map[uint64]any),
+		items:    make(map[string]StatusBarItem),
+		commands: make(map[string]CommandSpec),
+	}
+}
+
+func (r *remoteAPI) GetContacts() []Contact {
+	var contacts []Contact
+	_ = r.call("get_contacts", nil, &contacts)
+	return contacts
+}
+
+func (r *remoteAPI) GetContact(jid string) *Contact {
+	var contact *Contact
+	_ = r.call("get_contact", apiArgs{JID: jid}, &contact)
+	return contact
+}
+
+func (r *remoteAPI) AddContact(jid, name string, groups []string) error {
+	return r.call("add_contact", apiArgs{JID: jid, Name: name, Groups: groups}, nil)
+}
+
+func (r *remoteAPI) RemoveContact(jid string) error {
+	return r.call("remove_contact", apiArgs{JID: jid}, nil)
+}
+
+func (r *remoteAPI) GetPresence(jid string) string {
+	var status string
+	_ = r.call("get_presence", apiArgs{JID: jid}, &status)
+	return status
+}
+
+func (r *remoteAPI) SendMessage(to, body string) error {
+	return r.call("send_message", apiArgs{To: to, Body: body}, nil)
+}
+
+func (r *remoteAPI) GetHistory(jid string, limit int) []Message {
+	var messages []Message
+	_ = r.call("get_history", apiArgs{JID: jid, Limit: limit}, &messages)
+	return messages
+}
+
+func (r *remoteAPI) GetUnreadCount(jid string) int {
+	var count int
+	_ = r.call("get_unread_count", apiArgs{JID: jid}, &count)
+	return count
+}
+
+func (r *remoteAPI) ShowNotification(title, body string) error {
+	return r.call("show_notification", apiArgs{Title: title, Body: body}, nil)
+}
+
+func (r *remoteAPI) ShowDialog(title, message string, buttons []string) (int, error) {
+	choice := -1
+	err := r.call("show_dialog", apiArgs{Title: title, Body: message, Buttons: buttons}, &choice)
+	return choice, err
+}
+
+func (r *remoteAPI) AddStatusBarItem(id, text string) error {
+	return r.AddStatusBarWidget(StatusBarItem{ID: id, Text: text})
+}
+
+func (r *remoteAPI) AddStatusBarWidget(item StatusBarItem) error {
+	r.mu.Lock()
+	r.items[item.ID] = item
+	r.mu.Unlock()
+	err := r.call("set_status_item", apiArgs{Item: &statusItem{
+		ID:        item.ID,
+		Text:      item.Text,
+		Priority:  item.Priority,
+		MaxWidth:  item.MaxWidth,
+		Refresh:   item.Refresh,
+		Refreshes: item.OnRefresh != nil,
+		Activates: item.OnActivate != nil,
+	}}, nil)
+	if err != nil {
+		r.mu.Lock()
+		delete(r.items, item.ID)
+		r.mu.Unlock()
+	}
+	return err
+}
+
+func (r *remoteAPI) RemoveStatusBarItem(id string) error {
+	r.mu.Lock()
+	delete(r.items, id)
+	r.mu.Unlock()
+	return r.call("remove_status_item", apiArgs{Item: &statusItem{ID: id}}, nil)
+}
+
+// statusItem returns a status bar item the plugin added
+func (r *remoteAPI) statusItem(id string) (StatusBarItem, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item, ok := r.items[id]
+	return item, ok
+}
+
+func (r *remoteAPI) OnMessage(handler func(msg Message)) func() {
+	return r.subscribe(eventMessage, handler)
+}
+
+func (r *remoteAPI) OnURL(handler func(ev URLEvent)) func() {
+	return r.subscribe(eventURL, handler)
+}
+
+func (r *remoteAPI) OnPresence(handler func(jid, status string)) func() {
+	return r.subscribe(eventPresence, handler)
+}
+
+func (r *remoteAPI) OnConnect(handler func()) func() {
+	return r.subscribe(eventConnect, handler)
+}
+
+func (r *remoteAPI) OnDisconnect(handler func()) func() {
+	return r.subscribe(eventDisconnect, handler)
+}
+
+func (r *remoteAPI) RegisterCommand(name, description string, handler CommandHandler) error {
+	return r.RegisterCommandSpec(CommandSpec{Name: name, Description: description, Handler: handler})
+}
+
+func (r *remoteAPI) RegisterCommandSpec(spec CommandSpec) error {
+	if spec.Name == "" || spec.Handler == nil {
+		return fmt.Errorf("command needs a name and a handler")
+	}
+	// Kept first, as the command can be run as soon as roster has it
+	r.mu.Lock()
+	old, replaced := r.commands[spec.Name]
+	r.commands[spec.Name] = spec
+	r.mu.Unlock()
+	err := r.call("register_command", apiArgs{Command: &commandSpec{
+		Name:        spec.Name,
+		Description: spec.Description,
+		Args:        spec.Args,
+		Help:        spec.Help,
+		Completes:   spec.Complete != nil,
+	}}, nil)
+	if err != nil {
+		r.mu.Lock()
+		if replaced {
+			r.commands[spec.Name] = old
+		} else {
+			delete(r.commands, spec.Name)
+		}
+		r.mu.Unlock()
+	}
+	return err
+}
+
+func (r *remoteAPI) UnregisterCommand(name string) error {
+	r.mu.Lock()
+	delete(r.commands, name)
+	r.mu.Unlock()
+	return r.call("unregister_command", apiArgs{Name: name}, nil)
+}
+
+// command returns a command the plugin registered
+func (r *remoteAPI) command(name string) (CommandSpec, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	spec, ok := r.commands[name]
+	return spec, ok
+}
+
+// subscribe keeps an event handler and asks roster to forward the event.
+// The returned function drops the handler and cancels the forwarding.
+func (r *remoteAPI) subscribe(event string, handler any) func() {
+	r.mu.Lock()
+	r.lastID++
+	id := r.lastID
+	r.handlers[id] = handler
+	r.mu.Unlock()
+
+	drop := func() {
+		r.mu.Lock()
+		delete(r.handlers, id)
+		r.mu.Unlock()
+	}
+	if err := r.call("subscribe", apiArgs{Event: event, Subscription: id}, nil); err != nil {
+		drop()
+		return func() {}
+	}
+	return func() {
+		drop()
+		_ = r.call("unsubscribe", apiArgs{Subscription: id}, nil)
+	}
+}
+
+// deliver runs the handler of a subscription with the event roster sent
+func (r *remoteAPI) deliver(args pluginArgs) {
+	r.mu.Lock()
+	handler := r.handlers[args.Subscription]
+	r.mu.Unlock()
+
+	switch h := handler.(type) {
+	case func(Message):
+		if args.Message != nil {
+			h(*args.Message)
+		}
+	case func(URLEvent):
+		if args.URL != nil {
+			h(*args.URL)
+		}
+	case func(string, string):
+		h(args.JID, args.Status)
+	case func():
+		h()
+	}
+}
@@ -124,6 +124,5 @@ func sendNotification(title, body string) error {
 }
 
 func main() {
-	// This would use go-plugin to serve the plugin
-	// Simplified for example purposes
+	plugin.Serve(&StatusNotifyPlugin{})
 }